@@ -0,0 +1,5 @@
+package v1
+
+// Hub marks v1, the storage version, as the version the others convert
+// through
+func (*PostgreSQLCluster) Hub() {}
@@ -0,0 +1,85 @@
+package v1
+
+import (
+	"context"
+	"fmt"
+
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// defaultClassAnnotation marks the StorageClass of claims that name none
+const defaultClassAnnotation = "storageclass.kubernetes.io/is-default-class"
+
+// EncryptedStorageParameters are the StorageClass parameters that tell
+// that a class of a known provisioner encrypts its volumes, as
+// EncryptionSpec.EncryptedStorageParameters does
+var EncryptedStorageParameters = map[string]map[string]string{
+	"ebs.csi.aws.com":       {"encrypted": "true"},
+	"kubernetes.io/aws-ebs": {"encrypted": "true"},
+	"pd.csi.storage.gke.io": {"disk-encryption-kms-key": ""},
+	"disk.csi.azure.com":    {"diskEncryptionSetID": ""},
+	"rbd.csi.ceph.com":      {"encrypted": "true"},
+}
+
+// BaseStorageClass returns the StorageClass the data volumes of cluster
+// are provisioned from: spec.postgresql.storage.storageClass, or else the
+// default class.  With spec.encryption.volumeParameters the volumes come
+// from a class of the operator's, made from this one.
+func BaseStorageClass(ctx context.Context, reader client.Reader, cluster *PostgreSQLCluster) (*storagev1.StorageClass, error) {
+	if name := cluster.Spec.PostgreSQL.Storage.StorageClass; name != "" {
+		class := &storagev1.StorageClass{}
+		if err := reader.Get(ctx, types.NamespacedName{Name: name}, class); err != nil {
+			return nil, err
+		}
+		return class, nil
+	}
+
+	classes := &storagev1.StorageClassList{}
+	if err := reader.List(ctx, classes); err != nil {
+		return nil, err
+	}
+	for i := range classes.Items {
+		if classes.Items[i].Annotations[defaultClassAnnotation] == "true" {
+			return &classes.Items[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no StorageClass is named and there is no default one")
+}
+
+// StorageParametersEncrypt reports whether the parameters of a class of
+// provisioner encrypt its volumes: whether they have every one of
+// required, or of EncryptedStorageParameters if required is empty.  A
+// required parameter with an empty value must only be set.
+func StorageParametersEncrypt(provisioner string, parameters, required map[string]string) bool {
+	if len(required) == 0 {
+		required = EncryptedStorageParameters[provisioner]
+		if len(required) == 0 {
+			return false
+		}
+	}
+	for name, value := range required {
+		actual, ok := parameters[name]
+		if !ok || (value != "" && actual != value) {
+			return false
+		}
+	}
+	return true
+}
+
+// VolumeParameters returns the parameters the data volumes of cluster are
+// provisioned with from class: its own, with
+// spec.encryption.volumeParameters over them
+func VolumeParameters(cluster *PostgreSQLCluster, class *storagev1.StorageClass) map[string]string {
+	parameters := make(map[string]string, len(class.Parameters))
+	for name, value := range class.Parameters {
+		parameters[name] = value
+	}
+	if cluster.Spec.Encryption != nil {
+		for name, value := range cluster.Spec.Encryption.VolumeParameters {
+			parameters[name] = value
+		}
+	}
+	return parameters
+}
@@ -6,6 +6,8 @@ import (
 )
 
 // PostgreSQLClusterSpec defines the desired state of PostgreSQLCluster
+// +kubebuilder:validation:XValidation:rule="self.replicas % 2 == 1",message="replicas must be odd so that the members can always form a quorum"
+// +kubebuilder:validation:XValidation:rule="[has(self.restore), has(self.dataSource), has(self.standby)].filter(x, x).size() <= 1",message="only one of restore, dataSource and standby may be set"
 type PostgreSQLClusterSpec struct {
 	// Replicas is the number of PostgreSQL replicas in the cluster
 	// +kubebuilder:validation:Minimum=1
@@ -24,6 +26,344 @@ type PostgreSQLClusterSpec struct {
 
 	// Monitoring configuration
 	Monitoring MonitoringSpec `json:"monitoring,omitempty"`
+
+	// Connection pooler configuration
+	Pooler PoolerSpec `json:"pooler,omitempty"`
+
+	// Failover configuration
+	Failover FailoverSpec `json:"failover,omitempty"`
+
+	// Planned switchover to request
+	Switchover *SwitchoverSpec `json:"switchover,omitempty"`
+
+	// How the PostgreSQL pods are updated when their template changes
+	UpdateStrategy UpdateStrategySpec `json:"updateStrategy,omitempty"`
+
+	// Windows outside which disruptive operations wait; if unset they run
+	// whenever they are due
+	MaintenanceWindow *MaintenanceWindowSpec `json:"maintenanceWindow,omitempty"`
+
+	// Stop every pod of the cluster, keeping its volumes, Secrets and
+	// backups, until unset
+	Hibernate bool `json:"hibernate,omitempty"`
+
+	// Revert changes made out of band to the StatefulSet, Services,
+	// ConfigMaps and Secrets the operator generates, and recreate them if
+	// deleted, with an event for each repair; if false they are left as
+	// they are, and reported, until the spec changes
+	// +kubebuilder:default=true
+	SelfHeal *bool `json:"selfHeal,omitempty"`
+
+	// Database credentials
+	Credentials CredentialsSpec `json:"credentials,omitempty"`
+
+	// Secrets to pull the images of every pod of the cluster with
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+
+	// Encryption at rest of the data volumes, backups and archived WAL
+	Encryption *EncryptionSpec `json:"encryption,omitempty"`
+
+	// How a new cluster that is not created from existing data is
+	// initialized; only set when the cluster is created
+	Bootstrap *BootstrapSpec `json:"bootstrap,omitempty"`
+
+	// Backup to bootstrap a new cluster from
+	Restore *RestoreSpec `json:"restore,omitempty"`
+
+	// Existing cluster to bootstrap a new cluster as a copy of
+	DataSource *DataSourceSpec `json:"dataSource,omitempty"`
+
+	// Primary cluster to follow as a standby
+	Standby *StandbySpec `json:"standby,omitempty"`
+
+	// What happens to the cluster's data when it is deleted
+	Teardown TeardownSpec `json:"teardown,omitempty"`
+
+	// Database roles to create, keep in line with the spec, or drop
+	// +listType=map
+	// +listMapKey=name
+	ManagedRoles []ManagedRoleSpec `json:"managedRoles,omitempty"`
+
+	// Databases to create, keep in line with the spec, or drop
+	// +listType=map
+	// +listMapKey=name
+	ManagedDatabases []ManagedDatabaseSpec `json:"managedDatabases,omitempty"`
+}
+
+// Values of ManagedRoleSpec.Ensure and ManagedDatabaseSpec.Ensure
+const (
+	EnsurePresent = "Present"
+	EnsureAbsent  = "Absent"
+)
+
+// ManagedRoleSpec defines a database role the operator maintains on the
+// primary
+type ManagedRoleSpec struct {
+	// Name of the role
+	// +kubebuilder:validation:Pattern=`^[a-z_][a-z0-9_]{0,62}$`
+	Name string `json:"name"`
+
+	// Whether the role should exist
+	// +kubebuilder:validation:Enum=Present;Absent
+	// +kubebuilder:default="Present"
+	Ensure string `json:"ensure,omitempty"`
+
+	// Secret holding the role's password under the key password; the
+	// password is set again whenever the Secret changes
+	PasswordSecret string `json:"passwordSecret,omitempty"`
+
+	// Whether the role may log in; true if unset
+	Login *bool `json:"login,omitempty"`
+
+	// Attributes of the role, as in CREATE ROLE
+	Superuser   bool `json:"superuser,omitempty"`
+	CreateDB    bool `json:"createdb,omitempty"`
+	CreateRole  bool `json:"createrole,omitempty"`
+	Replication bool `json:"replication,omitempty"`
+
+	// Most concurrent connections the role may make; no limit if unset
+	// +kubebuilder:validation:Minimum=-1
+	ConnectionLimit *int32 `json:"connectionLimit,omitempty"`
+
+	// Roles the role is granted membership of
+	InRoles []string `json:"inRoles,omitempty"`
+}
+
+// ManagedDatabaseSpec defines a database the operator maintains on the
+// primary
+type ManagedDatabaseSpec struct {
+	// Name of the database
+	// +kubebuilder:validation:Pattern=`^[a-z_][a-z0-9_]{0,62}$`
+	Name string `json:"name"`
+
+	// Whether the database should exist; an absent database is dropped
+	// with the connections to it
+	// +kubebuilder:validation:Enum=Present;Absent
+	// +kubebuilder:default="Present"
+	Ensure string `json:"ensure,omitempty"`
+
+	// Role owning the database; postgres if unset
+	Owner string `json:"owner,omitempty"`
+
+	// Extensions to create in the database
+	Extensions []string `json:"extensions,omitempty"`
+}
+
+// BootstrapSpec defines how a new cluster is initialized.  The members
+// initdb with InitDB; once there is a primary a Job creates the roles, then
+// the databases, then runs the SQL scripts, once, which the Initialized
+// condition records.  Unlike spec.managedRoles and spec.managedDatabases,
+// nothing is kept in line afterwards.
+type BootstrapSpec struct {
+	// Options of initdb
+	InitDB *InitDBSpec `json:"initdb,omitempty"`
+
+	// Roles to create, with their passwords from the Secrets; ensure must
+	// be Present
+	// +listType=map
+	// +listMapKey=name
+	Roles []ManagedRoleSpec `json:"roles,omitempty"`
+
+	// Databases to create; ensure must be Present
+	// +listType=map
+	// +listMapKey=name
+	Databases []ManagedDatabaseSpec `json:"databases,omitempty"`
+
+	// SQL scripts to run after the roles and databases are created, in
+	// order, each in a transaction of its own unless it says otherwise
+	SQLScripts []SQLScriptSpec `json:"sqlScripts,omitempty"`
+}
+
+// InitDBSpec defines the options the members initdb with
+type InitDBSpec struct {
+	// Encoding of the template databases
+	// +kubebuilder:validation:Pattern=`^[A-Za-z0-9_.@-]+$`
+	Encoding string `json:"encoding,omitempty"`
+
+	// Locale of the template databases, which the image must have; the
+	// image's if unset
+	// +kubebuilder:validation:Pattern=`^[A-Za-z0-9_.@-]+$`
+	Locale string `json:"locale,omitempty"`
+
+	// Collation and character classification, over locale
+	// +kubebuilder:validation:Pattern=`^[A-Za-z0-9_.@-]+$`
+	LCCollate string `json:"lcCollate,omitempty"`
+	// +kubebuilder:validation:Pattern=`^[A-Za-z0-9_.@-]+$`
+	LCCtype string `json:"lcCtype,omitempty"`
+
+	// Checksum the data pages; if unset as the version of PostgreSQL does
+	// by default, which is from PostgreSQL 18
+	DataChecksums *bool `json:"dataChecksums,omitempty"`
+
+	// Size of the WAL segments in megabytes
+	// +kubebuilder:validation:Enum=1;2;4;8;16;32;64;128;256;512;1024
+	WALSegmentSize int32 `json:"walSegmentSize,omitempty"`
+}
+
+// SQLScriptSpec defines SQL scripts held in a ConfigMap
+type SQLScriptSpec struct {
+	// ConfigMap holding the scripts
+	ConfigMap string `json:"configMap"`
+
+	// Keys of the scripts to run, in order; all of the ConfigMap's, in the
+	// order of their names, if unset
+	Keys []string `json:"keys,omitempty"`
+
+	// Database to run the scripts in
+	// +kubebuilder:validation:Pattern=`^[a-z_][a-z0-9_]{0,62}$`
+	// +kubebuilder:default="postgres"
+	Database string `json:"database,omitempty"`
+}
+
+// EncryptionSpec defines what of the cluster's data must be encrypted at
+// rest and how.  Whether it is shows in status.encryption and the
+// EncryptionCompliant condition.
+type EncryptionSpec struct {
+	// Refuse a StorageClass for the data volumes that does not encrypt
+	// them, as told by its parameters
+	RequireEncryptedStorage bool `json:"requireEncryptedStorage,omitempty"`
+
+	// StorageClass parameters that tell that a class encrypts its volumes,
+	// all of which it must have; a parameter with an empty value must only
+	// be set.  Defaults to those of the CSI drivers of AWS EBS, GCE PD,
+	// Azure Disk and Ceph RBD.
+	EncryptedStorageParameters map[string]string `json:"encryptedStorageParameters,omitempty"`
+
+	// Parameters to provision the data volumes with, e.g. the KMS key of
+	// the CSI driver.  The operator provisions them from a StorageClass of
+	// its own, ram-<namespace>-<cluster>, with these over the parameters
+	// of spec.postgresql.storage.storageClass or the default class; they
+	// can only be set when the cluster is created.
+	VolumeParameters map[string]string `json:"volumeParameters,omitempty"`
+
+	// Refuse to archive the WAL or take backups unencrypted, by wal-g with
+	// spec.postgresql.walArchive.encryption or by the object storage with
+	// kms
+	RequireEncryptedBackups bool `json:"requireEncryptedBackups,omitempty"`
+
+	// KMS key the object storage encrypts the backups and archived WAL
+	// with, server-side
+	KMS *KMSSpec `json:"kms,omitempty"`
+}
+
+// KMSSpec defines a key of a key management service.  Only S3
+// repositories are supported, with SSE-KMS.
+type KMSSpec struct {
+	// ID or ARN of the key
+	KeyID string `json:"keyID"`
+
+	// Secret with the credentials allowed to use the key, for when the
+	// repository's are not.  Its keys are passed to wal-g as environment
+	// variables, over the repository's, and it is mounted at
+	// /etc/wal-g-kms, e.g. for AWS_SHARED_CREDENTIALS_FILE.
+	CredentialsSecret string `json:"credentialsSecret,omitempty"`
+}
+
+// TeardownSpec defines how the cluster is taken down when it is deleted.
+// The members leave raft one by one and PostgreSQL is stopped before the
+// cluster's resources are removed.
+type TeardownSpec struct {
+	// Take a last backup before stopping PostgreSQL; needs backups to be
+	// configured
+	FinalBackup bool `json:"finalBackup,omitempty"`
+
+	// Keep or delete the PostgreSQL volumes
+	// +kubebuilder:validation:Enum=Retain;Delete
+	// +kubebuilder:default="Retain"
+	DataPolicy string `json:"dataPolicy,omitempty"`
+
+	// Keep or delete the backups and archived WAL in the backup repository
+	// +kubebuilder:validation:Enum=Retain;Delete
+	// +kubebuilder:default="Retain"
+	BackupPolicy string `json:"backupPolicy,omitempty"`
+}
+
+// StandbySpec makes the cluster a standby of a primary cluster, in this
+// Kubernetes cluster or another, for disaster recovery.  The standby
+// starts from the latest backup in the primary's WAL archive, or a base
+// backup of the primary, and then replays the archive, streams from the
+// primary, or both.
+// +kubebuilder:validation:XValidation:rule="has(self.repository) || has(self.host)",message="a standby follows a repository, a host or both"
+// +kubebuilder:validation:XValidation:rule="!has(oldSelf.promote) || !oldSelf.promote || (has(self.promote) && self.promote)",message="a promoted standby cannot go back to being a standby"
+type StandbySpec struct {
+	// WAL archive of the primary cluster
+	Repository *BackupRepositorySpec `json:"repository,omitempty"`
+
+	// Key the WAL archive is encrypted with, as in the walArchive of the
+	// primary cluster
+	Encryption *ArchiveEncryptionSpec `json:"encryption,omitempty"`
+
+	// PostgreSQL endpoint of the primary cluster
+	Host string `json:"host,omitempty"`
+
+	// +kubebuilder:default=5432
+	Port int32 `json:"port,omitempty"`
+
+	// Secret with the primary's postgres-password; defaults to this
+	// cluster's credentials Secret
+	CredentialsSecret string `json:"credentialsSecret,omitempty"`
+
+	// Promote the cluster out of standby, e.g. when the primary is lost
+	Promote bool `json:"promote,omitempty"`
+}
+
+// DataSourceSpec bootstraps the cluster as a copy of another
+type DataSourceSpec struct {
+	// Cluster to copy, in the same namespace
+	ClusterRef ClusterReference `json:"clusterRef"`
+
+	// How to copy it: from its latest backup, or by a base backup taken
+	// from its primary, which must accept replication connections
+	// +kubebuilder:validation:Enum=backup;basebackup
+	// +kubebuilder:default="backup"
+	Method string `json:"method,omitempty"`
+}
+
+// ClusterReference names a PostgreSQLCluster
+type ClusterReference struct {
+	Name string `json:"name"`
+}
+
+// RestoreSpec bootstraps the cluster from a backup taken by wal-g, e.g. by
+// another cluster's spec.postgresql.backup
+type RestoreSpec struct {
+	// Repository holding the backup
+	Repository BackupRepositorySpec `json:"repository"`
+
+	// Key the repository is encrypted with, as in the walArchive of the
+	// cluster that wrote it
+	Encryption *ArchiveEncryptionSpec `json:"encryption,omitempty"`
+
+	// Name of the base backup to start from
+	// +kubebuilder:default="LATEST"
+	Backup string `json:"backup,omitempty"`
+
+	// Recover up to this time rather than to the end of the archived WAL
+	TargetTime *metav1.Time `json:"targetTime,omitempty"`
+}
+
+// CredentialsSpec defines where the database passwords come from
+type CredentialsSpec struct {
+	// Secret of the user's own, with postgres-password and
+	// replication-password keys, to use instead of generated passwords.
+	// Either Secret may hold a ramd-token key, sent to the RAMD API when
+	// ramd requires authentication.
+	ExistingSecret string `json:"existingSecret,omitempty"`
+}
+
+// FailoverSpec defines how the operator fails over from an unhealthy leader
+type FailoverSpec struct {
+	// Fail over when the leader pod stays unhealthy
+	// +kubebuilder:default=true
+	Enabled bool `json:"enabled,omitempty"`
+
+	// How long the leader pod must be unready before failing over
+	// +kubebuilder:default="30s"
+	UnhealthyAfter string `json:"unhealthyAfter,omitempty"`
+
+	// How long a failover may take before it is given up as failed
+	// +kubebuilder:default="2m"
+	Timeout string `json:"timeout,omitempty"`
 }
 
 // PostgreSQLSpec defines PostgreSQL-specific configuration
@@ -39,14 +379,71 @@ type PostgreSQLSpec struct {
 	// Resource requirements
 	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
 
-	// PostgreSQL configuration parameters
+	// PostgreSQL configuration parameters, rendered into postgresql.conf
+	// over the pgraft defaults; shared_preload_libraries always includes
+	// pgraft
 	Parameters map[string]string `json:"parameters,omitempty"`
 
+	// Client authentication rules, in order, placed between the operator's
+	// own rules for the local socket and ramd and its defaults for
+	// replication and password access
+	PgHBA []PgHBARule `json:"pgHBA,omitempty"`
+
 	// Storage configuration
 	Storage StorageSpec `json:"storage,omitempty"`
 
 	// Backup configuration
 	Backup BackupSpec `json:"backup,omitempty"`
+
+	// Continuous archiving of the WAL, which restores to a point in time
+	// and standbys of this cluster replay; if unset the WAL is archived to
+	// the backup repository while backups are enabled
+	WALArchive *WALArchiveSpec `json:"walArchive,omitempty"`
+
+	// Security context of the PostgreSQL pods; if unset they run as the
+	// postgres user, 999, within the restricted Pod Security Standard
+	PodSecurityContext *corev1.PodSecurityContext `json:"podSecurityContext,omitempty"`
+
+	// Security context of the containers of the PostgreSQL pods but ramd;
+	// if unset they drop every capability and have a read-only root
+	// filesystem
+	SecurityContext *corev1.SecurityContext `json:"securityContext,omitempty"`
+
+	// ServiceAccount the PostgreSQL pods run as, with ramd in Sidecar mode
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+
+	// Scheduling of the PostgreSQL pods
+	SchedulingSpec `json:",inline"`
+
+	// Containers and volumes to add to the PostgreSQL pods, whose volume
+	// mounts go to the postgresql container
+	PodExtrasSpec `json:",inline"`
+
+	// Image and approvals of an upgrade to a new major version, which
+	// raising version starts
+	MajorUpgrade MajorUpgradeSpec `json:"majorUpgrade,omitempty"`
+}
+
+// MajorUpgradeSpec gates an upgrade to a new major version of PostgreSQL
+// with pg_upgrade.  Each step waits for its approval, given by setting the
+// field to the new version, so that an approval is never carried over to
+// a later upgrade; a step that failed is tried again once its approval is
+// withdrawn and given again.
+type MajorUpgradeSpec struct {
+	// Image running pg_upgrade, with the binaries of both versions in
+	// /usr/lib/postgresql/<major>/bin and pgraft built for both
+	Image string `json:"image,omitempty"`
+
+	// Version whose upgrade may be checked: pg_upgrade runs on a clone of
+	// the leader's volume while the cluster serves, and the upgraded copy
+	// must start and open every database
+	ApproveCheck string `json:"approveCheck,omitempty"`
+
+	// Version the cluster may cut over to once the check has passed: the
+	// cluster stops, the leader's data is upgraded next to the old data,
+	// and the members start on the new version, or go back to the old one
+	// if it fails
+	ApproveCutover string `json:"approveCutover,omitempty"`
 }
 
 // RAMDSpec defines RAMD daemon configuration
@@ -55,11 +452,70 @@ type RAMDSpec struct {
 	// +kubebuilder:default="pgraft/ramd:latest"
 	Image string `json:"image,omitempty"`
 
+	// Run ramd as a sidecar in every PostgreSQL pod, or as a single
+	// Deployment
+	// +kubebuilder:validation:Enum=Sidecar;Deployment
+	// +kubebuilder:default="Sidecar"
+	Mode string `json:"mode,omitempty"`
+
 	// Resource requirements
 	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
 
 	// RAMD configuration
 	Config RAMDConfig `json:"config,omitempty"`
+
+	// Security context of the RAMD pods, in Deployment mode; if unset they
+	// run as user 999 within the restricted Pod Security Standard
+	PodSecurityContext *corev1.PodSecurityContext `json:"podSecurityContext,omitempty"`
+
+	// Security context of the ramd container; if unset it drops every
+	// capability and has a read-only root filesystem
+	SecurityContext *corev1.SecurityContext `json:"securityContext,omitempty"`
+
+	// ServiceAccount the RAMD pods run as, in Deployment mode
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+
+	// Scheduling of the RAMD pods, in Deployment mode
+	SchedulingSpec `json:",inline"`
+
+	// Containers and volumes to add to the RAMD pods, in Deployment mode.
+	// The volume mounts go to the ramd container in either mode; as a
+	// sidecar it mounts the volumes of spec.postgresql.volumes.
+	PodExtrasSpec `json:",inline"`
+}
+
+// SchedulingSpec defines where pods may be scheduled
+type SchedulingSpec struct {
+	// Labels a node must have to run the pods
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// Taints the pods tolerate
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+
+	// Node and pod affinity of the pods
+	Affinity *corev1.Affinity `json:"affinity,omitempty"`
+
+	// How the pods are spread across zones, nodes or other topology domains
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
+
+	// PriorityClass of the pods
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+}
+
+// PodExtrasSpec defines containers and volumes of the user's own that the
+// operator adds to the pods it generates
+type PodExtrasSpec struct {
+	// Containers to run next to the operator's, e.g. log shippers
+	Sidecars []corev1.Container `json:"sidecars,omitempty"`
+
+	// Init containers to run after the operator's
+	InitContainers []corev1.Container `json:"initContainers,omitempty"`
+
+	// Volumes to add to the pods
+	Volumes []corev1.Volume `json:"volumes,omitempty"`
+
+	// Volume mounts to add to the operator's main container
+	VolumeMounts []corev1.VolumeMount `json:"volumeMounts,omitempty"`
 }
 
 // RAMDConfig defines RAMD-specific configuration
@@ -100,6 +556,36 @@ type RAMDSecurityConfig struct {
 	AuditLogging bool `json:"auditLogging,omitempty"`
 }
 
+// PgHBARule is one line of pg_hba.conf
+// +kubebuilder:validation:XValidation:rule="self.type == 'local' ? !has(self.address) : has(self.address)",message="address is required by host rules and not allowed in local ones"
+type PgHBARule struct {
+	// Connection type
+	// +kubebuilder:validation:Enum=local;host;hostssl;hostnossl;hostgssenc;hostnogssenc
+	Type string `json:"type"`
+
+	// Databases the rule matches, comma-separated, or all
+	// +kubebuilder:validation:Pattern=`^\S+$`
+	// +kubebuilder:default="all"
+	Database string `json:"database,omitempty"`
+
+	// Users the rule matches, comma-separated, or all
+	// +kubebuilder:validation:Pattern=`^\S+$`
+	// +kubebuilder:default="all"
+	User string `json:"user,omitempty"`
+
+	// Client addresses: an address with a CIDR mask, a host name, samehost,
+	// samenet or all
+	// +kubebuilder:validation:Pattern=`^\S+$`
+	Address string `json:"address,omitempty"`
+
+	// Authentication method
+	// +kubebuilder:validation:Enum=trust;reject;scram-sha-256;md5;password;gss;sspi;ident;peer;ldap;radius;cert;pam
+	Method string `json:"method"`
+
+	// Options of the method, e.g. clientcert: verify-full
+	Options map[string]string `json:"options,omitempty"`
+}
+
 // StorageSpec defines storage configuration
 type StorageSpec struct {
 	// Size of the persistent volume
@@ -123,6 +609,98 @@ type BackupSpec struct {
 	// Number of days to retain backups
 	// +kubebuilder:default=7
 	Retention int32 `json:"retention,omitempty"`
+
+	// Image providing the wal-g binary at /usr/local/bin/wal-g
+	// +kubebuilder:default="pgraft/wal-g:latest"
+	Image string `json:"image,omitempty"`
+
+	// Object storage the backups and archived WAL go to; backups run only
+	// once it is set
+	Repository *BackupRepositorySpec `json:"repository,omitempty"`
+
+	// Restore the latest backup on a schedule into a throwaway instance,
+	// to find out that backups cannot be restored before one is needed
+	Verification *BackupVerificationSpec `json:"verification,omitempty"`
+}
+
+// BackupVerificationSpec defines scheduled restores of the latest backup.
+// Each restores it with its WAL into a volume of the size of the members',
+// starts PostgreSQL on it without a network, reads every database and runs
+// the queries; the instance and its volume go away with the Job.
+type BackupVerificationSpec struct {
+	// Cron schedule of the verifications
+	// +kubebuilder:default="0 5 * * 0"
+	Schedule string `json:"schedule,omitempty"`
+
+	// SQL run in the restored instance, all of which must succeed, e.g.
+	// a count of the rows of a table that is never empty
+	Queries []string `json:"queries,omitempty"`
+
+	// Database the queries run in
+	// +kubebuilder:default=postgres
+	Database string `json:"database,omitempty"`
+
+	// How long a verification may take before it counts as failed
+	// +kubebuilder:default="2h"
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// WALArchiveSpec defines where and how the WAL is archived.  The base
+// backups go to the same repository, with the same compression and
+// encryption, as a restore needs both.
+type WALArchiveSpec struct {
+	// Object storage the WAL is archived to; defaults to
+	// spec.postgresql.backup.repository, and if both are set they must be
+	// the same
+	Repository *BackupRepositorySpec `json:"repository,omitempty"`
+
+	// Compression of the archived WAL and base backups
+	// +kubebuilder:validation:Enum=lz4;lzma;zstd;brotli
+	// +kubebuilder:default="lz4"
+	Compression string `json:"compression,omitempty"`
+
+	// Encrypt the archived WAL and base backups before they are uploaded
+	Encryption *ArchiveEncryptionSpec `json:"encryption,omitempty"`
+}
+
+// ArchiveEncryptionSpec defines the key wal-g encrypts an archive with,
+// or decrypts it with when restoring or replaying it
+type ArchiveEncryptionSpec struct {
+	// Cipher of wal-g to use
+	// +kubebuilder:validation:Enum=libsodium;pgp
+	// +kubebuilder:default="libsodium"
+	Method string `json:"method,omitempty"`
+
+	// Secret holding the key: a hex-encoded 32-byte key under
+	// libsodium-key, or an armored PGP key without a passphrase under
+	// pgp-key.  Losing it makes the archive unreadable.
+	KeySecret string `json:"keySecret"`
+}
+
+// BackupRepositorySpec defines the object storage backups are kept in
+type BackupRepositorySpec struct {
+	// Kind of object storage
+	// +kubebuilder:validation:Enum=s3;gcs;azure
+	Type string `json:"type"`
+
+	// Bucket, or container on Azure
+	Bucket string `json:"bucket"`
+
+	// Prefix within the bucket; defaults to <namespace>/<cluster name>
+	Path string `json:"path,omitempty"`
+
+	// Endpoint of S3-compatible storage other than AWS
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// Region of the S3 bucket
+	Region string `json:"region,omitempty"`
+
+	// Secret with the storage credentials.  Its keys are passed to wal-g
+	// as environment variables, e.g. AWS_ACCESS_KEY_ID and
+	// AWS_SECRET_ACCESS_KEY, or AZURE_STORAGE_ACCOUNT and
+	// AZURE_STORAGE_ACCESS_KEY, and it is mounted at /etc/wal-g so that
+	// GOOGLE_APPLICATION_CREDENTIALS can name a key file in it.
+	CredentialsSecret string `json:"credentialsSecret,omitempty"`
 }
 
 // NetworkingSpec defines networking configuration
@@ -134,9 +712,44 @@ type NetworkingSpec struct {
 
 	// Port configuration
 	Ports PortsSpec `json:"ports,omitempty"`
+
+	// A Service of its own for each member, reachable from outside
+	// Kubernetes, e.g. for clients that must pick the member or for a
+	// standby cluster elsewhere
+	ExternalAccess *ExternalAccessSpec `json:"externalAccess,omitempty"`
+}
+
+// ExternalAccessSpec defines the Services reaching each PostgreSQL pod
+// from outside Kubernetes, named after the pod with an -external suffix
+type ExternalAccessSpec struct {
+	// Type of the Services
+	// +kubebuilder:validation:Enum=LoadBalancer;NodePort
+	// +kubebuilder:default=LoadBalancer
+	Type corev1.ServiceType `json:"type,omitempty"`
+
+	// Annotations of the Services, e.g. to have an internal load balancer
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// DNS zone ExternalDNS publishes each member in, as <pod>.<domain>;
+	// no names are published if unset
+	Domain string `json:"domain,omitempty"`
+
+	// TTL in seconds of the published names
+	// +kubebuilder:validation:Minimum=1
+	DNSTTL int32 `json:"dnsTTL,omitempty"`
+
+	// Client addresses a LoadBalancer accepts, as CIDRs; any if unset
+	LoadBalancerSourceRanges []string `json:"loadBalancerSourceRanges,omitempty"`
+
+	// Whether traffic is only routed to the pod from its own node, which
+	// keeps the client address for pg_hba.conf
+	// +kubebuilder:validation:Enum=Cluster;Local
+	// +kubebuilder:default=Cluster
+	ExternalTrafficPolicy corev1.ServiceExternalTrafficPolicyType `json:"externalTrafficPolicy,omitempty"`
 }
 
 // PortsSpec defines port configuration
+// +kubebuilder:validation:XValidation:rule="[self.postgresql, self.ramd, self.prometheus, self.raft, self.exporter].all(p, [self.postgresql, self.ramd, self.prometheus, self.raft, self.exporter].filter(q, q == p).size() == 1)",message="ports must all differ"
 type PortsSpec struct {
 	// PostgreSQL port
 	// +kubebuilder:default=5432
@@ -149,6 +762,14 @@ type PortsSpec struct {
 	// Prometheus port
 	// +kubebuilder:default=9090
 	Prometheus int32 `json:"prometheus,omitempty"`
+
+	// pgraft port the members reach each other on
+	// +kubebuilder:default=7400
+	Raft int32 `json:"raft,omitempty"`
+
+	// postgres-exporter metrics port
+	// +kubebuilder:default=9187
+	Exporter int32 `json:"exporter,omitempty"`
 }
 
 // MonitoringSpec defines monitoring configuration
@@ -162,6 +783,64 @@ type MonitoringSpec struct {
 
 	// Prometheus configuration
 	Prometheus PrometheusSpec `json:"prometheus,omitempty"`
+
+	// How often Prometheus scrapes the RAMD and postgres-exporter endpoints
+	// +kubebuilder:default="30s"
+	ScrapeInterval string `json:"scrapeInterval,omitempty"`
+
+	// Labels to set on the ServiceMonitor and PodMonitor, e.g. to match a
+	// Prometheus serviceMonitorSelector
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// postgres-exporter sidecar of the PostgreSQL pods
+	Exporter ExporterSpec `json:"exporter,omitempty"`
+}
+
+// ExporterSpec defines the postgres-exporter sidecar
+type ExporterSpec struct {
+	// postgres-exporter image
+	// +kubebuilder:default="quay.io/prometheuscommunity/postgres-exporter:latest"
+	Image string `json:"image,omitempty"`
+
+	// Resource requirements
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+}
+
+// PoolerSpec defines the PgBouncer connection pooler.  One pooler sits in
+// front of the read-write Service and one in front of the read-only
+// Service, each with a Service of its own.
+type PoolerSpec struct {
+	// Deploy PgBouncer
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Number of PgBouncer pods of each pooler
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=2
+	Replicas int32 `json:"replicas,omitempty"`
+
+	// PgBouncer image
+	// +kubebuilder:default="edoburu/pgbouncer:latest"
+	Image string `json:"image,omitempty"`
+
+	// Resource requirements
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// When a server connection is returned to the pool: after each
+	// session, transaction or statement
+	// +kubebuilder:validation:Enum=session;transaction;statement
+	// +kubebuilder:default="transaction"
+	PoolMode string `json:"poolMode,omitempty"`
+
+	// Port PgBouncer listens on
+	// +kubebuilder:default=6432
+	Port int32 `json:"port,omitempty"`
+
+	// Look up the passwords of database users in PostgreSQL, so that every
+	// user can connect through the pooler and not only postgres
+	AuthPassthrough bool `json:"authPassthrough,omitempty"`
+
+	// Further settings of the [pgbouncer] section, e.g. max_client_conn
+	Parameters map[string]string `json:"parameters,omitempty"`
 }
 
 // GrafanaSpec defines Grafana configuration
@@ -185,10 +864,52 @@ type PrometheusSpec struct {
 	Retention string `json:"retention,omitempty"`
 }
 
+// SwitchoverSpec requests a planned switchover of the leader to a pod.
+// It is carried out once per RequestedAt; set a new RequestedAt to switch
+// over again.
+type SwitchoverSpec struct {
+	// Pod to make the leader
+	TargetPod string `json:"targetPod"`
+
+	// When the switchover was requested
+	RequestedAt metav1.Time `json:"requestedAt"`
+
+	// Switch over even if RAMD reports the target unhealthy
+	Force bool `json:"force,omitempty"`
+}
+
+// UpdateStrategySpec defines how the PostgreSQL pods are updated, e.g. to
+// a new minor version of PostgreSQL
+type UpdateStrategySpec struct {
+	// Switchover updates the replicas one at a time, switches over to an
+	// updated one and updates the old leader last; RollingUpdate leaves the
+	// update to the StatefulSet, which restarts the leader wherever it is
+	// +kubebuilder:validation:Enum=Switchover;RollingUpdate
+	// +kubebuilder:default="Switchover"
+	Type string `json:"type,omitempty"`
+
+	// Hold a Switchover update after the pod being updated, until unset
+	Paused bool `json:"paused,omitempty"`
+}
+
+// MaintenanceWindowSpec defines recurring windows for the operations that
+// restart the members or take the cluster down: updates of the PostgreSQL
+// pods and the cutover of a major upgrade.  One already under way runs on
+// to its next step that disrupts the cluster.
+type MaintenanceWindowSpec struct {
+	// Cron schedule of the starts of the windows, in UTC unless it starts
+	// with CRON_TZ=<zone>, e.g. "0 2 * * 6" for Saturdays at 02:00
+	Schedule string `json:"schedule"`
+
+	// How long each window stays open
+	// +kubebuilder:default="1h"
+	Duration string `json:"duration,omitempty"`
+}
+
 // PostgreSQLClusterStatus defines the observed state of PostgreSQLCluster
 type PostgreSQLClusterStatus struct {
 	// Current phase of the cluster
-	// +kubebuilder:validation:Enum=Pending;Running;Failed;Updating
+	// +kubebuilder:validation:Enum=Pending;Running;Failed;Updating;Hibernated
 	Phase string `json:"phase,omitempty"`
 
 	// Number of ready replicas
@@ -200,11 +921,216 @@ type PostgreSQLClusterStatus struct {
 	// Current leader node
 	Leader string `json:"leader,omitempty"`
 
+	// Generation of the spec the status was last set for
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
 	// Conditions represent the latest available observations
 	Conditions []metav1.Condition `json:"conditions,omitempty"`
 
 	// Endpoints for the cluster
 	Endpoints ClusterEndpoints `json:"endpoints,omitempty"`
+
+	// The failover in progress, or the last one
+	Failover *FailoverStatus `json:"failover,omitempty"`
+
+	// The switchover in progress, or the last one
+	Switchover *SwitchoverStatus `json:"switchover,omitempty"`
+
+	// The update of the PostgreSQL pods in progress, or the last one
+	Update *UpdateStatus `json:"update,omitempty"`
+
+	// Outcome of the scheduled backups
+	Backup *BackupStatus `json:"backup,omitempty"`
+
+	// Outcome of applying spec.managedRoles and spec.managedDatabases
+	ManagedObjects *ManagedObjectsStatus `json:"managedObjects,omitempty"`
+
+	// Data directory of the members, set when the cluster is created and
+	// by the cutover of a major upgrade
+	DataDirectory string `json:"dataDirectory,omitempty"`
+
+	// Version and image of PostgreSQL the members run, which follow
+	// spec.postgresql but for a new major version, which waits for the
+	// cutover of its upgrade
+	PostgreSQLVersion string `json:"postgresqlVersion,omitempty"`
+	PostgreSQLImage   string `json:"postgresqlImage,omitempty"`
+
+	// The major upgrade in progress, or the last one
+	MajorUpgrade *MajorUpgradeStatus `json:"majorUpgrade,omitempty"`
+
+	// The hibernation of the cluster, or the last one
+	Hibernation *HibernationStatus `json:"hibernation,omitempty"`
+
+	// Encryption at rest of the cluster's data, with spec.encryption
+	Encryption *EncryptionStatus `json:"encryption,omitempty"`
+}
+
+// EncryptionStatus records what of the cluster's data is encrypted at rest
+type EncryptionStatus struct {
+	// StorageClass the data volumes are provisioned with
+	StorageClass string `json:"storageClass,omitempty"`
+
+	// Whether that StorageClass encrypts them
+	StorageEncrypted bool `json:"storageEncrypted"`
+
+	// Whether the backups and archived WAL are encrypted; false too if
+	// there are none
+	BackupsEncrypted bool `json:"backupsEncrypted"`
+}
+
+// HibernationStatus records the cluster going to sleep with spec.hibernate
+// and waking up
+type HibernationStatus struct {
+	// Current phase of the hibernation
+	// +kubebuilder:validation:Enum=SwitchingOver;Stopping;Hibernated;Resuming;Resumed
+	Phase string `json:"phase"`
+
+	// What the phase waits for
+	Message string `json:"message,omitempty"`
+
+	// When the leadership was asked to move to the first pod, which stops
+	// last and starts first
+	SwitchoverStartedAt *metav1.Time `json:"switchoverStartedAt,omitempty"`
+
+	// When the hibernation was asked for, when every pod had stopped, and
+	// when the cluster was back
+	StartedAt    metav1.Time  `json:"startedAt"`
+	HibernatedAt *metav1.Time `json:"hibernatedAt,omitempty"`
+	ResumedAt    *metav1.Time `json:"resumedAt,omitempty"`
+}
+
+// MajorUpgradeStatus records an upgrade to a new major version of
+// PostgreSQL
+type MajorUpgradeStatus struct {
+	// Version, image and data directory upgraded from
+	FromVersion       string `json:"fromVersion"`
+	FromImage         string `json:"fromImage"`
+	FromDataDirectory string `json:"fromDataDirectory,omitempty"`
+
+	// Version and image upgraded to
+	ToVersion string `json:"toVersion"`
+	ToImage   string `json:"toImage"`
+
+	// Current phase of the upgrade
+	// +kubebuilder:validation:Enum=AwaitingCheck;Checking;CheckFailed;AwaitingCutover;SwitchingOver;Stopping;Upgrading;Starting;Completed;RollingBack;RolledBack;Withdrawn
+	Phase string `json:"phase"`
+
+	// What the phase waits for, or why the upgrade failed
+	Message string `json:"message,omitempty"`
+
+	// When the upgrade started, when its phase did, and when it completed
+	StartedAt      metav1.Time  `json:"startedAt"`
+	PhaseStartedAt metav1.Time  `json:"phaseStartedAt"`
+	CompletedAt    *metav1.Time `json:"completedAt,omitempty"`
+}
+
+// UpdateStatus records an update of the PostgreSQL pods with the
+// Switchover strategy
+type UpdateStatus struct {
+	// StatefulSet revision the pods are updated to
+	Revision string `json:"revision"`
+
+	// Current phase of the update
+	// +kubebuilder:validation:Enum=UpdatingReplicas;SwitchingOver;UpdatingLeader;Completed
+	Phase string `json:"phase"`
+
+	// Pods on the revision, of all pods
+	Updated int32 `json:"updated,omitempty"`
+	Total   int32 `json:"total,omitempty"`
+
+	// Updated replica the leadership is switched over to, and when
+	SwitchoverTarget    string       `json:"switchoverTarget,omitempty"`
+	SwitchoverStartedAt *metav1.Time `json:"switchoverStartedAt,omitempty"`
+
+	// What the update waits for
+	Message string `json:"message,omitempty"`
+
+	// When the update started and completed
+	StartedAt   metav1.Time  `json:"startedAt"`
+	CompletedAt *metav1.Time `json:"completedAt,omitempty"`
+}
+
+// ManagedObjectsStatus records the Jobs that apply the managed roles and
+// databases to the primary
+type ManagedObjectsStatus struct {
+	// Digest of the managed roles and databases, and of their password
+	// Secrets, last applied successfully
+	Applied string `json:"applied,omitempty"`
+
+	// Job of the last attempt, and whether it is Running, Succeeded or Failed
+	LastJob    string `json:"lastJob,omitempty"`
+	LastResult string `json:"lastResult,omitempty"`
+}
+
+// BackupStatus records the scheduled backups
+type BackupStatus struct {
+	// When the last backup was started
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty"`
+
+	// When the last backup that succeeded completed
+	LastSuccessfulTime *metav1.Time `json:"lastSuccessfulTime,omitempty"`
+
+	// Job of the last backup, and whether it is Running, Succeeded or Failed
+	LastJob    string `json:"lastJob,omitempty"`
+	LastResult string `json:"lastResult,omitempty"`
+
+	// Outcome of the verifications of spec.postgresql.backup.verification
+	Verification *BackupVerificationStatus `json:"verification,omitempty"`
+}
+
+// BackupVerificationStatus records the restores verifying the backups
+type BackupVerificationStatus struct {
+	// When the last verification was started
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty"`
+
+	// When the last verification that succeeded completed
+	LastSuccessfulTime *metav1.Time `json:"lastSuccessfulTime,omitempty"`
+
+	// Job of the last verification, and whether it is Running, Succeeded
+	// or Failed
+	LastJob    string `json:"lastJob,omitempty"`
+	LastResult string `json:"lastResult,omitempty"`
+}
+
+// FailoverStatus records a failover the operator ran
+type FailoverStatus struct {
+	// Step the failover is at
+	// +kubebuilder:validation:Enum=Electing;Promoting;Fencing;Completed;Failed
+	Phase string `json:"phase"`
+
+	// Leader pod that became unhealthy
+	OldLeader string `json:"oldLeader"`
+
+	// Pod promoted in its place, once raft elected one
+	NewLeader string `json:"newLeader,omitempty"`
+
+	// When the failover started and ended
+	StartedAt   metav1.Time  `json:"startedAt"`
+	CompletedAt *metav1.Time `json:"completedAt,omitempty"`
+
+	// What the failover is waiting for, or why it failed
+	Message string `json:"message,omitempty"`
+}
+
+// SwitchoverStatus records a switchover the operator ran
+type SwitchoverStatus struct {
+	// Step the switchover is at
+	// +kubebuilder:validation:Enum=Transferring;Confirming;Completed;Failed
+	Phase string `json:"phase"`
+
+	// The request it carries out, from SwitchoverSpec
+	TargetPod   string      `json:"targetPod"`
+	RequestedAt metav1.Time `json:"requestedAt"`
+
+	// Leader pod when the switchover started
+	OldLeader string `json:"oldLeader,omitempty"`
+
+	// When the switchover started and ended
+	StartedAt   metav1.Time  `json:"startedAt"`
+	CompletedAt *metav1.Time `json:"completedAt,omitempty"`
+
+	// What the switchover is waiting for, or why it failed
+	Message string `json:"message,omitempty"`
 }
 
 // ClusterEndpoints defines cluster endpoints
@@ -214,10 +1140,22 @@ type ClusterEndpoints struct {
 
 	// Replica endpoints
 	Replicas []string `json:"replicas,omitempty"`
+
+	// Read-write Service, which follows the leader across failovers
+	ReadWrite string `json:"readWrite,omitempty"`
+
+	// Read-only Service, which reaches the replicas
+	ReadOnly string `json:"readOnly,omitempty"`
+
+	// Address of each member from outside Kubernetes, by pod, with
+	// spec.networking.externalAccess; a member is missing until its
+	// Service has an address
+	External map[string]string `json:"external,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:storageversion
 //+kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase"
 //+kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.readyReplicas"
 //+kubebuilder:printcolumn:name="Total",type="integer",JSONPath=".status.totalReplicas"
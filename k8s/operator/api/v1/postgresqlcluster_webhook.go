@@ -0,0 +1,609 @@
+package v1
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// minPostgreSQLVersion is the oldest major version pgraft supports
+const minPostgreSQLVersion = 15
+
+// operatorParameters are the PostgreSQL settings the operator renders
+// itself, which spec.postgresql.parameters must leave alone
+var operatorParameters = []string{
+	"config_file", "hba_file", "data_directory", "listen_addresses", "port",
+	"pgraft.cluster_name", "pgraft.port", "pgraft.node_id", "pgraft.node_name",
+	"pgraft.address", "pgraft.peers", "pgraft.cluster_size",
+}
+
+// operatorContainers and operatorVolumes are the names of the containers
+// and volumes the operator puts in the pods, which the user's must not take
+var (
+	operatorContainers = []string{"postgresql", "ramd", "postgres-exporter", "wal-g", "bootstrap"}
+	operatorVolumes    = []string{
+		"postgresql-data", "postgresql-config", "postgresql-hba", "ramd-config", "wal-g",
+		"wal-g-credentials", "bootstrap-credentials", "standby-credentials", "wal-g-key", "bootstrap-key",
+		"standby-key", "wal-g-kms", "tmp", "run",
+	}
+)
+
+// SetupWebhookWithManager registers the defaulting and validating webhooks
+// with the manager
+func (r *PostgreSQLCluster) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		WithDefaulter(&postgreSQLClusterDefaulter{}).
+		WithValidator(&postgreSQLClusterValidator{reader: mgr.GetAPIReader()}).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-ram-pgelephant-com-v1-postgresqlcluster,mutating=true,failurePolicy=fail,sideEffects=None,groups=ram.pgelephant.com,resources=postgresqlclusters,verbs=create;update,versions=v1,name=mpostgresqlcluster.ram.pgelephant.com,admissionReviewVersions=v1
+
+// postgreSQLClusterDefaulter is the defaulting webhook of
+// PostgreSQLClusters
+type postgreSQLClusterDefaulter struct{}
+
+var _ admission.CustomDefaulter = &postgreSQLClusterDefaulter{}
+
+// Default sets the defaults of the unset fields of a PostgreSQLCluster, so
+// that they are stored with the object
+func (d *postgreSQLClusterDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	cluster, ok := obj.(*PostgreSQLCluster)
+	if !ok {
+		return fmt.Errorf("expected a PostgreSQLCluster, got %T", obj)
+	}
+	cluster.Default()
+	return nil
+}
+
+// Default sets the defaults of unset fields.  The defaulting webhook calls
+// it, and the controller too in case the webhook is not serving.
+func (r *PostgreSQLCluster) Default() {
+	if r.Spec.PostgreSQL.Version == "" {
+		r.Spec.PostgreSQL.Version = "17"
+	}
+	if r.Spec.PostgreSQL.Image == "" {
+		r.Spec.PostgreSQL.Image = "postgres:17"
+	}
+	if r.Spec.RAMD.Image == "" {
+		r.Spec.RAMD.Image = "pgraft/ramd:latest"
+	}
+	if r.Spec.RAMD.Mode == "" {
+		r.Spec.RAMD.Mode = "Sidecar"
+	}
+	for i := range r.Spec.PostgreSQL.PgHBA {
+		if r.Spec.PostgreSQL.PgHBA[i].Database == "" {
+			r.Spec.PostgreSQL.PgHBA[i].Database = "all"
+		}
+		if r.Spec.PostgreSQL.PgHBA[i].User == "" {
+			r.Spec.PostgreSQL.PgHBA[i].User = "all"
+		}
+	}
+	if r.Spec.PostgreSQL.Backup.Image == "" {
+		r.Spec.PostgreSQL.Backup.Image = "pgraft/wal-g:latest"
+	}
+	if r.Spec.PostgreSQL.Backup.Schedule == "" {
+		r.Spec.PostgreSQL.Backup.Schedule = "0 2 * * *"
+	}
+	if r.Spec.PostgreSQL.Backup.Retention == 0 {
+		r.Spec.PostgreSQL.Backup.Retention = 7
+	}
+	if verification := r.Spec.PostgreSQL.Backup.Verification; verification != nil {
+		if verification.Schedule == "" {
+			verification.Schedule = "0 5 * * 0"
+		}
+		if verification.Database == "" {
+			verification.Database = "postgres"
+		}
+		if verification.Timeout == "" {
+			verification.Timeout = "2h"
+		}
+	}
+	var encryptions []*ArchiveEncryptionSpec
+	if archive := r.Spec.PostgreSQL.WALArchive; archive != nil {
+		if archive.Compression == "" {
+			archive.Compression = "lz4"
+		}
+		encryptions = append(encryptions, archive.Encryption)
+	}
+	if r.Spec.Restore != nil {
+		encryptions = append(encryptions, r.Spec.Restore.Encryption)
+	}
+	if r.Spec.Standby != nil {
+		encryptions = append(encryptions, r.Spec.Standby.Encryption)
+	}
+	for _, encryption := range encryptions {
+		if encryption != nil && encryption.Method == "" {
+			encryption.Method = "libsodium"
+		}
+	}
+	if r.Spec.Networking.ServiceType == "" {
+		r.Spec.Networking.ServiceType = corev1.ServiceTypeClusterIP
+	}
+	if external := r.Spec.Networking.ExternalAccess; external != nil {
+		if external.Type == "" {
+			external.Type = corev1.ServiceTypeLoadBalancer
+		}
+		if external.ExternalTrafficPolicy == "" {
+			external.ExternalTrafficPolicy = corev1.ServiceExternalTrafficPolicyTypeCluster
+		}
+	}
+	if r.Spec.Networking.Ports.PostgreSQL == 0 {
+		r.Spec.Networking.Ports.PostgreSQL = 5432
+	}
+	if r.Spec.Networking.Ports.RAMD == 0 {
+		r.Spec.Networking.Ports.RAMD = 8080
+	}
+	if r.Spec.Networking.Ports.Prometheus == 0 {
+		r.Spec.Networking.Ports.Prometheus = 9090
+	}
+	if r.Spec.Networking.Ports.Raft == 0 {
+		r.Spec.Networking.Ports.Raft = 7400
+	}
+	if r.Spec.Networking.Ports.Exporter == 0 {
+		r.Spec.Networking.Ports.Exporter = 9187
+	}
+	if r.Spec.Monitoring.Exporter.Image == "" {
+		r.Spec.Monitoring.Exporter.Image = "quay.io/prometheuscommunity/postgres-exporter:latest"
+	}
+	if r.Spec.Monitoring.ScrapeInterval == "" {
+		r.Spec.Monitoring.ScrapeInterval = "30s"
+	}
+	if r.Spec.Pooler.Replicas == 0 {
+		r.Spec.Pooler.Replicas = 2
+	}
+	if r.Spec.Pooler.Image == "" {
+		r.Spec.Pooler.Image = "edoburu/pgbouncer:latest"
+	}
+	if r.Spec.Pooler.PoolMode == "" {
+		r.Spec.Pooler.PoolMode = "transaction"
+	}
+	if r.Spec.Pooler.Port == 0 {
+		r.Spec.Pooler.Port = 6432
+	}
+	if r.Spec.Restore != nil && r.Spec.Restore.Backup == "" {
+		r.Spec.Restore.Backup = "LATEST"
+	}
+	if r.Spec.DataSource != nil && r.Spec.DataSource.Method == "" {
+		r.Spec.DataSource.Method = "backup"
+	}
+	if r.Spec.Standby != nil && r.Spec.Standby.Port == 0 {
+		r.Spec.Standby.Port = 5432
+	}
+	if r.Spec.UpdateStrategy.Type == "" {
+		r.Spec.UpdateStrategy.Type = "Switchover"
+	}
+	if r.Spec.MaintenanceWindow != nil && r.Spec.MaintenanceWindow.Duration == "" {
+		r.Spec.MaintenanceWindow.Duration = "1h"
+	}
+	if r.Spec.SelfHeal == nil {
+		selfHeal := true
+		r.Spec.SelfHeal = &selfHeal
+	}
+	if r.Spec.Teardown.DataPolicy == "" {
+		r.Spec.Teardown.DataPolicy = "Retain"
+	}
+	if r.Spec.Teardown.BackupPolicy == "" {
+		r.Spec.Teardown.BackupPolicy = "Retain"
+	}
+	for i := range r.Spec.ManagedRoles {
+		if r.Spec.ManagedRoles[i].Ensure == "" {
+			r.Spec.ManagedRoles[i].Ensure = EnsurePresent
+		}
+	}
+	for i := range r.Spec.ManagedDatabases {
+		if r.Spec.ManagedDatabases[i].Ensure == "" {
+			r.Spec.ManagedDatabases[i].Ensure = EnsurePresent
+		}
+	}
+	if bootstrap := r.Spec.Bootstrap; bootstrap != nil {
+		for i := range bootstrap.Roles {
+			if bootstrap.Roles[i].Ensure == "" {
+				bootstrap.Roles[i].Ensure = EnsurePresent
+			}
+		}
+		for i := range bootstrap.Databases {
+			if bootstrap.Databases[i].Ensure == "" {
+				bootstrap.Databases[i].Ensure = EnsurePresent
+			}
+		}
+		for i := range bootstrap.SQLScripts {
+			if bootstrap.SQLScripts[i].Database == "" {
+				bootstrap.SQLScripts[i].Database = "postgres"
+			}
+		}
+	}
+}
+
+//+kubebuilder:webhook:path=/validate-ram-pgelephant-com-v1-postgresqlcluster,mutating=false,failurePolicy=fail,sideEffects=None,groups=ram.pgelephant.com,resources=postgresqlclusters,verbs=create;update,versions=v1,name=vpostgresqlcluster.ram.pgelephant.com,admissionReviewVersions=v1
+
+// postgreSQLClusterValidator is the validating webhook of
+// PostgreSQLClusters
+type postgreSQLClusterValidator struct {
+	// Reads StorageClasses, to enforce
+	// spec.encryption.requireEncryptedStorage
+	reader client.Reader
+}
+
+var _ admission.CustomValidator = &postgreSQLClusterValidator{}
+
+// ValidateCreate rejects an invalid spec
+func (v *postgreSQLClusterValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	cluster, ok := obj.(*PostgreSQLCluster)
+	if !ok {
+		return nil, fmt.Errorf("expected a PostgreSQLCluster, got %T", obj)
+	}
+
+	errs := cluster.validateSpec()
+	errs = append(errs, cluster.validateStorageEncryption(ctx, v.reader)...)
+	return nil, cluster.invalid(errs)
+}
+
+// ValidateUpdate rejects an invalid spec and changes the cluster cannot
+// carry out
+func (v *postgreSQLClusterValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	cluster, ok := newObj.(*PostgreSQLCluster)
+	if !ok {
+		return nil, fmt.Errorf("expected a PostgreSQLCluster, got %T", newObj)
+	}
+	previous, ok := oldObj.(*PostgreSQLCluster)
+	if !ok {
+		return nil, fmt.Errorf("expected a PostgreSQLCluster, got %T", oldObj)
+	}
+
+	errs := cluster.validateSpec()
+	errs = append(errs, cluster.validateStorageEncryption(ctx, v.reader)...)
+	errs = append(errs, cluster.validateUpdate(previous)...)
+	return nil, cluster.invalid(errs)
+}
+
+// ValidateDelete allows every deletion
+func (v *postgreSQLClusterValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// validateUpdate returns the changes from previous the cluster cannot
+// carry out: shrinking the volumes, changing the parameters they are
+// provisioned with or how the cluster is initialized, going back a major
+// version other than to withdraw an upgrade that has not cut over, or
+// changing the version while a major upgrade cuts over
+func (r *PostgreSQLCluster) validateUpdate(previous *PostgreSQLCluster) field.ErrorList {
+	var errs field.ErrorList
+	spec := field.NewPath("spec")
+
+	var parameters, previousParameters map[string]string
+	if r.Spec.Encryption != nil {
+		parameters = r.Spec.Encryption.VolumeParameters
+	}
+	if previous.Spec.Encryption != nil {
+		previousParameters = previous.Spec.Encryption.VolumeParameters
+	}
+	if !equality.Semantic.DeepEqual(parameters, previousParameters) {
+		errs = append(errs, field.Forbidden(spec.Child("encryption", "volumeParameters"),
+			"cannot change once the cluster's volumes are provisioned"))
+	}
+	if !equality.Semantic.DeepEqual(r.Spec.Bootstrap, previous.Spec.Bootstrap) {
+		errs = append(errs, field.Forbidden(spec.Child("bootstrap"), "is only read when the cluster is created"))
+	}
+
+	size := spec.Child("postgresql", "storage", "size")
+	newSize, err := resource.ParseQuantity(r.Spec.PostgreSQL.Storage.Size)
+	oldSize, oldErr := resource.ParseQuantity(previous.Spec.PostgreSQL.Storage.Size)
+	if err == nil && oldErr == nil && newSize.Cmp(oldSize) < 0 {
+		errs = append(errs, field.Forbidden(size,
+			fmt.Sprintf("cannot shrink volumes from %s", previous.Spec.PostgreSQL.Storage.Size)))
+	}
+
+	version := spec.Child("postgresql", "version")
+	upgrade := previous.Status.MajorUpgrade
+	cuttingOver := false
+	if upgrade != nil {
+		for _, phase := range cutoverPhases {
+			cuttingOver = cuttingOver || upgrade.Phase == phase
+		}
+	}
+	newMajor, err := majorVersion(r.Spec.PostgreSQL.Version)
+	oldMajor, oldErr := majorVersion(previous.Spec.PostgreSQL.Version)
+	running, runningErr := majorVersion(previous.Status.PostgreSQLVersion)
+	switch {
+	case cuttingOver && r.Spec.PostgreSQL.Version != previous.Spec.PostgreSQL.Version:
+		errs = append(errs, field.Forbidden(version,
+			fmt.Sprintf("cannot change while the cluster cuts over to PostgreSQL %s", upgrade.ToVersion)))
+	case err == nil && oldErr == nil && newMajor < oldMajor && (runningErr != nil || newMajor != running):
+		errs = append(errs, field.Forbidden(version,
+			fmt.Sprintf("cannot downgrade from PostgreSQL %d", oldMajor)))
+	}
+
+	return errs
+}
+
+// validateSpec returns what is wrong with the spec on its own
+func (r *PostgreSQLCluster) validateSpec() field.ErrorList {
+	var errs field.ErrorList
+	spec := field.NewPath("spec")
+
+	// Raft commits with a majority; an even member count tolerates no more
+	// failures than one member fewer and can split evenly
+	if r.Spec.Replicas%2 == 0 {
+		errs = append(errs, field.Invalid(spec.Child("replicas"), r.Spec.Replicas,
+			"must be odd so that the members can always form a quorum"))
+	}
+
+	size := spec.Child("postgresql", "storage", "size")
+	if r.Spec.PostgreSQL.Storage.Size != "" {
+		if _, err := resource.ParseQuantity(r.Spec.PostgreSQL.Storage.Size); err != nil {
+			errs = append(errs, field.Invalid(size, r.Spec.PostgreSQL.Storage.Size, err.Error()))
+		}
+	}
+
+	version := spec.Child("postgresql", "version")
+	if r.Spec.PostgreSQL.Version != "" {
+		major, err := majorVersion(r.Spec.PostgreSQL.Version)
+		if err != nil {
+			errs = append(errs, field.Invalid(version, r.Spec.PostgreSQL.Version, err.Error()))
+		} else if major < minPostgreSQLVersion {
+			errs = append(errs, field.NotSupported(version, r.Spec.PostgreSQL.Version,
+				[]string{fmt.Sprintf("%d or later", minPostgreSQLVersion)}))
+		}
+	}
+
+	// The PostgreSQL pods listen on the PostgreSQL, raft and exporter
+	// ports and RAMD on its own two; keep all of them apart
+	ports := spec.Child("networking", "ports")
+	seen := map[int32]string{}
+	for _, port := range []struct {
+		name   string
+		number int32
+	}{
+		{"postgresql", r.Spec.Networking.Ports.PostgreSQL},
+		{"ramd", r.Spec.Networking.Ports.RAMD},
+		{"prometheus", r.Spec.Networking.Ports.Prometheus},
+		{"raft", r.Spec.Networking.Ports.Raft},
+		{"exporter", r.Spec.Networking.Ports.Exporter},
+	} {
+		if port.number == 0 {
+			continue
+		}
+		if other, ok := seen[port.number]; ok {
+			errs = append(errs, field.Duplicate(ports.Child(port.name),
+				fmt.Sprintf("%d, also the %s port", port.number, other)))
+			continue
+		}
+		seen[port.number] = port.name
+	}
+
+	if external := r.Spec.Networking.ExternalAccess; external != nil {
+		path := spec.Child("networking", "externalAccess")
+		if external.Domain != "" {
+			if msgs := validation.IsDNS1123Subdomain(external.Domain); len(msgs) > 0 {
+				errs = append(errs, field.Invalid(path.Child("domain"), external.Domain, strings.Join(msgs, "; ")))
+			}
+		}
+		for i, cidr := range external.LoadBalancerSourceRanges {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				errs = append(errs, field.Invalid(path.Child("loadBalancerSourceRanges").Index(i), cidr, err.Error()))
+			}
+		}
+	}
+
+	if window := r.Spec.MaintenanceWindow; window != nil {
+		path := spec.Child("maintenanceWindow")
+		if _, err := cron.ParseStandard(window.Schedule); err != nil {
+			errs = append(errs, field.Invalid(path.Child("schedule"), window.Schedule, err.Error()))
+		}
+		if window.Duration != "" {
+			if duration, err := time.ParseDuration(window.Duration); err != nil || duration <= 0 {
+				errs = append(errs, field.Invalid(path.Child("duration"), window.Duration, "must be a positive duration such as 1h"))
+			}
+		}
+	}
+
+	if verification := r.Spec.PostgreSQL.Backup.Verification; verification != nil {
+		path := spec.Child("postgresql", "backup", "verification")
+		if verification.Schedule != "" {
+			if _, err := cron.ParseStandard(verification.Schedule); err != nil {
+				errs = append(errs, field.Invalid(path.Child("schedule"), verification.Schedule, err.Error()))
+			}
+		}
+		if verification.Timeout != "" {
+			if timeout, err := time.ParseDuration(verification.Timeout); err != nil || timeout <= 0 {
+				errs = append(errs, field.Invalid(path.Child("timeout"), verification.Timeout, "must be a positive duration such as 2h"))
+			}
+		}
+	}
+
+	if archive := r.Spec.PostgreSQL.WALArchive; archive != nil {
+		path := spec.Child("postgresql", "walArchive")
+		backup := r.Spec.PostgreSQL.Backup.Repository
+		switch {
+		case archive.Repository == nil && backup == nil:
+			errs = append(errs, field.Required(path.Child("repository"),
+				"set it or spec.postgresql.backup.repository"))
+		case archive.Repository != nil && backup != nil && *archive.Repository != *backup:
+			errs = append(errs, field.Invalid(path.Child("repository"), archive.Repository.Bucket,
+				"must be spec.postgresql.backup.repository, as a backup is restored with the WAL archived after it"))
+		}
+	}
+
+	parameters := spec.Child("postgresql", "parameters")
+	for _, name := range operatorParameters {
+		if _, ok := r.Spec.PostgreSQL.Parameters[name]; ok {
+			errs = append(errs, field.Forbidden(parameters.Key(name), "is set by the operator"))
+		}
+	}
+
+	// Each option is written as name="value" on the rule's line
+	hba := spec.Child("postgresql", "pgHBA")
+	for i, rule := range r.Spec.PostgreSQL.PgHBA {
+		for name, value := range rule.Options {
+			if strings.ContainsAny(name, " \t\r\n=\"") || strings.ContainsAny(value, "\r\n\"") {
+				errs = append(errs, field.Invalid(hba.Index(i).Child("options").Key(name), value,
+					"must not contain quotes or line breaks"))
+			}
+		}
+	}
+
+	for _, pods := range []struct {
+		path   *field.Path
+		extras PodExtrasSpec
+	}{
+		{spec.Child("postgresql"), r.Spec.PostgreSQL.PodExtrasSpec},
+		{spec.Child("ramd"), r.Spec.RAMD.PodExtrasSpec},
+	} {
+		errs = append(errs, validatePodExtras(pods.path, pods.extras)...)
+	}
+
+	// The operator connects as postgres, so it must not change or drop it
+	roles := spec.Child("managedRoles")
+	for i, role := range r.Spec.ManagedRoles {
+		if role.Name == "postgres" {
+			errs = append(errs, field.Forbidden(roles.Index(i).Child("name"), "postgres is managed by the operator"))
+		}
+	}
+	if encryption := r.Spec.Encryption; encryption != nil {
+		path := spec.Child("encryption")
+		repository := r.Spec.PostgreSQL.Backup.Repository
+		if archive := r.Spec.PostgreSQL.WALArchive; archive != nil && archive.Repository != nil {
+			repository = archive.Repository
+		}
+		archived := repository != nil && (r.Spec.PostgreSQL.Backup.Enabled || r.Spec.PostgreSQL.WALArchive != nil)
+		if encryption.KMS != nil && repository != nil && repository.Type != "s3" {
+			errs = append(errs, field.Invalid(path.Child("kms"), repository.Type,
+				"is only supported with an s3 repository"))
+		}
+		if encryption.RequireEncryptedBackups && archived && encryption.KMS == nil &&
+			(r.Spec.PostgreSQL.WALArchive == nil || r.Spec.PostgreSQL.WALArchive.Encryption == nil) {
+			errs = append(errs, field.Required(path.Child("kms"),
+				"set it or spec.postgresql.walArchive.encryption, as encrypted backups are required"))
+		}
+	}
+
+	databases := spec.Child("managedDatabases")
+	for i, database := range r.Spec.ManagedDatabases {
+		if database.Name == "postgres" && database.Ensure == EnsureAbsent {
+			errs = append(errs, field.Forbidden(databases.Index(i).Child("ensure"), "the postgres database cannot be dropped"))
+		}
+	}
+
+	if bootstrap := r.Spec.Bootstrap; bootstrap != nil {
+		path := spec.Child("bootstrap")
+		if r.Spec.Restore != nil || r.Spec.DataSource != nil || r.Spec.Standby != nil {
+			errs = append(errs, field.Forbidden(path,
+				"a cluster created from spec.restore, spec.dataSource or spec.standby is not initialized"))
+		}
+		for i, role := range bootstrap.Roles {
+			if role.Name == "postgres" {
+				errs = append(errs, field.Forbidden(path.Child("roles").Index(i).Child("name"), "postgres is managed by the operator"))
+			}
+			if role.Ensure == EnsureAbsent {
+				errs = append(errs, field.NotSupported(path.Child("roles").Index(i).Child("ensure"), role.Ensure, []string{EnsurePresent}))
+			}
+		}
+		for i, database := range bootstrap.Databases {
+			if database.Ensure == EnsureAbsent {
+				errs = append(errs, field.NotSupported(path.Child("databases").Index(i).Child("ensure"), database.Ensure, []string{EnsurePresent}))
+			}
+		}
+		for i, script := range bootstrap.SQLScripts {
+			for j, key := range script.Keys {
+				for _, msg := range validation.IsConfigMapKey(key) {
+					errs = append(errs, field.Invalid(path.Child("sqlScripts").Index(i).Child("keys").Index(j), key, msg))
+				}
+			}
+		}
+	}
+
+	return errs
+}
+
+// validateStorageEncryption rejects a StorageClass that does not encrypt
+// the data volumes if spec.encryption.requireEncryptedStorage is set.  The
+// class is looked up with reader, so this is left out of validateSpec,
+// which needs none.
+func (r *PostgreSQLCluster) validateStorageEncryption(ctx context.Context, reader client.Reader) field.ErrorList {
+	encryption := r.Spec.Encryption
+	if encryption == nil || !encryption.RequireEncryptedStorage {
+		return nil
+	}
+	path := field.NewPath("spec", "postgresql", "storage", "storageClass")
+
+	class, err := BaseStorageClass(ctx, reader, r)
+	if err != nil {
+		return field.ErrorList{field.Invalid(path, r.Spec.PostgreSQL.Storage.StorageClass, err.Error())}
+	}
+	if !StorageParametersEncrypt(class.Provisioner, VolumeParameters(r, class), encryption.EncryptedStorageParameters) {
+		return field.ErrorList{field.Invalid(path, class.Name,
+			"does not encrypt its volumes, which spec.encryption.requireEncryptedStorage requires; "+
+				"set spec.encryption.volumeParameters or pick another class")}
+	}
+	return nil
+}
+
+// validatePodExtras rejects containers and volumes of the user's that
+// take the name of one of the operator's
+func validatePodExtras(path *field.Path, extras PodExtrasSpec) field.ErrorList {
+	var errs field.ErrorList
+	reserved := func(names []string, name string) bool {
+		for _, n := range names {
+			if n == name {
+				return true
+			}
+		}
+		return false
+	}
+	for i, container := range extras.Sidecars {
+		if reserved(operatorContainers, container.Name) {
+			errs = append(errs, field.Forbidden(path.Child("sidecars").Index(i).Child("name"), "is the name of a container of the operator"))
+		}
+	}
+	for i, container := range extras.InitContainers {
+		if reserved(operatorContainers, container.Name) {
+			errs = append(errs, field.Forbidden(path.Child("initContainers").Index(i).Child("name"), "is the name of a container of the operator"))
+		}
+	}
+	for i, volume := range extras.Volumes {
+		if reserved(operatorVolumes, volume.Name) {
+			errs = append(errs, field.Forbidden(path.Child("volumes").Index(i).Child("name"), "is the name of a volume of the operator"))
+		}
+	}
+	return errs
+}
+
+// invalid returns errs as the Invalid error the API server reports, or nil
+func (r *PostgreSQLCluster) invalid(errs field.ErrorList) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("PostgreSQLCluster").GroupKind(), r.Name, errs)
+}
+
+// cutoverPhases are the phases of a major upgrade from which the cluster
+// can only go on to the new version or roll back to the old one
+var cutoverPhases = []string{"Stopping", "Upgrading", "Starting", "RollingBack"}
+
+// majorVersion returns the major version of a PostgreSQL version such as
+// "17" or "16.4"
+func majorVersion(version string) (int, error) {
+	major, _, _ := strings.Cut(version, ".")
+	n, err := strconv.Atoi(major)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("not a PostgreSQL version")
+	}
+	return n, nil
+}
@@ -0,0 +1,21 @@
+// Package v1beta1 contains the v1beta1 API of the ram.pgelephant.com group,
+// converted to and from the v1 storage version by the conversion webhook
+// +kubebuilder:object:generate=true
+// +groupName=ram.pgelephant.com
+package v1beta1
+
+import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/scheme"
+)
+
+var (
+	// GroupVersion is the group version used to register these objects
+	GroupVersion = schema.GroupVersion{Group: "ram.pgelephant.com", Version: "v1beta1"}
+
+	// SchemeBuilder is used to add go types to the GroupVersionKind scheme
+	SchemeBuilder = &scheme.Builder{GroupVersion: GroupVersion}
+
+	// AddToScheme adds the types in this group-version to the given scheme
+	AddToScheme = SchemeBuilder.AddToScheme
+)
@@ -0,0 +1,105 @@
+package v1beta1
+
+import (
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	ramv1 "github.com/pgelephant/pgraft/k8s/operator/api/v1"
+)
+
+var _ conversion.Convertible = &PostgreSQLCluster{}
+
+// ConvertTo converts this PostgreSQLCluster to the v1 hub version
+func (src *PostgreSQLCluster) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*ramv1.PostgreSQLCluster)
+
+	dst.ObjectMeta = src.ObjectMeta
+	dst.Spec = ramv1.PostgreSQLClusterSpec{
+		Replicas: src.Spec.Replicas,
+		PostgreSQL: ramv1.PostgreSQLSpec{
+			Version:            src.Spec.PostgreSQL.Version,
+			Image:              src.Spec.PostgreSQL.Image,
+			Resources:          src.Spec.PostgreSQL.Resources,
+			Parameters:         src.Spec.PostgreSQL.Parameters,
+			PgHBA:              src.Spec.PostgreSQL.PgHBA,
+			Storage:            src.Spec.PostgreSQL.Storage,
+			WALArchive:         src.Spec.PostgreSQL.WALArchive,
+			Backup:             src.Spec.Backup,
+			PodSecurityContext: src.Spec.PostgreSQL.PodSecurityContext,
+			SecurityContext:    src.Spec.PostgreSQL.SecurityContext,
+			ServiceAccountName: src.Spec.PostgreSQL.ServiceAccountName,
+			SchedulingSpec:     src.Spec.PostgreSQL.SchedulingSpec,
+			PodExtrasSpec:      src.Spec.PostgreSQL.PodExtrasSpec,
+			MajorUpgrade:       src.Spec.PostgreSQL.MajorUpgrade,
+		},
+		RAMD:              src.Spec.RAMD,
+		Networking:        src.Spec.Networking,
+		Monitoring:        src.Spec.Monitoring,
+		Pooler:            src.Spec.Pooler,
+		Failover:          src.Spec.Failover,
+		Switchover:        src.Spec.Switchover,
+		UpdateStrategy:    src.Spec.UpdateStrategy,
+		MaintenanceWindow: src.Spec.MaintenanceWindow,
+		Hibernate:         src.Spec.Hibernate,
+		SelfHeal:          src.Spec.SelfHeal,
+		Credentials:       src.Spec.Credentials,
+		ImagePullSecrets:  src.Spec.ImagePullSecrets,
+		Encryption:        src.Spec.Encryption,
+		Bootstrap:         src.Spec.Bootstrap,
+		Restore:           src.Spec.Restore,
+		DataSource:        src.Spec.DataSource,
+		Standby:           src.Spec.Standby,
+		Teardown:          src.Spec.Teardown,
+		ManagedRoles:      src.Spec.ManagedRoles,
+		ManagedDatabases:  src.Spec.ManagedDatabases,
+	}
+	dst.Status = src.Status
+	return nil
+}
+
+// ConvertFrom converts from the v1 hub version to this version
+func (dst *PostgreSQLCluster) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*ramv1.PostgreSQLCluster)
+
+	dst.ObjectMeta = src.ObjectMeta
+	dst.Spec = PostgreSQLClusterSpec{
+		Replicas: src.Spec.Replicas,
+		PostgreSQL: PostgreSQLSpec{
+			Version:            src.Spec.PostgreSQL.Version,
+			Image:              src.Spec.PostgreSQL.Image,
+			Resources:          src.Spec.PostgreSQL.Resources,
+			Parameters:         src.Spec.PostgreSQL.Parameters,
+			PgHBA:              src.Spec.PostgreSQL.PgHBA,
+			Storage:            src.Spec.PostgreSQL.Storage,
+			WALArchive:         src.Spec.PostgreSQL.WALArchive,
+			PodSecurityContext: src.Spec.PostgreSQL.PodSecurityContext,
+			SecurityContext:    src.Spec.PostgreSQL.SecurityContext,
+			ServiceAccountName: src.Spec.PostgreSQL.ServiceAccountName,
+			SchedulingSpec:     src.Spec.PostgreSQL.SchedulingSpec,
+			PodExtrasSpec:      src.Spec.PostgreSQL.PodExtrasSpec,
+			MajorUpgrade:       src.Spec.PostgreSQL.MajorUpgrade,
+		},
+		Backup:            src.Spec.PostgreSQL.Backup,
+		RAMD:              src.Spec.RAMD,
+		Networking:        src.Spec.Networking,
+		Monitoring:        src.Spec.Monitoring,
+		Pooler:            src.Spec.Pooler,
+		Failover:          src.Spec.Failover,
+		Switchover:        src.Spec.Switchover,
+		UpdateStrategy:    src.Spec.UpdateStrategy,
+		MaintenanceWindow: src.Spec.MaintenanceWindow,
+		Hibernate:         src.Spec.Hibernate,
+		SelfHeal:          src.Spec.SelfHeal,
+		Credentials:       src.Spec.Credentials,
+		ImagePullSecrets:  src.Spec.ImagePullSecrets,
+		Encryption:        src.Spec.Encryption,
+		Bootstrap:         src.Spec.Bootstrap,
+		Restore:           src.Spec.Restore,
+		DataSource:        src.Spec.DataSource,
+		Standby:           src.Spec.Standby,
+		Teardown:          src.Spec.Teardown,
+		ManagedRoles:      src.Spec.ManagedRoles,
+		ManagedDatabases:  src.Spec.ManagedDatabases,
+	}
+	dst.Status = src.Status
+	return nil
+}
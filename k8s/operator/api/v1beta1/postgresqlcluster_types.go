@@ -0,0 +1,170 @@
+package v1beta1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ramv1 "github.com/pgelephant/pgraft/k8s/operator/api/v1"
+)
+
+// PostgreSQLClusterSpec defines the desired state of PostgreSQLCluster.
+// Unlike v1 it has backups as a section of their own rather than under
+// postgresql; the sections both versions share are the v1 types.
+// +kubebuilder:validation:XValidation:rule="self.replicas % 2 == 1",message="replicas must be odd so that the members can always form a quorum"
+// +kubebuilder:validation:XValidation:rule="[has(self.restore), has(self.dataSource), has(self.standby)].filter(x, x).size() <= 1",message="only one of restore, dataSource and standby may be set"
+type PostgreSQLClusterSpec struct {
+	// Replicas is the number of PostgreSQL replicas in the cluster
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=10
+	// +kubebuilder:default=3
+	Replicas int32 `json:"replicas"`
+
+	// PostgreSQL configuration
+	PostgreSQL PostgreSQLSpec `json:"postgresql"`
+
+	// Backup configuration
+	Backup ramv1.BackupSpec `json:"backup,omitempty"`
+
+	// RAMD daemon configuration
+	RAMD ramv1.RAMDSpec `json:"ramd"`
+
+	// Networking configuration
+	Networking ramv1.NetworkingSpec `json:"networking,omitempty"`
+
+	// Monitoring configuration
+	Monitoring ramv1.MonitoringSpec `json:"monitoring,omitempty"`
+
+	// Connection pooler configuration
+	Pooler ramv1.PoolerSpec `json:"pooler,omitempty"`
+
+	// Failover configuration
+	Failover ramv1.FailoverSpec `json:"failover,omitempty"`
+
+	// Planned switchover to request
+	Switchover *ramv1.SwitchoverSpec `json:"switchover,omitempty"`
+
+	// How the PostgreSQL pods are updated when their template changes
+	UpdateStrategy ramv1.UpdateStrategySpec `json:"updateStrategy,omitempty"`
+
+	// Windows outside which disruptive operations wait
+	MaintenanceWindow *ramv1.MaintenanceWindowSpec `json:"maintenanceWindow,omitempty"`
+
+	// Stop every pod of the cluster, keeping its data, until unset
+	Hibernate bool `json:"hibernate,omitempty"`
+
+	// Revert changes made out of band to the StatefulSet, Services,
+	// ConfigMaps and Secrets the operator generates, and recreate them if
+	// deleted, with an event for each repair; if false they are left as
+	// they are, and reported, until the spec changes
+	// +kubebuilder:default=true
+	SelfHeal *bool `json:"selfHeal,omitempty"`
+
+	// Database credentials
+	Credentials ramv1.CredentialsSpec `json:"credentials,omitempty"`
+
+	// Secrets to pull the images of every pod of the cluster with
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+
+	// Encryption at rest of the data volumes, backups and archived WAL
+	Encryption *ramv1.EncryptionSpec `json:"encryption,omitempty"`
+
+	// How a new cluster that is not created from existing data is
+	// initialized; only set when the cluster is created
+	Bootstrap *ramv1.BootstrapSpec `json:"bootstrap,omitempty"`
+
+	// Backup to bootstrap a new cluster from
+	Restore *ramv1.RestoreSpec `json:"restore,omitempty"`
+
+	// Existing cluster to bootstrap a new cluster as a copy of
+	DataSource *ramv1.DataSourceSpec `json:"dataSource,omitempty"`
+
+	// Primary cluster to follow as a standby
+	Standby *ramv1.StandbySpec `json:"standby,omitempty"`
+
+	// What happens to the cluster's data when it is deleted
+	Teardown ramv1.TeardownSpec `json:"teardown,omitempty"`
+
+	// Database roles to create, keep in line with the spec, or drop
+	// +listType=map
+	// +listMapKey=name
+	ManagedRoles []ramv1.ManagedRoleSpec `json:"managedRoles,omitempty"`
+
+	// Databases to create, keep in line with the spec, or drop
+	// +listType=map
+	// +listMapKey=name
+	ManagedDatabases []ramv1.ManagedDatabaseSpec `json:"managedDatabases,omitempty"`
+}
+
+// PostgreSQLSpec defines PostgreSQL-specific configuration
+type PostgreSQLSpec struct {
+	// Version of PostgreSQL to use
+	// +kubebuilder:default="17"
+	Version string `json:"version,omitempty"`
+
+	// Docker image for PostgreSQL
+	// +kubebuilder:default="postgres:17"
+	Image string `json:"image,omitempty"`
+
+	// Resource requirements
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// PostgreSQL configuration parameters
+	Parameters map[string]string `json:"parameters,omitempty"`
+
+	// Client authentication rules, in order
+	PgHBA []ramv1.PgHBARule `json:"pgHBA,omitempty"`
+
+	// Storage configuration
+	Storage ramv1.StorageSpec `json:"storage,omitempty"`
+
+	// Continuous archiving of the WAL
+	WALArchive *ramv1.WALArchiveSpec `json:"walArchive,omitempty"`
+
+	// Security context of the PostgreSQL pods
+	PodSecurityContext *corev1.PodSecurityContext `json:"podSecurityContext,omitempty"`
+
+	// Security context of the containers of the PostgreSQL pods but ramd
+	SecurityContext *corev1.SecurityContext `json:"securityContext,omitempty"`
+
+	// ServiceAccount the PostgreSQL pods run as
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+
+	// Scheduling of the PostgreSQL pods
+	ramv1.SchedulingSpec `json:",inline"`
+
+	// Containers and volumes to add to the PostgreSQL pods
+	ramv1.PodExtrasSpec `json:",inline"`
+
+	// Image and approvals of an upgrade to a new major version
+	MajorUpgrade ramv1.MajorUpgradeSpec `json:"majorUpgrade,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase"
+//+kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.readyReplicas"
+//+kubebuilder:printcolumn:name="Total",type="integer",JSONPath=".status.totalReplicas"
+//+kubebuilder:printcolumn:name="Leader",type="string",JSONPath=".status.leader"
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// PostgreSQLCluster is the Schema for the postgresqlclusters API
+type PostgreSQLCluster struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PostgreSQLClusterSpec         `json:"spec,omitempty"`
+	Status ramv1.PostgreSQLClusterStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// PostgreSQLClusterList contains a list of PostgreSQLCluster
+type PostgreSQLClusterList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []PostgreSQLCluster `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&PostgreSQLCluster{}, &PostgreSQLClusterList{})
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	ramv1 "github.com/pgelephant/pgraft/k8s/operator/api/v1"
+	"github.com/pgelephant/pgraft/k8s/operator/controllers"
+)
+
+// waitInterval is how often a command waiting for the operator looks at
+// the cluster
+const waitInterval = 2 * time.Second
+
+func newSwitchoverCommand(p *plugin) *cobra.Command {
+	var force bool
+	var timeout time.Duration
+	cmd := &cobra.Command{
+		Use:   "switchover CLUSTER POD",
+		Short: "Move the leadership of a cluster to one of its pods",
+		Long: "Requests a switchover through spec.switchover and, unless --timeout is 0, waits for the operator " +
+			"to complete it.",
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return p.switchover(cmd.Context(), args[0], args[1], force, timeout)
+		},
+	}
+	cmd.Flags().BoolVar(&force, "force", false, "Switch over even if RAMD reports the target unhealthy")
+	cmd.Flags().DurationVar(&timeout, "timeout", 3*time.Minute, "How long to wait for the switchover; 0 not to wait")
+	return cmd
+}
+
+// switchover sets spec.switchover to make target the leader and waits up
+// to timeout for the operator to report the outcome
+func (p *plugin) switchover(ctx context.Context, name, target string, force bool, timeout time.Duration) error {
+	cluster, err := p.cluster(ctx, name)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(target, cluster.Name+"-postgresql-") {
+		return fmt.Errorf("%s is not a PostgreSQL pod of %s", target, cluster.Name)
+	}
+	if target == cluster.Status.Leader {
+		fmt.Fprintf(p.streams.Out, "%s is already the leader\n", target)
+		return nil
+	}
+
+	patch := client.MergeFrom(cluster.DeepCopy())
+	cluster.Spec.Switchover = &ramv1.SwitchoverSpec{
+		TargetPod:   target,
+		RequestedAt: metav1.Now(),
+		Force:       force,
+	}
+	if err := p.client.Patch(ctx, cluster, patch); err != nil {
+		return err
+	}
+	// As stored, to the second
+	requestedAt := cluster.Spec.Switchover.RequestedAt
+	fmt.Fprintf(p.streams.Out, "requested a switchover of %s to %s\n", cluster.Name, target)
+	if timeout == 0 {
+		return nil
+	}
+
+	// The operator records the request it carries out with its time
+	var switchover *ramv1.SwitchoverStatus
+	err = wait.PollUntilContextTimeout(ctx, waitInterval, timeout, false, func(ctx context.Context) (bool, error) {
+		current := &ramv1.PostgreSQLCluster{}
+		if err := p.client.Get(ctx, types.NamespacedName{Name: cluster.Name, Namespace: cluster.Namespace}, current); err != nil {
+			return false, err
+		}
+		switchover = current.Status.Switchover
+		return switchover != nil && switchover.RequestedAt.Equal(&requestedAt) &&
+			(switchover.Phase == controllers.SwitchoverCompleted || switchover.Phase == controllers.SwitchoverFailed), nil
+	})
+	if err != nil {
+		return fmt.Errorf("waiting for the switchover: %w", err)
+	}
+	if switchover.Phase == controllers.SwitchoverFailed {
+		return fmt.Errorf("switchover failed: %s", switchover.Message)
+	}
+	fmt.Fprintf(p.streams.Out, "%s is the leader\n", target)
+	return nil
+}
+
+func newRestartCommand(p *plugin) *cobra.Command {
+	return &cobra.Command{
+		Use:   "restart CLUSTER",
+		Short: "Restart the PostgreSQL pods of a cluster",
+		Long: "Restarts the PostgreSQL pods as an update with spec.updateStrategy: with the Switchover strategy " +
+			"the replicas restart first and the leader last, after a switchover.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return p.restart(cmd.Context(), args[0])
+		},
+	}
+}
+
+// restart stamps the cluster with the restartedAt annotation, which the
+// operator copies to the pod template of the StatefulSet
+func (p *plugin) restart(ctx context.Context, name string) error {
+	cluster, err := p.cluster(ctx, name)
+	if err != nil {
+		return err
+	}
+	patch := client.MergeFrom(cluster.DeepCopy())
+	if cluster.Annotations == nil {
+		cluster.Annotations = make(map[string]string)
+	}
+	cluster.Annotations[controllers.RestartedAtAnnotation] = time.Now().UTC().Format(time.RFC3339)
+	if err := p.client.Patch(ctx, cluster, patch); err != nil {
+		return err
+	}
+	fmt.Fprintf(p.streams.Out, "requested a restart of %s\n", cluster.Name)
+	return nil
+}
+
+func newBackupCommand(p *plugin) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "backup",
+		Short: "Manage the backups of a cluster",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "now CLUSTER",
+		Short: "Take a backup now, outside the schedule",
+		Long: "Starts a Job from the cluster's backup CronJob, as kubectl create job --from would; the operator " +
+			"reports its outcome in status.backup like a scheduled backup's.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return p.backupNow(cmd.Context(), args[0])
+		},
+	})
+	return cmd
+}
+
+// backupNow creates a Job from the backup CronJob of the cluster
+func (p *plugin) backupNow(ctx context.Context, name string) error {
+	cluster, err := p.cluster(ctx, name)
+	if err != nil {
+		return err
+	}
+	cronJob := &batchv1.CronJob{}
+	if err := p.client.Get(ctx, types.NamespacedName{Name: cluster.Name + "-backup", Namespace: cluster.Namespace}, cronJob); err != nil {
+		return fmt.Errorf("backup CronJob of %s, which needs spec.postgresql.backup enabled: %w", cluster.Name, err)
+	}
+
+	template := cronJob.Spec.JobTemplate
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        fmt.Sprintf("%s-manual-%d", cronJob.Name, time.Now().Unix()),
+			Namespace:   cronJob.Namespace,
+			Labels:      template.Labels,
+			Annotations: map[string]string{"cronjob.kubernetes.io/instantiate": "manual"},
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(cronJob, batchv1.SchemeGroupVersion.WithKind("CronJob")),
+			},
+		},
+		Spec: template.Spec,
+	}
+	for key, value := range template.Annotations {
+		job.Annotations[key] = value
+	}
+	if err := p.client.Create(ctx, job); err != nil {
+		return err
+	}
+	fmt.Fprintf(p.streams.Out, "started backup %s\n", job.Name)
+	return nil
+}
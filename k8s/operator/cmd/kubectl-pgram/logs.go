@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/pgelephant/pgraft/k8s/operator/controllers"
+)
+
+// logsOptions are the flags of the logs command
+type logsOptions struct {
+	container string
+	ramd      bool
+	follow    bool
+	previous  bool
+	tail      int64
+}
+
+func newLogsCommand(p *plugin) *cobra.Command {
+	options := &logsOptions{}
+	cmd := &cobra.Command{
+		Use:   "logs CLUSTER [POD]",
+		Short: "Print the logs of a cluster's leader, or of one of its pods",
+		Long: "Prints the PostgreSQL logs of POD, or of the leader if POD is omitted.  With --ramd, prints RAMD's " +
+			"logs instead: those of the pod's ramd sidecar, or of the RAMD Deployment's pod.",
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			pod := ""
+			if len(args) == 2 {
+				pod = args[1]
+			}
+			return p.logs(cmd.Context(), args[0], pod, options)
+		},
+	}
+	cmd.Flags().StringVarP(&options.container, "container", "c", "", "Container to print the logs of")
+	cmd.Flags().BoolVar(&options.ramd, "ramd", false, "Print the logs of RAMD")
+	cmd.Flags().BoolVarP(&options.follow, "follow", "f", false, "Stream the logs")
+	cmd.Flags().BoolVarP(&options.previous, "previous", "p", false, "Print the logs of the previous instance of the container")
+	cmd.Flags().Int64Var(&options.tail, "tail", -1, "Lines of recent log to print; all if negative")
+	return cmd
+}
+
+// logs copies the logs of a container of the cluster to the output
+func (p *plugin) logs(ctx context.Context, name, pod string, options *logsOptions) error {
+	cluster, err := p.cluster(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	container := options.container
+	switch {
+	case options.ramd && cluster.Spec.RAMD.Mode == controllers.RAMDModeDeployment && pod == "":
+		// RAMD runs apart from PostgreSQL, in a single pod
+		pods := &corev1.PodList{}
+		if err := p.client.List(ctx, pods, client.InNamespace(cluster.Namespace), client.MatchingLabels{
+			"app":       "postgresql-cluster",
+			"cluster":   cluster.Name,
+			"component": "ramd",
+		}); err != nil {
+			return err
+		}
+		if len(pods.Items) == 0 {
+			return fmt.Errorf("%s has no RAMD pod", cluster.Name)
+		}
+		pod = pods.Items[0].Name
+		container = "ramd"
+	case pod == "":
+		if cluster.Status.Leader == "" {
+			return fmt.Errorf("%s has no leader; name the pod", cluster.Name)
+		}
+		pod = cluster.Status.Leader
+	}
+	if container == "" {
+		container = "postgresql"
+		if options.ramd {
+			container = "ramd"
+		}
+	}
+	if !strings.HasPrefix(pod, cluster.Name+"-") {
+		return fmt.Errorf("%s is not a pod of %s", pod, cluster.Name)
+	}
+
+	logOptions := &corev1.PodLogOptions{
+		Container: container,
+		Follow:    options.follow,
+		Previous:  options.previous,
+	}
+	if options.tail >= 0 {
+		logOptions.TailLines = &options.tail
+	}
+	stream, err := p.clientset.CoreV1().Pods(cluster.Namespace).GetLogs(pod, logOptions).Stream(ctx)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	_, err = io.Copy(p.streams.Out, stream)
+	return err
+}
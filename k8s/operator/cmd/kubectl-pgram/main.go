@@ -0,0 +1,103 @@
+// kubectl-pgram manages PostgreSQLClusters from kubectl: it shows their
+// state as the operator and RAMD see it, and requests switchovers,
+// restarts and backups through the custom resource, so that nobody has to
+// write patches by hand or port-forward to RAMD.
+//
+// Installed on the PATH, or with krew, it runs as kubectl pgram.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	_ "k8s.io/client-go/plugin/pkg/client/auth"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	ramv1 "github.com/pgelephant/pgraft/k8s/operator/api/v1"
+)
+
+// plugin holds the connection to the cluster shared by the commands
+type plugin struct {
+	flags     *genericclioptions.ConfigFlags
+	streams   genericclioptions.IOStreams
+	namespace string
+
+	// client reads and patches PostgreSQLClusters and the objects they own
+	client client.Client
+
+	// clientset streams logs and proxies requests to RAMD
+	clientset kubernetes.Interface
+}
+
+// connect builds the clients from the kubeconfig flags
+func (p *plugin) connect() error {
+	config, err := p.flags.ToRESTConfig()
+	if err != nil {
+		return err
+	}
+	p.namespace, _, err = p.flags.ToRawKubeConfigLoader().Namespace()
+	if err != nil {
+		return err
+	}
+
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		return err
+	}
+	if err := ramv1.AddToScheme(scheme); err != nil {
+		return err
+	}
+	if p.client, err = client.New(config, client.Options{Scheme: scheme}); err != nil {
+		return err
+	}
+	p.clientset, err = kubernetes.NewForConfig(config)
+	return err
+}
+
+// cluster gets the PostgreSQLCluster name in the current namespace, with
+// the defaults the operator applies
+func (p *plugin) cluster(ctx context.Context, name string) (*ramv1.PostgreSQLCluster, error) {
+	cluster := &ramv1.PostgreSQLCluster{}
+	if err := p.client.Get(ctx, types.NamespacedName{Name: name, Namespace: p.namespace}, cluster); err != nil {
+		return nil, err
+	}
+	cluster.Default()
+	return cluster, nil
+}
+
+func main() {
+	p := &plugin{
+		flags:   genericclioptions.NewConfigFlags(true),
+		streams: genericclioptions.IOStreams{In: os.Stdin, Out: os.Stdout, ErrOut: os.Stderr},
+	}
+
+	root := &cobra.Command{
+		Use:           "kubectl-pgram",
+		Short:         "Manage PostgreSQLClusters run by the RAM operator",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return p.connect()
+		},
+	}
+	p.flags.AddFlags(root.PersistentFlags())
+	root.AddCommand(
+		newStatusCommand(p),
+		newSwitchoverCommand(p),
+		newRestartCommand(p),
+		newBackupCommand(p),
+		newLogsCommand(p),
+	)
+
+	if err := root.Execute(); err != nil {
+		fmt.Fprintln(p.streams.ErrOut, "error:", err)
+		os.Exit(1)
+	}
+}
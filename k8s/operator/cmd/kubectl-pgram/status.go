@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	ramv1 "github.com/pgelephant/pgraft/k8s/operator/api/v1"
+	"github.com/pgelephant/pgraft/k8s/operator/controllers"
+)
+
+// ramdStatus is the part of RAMD's GET /api/v1/cluster/status shown
+type ramdStatus struct {
+	Status        string `json:"status"`
+	PrimaryNodeID int32  `json:"primary_node_id"`
+	HasQuorum     bool   `json:"has_quorum"`
+	HealthyNodes  int32  `json:"healthy_nodes"`
+	FailoverState string `json:"failover_state"`
+}
+
+// ramdNodes is the part of RAMD's GET /api/v1/nodes shown
+type ramdNodes struct {
+	Data struct {
+		Nodes []struct {
+			NodeID    int32  `json:"node_id"`
+			Hostname  string `json:"hostname"`
+			Role      string `json:"role"`
+			State     string `json:"state"`
+			IsHealthy bool   `json:"is_healthy"`
+		} `json:"nodes"`
+	} `json:"data"`
+}
+
+func newStatusCommand(p *plugin) *cobra.Command {
+	return &cobra.Command{
+		Use:   "status CLUSTER",
+		Short: "Show a cluster as the operator and RAMD see it",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return p.status(cmd.Context(), args[0])
+		},
+	}
+}
+
+// status prints the cluster's status, its pods and RAMD's view of raft
+func (p *plugin) status(ctx context.Context, name string) error {
+	cluster, err := p.cluster(ctx, name)
+	if err != nil {
+		return err
+	}
+	status := &cluster.Status
+	out := tabwriter.NewWriter(p.streams.Out, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(out, "Cluster:\t%s/%s\n", cluster.Namespace, cluster.Name)
+	fmt.Fprintf(out, "Phase:\t%s\n", orNone(status.Phase))
+	fmt.Fprintf(out, "PostgreSQL:\t%s\n", orNone(status.PostgreSQLVersion))
+	fmt.Fprintf(out, "Leader:\t%s\n", orNone(status.Leader))
+	fmt.Fprintf(out, "Ready:\t%d/%d\n", status.ReadyReplicas, cluster.Spec.Replicas)
+	operation := "none"
+	if progressing := meta.FindStatusCondition(status.Conditions, controllers.ConditionProgressing); progressing != nil &&
+		progressing.Status == metav1.ConditionTrue {
+		operation = fmt.Sprintf("%s: %s", progressing.Reason, progressing.Message)
+	}
+	fmt.Fprintf(out, "Operation:\t%s\n", operation)
+	if backup := status.Backup; backup != nil {
+		last := "never succeeded"
+		if backup.LastSuccessfulTime != nil {
+			last = "last succeeded " + age(backup.LastSuccessfulTime.Time) + " ago"
+		}
+		fmt.Fprintf(out, "Backup:\t%s, %s is %s\n", last, orNone(backup.LastJob), orNone(backup.LastResult))
+	}
+
+	fmt.Fprintln(out, "\nCONDITION\tSTATUS\tREASON\tMESSAGE")
+	for _, condition := range status.Conditions {
+		fmt.Fprintf(out, "%s\t%s\t%s\t%s\n", condition.Type, condition.Status, condition.Reason, condition.Message)
+	}
+
+	pods := &corev1.PodList{}
+	if err := p.client.List(ctx, pods, client.InNamespace(cluster.Namespace), client.MatchingLabels{
+		"app":       "postgresql-cluster",
+		"cluster":   cluster.Name,
+		"component": "postgresql",
+	}); err != nil {
+		return err
+	}
+	fmt.Fprintln(out, "\nPOD\tROLE\tREADY\tRESTARTS\tNODE\tAGE")
+	for _, pod := range pods.Items {
+		ready, restarts := 0, int32(0)
+		for _, container := range pod.Status.ContainerStatuses {
+			if container.Ready {
+				ready++
+			}
+			restarts += container.RestartCount
+		}
+		fmt.Fprintf(out, "%s\t%s\t%d/%d\t%d\t%s\t%s\n", pod.Name, orNone(pod.Labels[controllers.RoleLabel]),
+			ready, len(pod.Spec.Containers), restarts, orNone(pod.Spec.NodeName), age(pod.CreationTimestamp.Time))
+	}
+
+	p.ramdStatus(ctx, cluster, out)
+	return out.Flush()
+}
+
+// ramdStatus prints raft as RAMD reports it, or why RAMD cannot be asked
+func (p *plugin) ramdStatus(ctx context.Context, cluster *ramv1.PostgreSQLCluster, out io.Writer) {
+	var status ramdStatus
+	if err := p.ramd(ctx, cluster, "/api/v1/cluster/status", &status); err != nil {
+		fmt.Fprintf(out, "\nRAMD:\tunavailable: %v\n", err)
+		return
+	}
+	fmt.Fprintf(out, "\nRAMD:\t%s, quorum %t, %d healthy, failover %s\n",
+		status.Status, status.HasQuorum, status.HealthyNodes, status.FailoverState)
+
+	var nodes ramdNodes
+	if err := p.ramd(ctx, cluster, "/api/v1/nodes", &nodes); err != nil {
+		fmt.Fprintf(out, "Nodes:\tunavailable: %v\n", err)
+		return
+	}
+	fmt.Fprintln(out, "\nNODE\tHOSTNAME\tROLE\tSTATE\tHEALTHY")
+	for _, node := range nodes.Data.Nodes {
+		fmt.Fprintf(out, "%d\t%s\t%s\t%s\t%t\n", node.NodeID, node.Hostname, node.Role, node.State, node.IsHealthy)
+	}
+}
+
+// ramd sends a GET for path to the cluster's RAMD Service through the API
+// server's proxy, so that no port-forward is needed, and decodes the reply
+// into out
+func (p *plugin) ramd(ctx context.Context, cluster *ramv1.PostgreSQLCluster, path string, out interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	data, err := p.clientset.CoreV1().Services(cluster.Namespace).
+		ProxyGet("http", cluster.Name+"-ramd", fmt.Sprint(cluster.Spec.Networking.Ports.RAMD), path, nil).
+		DoRaw(ctx)
+	if err != nil {
+		return fmt.Errorf("GET %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("GET %s: %w", path, err)
+	}
+	return nil
+}
+
+// orNone returns s, or "<none>" as kubectl prints an empty value
+func orNone(s string) string {
+	if strings.TrimSpace(s) == "" {
+		return "<none>"
+	}
+	return s
+}
+
+// age returns the time since t the way kubectl prints ages
+func age(t time.Time) string {
+	return duration.HumanDuration(time.Since(t))
+}
@@ -0,0 +1,473 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	ramv1 "github.com/pgelephant/pgraft/k8s/operator/api/v1"
+)
+
+const (
+	// walgDir is where the PostgreSQL pods get the wal-g binary
+	walgDir = "/opt/wal-g"
+
+	// walgCredentialsDir is where the repository credentials are mounted
+	walgCredentialsDir = "/etc/wal-g"
+
+	// walgKeyDir is where the key of an encrypted repository is mounted,
+	// and standbyKeyDir where a standby has the key of its primary's
+	walgKeyDir    = "/etc/wal-g-key"
+	standbyKeyDir = "/etc/wal-g-standby-key"
+
+	// walgKMSDir is where the credentials of spec.encryption.kms are mounted
+	walgKMSDir = "/etc/wal-g-kms"
+
+	// backupKubectlImage runs the backup Jobs, which exec wal-g in a pod
+	backupKubectlImage = "bitnami/kubectl:latest"
+)
+
+// Results of a backup Job
+const (
+	BackupRunning   = "Running"
+	BackupSucceeded = "Succeeded"
+	BackupFailed    = "Failed"
+)
+
+// Methods of ArchiveEncryptionSpec, with the keys of their Secrets
+const (
+	EncryptionLibsodium = "libsodium"
+	EncryptionPGP       = "pgp"
+
+	libsodiumKeyKey = "libsodium-key"
+	pgpKeyKey       = "pgp-key"
+)
+
+// backupConfigured reports whether backups are enabled and have somewhere to go
+func backupConfigured(cluster *ramv1.PostgreSQLCluster) bool {
+	return cluster.Spec.PostgreSQL.Backup.Enabled && archiveRepository(cluster) != nil
+}
+
+// archiveRepository returns the repository the WAL and base backups go
+// to: that of spec.postgresql.walArchive, or else the backup repository
+func archiveRepository(cluster *ramv1.PostgreSQLCluster) *ramv1.BackupRepositorySpec {
+	if archive := cluster.Spec.PostgreSQL.WALArchive; archive != nil && archive.Repository != nil {
+		return archive.Repository
+	}
+	return cluster.Spec.PostgreSQL.Backup.Repository
+}
+
+// archiveEncryption returns the key the cluster's archive is encrypted
+// with, or nil
+func archiveEncryption(cluster *ramv1.PostgreSQLCluster) *ramv1.ArchiveEncryptionSpec {
+	if archive := cluster.Spec.PostgreSQL.WALArchive; archive != nil {
+		return archive.Encryption
+	}
+	return nil
+}
+
+// walArchiveConfigured reports whether the cluster archives its WAL: if
+// spec.postgresql.walArchive is set, or along with backups
+func walArchiveConfigured(cluster *ramv1.PostgreSQLCluster) bool {
+	return archiveRepository(cluster) != nil && (cluster.Spec.PostgreSQL.WALArchive != nil || backupConfigured(cluster))
+}
+
+// backupName returns the name of the backup CronJob and of its ServiceAccount and Role
+func backupName(cluster *ramv1.PostgreSQLCluster) string {
+	return cluster.Name + "-backup"
+}
+
+// backupLabels returns the labels of the backup objects and Jobs
+func backupLabels(cluster *ramv1.PostgreSQLCluster) map[string]string {
+	return map[string]string{
+		"app":       "postgresql-cluster",
+		"cluster":   cluster.Name,
+		"component": "backup",
+	}
+}
+
+// walgEnv returns the environment pointing wal-g at repository and at the
+// local PostgreSQL
+func walgEnv(cluster *ramv1.PostgreSQLCluster, repository *ramv1.BackupRepositorySpec) []corev1.EnvVar {
+	path := repository.Path
+	if path == "" {
+		path = cluster.Namespace + "/" + cluster.Name
+	}
+
+	env := []corev1.EnvVar{
+		{Name: "PGHOST", Value: "/var/run/postgresql"},
+		{Name: "PGUSER", Value: "postgres"},
+	}
+	switch repository.Type {
+	case "gcs":
+		env = append(env, corev1.EnvVar{Name: "WALG_GS_PREFIX", Value: fmt.Sprintf("gs://%s/%s", repository.Bucket, path)})
+	case "azure":
+		env = append(env, corev1.EnvVar{Name: "WALG_AZ_PREFIX", Value: fmt.Sprintf("azure://%s/%s", repository.Bucket, path)})
+	default:
+		env = append(env, corev1.EnvVar{Name: "WALG_S3_PREFIX", Value: fmt.Sprintf("s3://%s/%s", repository.Bucket, path)})
+		if repository.Region != "" {
+			env = append(env, corev1.EnvVar{Name: "AWS_REGION", Value: repository.Region})
+		}
+		if repository.Endpoint != "" {
+			env = append(env,
+				corev1.EnvVar{Name: "AWS_ENDPOINT", Value: repository.Endpoint},
+				corev1.EnvVar{Name: "AWS_S3_FORCE_PATH_STYLE", Value: "true"})
+		}
+	}
+	return env
+}
+
+// walgEncryptionEnv returns the environment having wal-g encrypt and
+// decrypt with the key of encryption, mounted in dir; none if nil.  The
+// key is passed by path so that the environment can be inlined in a
+// restore_command.
+func walgEncryptionEnv(encryption *ramv1.ArchiveEncryptionSpec, dir string) []corev1.EnvVar {
+	if encryption == nil {
+		return nil
+	}
+	if encryption.Method == EncryptionPGP {
+		return []corev1.EnvVar{{Name: "WALG_PGP_KEY_PATH", Value: dir + "/" + pgpKeyKey}}
+	}
+	return []corev1.EnvVar{
+		{Name: "WALG_LIBSODIUM_KEY_PATH", Value: dir + "/" + libsodiumKeyKey},
+		{Name: "WALG_LIBSODIUM_KEY_TRANSFORM", Value: "hex"},
+	}
+}
+
+// addWALGKey mounts the key Secret of encryption in container at dir, as
+// the volume named volume; nothing if encryption is nil
+func addWALGKey(spec *corev1.PodSpec, container *corev1.Container, encryption *ramv1.ArchiveEncryptionSpec, volume, dir string) {
+	if encryption == nil {
+		return
+	}
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      volume,
+		MountPath: dir,
+		ReadOnly:  true,
+	})
+	spec.Volumes = append(spec.Volumes, corev1.Volume{
+		Name: volume,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{SecretName: encryption.KeySecret},
+		},
+	})
+}
+
+// addWALGKMS has wal-g in container ask S3 to encrypt what it uploads with
+// the key of spec.encryption.kms, with the key's credentials over the
+// repository's; nothing without one
+func addWALGKMS(cluster *ramv1.PostgreSQLCluster, spec *corev1.PodSpec, container *corev1.Container) {
+	if cluster.Spec.Encryption == nil || cluster.Spec.Encryption.KMS == nil {
+		return
+	}
+	kms := cluster.Spec.Encryption.KMS
+	container.Env = append(container.Env,
+		corev1.EnvVar{Name: "S3_SSE", Value: "aws:kms"},
+		corev1.EnvVar{Name: "S3_SSE_KMS_ID", Value: kms.KeyID})
+	if kms.CredentialsSecret == "" {
+		return
+	}
+	container.EnvFrom = append(container.EnvFrom, corev1.EnvFromSource{
+		SecretRef: &corev1.SecretEnvSource{
+			LocalObjectReference: corev1.LocalObjectReference{Name: kms.CredentialsSecret},
+		},
+	})
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      "wal-g-kms",
+		MountPath: walgKMSDir,
+		ReadOnly:  true,
+	})
+	spec.Volumes = append(spec.Volumes, corev1.Volume{
+		Name: "wal-g-kms",
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{SecretName: kms.CredentialsSecret},
+		},
+	})
+}
+
+// addWALG gives the pods wal-g, copied by an init container into a volume
+// mounted at walgDir
+func addWALG(cluster *ramv1.PostgreSQLCluster, spec *corev1.PodSpec) {
+	for _, container := range spec.InitContainers {
+		if container.Name == "wal-g" {
+			return
+		}
+	}
+	spec.InitContainers = append(spec.InitContainers, corev1.Container{
+		Name:    "wal-g",
+		Image:   cluster.Spec.PostgreSQL.Backup.Image,
+		Command: []string{"cp", "/usr/local/bin/wal-g", walgDir + "/wal-g"},
+		VolumeMounts: []corev1.VolumeMount{
+			{Name: "wal-g", MountPath: walgDir},
+		},
+	})
+	spec.Volumes = append(spec.Volumes, corev1.Volume{
+		Name:         "wal-g",
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	})
+}
+
+// addWALArchiving has PostgreSQL archive its WAL to the repository with
+// wal-g, so the base backups can be restored to any point since.  The
+// backup Jobs run wal-g in the postgresql container, so the base backups
+// are compressed and encrypted as the WAL is.
+func addWALArchiving(cluster *ramv1.PostgreSQLCluster, spec *corev1.PodSpec) {
+	repository := archiveRepository(cluster)
+	encryption := archiveEncryption(cluster)
+	addWALG(cluster, spec)
+
+	postgresql := &spec.Containers[0]
+	postgresql.Args = append(postgresql.Args,
+		"-c", "archive_mode=on",
+		"-c", fmt.Sprintf("archive_command=%s/wal-g wal-push %%p", walgDir))
+	postgresql.Env = append(postgresql.Env, walgEnv(cluster, repository)...)
+	if archive := cluster.Spec.PostgreSQL.WALArchive; archive != nil && archive.Compression != "" {
+		postgresql.Env = append(postgresql.Env, corev1.EnvVar{Name: "WALG_COMPRESSION_METHOD", Value: archive.Compression})
+	}
+	postgresql.Env = append(postgresql.Env, walgEncryptionEnv(encryption, walgKeyDir)...)
+	postgresql.VolumeMounts = append(postgresql.VolumeMounts, corev1.VolumeMount{Name: "wal-g", MountPath: walgDir})
+	addWALGKey(spec, postgresql, encryption, "wal-g-key", walgKeyDir)
+
+	if repository.CredentialsSecret != "" {
+		postgresql.EnvFrom = append(postgresql.EnvFrom, corev1.EnvFromSource{
+			SecretRef: &corev1.SecretEnvSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: repository.CredentialsSecret},
+			},
+		})
+		postgresql.VolumeMounts = append(postgresql.VolumeMounts, corev1.VolumeMount{
+			Name:      "wal-g-credentials",
+			MountPath: walgCredentialsDir,
+			ReadOnly:  true,
+		})
+		spec.Volumes = append(spec.Volumes, corev1.Volume{
+			Name: "wal-g-credentials",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: repository.CredentialsSecret},
+			},
+		})
+	}
+	addWALGKMS(cluster, spec, postgresql)
+}
+
+// backupScript returns the script of the backup Jobs: it picks a replica,
+// or the primary if there is none, runs wal-g backup-push in it, and then
+// deletes the backups older than the retention together with their WAL
+func backupScript(cluster *ramv1.PostgreSQLCluster) string {
+	selector := fmt.Sprintf("app=postgresql-cluster,cluster=%s,component=postgresql", cluster.Name)
+	return fmt.Sprintf(`set -e
+pod=$(kubectl get pods -l %[1]s,%[2]s=%[3]s -o jsonpath='{.items[0].metadata.name}' 2>/dev/null || true)
+if [ -z "$pod" ]; then
+  pod=$(kubectl get pods -l %[1]s,%[2]s=%[4]s -o jsonpath='{.items[0].metadata.name}')
+fi
+echo "backing up from $pod"
+kubectl exec "$pod" -c postgresql -- sh -c '%[5]s/wal-g backup-push "$PGDATA" && %[5]s/wal-g delete before FIND_FULL "$(date -u -d "-%[6]d days" +%%Y-%%m-%%dT%%H:%%M:%%SZ)" --confirm'
+`, selector, RoleLabel, RoleReplica, RolePrimary, walgDir, cluster.Spec.PostgreSQL.Backup.Retention)
+}
+
+// reconcileBackup runs base backups on spec.postgresql.backup.schedule
+// through a CronJob, whose Jobs exec wal-g in a PostgreSQL pod and may do
+// nothing else, and records their outcome in Status.Backup.  Without a
+// repository, or with backups disabled, the CronJob is removed.
+func (r *PostgreSQLClusterReconciler) reconcileBackup(ctx context.Context, cluster *ramv1.PostgreSQLCluster) error {
+	before := cluster.Status.DeepCopy()
+
+	if !backupConfigured(cluster) {
+		cronJob := &batchv1.CronJob{ObjectMeta: metav1.ObjectMeta{Name: backupName(cluster), Namespace: cluster.Namespace}}
+		if err := r.Delete(ctx, cronJob); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		cluster.Status.Backup = nil
+		if err := r.reconcileBackupVerification(ctx, cluster, before.Backup); err != nil {
+			return err
+		}
+	} else {
+		if err := r.reconcileBackupRBAC(ctx, cluster); err != nil {
+			return err
+		}
+		cronJob, err := r.reconcileBackupCronJob(ctx, cluster)
+		if err != nil {
+			return err
+		}
+		if err := r.updateBackupStatus(ctx, cluster, cronJob); err != nil {
+			return err
+		}
+		r.backupEvent(cluster, before.Backup)
+		if err := r.reconcileBackupVerification(ctx, cluster, before.Backup); err != nil {
+			return err
+		}
+	}
+	setBackupCondition(cluster)
+	setBackupVerifiedCondition(cluster)
+
+	if equality.Semantic.DeepEqual(before, &cluster.Status) {
+		return nil
+	}
+	return r.Status().Update(ctx, cluster)
+}
+
+// reconcileBackupRBAC creates the ServiceAccount of the backup Jobs and
+// lets it exec into the cluster's pods
+func (r *PostgreSQLClusterReconciler) reconcileBackupRBAC(ctx context.Context, cluster *ramv1.PostgreSQLCluster) error {
+	meta := metav1.ObjectMeta{Name: backupName(cluster), Namespace: cluster.Namespace}
+
+	serviceAccount := &corev1.ServiceAccount{ObjectMeta: meta}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, serviceAccount, func() error {
+		serviceAccount.Labels = backupLabels(cluster)
+		return controllerutil.SetControllerReference(cluster, serviceAccount, r.Scheme)
+	}); err != nil {
+		return err
+	}
+
+	role := &rbacv1.Role{ObjectMeta: meta}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, role, func() error {
+		role.Labels = backupLabels(cluster)
+		role.Rules = []rbacv1.PolicyRule{
+			{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get", "list"}},
+			{APIGroups: []string{""}, Resources: []string{"pods/exec"}, Verbs: []string{"create"}},
+		}
+		return controllerutil.SetControllerReference(cluster, role, r.Scheme)
+	}); err != nil {
+		return err
+	}
+
+	binding := &rbacv1.RoleBinding{ObjectMeta: meta}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, binding, func() error {
+		binding.Labels = backupLabels(cluster)
+		binding.RoleRef = rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: role.Name}
+		binding.Subjects = []rbacv1.Subject{
+			{Kind: rbacv1.ServiceAccountKind, Name: serviceAccount.Name, Namespace: cluster.Namespace},
+		}
+		return controllerutil.SetControllerReference(cluster, binding, r.Scheme)
+	})
+	return err
+}
+
+// reconcileBackupCronJob creates or updates the backup CronJob
+func (r *PostgreSQLClusterReconciler) reconcileBackupCronJob(ctx context.Context, cluster *ramv1.PostgreSQLCluster) (*batchv1.CronJob, error) {
+	cronJob := &batchv1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      backupName(cluster),
+			Namespace: cluster.Namespace,
+		},
+	}
+
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, cronJob, func() error {
+		cronJob.Labels = backupLabels(cluster)
+
+		history := int32(3)
+		suspend := hibernationInProgress(cluster)
+		cronJob.Spec.Schedule = cluster.Spec.PostgreSQL.Backup.Schedule
+		cronJob.Spec.Suspend = &suspend
+		cronJob.Spec.ConcurrencyPolicy = batchv1.ForbidConcurrent
+		cronJob.Spec.SuccessfulJobsHistoryLimit = &history
+		cronJob.Spec.FailedJobsHistoryLimit = &history
+		cronJob.Spec.JobTemplate = batchv1.JobTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{Labels: backupLabels(cluster)},
+			Spec:       backupJobSpec(cluster),
+		}
+
+		return controllerutil.SetControllerReference(cluster, cronJob, r.Scheme)
+	})
+
+	return cronJob, err
+}
+
+// backupJobSpec returns the spec of a Job taking one backup
+func backupJobSpec(cluster *ramv1.PostgreSQLCluster) batchv1.JobSpec {
+	backoffLimit := int32(1)
+	return batchv1.JobSpec{
+		BackoffLimit: &backoffLimit,
+		Template: corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{Labels: backupLabels(cluster)},
+			Spec: corev1.PodSpec{
+				ServiceAccountName: backupName(cluster),
+				ImagePullSecrets:   cluster.Spec.ImagePullSecrets,
+				RestartPolicy:      corev1.RestartPolicyNever,
+				Containers: []corev1.Container{
+					{
+						Name:    "backup",
+						Image:   backupKubectlImage,
+						Command: []string{"/bin/sh", "-c", backupScript(cluster)},
+					},
+				},
+			},
+		},
+	}
+}
+
+// updateBackupStatus sets Status.Backup from the CronJob and its newest Job
+func (r *PostgreSQLClusterReconciler) updateBackupStatus(ctx context.Context, cluster *ramv1.PostgreSQLCluster, cronJob *batchv1.CronJob) error {
+	status := &ramv1.BackupStatus{
+		LastScheduleTime:   cronJob.Status.LastScheduleTime,
+		LastSuccessfulTime: cronJob.Status.LastSuccessfulTime,
+	}
+
+	last, err := r.newestJob(ctx, cluster, backupLabels(cluster))
+	if err != nil {
+		return err
+	}
+	if last != nil {
+		status.LastJob = last.Name
+		status.LastResult = jobResult(last)
+	}
+
+	cluster.Status.Backup = status
+	return nil
+}
+
+// newestJob returns the newest of the cluster's Jobs with labels, or nil
+func (r *PostgreSQLClusterReconciler) newestJob(ctx context.Context, cluster *ramv1.PostgreSQLCluster, labels map[string]string) (*batchv1.Job, error) {
+	jobs := &batchv1.JobList{}
+	if err := r.List(ctx, jobs, client.InNamespace(cluster.Namespace), client.MatchingLabels(labels)); err != nil {
+		return nil, err
+	}
+	var last *batchv1.Job
+	for i := range jobs.Items {
+		if last == nil || last.CreationTimestamp.Before(&jobs.Items[i].CreationTimestamp) {
+			last = &jobs.Items[i]
+		}
+	}
+	return last, nil
+}
+
+// jobResult returns whether job is running, has succeeded or has failed
+func jobResult(job *batchv1.Job) string {
+	for _, c := range job.Status.Conditions {
+		if c.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch c.Type {
+		case batchv1.JobComplete:
+			return BackupSucceeded
+		case batchv1.JobFailed:
+			return BackupFailed
+		}
+	}
+	return BackupRunning
+}
+
+// backupEvent records an event when the last backup Job, compared to
+// previous, has just finished
+func (r *PostgreSQLClusterReconciler) backupEvent(cluster *ramv1.PostgreSQLCluster, previous *ramv1.BackupStatus) {
+	status := cluster.Status.Backup
+	if status == nil || status.LastJob == "" {
+		return
+	}
+	if previous != nil && previous.LastJob == status.LastJob && previous.LastResult == status.LastResult {
+		return
+	}
+	switch status.LastResult {
+	case BackupSucceeded:
+		r.event(cluster, corev1.EventTypeNormal, "BackupSucceeded", "backup %s succeeded", status.LastJob)
+	case BackupFailed:
+		r.event(cluster, corev1.EventTypeWarning, "BackupFailed", "backup %s failed", status.LastJob)
+	}
+}
@@ -0,0 +1,398 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	ramv1 "github.com/pgelephant/pgraft/k8s/operator/api/v1"
+)
+
+// ConditionBootstrapped is true once a cluster created from spec.restore or
+// spec.dataSource holds the data it was created from; until then its
+// reason says how far the bootstrap got
+const ConditionBootstrapped = "Bootstrapped"
+
+// Reasons of the Bootstrapped condition
+const (
+	BootstrapPending    = "Pending"
+	BootstrapInProgress = "Bootstrapping"
+	BootstrapFailed     = "BootstrapFailed"
+	BootstrapCompleted  = "Completed"
+)
+
+// Methods of DataSourceSpec
+const (
+	DataSourceBackup     = "backup"
+	DataSourceBaseBackup = "basebackup"
+)
+
+// bootstrapRequeue is how often a bootstrap in progress is looked at
+const bootstrapRequeue = 10 * time.Second
+
+// bootstrapMarker is left in the data directory from when the data is in
+// place until the bootstrap has finished with it
+const bootstrapMarker = ".ram-bootstrap"
+
+// bootstrap is where a new cluster's data comes from: a wal-g backup to
+// restore, or a PostgreSQL server to take a base backup from
+type bootstrap struct {
+	// Backup to fetch, for spec.restore, a copy from backup or a standby
+	// of a WAL archive
+	restore *ramv1.RestoreSpec
+
+	// Server to take a base backup from, unless there is a backup, and to
+	// stream from as a standby, with the Secret holding its password
+	host           string
+	port           int32
+	passwordSecret string
+
+	// Stay a standby of the source instead of recovering and promoting
+	standby *ramv1.StandbySpec
+
+	// What is being bootstrapped from, for the Bootstrapped condition
+	description string
+}
+
+// bootstrapPending reports whether the cluster is to be created from
+// existing data and has not been yet
+func bootstrapPending(cluster *ramv1.PostgreSQLCluster) bool {
+	return (cluster.Spec.Restore != nil || cluster.Spec.DataSource != nil || cluster.Spec.Standby != nil) &&
+		!meta.IsStatusConditionTrue(cluster.Status.Conditions, ConditionBootstrapped)
+}
+
+// bootstrapFor returns where the cluster's data comes from.  A copy from
+// backup restores the other cluster's latest backup from its repository; a
+// standby starts from the latest backup in the primary's WAL archive if it
+// has one.
+func (r *PostgreSQLClusterReconciler) bootstrapFor(ctx context.Context, cluster *ramv1.PostgreSQLCluster) (*bootstrap, error) {
+	sources := 0
+	for _, set := range []bool{cluster.Spec.Restore != nil, cluster.Spec.DataSource != nil, cluster.Spec.Standby != nil} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return nil, fmt.Errorf("spec.restore, spec.dataSource and spec.standby are mutually exclusive")
+	}
+
+	if standby := cluster.Spec.Standby; standby != nil {
+		b := &bootstrap{
+			host:           standby.Host,
+			port:           standby.Port,
+			passwordSecret: standby.CredentialsSecret,
+			standby:        standby,
+		}
+		if b.port == 0 {
+			b.port = 5432
+		}
+		if b.passwordSecret == "" {
+			b.passwordSecret = credentialsSecretName(cluster)
+		}
+		switch {
+		case standby.Repository != nil:
+			b.restore = &ramv1.RestoreSpec{
+				Repository: *standby.Repository,
+				Backup:     "LATEST",
+				Encryption: standby.Encryption,
+			}
+			b.description = fmt.Sprintf("the WAL archive in %s bucket %s", standby.Repository.Type, standby.Repository.Bucket)
+			if standby.Host != "" {
+				b.description += " and " + standby.Host
+			}
+		case standby.Host != "":
+			b.description = standby.Host
+		default:
+			return nil, fmt.Errorf("spec.standby: set repository, host or both")
+		}
+		b.description = "standby of " + b.description
+		return b, nil
+	}
+
+	if cluster.Spec.Restore != nil {
+		restore := cluster.Spec.Restore.DeepCopy()
+		if restore.Backup == "" {
+			restore.Backup = "LATEST"
+		}
+		description := fmt.Sprintf("backup %s up to the end of the archived WAL", restore.Backup)
+		if restore.TargetTime != nil {
+			description = fmt.Sprintf("backup %s up to %s", restore.Backup, restore.TargetTime.UTC().Format(time.RFC3339))
+		}
+		return &bootstrap{restore: restore, description: description}, nil
+	}
+
+	dataSource := cluster.Spec.DataSource
+	source := &ramv1.PostgreSQLCluster{}
+	if err := r.Get(ctx, types.NamespacedName{Name: dataSource.ClusterRef.Name, Namespace: cluster.Namespace}, source); err != nil {
+		return nil, fmt.Errorf("spec.dataSource.clusterRef: %w", err)
+	}
+
+	switch dataSource.Method {
+	case DataSourceBaseBackup:
+		return &bootstrap{
+			host:           roleServiceName(source, RolePrimary),
+			port:           source.Spec.Networking.Ports.PostgreSQL,
+			passwordSecret: credentialsSecretName(source),
+			description:    fmt.Sprintf("base backup of cluster %s", source.Name),
+		}, nil
+	case "", DataSourceBackup:
+		repository := archiveRepository(source)
+		if repository == nil {
+			return nil, fmt.Errorf("spec.dataSource.clusterRef: cluster %s has no backup repository", source.Name)
+		}
+		repository = repository.DeepCopy()
+		if repository.Path == "" {
+			repository.Path = source.Namespace + "/" + source.Name
+		}
+		return &bootstrap{
+			restore: &ramv1.RestoreSpec{
+				Repository: *repository,
+				Backup:     "LATEST",
+				Encryption: archiveEncryption(source),
+			},
+			description: fmt.Sprintf("latest backup of cluster %s", source.Name),
+		}, nil
+	default:
+		return nil, fmt.Errorf("spec.dataSource.method: unknown method %q", dataSource.Method)
+	}
+}
+
+// bootstrapScript returns the script of the bootstrap init container.  On
+// the first pod, with an empty data directory, it puts the data in place,
+// fetching the base backup or taking one from the source server.  A standby
+// is then set up to replay from its primary and left to the postgresql
+// container.  Otherwise PostgreSQL runs until it has recovered to the
+// target and promoted, the postgres password is set to the one of this
+// cluster, and PostgreSQL is stopped so the postgresql container starts on
+// the data.  A pod restarted midway resumes after the copy; the other pods,
+// and the first once bootstrapped, skip it.
+func bootstrapScript(cluster *ramv1.PostgreSQLCluster, b *bootstrap) string {
+	var fetch string
+	if b.restore != nil {
+		fetch = fmt.Sprintf(`  echo "fetching backup %[1]s"
+  %[2]s/wal-g backup-fetch "$PGDATA" %[1]s || { rm -rf "$PGDATA"/*; exit 1; }
+`, b.restore.Backup, walgDir)
+	} else {
+		fetch = fmt.Sprintf(`  echo "taking a base backup of %[1]s"
+  PGPASSWORD="$SOURCE_PASSWORD" pg_basebackup -h %[1]s -p %[2]d -U postgres -D "$PGDATA" -X stream --checkpoint=fast ||
+    { rm -rf "$PGDATA"/*; exit 1; }
+`, b.host, b.port)
+	}
+
+	var settings []string
+	signal := "recovery.signal"
+	if b.standby != nil {
+		signal = "standby.signal"
+		if b.standby.Repository != nil {
+			env := append(walgEnv(cluster, b.standby.Repository), walgEncryptionEnv(b.standby.Encryption, standbyKeyDir)...)
+			settings = append(settings, fmt.Sprintf("restore_command = '%s %s/wal-g wal-fetch %%f %%p'",
+				inlineEnv(env), walgDir))
+		}
+		if b.host != "" {
+			settings = append(settings, fmt.Sprintf(
+				"primary_conninfo = 'host=%s port=%d user=postgres password=$SOURCE_PASSWORD application_name=%s'",
+				b.host, b.port, cluster.Name))
+		}
+	} else if b.restore != nil {
+		settings = append(settings,
+			fmt.Sprintf("restore_command = '%s/wal-g wal-fetch %%f %%p'", walgDir),
+			"recovery_target_action = 'promote'")
+		if b.restore.TargetTime != nil {
+			settings = append(settings, fmt.Sprintf("recovery_target_time = '%s'",
+				b.restore.TargetTime.UTC().Format(time.RFC3339)))
+		}
+	}
+	if len(settings) > 0 {
+		fetch += fmt.Sprintf(`  touch "$PGDATA/%s"
+  cat >> "$PGDATA/postgresql.auto.conf" <<EOF
+%s
+EOF
+`, signal, strings.Join(settings, "\n"))
+	}
+
+	// A standby replays in the postgresql container from the start
+	finish := ""
+	if b.standby == nil {
+		finish = `echo "starting PostgreSQL to recover"
+$as_postgres pg_ctl -D "$PGDATA" -w -t 3600 -o "-c listen_addresses=''" start
+until [ "$($as_postgres psql -tAc 'SELECT pg_is_in_recovery()' 2>/dev/null)" = f ]; do
+  $as_postgres pg_ctl -D "$PGDATA" status >/dev/null || { echo "recovery failed"; exit 1; }
+  sleep 5
+done
+$as_postgres psql -c 'ALTER SYSTEM RESET restore_command' -c 'ALTER SYSTEM RESET recovery_target_action' -c 'ALTER SYSTEM RESET recovery_target_time'
+echo "ALTER USER postgres PASSWORD :'password'" | $as_postgres psql -v password="$POSTGRES_PASSWORD"
+$as_postgres pg_ctl -D "$PGDATA" -m fast -w stop
+`
+	}
+
+	return fmt.Sprintf(`set -e
+case "$HOSTNAME" in %[1]s) ;; *) exit 0 ;; esac
+# The pod runs as postgres unless its security context says otherwise
+as_postgres=
+if [ "$(id -u)" = 0 ]; then as_postgres="gosu postgres"; fi
+if [ ! -s "$PGDATA/PG_VERSION" ]; then
+  mkdir -p "$PGDATA"
+%[2]s  touch "$PGDATA/%[3]s"
+  if [ -n "$as_postgres" ]; then chown -R postgres:postgres "$PGDATA"; fi
+  chmod 700 "$PGDATA"
+elif [ ! -f "$PGDATA/%[3]s" ]; then
+  exit 0
+fi
+%[4]srm "$PGDATA/%[3]s"
+echo "bootstrapped"
+`, podName(cluster, 0), fetch, bootstrapMarker, finish)
+}
+
+// addBootstrap adds the bootstrap init container, which runs before the
+// postgresql container starts.  It is added only until the bootstrap
+// completes, so that a pod that later loses its volume is not bootstrapped
+// again from old data.
+func addBootstrap(cluster *ramv1.PostgreSQLCluster, b *bootstrap, spec *corev1.PodSpec) {
+	container := corev1.Container{
+		Name:    "bootstrap",
+		Image:   postgresqlImage(cluster),
+		Command: []string{"/bin/sh", "-c", bootstrapScript(cluster, b)},
+		Env: []corev1.EnvVar{
+			{
+				Name: "POSTGRES_PASSWORD",
+				ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: credentialsSecretName(cluster)},
+						Key:                  postgresPasswordKey,
+					},
+				},
+			},
+			{Name: "PGDATA", Value: dataDirectory(cluster)},
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{Name: "postgresql-data", MountPath: dataVolumePath},
+		},
+		Resources: cluster.Spec.PostgreSQL.Resources,
+	}
+
+	if b.host != "" {
+		container.Env = append(container.Env, corev1.EnvVar{
+			Name: "SOURCE_PASSWORD",
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: b.passwordSecret},
+					Key:                  postgresPasswordKey,
+				},
+			},
+		})
+	}
+	if b.restore == nil {
+		container.Env = append(container.Env,
+			corev1.EnvVar{Name: "PGHOST", Value: "/var/run/postgresql"},
+			corev1.EnvVar{Name: "PGUSER", Value: "postgres"})
+		spec.InitContainers = append(spec.InitContainers, container)
+		return
+	}
+
+	// wal-g is copied in first
+	repository := &b.restore.Repository
+	addWALG(cluster, spec)
+	container.Env = append(container.Env, walgEnv(cluster, repository)...)
+	container.Env = append(container.Env, walgEncryptionEnv(b.restore.Encryption, walgKeyDir)...)
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{Name: "wal-g", MountPath: walgDir})
+	addWALGKey(spec, &container, b.restore.Encryption, "bootstrap-key", walgKeyDir)
+	if repository.CredentialsSecret != "" {
+		container.EnvFrom = []corev1.EnvFromSource{
+			{
+				SecretRef: &corev1.SecretEnvSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: repository.CredentialsSecret},
+				},
+			},
+		}
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      "bootstrap-credentials",
+			MountPath: walgCredentialsDir,
+			ReadOnly:  true,
+		})
+		spec.Volumes = append(spec.Volumes, corev1.Volume{
+			Name: "bootstrap-credentials",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: repository.CredentialsSecret},
+			},
+		})
+	}
+	spec.InitContainers = append(spec.InitContainers, container)
+}
+
+// updateBootstrap follows the bootstrap init container of the first pod and
+// records its progress in the Bootstrapped condition.  Once the bootstrap
+// has completed it is not looked at again.  It returns how soon to look
+// again, zero if there is nothing to wait for.
+func (r *PostgreSQLClusterReconciler) updateBootstrap(ctx context.Context, cluster *ramv1.PostgreSQLCluster) (time.Duration, error) {
+	if !bootstrapPending(cluster) {
+		return 0, nil
+	}
+	b, err := r.bootstrapFor(ctx, cluster)
+	if err != nil {
+		return 0, err
+	}
+
+	before := cluster.Status.DeepCopy()
+	condition := metav1.Condition{
+		Type:               ConditionBootstrapped,
+		Status:             metav1.ConditionFalse,
+		Reason:             BootstrapPending,
+		Message:            "waiting for the first pod",
+		ObservedGeneration: cluster.Generation,
+	}
+
+	pod := &corev1.Pod{}
+	err = r.Get(ctx, types.NamespacedName{Name: podName(cluster, 0), Namespace: cluster.Namespace}, pod)
+	if err != nil && !errors.IsNotFound(err) {
+		return 0, err
+	}
+	if err == nil {
+		for _, status := range pod.Status.InitContainerStatuses {
+			if status.Name != "bootstrap" {
+				continue
+			}
+			terminated := status.State.Terminated
+			if terminated == nil && status.LastTerminationState.Terminated != nil &&
+				status.LastTerminationState.Terminated.ExitCode != 0 {
+				// Crash looping: report the last failure
+				terminated = status.LastTerminationState.Terminated
+			}
+			switch {
+			case terminated != nil && terminated.ExitCode == 0:
+				condition.Status = metav1.ConditionTrue
+				condition.Reason = BootstrapCompleted
+				condition.Message = "bootstrapped from " + b.description
+			case terminated != nil:
+				condition.Reason = BootstrapFailed
+				condition.Message = strings.TrimSpace(fmt.Sprintf("bootstrap exited with %d: %s %s",
+					terminated.ExitCode, terminated.Reason, terminated.Message))
+			case status.State.Running != nil:
+				condition.Reason = BootstrapInProgress
+				condition.Message = "bootstrapping from " + b.description
+			}
+		}
+	}
+	meta.SetStatusCondition(&cluster.Status.Conditions, condition)
+
+	requeue := bootstrapRequeue
+	if condition.Status == metav1.ConditionTrue {
+		requeue = 0
+	}
+	if equality.Semantic.DeepEqual(before, &cluster.Status) {
+		return requeue, nil
+	}
+	if condition.Reason == BootstrapFailed {
+		r.event(cluster, corev1.EventTypeWarning, "BootstrapFailed", "%s", condition.Message)
+	} else if condition.Reason == BootstrapCompleted {
+		r.event(cluster, corev1.EventTypeNormal, "Bootstrapped", "%s", condition.Message)
+	}
+	return requeue, r.Status().Update(ctx, cluster)
+}
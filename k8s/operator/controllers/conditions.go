@@ -0,0 +1,219 @@
+package controllers
+
+import (
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ramv1 "github.com/pgelephant/pgraft/k8s/operator/api/v1"
+)
+
+// Conditions summarising the cluster, set on every reconcile from the
+// StatefulSet and the conditions of the individual operations
+const (
+	// ConditionReady is true while every member is ready and the raft
+	// leader is known
+	ConditionReady = "Ready"
+
+	// ConditionQuorumAvailable is true while a majority of the members
+	// can elect a leader and commit
+	ConditionQuorumAvailable = "QuorumAvailable"
+
+	// ConditionBackupSucceeded follows the last scheduled backup; it is
+	// only set while backups are configured
+	ConditionBackupSucceeded = "BackupSucceeded"
+
+	// ConditionBackupVerified follows the last restore verifying the
+	// backups; it is only set while verification is configured
+	ConditionBackupVerified = "BackupVerified"
+
+	// ConditionDegraded is true while the cluster serves with members,
+	// the leader or backups failing
+	ConditionDegraded = "Degraded"
+
+	// ConditionProgressing is true while the operator is changing the
+	// cluster: rolling out, scaling, bootstrapping, failing or switching
+	// over, or cutting over to a new major version
+	ConditionProgressing = "Progressing"
+)
+
+// rollingOut reports whether the StatefulSet has pods to update
+func rollingOut(statefulSet *appsv1.StatefulSet) bool {
+	if statefulSet == nil {
+		return false
+	}
+	return statefulSet.Status.ObservedGeneration < statefulSet.Generation ||
+		statefulSet.Status.UpdateRevision != statefulSet.Status.CurrentRevision
+}
+
+// setClusterConditions sets the Ready, QuorumAvailable, Degraded and
+// Progressing conditions and the observed generation.  statefulSet is nil
+// until it has been created.
+func setClusterConditions(cluster *ramv1.PostgreSQLCluster, statefulSet *appsv1.StatefulSet) {
+	cluster.Status.ObservedGeneration = cluster.Generation
+	set := func(conditionType string, status metav1.ConditionStatus, reason, message string) {
+		meta.SetStatusCondition(&cluster.Status.Conditions, metav1.Condition{
+			Type:               conditionType,
+			Status:             status,
+			Reason:             reason,
+			Message:            message,
+			ObservedGeneration: cluster.Generation,
+		})
+	}
+
+	total := podCount(cluster)
+	ready := cluster.Status.ReadyReplicas
+	majority := total/2 + 1
+	leaderKnown := meta.FindStatusCondition(cluster.Status.Conditions, ConditionLeaderKnown)
+	readyMessage := fmt.Sprintf("%d of %d members ready", ready, total)
+
+	// QuorumAvailable: a known leader proves a quorum; otherwise too few
+	// ready members rule one out, and RAMD may report that it has none
+	switch {
+	case leaderKnown != nil && leaderKnown.Status == metav1.ConditionTrue:
+		set(ConditionQuorumAvailable, metav1.ConditionTrue, "LeaderElected", readyMessage)
+	case leaderKnown != nil && leaderKnown.Reason == "NoQuorum":
+		set(ConditionQuorumAvailable, metav1.ConditionFalse, "NoQuorum", leaderKnown.Message)
+	case ready < majority:
+		set(ConditionQuorumAvailable, metav1.ConditionFalse, "TooFewMembersReady",
+			fmt.Sprintf("%s, %d needed for a quorum", readyMessage, majority))
+	case leaderKnown != nil:
+		set(ConditionQuorumAvailable, metav1.ConditionUnknown, leaderKnown.Reason, leaderKnown.Message)
+	default:
+		set(ConditionQuorumAvailable, metav1.ConditionUnknown, "Pending", readyMessage)
+	}
+
+	// Progressing: the first operation under way
+	switch {
+	case bootstrapPending(cluster):
+		set(ConditionProgressing, metav1.ConditionTrue, "Bootstrapping", "the cluster is being bootstrapped")
+	case failoverInProgress(cluster):
+		set(ConditionProgressing, metav1.ConditionTrue, "FailingOver",
+			fmt.Sprintf("failing over from %s", cluster.Status.Failover.OldLeader))
+	case switchoverInProgress(cluster):
+		set(ConditionProgressing, metav1.ConditionTrue, "SwitchingOver",
+			fmt.Sprintf("switching over to %s", cluster.Status.Switchover.TargetPod))
+	case meta.IsStatusConditionTrue(cluster.Status.Conditions, ConditionScaling):
+		set(ConditionProgressing, metav1.ConditionTrue, "Scaling",
+			meta.FindStatusCondition(cluster.Status.Conditions, ConditionScaling).Message)
+	case hibernationInProgress(cluster) && cluster.Status.Hibernation.Phase != HibernationHibernated:
+		set(ConditionProgressing, metav1.ConditionTrue, "Hibernating",
+			fmt.Sprintf("hibernation %s", cluster.Status.Hibernation.Phase))
+	case majorUpgradeCuttingOver(cluster):
+		set(ConditionProgressing, metav1.ConditionTrue, "UpgradingMajorVersion",
+			fmt.Sprintf("%s to PostgreSQL %s", cluster.Status.MajorUpgrade.Phase, cluster.Status.MajorUpgrade.ToVersion))
+	case updateInProgress(cluster):
+		set(ConditionProgressing, metav1.ConditionTrue, "Updating",
+			fmt.Sprintf("%s, %d of %d pods updated", cluster.Status.Update.Phase, cluster.Status.Update.Updated, cluster.Status.Update.Total))
+	case rollingOut(statefulSet):
+		set(ConditionProgressing, metav1.ConditionTrue, "RollingOut",
+			fmt.Sprintf("%d of %d pods updated", statefulSet.Status.UpdatedReplicas, total))
+	default:
+		set(ConditionProgressing, metav1.ConditionFalse, "Stable", "no operation in progress")
+	}
+
+	// Degraded: what fails while the cluster still serves
+	leaderHealthy := meta.FindStatusCondition(cluster.Status.Conditions, ConditionLeaderHealthy)
+	backup := meta.FindStatusCondition(cluster.Status.Conditions, ConditionBackupSucceeded)
+	verified := meta.FindStatusCondition(cluster.Status.Conditions, ConditionBackupVerified)
+	switch {
+	case statefulSet != nil && ready < total:
+		set(ConditionDegraded, metav1.ConditionTrue, "MembersNotReady", readyMessage)
+	case leaderHealthy != nil && leaderHealthy.Status == metav1.ConditionFalse:
+		set(ConditionDegraded, metav1.ConditionTrue, "LeaderUnhealthy", leaderHealthy.Message)
+	case backup != nil && backup.Status == metav1.ConditionFalse:
+		set(ConditionDegraded, metav1.ConditionTrue, "BackupFailed", backup.Message)
+	case verified != nil && verified.Status == metav1.ConditionFalse:
+		set(ConditionDegraded, metav1.ConditionTrue, "BackupVerificationFailed", verified.Message)
+	default:
+		set(ConditionDegraded, metav1.ConditionFalse, "AsExpected", readyMessage)
+	}
+
+	// Ready: every member ready under a known leader
+	switch {
+	case statefulSet == nil:
+		set(ConditionReady, metav1.ConditionFalse, "Pending", "the PostgreSQL pods have not been created")
+	case bootstrapPending(cluster):
+		set(ConditionReady, metav1.ConditionFalse, "Bootstrapping", "the cluster is being bootstrapped")
+	case ready < total:
+		set(ConditionReady, metav1.ConditionFalse, "MembersNotReady", readyMessage)
+	case leaderKnown == nil || leaderKnown.Status != metav1.ConditionTrue:
+		set(ConditionReady, metav1.ConditionFalse, "LeaderUnknown", "the raft leader is not known")
+	default:
+		set(ConditionReady, metav1.ConditionTrue, "ClusterReady",
+			fmt.Sprintf("%s, %s is the leader", readyMessage, cluster.Status.Leader))
+	}
+}
+
+// setBackupCondition sets the BackupSucceeded condition from the last
+// backup Job, or removes it while backups are not configured
+func setBackupCondition(cluster *ramv1.PostgreSQLCluster) {
+	backup := cluster.Status.Backup
+	if backup == nil {
+		meta.RemoveStatusCondition(&cluster.Status.Conditions, ConditionBackupSucceeded)
+		return
+	}
+	condition := metav1.Condition{
+		Type:               ConditionBackupSucceeded,
+		Status:             metav1.ConditionUnknown,
+		Reason:             "NoBackupYet",
+		Message:            "no backup has run yet",
+		ObservedGeneration: cluster.Generation,
+	}
+	switch backup.LastResult {
+	case BackupRunning:
+		// A running backup leaves the outcome of the one before
+		if meta.FindStatusCondition(cluster.Status.Conditions, ConditionBackupSucceeded) != nil {
+			return
+		}
+		condition.Reason = "BackupRunning"
+		condition.Message = fmt.Sprintf("backup %s is running", backup.LastJob)
+	case BackupSucceeded:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "BackupSucceeded"
+		condition.Message = fmt.Sprintf("backup %s succeeded", backup.LastJob)
+	case BackupFailed:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "BackupFailed"
+		condition.Message = fmt.Sprintf("backup %s failed", backup.LastJob)
+	}
+	meta.SetStatusCondition(&cluster.Status.Conditions, condition)
+}
+
+// setBackupVerifiedCondition sets the BackupVerified condition from the
+// last verification Job, or removes it while verification is not
+// configured
+func setBackupVerifiedCondition(cluster *ramv1.PostgreSQLCluster) {
+	if cluster.Status.Backup == nil || cluster.Status.Backup.Verification == nil {
+		meta.RemoveStatusCondition(&cluster.Status.Conditions, ConditionBackupVerified)
+		return
+	}
+	verification := cluster.Status.Backup.Verification
+	condition := metav1.Condition{
+		Type:               ConditionBackupVerified,
+		Status:             metav1.ConditionUnknown,
+		Reason:             "NoVerificationYet",
+		Message:            "no backup has been verified yet",
+		ObservedGeneration: cluster.Generation,
+	}
+	switch verification.LastResult {
+	case BackupRunning:
+		// A running verification leaves the outcome of the one before
+		if meta.FindStatusCondition(cluster.Status.Conditions, ConditionBackupVerified) != nil {
+			return
+		}
+		condition.Reason = "VerificationRunning"
+		condition.Message = fmt.Sprintf("verification %s is running", verification.LastJob)
+	case BackupSucceeded:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "BackupRestored"
+		condition.Message = fmt.Sprintf("verification %s restored the latest backup", verification.LastJob)
+	case BackupFailed:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "RestoreFailed"
+		condition.Message = fmt.Sprintf("verification %s could not restore the latest backup", verification.LastJob)
+	}
+	meta.SetStatusCondition(&cluster.Status.Conditions, condition)
+}
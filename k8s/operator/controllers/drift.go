@@ -0,0 +1,217 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	ramv1 "github.com/pgelephant/pgraft/k8s/operator/api/v1"
+)
+
+// Reasons of the events on generated objects changed or deleted out of
+// band
+const (
+	DriftRepaired = "DriftRepaired"
+	DriftDetected = "DriftDetected"
+)
+
+// objectKey identifies a generated object of a cluster, in its namespace
+type objectKey struct {
+	kind, name string
+}
+
+// appliedObject is how the operator last left a generated object
+type appliedObject struct {
+	// Digest of the object as stored, but for its status and the metadata
+	// the API server maintains
+	digest string
+
+	// Generation of the cluster the object was last written or found
+	// unchanged for
+	generation int64
+
+	// Whether its drift has been reported while self-healing is off
+	reported bool
+}
+
+// appliedTracker keeps how the operator last left the generated objects
+// of each cluster.  It lives in memory: after a restart the operator
+// starts afresh, so what drifted while it was down is repaired but not
+// reported.
+type appliedTracker struct {
+	mu      sync.Mutex
+	objects map[clusterKey]map[objectKey]appliedObject
+}
+
+var appliedObjects = &appliedTracker{
+	objects: make(map[clusterKey]map[objectKey]appliedObject),
+}
+
+// get returns how the operator last left the object key of cluster, and
+// whether it has left it at all
+func (t *appliedTracker) get(cluster *ramv1.PostgreSQLCluster, key objectKey) (appliedObject, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	applied, ok := t.objects[clusterKey{namespace: cluster.Namespace, name: cluster.Name}][key]
+	return applied, ok
+}
+
+// set records how the operator left the object key of cluster
+func (t *appliedTracker) set(cluster *ramv1.PostgreSQLCluster, key objectKey, applied appliedObject) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ck := clusterKey{namespace: cluster.Namespace, name: cluster.Name}
+	if t.objects[ck] == nil {
+		t.objects[ck] = make(map[objectKey]appliedObject)
+	}
+	t.objects[ck][key] = applied
+}
+
+// forget drops the objects of a cluster that is gone
+func (t *appliedTracker) forget(namespace, name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.objects, clusterKey{namespace: namespace, name: name})
+}
+
+// objectDigest returns a digest of obj but for its status and the
+// metadata the API server maintains, which changes with any write to it
+// from anyone
+func objectDigest(obj client.Object) (string, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return "", err
+	}
+	delete(content, "status")
+	if metadata, ok := content["metadata"].(map[string]interface{}); ok {
+		for _, field := range []string{"resourceVersion", "generation", "managedFields", "creationTimestamp", "uid"} {
+			delete(metadata, field)
+		}
+	}
+	data, err := json.Marshal(content)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// selfHealing reports whether the generated objects of cluster that drift
+// are repaired
+func selfHealing(cluster *ramv1.PostgreSQLCluster) bool {
+	return cluster.Spec.SelfHeal == nil || *cluster.Spec.SelfHeal
+}
+
+// createOrUpdate creates or updates obj, a generated object of cluster,
+// with mutate as controllerutil.CreateOrUpdate does, and notices when it
+// has drifted: been changed or deleted other than by the operator since
+// the operator last left it, e.g. with kubectl edit.  A drifted object is
+// repaired with an event for each repair.  With spec.selfHeal false it is
+// left as it is instead, and reported once, until the spec changes; so are
+// the operator's own changes to it meanwhile.
+func (r *PostgreSQLClusterReconciler) createOrUpdate(ctx context.Context, cluster *ramv1.PostgreSQLCluster, obj client.Object, mutate controllerutil.MutateFn) (controllerutil.OperationResult, error) {
+	gvk, err := apiutil.GVKForObject(obj, r.Scheme)
+	if err != nil {
+		return controllerutil.OperationResultNone, err
+	}
+	key := objectKey{kind: gvk.Kind, name: obj.GetName()}
+	applied, known := appliedObjects.get(cluster, key)
+	// An object the operator stopped writing, e.g. a Service of a member
+	// scaled away, is only written again after a change of the spec
+	known = known && applied.generation == cluster.Generation
+
+	if err := r.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+		if !errors.IsNotFound(err) {
+			return controllerutil.OperationResultNone, err
+		}
+		if known && !selfHealing(cluster) {
+			r.reportDrift(cluster, key, applied, "was deleted")
+			return controllerutil.OperationResultNone, nil
+		}
+		if err := mutate(); err != nil {
+			return controllerutil.OperationResultNone, err
+		}
+		if err := r.Create(ctx, obj); err != nil {
+			return controllerutil.OperationResultNone, err
+		}
+		if known {
+			r.event(cluster, corev1.EventTypeWarning, DriftRepaired,
+				"recreated %s %s, which was deleted out of band", key.kind, key.name)
+		}
+		return controllerutil.OperationResultCreated, r.recordApplied(cluster, key, obj)
+	}
+
+	existing := obj.DeepCopyObject().(client.Object)
+	digest, err := objectDigest(existing)
+	if err != nil {
+		return controllerutil.OperationResultNone, err
+	}
+	drifted := known && applied.digest != digest
+	if err := mutate(); err != nil {
+		return controllerutil.OperationResultNone, err
+	}
+	if equality.Semantic.DeepEqual(existing, obj) {
+		return controllerutil.OperationResultNone, r.recordApplied(cluster, key, obj)
+	}
+
+	if drifted && !selfHealing(cluster) {
+		// Only what the update would revert counts, not, say, an
+		// annotation of someone else's
+		if err := r.Update(ctx, obj, client.DryRunAll); err != nil {
+			return controllerutil.OperationResultNone, err
+		}
+		result, err := objectDigest(obj)
+		if err != nil {
+			return controllerutil.OperationResultNone, err
+		}
+		if err := r.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+			return controllerutil.OperationResultNone, err
+		}
+		if result != digest {
+			r.reportDrift(cluster, key, applied, "was changed")
+			return controllerutil.OperationResultNone, nil
+		}
+		return controllerutil.OperationResultNone, r.recordApplied(cluster, key, obj)
+	}
+
+	if err := r.Update(ctx, obj); err != nil {
+		return controllerutil.OperationResultNone, err
+	}
+	if drifted && obj.GetResourceVersion() != existing.GetResourceVersion() {
+		r.event(cluster, corev1.EventTypeWarning, DriftRepaired,
+			"reverted the out-of-band changes to %s %s", key.kind, key.name)
+	}
+	return controllerutil.OperationResultUpdated, r.recordApplied(cluster, key, obj)
+}
+
+// recordApplied records obj, as stored, as how the operator left it
+func (r *PostgreSQLClusterReconciler) recordApplied(cluster *ramv1.PostgreSQLCluster, key objectKey, obj client.Object) error {
+	digest, err := objectDigest(obj)
+	if err != nil {
+		return err
+	}
+	appliedObjects.set(cluster, key, appliedObject{digest: digest, generation: cluster.Generation})
+	return nil
+}
+
+// reportDrift reports once that the object key, which self-healing is
+// off for, has drifted, and keeps it as the operator left it so that it
+// is not written until the spec changes
+func (r *PostgreSQLClusterReconciler) reportDrift(cluster *ramv1.PostgreSQLCluster, key objectKey, applied appliedObject, what string) {
+	if !applied.reported {
+		r.event(cluster, corev1.EventTypeWarning, DriftDetected,
+			"%s %s %s out of band and is left as it is, as spec.selfHeal is false", key.kind, key.name, what)
+	}
+	applied.reported = true
+	appliedObjects.set(cluster, key, applied)
+}
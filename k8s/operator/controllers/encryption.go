@@ -0,0 +1,156 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	ramv1 "github.com/pgelephant/pgraft/k8s/operator/api/v1"
+)
+
+// ConditionEncryptionCompliant is true while the data volumes are
+// encrypted, and the backups and archived WAL too if there are any; it is
+// only set with spec.encryption
+const ConditionEncryptionCompliant = "EncryptionCompliant"
+
+// managedStorageClassName returns the name of the StorageClass the
+// operator provisions the data volumes of cluster from with
+// spec.encryption.volumeParameters.  StorageClasses are cluster-scoped,
+// so it names the namespace too.
+func managedStorageClassName(cluster *ramv1.PostgreSQLCluster) string {
+	return fmt.Sprintf("ram-%s-%s", cluster.Namespace, cluster.Name)
+}
+
+// managedStorageClass reports whether the data volumes of cluster come
+// from a StorageClass of the operator's
+func managedStorageClass(cluster *ramv1.PostgreSQLCluster) bool {
+	return cluster.Spec.Encryption != nil && len(cluster.Spec.Encryption.VolumeParameters) > 0
+}
+
+// dataStorageClassName returns the StorageClass the data volumes are
+// claimed with; empty for the default class
+func dataStorageClassName(cluster *ramv1.PostgreSQLCluster) string {
+	if managedStorageClass(cluster) {
+		return managedStorageClassName(cluster)
+	}
+	return cluster.Spec.PostgreSQL.Storage.StorageClass
+}
+
+// reconcileEncryption creates the StorageClass of the operator's for a
+// cluster with spec.encryption.volumeParameters, a copy of the class it
+// would otherwise use with those parameters over its own, and records in
+// Status.Encryption and the EncryptionCompliant condition what of the
+// data is encrypted.  The class is only created: the parameters of a
+// StorageClass cannot change.
+func (r *PostgreSQLClusterReconciler) reconcileEncryption(ctx context.Context, cluster *ramv1.PostgreSQLCluster) error {
+	before := cluster.Status.DeepCopy()
+	encryption := cluster.Spec.Encryption
+	if encryption == nil {
+		cluster.Status.Encryption = nil
+		meta.RemoveStatusCondition(&cluster.Status.Conditions, ConditionEncryptionCompliant)
+		if equality.Semantic.DeepEqual(before, &cluster.Status) {
+			return nil
+		}
+		return r.Status().Update(ctx, cluster)
+	}
+
+	class, err := r.dataStorageClass(ctx, cluster)
+	if err != nil {
+		return err
+	}
+	status := &ramv1.EncryptionStatus{
+		StorageClass:     class.Name,
+		StorageEncrypted: ramv1.StorageParametersEncrypt(class.Provisioner, class.Parameters, encryption.EncryptedStorageParameters),
+		BackupsEncrypted: walArchiveConfigured(cluster) && (archiveEncryption(cluster) != nil || encryption.KMS != nil),
+	}
+	cluster.Status.Encryption = status
+
+	condition := metav1.Condition{
+		Type:               ConditionEncryptionCompliant,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Encrypted",
+		Message:            fmt.Sprintf("StorageClass %s encrypts the data volumes", class.Name),
+		ObservedGeneration: cluster.Generation,
+	}
+	switch {
+	case !status.StorageEncrypted:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "StorageNotEncrypted"
+		condition.Message = fmt.Sprintf("StorageClass %s does not encrypt the data volumes", class.Name)
+	case walArchiveConfigured(cluster) && !status.BackupsEncrypted:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "BackupsNotEncrypted"
+		condition.Message = "the backups and archived WAL are not encrypted"
+	case walArchiveConfigured(cluster):
+		condition.Message += ", and the backups and archived WAL are encrypted"
+	}
+	previous := meta.FindStatusCondition(cluster.Status.Conditions, ConditionEncryptionCompliant)
+	if condition.Status == metav1.ConditionFalse && (previous == nil || previous.Reason != condition.Reason) {
+		r.event(cluster, corev1.EventTypeWarning, condition.Reason, "%s", condition.Message)
+	}
+	meta.SetStatusCondition(&cluster.Status.Conditions, condition)
+
+	if equality.Semantic.DeepEqual(before, &cluster.Status) {
+		return nil
+	}
+	return r.Status().Update(ctx, cluster)
+}
+
+// dataStorageClass returns the StorageClass the data volumes come from,
+// creating the operator's if the cluster needs one
+func (r *PostgreSQLClusterReconciler) dataStorageClass(ctx context.Context, cluster *ramv1.PostgreSQLCluster) (*storagev1.StorageClass, error) {
+	if !managedStorageClass(cluster) {
+		return ramv1.BaseStorageClass(ctx, r.Client, cluster)
+	}
+
+	class := &storagev1.StorageClass{}
+	err := r.Get(ctx, types.NamespacedName{Name: managedStorageClassName(cluster)}, class)
+	if err == nil || !errors.IsNotFound(err) {
+		return class, err
+	}
+	base, err := ramv1.BaseStorageClass(ctx, r.Client, cluster)
+	if err != nil {
+		return nil, err
+	}
+	class = &storagev1.StorageClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: managedStorageClassName(cluster),
+			Labels: map[string]string{
+				"app":                          "postgresql-cluster",
+				"cluster":                      cluster.Name,
+				"ram.pgelephant.com/namespace": cluster.Namespace,
+			},
+		},
+		Provisioner:          base.Provisioner,
+		Parameters:           ramv1.VolumeParameters(cluster, base),
+		ReclaimPolicy:        base.ReclaimPolicy,
+		MountOptions:         base.MountOptions,
+		AllowVolumeExpansion: base.AllowVolumeExpansion,
+		VolumeBindingMode:    base.VolumeBindingMode,
+		AllowedTopologies:    base.AllowedTopologies,
+	}
+	if err := r.Create(ctx, class); err != nil {
+		return nil, err
+	}
+	r.event(cluster, corev1.EventTypeNormal, "StorageClassCreated",
+		"created StorageClass %s from %s with the volume parameters of spec.encryption", class.Name, base.Name)
+	return class, nil
+}
+
+// deleteStorageClass deletes the StorageClass of the operator's, which as
+// a cluster-scoped object cannot be owned by the cluster.  Volumes it
+// provisioned that are retained keep their encryption.
+func (r *PostgreSQLClusterReconciler) deleteStorageClass(ctx context.Context, cluster *ramv1.PostgreSQLCluster) (string, error) {
+	class := &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: managedStorageClassName(cluster)}}
+	if err := r.Delete(ctx, class); err != nil && !errors.IsNotFound(err) {
+		return "", err
+	}
+	return "", nil
+}
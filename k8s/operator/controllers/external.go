@@ -0,0 +1,182 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	ramv1 "github.com/pgelephant/pgraft/k8s/operator/api/v1"
+)
+
+// Annotations ExternalDNS publishes a Service under
+const (
+	externalDNSHostnameAnnotation = "external-dns.alpha.kubernetes.io/hostname"
+	externalDNSTTLAnnotation      = "external-dns.alpha.kubernetes.io/ttl"
+)
+
+// externalServiceName returns the name of the Service reaching pod from
+// outside Kubernetes
+func externalServiceName(pod string) string {
+	return pod + "-external"
+}
+
+// externalLabels returns the labels of the external Services
+func externalLabels(cluster *ramv1.PostgreSQLCluster) map[string]string {
+	return map[string]string{
+		"app":       "postgresql-cluster",
+		"cluster":   cluster.Name,
+		"component": "external",
+	}
+}
+
+// externalAddress returns where service is reached from outside
+// Kubernetes: the name published for it, or else the address of its load
+// balancer, with its port; empty while it has none.  A NodePort Service
+// without a name has no address of its own, only the nodes'.
+func externalAddress(service *corev1.Service) string {
+	port := service.Spec.Ports[0].Port
+	if service.Spec.Type == corev1.ServiceTypeNodePort {
+		port = service.Spec.Ports[0].NodePort
+	}
+	if port == 0 {
+		return ""
+	}
+
+	host := service.Annotations[externalDNSHostnameAnnotation]
+	if host == "" && service.Spec.Type == corev1.ServiceTypeLoadBalancer {
+		for _, ingress := range service.Status.LoadBalancer.Ingress {
+			host = ingress.Hostname
+			if host == "" {
+				host = ingress.IP
+			}
+			if host != "" {
+				break
+			}
+		}
+	}
+	if host == "" {
+		return ""
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(port)))
+}
+
+// reconcileExternalAccess gives each member within spec.replicas a
+// Service of its own of spec.networking.externalAccess.type, selecting its
+// pod by name, and records their addresses in Status.Endpoints.External.
+// With a domain the Services carry the ExternalDNS annotations naming them
+// <pod>.<domain>.  The Services of members scaled away, or all of them if
+// externalAccess is unset, are removed.
+func (r *PostgreSQLClusterReconciler) reconcileExternalAccess(ctx context.Context, cluster *ramv1.PostgreSQLCluster) error {
+	external := cluster.Spec.Networking.ExternalAccess
+	before := cluster.Status.DeepCopy()
+
+	wanted := map[string]bool{}
+	addresses := map[string]string{}
+	if external != nil {
+		for i := int32(0); i < cluster.Spec.Replicas; i++ {
+			pod := podName(cluster, i)
+			service, err := r.reconcileExternalService(ctx, cluster, pod)
+			if err != nil {
+				return fmt.Errorf("external Service of %s: %w", pod, err)
+			}
+			wanted[service.Name] = true
+			if address := externalAddress(service); address != "" {
+				addresses[pod] = address
+			}
+		}
+	}
+
+	services := &corev1.ServiceList{}
+	if err := r.List(ctx, services, client.InNamespace(cluster.Namespace),
+		client.MatchingLabels(externalLabels(cluster))); err != nil {
+		return err
+	}
+	for i := range services.Items {
+		service := &services.Items[i]
+		if wanted[service.Name] {
+			continue
+		}
+		if err := r.Delete(ctx, service); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	cluster.Status.Endpoints.External = nil
+	if len(addresses) > 0 {
+		cluster.Status.Endpoints.External = addresses
+	}
+	if equality.Semantic.DeepEqual(before, &cluster.Status) {
+		return nil
+	}
+	return r.Status().Update(ctx, cluster)
+}
+
+// reconcileExternalService creates or updates the external Service of pod
+func (r *PostgreSQLClusterReconciler) reconcileExternalService(ctx context.Context, cluster *ramv1.PostgreSQLCluster, pod string) (*corev1.Service, error) {
+	external := cluster.Spec.Networking.ExternalAccess
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      externalServiceName(pod),
+			Namespace: cluster.Namespace,
+		},
+	}
+
+	_, err := r.createOrUpdate(ctx, cluster, service, func() error {
+		service.Labels = externalLabels(cluster)
+
+		// The user's annotations, with the operator's over them; others
+		// are left to whoever set them, e.g. the cloud's controller
+		if service.Annotations == nil {
+			service.Annotations = map[string]string{}
+		}
+		for key, value := range external.Annotations {
+			service.Annotations[key] = value
+		}
+		delete(service.Annotations, externalDNSHostnameAnnotation)
+		delete(service.Annotations, externalDNSTTLAnnotation)
+		if external.Domain != "" {
+			service.Annotations[externalDNSHostnameAnnotation] = pod + "." + external.Domain
+			if external.DNSTTL > 0 {
+				service.Annotations[externalDNSTTLAnnotation] = strconv.Itoa(int(external.DNSTTL))
+			}
+		}
+
+		selector := postgresqlPodLabels(cluster)
+		selector["statefulset.kubernetes.io/pod-name"] = pod
+
+		// The node port is kept across updates once allocated
+		var nodePort int32
+		if len(service.Spec.Ports) == 1 {
+			nodePort = service.Spec.Ports[0].NodePort
+		}
+		service.Spec.Type = external.Type
+		service.Spec.Ports = []corev1.ServicePort{
+			{
+				Name:       "postgresql",
+				Port:       cluster.Spec.Networking.Ports.PostgreSQL,
+				TargetPort: intstr.FromInt(int(cluster.Spec.Networking.Ports.PostgreSQL)),
+				NodePort:   nodePort,
+				Protocol:   corev1.ProtocolTCP,
+			},
+		}
+		service.Spec.Selector = selector
+		service.Spec.ExternalTrafficPolicy = external.ExternalTrafficPolicy
+		service.Spec.LoadBalancerSourceRanges = nil
+		if external.Type == corev1.ServiceTypeLoadBalancer {
+			service.Spec.LoadBalancerSourceRanges = external.LoadBalancerSourceRanges
+		}
+
+		return controllerutil.SetControllerReference(cluster, service, r.Scheme)
+	})
+
+	return service, err
+}
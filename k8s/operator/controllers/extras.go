@@ -0,0 +1,27 @@
+package controllers
+
+import (
+	corev1 "k8s.io/api/core/v1"
+
+	ramv1 "github.com/pgelephant/pgraft/k8s/operator/api/v1"
+)
+
+// addPodExtras adds the user's containers and volumes of extras to a pod
+// spec, and their volume mounts to its container named container.  It runs
+// after addSecurityContext, so that the user's containers keep the
+// security context of their own spec.
+func addPodExtras(spec *corev1.PodSpec, extras ramv1.PodExtrasSpec, container string) {
+	spec.InitContainers = append(spec.InitContainers, extras.InitContainers...)
+	spec.Containers = append(spec.Containers, extras.Sidecars...)
+	spec.Volumes = append(spec.Volumes, extras.Volumes...)
+	addVolumeMounts(spec, extras.VolumeMounts, container)
+}
+
+// addVolumeMounts adds mounts to the container of spec named container
+func addVolumeMounts(spec *corev1.PodSpec, mounts []corev1.VolumeMount, container string) {
+	for i := range spec.Containers {
+		if spec.Containers[i].Name == container {
+			spec.Containers[i].VolumeMounts = append(spec.Containers[i].VolumeMounts, mounts...)
+		}
+	}
+}
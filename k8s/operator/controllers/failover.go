@@ -0,0 +1,308 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	ramv1 "github.com/pgelephant/pgraft/k8s/operator/api/v1"
+)
+
+// Phases of a failover, in order
+const (
+	FailoverElecting  = "Electing"
+	FailoverPromoting = "Promoting"
+	FailoverFencing   = "Fencing"
+	FailoverCompleted = "Completed"
+	FailoverFailed    = "Failed"
+)
+
+// ConditionLeaderHealthy is true while the leader pod is ready
+const ConditionLeaderHealthy = "LeaderHealthy"
+
+// failoverRequeue is how often a failover in progress is advanced
+const failoverRequeue = 5 * time.Second
+
+// Defaults of FailoverSpec, for clusters created without them
+const (
+	defaultUnhealthyAfter  = 30 * time.Second
+	defaultFailoverTimeout = 2 * time.Minute
+)
+
+// failoverInProgress reports whether a failover has started and not ended
+func failoverInProgress(cluster *ramv1.PostgreSQLCluster) bool {
+	failover := cluster.Status.Failover
+	return failover != nil && failover.Phase != FailoverCompleted && failover.Phase != FailoverFailed
+}
+
+// specDuration parses a duration of the spec, or returns fallback if unset
+func specDuration(text string, fallback time.Duration) (time.Duration, error) {
+	if text == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(text)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration %q", text)
+	}
+	return d, nil
+}
+
+// leaderUnreadySince checks the leader pod and records the result in the
+// LeaderHealthy condition.  It returns whether the pod is ready and, if
+// not, since when it has not been.
+func (r *PostgreSQLClusterReconciler) leaderUnreadySince(ctx context.Context, cluster *ramv1.PostgreSQLCluster) (bool, time.Time, error) {
+	condition := metav1.Condition{
+		Type:               ConditionLeaderHealthy,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: cluster.Generation,
+	}
+	since := time.Now()
+
+	pod := &corev1.Pod{}
+	err := r.Get(ctx, types.NamespacedName{Name: cluster.Status.Leader, Namespace: cluster.Namespace}, pod)
+	switch {
+	case errors.IsNotFound(err):
+		// The condition remembers since when the pod has been missing
+		condition.Reason = "PodMissing"
+		condition.Message = fmt.Sprintf("leader pod %s does not exist", cluster.Status.Leader)
+		if previous := meta.FindStatusCondition(cluster.Status.Conditions, ConditionLeaderHealthy); previous != nil &&
+			previous.Status == metav1.ConditionFalse {
+			since = previous.LastTransitionTime.Time
+		}
+	case err != nil:
+		return false, since, err
+	case pod.DeletionTimestamp != nil:
+		condition.Reason = "PodTerminating"
+		condition.Message = fmt.Sprintf("leader pod %s is being deleted", pod.Name)
+		since = pod.DeletionTimestamp.Time
+	default:
+		condition.Reason = "PodNotReady"
+		condition.Message = fmt.Sprintf("leader pod %s is not ready", pod.Name)
+		since = pod.CreationTimestamp.Time
+		for _, c := range pod.Status.Conditions {
+			if c.Type != corev1.PodReady {
+				continue
+			}
+			if c.Status == corev1.ConditionTrue {
+				condition.Status = metav1.ConditionTrue
+				condition.Reason = "PodReady"
+				condition.Message = fmt.Sprintf("leader pod %s is ready", pod.Name)
+			}
+			since = c.LastTransitionTime.Time
+		}
+	}
+
+	meta.SetStatusCondition(&cluster.Status.Conditions, condition)
+	return condition.Status == metav1.ConditionTrue, since, nil
+}
+
+// podReady reports whether the named pod exists and is ready
+func (r *PostgreSQLClusterReconciler) podReady(ctx context.Context, cluster *ramv1.PostgreSQLCluster, name string) (bool, error) {
+	pod := &corev1.Pod{}
+	err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: cluster.Namespace}, pod)
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return isPodReady(pod), nil
+}
+
+// isPodReady reports whether pod is ready and not being deleted
+func isPodReady(pod *corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue && pod.DeletionTimestamp == nil
+		}
+	}
+	return false
+}
+
+// reconcileFailover fails over from a leader pod that has been unready for
+// Spec.Failover.UnhealthyAfter: it waits for RAMD to report a new raft
+// leader on a ready pod, asking RAMD to fail over if it has not, promotes
+// that pod's PostgreSQL, then fences the old leader by demoting it through
+// RAMD or, if that fails, deleting its pod so it restarts as a standby.
+// The old leader is labeled fenced from the start, so neither Service
+// reaches it.  Each step is recorded in Status.Failover, so a failover
+// resumes where it was if the operator restarts.  It returns how soon to
+// look again, zero if there is nothing to wait for.
+func (r *PostgreSQLClusterReconciler) reconcileFailover(ctx context.Context, cluster *ramv1.PostgreSQLCluster) (time.Duration, error) {
+	if !cluster.Spec.Failover.Enabled || standbyActive(cluster) {
+		return 0, nil
+	}
+	unhealthyAfter, err := specDuration(cluster.Spec.Failover.UnhealthyAfter, defaultUnhealthyAfter)
+	if err != nil {
+		return 0, fmt.Errorf("spec.failover.unhealthyAfter: %w", err)
+	}
+	timeout, err := specDuration(cluster.Spec.Failover.Timeout, defaultFailoverTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("spec.failover.timeout: %w", err)
+	}
+
+	before := cluster.Status.DeepCopy()
+	requeue := time.Duration(0)
+
+	// A switchover, the cutover of a major upgrade or a hibernation takes
+	// the leader down on purpose
+	if !failoverInProgress(cluster) && !switchoverInProgress(cluster) && !majorUpgradeCuttingOver(cluster) &&
+		!hibernationInProgress(cluster) && cluster.Status.Leader != "" {
+		ready, since, err := r.leaderUnreadySince(ctx, cluster)
+		if err != nil {
+			return 0, err
+		}
+		if !ready {
+			if wait := unhealthyAfter - time.Since(since); wait > 0 {
+				requeue = wait
+			} else {
+				cluster.Status.Failover = &ramv1.FailoverStatus{
+					Phase:     FailoverElecting,
+					OldLeader: cluster.Status.Leader,
+					StartedAt: metav1.Now(),
+				}
+				cluster.Status.Leader = ""
+				r.event(cluster, corev1.EventTypeWarning, "FailoverStarted",
+					"leader %s unready since %s, failing over", cluster.Status.Failover.OldLeader,
+					since.Format(time.RFC3339))
+			}
+		}
+	}
+
+	// Advance through as many steps as can be taken now
+	for failoverInProgress(cluster) {
+		failover := cluster.Status.Failover
+		if time.Since(failover.StartedAt.Time) > timeout {
+			failover.Phase = FailoverFailed
+			now := metav1.Now()
+			failover.CompletedAt = &now
+			failover.Message = fmt.Sprintf("gave up after %v: %s", timeout, failover.Message)
+			r.event(cluster, corev1.EventTypeWarning, "FailoverFailed", "failover from %s: %s",
+				failover.OldLeader, failover.Message)
+			recordFailover(cluster, FailoverFailed)
+			break
+		}
+		phase := failover.Phase
+		r.failoverStep(ctx, cluster, failover)
+		if failover.Phase == phase {
+			requeue = failoverRequeue
+			break
+		}
+	}
+
+	if equality.Semantic.DeepEqual(before, &cluster.Status) {
+		return requeue, nil
+	}
+	updateEndpoints(cluster)
+	return requeue, r.Status().Update(ctx, cluster)
+}
+
+// failoverStep takes the next step of a failover, advancing its phase if
+// the step succeeded and otherwise recording what it waits for
+func (r *PostgreSQLClusterReconciler) failoverStep(ctx context.Context, cluster *ramv1.PostgreSQLCluster, failover *ramv1.FailoverStatus) {
+	logger := log.FromContext(ctx)
+
+	switch failover.Phase {
+	case FailoverElecting:
+		leader, err := r.queryLeader(ctx, cluster)
+		if err == errNoQuorum {
+			failover.Message = "waiting for a majority of the members to be up"
+			return
+		}
+		if err != nil && err != errNoLeader {
+			failover.Message = fmt.Sprintf("RAMD unavailable: %v", err)
+			return
+		}
+		if err == errNoLeader || leader == failover.OldLeader {
+			// RAMD has not noticed yet; ask it to fail over
+			failover.Message = "waiting for raft to elect a new leader"
+			if err := r.callRAMD(ctx, cluster, http.MethodPost, "/api/v1/failover", nil, nil); err != nil {
+				logger.Info("RAMD failover request failed", "error", err.Error())
+			}
+			return
+		}
+		ready, err := r.podReady(ctx, cluster, leader)
+		if err != nil || !ready {
+			failover.Message = fmt.Sprintf("waiting for new leader %s to be ready", leader)
+			return
+		}
+		failover.NewLeader = leader
+		failover.Phase = FailoverPromoting
+		failover.Message = ""
+		r.event(cluster, corev1.EventTypeNormal, "LeaderElected", "raft elected %s in place of %s",
+			leader, failover.OldLeader)
+
+	case FailoverPromoting:
+		node, err := r.nodeForPod(ctx, cluster, failover.NewLeader)
+		if err == nil {
+			err = r.callRAMD(ctx, cluster, http.MethodPost, fmt.Sprintf("/api/v1/promote/%d", node.NodeID), nil, nil)
+		}
+		if err != nil {
+			failover.Message = fmt.Sprintf("promoting %s: %v", failover.NewLeader, err)
+			return
+		}
+		failover.Phase = FailoverFencing
+		failover.Message = ""
+		cluster.Status.Leader = failover.NewLeader
+		r.event(cluster, corev1.EventTypeNormal, "Promoted", "promoted %s", failover.NewLeader)
+
+	case FailoverFencing:
+		if err := r.fence(ctx, cluster, failover.OldLeader); err != nil {
+			failover.Message = fmt.Sprintf("fencing %s: %v", failover.OldLeader, err)
+			return
+		}
+		failover.Phase = FailoverCompleted
+		failover.Message = ""
+		now := metav1.Now()
+		failover.CompletedAt = &now
+		r.event(cluster, corev1.EventTypeNormal, "FailoverCompleted", "failed over from %s to %s in %v",
+			failover.OldLeader, failover.NewLeader, now.Sub(failover.StartedAt.Time).Round(time.Second))
+		recordFailover(cluster, FailoverCompleted)
+	}
+}
+
+// fence keeps the old leader from taking writes: RAMD demotes it, or if
+// RAMD cannot, its pod is deleted and restarts as a standby
+func (r *PostgreSQLClusterReconciler) fence(ctx context.Context, cluster *ramv1.PostgreSQLCluster, name string) error {
+	node, err := r.nodeForPod(ctx, cluster, name)
+	if err == nil {
+		err = r.callRAMD(ctx, cluster, http.MethodPost, fmt.Sprintf("/api/v1/demote/%d", node.NodeID), nil, nil)
+	}
+	if err == nil {
+		return nil
+	}
+
+	pod := &corev1.Pod{}
+	if gerr := r.Get(ctx, types.NamespacedName{Name: name, Namespace: cluster.Namespace}, pod); gerr != nil {
+		if errors.IsNotFound(gerr) {
+			// Already gone; the StatefulSet recreates it as a standby
+			return nil
+		}
+		return gerr
+	}
+	if pod.DeletionTimestamp != nil {
+		return nil
+	}
+	log.FromContext(ctx).Info("Deleting old leader pod to fence it", "pod", name, "demoteError", err.Error())
+	if derr := r.Delete(ctx, pod); derr != nil && !errors.IsNotFound(derr) {
+		return derr
+	}
+	r.event(cluster, corev1.EventTypeWarning, "Fenced", "deleted pod %s, which RAMD could not demote: %v", name, err)
+	return nil
+}
+
+// event records an event on the cluster, if there is a recorder
+func (r *PostgreSQLClusterReconciler) event(cluster *ramv1.PostgreSQLCluster, eventType, reason, format string, args ...interface{}) {
+	if r.Recorder != nil {
+		r.Recorder.Eventf(cluster, eventType, reason, format, args...)
+	}
+}
@@ -0,0 +1,188 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	ramv1 "github.com/pgelephant/pgraft/k8s/operator/api/v1"
+)
+
+// hbaDir is where PostgreSQL finds pg_hba.conf.  The ConfigMap is mounted
+// there without a subPath, so that the kubelet updates the file in place.
+const hbaDir = "/etc/postgresql/hba"
+
+// hbaLine formats one pg_hba.conf line
+func hbaLine(rule ramv1.PgHBARule) string {
+	line := fmt.Sprintf("%-9s %-15s %-15s %-23s %s", rule.Type, rule.Database, rule.User, rule.Address, rule.Method)
+	names := make([]string, 0, len(rule.Options))
+	for name := range rule.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		line += fmt.Sprintf(" %s=\"%s\"", name, rule.Options[name])
+	}
+	return line + "\n"
+}
+
+// pgHBA returns the cluster's pg_hba.conf.  Connections on the local
+// socket and from ramd, which reaches PostgreSQL over the pod's own
+// address, are matched first, so that no rule of spec.postgresql.pgHBA can
+// lock out the operator; the rules of the spec follow in order, and then
+// password access for replication and every other client.
+func pgHBA(cluster *ramv1.PostgreSQLCluster) string {
+	var conf strings.Builder
+	conf.WriteString("# Generated by the operator from spec.postgresql.pgHBA\n")
+	conf.WriteString(hbaLine(ramv1.PgHBARule{Type: "local", Database: "all", User: "all", Method: "trust"}))
+	conf.WriteString(hbaLine(ramv1.PgHBARule{Type: "host", Database: "all", User: "postgres", Address: "samehost", Method: "trust"}))
+	for _, rule := range cluster.Spec.PostgreSQL.PgHBA {
+		conf.WriteString(hbaLine(rule))
+	}
+	conf.WriteString(hbaLine(ramv1.PgHBARule{Type: "host", Database: "replication", User: "all", Address: "all", Method: "scram-sha-256"}))
+	conf.WriteString(hbaLine(ramv1.PgHBARule{Type: "host", Database: "all", User: "all", Address: "all", Method: "scram-sha-256"}))
+	return conf.String()
+}
+
+// hbaVolume returns the volume with pg_hba.conf from the cluster's ConfigMap
+func hbaVolume(cluster *ramv1.PostgreSQLCluster) corev1.Volume {
+	return corev1.Volume{
+		Name: "postgresql-hba",
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: cluster.Name + "-config"},
+				Items:                []corev1.KeyToPath{{Key: "pg_hba.conf", Path: "pg_hba.conf"}},
+			},
+		},
+	}
+}
+
+// addHBA has PostgreSQL read pg_hba.conf from the cluster's ConfigMap
+// rather than its data directory
+func addHBA(cluster *ramv1.PostgreSQLCluster, spec *corev1.PodSpec) {
+	postgresql := &spec.Containers[0]
+	postgresql.Args = append(postgresql.Args, "-c", "hba_file="+hbaDir+"/pg_hba.conf")
+	postgresql.VolumeMounts = append(postgresql.VolumeMounts, corev1.VolumeMount{
+		Name:      "postgresql-hba",
+		MountPath: hbaDir,
+		ReadOnly:  true,
+	})
+	spec.Volumes = append(spec.Volumes, hbaVolume(cluster))
+}
+
+// hbaReloadScript returns the script of the Job that has every member
+// reload pg_hba.conf once the kubelet has updated its copy.  A member that
+// is down is skipped, as it reads the file when it starts.
+func hbaReloadScript(cluster *ramv1.PostgreSQLCluster) string {
+	hosts := make([]string, 0, podCount(cluster))
+	for ordinal := int32(0); ordinal < podCount(cluster); ordinal++ {
+		hosts = append(hosts, podHost(cluster, podName(cluster, ordinal)))
+	}
+	return fmt.Sprintf(`set -u
+expected=$(md5sum %[1]s/pg_hba.conf | cut -d' ' -f1)
+status=0
+for host in %[2]s; do
+  done=
+  for attempt in $(seq 60); do
+    if ! current=$(psql -h "$host" -p %[3]d -U postgres -d postgres -tAc "SELECT md5(pg_read_file(current_setting('hba_file')))" 2>/dev/null); then
+      echo "$host is down; it reads pg_hba.conf when it starts"
+      done=1
+      break
+    fi
+    if [ "$current" = "$expected" ]; then
+      psql -h "$host" -p %[3]d -U postgres -d postgres -tAc "SELECT pg_reload_conf()" >/dev/null && echo "$host reloaded pg_hba.conf" && done=1
+      break
+    fi
+    sleep 5
+  done
+  if [ -z "$done" ]; then
+    echo "$host did not reload pg_hba.conf"
+    status=1
+  fi
+done
+exit $status
+`, hbaDir, strings.Join(hosts, " "), cluster.Spec.Networking.Ports.PostgreSQL)
+}
+
+// hbaReloadLabels returns the labels of the Jobs reloading pg_hba.conf
+func hbaReloadLabels(cluster *ramv1.PostgreSQLCluster) map[string]string {
+	return map[string]string{
+		"app":       "postgresql-cluster",
+		"cluster":   cluster.Name,
+		"component": "hba-reload",
+	}
+}
+
+// reconcileHBAReload has the running members reload pg_hba.conf when it
+// changes, with a Job named after its digest; the Job of the previous
+// version is removed.  Members that start later read the file themselves.
+func (r *PostgreSQLClusterReconciler) reconcileHBAReload(ctx context.Context, cluster *ramv1.PostgreSQLCluster) error {
+	if cluster.Status.ReadyReplicas == 0 {
+		return nil
+	}
+
+	digest := sha256.Sum256([]byte(pgHBA(cluster)))
+	name := fmt.Sprintf("%s-hba-%s", cluster.Name, hex.EncodeToString(digest[:])[:10])
+
+	backoffLimit := int32(1)
+	spec := batchv1.JobSpec{
+		BackoffLimit: &backoffLimit,
+		Template: corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{Labels: hbaReloadLabels(cluster)},
+			Spec: corev1.PodSpec{
+				ImagePullSecrets: cluster.Spec.ImagePullSecrets,
+				RestartPolicy:    corev1.RestartPolicyNever,
+				Containers: []corev1.Container{
+					{
+						Name:    "reload",
+						Image:   cluster.Spec.PostgreSQL.Image,
+						Command: []string{"/bin/sh", "-c", hbaReloadScript(cluster)},
+						Env: []corev1.EnvVar{
+							{
+								Name: "PGPASSWORD",
+								ValueFrom: &corev1.EnvVarSource{
+									SecretKeyRef: &corev1.SecretKeySelector{
+										LocalObjectReference: corev1.LocalObjectReference{Name: credentialsSecretName(cluster)},
+										Key:                  postgresPasswordKey,
+									},
+								},
+							},
+						},
+						VolumeMounts: []corev1.VolumeMount{
+							{Name: "postgresql-hba", MountPath: hbaDir, ReadOnly: true},
+						},
+					},
+				},
+				Volumes: []corev1.Volume{hbaVolume(cluster)},
+			},
+		},
+	}
+	if _, err := r.runJob(ctx, cluster, name, spec); err != nil {
+		return err
+	}
+
+	jobs := &batchv1.JobList{}
+	if err := r.List(ctx, jobs, client.InNamespace(cluster.Namespace),
+		client.MatchingLabels(hbaReloadLabels(cluster))); err != nil {
+		return err
+	}
+	for i := range jobs.Items {
+		if jobs.Items[i].Name == name {
+			continue
+		}
+		err := r.Delete(ctx, &jobs.Items[i], client.PropagationPolicy(metav1.DeletePropagationBackground))
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ramv1 "github.com/pgelephant/pgraft/k8s/operator/api/v1"
+)
+
+// Phases of a hibernation, in order
+const (
+	HibernationSwitchingOver = "SwitchingOver"
+	HibernationStopping      = "Stopping"
+	HibernationHibernated    = "Hibernated"
+	HibernationResuming      = "Resuming"
+	HibernationResumed       = "Resumed"
+)
+
+// ConditionHibernated is true while every pod of a hibernating cluster is
+// stopped; its reason is the phase of the hibernation
+const ConditionHibernated = "Hibernated"
+
+// hibernationRequeue is how often a hibernation or resumption in progress
+// is advanced
+const hibernationRequeue = 5 * time.Second
+
+// hibernationInProgress reports whether the cluster is going to sleep, is
+// asleep or is waking up, so that other operations wait
+func hibernationInProgress(cluster *ramv1.PostgreSQLCluster) bool {
+	hibernation := cluster.Status.Hibernation
+	return hibernation != nil && hibernation.Phase != HibernationResumed
+}
+
+// hibernated reports whether the cluster's pods are to be stopped, or are
+func hibernated(cluster *ramv1.PostgreSQLCluster) bool {
+	hibernation := cluster.Status.Hibernation
+	return hibernation != nil &&
+		(hibernation.Phase == HibernationStopping || hibernation.Phase == HibernationHibernated)
+}
+
+// reconcileHibernation puts the cluster to sleep while spec.hibernate is
+// set and wakes it once it is unset.  Going to sleep, the leadership moves
+// to the first pod, so that the StatefulSet stops it last and starts it
+// first with the latest data; then every pod stops.  The volumes, Secrets
+// and backups stay, the backup schedule is suspended, and failovers,
+// switchovers, scaling, updates and major upgrades wait until the cluster
+// is back, which is once every member is ready under a leader.  It returns
+// how soon to look again, zero if there is nothing to wait for.
+func (r *PostgreSQLClusterReconciler) reconcileHibernation(ctx context.Context, cluster *ramv1.PostgreSQLCluster) (time.Duration, error) {
+	before := cluster.Status.DeepCopy()
+	requeue, err := r.hibernationStep(ctx, cluster)
+	if err != nil {
+		return 0, err
+	}
+
+	if hibernation := cluster.Status.Hibernation; hibernation != nil {
+		condition := metav1.Condition{
+			Type:               ConditionHibernated,
+			Status:             metav1.ConditionFalse,
+			Reason:             hibernation.Phase,
+			Message:            hibernation.Message,
+			ObservedGeneration: cluster.Generation,
+		}
+		if hibernation.Phase == HibernationHibernated {
+			condition.Status = metav1.ConditionTrue
+		}
+		if condition.Message == "" {
+			condition.Message = "spec.hibernate is set"
+			if !cluster.Spec.Hibernate {
+				condition.Message = "spec.hibernate is unset"
+			}
+		}
+		meta.SetStatusCondition(&cluster.Status.Conditions, condition)
+	}
+
+	if equality.Semantic.DeepEqual(before, &cluster.Status) {
+		return requeue, nil
+	}
+	return requeue, r.Status().Update(ctx, cluster)
+}
+
+// hibernationStep takes the next step of the hibernation or resumption,
+// recording it in Status.Hibernation, and returns how soon to look again
+func (r *PostgreSQLClusterReconciler) hibernationStep(ctx context.Context, cluster *ramv1.PostgreSQLCluster) (time.Duration, error) {
+	hibernation := cluster.Status.Hibernation
+	first := podName(cluster, 0)
+
+	if !cluster.Spec.Hibernate {
+		if hibernation == nil || hibernation.Phase == HibernationResumed {
+			return 0, nil
+		}
+		if hibernation.Phase != HibernationResuming {
+			hibernation.Phase = HibernationResuming
+			hibernation.SwitchoverStartedAt = nil
+			r.event(cluster, corev1.EventTypeNormal, "Resuming", "starting %d members", cluster.Spec.Replicas)
+		}
+		// The members start in order from the first pod, which leads again
+		ready := int32(0)
+		for ordinal := int32(0); ordinal < cluster.Spec.Replicas; ordinal++ {
+			ok, err := r.podReady(ctx, cluster, podName(cluster, ordinal))
+			if err != nil {
+				return 0, err
+			}
+			if ok {
+				ready++
+			}
+		}
+		if ready < cluster.Spec.Replicas || !meta.IsStatusConditionTrue(cluster.Status.Conditions, ConditionLeaderKnown) {
+			hibernation.Message = fmt.Sprintf("%d of %d members ready", ready, cluster.Spec.Replicas)
+			return hibernationRequeue, nil
+		}
+		now := metav1.Now()
+		hibernation.Phase = HibernationResumed
+		hibernation.Message = fmt.Sprintf("%s is the leader", cluster.Status.Leader)
+		hibernation.ResumedAt = &now
+		r.event(cluster, corev1.EventTypeNormal, "Resumed", "resumed with %d members, %s leading",
+			cluster.Spec.Replicas, cluster.Status.Leader)
+		return 0, nil
+	}
+
+	if hibernation == nil || hibernation.Phase == HibernationResuming || hibernation.Phase == HibernationResumed {
+		hibernation = &ramv1.HibernationStatus{
+			Phase:     HibernationSwitchingOver,
+			StartedAt: metav1.Now(),
+		}
+		cluster.Status.Hibernation = hibernation
+		r.event(cluster, corev1.EventTypeNormal, "Hibernating", "stopping every pod of the cluster")
+	}
+
+	switch hibernation.Phase {
+	case HibernationSwitchingOver:
+		switch {
+		case failoverInProgress(cluster):
+			hibernation.Message = "waiting for the failover"
+			return hibernationRequeue, nil
+		case switchoverInProgress(cluster):
+			hibernation.Message = "waiting for the switchover"
+			return hibernationRequeue, nil
+		case majorUpgradeCuttingOver(cluster):
+			hibernation.Message = "waiting for the major upgrade"
+			return hibernationRequeue, nil
+		}
+		leader := cluster.Status.Leader
+		if leader != "" && leader != first && podCount(cluster) > 1 && !standbyActive(cluster) {
+			if hibernation.SwitchoverStartedAt == nil {
+				if err := r.switchoverTo(ctx, cluster, first); err != nil {
+					hibernation.Message = fmt.Sprintf("transferring leadership to %s: %v", first, err)
+					return failoverRequeue, nil
+				}
+				now := metav1.Now()
+				hibernation.SwitchoverStartedAt = &now
+			}
+			if time.Since(hibernation.SwitchoverStartedAt.Time) < switchoverTimeout {
+				hibernation.Message = fmt.Sprintf("waiting for RAMD to report %s as the leader", first)
+				return failoverRequeue, nil
+			}
+			r.event(cluster, corev1.EventTypeWarning, "HibernationSwitchoverTimedOut",
+				"%s did not become the leader within %v; stopping with %s leading", first, switchoverTimeout, leader)
+		}
+		hibernation.Phase = HibernationStopping
+		hibernation.Message = "stopping the members"
+		return hibernationRequeue, nil
+
+	case HibernationStopping:
+		message, err := r.membersStopped(ctx, cluster)
+		if err != nil {
+			return 0, err
+		}
+		if message != "" {
+			hibernation.Message = message
+			return hibernationRequeue, nil
+		}
+		now := metav1.Now()
+		hibernation.Phase = HibernationHibernated
+		hibernation.Message = ""
+		hibernation.HibernatedAt = &now
+		r.event(cluster, corev1.EventTypeNormal, "Hibernated", "every pod of the cluster is stopped")
+	}
+	return 0, nil
+}
@@ -0,0 +1,268 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ramv1 "github.com/pgelephant/pgraft/k8s/operator/api/v1"
+)
+
+// ConditionInitialized is true once the roles, databases and SQL scripts
+// of spec.bootstrap have been applied to the new cluster; it is only set
+// if there are any
+const ConditionInitialized = "Initialized"
+
+// Reasons of the Initialized condition
+const (
+	InitializationPending = "Pending"
+	InitializationRunning = "Initializing"
+	InitializationFailed  = "InitializationFailed"
+	InitializationDone    = "Initialized"
+)
+
+// sqlScriptsDir is where the ConfigMaps of spec.bootstrap.sqlScripts are
+// mounted, each in a directory named after its index
+const sqlScriptsDir = "/etc/ram-bootstrap"
+
+// initializationName returns the name of the Job initializing the cluster
+func initializationName(cluster *ramv1.PostgreSQLCluster) string {
+	return cluster.Name + "-initialize"
+}
+
+// initdbArgs returns the options of spec.bootstrap.initdb as initdb takes
+// them, for POSTGRES_INITDB_ARGS of the image's entrypoint
+func initdbArgs(cluster *ramv1.PostgreSQLCluster) string {
+	if cluster.Spec.Bootstrap == nil || cluster.Spec.Bootstrap.InitDB == nil {
+		return ""
+	}
+	initdb := cluster.Spec.Bootstrap.InitDB
+
+	var args []string
+	for _, option := range []struct{ name, value string }{
+		{"--encoding", initdb.Encoding},
+		{"--locale", initdb.Locale},
+		{"--lc-collate", initdb.LCCollate},
+		{"--lc-ctype", initdb.LCCtype},
+	} {
+		if option.value != "" {
+			args = append(args, option.name+"="+option.value)
+		}
+	}
+	if initdb.DataChecksums != nil {
+		// initdb checksums the data by default from PostgreSQL 18, and
+		// only from then takes the option not to
+		major, _ := majorVersion(cluster.Spec.PostgreSQL.Version)
+		switch {
+		case *initdb.DataChecksums:
+			args = append(args, "--data-checksums")
+		case major >= 18:
+			args = append(args, "--no-data-checksums")
+		}
+	}
+	if initdb.WALSegmentSize > 0 {
+		args = append(args, fmt.Sprintf("--wal-segsize=%d", initdb.WALSegmentSize))
+	}
+	return strings.Join(args, " ")
+}
+
+// addInitDB has the members initdb an empty data directory with the
+// options of spec.bootstrap.initdb
+func addInitDB(cluster *ramv1.PostgreSQLCluster, spec *corev1.PodSpec) {
+	args := initdbArgs(cluster)
+	if args == "" {
+		return
+	}
+	postgresql := &spec.Containers[0]
+	postgresql.Env = append(postgresql.Env, corev1.EnvVar{Name: "POSTGRES_INITDB_ARGS", Value: args})
+}
+
+// initializationPending reports whether the new cluster has roles,
+// databases or SQL scripts of spec.bootstrap still to apply.  A cluster
+// created from existing data is not initialized.
+func initializationPending(cluster *ramv1.PostgreSQLCluster) bool {
+	bootstrap := cluster.Spec.Bootstrap
+	if bootstrap == nil || len(bootstrap.Roles)+len(bootstrap.Databases)+len(bootstrap.SQLScripts) == 0 {
+		return false
+	}
+	if cluster.Spec.Restore != nil || cluster.Spec.DataSource != nil || cluster.Spec.Standby != nil {
+		return false
+	}
+	return !meta.IsStatusConditionTrue(cluster.Status.Conditions, ConditionInitialized)
+}
+
+// initializationScript returns the script of the initialization Job: the
+// roles and then the databases of spec.bootstrap are created in one psql
+// session, as spec.managedRoles and spec.managedDatabases are, and the SQL
+// scripts run after in their databases, stopping at the first error
+func initializationScript(cluster *ramv1.PostgreSQLCluster) string {
+	bootstrap := cluster.Spec.Bootstrap
+
+	var script strings.Builder
+	fmt.Fprintf(&script, "set -e\nexport PGHOST=%s.%s.svc.cluster.local PGPORT=%d PGUSER=postgres\n",
+		roleServiceName(cluster, RolePrimary), cluster.Namespace, cluster.Spec.Networking.Ports.PostgreSQL)
+
+	if len(bootstrap.Roles)+len(bootstrap.Databases) > 0 {
+		command := "psql -v ON_ERROR_STOP=1 -d postgres"
+		var sql strings.Builder
+		for i, role := range bootstrap.Roles {
+			if role.PasswordSecret != "" {
+				command += fmt.Sprintf(` -v "password_%d=$PASSWORD_%d"`, i, i)
+			}
+			sql.WriteString(managedRoleSQL(role, i))
+		}
+		for _, database := range bootstrap.Databases {
+			sql.WriteString(managedDatabaseSQL(database))
+		}
+		fmt.Fprintf(&script, "echo \"creating the roles and databases\"\n%s <<'EOSQL'\n%sEOSQL\n", command, sql.String())
+	}
+
+	// The database is a plain identifier, which the CRD enforces
+	for i, sqlScript := range bootstrap.SQLScripts {
+		fmt.Fprintf(&script, `for script in %[1]s/%[2]d/*; do
+  echo "running $script"
+  psql -v ON_ERROR_STOP=1 -d %[3]s -f "$script"
+done
+`, sqlScriptsDir, i, sqlScript.Database)
+	}
+	return script.String()
+}
+
+// initializationJobSpec returns the spec of the Job initializing the
+// cluster.  Its scripts may not run twice safely, so a failed Job is not
+// retried; deleting it runs it again.
+func initializationJobSpec(cluster *ramv1.PostgreSQLCluster) batchv1.JobSpec {
+	bootstrap := cluster.Spec.Bootstrap
+	labels := map[string]string{
+		"app":       "postgresql-cluster",
+		"cluster":   cluster.Name,
+		"component": "initialize",
+	}
+	backoffLimit := int32(0)
+	automountToken := false
+
+	container := corev1.Container{
+		Name:    "initialize",
+		Image:   postgresqlImage(cluster),
+		Command: []string{"/bin/sh", "-c", initializationScript(cluster)},
+		Env: []corev1.EnvVar{
+			{
+				Name: "PGPASSWORD",
+				ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: credentialsSecretName(cluster)},
+						Key:                  postgresPasswordKey,
+					},
+				},
+			},
+		},
+	}
+	for i, role := range bootstrap.Roles {
+		if role.PasswordSecret == "" {
+			continue
+		}
+		container.Env = append(container.Env, corev1.EnvVar{
+			Name: fmt.Sprintf("PASSWORD_%d", i),
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: role.PasswordSecret},
+					Key:                  managedPasswordKey,
+				},
+			},
+		})
+	}
+
+	spec := corev1.PodSpec{
+		ImagePullSecrets:             cluster.Spec.ImagePullSecrets,
+		RestartPolicy:                corev1.RestartPolicyNever,
+		AutomountServiceAccountToken: &automountToken,
+	}
+	for i, sqlScript := range bootstrap.SQLScripts {
+		// The keys are numbered so that they run in the order given
+		var items []corev1.KeyToPath
+		for j, key := range sqlScript.Keys {
+			items = append(items, corev1.KeyToPath{Key: key, Path: fmt.Sprintf("%03d-%s", j, key)})
+		}
+		name := fmt.Sprintf("sql-scripts-%d", i)
+		spec.Volumes = append(spec.Volumes, corev1.Volume{
+			Name: name,
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: sqlScript.ConfigMap},
+					Items:                items,
+				},
+			},
+		})
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      name,
+			MountPath: fmt.Sprintf("%s/%d", sqlScriptsDir, i),
+			ReadOnly:  true,
+		})
+	}
+	spec.Containers = []corev1.Container{container}
+	addSecurityContext(cluster, &spec, cluster.Spec.PostgreSQL.PodSecurityContext)
+
+	return batchv1.JobSpec{
+		BackoffLimit: &backoffLimit,
+		Template: corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{Labels: labels},
+			Spec:       spec,
+		},
+	}
+}
+
+// reconcileInitialization applies the roles, databases and SQL scripts of
+// spec.bootstrap to a new cluster with a Job, once, and records the
+// outcome in the Initialized condition.  It waits for the cluster to have
+// a writable primary: a leader, and not hibernating.
+func (r *PostgreSQLClusterReconciler) reconcileInitialization(ctx context.Context, cluster *ramv1.PostgreSQLCluster) error {
+	if !initializationPending(cluster) {
+		return nil
+	}
+
+	before := cluster.Status.DeepCopy()
+	condition := metav1.Condition{
+		Type:               ConditionInitialized,
+		Status:             metav1.ConditionFalse,
+		Reason:             InitializationPending,
+		Message:            "waiting for a primary",
+		ObservedGeneration: cluster.Generation,
+	}
+	if cluster.Status.Leader != "" && !hibernationInProgress(cluster) {
+		name := initializationName(cluster)
+		result, err := r.runJob(ctx, cluster, name, initializationJobSpec(cluster))
+		if err != nil {
+			return err
+		}
+		switch result {
+		case BackupSucceeded:
+			condition.Status = metav1.ConditionTrue
+			condition.Reason = InitializationDone
+			condition.Message = fmt.Sprintf("%s applied spec.bootstrap", name)
+		case BackupFailed:
+			condition.Reason = InitializationFailed
+			condition.Message = fmt.Sprintf("%s failed; see its logs, and delete it to run it again", name)
+		default:
+			condition.Reason = InitializationRunning
+			condition.Message = fmt.Sprintf("%s is applying spec.bootstrap", name)
+		}
+	}
+	meta.SetStatusCondition(&cluster.Status.Conditions, condition)
+
+	if equality.Semantic.DeepEqual(before, &cluster.Status) {
+		return nil
+	}
+	switch condition.Reason {
+	case InitializationDone:
+		r.event(cluster, corev1.EventTypeNormal, "Initialized", "%s", condition.Message)
+	case InitializationFailed:
+		r.event(cluster, corev1.EventTypeWarning, "InitializationFailed", "%s", condition.Message)
+	}
+	return r.Status().Update(ctx, cluster)
+}
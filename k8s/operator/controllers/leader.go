@@ -0,0 +1,272 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	ramv1 "github.com/pgelephant/pgraft/k8s/operator/api/v1"
+)
+
+// ConditionLeaderKnown is true while RAMD reports a raft leader
+const ConditionLeaderKnown = "LeaderKnown"
+
+// ramdTimeout bounds each request to the RAMD API
+const ramdTimeout = 5 * time.Second
+
+// ramdClusterStatus is the part of GET /api/v1/cluster/status the operator reads
+type ramdClusterStatus struct {
+	PrimaryNodeID int32 `json:"primary_node_id"`
+	HasQuorum     bool  `json:"has_quorum"`
+}
+
+// ramdNode is the part of a node in GET /api/v1/nodes the operator reads
+type ramdNode struct {
+	NodeID   int32  `json:"node_id"`
+	Hostname string `json:"hostname"`
+}
+
+// ramdNodeList is the reply to GET /api/v1/nodes
+type ramdNodeList struct {
+	Data struct {
+		Nodes []ramdNode `json:"nodes"`
+	} `json:"data"`
+}
+
+// errNoLeader is returned while the cluster has no raft leader, e.g. during an election
+var errNoLeader = fmt.Errorf("no raft leader")
+
+// errNoQuorum is returned while too few members are up to elect a leader
+var errNoQuorum = fmt.Errorf("no raft quorum")
+
+// ramdBaseURL returns the URL of the cluster's RAMD service
+func ramdBaseURL(cluster *ramv1.PostgreSQLCluster) string {
+	return fmt.Sprintf("http://%s-ramd.%s.svc.cluster.local:%d",
+		cluster.Name, cluster.Namespace, cluster.Spec.Networking.Ports.RAMD)
+}
+
+// podHost returns the DNS name of one PostgreSQL pod
+func podHost(cluster *ramv1.PostgreSQLCluster, pod string) string {
+	return fmt.Sprintf("%s.%s-postgresql.%s.svc.cluster.local", pod, cluster.Name, cluster.Namespace)
+}
+
+// podEndpoint returns the address of one PostgreSQL pod
+func podEndpoint(cluster *ramv1.PostgreSQLCluster, pod string) string {
+	return fmt.Sprintf("%s:%d", podHost(cluster, pod), cluster.Spec.Networking.Ports.PostgreSQL)
+}
+
+// ramdToken returns the token of the RAMD API in the credentials Secret;
+// empty if the Secret holds none, for a ramd that requires no
+// authentication
+func (r *PostgreSQLClusterReconciler) ramdToken(ctx context.Context, cluster *ramv1.PostgreSQLCluster) (string, error) {
+	credentials := &corev1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{Name: credentialsSecretName(cluster), Namespace: cluster.Namespace}, credentials); err != nil {
+		return "", fmt.Errorf("credentials Secret: %w", err)
+	}
+	return strings.TrimSpace(string(credentials.Data[ramdTokenKey])), nil
+}
+
+// callRAMD sends a request to the RAMD API, with body as JSON unless it is
+// nil, and decodes the JSON reply into out unless out is nil.  The request
+// carries the token of the credentials Secret, if any, as its
+// Authorization header, which ramd compares with its token as is.
+func (r *PostgreSQLClusterReconciler) callRAMD(ctx context.Context, cluster *ramv1.PostgreSQLCluster, method, path string, body, out interface{}) error {
+	client := r.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: ramdTimeout}
+	}
+	token, err := r.ramdToken(ctx, cluster)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, ramdTimeout)
+	defer cancel()
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, ramdBaseURL(cluster)+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+// podForHostname returns the PostgreSQL pod a RAMD hostname names, if any;
+// the hostname is the pod name or its DNS name
+func podForHostname(cluster *ramv1.PostgreSQLCluster, hostname string) (string, bool) {
+	pod, _, _ := strings.Cut(hostname, ".")
+	ordinal, ok := podOrdinal(cluster, pod)
+	if !ok || ordinal >= podCount(cluster) {
+		return "", false
+	}
+	return pod, true
+}
+
+// queryLeader asks RAMD for the raft leader and returns the name of its pod.
+// The leader's node is matched to a pod by the hostname RAMD lists for it,
+// falling back to pod N running node N+1.
+func (r *PostgreSQLClusterReconciler) queryLeader(ctx context.Context, cluster *ramv1.PostgreSQLCluster) (string, error) {
+	var status ramdClusterStatus
+	if err := r.callRAMD(ctx, cluster, http.MethodGet, "/api/v1/cluster/status", nil, &status); err != nil {
+		return "", err
+	}
+	if !status.HasQuorum {
+		return "", errNoQuorum
+	}
+	if status.PrimaryNodeID <= 0 {
+		return "", errNoLeader
+	}
+
+	var nodes ramdNodeList
+	if err := r.callRAMD(ctx, cluster, http.MethodGet, "/api/v1/nodes", nil, &nodes); err != nil {
+		return "", err
+	}
+	for _, node := range nodes.Data.Nodes {
+		if node.NodeID != status.PrimaryNodeID {
+			continue
+		}
+		if pod, ok := podForHostname(cluster, node.Hostname); ok {
+			return pod, nil
+		}
+	}
+	if status.PrimaryNodeID > podCount(cluster) {
+		return "", fmt.Errorf("leader is node %d, which runs on no pod of the %d replicas",
+			status.PrimaryNodeID, podCount(cluster))
+	}
+	return fmt.Sprintf("%s-postgresql-%d", cluster.Name, status.PrimaryNodeID-1), nil
+}
+
+// nodeForPod returns the RAMD node running on pod, matched the same way as
+// in queryLeader; a node not listed by RAMD gets the pod name as hostname
+func (r *PostgreSQLClusterReconciler) nodeForPod(ctx context.Context, cluster *ramv1.PostgreSQLCluster, pod string) (ramdNode, error) {
+	var nodes ramdNodeList
+	if err := r.callRAMD(ctx, cluster, http.MethodGet, "/api/v1/nodes", nil, &nodes); err != nil {
+		return ramdNode{}, err
+	}
+	for _, node := range nodes.Data.Nodes {
+		if name, ok := podForHostname(cluster, node.Hostname); ok && name == pod {
+			return node, nil
+		}
+	}
+	ordinal, ok := podOrdinal(cluster, pod)
+	if !ok {
+		return ramdNode{}, fmt.Errorf("%s is not a PostgreSQL pod of the cluster", pod)
+	}
+	return ramdNode{NodeID: ordinal + 1, Hostname: pod}, nil
+}
+
+// updateLeader sets Status.Leader and Status.Endpoints from the leader RAMD
+// reports, or from the failover or switchover in progress.  If RAMD cannot be reached the
+// last known leader is kept, so a brief outage of the API does not move the
+// endpoints; the LeaderKnown condition says whether the leader is current.
+func (r *PostgreSQLClusterReconciler) updateLeader(ctx context.Context, cluster *ramv1.PostgreSQLCluster) error {
+	condition := metav1.Condition{
+		Type:               ConditionLeaderKnown,
+		Status:             metav1.ConditionTrue,
+		Reason:             "RAMDReported",
+		ObservedGeneration: cluster.Generation,
+	}
+
+	var err error
+	if failoverInProgress(cluster) {
+		// The failover decides the leader until it ends
+		cluster.Status.Leader = cluster.Status.Failover.NewLeader
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "FailoverInProgress"
+		condition.Message = fmt.Sprintf("failing over from %s", cluster.Status.Failover.OldLeader)
+	} else if switchoverInProgress(cluster) {
+		// No pod is the leader until the target is confirmed as it
+		cluster.Status.Leader = ""
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "SwitchoverInProgress"
+		condition.Message = fmt.Sprintf("switching over to %s", cluster.Status.Switchover.TargetPod)
+	} else if cluster.Status.ReadyReplicas == 0 {
+		cluster.Status.Leader = ""
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "NoReadyReplicas"
+		condition.Message = "no PostgreSQL pod is ready"
+	} else if leader, qerr := r.queryLeader(ctx, cluster); qerr == nil {
+		cluster.Status.Leader = leader
+		condition.Message = fmt.Sprintf("%s is the raft leader", leader)
+	} else if qerr == errNoLeader {
+		cluster.Status.Leader = ""
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "NoLeader"
+		condition.Message = "the cluster has no raft leader"
+	} else if qerr == errNoQuorum {
+		cluster.Status.Leader = ""
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "NoQuorum"
+		condition.Message = "too few members are up to elect a raft leader"
+	} else {
+		err = qerr
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "RAMDUnavailable"
+		condition.Message = qerr.Error()
+	}
+	meta.SetStatusCondition(&cluster.Status.Conditions, condition)
+
+	updateEndpoints(cluster)
+	return err
+}
+
+// updateEndpoints sets Status.Endpoints for the current leader
+func updateEndpoints(cluster *ramv1.PostgreSQLCluster) {
+	// The primary endpoint follows the leader; without one it is the
+	// service, which reaches whichever pod is ready
+	cluster.Status.Endpoints.Primary = fmt.Sprintf("%s-postgresql.%s.svc.cluster.local:%d",
+		cluster.Name, cluster.Namespace, cluster.Spec.Networking.Ports.PostgreSQL)
+	if cluster.Status.Leader != "" {
+		cluster.Status.Endpoints.Primary = podEndpoint(cluster, cluster.Status.Leader)
+	}
+
+	cluster.Status.Endpoints.ReadWrite = fmt.Sprintf("%s.%s.svc.cluster.local:%d",
+		roleServiceName(cluster, RolePrimary), cluster.Namespace, cluster.Spec.Networking.Ports.PostgreSQL)
+	cluster.Status.Endpoints.ReadOnly = fmt.Sprintf("%s.%s.svc.cluster.local:%d",
+		roleServiceName(cluster, RoleReplica), cluster.Namespace, cluster.Spec.Networking.Ports.PostgreSQL)
+
+	cluster.Status.Endpoints.Replicas = []string{}
+	for i := int32(0); i < cluster.Spec.Replicas; i++ {
+		pod := fmt.Sprintf("%s-postgresql-%d", cluster.Name, i)
+		if pod == cluster.Status.Leader {
+			continue
+		}
+		cluster.Status.Endpoints.Replicas = append(cluster.Status.Endpoints.Replicas, podEndpoint(cluster, pod))
+	}
+}
@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ramv1 "github.com/pgelephant/pgraft/k8s/operator/api/v1"
+)
+
+// ConditionMaintenanceWindowOpen is true while spec.maintenanceWindow lets
+// disruptive operations run; it is only set while a window is configured
+const ConditionMaintenanceWindowOpen = "MaintenanceWindowOpen"
+
+// defaultMaintenanceWindowDuration is how long a window stays open if
+// spec.maintenanceWindow.duration is unset
+const defaultMaintenanceWindowDuration = time.Hour
+
+// maintenanceWindow reports whether a window of spec.maintenanceWindow is
+// open at now, and when it closes if it is, or when the next one opens
+func maintenanceWindow(window *ramv1.MaintenanceWindowSpec, now time.Time) (bool, time.Time, error) {
+	schedule, err := cron.ParseStandard(window.Schedule)
+	if err != nil {
+		return false, time.Time{}, fmt.Errorf("spec.maintenanceWindow.schedule: %w", err)
+	}
+	duration, err := specDuration(window.Duration, defaultMaintenanceWindowDuration)
+	if err != nil {
+		return false, time.Time{}, fmt.Errorf("spec.maintenanceWindow.duration: %w", err)
+	}
+
+	// The window open at now, if any, started within the last duration
+	start := schedule.Next(now.Add(-duration))
+	if !start.After(now) {
+		return true, start.Add(duration), nil
+	}
+	return false, start, nil
+}
+
+// maintenanceAllowed reports whether disruptive operations may run now:
+// always, unless spec.maintenanceWindow holds them until its next window
+func maintenanceAllowed(cluster *ramv1.PostgreSQLCluster) bool {
+	return cluster.Spec.MaintenanceWindow == nil ||
+		meta.IsStatusConditionTrue(cluster.Status.Conditions, ConditionMaintenanceWindowOpen)
+}
+
+// reconcileMaintenanceWindow sets the MaintenanceWindowOpen condition from
+// spec.maintenanceWindow, or removes it while no window is configured.  It
+// returns how long until the window opens or closes, so that the operations
+// waiting for it are looked at again then.
+func (r *PostgreSQLClusterReconciler) reconcileMaintenanceWindow(ctx context.Context, cluster *ramv1.PostgreSQLCluster) (time.Duration, error) {
+	before := cluster.Status.DeepCopy()
+	requeue := time.Duration(0)
+
+	if window := cluster.Spec.MaintenanceWindow; window == nil {
+		meta.RemoveStatusCondition(&cluster.Status.Conditions, ConditionMaintenanceWindowOpen)
+	} else {
+		open, at, err := maintenanceWindow(window, time.Now())
+		if err != nil {
+			return 0, err
+		}
+		condition := metav1.Condition{
+			Type:               ConditionMaintenanceWindowOpen,
+			Status:             metav1.ConditionFalse,
+			Reason:             "OutsideWindow",
+			Message:            fmt.Sprintf("the next window opens at %s", at.UTC().Format(time.RFC3339)),
+			ObservedGeneration: cluster.Generation,
+		}
+		if open {
+			condition.Status = metav1.ConditionTrue
+			condition.Reason = "InsideWindow"
+			condition.Message = fmt.Sprintf("the window closes at %s", at.UTC().Format(time.RFC3339))
+		}
+		if meta.IsStatusConditionTrue(before.Conditions, ConditionMaintenanceWindowOpen) != open {
+			r.event(cluster, corev1.EventTypeNormal, "MaintenanceWindow", "%s", condition.Message)
+		}
+		meta.SetStatusCondition(&cluster.Status.Conditions, condition)
+		requeue = time.Until(at) + time.Second
+	}
+
+	if equality.Semantic.DeepEqual(before, &cluster.Status) {
+		return requeue, nil
+	}
+	return requeue, r.Status().Update(ctx, cluster)
+}
@@ -0,0 +1,635 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	ramv1 "github.com/pgelephant/pgraft/k8s/operator/api/v1"
+)
+
+// Phases of a major upgrade.  The check runs while the cluster serves;
+// the cutover, from Stopping to Starting, takes it down.
+const (
+	MajorUpgradeAwaitingCheck   = "AwaitingCheck"
+	MajorUpgradeChecking        = "Checking"
+	MajorUpgradeCheckFailed     = "CheckFailed"
+	MajorUpgradeAwaitingCutover = "AwaitingCutover"
+	MajorUpgradeSwitchingOver   = "SwitchingOver"
+	MajorUpgradeStopping        = "Stopping"
+	MajorUpgradeUpgrading       = "Upgrading"
+	MajorUpgradeStarting        = "Starting"
+	MajorUpgradeCompleted       = "Completed"
+	MajorUpgradeRollingBack     = "RollingBack"
+	MajorUpgradeRolledBack      = "RolledBack"
+	MajorUpgradeWithdrawn       = "Withdrawn"
+)
+
+// ConditionMajorUpgradeInProgress is true from when a new major version is
+// asked for until the cluster runs it or the upgrade is withdrawn; its
+// reason is the phase of the upgrade
+const ConditionMajorUpgradeInProgress = "MajorUpgradeInProgress"
+
+// majorUpgradeRequeue is how often a major upgrade in progress is advanced
+const majorUpgradeRequeue = 10 * time.Second
+
+// majorUpgradeStartTimeout bounds how long the upgraded first pod may take
+// to become the leader before the cluster rolls back
+const majorUpgradeStartTimeout = 10 * time.Minute
+
+// majorVersion returns the major version of a PostgreSQL version such as
+// "17" or "16.4", and whether it is one
+func majorVersion(version string) (int, bool) {
+	major, _, _ := strings.Cut(version, ".")
+	n, err := strconv.Atoi(major)
+	return n, err == nil && n > 0
+}
+
+// postgresqlImage returns the image the members run: spec.postgresql.image
+// but during a major upgrade, whose new image waits for the cutover
+func postgresqlImage(cluster *ramv1.PostgreSQLCluster) string {
+	if cluster.Status.PostgreSQLImage != "" {
+		return cluster.Status.PostgreSQLImage
+	}
+	return cluster.Spec.PostgreSQL.Image
+}
+
+// upgradedDataDirectory returns the data directory a major upgrade to
+// major puts next to the old one
+func upgradedDataDirectory(major int) string {
+	return fmt.Sprintf("%s/pgdata-%d", dataVolumePath, major)
+}
+
+// dataClaimName returns the name of the data volume claim of a pod
+func dataClaimName(pod string) string {
+	return "postgresql-data-" + pod
+}
+
+// majorUpgradeInProgress reports whether a major upgrade has been asked for
+// and has neither completed nor been withdrawn
+func majorUpgradeInProgress(cluster *ramv1.PostgreSQLCluster) bool {
+	upgrade := cluster.Status.MajorUpgrade
+	return upgrade != nil && upgrade.Phase != MajorUpgradeCompleted && upgrade.Phase != MajorUpgradeWithdrawn
+}
+
+// majorUpgradeCuttingOver reports whether a major upgrade is past the point
+// where it can be withdrawn: it only goes on to the new version or back to
+// the old one
+func majorUpgradeCuttingOver(cluster *ramv1.PostgreSQLCluster) bool {
+	if cluster.Status.MajorUpgrade == nil {
+		return false
+	}
+	switch cluster.Status.MajorUpgrade.Phase {
+	case MajorUpgradeStopping, MajorUpgradeUpgrading, MajorUpgradeStarting, MajorUpgradeRollingBack:
+		return true
+	}
+	return false
+}
+
+// majorUpgradeStopped reports whether the cutover of a major upgrade needs
+// every member stopped
+func majorUpgradeStopped(cluster *ramv1.PostgreSQLCluster) bool {
+	return majorUpgradeCuttingOver(cluster) && cluster.Status.MajorUpgrade.Phase != MajorUpgradeStarting
+}
+
+// majorUpgradeCheckName returns the name of the Job checking a major
+// upgrade and of the volume it runs on
+func majorUpgradeCheckName(cluster *ramv1.PostgreSQLCluster) string {
+	return cluster.Name + "-major-upgrade-check"
+}
+
+// majorUpgradeCutoverName returns the name of the Job upgrading the data of
+// the first pod at the cutover
+func majorUpgradeCutoverName(cluster *ramv1.PostgreSQLCluster) string {
+	return cluster.Name + "-major-upgrade"
+}
+
+// majorUpgradeScript returns the script upgrading the data directory from,
+// of PostgreSQL fromMajor, into to for toMajor.  The old server first
+// recovers to a clean shutdown, as the volume may be a clone of a running
+// one, and tells the encoding and locale to initdb the new data directory
+// with.  The upgraded server must then start and open every database.
+// With link the old data directory is given up to spare the copy; without
+// it the old data stays as it was, to roll back to.  The script starts over
+// if it runs again.
+func majorUpgradeScript(fromMajor, toMajor int, from, to string, link bool) string {
+	mode := ""
+	if link {
+		mode = "--link"
+	}
+	// initdb checksums the data by default from PostgreSQL 18
+	noChecksums := ""
+	if toMajor >= 18 {
+		noChecksums = "--no-data-checksums"
+	}
+
+	return fmt.Sprintf(`set -e
+old=/usr/lib/postgresql/%[1]d/bin
+new=/usr/lib/postgresql/%[2]d/bin
+from=%[3]s
+to=%[4]s
+socket=/var/run/postgresql
+cd /tmp
+
+echo "recovering $from"
+rm -f "$from/postmaster.pid"
+"$old/pg_ctl" -D "$from" -w -t 3600 -o "-c listen_addresses='' -c unix_socket_directories=$socket" start
+set -- $("$old/psql" -h "$socket" -U postgres -d template1 -tA -F ' ' \
+  -c "SELECT pg_encoding_to_char(encoding), datcollate, datctype FROM pg_database WHERE datname = 'template1'")
+"$old/pg_ctl" -D "$from" -m fast -w stop
+
+checksums=--data-checksums
+if "$old/pg_controldata" "$from" | grep -q '^Data page checksum version: *0$'; then checksums=%[5]s; fi
+rm -rf "$to"
+"$new/initdb" -D "$to" -U postgres -E "$1" --lc-collate="$2" --lc-ctype="$3" $checksums
+
+echo "upgrading $from to $to"
+"$new/pg_upgrade" -b "$old" -B "$new" -d "$from" -D "$to" -U postgres -s "$socket" %[6]s
+cp "$from/postgresql.auto.conf" "$to/postgresql.auto.conf"
+
+echo "opening every database of $to"
+"$new/pg_ctl" -D "$to" -w -t 3600 -o "-c listen_addresses='' -c unix_socket_directories=$socket" start
+for db in $("$new/psql" -h "$socket" -U postgres -d postgres -tAc "SELECT datname FROM pg_database WHERE datallowconn"); do
+  "$new/psql" -h "$socket" -U postgres -d "$db" -v ON_ERROR_STOP=1 -c "SELECT 1" >/dev/null
+done
+"$new/pg_ctl" -D "$to" -m fast -w stop
+echo "upgraded"
+`, fromMajor, toMajor, from, to, noChecksums, mode)
+}
+
+// majorUpgradeJobSpec returns the spec of a Job running script in the
+// pg_upgrade image on the data volume claim.  It runs as the PostgreSQL
+// pods do, since pg_upgrade refuses to run as root.
+func majorUpgradeJobSpec(cluster *ramv1.PostgreSQLCluster, claim, script string) batchv1.JobSpec {
+	backoffLimit := int32(2)
+	spec := batchv1.JobSpec{
+		BackoffLimit: &backoffLimit,
+		Template: corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{
+				Labels: map[string]string{
+					"app":       "postgresql-cluster",
+					"cluster":   cluster.Name,
+					"component": "major-upgrade",
+				},
+			},
+			Spec: corev1.PodSpec{
+				ImagePullSecrets: cluster.Spec.ImagePullSecrets,
+				RestartPolicy:    corev1.RestartPolicyNever,
+				Containers: []corev1.Container{
+					{
+						Name:    "pg-upgrade",
+						Image:   cluster.Spec.PostgreSQL.MajorUpgrade.Image,
+						Command: []string{"/bin/sh", "-c", script},
+						VolumeMounts: []corev1.VolumeMount{
+							{Name: "postgresql-data", MountPath: dataVolumePath},
+						},
+						Resources: cluster.Spec.PostgreSQL.Resources,
+					},
+				},
+				Volumes: []corev1.Volume{
+					{
+						Name: "postgresql-data",
+						VolumeSource: corev1.VolumeSource{
+							PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim},
+						},
+					},
+				},
+			},
+		},
+	}
+	addSecurityContext(cluster, &spec.Template.Spec, cluster.Spec.PostgreSQL.PodSecurityContext)
+	return spec
+}
+
+// createCheckVolume creates the volume the check runs on, a clone of pod's
+// data volume, unless it exists
+func (r *PostgreSQLClusterReconciler) createCheckVolume(ctx context.Context, cluster *ramv1.PostgreSQLCluster, pod string) error {
+	source := &corev1.PersistentVolumeClaim{}
+	if err := r.Get(ctx, types.NamespacedName{Name: dataClaimName(pod), Namespace: cluster.Namespace}, source); err != nil {
+		return err
+	}
+	claim := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      majorUpgradeCheckName(cluster),
+			Namespace: cluster.Namespace,
+			Labels: map[string]string{
+				"app":       "postgresql-cluster",
+				"cluster":   cluster.Name,
+				"component": "major-upgrade",
+			},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			Resources:        source.Spec.Resources,
+			StorageClassName: source.Spec.StorageClassName,
+			DataSource: &corev1.TypedLocalObjectReference{
+				Kind: "PersistentVolumeClaim",
+				Name: source.Name,
+			},
+		},
+	}
+	if err := controllerutil.SetControllerReference(cluster, claim, r.Scheme); err != nil {
+		return err
+	}
+	if err := r.Create(ctx, claim); err != nil && !errors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+// deleteMajorUpgradeObjects deletes the Jobs of a major upgrade and the
+// volume of its check, so that a retried step runs them again
+func (r *PostgreSQLClusterReconciler) deleteMajorUpgradeObjects(ctx context.Context, cluster *ramv1.PostgreSQLCluster) error {
+	for _, name := range []string{majorUpgradeCheckName(cluster), majorUpgradeCutoverName(cluster)} {
+		job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: cluster.Namespace}}
+		err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground))
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	claim := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: majorUpgradeCheckName(cluster), Namespace: cluster.Namespace}}
+	if err := r.Delete(ctx, claim); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// setMajorUpgradePhase moves upgrade to phase
+func setMajorUpgradePhase(upgrade *ramv1.MajorUpgradeStatus, phase, message string) {
+	upgrade.Phase = phase
+	upgrade.Message = message
+	upgrade.PhaseStartedAt = metav1.Now()
+}
+
+// majorUpgradeBlocked returns what keeps a major upgrade from taking its
+// next step while the cluster serves, or an empty string
+func majorUpgradeBlocked(cluster *ramv1.PostgreSQLCluster) string {
+	switch {
+	case standbyActive(cluster):
+		return "a standby cluster is upgraded by creating it again from its upgraded primary"
+	case bootstrapPending(cluster):
+		return "waiting for the bootstrap"
+	case failoverInProgress(cluster):
+		return "waiting for the failover"
+	case switchoverInProgress(cluster):
+		return "waiting for the switchover"
+	case meta.IsStatusConditionTrue(cluster.Status.Conditions, ConditionScaling):
+		return "waiting for scaling"
+	case updateInProgress(cluster):
+		return "waiting for the update of the pods"
+	case hibernationInProgress(cluster):
+		return "waiting for the cluster to resume"
+	case cluster.Status.Leader == "":
+		return "waiting for a leader"
+	}
+	return ""
+}
+
+// switchoverTo asks RAMD to transfer the leadership to pod
+func (r *PostgreSQLClusterReconciler) switchoverTo(ctx context.Context, cluster *ramv1.PostgreSQLCluster, pod string) error {
+	node, err := r.nodeForPod(ctx, cluster, pod)
+	if err != nil {
+		return err
+	}
+	return r.callRAMD(ctx, cluster, http.MethodPost, "/api/v1/cluster/switchover",
+		ramdSwitchoverRequest{TargetNode: node.Hostname}, nil)
+}
+
+// reconcileMajorUpgrade carries a raised spec.postgresql.version through
+// pg_upgrade.  The members keep running the version and image in the
+// status until the cutover.  First pg_upgrade runs on a clone of the
+// leader's volume; then the leadership moves to the first pod, every
+// member stops, the first pod's data is upgraded next to the old data,
+// and the members start on the new version, the replicas being rebuilt
+// from the first pod.  If the upgrade fails or the first pod does not
+// lead within majorUpgradeStartTimeout, the members stop again and start
+// on the old version and data.  Each of the check and the cutover waits
+// for its approval in spec.postgresql.majorUpgrade, and the cutover for the
+// maintenance window.  It returns how soon to look again, zero if there is
+// nothing to wait for.
+func (r *PostgreSQLClusterReconciler) reconcileMajorUpgrade(ctx context.Context, cluster *ramv1.PostgreSQLCluster) (time.Duration, error) {
+	before := cluster.Status.DeepCopy()
+	requeue, err := r.majorUpgradeStep(ctx, cluster)
+	if err != nil {
+		return 0, err
+	}
+
+	if upgrade := cluster.Status.MajorUpgrade; upgrade != nil {
+		condition := metav1.Condition{
+			Type:               ConditionMajorUpgradeInProgress,
+			Status:             metav1.ConditionFalse,
+			Reason:             upgrade.Phase,
+			Message:            fmt.Sprintf("PostgreSQL %s to %s", upgrade.FromVersion, upgrade.ToVersion),
+			ObservedGeneration: cluster.Generation,
+		}
+		if majorUpgradeInProgress(cluster) {
+			condition.Status = metav1.ConditionTrue
+		}
+		if upgrade.Message != "" {
+			condition.Message += ": " + upgrade.Message
+		}
+		meta.SetStatusCondition(&cluster.Status.Conditions, condition)
+	}
+
+	if equality.Semantic.DeepEqual(before, &cluster.Status) {
+		return requeue, nil
+	}
+	return requeue, r.Status().Update(ctx, cluster)
+}
+
+// majorUpgradeStep takes the next step of the major upgrade, recording it
+// in Status.MajorUpgrade, and returns how soon to look again
+func (r *PostgreSQLClusterReconciler) majorUpgradeStep(ctx context.Context, cluster *ramv1.PostgreSQLCluster) (time.Duration, error) {
+	spec := &cluster.Spec.PostgreSQL
+	status := &cluster.Status
+	if status.PostgreSQLVersion == "" {
+		status.PostgreSQLVersion = spec.Version
+		status.PostgreSQLImage = spec.Image
+	}
+	target, targetOK := majorVersion(spec.Version)
+	running, runningOK := majorVersion(status.PostgreSQLVersion)
+	upgrade := status.MajorUpgrade
+
+	if !majorUpgradeCuttingOver(cluster) && (!targetOK || !runningOK || target <= running) {
+		// An upgrade that has not cut over is withdrawn by going back
+		if majorUpgradeInProgress(cluster) {
+			if err := r.deleteMajorUpgradeObjects(ctx, cluster); err != nil {
+				return 0, err
+			}
+			now := metav1.Now()
+			setMajorUpgradePhase(upgrade, MajorUpgradeWithdrawn,
+				fmt.Sprintf("spec.postgresql.version went back to %s", spec.Version))
+			upgrade.CompletedAt = &now
+			r.event(cluster, corev1.EventTypeNormal, "MajorUpgradeWithdrawn", "withdrew the upgrade to PostgreSQL %s",
+				upgrade.ToVersion)
+		}
+		if targetOK && runningOK && target < running {
+			return 0, fmt.Errorf("spec.postgresql.version: cannot downgrade from PostgreSQL %d", running)
+		}
+		// Minor versions and images are rolled out as the spec changes
+		status.PostgreSQLVersion = spec.Version
+		status.PostgreSQLImage = spec.Image
+		return 0, nil
+	}
+
+	if !majorUpgradeCuttingOver(cluster) {
+		if !majorUpgradeInProgress(cluster) || upgrade.ToVersion != spec.Version {
+			if err := r.deleteMajorUpgradeObjects(ctx, cluster); err != nil {
+				return 0, err
+			}
+			now := metav1.Now()
+			upgrade = &ramv1.MajorUpgradeStatus{
+				FromVersion:       status.PostgreSQLVersion,
+				FromImage:         status.PostgreSQLImage,
+				FromDataDirectory: dataDirectory(cluster),
+				ToVersion:         spec.Version,
+				Phase:             MajorUpgradeAwaitingCheck,
+				StartedAt:         now,
+				PhaseStartedAt:    now,
+			}
+			status.MajorUpgrade = upgrade
+			r.event(cluster, corev1.EventTypeNormal, "MajorUpgradeRequested", "upgrade from PostgreSQL %s to %s requested",
+				upgrade.FromVersion, upgrade.ToVersion)
+		}
+		upgrade.ToImage = spec.Image
+	}
+
+	from, _ := majorVersion(upgrade.FromVersion)
+	to, _ := majorVersion(upgrade.ToVersion)
+	approval := &spec.MajorUpgrade
+	first := podName(cluster, 0)
+
+	switch upgrade.Phase {
+	case MajorUpgradeAwaitingCheck:
+		if approval.Image == "" {
+			upgrade.Message = "set spec.postgresql.majorUpgrade.image to an image with the binaries of both versions"
+			return 0, nil
+		}
+		if approval.ApproveCheck != upgrade.ToVersion {
+			upgrade.Message = fmt.Sprintf("waiting for spec.postgresql.majorUpgrade.approveCheck to be %s", upgrade.ToVersion)
+			return 0, nil
+		}
+		if message := majorUpgradeBlocked(cluster); message != "" {
+			upgrade.Message = message
+			return majorUpgradeRequeue, nil
+		}
+		if err := r.createCheckVolume(ctx, cluster, status.Leader); err != nil {
+			return 0, err
+		}
+		setMajorUpgradePhase(upgrade, MajorUpgradeChecking,
+			fmt.Sprintf("running pg_upgrade on a clone of the volume of %s", status.Leader))
+		r.event(cluster, corev1.EventTypeNormal, "MajorUpgradeChecking",
+			"checking the upgrade to PostgreSQL %s on a clone of the volume of %s", upgrade.ToVersion, status.Leader)
+		return majorUpgradeRequeue, nil
+
+	case MajorUpgradeChecking:
+		if approval.ApproveCheck != upgrade.ToVersion {
+			if err := r.deleteMajorUpgradeObjects(ctx, cluster); err != nil {
+				return 0, err
+			}
+			setMajorUpgradePhase(upgrade, MajorUpgradeAwaitingCheck, "the approval of the check was withdrawn")
+			return 0, nil
+		}
+		name := majorUpgradeCheckName(cluster)
+		result, err := r.runJob(ctx, cluster, name, majorUpgradeJobSpec(cluster, name,
+			majorUpgradeScript(from, to, upgrade.FromDataDirectory, upgradedDataDirectory(to), true)))
+		if err != nil {
+			return 0, err
+		}
+		switch result {
+		case BackupRunning:
+			return majorUpgradeRequeue, nil
+		case BackupFailed:
+			setMajorUpgradePhase(upgrade, MajorUpgradeCheckFailed, fmt.Sprintf(
+				"%s failed; see its logs, then withdraw and give spec.postgresql.majorUpgrade.approveCheck again to retry", name))
+			r.event(cluster, corev1.EventTypeWarning, "MajorUpgradeCheckFailed", "checking the upgrade to PostgreSQL %s failed",
+				upgrade.ToVersion)
+			return 0, nil
+		}
+		if err := r.deleteMajorUpgradeObjects(ctx, cluster); err != nil {
+			return 0, err
+		}
+		setMajorUpgradePhase(upgrade, MajorUpgradeAwaitingCutover, "")
+		r.event(cluster, corev1.EventTypeNormal, "MajorUpgradeCheckPassed",
+			"upgraded a clone of the leader's data to PostgreSQL %s", upgrade.ToVersion)
+		return majorUpgradeRequeue, nil
+
+	case MajorUpgradeCheckFailed:
+		if approval.ApproveCheck == upgrade.ToVersion {
+			return 0, nil
+		}
+		if err := r.deleteMajorUpgradeObjects(ctx, cluster); err != nil {
+			return 0, err
+		}
+		setMajorUpgradePhase(upgrade, MajorUpgradeAwaitingCheck, "")
+		return majorUpgradeRequeue, nil
+
+	case MajorUpgradeAwaitingCutover:
+		if approval.ApproveCutover != upgrade.ToVersion {
+			upgrade.Message = fmt.Sprintf("waiting for spec.postgresql.majorUpgrade.approveCutover to be %s", upgrade.ToVersion)
+			return 0, nil
+		}
+		if !maintenanceAllowed(cluster) {
+			upgrade.Message = "waiting for the maintenance window"
+			return 0, nil
+		}
+		if message := majorUpgradeBlocked(cluster); message != "" {
+			upgrade.Message = message
+			return majorUpgradeRequeue, nil
+		}
+		r.event(cluster, corev1.EventTypeNormal, "MajorUpgradeCutover",
+			"cutting over to PostgreSQL %s; the cluster is down until the members start on it", upgrade.ToVersion)
+		if status.Leader == first {
+			setMajorUpgradePhase(upgrade, MajorUpgradeStopping, "stopping the members")
+			return majorUpgradeRequeue, nil
+		}
+		// The upgrade takes the first pod's data, which must be the latest
+		if err := r.switchoverTo(ctx, cluster, first); err != nil {
+			upgrade.Message = fmt.Sprintf("transferring leadership to %s: %v", first, err)
+			return failoverRequeue, nil
+		}
+		setMajorUpgradePhase(upgrade, MajorUpgradeSwitchingOver,
+			fmt.Sprintf("waiting for RAMD to report %s as the leader", first))
+		return failoverRequeue, nil
+
+	case MajorUpgradeSwitchingOver:
+		if approval.ApproveCutover != upgrade.ToVersion {
+			setMajorUpgradePhase(upgrade, MajorUpgradeAwaitingCutover, "the approval of the cutover was withdrawn")
+			return 0, nil
+		}
+		if status.Leader == first {
+			setMajorUpgradePhase(upgrade, MajorUpgradeStopping, "stopping the members")
+			return majorUpgradeRequeue, nil
+		}
+		if time.Since(upgrade.PhaseStartedAt.Time) < switchoverTimeout {
+			return failoverRequeue, nil
+		}
+		r.event(cluster, corev1.EventTypeWarning, "MajorUpgradeSwitchoverTimedOut",
+			"%s did not become the leader within %v; retrying", first, switchoverTimeout)
+		if err := r.switchoverTo(ctx, cluster, first); err != nil {
+			upgrade.Message = fmt.Sprintf("transferring leadership to %s: %v", first, err)
+			return failoverRequeue, nil
+		}
+		upgrade.PhaseStartedAt = metav1.Now()
+		return failoverRequeue, nil
+
+	case MajorUpgradeStopping:
+		if message, err := r.membersStopped(ctx, cluster); err != nil || message != "" {
+			upgrade.Message = message
+			return majorUpgradeRequeue, err
+		}
+		setMajorUpgradePhase(upgrade, MajorUpgradeUpgrading,
+			fmt.Sprintf("upgrading the data of %s with pg_upgrade", first))
+		return majorUpgradeRequeue, nil
+
+	case MajorUpgradeUpgrading:
+		name := majorUpgradeCutoverName(cluster)
+		result, err := r.runJob(ctx, cluster, name, majorUpgradeJobSpec(cluster, dataClaimName(first),
+			majorUpgradeScript(from, to, upgrade.FromDataDirectory, upgradedDataDirectory(to), false)))
+		if err != nil {
+			return 0, err
+		}
+		switch result {
+		case BackupRunning:
+			return majorUpgradeRequeue, nil
+		case BackupFailed:
+			setMajorUpgradePhase(upgrade, MajorUpgradeRollingBack, fmt.Sprintf("%s failed", name))
+			r.event(cluster, corev1.EventTypeWarning, "MajorUpgradeFailed", "upgrading the data of %s failed; rolling back to PostgreSQL %s",
+				first, upgrade.FromVersion)
+			return majorUpgradeRequeue, nil
+		}
+		status.PostgreSQLVersion = upgrade.ToVersion
+		status.PostgreSQLImage = upgrade.ToImage
+		status.DataDirectory = upgradedDataDirectory(to)
+		setMajorUpgradePhase(upgrade, MajorUpgradeStarting,
+			fmt.Sprintf("waiting for %s to lead on PostgreSQL %s", first, upgrade.ToVersion))
+		return majorUpgradeRequeue, nil
+
+	case MajorUpgradeStarting:
+		ready, err := r.podReady(ctx, cluster, first)
+		if err != nil {
+			return 0, err
+		}
+		if ready && status.Leader == first {
+			if err := r.deleteMajorUpgradeObjects(ctx, cluster); err != nil {
+				return 0, err
+			}
+			now := metav1.Now()
+			setMajorUpgradePhase(upgrade, MajorUpgradeCompleted,
+				fmt.Sprintf("the data of PostgreSQL %s is kept in %s", upgrade.FromVersion, upgrade.FromDataDirectory))
+			upgrade.CompletedAt = &now
+			r.event(cluster, corev1.EventTypeNormal, "MajorUpgradeCompleted", "upgraded from PostgreSQL %s to %s in %v",
+				upgrade.FromVersion, upgrade.ToVersion, now.Sub(upgrade.StartedAt.Time).Round(time.Second))
+			return 0, nil
+		}
+		if time.Since(upgrade.PhaseStartedAt.Time) < majorUpgradeStartTimeout {
+			return majorUpgradeRequeue, nil
+		}
+		setMajorUpgradePhase(upgrade, MajorUpgradeRollingBack, fmt.Sprintf("%s did not lead on PostgreSQL %s within %v",
+			first, upgrade.ToVersion, majorUpgradeStartTimeout))
+		r.event(cluster, corev1.EventTypeWarning, "MajorUpgradeFailed", "%s; rolling back to PostgreSQL %s",
+			upgrade.Message, upgrade.FromVersion)
+		return majorUpgradeRequeue, nil
+
+	case MajorUpgradeRollingBack:
+		// The message keeps why the upgrade failed
+		if message, err := r.membersStopped(ctx, cluster); err != nil || message != "" {
+			return majorUpgradeRequeue, err
+		}
+		status.PostgreSQLVersion = upgrade.FromVersion
+		status.PostgreSQLImage = upgrade.FromImage
+		status.DataDirectory = upgrade.FromDataDirectory
+		setMajorUpgradePhase(upgrade, MajorUpgradeRolledBack, fmt.Sprintf(
+			"%s; withdraw and give spec.postgresql.majorUpgrade.approveCutover again to retry", upgrade.Message))
+		r.event(cluster, corev1.EventTypeWarning, "MajorUpgradeRolledBack", "starting the members on PostgreSQL %s again",
+			upgrade.FromVersion)
+		return majorUpgradeRequeue, nil
+
+	case MajorUpgradeRolledBack:
+		if approval.ApproveCutover == upgrade.ToVersion {
+			return 0, nil
+		}
+		if err := r.deleteMajorUpgradeObjects(ctx, cluster); err != nil {
+			return 0, err
+		}
+		setMajorUpgradePhase(upgrade, MajorUpgradeAwaitingCutover, "")
+		return majorUpgradeRequeue, nil
+	}
+	return 0, nil
+}
+
+// membersStopped returns what stopping every member, for the cutover or a
+// hibernation, waits for, or an empty string once the StatefulSet has no
+// pods left
+func (r *PostgreSQLClusterReconciler) membersStopped(ctx context.Context, cluster *ramv1.PostgreSQLCluster) (string, error) {
+	statefulSet := &appsv1.StatefulSet{}
+	err := r.Get(ctx, types.NamespacedName{Name: cluster.Name + "-postgresql", Namespace: cluster.Namespace}, statefulSet)
+	if errors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if statefulSet.Status.ObservedGeneration < statefulSet.Generation ||
+		(statefulSet.Spec.Replicas != nil && *statefulSet.Spec.Replicas > 0) {
+		return "waiting for the StatefulSet to scale to zero", nil
+	}
+	if statefulSet.Status.Replicas > 0 {
+		return fmt.Sprintf("waiting for %d PostgreSQL pods to stop", statefulSet.Status.Replicas), nil
+	}
+	return "", nil
+}
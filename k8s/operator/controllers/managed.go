@@ -0,0 +1,257 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	ramv1 "github.com/pgelephant/pgraft/k8s/operator/api/v1"
+)
+
+// managedPasswordKey is the key of a managed role's password in its Secret
+const managedPasswordKey = "password"
+
+// managedObjectsTTL is how long a finished Job applying the managed roles
+// and databases is kept.  A failed one is retried once it is gone.
+const managedObjectsTTL = int32(3600)
+
+// quoteIdent quotes a name for SQL
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// quoteLiteral quotes a string for SQL
+func quoteLiteral(text string) string {
+	return `'` + strings.ReplaceAll(text, `'`, `''`) + `'`
+}
+
+// managedRoleSQL returns the statements that bring role in line with its
+// spec.  Its password, if it has a Secret, comes from the psql variable
+// password_<i>.
+func managedRoleSQL(role ramv1.ManagedRoleSpec, i int) string {
+	name := quoteIdent(role.Name)
+	if role.Ensure == ramv1.EnsureAbsent {
+		return fmt.Sprintf("DROP ROLE IF EXISTS %s;\n", name)
+	}
+
+	option := func(set bool, keyword string) string {
+		if set {
+			return keyword
+		}
+		return "NO" + keyword
+	}
+	limit := int32(-1)
+	if role.ConnectionLimit != nil {
+		limit = *role.ConnectionLimit
+	}
+
+	var sql strings.Builder
+	fmt.Fprintf(&sql, "SELECT %s WHERE NOT EXISTS (SELECT FROM pg_roles WHERE rolname = %s)\\gexec\n",
+		quoteLiteral("CREATE ROLE "+name), quoteLiteral(role.Name))
+	fmt.Fprintf(&sql, "ALTER ROLE %s WITH %s %s %s %s %s CONNECTION LIMIT %d;\n", name,
+		option(role.Login == nil || *role.Login, "LOGIN"), option(role.Superuser, "SUPERUSER"),
+		option(role.CreateDB, "CREATEDB"), option(role.CreateRole, "CREATEROLE"),
+		option(role.Replication, "REPLICATION"), limit)
+	if role.PasswordSecret != "" {
+		fmt.Fprintf(&sql, "ALTER ROLE %s WITH PASSWORD :'password_%d';\n", name, i)
+	}
+	for _, member := range role.InRoles {
+		fmt.Fprintf(&sql, "GRANT %s TO %s;\n", quoteIdent(member), name)
+	}
+	return sql.String()
+}
+
+// managedDatabaseSQL returns the statements that bring database in line
+// with its spec
+func managedDatabaseSQL(database ramv1.ManagedDatabaseSpec) string {
+	name := quoteIdent(database.Name)
+	if database.Ensure == ramv1.EnsureAbsent {
+		return fmt.Sprintf("DROP DATABASE IF EXISTS %s WITH (FORCE);\n", name)
+	}
+
+	owner := quoteIdent("postgres")
+	if database.Owner != "" {
+		owner = quoteIdent(database.Owner)
+	}
+
+	var sql strings.Builder
+	fmt.Fprintf(&sql, "SELECT %s WHERE NOT EXISTS (SELECT FROM pg_database WHERE datname = %s)\\gexec\n",
+		quoteLiteral("CREATE DATABASE "+name+" OWNER "+owner), quoteLiteral(database.Name))
+	fmt.Fprintf(&sql, "ALTER DATABASE %s OWNER TO %s;\n", name, owner)
+	if len(database.Extensions) > 0 {
+		// The name is a plain identifier, which the CRD enforces
+		fmt.Fprintf(&sql, "\\connect %s\n", database.Name)
+		for _, extension := range database.Extensions {
+			fmt.Fprintf(&sql, "CREATE EXTENSION IF NOT EXISTS %s;\n", quoteIdent(extension))
+		}
+		sql.WriteString("\\connect postgres\n")
+	}
+	return sql.String()
+}
+
+// managedObjectsSQL returns the psql script that brings the primary in
+// line with spec.managedRoles and spec.managedDatabases.  Roles are
+// created before the databases they may own and dropped after the
+// databases they may have owned.
+func managedObjectsSQL(cluster *ramv1.PostgreSQLCluster) string {
+	var sql strings.Builder
+	for i, role := range cluster.Spec.ManagedRoles {
+		if role.Ensure != ramv1.EnsureAbsent {
+			sql.WriteString(managedRoleSQL(role, i))
+		}
+	}
+	for _, database := range cluster.Spec.ManagedDatabases {
+		sql.WriteString(managedDatabaseSQL(database))
+	}
+	for i, role := range cluster.Spec.ManagedRoles {
+		if role.Ensure == ramv1.EnsureAbsent {
+			sql.WriteString(managedRoleSQL(role, i))
+		}
+	}
+	return sql.String()
+}
+
+// managedObjectsJobSpec returns the spec of a Job that runs the script of
+// managedObjectsSQL against the primary.  The passwords reach psql from
+// the Secrets through the environment, never through the Job spec.
+func managedObjectsJobSpec(cluster *ramv1.PostgreSQLCluster) batchv1.JobSpec {
+	labels := map[string]string{
+		"app":       "postgresql-cluster",
+		"cluster":   cluster.Name,
+		"component": "managed-objects",
+	}
+	env := []corev1.EnvVar{
+		{
+			Name: "PGPASSWORD",
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: credentialsSecretName(cluster)},
+					Key:                  postgresPasswordKey,
+				},
+			},
+		},
+	}
+	command := fmt.Sprintf("psql -v ON_ERROR_STOP=1 -h %s.%s.svc.cluster.local -p %d -U postgres -d postgres",
+		roleServiceName(cluster, RolePrimary), cluster.Namespace, cluster.Spec.Networking.Ports.PostgreSQL)
+	for i, role := range cluster.Spec.ManagedRoles {
+		if role.Ensure == ramv1.EnsureAbsent || role.PasswordSecret == "" {
+			continue
+		}
+		env = append(env, corev1.EnvVar{
+			Name: fmt.Sprintf("PASSWORD_%d", i),
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: role.PasswordSecret},
+					Key:                  managedPasswordKey,
+				},
+			},
+		})
+		command += fmt.Sprintf(` -v "password_%d=$PASSWORD_%d"`, i, i)
+	}
+
+	backoffLimit := int32(1)
+	ttl := managedObjectsTTL
+	return batchv1.JobSpec{
+		BackoffLimit:            &backoffLimit,
+		TTLSecondsAfterFinished: &ttl,
+		Template: corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{Labels: labels},
+			Spec: corev1.PodSpec{
+				ImagePullSecrets: cluster.Spec.ImagePullSecrets,
+				RestartPolicy:    corev1.RestartPolicyNever,
+				Containers: []corev1.Container{
+					{
+						Name:    "psql",
+						Image:   cluster.Spec.PostgreSQL.Image,
+						Command: []string{"/bin/sh", "-c", command + " <<'EOSQL'\n" + managedObjectsSQL(cluster) + "EOSQL\n"},
+						Env:     env,
+					},
+				},
+			},
+		},
+	}
+}
+
+// managedObjectsDigest returns a digest of the managed roles and databases
+// and of the versions of their password Secrets, which changes whenever
+// there is something new to apply
+func (r *PostgreSQLClusterReconciler) managedObjectsDigest(ctx context.Context, cluster *ramv1.PostgreSQLCluster) (string, error) {
+	digest := sha256.New()
+	digest.Write([]byte(managedObjectsSQL(cluster)))
+	for i, role := range cluster.Spec.ManagedRoles {
+		if role.Ensure == ramv1.EnsureAbsent || role.PasswordSecret == "" {
+			continue
+		}
+		secret := &corev1.Secret{}
+		if err := r.Get(ctx, types.NamespacedName{Name: role.PasswordSecret, Namespace: cluster.Namespace}, secret); err != nil {
+			return "", fmt.Errorf("spec.managedRoles[%d].passwordSecret: %w", i, err)
+		}
+		if len(secret.Data[managedPasswordKey]) == 0 {
+			return "", fmt.Errorf("spec.managedRoles[%d].passwordSecret: Secret %s has no %s",
+				i, secret.Name, managedPasswordKey)
+		}
+		fmt.Fprintf(digest, "%s/%s\n", secret.Name, secret.ResourceVersion)
+	}
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+// reconcileManagedObjects applies spec.managedRoles and
+// spec.managedDatabases to the primary with a Job whenever they or their
+// password Secrets change, and records the outcome in
+// Status.ManagedObjects.  It waits for the cluster to have a writable
+// primary: a leader, no bootstrap or initialization in progress, not a
+// standby and not hibernating.
+func (r *PostgreSQLClusterReconciler) reconcileManagedObjects(ctx context.Context, cluster *ramv1.PostgreSQLCluster) error {
+	if len(cluster.Spec.ManagedRoles) == 0 && len(cluster.Spec.ManagedDatabases) == 0 {
+		return nil
+	}
+	if cluster.Status.Leader == "" || bootstrapPending(cluster) || initializationPending(cluster) ||
+		standbyActive(cluster) || hibernationInProgress(cluster) {
+		return nil
+	}
+
+	digest, err := r.managedObjectsDigest(ctx, cluster)
+	if err != nil {
+		return err
+	}
+	before := cluster.Status.DeepCopy()
+	if cluster.Status.ManagedObjects == nil {
+		cluster.Status.ManagedObjects = &ramv1.ManagedObjectsStatus{}
+	}
+	status := cluster.Status.ManagedObjects
+	if status.Applied == digest {
+		return nil
+	}
+
+	name := fmt.Sprintf("%s-managed-%s", cluster.Name, digest[:10])
+	result, err := r.runJob(ctx, cluster, name, managedObjectsJobSpec(cluster))
+	if err != nil {
+		return err
+	}
+	if result != status.LastResult || name != status.LastJob {
+		switch result {
+		case BackupSucceeded:
+			r.event(cluster, corev1.EventTypeNormal, "ManagedObjectsApplied", "applied the managed roles and databases with %s", name)
+		case BackupFailed:
+			r.event(cluster, corev1.EventTypeWarning, "ManagedObjectsFailed", "applying the managed roles and databases with %s failed", name)
+		}
+	}
+	status.LastJob = name
+	status.LastResult = result
+	if result == BackupSucceeded {
+		status.Applied = digest
+	}
+
+	if equality.Semantic.DeepEqual(before, &cluster.Status) {
+		return nil
+	}
+	return r.Status().Update(ctx, cluster)
+}
@@ -0,0 +1,205 @@
+package controllers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	ramv1 "github.com/pgelephant/pgraft/k8s/operator/api/v1"
+)
+
+// The metrics below are served with controller-runtime's on the manager's
+// metrics endpoint, one series per cluster labelled with its namespace and
+// name.  They are the operator's view, as of the cluster's last reconcile.
+var (
+	clusterPhaseMetric = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgraft_operator_cluster_phase",
+		Help: "1 for the phase the PostgreSQLCluster is in, 0 for the others.",
+	}, []string{"namespace", "cluster", "phase"})
+
+	clusterReplicasMetric = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgraft_operator_cluster_replicas",
+		Help: "Members the PostgreSQLCluster is to have, from spec.replicas.",
+	}, []string{"namespace", "cluster"})
+
+	clusterReadyReplicasMetric = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgraft_operator_cluster_ready_replicas",
+		Help: "PostgreSQL pods of the PostgreSQLCluster that are ready.",
+	}, []string{"namespace", "cluster"})
+
+	clusterLeaderKnownMetric = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgraft_operator_cluster_leader_known",
+		Help: "1 if RAMD reports a leader for the PostgreSQLCluster, 0 if not.",
+	}, []string{"namespace", "cluster"})
+
+	clusterLeaderChangesMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pgraft_operator_cluster_leader_changes_total",
+		Help: "Times the leader of the PostgreSQLCluster moved to another pod, by failover, switchover or raft.",
+	}, []string{"namespace", "cluster"})
+
+	clusterFailoversMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pgraft_operator_cluster_failovers_total",
+		Help: "Failovers the operator ran for the PostgreSQLCluster, by result.",
+	}, []string{"namespace", "cluster", "result"})
+
+	reconcileStepErrorsMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pgraft_operator_reconcile_step_errors_total",
+		Help: "Reconciles that failed, by the step that failed; conflicts are not counted.",
+	}, []string{"step"})
+
+	clusterBackupAgeDesc = prometheus.NewDesc(
+		"pgraft_operator_cluster_last_backup_age_seconds",
+		"Seconds since the last scheduled backup of the PostgreSQLCluster that succeeded completed.",
+		[]string{"namespace", "cluster"}, nil)
+
+	clusterVerificationAgeDesc = prometheus.NewDesc(
+		"pgraft_operator_cluster_last_backup_verification_age_seconds",
+		"Seconds since the last verification of the backups of the PostgreSQLCluster that succeeded completed.",
+		[]string{"namespace", "cluster"}, nil)
+
+	clusterBackupVerifiedMetric = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pgraft_operator_cluster_backup_verified",
+		Help: "1 if the last verification of the backups of the PostgreSQLCluster restored them, 0 if it failed.",
+	}, []string{"namespace", "cluster"})
+)
+
+// clusterPhases are the values of Status.Phase, each with a series of
+// pgraft_operator_cluster_phase
+var clusterPhases = []string{"Pending", "Running", "Failed", "Updating", "Hibernated"}
+
+// clusterKey identifies a cluster in the operator's metrics
+type clusterKey struct {
+	namespace, name string
+}
+
+// clusterTracker keeps what the metrics of each cluster are derived from
+// between reconciles: the last leader, to count changes across a failover
+// that has no leader for a while, and the times of the last backup and
+// verification, whose ages are computed when scraped
+type clusterTracker struct {
+	mu           sync.Mutex
+	leaders      map[clusterKey]string
+	lastBackup   map[clusterKey]time.Time
+	lastVerified map[clusterKey]time.Time
+}
+
+var trackedClusters = &clusterTracker{
+	leaders:      make(map[clusterKey]string),
+	lastBackup:   make(map[clusterKey]time.Time),
+	lastVerified: make(map[clusterKey]time.Time),
+}
+
+// Describe is part of prometheus.Collector
+func (t *clusterTracker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- clusterBackupAgeDesc
+	ch <- clusterVerificationAgeDesc
+}
+
+// Collect is part of prometheus.Collector
+func (t *clusterTracker) Collect(ch chan<- prometheus.Metric) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key, at := range t.lastBackup {
+		ch <- prometheus.MustNewConstMetric(clusterBackupAgeDesc, prometheus.GaugeValue,
+			time.Since(at).Seconds(), key.namespace, key.name)
+	}
+	for key, at := range t.lastVerified {
+		ch <- prometheus.MustNewConstMetric(clusterVerificationAgeDesc, prometheus.GaugeValue,
+			time.Since(at).Seconds(), key.namespace, key.name)
+	}
+}
+
+func init() {
+	metrics.Registry.MustRegister(
+		clusterPhaseMetric,
+		clusterReplicasMetric,
+		clusterReadyReplicasMetric,
+		clusterLeaderKnownMetric,
+		clusterLeaderChangesMetric,
+		clusterFailoversMetric,
+		reconcileStepErrorsMetric,
+		clusterBackupVerifiedMetric,
+		trackedClusters,
+	)
+}
+
+// recordClusterMetrics updates the metrics of cluster from its status
+func recordClusterMetrics(cluster *ramv1.PostgreSQLCluster) {
+	namespace, name := cluster.Namespace, cluster.Name
+	status := &cluster.Status
+
+	for _, phase := range clusterPhases {
+		value := 0.0
+		if phase == status.Phase {
+			value = 1
+		}
+		clusterPhaseMetric.WithLabelValues(namespace, name, phase).Set(value)
+	}
+	clusterReplicasMetric.WithLabelValues(namespace, name).Set(float64(cluster.Spec.Replicas))
+	clusterReadyReplicasMetric.WithLabelValues(namespace, name).Set(float64(status.ReadyReplicas))
+	leaderKnown := 0.0
+	if status.Leader != "" {
+		leaderKnown = 1
+	}
+	clusterLeaderKnownMetric.WithLabelValues(namespace, name).Set(leaderKnown)
+	// Initialized, so that the counter is scraped before the first change
+	clusterLeaderChangesMetric.WithLabelValues(namespace, name)
+	var verification *ramv1.BackupVerificationStatus
+	if status.Backup != nil {
+		verification = status.Backup.Verification
+	}
+	switch {
+	case verification == nil:
+		clusterBackupVerifiedMetric.DeleteLabelValues(namespace, name)
+	case verification.LastResult == BackupSucceeded:
+		clusterBackupVerifiedMetric.WithLabelValues(namespace, name).Set(1)
+	case verification.LastResult == BackupFailed:
+		clusterBackupVerifiedMetric.WithLabelValues(namespace, name).Set(0)
+	}
+
+	key := clusterKey{namespace: namespace, name: name}
+	trackedClusters.mu.Lock()
+	defer trackedClusters.mu.Unlock()
+	if status.Leader != "" {
+		if last, ok := trackedClusters.leaders[key]; ok && last != status.Leader {
+			clusterLeaderChangesMetric.WithLabelValues(namespace, name).Inc()
+		}
+		trackedClusters.leaders[key] = status.Leader
+	}
+	if status.Backup != nil && status.Backup.LastSuccessfulTime != nil {
+		trackedClusters.lastBackup[key] = status.Backup.LastSuccessfulTime.Time
+	} else {
+		delete(trackedClusters.lastBackup, key)
+	}
+	if verification != nil && verification.LastSuccessfulTime != nil {
+		trackedClusters.lastVerified[key] = verification.LastSuccessfulTime.Time
+	} else {
+		delete(trackedClusters.lastVerified, key)
+	}
+}
+
+// recordFailover counts a failover of cluster that ended with result
+func recordFailover(cluster *ramv1.PostgreSQLCluster, result string) {
+	clusterFailoversMetric.WithLabelValues(cluster.Namespace, cluster.Name, result).Inc()
+}
+
+// forgetClusterMetrics removes the metrics of a cluster that is gone
+func forgetClusterMetrics(namespace, name string) {
+	labels := prometheus.Labels{"namespace": namespace, "cluster": name}
+	clusterPhaseMetric.DeletePartialMatch(labels)
+	clusterReplicasMetric.DeletePartialMatch(labels)
+	clusterReadyReplicasMetric.DeletePartialMatch(labels)
+	clusterLeaderKnownMetric.DeletePartialMatch(labels)
+	clusterLeaderChangesMetric.DeletePartialMatch(labels)
+	clusterFailoversMetric.DeletePartialMatch(labels)
+	clusterBackupVerifiedMetric.DeletePartialMatch(labels)
+
+	key := clusterKey{namespace: namespace, name: name}
+	trackedClusters.mu.Lock()
+	defer trackedClusters.mu.Unlock()
+	delete(trackedClusters.leaders, key)
+	delete(trackedClusters.lastBackup, key)
+	delete(trackedClusters.lastVerified, key)
+}
@@ -0,0 +1,168 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	ramv1 "github.com/pgelephant/pgraft/k8s/operator/api/v1"
+)
+
+// The prometheus-operator kinds are handled as unstructured objects so
+// that the operator neither depends on prometheus-operator nor fails on
+// a cluster where its CRDs are not installed
+var (
+	serviceMonitorGVK = schema.GroupVersionKind{Group: "monitoring.coreos.com", Version: "v1", Kind: "ServiceMonitor"}
+	podMonitorGVK     = schema.GroupVersionKind{Group: "monitoring.coreos.com", Version: "v1", Kind: "PodMonitor"}
+)
+
+// exporterPortName names the postgres-exporter port the PodMonitor scrapes
+const exporterPortName = "exporter"
+
+// ramdLabels returns the labels of the RAMD Deployment, pods and Service
+func ramdLabels(cluster *ramv1.PostgreSQLCluster) map[string]string {
+	return map[string]string{
+		"app":       "postgresql-cluster",
+		"cluster":   cluster.Name,
+		"component": "ramd",
+	}
+}
+
+// addExporter adds a postgres-exporter sidecar, connecting as postgres over
+// localhost, to the PostgreSQL pod spec
+func addExporter(cluster *ramv1.PostgreSQLCluster, spec *corev1.PodSpec) {
+	spec.Containers = append(spec.Containers, corev1.Container{
+		Name:  "postgres-exporter",
+		Image: cluster.Spec.Monitoring.Exporter.Image,
+		Ports: []corev1.ContainerPort{
+			{
+				ContainerPort: cluster.Spec.Networking.Ports.Exporter,
+				Name:          exporterPortName,
+			},
+		},
+		Args: []string{fmt.Sprintf("--web.listen-address=:%d", cluster.Spec.Networking.Ports.Exporter)},
+		Env: []corev1.EnvVar{
+			{
+				Name:  "DATA_SOURCE_URI",
+				Value: fmt.Sprintf("localhost:%d/postgres?sslmode=disable", cluster.Spec.Networking.Ports.PostgreSQL),
+			},
+			{
+				Name:  "DATA_SOURCE_USER",
+				Value: "postgres",
+			},
+			{
+				Name: "DATA_SOURCE_PASS",
+				ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{
+							Name: credentialsSecretName(cluster),
+						},
+						Key: postgresPasswordKey,
+					},
+				},
+			},
+		},
+		Resources: cluster.Spec.Monitoring.Exporter.Resources,
+	})
+}
+
+// stringsValue converts labels to a value an unstructured object can hold
+func stringsValue(labels map[string]string) map[string]interface{} {
+	value := make(map[string]interface{}, len(labels))
+	for k, v := range labels {
+		value[k] = v
+	}
+	return value
+}
+
+// reconcileMonitoring creates or updates a ServiceMonitor for the RAMD
+// metrics and a PodMonitor for the postgres-exporter sidecars, or deletes
+// them once monitoring is disabled.  Without the prometheus-operator CRDs
+// there is nothing to do.
+func (r *PostgreSQLClusterReconciler) reconcileMonitoring(ctx context.Context, cluster *ramv1.PostgreSQLCluster) error {
+	log := log.FromContext(ctx)
+	monitoring := cluster.Spec.Monitoring
+
+	serviceMonitor := &unstructured.Unstructured{}
+	serviceMonitor.SetGroupVersionKind(serviceMonitorGVK)
+	serviceMonitor.SetName(cluster.Name + "-ramd")
+	serviceMonitor.SetNamespace(cluster.Namespace)
+
+	podMonitor := &unstructured.Unstructured{}
+	podMonitor.SetGroupVersionKind(podMonitorGVK)
+	podMonitor.SetName(cluster.Name + "-postgresql")
+	podMonitor.SetNamespace(cluster.Namespace)
+
+	if !monitoring.Enabled {
+		for _, monitor := range []client.Object{serviceMonitor, podMonitor} {
+			err := r.Delete(ctx, monitor)
+			if err != nil && !errors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+				return err
+			}
+		}
+		return nil
+	}
+
+	labels := func(component string) map[string]string {
+		labels := map[string]string{}
+		for k, v := range monitoring.Labels {
+			labels[k] = v
+		}
+		labels["app"] = "postgresql-cluster"
+		labels["cluster"] = cluster.Name
+		labels["component"] = component
+		return labels
+	}
+
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, serviceMonitor, func() error {
+		serviceMonitor.SetLabels(labels("ramd"))
+		serviceMonitor.Object["spec"] = map[string]interface{}{
+			"selector": map[string]interface{}{
+				"matchLabels": stringsValue(ramdLabels(cluster)),
+			},
+			"endpoints": []interface{}{
+				map[string]interface{}{
+					"port":     "prometheus",
+					"path":     "/metrics",
+					"interval": monitoring.ScrapeInterval,
+				},
+			},
+			"targetLabels": []interface{}{"cluster"},
+		}
+		return controllerutil.SetControllerReference(cluster, serviceMonitor, r.Scheme)
+	})
+	if meta.IsNoMatchError(err) {
+		log.Info("prometheus-operator CRDs are not installed, not creating a ServiceMonitor or PodMonitor")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, podMonitor, func() error {
+		podMonitor.SetLabels(labels("postgresql"))
+		podMonitor.Object["spec"] = map[string]interface{}{
+			"selector": map[string]interface{}{
+				"matchLabels": stringsValue(postgresqlPodLabels(cluster)),
+			},
+			"podMetricsEndpoints": []interface{}{
+				map[string]interface{}{
+					"port":     exporterPortName,
+					"path":     "/metrics",
+					"interval": monitoring.ScrapeInterval,
+				},
+			},
+			"podTargetLabels": []interface{}{"cluster", RoleLabel},
+		}
+		return controllerutil.SetControllerReference(cluster, podMonitor, r.Scheme)
+	})
+	return err
+}
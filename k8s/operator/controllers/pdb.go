@@ -0,0 +1,51 @@
+package controllers
+
+import (
+	"context"
+
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	ramv1 "github.com/pgelephant/pgraft/k8s/operator/api/v1"
+)
+
+// maxUnavailable returns how many of members raft members can be down
+// with the rest still a quorum
+func maxUnavailable(members int32) int32 {
+	if members < 1 {
+		return 0
+	}
+	return members - (members/2 + 1)
+}
+
+// reconcilePodDisruptionBudget creates or updates the PodDisruptionBudget
+// of the PostgreSQL pods, so that node drains and other voluntary
+// disruptions never evict more pods than raft can lose and keep quorum.
+// members is the number of pods the StatefulSet has now, all of which are
+// raft members until a scale down removes them.  A single-member cluster
+// allows no eviction at all: evicting its pod would take it down.
+func (r *PostgreSQLClusterReconciler) reconcilePodDisruptionBudget(ctx context.Context, cluster *ramv1.PostgreSQLCluster, members int32) error {
+	pdb := &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      cluster.Name + "-postgresql",
+			Namespace: cluster.Namespace,
+		},
+	}
+
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, pdb, func() error {
+		pdb.Labels = postgresqlPodLabels(cluster)
+
+		unavailable := intstr.FromInt(int(maxUnavailable(members)))
+		pdb.Spec.MaxUnavailable = &unavailable
+		pdb.Spec.MinAvailable = nil
+		pdb.Spec.Selector = &metav1.LabelSelector{
+			MatchLabels: postgresqlPodLabels(cluster),
+		}
+
+		return controllerutil.SetControllerReference(cluster, pdb, r.Scheme)
+	})
+
+	return err
+}
@@ -0,0 +1,242 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	ramv1 "github.com/pgelephant/pgraft/k8s/operator/api/v1"
+)
+
+// postgresqlConfigFile is where PostgreSQL finds the postgresql.conf
+// rendered into the cluster's ConfigMap
+const postgresqlConfigFile = "/etc/postgresql/postgresql.conf"
+
+// pgraftConfigFile is where PostgreSQL finds the pgraft settings of its
+// own member, which postgresql.conf includes
+const pgraftConfigFile = "/etc/postgresql/pgraft.conf"
+
+// postgresqlConfigHashAnnotation on the PostgreSQL pods changes with
+// postgresql.conf, so that new parameters roll them
+const postgresqlConfigHashAnnotation = "ram.pgelephant.com/postgresql-config"
+
+// pgraftDefaults are the pgraft settings spec.postgresql.parameters may
+// override
+var pgraftDefaults = map[string]string{
+	"pgraft.heartbeat_interval": "1000",
+	"pgraft.election_timeout":   "5000",
+	"pgraft.log_level":          "1",
+	"pgraft.worker_enabled":     "on",
+}
+
+// preloadLibraries returns shared_preload_libraries with pgraft first,
+// followed by the libraries spec.postgresql.parameters asks for
+func preloadLibraries(cluster *ramv1.PostgreSQLCluster) string {
+	libraries := []string{"pgraft"}
+	for _, library := range strings.Split(strings.Trim(cluster.Spec.PostgreSQL.Parameters["shared_preload_libraries"], "'"), ",") {
+		library = strings.TrimSpace(library)
+		if library != "" && library != "pgraft" {
+			libraries = append(libraries, library)
+		}
+	}
+	return "'" + strings.Join(libraries, ",") + "'"
+}
+
+// postgresqlConf returns the cluster's postgresql.conf: the parameters of
+// the spec over the pgraft defaults, and the settings the operator owns
+// over both.  Settings that differ between members come from the file of
+// pgraftPodConfig it includes, so that this one is the same for all.
+func postgresqlConf(cluster *ramv1.PostgreSQLCluster) string {
+	settings := make(map[string]string)
+	for name, value := range pgraftDefaults {
+		settings[name] = value
+	}
+	for name, value := range cluster.Spec.PostgreSQL.Parameters {
+		settings[name] = value
+	}
+	settings["listen_addresses"] = "'*'"
+	settings["port"] = fmt.Sprint(cluster.Spec.Networking.Ports.PostgreSQL)
+	settings["shared_preload_libraries"] = preloadLibraries(cluster)
+	settings["pgraft.cluster_name"] = quoteLiteral(cluster.Name)
+	settings["pgraft.port"] = fmt.Sprint(cluster.Spec.Networking.Ports.Raft)
+
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var conf strings.Builder
+	conf.WriteString("# Generated by the operator from spec.postgresql.parameters\n")
+	for _, name := range names {
+		fmt.Fprintf(&conf, "%s = %s\n", name, settings[name])
+	}
+	fmt.Fprintf(&conf, "include_if_exists = '%s'\n", pgraftConfigFile)
+	return conf.String()
+}
+
+// pgraftConfigKey returns the key of pod's pgraft settings in the
+// cluster's ConfigMap
+func pgraftConfigKey(pod string) string {
+	return pod + ".pgraft.conf"
+}
+
+// pgraftPeers returns pgraft.peers, which lists every member there may be
+// as id:address:port, the pod with ordinal running node ordinal+1
+func pgraftPeers(cluster *ramv1.PostgreSQLCluster) string {
+	peers := make([]string, 0, podCount(cluster))
+	for ordinal := int32(0); ordinal < podCount(cluster); ordinal++ {
+		peers = append(peers, fmt.Sprintf("%d:%s:%d", ordinal+1,
+			podHost(cluster, podName(cluster, ordinal)), cluster.Spec.Networking.Ports.Raft))
+	}
+	return strings.Join(peers, ",")
+}
+
+// pgraftPodConfig returns the pgraft settings of the pod with ordinal.
+// They reach the running member when its PostgreSQL restarts; members that
+// join later are added to raft through RAMD.
+func pgraftPodConfig(cluster *ramv1.PostgreSQLCluster, ordinal int32) string {
+	pod := podName(cluster, ordinal)
+	settings := [][2]string{
+		{"pgraft.node_id", fmt.Sprint(ordinal + 1)},
+		{"pgraft.node_name", quoteLiteral(pod)},
+		{"pgraft.address", quoteLiteral(podHost(cluster, pod))},
+		{"pgraft.peers", quoteLiteral(pgraftPeers(cluster))},
+		{"pgraft.cluster_size", fmt.Sprint(podCount(cluster))},
+	}
+
+	var conf strings.Builder
+	fmt.Fprintf(&conf, "# Generated by the operator for %s\n", pod)
+	for _, setting := range settings {
+		fmt.Fprintf(&conf, "%s = %s\n", setting[0], setting[1])
+	}
+	return conf.String()
+}
+
+// pgraftPodConfigs returns the pgraft settings of every pod there may be,
+// by ConfigMap key
+func pgraftPodConfigs(cluster *ramv1.PostgreSQLCluster) map[string]string {
+	configs := make(map[string]string)
+	for ordinal := int32(0); ordinal < podCount(cluster); ordinal++ {
+		configs[pgraftConfigKey(podName(cluster, ordinal))] = pgraftPodConfig(cluster, ordinal)
+	}
+	return configs
+}
+
+// addPostgreSQLConfig has PostgreSQL read the postgresql.conf of the
+// cluster's ConfigMap, and its pod's own pgraft settings next to it, and
+// rolls the pods when postgresql.conf changes
+func addPostgreSQLConfig(cluster *ramv1.PostgreSQLCluster, template *corev1.PodTemplateSpec) {
+	digest := sha256.Sum256([]byte(postgresqlConf(cluster)))
+	if template.Annotations == nil {
+		template.Annotations = make(map[string]string)
+	}
+	template.Annotations[postgresqlConfigHashAnnotation] = hex.EncodeToString(digest[:])[:16]
+
+	postgresql := &template.Spec.Containers[0]
+	postgresql.Args = append(postgresql.Args, "-c", "config_file="+postgresqlConfigFile)
+	postgresql.Env = append(postgresql.Env, corev1.EnvVar{
+		Name: "POD_NAME",
+		ValueFrom: &corev1.EnvVarSource{
+			FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"},
+		},
+	})
+	postgresql.VolumeMounts = append(postgresql.VolumeMounts, corev1.VolumeMount{
+		Name:        "postgresql-config",
+		MountPath:   pgraftConfigFile,
+		SubPathExpr: pgraftConfigKey("$(POD_NAME)"),
+	})
+}
+
+// pgraftExtensionJobName returns the name of the Job creating the pgraft
+// extension
+func pgraftExtensionJobName(cluster *ramv1.PostgreSQLCluster) string {
+	return cluster.Name + "-pgraft-extension"
+}
+
+// pgraftExtensionJobSpec returns the spec of the Job that creates the
+// pgraft extension on whichever member is the primary.  The standbys get
+// it through replication.
+func pgraftExtensionJobSpec(cluster *ramv1.PostgreSQLCluster) batchv1.JobSpec {
+	hosts := make([]string, 0, podCount(cluster))
+	for ordinal := int32(0); ordinal < podCount(cluster); ordinal++ {
+		hosts = append(hosts, podHost(cluster, podName(cluster, ordinal)))
+	}
+	script := fmt.Sprintf(`for host in %s; do
+  if [ "$(psql -h "$host" -p %d -U postgres -d postgres -tAc "SELECT pg_is_in_recovery()" 2>/dev/null)" = f ]; then
+    exec psql -v ON_ERROR_STOP=1 -h "$host" -p %[2]d -U postgres -d postgres -c "CREATE EXTENSION IF NOT EXISTS pgraft"
+  fi
+done
+echo "no member is the primary"
+exit 1
+`, strings.Join(hosts, " "), cluster.Spec.Networking.Ports.PostgreSQL)
+
+	backoffLimit := int32(6)
+	return batchv1.JobSpec{
+		BackoffLimit: &backoffLimit,
+		Template: corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{
+				Labels: map[string]string{
+					"app":       "postgresql-cluster",
+					"cluster":   cluster.Name,
+					"component": "pgraft-extension",
+				},
+			},
+			Spec: corev1.PodSpec{
+				ImagePullSecrets: cluster.Spec.ImagePullSecrets,
+				RestartPolicy:    corev1.RestartPolicyNever,
+				Containers: []corev1.Container{
+					{
+						Name:    "psql",
+						Image:   cluster.Spec.PostgreSQL.Image,
+						Command: []string{"/bin/sh", "-c", script},
+						Env: []corev1.EnvVar{
+							{
+								Name: "PGPASSWORD",
+								ValueFrom: &corev1.EnvVarSource{
+									SecretKeyRef: &corev1.SecretKeySelector{
+										LocalObjectReference: corev1.LocalObjectReference{Name: credentialsSecretName(cluster)},
+										Key:                  postgresPasswordKey,
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// reconcilePGRaftExtension creates the pgraft extension with a Job once a
+// member is up.  The Job is kept once it succeeds; one that fails is
+// removed, so that the next pass runs it again.  A standby gets the
+// extension from the cluster it follows.
+func (r *PostgreSQLClusterReconciler) reconcilePGRaftExtension(ctx context.Context, cluster *ramv1.PostgreSQLCluster) error {
+	if cluster.Status.ReadyReplicas == 0 || bootstrapPending(cluster) || standbyActive(cluster) {
+		return nil
+	}
+
+	name := pgraftExtensionJobName(cluster)
+	result, err := r.runJob(ctx, cluster, name, pgraftExtensionJobSpec(cluster))
+	if err != nil || result != BackupFailed {
+		return err
+	}
+
+	r.event(cluster, corev1.EventTypeWarning, "PGRaftExtensionFailed", "creating the pgraft extension with %s failed; retrying", name)
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: cluster.Namespace}}
+	err = r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground))
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,286 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	ramv1 "github.com/pgelephant/pgraft/k8s/operator/api/v1"
+)
+
+// poolerConfigDir is where PgBouncer finds its configuration
+const poolerConfigDir = "/etc/pgbouncer"
+
+// poolerConfigHashAnnotation on the pooler pods changes with their
+// configuration, so that new credentials or endpoints roll them
+const poolerConfigHashAnnotation = "ram.pgelephant.com/pooler-config"
+
+// poolerName returns the name of the pooler Deployment and Service in
+// front of the Service for pods of role
+func poolerName(cluster *ramv1.PostgreSQLCluster, role string) string {
+	if role == RolePrimary {
+		return cluster.Name + "-pooler-primary"
+	}
+	return cluster.Name + "-pooler-replicas"
+}
+
+// poolerSecretName returns the name of the Secret holding the poolers'
+// configuration, which includes the postgres password
+func poolerSecretName(cluster *ramv1.PostgreSQLCluster) string {
+	return cluster.Name + "-pooler"
+}
+
+// poolerLabels returns the labels of the pods of the pooler for role
+func poolerLabels(cluster *ramv1.PostgreSQLCluster, role string) map[string]string {
+	return map[string]string{
+		"app":       "postgresql-cluster",
+		"cluster":   cluster.Name,
+		"component": "pooler",
+		"pooler":    role,
+	}
+}
+
+// poolerConfig returns the pgbouncer.ini of the pooler for role, which
+// sends every database to the Service for pods of role.  The listener and
+// authentication settings are the operator's; spec.pooler.parameters may
+// set any other.
+func poolerConfig(cluster *ramv1.PostgreSQLCluster, role string) string {
+	pooler := cluster.Spec.Pooler
+
+	settings := map[string]string{
+		"pool_mode":                 pooler.PoolMode,
+		"ignore_startup_parameters": "extra_float_digits",
+	}
+	for name, value := range pooler.Parameters {
+		settings[name] = value
+	}
+	settings["listen_addr"] = "*"
+	settings["listen_port"] = fmt.Sprint(pooler.Port)
+	settings["auth_type"] = "scram-sha-256"
+	settings["auth_file"] = poolerConfigDir + "/userlist.txt"
+	settings["admin_users"] = "postgres"
+	if pooler.AuthPassthrough {
+		settings["auth_user"] = "postgres"
+		settings["auth_query"] = "SELECT usename, passwd FROM pg_shadow WHERE usename = $1"
+	}
+
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var conf strings.Builder
+	conf.WriteString("[databases]\n")
+	fmt.Fprintf(&conf, "* = host=%s.%s.svc.cluster.local port=%d\n\n",
+		roleServiceName(cluster, role), cluster.Namespace, cluster.Spec.Networking.Ports.PostgreSQL)
+	conf.WriteString("[pgbouncer]\n")
+	for _, name := range names {
+		fmt.Fprintf(&conf, "%s = %s\n", name, settings[name])
+	}
+	return conf.String()
+}
+
+// poolerConfigHash returns a digest of the configuration the pooler for
+// role runs with
+func poolerConfigHash(secret *corev1.Secret, role string) string {
+	digest := sha256.New()
+	digest.Write(secret.Data[role+".ini"])
+	digest.Write(secret.Data["userlist.txt"])
+	return hex.EncodeToString(digest.Sum(nil))[:16]
+}
+
+// reconcilePooler deploys PgBouncer in front of the read-write and
+// read-only Services, or removes it if spec.pooler is disabled.  Its
+// configuration is regenerated from the credentials and endpoints on every
+// pass, and the pooler pods are rolled when it changes.
+func (r *PostgreSQLClusterReconciler) reconcilePooler(ctx context.Context, cluster *ramv1.PostgreSQLCluster) error {
+	if !cluster.Spec.Pooler.Enabled {
+		objects := []client.Object{
+			&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: poolerSecretName(cluster), Namespace: cluster.Namespace}},
+		}
+		for _, role := range []string{RolePrimary, RoleReplica} {
+			objects = append(objects,
+				&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: poolerName(cluster, role), Namespace: cluster.Namespace}},
+				&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: poolerName(cluster, role), Namespace: cluster.Namespace}})
+		}
+		for _, object := range objects {
+			if err := r.Delete(ctx, object); err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+		}
+		return nil
+	}
+
+	credentials := &corev1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{Name: credentialsSecretName(cluster), Namespace: cluster.Namespace}, credentials); err != nil {
+		return err
+	}
+	password := credentials.Data[postgresPasswordKey]
+	if len(password) == 0 {
+		return fmt.Errorf("credentials Secret %s has no %s", credentials.Name, postgresPasswordKey)
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      poolerSecretName(cluster),
+			Namespace: cluster.Namespace,
+		},
+	}
+	_, err := r.createOrUpdate(ctx, cluster, secret, func() error {
+		secret.Labels = map[string]string{
+			"app":       "postgresql-cluster",
+			"cluster":   cluster.Name,
+			"component": "pooler",
+		}
+		secret.Data = map[string][]byte{
+			"userlist.txt": []byte(fmt.Sprintf("\"postgres\" \"%s\"\n",
+				strings.ReplaceAll(string(password), `"`, `""`))),
+		}
+		for _, role := range []string{RolePrimary, RoleReplica} {
+			secret.Data[role+".ini"] = []byte(poolerConfig(cluster, role))
+		}
+		return controllerutil.SetControllerReference(cluster, secret, r.Scheme)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, role := range []string{RolePrimary, RoleReplica} {
+		if err := r.reconcilePoolerDeployment(ctx, cluster, secret, role); err != nil {
+			return fmt.Errorf("pooler %s: %w", poolerName(cluster, role), err)
+		}
+		if err := r.reconcilePoolerService(ctx, cluster, role); err != nil {
+			return fmt.Errorf("pooler %s: %w", poolerName(cluster, role), err)
+		}
+	}
+	return nil
+}
+
+// reconcilePoolerDeployment creates or updates the PgBouncer Deployment in
+// front of the Service for pods of role
+func (r *PostgreSQLClusterReconciler) reconcilePoolerDeployment(ctx context.Context, cluster *ramv1.PostgreSQLCluster, secret *corev1.Secret, role string) error {
+	pooler := cluster.Spec.Pooler
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      poolerName(cluster, role),
+			Namespace: cluster.Namespace,
+		},
+	}
+
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, deployment, func() error {
+		deployment.Labels = poolerLabels(cluster, role)
+
+		replicas := pooler.Replicas
+		if hibernated(cluster) {
+			replicas = 0
+		}
+		probe := &corev1.Probe{
+			ProbeHandler: corev1.ProbeHandler{
+				TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromString("pgbouncer")},
+			},
+			PeriodSeconds: 10,
+		}
+		deployment.Spec = appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: poolerLabels(cluster, role),
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: poolerLabels(cluster, role),
+					Annotations: map[string]string{
+						poolerConfigHashAnnotation: poolerConfigHash(secret, role),
+					},
+				},
+				Spec: corev1.PodSpec{
+					ImagePullSecrets: cluster.Spec.ImagePullSecrets,
+					Containers: []corev1.Container{
+						{
+							Name:    "pgbouncer",
+							Image:   pooler.Image,
+							Command: []string{"pgbouncer", poolerConfigDir + "/pgbouncer.ini"},
+							Ports: []corev1.ContainerPort{
+								{
+									ContainerPort: pooler.Port,
+									Name:          "pgbouncer",
+								},
+							},
+							VolumeMounts: []corev1.VolumeMount{
+								{
+									Name:      "pooler-config",
+									MountPath: poolerConfigDir + "/pgbouncer.ini",
+									SubPath:   role + ".ini",
+								},
+								{
+									Name:      "pooler-config",
+									MountPath: poolerConfigDir + "/userlist.txt",
+									SubPath:   "userlist.txt",
+								},
+							},
+							ReadinessProbe: probe,
+							LivenessProbe:  probe,
+							Resources:      pooler.Resources,
+						},
+					},
+					Volumes: []corev1.Volume{
+						{
+							Name: "pooler-config",
+							VolumeSource: corev1.VolumeSource{
+								Secret: &corev1.SecretVolumeSource{
+									SecretName: secret.Name,
+								},
+							},
+						},
+					},
+				},
+			},
+		}
+
+		return controllerutil.SetControllerReference(cluster, deployment, r.Scheme)
+	})
+
+	return err
+}
+
+// reconcilePoolerService creates or updates the Service reaching the
+// PgBouncer pods in front of the Service for pods of role
+func (r *PostgreSQLClusterReconciler) reconcilePoolerService(ctx context.Context, cluster *ramv1.PostgreSQLCluster, role string) error {
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      poolerName(cluster, role),
+			Namespace: cluster.Namespace,
+		},
+	}
+
+	_, err := r.createOrUpdate(ctx, cluster, service, func() error {
+		service.Labels = poolerLabels(cluster, role)
+
+		service.Spec.Type = cluster.Spec.Networking.ServiceType
+		service.Spec.Ports = []corev1.ServicePort{
+			{
+				Name:       "pgbouncer",
+				Port:       cluster.Spec.Pooler.Port,
+				TargetPort: intstr.FromInt(int(cluster.Spec.Pooler.Port)),
+				Protocol:   corev1.ProtocolTCP,
+			},
+		}
+		service.Spec.Selector = poolerLabels(cluster, role)
+
+		return controllerutil.SetControllerReference(cluster, service, r.Scheme)
+	})
+
+	return err
+}
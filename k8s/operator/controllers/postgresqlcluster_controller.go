@@ -2,19 +2,33 @@ package controllers
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"fmt"
+	"net/http"
 	"time"
 
+	"golang.org/x/time/rate"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	ramv1 "github.com/pgelephant/pgraft/k8s/operator/api/v1"
@@ -24,16 +38,71 @@ import (
 type PostgreSQLClusterReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+
+	// HTTPClient queries the RAMD API; a client with a 5s timeout if nil
+	HTTPClient *http.Client
+
+	// Recorder records failover events on the cluster, if not nil
+	Recorder record.EventRecorder
+
+	// MaxConcurrentReconciles is how many clusters are reconciled at once;
+	// one if zero
+	MaxConcurrentReconciles int
+
+	// ResyncPeriod is how often a cluster with nothing to wait for is
+	// looked at again, to notice what is not watched, such as a change of
+	// leader inside RAMD; DefaultResyncPeriod if zero
+	ResyncPeriod time.Duration
+
+	// RetryBaseDelay and RetryMaxDelay bound the exponential backoff of a
+	// cluster whose reconcile fails; DefaultRetryBaseDelay and
+	// DefaultRetryMaxDelay if zero
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+}
+
+// Defaults of the reconciler's tunables
+const (
+	DefaultResyncPeriod   = 5 * time.Minute
+	DefaultRetryBaseDelay = time.Second
+	DefaultRetryMaxDelay  = 5 * time.Minute
+)
+
+// leaderUnknownRequeue is how often RAMD is asked for the leader while
+// there is none, e.g. during a failover
+const leaderUnknownRequeue = 5 * time.Second
+
+// resyncJitter spreads the resyncs of clusters reconciled together, so that
+// they do not keep hitting the API server at once
+const resyncJitter = 0.1
+
+// orDefault returns d, or def if d is not positive
+func orDefault(d, def time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return def
 }
 
 //+kubebuilder:rbac:groups=ram.pgelephant.com,resources=postgresqlclusters,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=ram.pgelephant.com,resources=postgresqlclusters/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=ram.pgelephant.com,resources=postgresqlclusters/finalizers,verbs=update
 //+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;patch;delete
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
 //+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=batch,resources=cronjobs,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;delete
+//+kubebuilder:rbac:groups=core,resources=serviceaccounts,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=roles;rolebindings,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=pods/exec,verbs=create
+//+kubebuilder:rbac:groups=monitoring.coreos.com,resources=servicemonitors;podmonitors,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=storage.k8s.io,resources=storageclasses,verbs=get;list;watch;create;delete
 
 // Reconcile is part of the main kubernetes reconciliation loop
 func (r *PostgreSQLClusterReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -45,91 +114,227 @@ func (r *PostgreSQLClusterReconciler) Reconcile(ctx context.Context, req ctrl.Re
 	if err != nil {
 		if errors.IsNotFound(err) {
 			log.Info("PostgreSQLCluster resource not found. Ignoring since object must be deleted.")
+			forgetClusterMetrics(req.Namespace, req.Name)
+			appliedObjects.forget(req.Namespace, req.Name)
 			return ctrl.Result{}, nil
 		}
 		log.Error(err, "Failed to get PostgreSQLCluster")
 		return ctrl.Result{}, err
 	}
 
-	// Set default values
-	r.setDefaults(cluster)
+	// Set default values, as the defaulting webhook does when it serves
+	cluster.Default()
+
+	// Whatever the reconcile gets to, the metrics show the status it leaves
+	defer recordClusterMetrics(cluster)
+
+	// Take a deleted cluster down in order before its resources go
+	if !cluster.DeletionTimestamp.IsZero() {
+		result, err := r.teardown(ctx, cluster)
+		if err != nil {
+			return r.reconcileFailed(ctx, cluster, err, "Failed to tear down")
+		}
+		return result, nil
+	}
+	if err := r.ensureFinalizer(ctx, cluster); err != nil {
+		return r.reconcileFailed(ctx, cluster, err, "Failed to add finalizer")
+	}
 
 	// Update status
 	if err := r.updateStatus(ctx, cluster); err != nil {
-		log.Error(err, "Failed to update status")
-		return ctrl.Result{}, err
+		return r.reconcileFailed(ctx, cluster, err, "Failed to update status")
+	}
+
+	// Open and close the maintenance window disruptive operations wait for
+	maintenanceAfter, err := r.reconcileMaintenanceWindow(ctx, cluster)
+	if err != nil {
+		return r.reconcileFailed(ctx, cluster, err, "Failed to reconcile maintenance window")
+	}
+
+	// Stop every member while spec.hibernate is set, and start them again
+	// once it is unset
+	hibernateAfter, err := r.reconcileHibernation(ctx, cluster)
+	if err != nil {
+		return r.reconcileFailed(ctx, cluster, err, "Failed to reconcile hibernation")
+	}
+
+	// Follow the primary cluster, or promote out of standby
+	standbyAfter, err := r.reconcileStandby(ctx, cluster)
+	if err != nil {
+		return r.reconcileFailed(ctx, cluster, err, "Failed to reconcile standby")
+	}
+
+	// Fail over from an unhealthy leader
+	failoverAfter, err := r.reconcileFailover(ctx, cluster)
+	if err != nil {
+		return r.reconcileFailed(ctx, cluster, err, "Failed to reconcile failover")
+	}
+
+	// Carry out a requested switchover
+	switchoverAfter, err := r.reconcileSwitchover(ctx, cluster)
+	if err != nil {
+		return r.reconcileFailed(ctx, cluster, err, "Failed to reconcile switchover")
+	}
+
+	// Label the leader pod primary and the rest replicas, for the
+	// read-write and read-only Services; pods being removed are drained
+	// before they leave raft
+	if err := r.reconcileRoleLabels(ctx, cluster); err != nil {
+		return r.reconcileFailed(ctx, cluster, err, "Failed to reconcile pod roles")
+	}
+
+	// Change the raft membership to follow spec.replicas
+	replicas, scaleAfter, err := r.reconcileScale(ctx, cluster)
+	if err != nil {
+		return r.reconcileFailed(ctx, cluster, err, "Failed to reconcile scale")
+	}
+
+	// Carry a raised major version through pg_upgrade, stopping every
+	// member for the cutover
+	majorUpgradeAfter, err := r.reconcileMajorUpgrade(ctx, cluster)
+	if err != nil {
+		return r.reconcileFailed(ctx, cluster, err, "Failed to reconcile major upgrade")
+	}
+	if majorUpgradeStopped(cluster) || hibernated(cluster) {
+		replicas = 0
 	}
 
 	// Create or update ConfigMap
 	if err := r.reconcileConfigMap(ctx, cluster); err != nil {
-		log.Error(err, "Failed to reconcile ConfigMap")
-		return ctrl.Result{}, err
+		return r.reconcileFailed(ctx, cluster, err, "Failed to reconcile ConfigMap")
 	}
 
 	// Create or update Secret
 	if err := r.reconcileSecret(ctx, cluster); err != nil {
-		log.Error(err, "Failed to reconcile Secret")
-		return ctrl.Result{}, err
+		return r.reconcileFailed(ctx, cluster, err, "Failed to reconcile Secret")
+	}
+
+	// Provision the volumes as spec.encryption asks and report whether
+	// the data is encrypted
+	if err := r.reconcileEncryption(ctx, cluster); err != nil {
+		return r.reconcileFailed(ctx, cluster, err, "Failed to reconcile encryption")
 	}
 
 	// Create or update StatefulSet for PostgreSQL
-	if err := r.reconcileStatefulSet(ctx, cluster); err != nil {
-		log.Error(err, "Failed to reconcile StatefulSet")
-		return ctrl.Result{}, err
+	if err := r.reconcileStatefulSet(ctx, cluster, replicas); err != nil {
+		return r.reconcileFailed(ctx, cluster, err, "Failed to reconcile StatefulSet")
+	}
+
+	// Update the pods to a new revision replicas first, switching over
+	// before the leader's turn
+	updateAfter, err := r.reconcileUpdate(ctx, cluster)
+	if err != nil {
+		return r.reconcileFailed(ctx, cluster, err, "Failed to update the PostgreSQL pods")
+	}
+
+	// Have the running members pick up a changed pg_hba.conf
+	if err := r.reconcileHBAReload(ctx, cluster); err != nil {
+		return r.reconcileFailed(ctx, cluster, err, "Failed to reload pg_hba.conf")
+	}
+
+	// Keep voluntary disruptions from taking raft below quorum
+	if err := r.reconcilePodDisruptionBudget(ctx, cluster, replicas); err != nil {
+		return r.reconcileFailed(ctx, cluster, err, "Failed to reconcile PodDisruptionBudget")
 	}
 
 	// Create or update Service
 	if err := r.reconcileService(ctx, cluster); err != nil {
-		log.Error(err, "Failed to reconcile Service")
-		return ctrl.Result{}, err
+		return r.reconcileFailed(ctx, cluster, err, "Failed to reconcile Service")
 	}
 
-	// Create or update RAMD Deployment
-	if err := r.reconcileRAMDDeployment(ctx, cluster); err != nil {
-		log.Error(err, "Failed to reconcile RAMD Deployment")
-		return ctrl.Result{}, err
+	for _, role := range []string{RolePrimary, RoleReplica} {
+		if err := r.reconcileRoleService(ctx, cluster, role); err != nil {
+			return r.reconcileFailed(ctx, cluster, err, "Failed to reconcile Service", "role", role)
+		}
+	}
+
+	// Give each member a Service reachable from outside Kubernetes, or
+	// remove them
+	if err := r.reconcileExternalAccess(ctx, cluster); err != nil {
+		return r.reconcileFailed(ctx, cluster, err, "Failed to reconcile external access")
+	}
+
+	// Create, update or remove the PgBouncer poolers in front of them
+	if err := r.reconcilePooler(ctx, cluster); err != nil {
+		return r.reconcileFailed(ctx, cluster, err, "Failed to reconcile pooler")
+	}
+
+	// Create or update RAMD Deployment, unless ramd runs in the
+	// PostgreSQL pods
+	if ramdSidecar(cluster) {
+		if err := r.deleteRAMDDeployment(ctx, cluster); err != nil {
+			return r.reconcileFailed(ctx, cluster, err, "Failed to delete RAMD Deployment")
+		}
+	} else if err := r.reconcileRAMDDeployment(ctx, cluster); err != nil {
+		return r.reconcileFailed(ctx, cluster, err, "Failed to reconcile RAMD Deployment")
 	}
 
 	// Create or update RAMD Service
 	if err := r.reconcileRAMDService(ctx, cluster); err != nil {
-		log.Error(err, "Failed to reconcile RAMD Service")
-		return ctrl.Result{}, err
+		return r.reconcileFailed(ctx, cluster, err, "Failed to reconcile RAMD Service")
 	}
 
-	// Create or update Monitoring resources
-	if cluster.Spec.Monitoring.Enabled {
-		if err := r.reconcileMonitoring(ctx, cluster); err != nil {
-			log.Error(err, "Failed to reconcile Monitoring")
-			return ctrl.Result{}, err
-		}
+	// Schedule backups to the repository
+	if err := r.reconcileBackup(ctx, cluster); err != nil {
+		return r.reconcileFailed(ctx, cluster, err, "Failed to reconcile backups")
 	}
 
-	return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
-}
+	// Follow the bootstrap from spec.restore or spec.dataSource
+	bootstrapAfter, err := r.updateBootstrap(ctx, cluster)
+	if err != nil {
+		return r.reconcileFailed(ctx, cluster, err, "Failed to update bootstrap status")
+	}
 
-// setDefaults sets default values for the PostgreSQLCluster
-func (r *PostgreSQLClusterReconciler) setDefaults(cluster *ramv1.PostgreSQLCluster) {
-	if cluster.Spec.PostgreSQL.Version == "" {
-		cluster.Spec.PostgreSQL.Version = "17"
+	// Create the pgraft extension once a member is up
+	if err := r.reconcilePGRaftExtension(ctx, cluster); err != nil {
+		return r.reconcileFailed(ctx, cluster, err, "Failed to create the pgraft extension")
 	}
-	if cluster.Spec.PostgreSQL.Image == "" {
-		cluster.Spec.PostgreSQL.Image = "postgres:17"
+
+	// Apply the roles, databases and SQL scripts of spec.bootstrap to a
+	// new cluster, once
+	if err := r.reconcileInitialization(ctx, cluster); err != nil {
+		return r.reconcileFailed(ctx, cluster, err, "Failed to initialize the cluster")
 	}
-	if cluster.Spec.RAMD.Image == "" {
-		cluster.Spec.RAMD.Image = "pgraft/ramd:latest"
+
+	// Apply the managed roles and databases to the primary
+	if err := r.reconcileManagedObjects(ctx, cluster); err != nil {
+		return r.reconcileFailed(ctx, cluster, err, "Failed to reconcile managed roles and databases")
 	}
-	if cluster.Spec.Networking.ServiceType == "" {
-		cluster.Spec.Networking.ServiceType = corev1.ServiceTypeClusterIP
+
+	// Create, update or remove Monitoring resources
+	if err := r.reconcileMonitoring(ctx, cluster); err != nil {
+		return r.reconcileFailed(ctx, cluster, err, "Failed to reconcile Monitoring")
 	}
-	if cluster.Spec.Networking.Ports.PostgreSQL == 0 {
-		cluster.Spec.Networking.Ports.PostgreSQL = 5432
+
+	// Changes to the cluster, the objects it owns and its pods are watched;
+	// otherwise look for the new leader sooner while there is none, e.g.
+	// during a failover, and come back when a failover, switchover, scale
+	// operation, update, major upgrade, bootstrap, promotion or hibernation
+	// has a step to take, or the maintenance window opens or closes
+	result := ctrl.Result{RequeueAfter: wait.Jitter(orDefault(r.ResyncPeriod, DefaultResyncPeriod), resyncJitter)}
+	if !meta.IsStatusConditionTrue(cluster.Status.Conditions, ConditionLeaderKnown) {
+		result.RequeueAfter = leaderUnknownRequeue
 	}
-	if cluster.Spec.Networking.Ports.RAMD == 0 {
-		cluster.Spec.Networking.Ports.RAMD = 8080
+	for _, after := range []time.Duration{failoverAfter, switchoverAfter, scaleAfter, updateAfter, majorUpgradeAfter, bootstrapAfter, standbyAfter, maintenanceAfter, hibernateAfter} {
+		if after > 0 && after < result.RequeueAfter {
+			result.RequeueAfter = after
+		}
 	}
-	if cluster.Spec.Networking.Ports.Prometheus == 0 {
-		cluster.Spec.Networking.Ports.Prometheus = 9090
+
+	return result, nil
+}
+
+// reconcileFailed logs err, which a step of the reconcile named by msg
+// failed with, records it as an event and returns it for a retry.
+// Conflicts are only retried: they come from a stale copy of the cluster
+// and say nothing about its health.
+func (r *PostgreSQLClusterReconciler) reconcileFailed(ctx context.Context, cluster *ramv1.PostgreSQLCluster, err error, msg string, keysAndValues ...interface{}) (ctrl.Result, error) {
+	log.FromContext(ctx).Error(err, msg, keysAndValues...)
+	if !errors.IsConflict(err) {
+		r.event(cluster, corev1.EventTypeWarning, "ReconcileFailed", "%s: %v", msg, err)
+		reconcileStepErrorsMetric.WithLabelValues(msg).Inc()
 	}
+	return ctrl.Result{}, err
 }
 
 // updateStatus updates the status of the PostgreSQLCluster
@@ -143,38 +348,39 @@ func (r *PostgreSQLClusterReconciler) updateStatus(ctx context.Context, cluster
 
 	if err != nil {
 		if errors.IsNotFound(err) {
+			if cluster.Status.DataDirectory == "" {
+				cluster.Status.DataDirectory = dataDirectory(cluster)
+			}
 			cluster.Status.Phase = "Pending"
 			cluster.Status.ReadyReplicas = 0
 			cluster.Status.TotalReplicas = cluster.Spec.Replicas
+			statefulSet = nil
 		} else {
 			return err
 		}
 	} else {
+		if cluster.Status.DataDirectory == "" {
+			cluster.Status.DataDirectory = dataVolumePath
+		}
 		cluster.Status.ReadyReplicas = statefulSet.Status.ReadyReplicas
 		cluster.Status.TotalReplicas = *statefulSet.Spec.Replicas
 
-		if statefulSet.Status.ReadyReplicas == *statefulSet.Spec.Replicas {
+		if hibernated(cluster) {
+			cluster.Status.Phase = "Hibernated"
+		} else if statefulSet.Status.ReadyReplicas == *statefulSet.Spec.Replicas {
 			cluster.Status.Phase = "Running"
 		} else {
 			cluster.Status.Phase = "Updating"
 		}
 	}
 
-	// Update leader (simplified - in real implementation, query RAMD)
-	if cluster.Status.ReadyReplicas > 0 {
-		cluster.Status.Leader = fmt.Sprintf("%s-postgresql-0", cluster.Name)
+	// Leader and endpoints as RAMD reports them; the status is still
+	// written when RAMD cannot be reached
+	if err := r.updateLeader(ctx, cluster); err != nil {
+		log.FromContext(ctx).Info("Could not get the leader from RAMD", "error", err.Error())
 	}
 
-	// Update endpoints
-	cluster.Status.Endpoints.Primary = fmt.Sprintf("%s-postgresql.%s.svc.cluster.local:%d",
-		cluster.Name, cluster.Namespace, cluster.Spec.Networking.Ports.PostgreSQL)
-
-	cluster.Status.Endpoints.Replicas = []string{}
-	for i := int32(1); i < cluster.Spec.Replicas; i++ {
-		replicaEndpoint := fmt.Sprintf("%s-postgresql-%d.%s-postgresql.%s.svc.cluster.local:%d",
-			cluster.Name, i, cluster.Name, cluster.Namespace, cluster.Spec.Networking.Ports.PostgreSQL)
-		cluster.Status.Endpoints.Replicas = append(cluster.Status.Endpoints.Replicas, replicaEndpoint)
-	}
+	setClusterConditions(cluster, statefulSet)
 
 	return r.Status().Update(ctx, cluster)
 }
@@ -188,24 +394,18 @@ func (r *PostgreSQLClusterReconciler) reconcileConfigMap(ctx context.Context, cl
 		},
 	}
 
-	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, configMap, func() error {
+	_, err := r.createOrUpdate(ctx, cluster, configMap, func() error {
 		configMap.Labels = map[string]string{
 			"app":       "postgresql-cluster",
 			"cluster":   cluster.Name,
 			"component": "config",
 		}
 
-		// PostgreSQL configuration
-		postgresqlConf := ""
-		for key, value := range cluster.Spec.PostgreSQL.Parameters {
-			postgresqlConf += fmt.Sprintf("%s = %s\n", key, value)
-		}
-
 		// RAMD configuration
 		ramdConf := fmt.Sprintf(`{
   "cluster": {
     "name": "%s",
-    "nodes": []
+    "nodes": %s
   },
   "postgresql": {
     "host": "localhost",
@@ -222,7 +422,7 @@ func (r *PostgreSQLClusterReconciler) reconcileConfigMap(ctx context.Context, cl
     "rate_limiting": %t,
     "audit_logging": %t
   }
-}`, cluster.Name, cluster.Spec.Networking.Ports.PostgreSQL,
+}`, cluster.Name, ramdNodes(cluster), cluster.Spec.Networking.Ports.PostgreSQL,
 			cluster.Spec.RAMD.Config.Monitoring.PrometheusPort,
 			cluster.Spec.RAMD.Config.Monitoring.MetricsInterval,
 			cluster.Spec.RAMD.Config.Security.EnableSSL,
@@ -230,9 +430,18 @@ func (r *PostgreSQLClusterReconciler) reconcileConfigMap(ctx context.Context, cl
 			cluster.Spec.RAMD.Config.Security.AuditLogging)
 
 		configMap.Data = map[string]string{
-			"postgresql.conf": postgresqlConf,
+			"postgresql.conf": postgresqlConf(cluster),
+			"pg_hba.conf":     pgHBA(cluster),
 			"ramd.json":       ramdConf,
 		}
+		for key, conf := range pgraftPodConfigs(cluster) {
+			configMap.Data[key] = conf
+		}
+		if ramdSidecar(cluster) {
+			for key, conf := range ramdPodConfigs(cluster) {
+				configMap.Data[key] = conf
+			}
+		}
 
 		return controllerutil.SetControllerReference(cluster, configMap, r.Scheme)
 	})
@@ -240,8 +449,44 @@ func (r *PostgreSQLClusterReconciler) reconcileConfigMap(ctx context.Context, cl
 	return err
 }
 
-// reconcileSecret creates or updates the Secret
+// Keys of the credentials Secret
+const (
+	postgresPasswordKey    = "postgres-password"
+	replicationPasswordKey = "replication-password"
+
+	// Optional: the Authorization header of RAMD API requests, for a
+	// ramd that requires authentication
+	ramdTokenKey = "ramd-token"
+)
+
+// credentialsSecretName returns the name of the Secret holding the
+// cluster's passwords: the user's own if spec.credentials names one,
+// otherwise the one the operator generates
+func credentialsSecretName(cluster *ramv1.PostgreSQLCluster) string {
+	if cluster.Spec.Credentials.ExistingSecret != "" {
+		return cluster.Spec.Credentials.ExistingSecret
+	}
+	return cluster.Name + "-secret"
+}
+
+// reconcileSecret creates the Secret with random passwords, or checks the
+// existing Secret named in spec.credentials.  Passwords already in the
+// generated Secret are never replaced, since the database was initialized
+// with them.
 func (r *PostgreSQLClusterReconciler) reconcileSecret(ctx context.Context, cluster *ramv1.PostgreSQLCluster) error {
+	if name := cluster.Spec.Credentials.ExistingSecret; name != "" {
+		secret := &corev1.Secret{}
+		if err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: cluster.Namespace}, secret); err != nil {
+			return fmt.Errorf("spec.credentials.existingSecret: %w", err)
+		}
+		for _, key := range []string{postgresPasswordKey, replicationPasswordKey} {
+			if len(secret.Data[key]) == 0 {
+				return fmt.Errorf("spec.credentials.existingSecret: Secret %s has no %s", name, key)
+			}
+		}
+		return nil
+	}
+
 	secret := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      cluster.Name + "-secret",
@@ -249,7 +494,7 @@ func (r *PostgreSQLClusterReconciler) reconcileSecret(ctx context.Context, clust
 		},
 	}
 
-	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+	_, err := r.createOrUpdate(ctx, cluster, secret, func() error {
 		secret.Labels = map[string]string{
 			"app":       "postgresql-cluster",
 			"cluster":   cluster.Name,
@@ -257,9 +502,18 @@ func (r *PostgreSQLClusterReconciler) reconcileSecret(ctx context.Context, clust
 		}
 
 		secret.Type = corev1.SecretTypeOpaque
-		secret.Data = map[string][]byte{
-			"postgres-password":    []byte("postgres"),
-			"replication-password": []byte("replication"),
+		if secret.Data == nil {
+			secret.Data = map[string][]byte{}
+		}
+		for _, key := range []string{postgresPasswordKey, replicationPasswordKey} {
+			if len(secret.Data[key]) > 0 {
+				continue
+			}
+			password, err := generatePassword()
+			if err != nil {
+				return err
+			}
+			secret.Data[key] = password
 		}
 
 		return controllerutil.SetControllerReference(cluster, secret, r.Scheme)
@@ -268,16 +522,37 @@ func (r *PostgreSQLClusterReconciler) reconcileSecret(ctx context.Context, clust
 	return err
 }
 
-// reconcileStatefulSet creates or updates the StatefulSet
-func (r *PostgreSQLClusterReconciler) reconcileStatefulSet(ctx context.Context, cluster *ramv1.PostgreSQLCluster) error {
+// generatePassword returns a random password of 32 URL-safe characters
+func generatePassword() ([]byte, error) {
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, fmt.Errorf("generating password: %w", err)
+	}
+	password := make([]byte, base64.RawURLEncoding.EncodedLen(len(raw)))
+	base64.RawURLEncoding.Encode(password, raw)
+	return password, nil
+}
+
+// reconcileStatefulSet creates or updates the StatefulSet with replicas
+// pods, which reconcileScale steps toward spec.replicas
+func (r *PostgreSQLClusterReconciler) reconcileStatefulSet(ctx context.Context, cluster *ramv1.PostgreSQLCluster, replicas int32) error {
+	var b *bootstrap
+	if bootstrapPending(cluster) {
+		var err error
+		if b, err = r.bootstrapFor(ctx, cluster); err != nil {
+			return err
+		}
+	}
+
 	statefulSet := &appsv1.StatefulSet{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      cluster.Name + "-postgresql",
 			Namespace: cluster.Namespace,
 		},
 	}
+	storageClass := dataStorageClassName(cluster)
 
-	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, statefulSet, func() error {
+	_, err := r.createOrUpdate(ctx, cluster, statefulSet, func() error {
 		statefulSet.Labels = map[string]string{
 			"app":       "postgresql-cluster",
 			"cluster":   cluster.Name,
@@ -285,7 +560,7 @@ func (r *PostgreSQLClusterReconciler) reconcileStatefulSet(ctx context.Context,
 		}
 
 		statefulSet.Spec = appsv1.StatefulSetSpec{
-			Replicas: &cluster.Spec.Replicas,
+			Replicas: &replicas,
 			Selector: &metav1.LabelSelector{
 				MatchLabels: map[string]string{
 					"app":       "postgresql-cluster",
@@ -302,10 +577,17 @@ func (r *PostgreSQLClusterReconciler) reconcileStatefulSet(ctx context.Context,
 					},
 				},
 				Spec: corev1.PodSpec{
+					NodeSelector:              cluster.Spec.PostgreSQL.NodeSelector,
+					Tolerations:               cluster.Spec.PostgreSQL.Tolerations,
+					Affinity:                  cluster.Spec.PostgreSQL.Affinity,
+					TopologySpreadConstraints: cluster.Spec.PostgreSQL.TopologySpreadConstraints,
+					PriorityClassName:         cluster.Spec.PostgreSQL.PriorityClassName,
+					ServiceAccountName:        cluster.Spec.PostgreSQL.ServiceAccountName,
+					ImagePullSecrets:          cluster.Spec.ImagePullSecrets,
 					Containers: []corev1.Container{
 						{
 							Name:  "postgresql",
-							Image: cluster.Spec.PostgreSQL.Image,
+							Image: postgresqlImage(cluster),
 							Ports: []corev1.ContainerPort{
 								{
 									ContainerPort: cluster.Spec.Networking.Ports.PostgreSQL,
@@ -318,9 +600,9 @@ func (r *PostgreSQLClusterReconciler) reconcileStatefulSet(ctx context.Context,
 									ValueFrom: &corev1.EnvVarSource{
 										SecretKeyRef: &corev1.SecretKeySelector{
 											LocalObjectReference: corev1.LocalObjectReference{
-												Name: cluster.Name + "-secret",
+												Name: credentialsSecretName(cluster),
 											},
-											Key: "postgres-password",
+											Key: postgresPasswordKey,
 										},
 									},
 								},
@@ -328,11 +610,15 @@ func (r *PostgreSQLClusterReconciler) reconcileStatefulSet(ctx context.Context,
 									Name:  "POSTGRES_DB",
 									Value: "postgres",
 								},
+								{
+									Name:  "PGDATA",
+									Value: dataDirectory(cluster),
+								},
 							},
 							VolumeMounts: []corev1.VolumeMount{
 								{
 									Name:      "postgresql-data",
-									MountPath: "/var/lib/postgresql/data",
+									MountPath: dataVolumePath,
 								},
 								{
 									Name:      "postgresql-config",
@@ -371,10 +657,39 @@ func (r *PostgreSQLClusterReconciler) reconcileStatefulSet(ctx context.Context,
 								corev1.ResourceStorage: cluster.Spec.PostgreSQL.Storage.Size,
 							},
 						},
-						StorageClassName: &cluster.Spec.PostgreSQL.Storage.StorageClass,
+						StorageClassName: &storageClass,
 					},
 				},
 			},
+			UpdateStrategy: statefulSetUpdateStrategy(cluster),
+		}
+
+		addPostgreSQLConfig(cluster, &statefulSet.Spec.Template)
+		if restartedAt, ok := cluster.Annotations[RestartedAtAnnotation]; ok {
+			statefulSet.Spec.Template.Annotations[RestartedAtAnnotation] = restartedAt
+		}
+		addHBA(cluster, &statefulSet.Spec.Template.Spec)
+		addInitDB(cluster, &statefulSet.Spec.Template.Spec)
+		if walArchiveConfigured(cluster) {
+			addWALArchiving(cluster, &statefulSet.Spec.Template.Spec)
+		}
+		if b != nil {
+			addBootstrap(cluster, b, &statefulSet.Spec.Template.Spec)
+		}
+		if standbyActive(cluster) {
+			addStandby(cluster, &statefulSet.Spec.Template.Spec)
+		}
+		if cluster.Spec.Monitoring.Enabled {
+			addExporter(cluster, &statefulSet.Spec.Template.Spec)
+		}
+		if ramdSidecar(cluster) {
+			addRAMDSidecar(cluster, &statefulSet.Spec.Template.Spec)
+		}
+		addProbes(cluster, &statefulSet.Spec.Template.Spec)
+		addSecurityContext(cluster, &statefulSet.Spec.Template.Spec, cluster.Spec.PostgreSQL.PodSecurityContext)
+		addPodExtras(&statefulSet.Spec.Template.Spec, cluster.Spec.PostgreSQL.PodExtrasSpec, "postgresql")
+		if ramdSidecar(cluster) {
+			addVolumeMounts(&statefulSet.Spec.Template.Spec, cluster.Spec.RAMD.VolumeMounts, "ramd")
 		}
 
 		return controllerutil.SetControllerReference(cluster, statefulSet, r.Scheme)
@@ -392,7 +707,7 @@ func (r *PostgreSQLClusterReconciler) reconcileService(ctx context.Context, clus
 		},
 	}
 
-	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, service, func() error {
+	_, err := r.createOrUpdate(ctx, cluster, service, func() error {
 		service.Labels = map[string]string{
 			"app":       "postgresql-cluster",
 			"cluster":   cluster.Name,
@@ -439,6 +754,9 @@ func (r *PostgreSQLClusterReconciler) reconcileRAMDDeployment(ctx context.Contex
 		}
 
 		replicas := int32(1)
+		if hibernated(cluster) {
+			replicas = 0
+		}
 		deployment.Spec = appsv1.DeploymentSpec{
 			Replicas: &replicas,
 			Selector: &metav1.LabelSelector{
@@ -457,6 +775,13 @@ func (r *PostgreSQLClusterReconciler) reconcileRAMDDeployment(ctx context.Contex
 					},
 				},
 				Spec: corev1.PodSpec{
+					NodeSelector:              cluster.Spec.RAMD.NodeSelector,
+					Tolerations:               cluster.Spec.RAMD.Tolerations,
+					Affinity:                  cluster.Spec.RAMD.Affinity,
+					TopologySpreadConstraints: cluster.Spec.RAMD.TopologySpreadConstraints,
+					PriorityClassName:         cluster.Spec.RAMD.PriorityClassName,
+					ServiceAccountName:        cluster.Spec.RAMD.ServiceAccountName,
+					ImagePullSecrets:          cluster.Spec.ImagePullSecrets,
 					Containers: []corev1.Container{
 						{
 							Name:  "ramd",
@@ -496,6 +821,9 @@ func (r *PostgreSQLClusterReconciler) reconcileRAMDDeployment(ctx context.Contex
 				},
 			},
 		}
+		addProbes(cluster, &deployment.Spec.Template.Spec)
+		addSecurityContext(cluster, &deployment.Spec.Template.Spec, cluster.Spec.RAMD.PodSecurityContext)
+		addPodExtras(&deployment.Spec.Template.Spec, cluster.Spec.RAMD.PodExtrasSpec, "ramd")
 
 		return controllerutil.SetControllerReference(cluster, deployment, r.Scheme)
 	})
@@ -512,7 +840,7 @@ func (r *PostgreSQLClusterReconciler) reconcileRAMDService(ctx context.Context,
 		},
 	}
 
-	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, service, func() error {
+	_, err := r.createOrUpdate(ctx, cluster, service, func() error {
 		service.Labels = map[string]string{
 			"app":       "postgresql-cluster",
 			"cluster":   cluster.Name,
@@ -541,6 +869,12 @@ func (r *PostgreSQLClusterReconciler) reconcileRAMDService(ctx context.Context,
 				"component": "ramd",
 			},
 		}
+		// Any member's ramd answers for the cluster, including while its
+		// PostgreSQL is not ready, which is when ramd is needed most
+		if ramdSidecar(cluster) {
+			service.Spec.Selector = postgresqlPodLabels(cluster)
+			service.Spec.PublishNotReadyAddresses = true
+		}
 
 		return controllerutil.SetControllerReference(cluster, service, r.Scheme)
 	})
@@ -548,21 +882,43 @@ func (r *PostgreSQLClusterReconciler) reconcileRAMDService(ctx context.Context,
 	return err
 }
 
-// reconcileMonitoring creates or updates monitoring resources
-func (r *PostgreSQLClusterReconciler) reconcileMonitoring(ctx context.Context, cluster *ramv1.PostgreSQLCluster) error {
-	// This is a simplified implementation
-	// In a real implementation, you would create ServiceMonitor, GrafanaDashboard, etc.
-	return nil
-}
-
-// SetupWithManager sets up the controller with the Manager.
+// SetupWithManager sets up the controller with the Manager.  A failed
+// reconcile is retried with a backoff of its own, so that a cluster that
+// keeps failing neither spins nor holds up the others.
 func (r *PostgreSQLClusterReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	maxConcurrentReconciles := r.MaxConcurrentReconciles
+	if maxConcurrentReconciles < 1 {
+		maxConcurrentReconciles = 1
+	}
+	rateLimiter := workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(
+			orDefault(r.RetryBaseDelay, DefaultRetryBaseDelay), orDefault(r.RetryMaxDelay, DefaultRetryMaxDelay)),
+		// Overall, as client-go's default rate limiter
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(10), 100)},
+	)
+
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&ramv1.PostgreSQLCluster{}).
+		// The operator's own status updates do not change the generation
+		For(&ramv1.PostgreSQLCluster{}, builder.WithPredicates(predicate.Or(
+			predicate.GenerationChangedPredicate{},
+			predicate.LabelChangedPredicate{},
+			predicate.AnnotationChangedPredicate{}))).
 		Owns(&appsv1.StatefulSet{}).
 		Owns(&appsv1.Deployment{}).
 		Owns(&corev1.Service{}).
 		Owns(&corev1.ConfigMap{}).
 		Owns(&corev1.Secret{}).
+		Owns(&policyv1.PodDisruptionBudget{}).
+		Owns(&batchv1.CronJob{}).
+		// Jobs report their outcome when they finish
+		Owns(&batchv1.Job{}).
+		// The pods belong to the StatefulSet; watch them to relabel roles
+		// and notice an unready leader without waiting for a requeue
+		Watches(&corev1.Pod{}, handler.EnqueueRequestsFromMapFunc(podCluster),
+			builder.WithPredicates(podRoleChanged)).
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: maxConcurrentReconciles,
+			RateLimiter:             rateLimiter,
+		}).
 		Complete(r)
 }
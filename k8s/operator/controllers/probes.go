@@ -0,0 +1,83 @@
+package controllers
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	ramv1 "github.com/pgelephant/pgraft/k8s/operator/api/v1"
+)
+
+// Paths of ramd's probe endpoints, which answer 200 if the probe passes
+// and 503 if not
+const (
+	// ramdLivenessPath passes while ramd answers
+	ramdLivenessPath = "/liveness"
+
+	// ramdHealthPath passes while the local PostgreSQL accepts connections
+	ramdHealthPath = "/health"
+
+	// ramdReadinessPath passes while the local PostgreSQL accepts
+	// connections and is primary only if raft made its node the leader
+	ramdReadinessPath = "/readiness"
+)
+
+// ramdProbe returns a probe that asks the ramd listening on the pod's RAMD
+// port for path
+func ramdProbe(cluster *ramv1.PostgreSQLCluster, path string, failureThreshold int32) *corev1.Probe {
+	return &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			HTTPGet: &corev1.HTTPGetAction{
+				Path: path,
+				Port: intstr.FromInt(int(cluster.Spec.Networking.Ports.RAMD)),
+			},
+		},
+		PeriodSeconds:    10,
+		TimeoutSeconds:   5,
+		FailureThreshold: failureThreshold,
+	}
+}
+
+// pgIsReadyProbe returns a probe that runs pg_isready in the PostgreSQL
+// container, for pods without ramd of their own
+func pgIsReadyProbe(cluster *ramv1.PostgreSQLCluster, failureThreshold int32) *corev1.Probe {
+	return &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			Exec: &corev1.ExecAction{
+				Command: []string{"pg_isready", "-h", "127.0.0.1", "-U", "postgres",
+					"-p", fmt.Sprint(cluster.Spec.Networking.Ports.PostgreSQL)},
+			},
+		},
+		PeriodSeconds:    10,
+		TimeoutSeconds:   5,
+		FailureThreshold: failureThreshold,
+	}
+}
+
+// addProbes adds the probes of the PostgreSQL pod's containers.  With ramd
+// in the pod, PostgreSQL is live while ramd can reach it and ready while
+// its role agrees with raft's, so Services never route to a primary raft
+// has not made leader; a startup probe gives crash recovery and restores
+// time before the liveness probe counts.  ramd is live and ready while it
+// answers, which it does well before PostgreSQL would be restarted for
+// ramd being down.  Without ramd in the pod, PostgreSQL falls back to
+// pg_isready.
+func addProbes(cluster *ramv1.PostgreSQLCluster, spec *corev1.PodSpec) {
+	for i := range spec.Containers {
+		container := &spec.Containers[i]
+		switch {
+		case container.Name == "postgresql" && ramdSidecar(cluster):
+			container.StartupProbe = ramdProbe(cluster, ramdHealthPath, 180)
+			container.LivenessProbe = ramdProbe(cluster, ramdHealthPath, 6)
+			container.ReadinessProbe = ramdProbe(cluster, ramdReadinessPath, 3)
+		case container.Name == "postgresql":
+			container.StartupProbe = pgIsReadyProbe(cluster, 180)
+			container.LivenessProbe = pgIsReadyProbe(cluster, 6)
+			container.ReadinessProbe = pgIsReadyProbe(cluster, 3)
+		case container.Name == "ramd":
+			container.LivenessProbe = ramdProbe(cluster, ramdLivenessPath, 3)
+			container.ReadinessProbe = ramdProbe(cluster, ramdLivenessPath, 3)
+		}
+	}
+}
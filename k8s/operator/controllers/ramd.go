@@ -0,0 +1,172 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ramv1 "github.com/pgelephant/pgraft/k8s/operator/api/v1"
+)
+
+// Values of RAMDSpec.Mode
+const (
+	// RAMDModeSidecar runs ramd in every PostgreSQL pod, next to the
+	// instance it manages
+	RAMDModeSidecar = "Sidecar"
+
+	// RAMDModeDeployment runs a single ramd in a Deployment of its own
+	RAMDModeDeployment = "Deployment"
+)
+
+// ramdConfigDir is where the ramd sidecar finds its configuration
+const ramdConfigDir = "/etc/ramd"
+
+// ramdSidecar reports whether ramd runs as a sidecar of the PostgreSQL pods
+func ramdSidecar(cluster *ramv1.PostgreSQLCluster) bool {
+	return cluster.Spec.RAMD.Mode != RAMDModeDeployment
+}
+
+// ramdConfigKey returns the key of pod's ramd configuration in the
+// cluster's ConfigMap
+func ramdConfigKey(pod string) string {
+	return pod + ".ramd.conf"
+}
+
+// ramdPodConfig returns the ramd configuration of the pod with ordinal,
+// which runs node ordinal+1
+func ramdPodConfig(cluster *ramv1.PostgreSQLCluster, ordinal int32) string {
+	pod := podName(cluster, ordinal)
+	settings := [][2]string{
+		{"node_id", fmt.Sprint(ordinal + 1)},
+		{"hostname", podHost(cluster, pod)},
+		{"cluster_name", cluster.Name},
+		{"cluster_size", fmt.Sprint(podCount(cluster))},
+		{"postgresql_port", fmt.Sprint(cluster.Spec.Networking.Ports.PostgreSQL)},
+		{"postgresql_data_dir", dataDirectory(cluster)},
+		{"rale_port", fmt.Sprint(cluster.Spec.Networking.Ports.Raft)},
+		{"database_name", "postgres"},
+		{"database_user", "postgres"},
+		{"auto_failover_enabled", fmt.Sprint(cluster.Spec.Failover.Enabled)},
+		{"http_api_enabled", "true"},
+		{"http_bind_address", "0.0.0.0"},
+		{"http_port", fmt.Sprint(cluster.Spec.Networking.Ports.RAMD)},
+		{"log_to_console", "true"},
+		{"daemonize", "false"},
+	}
+
+	var conf strings.Builder
+	for _, setting := range settings {
+		fmt.Fprintf(&conf, "%s = %s\n", setting[0], setting[1])
+	}
+	return conf.String()
+}
+
+// ramdPodConfigs returns the ramd configuration of every pod there may be,
+// by ConfigMap key
+func ramdPodConfigs(cluster *ramv1.PostgreSQLCluster) map[string]string {
+	configs := make(map[string]string)
+	for ordinal := int32(0); ordinal < podCount(cluster); ordinal++ {
+		configs[ramdConfigKey(podName(cluster, ordinal))] = ramdPodConfig(cluster, ordinal)
+	}
+	return configs
+}
+
+// ramdConfigNode describes a member in ramd.json
+type ramdConfigNode struct {
+	ID             int32  `json:"id"`
+	Name           string `json:"name"`
+	Hostname       string `json:"hostname"`
+	PostgreSQLPort int32  `json:"postgresql_port"`
+	RaftPort       int32  `json:"raft_port"`
+	RAMDPort       int32  `json:"ramd_port"`
+}
+
+// ramdNodes returns the nodes of ramd.json: every member there may be,
+// the pod with ordinal running node ordinal+1
+func ramdNodes(cluster *ramv1.PostgreSQLCluster) string {
+	nodes := make([]ramdConfigNode, 0, podCount(cluster))
+	for ordinal := int32(0); ordinal < podCount(cluster); ordinal++ {
+		pod := podName(cluster, ordinal)
+		nodes = append(nodes, ramdConfigNode{
+			ID:             ordinal + 1,
+			Name:           pod,
+			Hostname:       podHost(cluster, pod),
+			PostgreSQLPort: cluster.Spec.Networking.Ports.PostgreSQL,
+			RaftPort:       cluster.Spec.Networking.Ports.Raft,
+			RAMDPort:       cluster.Spec.Networking.Ports.RAMD,
+		})
+	}
+	// Marshalling a slice of plain structs cannot fail
+	data, _ := json.MarshalIndent(nodes, "    ", "  ")
+	return string(data)
+}
+
+// addRAMDSidecar adds the ramd container to the PostgreSQL pod spec.  Each
+// pod mounts its own configuration from the ConfigMap by its name.
+func addRAMDSidecar(cluster *ramv1.PostgreSQLCluster, spec *corev1.PodSpec) {
+	spec.Containers = append(spec.Containers, corev1.Container{
+		Name:  "ramd",
+		Image: cluster.Spec.RAMD.Image,
+		Args:  []string{"--config", ramdConfigDir + "/ramd.conf"},
+		Ports: []corev1.ContainerPort{
+			{
+				ContainerPort: cluster.Spec.Networking.Ports.RAMD,
+				Name:          "ramd",
+			},
+			{
+				ContainerPort: cluster.Spec.Networking.Ports.Prometheus,
+				Name:          "prometheus",
+			},
+		},
+		Env: []corev1.EnvVar{
+			{
+				Name: "POD_NAME",
+				ValueFrom: &corev1.EnvVarSource{
+					FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"},
+				},
+			},
+			{
+				Name:  "PGDATA",
+				Value: dataDirectory(cluster),
+			},
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:        "ramd-config",
+				MountPath:   ramdConfigDir + "/ramd.conf",
+				SubPathExpr: ramdConfigKey("$(POD_NAME)"),
+			},
+			{
+				Name:      "postgresql-data",
+				MountPath: dataVolumePath,
+			},
+		},
+		Resources: cluster.Spec.RAMD.Resources,
+	})
+	spec.Volumes = append(spec.Volumes, corev1.Volume{
+		Name: "ramd-config",
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: cluster.Name + "-config",
+				},
+			},
+		},
+	})
+}
+
+// deleteRAMDDeployment removes the RAMD Deployment of a cluster that has
+// moved to ramd sidecars
+func (r *PostgreSQLClusterReconciler) deleteRAMDDeployment(ctx context.Context, cluster *ramv1.PostgreSQLCluster) error {
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: cluster.Name + "-ramd", Namespace: cluster.Namespace}}
+	if err := r.Delete(ctx, deployment); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
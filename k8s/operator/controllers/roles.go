@@ -0,0 +1,162 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	ramv1 "github.com/pgelephant/pgraft/k8s/operator/api/v1"
+)
+
+// RoleLabel marks each PostgreSQL pod as the primary, a replica, fenced or
+// draining; the read-write and read-only Services select on it
+const RoleLabel = "ram.pgelephant.com/role"
+
+const (
+	RolePrimary = "primary"
+	RoleReplica = "replica"
+
+	// The old leader during a failover or switchover, reached by neither
+	// Service
+	RoleFenced = "fenced"
+
+	// A pod being removed by a scale down, reached by neither Service
+	RoleDraining = "draining"
+)
+
+// postgresqlPodLabels returns the labels every PostgreSQL pod of the cluster carries
+func postgresqlPodLabels(cluster *ramv1.PostgreSQLCluster) map[string]string {
+	return map[string]string{
+		"app":       "postgresql-cluster",
+		"cluster":   cluster.Name,
+		"component": "postgresql",
+	}
+}
+
+// podCluster maps a PostgreSQL pod to the cluster it belongs to, so that
+// the cluster is reconciled as soon as one of its pods changes
+func podCluster(_ context.Context, obj client.Object) []reconcile.Request {
+	labels := obj.GetLabels()
+	if labels["app"] != "postgresql-cluster" || labels["component"] != "postgresql" || labels["cluster"] == "" {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{
+		Name:      labels["cluster"],
+		Namespace: obj.GetNamespace(),
+	}}}
+}
+
+// podRoleChanged passes the pod events that can move a role: a pod being
+// created or deleted, becoming ready or unready, starting to terminate, or
+// having its role label changed by someone other than the operator
+var podRoleChanged = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldPod, ok := e.ObjectOld.(*corev1.Pod)
+		if !ok {
+			return true
+		}
+		newPod, ok := e.ObjectNew.(*corev1.Pod)
+		if !ok {
+			return true
+		}
+		return isPodReady(oldPod) != isPodReady(newPod) ||
+			(oldPod.DeletionTimestamp == nil) != (newPod.DeletionTimestamp == nil) ||
+			oldPod.Labels[RoleLabel] != newPod.Labels[RoleLabel]
+	},
+}
+
+// roleServiceName returns the name of the Service for pods of role
+func roleServiceName(cluster *ramv1.PostgreSQLCluster, role string) string {
+	if role == RolePrimary {
+		return cluster.Name + "-primary"
+	}
+	return cluster.Name + "-replicas"
+}
+
+// reconcileRoleLabels labels the leader pod primary and every other pod
+// replica, except the old leader of a failover that has not completed or
+// of a switchover in progress, which is fenced, and the pods beyond
+// spec.replicas, which are draining until a scale down removes them.
+// While there is no leader no pod is labeled primary, so the read-write
+// Service has no endpoints rather than one that may be stale.
+func (r *PostgreSQLClusterReconciler) reconcileRoleLabels(ctx context.Context, cluster *ramv1.PostgreSQLCluster) error {
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(cluster.Namespace),
+		client.MatchingLabels(postgresqlPodLabels(cluster))); err != nil {
+		return err
+	}
+
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		role := RoleReplica
+		if pod.Name == cluster.Status.Leader {
+			role = RolePrimary
+		} else if failover := cluster.Status.Failover; failover != nil &&
+			failover.Phase != FailoverCompleted && pod.Name == failover.OldLeader {
+			role = RoleFenced
+		} else if switchoverInProgress(cluster) && pod.Name == cluster.Status.Switchover.OldLeader {
+			role = RoleFenced
+		} else if ordinal, ok := podOrdinal(cluster, pod.Name); ok && ordinal >= cluster.Spec.Replicas {
+			role = RoleDraining
+		}
+		if pod.Labels[RoleLabel] == role {
+			continue
+		}
+
+		patch := client.MergeFrom(pod.DeepCopy())
+		if pod.Labels == nil {
+			pod.Labels = map[string]string{}
+		}
+		pod.Labels[RoleLabel] = role
+		if err := r.Patch(ctx, pod, patch); err != nil {
+			return fmt.Errorf("labeling pod %s %s: %w", pod.Name, role, err)
+		}
+	}
+	return nil
+}
+
+// reconcileRoleService creates or updates the Service reaching the pods of role
+func (r *PostgreSQLClusterReconciler) reconcileRoleService(ctx context.Context, cluster *ramv1.PostgreSQLCluster, role string) error {
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      roleServiceName(cluster, role),
+			Namespace: cluster.Namespace,
+		},
+	}
+
+	_, err := r.createOrUpdate(ctx, cluster, service, func() error {
+		service.Labels = map[string]string{
+			"app":       "postgresql-cluster",
+			"cluster":   cluster.Name,
+			"component": "postgresql",
+			RoleLabel:   role,
+		}
+
+		selector := postgresqlPodLabels(cluster)
+		selector[RoleLabel] = role
+
+		service.Spec.Type = cluster.Spec.Networking.ServiceType
+		service.Spec.Ports = []corev1.ServicePort{
+			{
+				Name:       "postgresql",
+				Port:       cluster.Spec.Networking.Ports.PostgreSQL,
+				TargetPort: intstr.FromInt(int(cluster.Spec.Networking.Ports.PostgreSQL)),
+				Protocol:   corev1.ProtocolTCP,
+			},
+		}
+		service.Spec.Selector = selector
+
+		return controllerutil.SetControllerReference(cluster, service, r.Scheme)
+	})
+
+	return err
+}
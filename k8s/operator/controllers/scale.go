@@ -0,0 +1,259 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	ramv1 "github.com/pgelephant/pgraft/k8s/operator/api/v1"
+)
+
+// ConditionScaling is true while the pods or the raft membership do not
+// yet match spec.replicas
+const ConditionScaling = "Scaling"
+
+// scaleRequeue is how often a scale operation in progress is advanced
+const scaleRequeue = 5 * time.Second
+
+// ramdAddNodeRequest is the body of POST /api/v1/cluster/add-node
+type ramdAddNodeRequest struct {
+	NodeID   int32  `json:"node_id"`
+	Hostname string `json:"hostname"`
+	Address  string `json:"address"`
+	Port     int32  `json:"port"`
+}
+
+// ramdRemoveNodeRequest is the body of POST /api/v1/cluster/remove-node
+type ramdRemoveNodeRequest struct {
+	NodeID int32 `json:"node_id"`
+}
+
+// podName returns the name of the PostgreSQL pod with the given ordinal
+func podName(cluster *ramv1.PostgreSQLCluster, ordinal int32) string {
+	return fmt.Sprintf("%s-postgresql-%d", cluster.Name, ordinal)
+}
+
+// podOrdinal returns the StatefulSet ordinal of a PostgreSQL pod of the cluster
+func podOrdinal(cluster *ramv1.PostgreSQLCluster, pod string) (int32, bool) {
+	prefix := cluster.Name + "-postgresql-"
+	if !strings.HasPrefix(pod, prefix) {
+		return 0, false
+	}
+	ordinal, err := strconv.Atoi(strings.TrimPrefix(pod, prefix))
+	if err != nil || ordinal < 0 {
+		return 0, false
+	}
+	return int32(ordinal), true
+}
+
+// podCount returns how many PostgreSQL pods there may be: while scaling
+// down, the departing pods count until they are deleted
+func podCount(cluster *ramv1.PostgreSQLCluster) int32 {
+	if cluster.Status.TotalReplicas > cluster.Spec.Replicas {
+		return cluster.Status.TotalReplicas
+	}
+	return cluster.Spec.Replicas
+}
+
+// ramdMembers returns the pods whose node RAMD lists, with their node IDs
+func (r *PostgreSQLClusterReconciler) ramdMembers(ctx context.Context, cluster *ramv1.PostgreSQLCluster) (map[string]int32, error) {
+	var nodes ramdNodeList
+	if err := r.callRAMD(ctx, cluster, http.MethodGet, "/api/v1/nodes", nil, &nodes); err != nil {
+		return nil, err
+	}
+	members := make(map[string]int32)
+	for _, node := range nodes.Data.Nodes {
+		if pod, ok := podForHostname(cluster, node.Hostname); ok {
+			members[pod] = node.NodeID
+		} else if node.NodeID > 0 {
+			members[podName(cluster, node.NodeID-1)] = node.NodeID
+		}
+	}
+	return members, nil
+}
+
+// reconcileScale brings the raft membership and the pods to spec.replicas
+// in an order that keeps quorum.  Scaling up, the StatefulSet grows at once
+// and each new pod joins raft once it is ready.  Scaling down goes one pod
+// at a time from the highest ordinal: leadership is moved off the departing
+// pod, its pod is drained from the Services, it is removed from raft, and
+// only then is the StatefulSet shrunk so that the pod is deleted.  It
+// returns the replicas the StatefulSet should have now and how soon to look
+// again, zero if there is nothing to wait for.
+func (r *PostgreSQLClusterReconciler) reconcileScale(ctx context.Context, cluster *ramv1.PostgreSQLCluster) (int32, time.Duration, error) {
+	statefulSet := &appsv1.StatefulSet{}
+	err := r.Get(ctx, types.NamespacedName{Name: cluster.Name + "-postgresql", Namespace: cluster.Namespace}, statefulSet)
+	if errors.IsNotFound(err) {
+		// A new cluster bootstraps with all its members
+		return cluster.Spec.Replicas, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	current := cluster.Spec.Replicas
+	if statefulSet.Spec.Replicas != nil {
+		current = *statefulSet.Spec.Replicas
+	}
+	// The membership stays as it is while the cluster goes to sleep or
+	// sleeps, and catches up with spec.replicas as it resumes
+	if hibernationInProgress(cluster) && cluster.Status.Hibernation.Phase != HibernationResuming {
+		return current, 0, nil
+	}
+
+	before := cluster.Status.DeepCopy()
+	replicas := current
+	var message string
+	if cluster.Spec.Replicas >= current {
+		replicas = cluster.Spec.Replicas
+		message = r.addMembers(ctx, cluster)
+	} else {
+		replicas, message = r.scaleDownStep(ctx, cluster, current)
+	}
+
+	condition := metav1.Condition{
+		Type:               ConditionScaling,
+		Status:             metav1.ConditionFalse,
+		Reason:             "Scaled",
+		Message:            fmt.Sprintf("%d members", cluster.Spec.Replicas),
+		ObservedGeneration: cluster.Generation,
+	}
+	requeue := time.Duration(0)
+	if message != "" {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "Scaling"
+		condition.Message = message
+		requeue = scaleRequeue
+	}
+	wasScaling := meta.IsStatusConditionTrue(before.Conditions, ConditionScaling)
+	if message != "" && !wasScaling {
+		r.event(cluster, corev1.EventTypeNormal, "ScalingStarted", "scaling from %d to %d members: %s",
+			current, cluster.Spec.Replicas, message)
+	} else if message == "" && wasScaling {
+		r.event(cluster, corev1.EventTypeNormal, "Scaled", "scaled to %d members", cluster.Spec.Replicas)
+	}
+	meta.SetStatusCondition(&cluster.Status.Conditions, condition)
+
+	if equality.Semantic.DeepEqual(before, &cluster.Status) {
+		return replicas, requeue, nil
+	}
+	return replicas, requeue, r.Status().Update(ctx, cluster)
+}
+
+// addMembers adds each ready pod that is not yet a raft member, and
+// returns what scaling up still waits for, empty once every pod is a
+// member.  Members are added through the leader, so nothing is done while
+// there is none.
+func (r *PostgreSQLClusterReconciler) addMembers(ctx context.Context, cluster *ramv1.PostgreSQLCluster) string {
+	if cluster.Status.Leader == "" || failoverInProgress(cluster) || switchoverInProgress(cluster) {
+		return ""
+	}
+	members, err := r.ramdMembers(ctx, cluster)
+	if err != nil {
+		return fmt.Sprintf("RAMD unavailable: %v", err)
+	}
+
+	var waiting []string
+	for ordinal := int32(0); ordinal < cluster.Spec.Replicas; ordinal++ {
+		pod := podName(cluster, ordinal)
+		if _, ok := members[pod]; ok || pod == cluster.Status.Leader {
+			continue
+		}
+		// A pod joins once its PostgreSQL runs, so it does not count
+		// toward quorum before it can vote
+		if ready, err := r.podReady(ctx, cluster, pod); err != nil || !ready {
+			waiting = append(waiting, pod)
+			continue
+		}
+		host := podHost(cluster, pod)
+		request := ramdAddNodeRequest{
+			NodeID:   ordinal + 1,
+			Hostname: host,
+			Address:  host,
+			Port:     cluster.Spec.Networking.Ports.Raft,
+		}
+		if err := r.callRAMD(ctx, cluster, http.MethodPost, "/api/v1/cluster/add-node", request, nil); err != nil {
+			return fmt.Sprintf("adding %s to raft: %v", pod, err)
+		}
+		r.event(cluster, corev1.EventTypeNormal, "MemberAdded", "added %s to raft as node %d", pod, ordinal+1)
+	}
+
+	if len(waiting) > 0 {
+		return fmt.Sprintf("waiting for %s to be ready to join raft", strings.Join(waiting, ", "))
+	}
+	return ""
+}
+
+// scaleDownStep takes the next step of removing the pod with the highest
+// ordinal, and returns the replicas the StatefulSet should have and what
+// scaling down waits for
+func (r *PostgreSQLClusterReconciler) scaleDownStep(ctx context.Context, cluster *ramv1.PostgreSQLCluster, current int32) (int32, string) {
+	departing := podName(cluster, current-1)
+
+	if failoverInProgress(cluster) || switchoverInProgress(cluster) {
+		return current, fmt.Sprintf("waiting for the leader change to end before removing %s", departing)
+	}
+	if cluster.Status.Leader == "" {
+		return current, fmt.Sprintf("waiting for a leader to remove %s", departing)
+	}
+
+	// Never remove the leader: move leadership to a remaining pod first
+	if cluster.Status.Leader == departing {
+		for ordinal := int32(0); ordinal < cluster.Spec.Replicas; ordinal++ {
+			target := podName(cluster, ordinal)
+			if ready, err := r.podReady(ctx, cluster, target); err != nil || !ready {
+				continue
+			}
+			node, err := r.nodeForPod(ctx, cluster, target)
+			if err == nil {
+				err = r.callRAMD(ctx, cluster, http.MethodPost, "/api/v1/cluster/switchover",
+					ramdSwitchoverRequest{TargetNode: node.Hostname}, nil)
+			}
+			if err != nil {
+				return current, fmt.Sprintf("moving leadership from %s to %s: %v", departing, target, err)
+			}
+			r.event(cluster, corev1.EventTypeNormal, "LeaderMoved", "moving leadership from %s to %s to scale down",
+				departing, target)
+			return current, fmt.Sprintf("moving leadership off %s", departing)
+		}
+		return current, fmt.Sprintf("no remaining pod is ready to take over leadership from %s", departing)
+	}
+
+	// The departing pod leaves the Services before it leaves raft
+	pod := &corev1.Pod{}
+	err := r.Get(ctx, types.NamespacedName{Name: departing, Namespace: cluster.Namespace}, pod)
+	if err != nil && !errors.IsNotFound(err) {
+		return current, fmt.Sprintf("draining %s: %v", departing, err)
+	}
+	if err == nil && pod.Labels[RoleLabel] != RoleDraining {
+		return current, fmt.Sprintf("draining %s", departing)
+	}
+
+	members, err := r.ramdMembers(ctx, cluster)
+	if err != nil {
+		return current, fmt.Sprintf("RAMD unavailable: %v", err)
+	}
+	if id, ok := members[departing]; ok {
+		if err := r.callRAMD(ctx, cluster, http.MethodPost, "/api/v1/cluster/remove-node",
+			ramdRemoveNodeRequest{NodeID: id}, nil); err != nil {
+			return current, fmt.Sprintf("removing %s from raft: %v", departing, err)
+		}
+		r.event(cluster, corev1.EventTypeNormal, "MemberRemoved", "removed %s (node %d) from raft", departing, id)
+	}
+
+	// Out of raft; the StatefulSet may now delete the pod
+	if current-1 > cluster.Spec.Replicas {
+		return current - 1, fmt.Sprintf("removed %s, %d more to remove", departing, current-1-cluster.Spec.Replicas)
+	}
+	return current - 1, ""
+}
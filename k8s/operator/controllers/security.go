@@ -0,0 +1,88 @@
+package controllers
+
+import (
+	corev1 "k8s.io/api/core/v1"
+
+	ramv1 "github.com/pgelephant/pgraft/k8s/operator/api/v1"
+)
+
+// postgresUID is the user and group of postgres in the PostgreSQL image,
+// which the pods run as by default
+const postgresUID = int64(999)
+
+// dataVolumePath is where the PostgreSQL volume is mounted
+const dataVolumePath = "/var/lib/postgresql/data"
+
+// dataDirectory returns the members' data directory.  Clusters created
+// before the pods ran as postgres keep theirs at the root of the volume,
+// which the image's entrypoint handed to postgres as root; the others have
+// it in a directory of its own, as initdb cannot take over a volume root
+// that postgres does not own.
+func dataDirectory(cluster *ramv1.PostgreSQLCluster) string {
+	if cluster.Status.DataDirectory != "" {
+		return cluster.Status.DataDirectory
+	}
+	return dataVolumePath + "/pgdata"
+}
+
+// defaultPodSecurityContext returns the security context of the pods
+// whose spec sets none, which runs them as postgres within the restricted
+// Pod Security Standard
+func defaultPodSecurityContext() *corev1.PodSecurityContext {
+	uid := postgresUID
+	runAsNonRoot := true
+	changePolicy := corev1.FSGroupChangeOnRootMismatch
+	return &corev1.PodSecurityContext{
+		RunAsUser:           &uid,
+		RunAsGroup:          &uid,
+		RunAsNonRoot:        &runAsNonRoot,
+		FSGroup:             &uid,
+		FSGroupChangePolicy: &changePolicy,
+		SeccompProfile:      &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+	}
+}
+
+// defaultSecurityContext returns the security context of the containers
+// whose spec sets none
+func defaultSecurityContext() *corev1.SecurityContext {
+	allowPrivilegeEscalation := false
+	readOnlyRootFilesystem := true
+	return &corev1.SecurityContext{
+		AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+		ReadOnlyRootFilesystem:   &readOnlyRootFilesystem,
+		Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+	}
+}
+
+// addSecurityContext sets the security context of a pod of the cluster,
+// pod's if it is set, and of its containers: ramd's from spec.ramd and the
+// others' from spec.postgresql.  Every container gets a writable /tmp and
+// socket directory, so that they run with a read-only root filesystem.
+func addSecurityContext(cluster *ramv1.PostgreSQLCluster, spec *corev1.PodSpec, pod *corev1.PodSecurityContext) {
+	spec.SecurityContext = pod
+	if spec.SecurityContext == nil {
+		spec.SecurityContext = defaultPodSecurityContext()
+	}
+
+	secure := func(container *corev1.Container) {
+		container.SecurityContext = cluster.Spec.PostgreSQL.SecurityContext
+		if container.Name == "ramd" {
+			container.SecurityContext = cluster.Spec.RAMD.SecurityContext
+		}
+		if container.SecurityContext == nil {
+			container.SecurityContext = defaultSecurityContext()
+		}
+		container.VolumeMounts = append(container.VolumeMounts,
+			corev1.VolumeMount{Name: "tmp", MountPath: "/tmp"},
+			corev1.VolumeMount{Name: "run", MountPath: "/var/run/postgresql"})
+	}
+	for i := range spec.InitContainers {
+		secure(&spec.InitContainers[i])
+	}
+	for i := range spec.Containers {
+		secure(&spec.Containers[i])
+	}
+	spec.Volumes = append(spec.Volumes,
+		corev1.Volume{Name: "tmp", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+		corev1.Volume{Name: "run", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}})
+}
@@ -0,0 +1,145 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ramv1 "github.com/pgelephant/pgraft/k8s/operator/api/v1"
+)
+
+// ConditionStandby is true while the cluster is a standby of its primary
+// cluster; once promoted it is false with reason Promoted
+const ConditionStandby = "Standby"
+
+// Reasons of the Standby condition
+const (
+	StandbyReplaying = "Replaying"
+	StandbyPromoting = "Promoting"
+	StandbyPromoted  = "Promoted"
+)
+
+// standbyActive reports whether the cluster is a standby that has not been
+// promoted.  A standby leaves leader changes to its primary: it neither
+// fails over nor switches over.
+func standbyActive(cluster *ramv1.PostgreSQLCluster) bool {
+	if cluster.Spec.Standby == nil {
+		return false
+	}
+	condition := meta.FindStatusCondition(cluster.Status.Conditions, ConditionStandby)
+	return condition == nil || condition.Reason != StandbyPromoted
+}
+
+// inlineEnv renders env as NAME=value words to prefix a shell command with
+func inlineEnv(env []corev1.EnvVar) string {
+	words := make([]string, 0, len(env))
+	for _, e := range env {
+		words = append(words, e.Name+"="+e.Value)
+	}
+	return strings.Join(words, " ")
+}
+
+// addStandby gives the postgresql container of a standby replaying a WAL
+// archive wal-g, the archive's credentials and its key, for its
+// restore_command.  A standby that also archives its own WAL has both
+// Secrets as its environment, so the two repositories must take the same
+// variables; the key of its own archive, if encrypted, applies to the
+// primary's too unless that is encrypted as well.
+func addStandby(cluster *ramv1.PostgreSQLCluster, spec *corev1.PodSpec) {
+	repository := cluster.Spec.Standby.Repository
+	if repository == nil {
+		return
+	}
+	addWALG(cluster, spec)
+
+	postgresql := &spec.Containers[0]
+	mounted := map[string]bool{}
+	for _, mount := range postgresql.VolumeMounts {
+		mounted[mount.MountPath] = true
+	}
+	if !mounted[walgDir] {
+		postgresql.VolumeMounts = append(postgresql.VolumeMounts, corev1.VolumeMount{Name: "wal-g", MountPath: walgDir})
+	}
+	addWALGKey(spec, postgresql, cluster.Spec.Standby.Encryption, "standby-key", standbyKeyDir)
+	if repository.CredentialsSecret == "" {
+		return
+	}
+	postgresql.EnvFrom = append(postgresql.EnvFrom, corev1.EnvFromSource{
+		SecretRef: &corev1.SecretEnvSource{
+			LocalObjectReference: corev1.LocalObjectReference{Name: repository.CredentialsSecret},
+		},
+	})
+	if !mounted[walgCredentialsDir] {
+		postgresql.VolumeMounts = append(postgresql.VolumeMounts, corev1.VolumeMount{
+			Name:      "standby-credentials",
+			MountPath: walgCredentialsDir,
+			ReadOnly:  true,
+		})
+		spec.Volumes = append(spec.Volumes, corev1.Volume{
+			Name: "standby-credentials",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: repository.CredentialsSecret},
+			},
+		})
+	}
+}
+
+// reconcileStandby records in the Standby condition what a standby follows
+// and, once spec.standby.promote is set, promotes the first pod, which
+// holds the replayed data, through RAMD.  A promoted cluster stays
+// promoted.  Like reconcileFailover it returns how soon to look again.
+func (r *PostgreSQLClusterReconciler) reconcileStandby(ctx context.Context, cluster *ramv1.PostgreSQLCluster) (time.Duration, error) {
+	if !standbyActive(cluster) {
+		return 0, nil
+	}
+	b, err := r.bootstrapFor(ctx, cluster)
+	if err != nil {
+		return 0, err
+	}
+
+	before := cluster.Status.DeepCopy()
+	condition := metav1.Condition{
+		Type:               ConditionStandby,
+		Status:             metav1.ConditionTrue,
+		Reason:             StandbyReplaying,
+		Message:            b.description,
+		ObservedGeneration: cluster.Generation,
+	}
+	requeue := time.Duration(0)
+
+	if cluster.Spec.Standby.Promote {
+		pod := podName(cluster, 0)
+		condition.Reason = StandbyPromoting
+		if bootstrapPending(cluster) {
+			condition.Message = fmt.Sprintf("waiting for %s to be bootstrapped before promoting it", pod)
+			requeue = failoverRequeue
+		} else {
+			node, err := r.nodeForPod(ctx, cluster, pod)
+			if err == nil {
+				err = r.callRAMD(ctx, cluster, http.MethodPost, fmt.Sprintf("/api/v1/promote/%d", node.NodeID), nil, nil)
+			}
+			if err != nil {
+				condition.Message = fmt.Sprintf("promoting %s: %v", pod, err)
+				requeue = failoverRequeue
+			} else {
+				condition.Status = metav1.ConditionFalse
+				condition.Reason = StandbyPromoted
+				condition.Message = fmt.Sprintf("promoted %s out of standby", pod)
+				r.event(cluster, corev1.EventTypeNormal, "StandbyPromoted", "promoted %s, no longer a %s", pod, b.description)
+			}
+		}
+	}
+	meta.SetStatusCondition(&cluster.Status.Conditions, condition)
+
+	if equality.Semantic.DeepEqual(before, &cluster.Status) {
+		return requeue, nil
+	}
+	return requeue, r.Status().Update(ctx, cluster)
+}
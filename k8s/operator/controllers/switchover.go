@@ -0,0 +1,182 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ramv1 "github.com/pgelephant/pgraft/k8s/operator/api/v1"
+)
+
+// Phases of a switchover, in order
+const (
+	SwitchoverTransferring = "Transferring"
+	SwitchoverConfirming   = "Confirming"
+	SwitchoverCompleted    = "Completed"
+	SwitchoverFailed       = "Failed"
+)
+
+// ConditionSwitchoverInProgress is true while a switchover runs; when
+// false, its reason says how the last one ended
+const ConditionSwitchoverInProgress = "SwitchoverInProgress"
+
+// switchoverTimeout bounds a switchover, from the request to RAMD until
+// the target is confirmed as the leader
+const switchoverTimeout = 2 * time.Minute
+
+// ramdSwitchoverRequest is the body of POST /api/v1/cluster/switchover
+type ramdSwitchoverRequest struct {
+	TargetNode string `json:"target_node"`
+	Force      string `json:"force,omitempty"`
+}
+
+// switchoverInProgress reports whether a switchover has started and not ended
+func switchoverInProgress(cluster *ramv1.PostgreSQLCluster) bool {
+	switchover := cluster.Status.Switchover
+	return switchover != nil && switchover.Phase != SwitchoverCompleted && switchover.Phase != SwitchoverFailed
+}
+
+// switchoverRequested reports whether the spec requests a switchover that
+// has not been carried out
+func switchoverRequested(cluster *ramv1.PostgreSQLCluster) bool {
+	spec, status := cluster.Spec.Switchover, cluster.Status.Switchover
+	if spec == nil || spec.TargetPod == "" {
+		return false
+	}
+	return status == nil || status.TargetPod != spec.TargetPod || !status.RequestedAt.Equal(&spec.RequestedAt)
+}
+
+// reconcileSwitchover carries out a switchover requested in
+// Spec.Switchover: RAMD transfers raft leadership to the target pod and
+// promotes it, and the operator waits until RAMD reports the target as the
+// leader.  Until then the old leader is fenced and no pod is labeled
+// primary.  A request made during a failover waits for it to end.  Like
+// reconcileFailover it returns how soon to look again.
+func (r *PostgreSQLClusterReconciler) reconcileSwitchover(ctx context.Context, cluster *ramv1.PostgreSQLCluster) (time.Duration, error) {
+	before := cluster.Status.DeepCopy()
+	requeue := time.Duration(0)
+
+	if switchoverRequested(cluster) && !switchoverInProgress(cluster) {
+		if failoverInProgress(cluster) {
+			return failoverRequeue, nil
+		}
+		if hibernationInProgress(cluster) {
+			return hibernationRequeue, nil
+		}
+		spec := cluster.Spec.Switchover
+		switchover := &ramv1.SwitchoverStatus{
+			Phase:       SwitchoverTransferring,
+			TargetPod:   spec.TargetPod,
+			RequestedAt: spec.RequestedAt,
+			OldLeader:   cluster.Status.Leader,
+			StartedAt:   metav1.Now(),
+		}
+		cluster.Status.Switchover = switchover
+
+		if standbyActive(cluster) {
+			r.endSwitchover(cluster, SwitchoverFailed, "a standby cluster takes its leader from its primary")
+		} else if _, ok := podForHostname(cluster, spec.TargetPod); !ok {
+			r.endSwitchover(cluster, SwitchoverFailed,
+				fmt.Sprintf("%s is not a PostgreSQL pod of the cluster", spec.TargetPod))
+		} else if spec.TargetPod == cluster.Status.Leader {
+			r.endSwitchover(cluster, SwitchoverCompleted, fmt.Sprintf("%s is already the leader", spec.TargetPod))
+		} else {
+			cluster.Status.Leader = ""
+			r.event(cluster, corev1.EventTypeNormal, "SwitchoverStarted", "switching over from %s to %s",
+				switchover.OldLeader, switchover.TargetPod)
+		}
+	}
+
+	for switchoverInProgress(cluster) {
+		switchover := cluster.Status.Switchover
+		if time.Since(switchover.StartedAt.Time) > switchoverTimeout {
+			r.endSwitchover(cluster, SwitchoverFailed,
+				fmt.Sprintf("gave up after %v: %s", switchoverTimeout, switchover.Message))
+			break
+		}
+		phase := switchover.Phase
+		r.switchoverStep(ctx, cluster, switchover)
+		if switchover.Phase == phase {
+			requeue = failoverRequeue
+			break
+		}
+	}
+
+	if cluster.Status.Switchover != nil {
+		condition := metav1.Condition{
+			Type:               ConditionSwitchoverInProgress,
+			Status:             metav1.ConditionFalse,
+			Reason:             cluster.Status.Switchover.Phase,
+			Message:            cluster.Status.Switchover.Message,
+			ObservedGeneration: cluster.Generation,
+		}
+		if switchoverInProgress(cluster) {
+			condition.Status = metav1.ConditionTrue
+		}
+		meta.SetStatusCondition(&cluster.Status.Conditions, condition)
+	}
+
+	if equality.Semantic.DeepEqual(before, &cluster.Status) {
+		return requeue, nil
+	}
+	updateEndpoints(cluster)
+	return requeue, r.Status().Update(ctx, cluster)
+}
+
+// switchoverStep takes the next step of a switchover, advancing its phase
+// if the step succeeded and otherwise recording what it waits for
+func (r *PostgreSQLClusterReconciler) switchoverStep(ctx context.Context, cluster *ramv1.PostgreSQLCluster, switchover *ramv1.SwitchoverStatus) {
+	switch switchover.Phase {
+	case SwitchoverTransferring:
+		node, err := r.nodeForPod(ctx, cluster, switchover.TargetPod)
+		if err != nil {
+			switchover.Message = fmt.Sprintf("RAMD unavailable: %v", err)
+			return
+		}
+		request := ramdSwitchoverRequest{TargetNode: node.Hostname}
+		if cluster.Spec.Switchover != nil && cluster.Spec.Switchover.Force {
+			request.Force = "true"
+		}
+		if err := r.callRAMD(ctx, cluster, http.MethodPost, "/api/v1/cluster/switchover", request, nil); err != nil {
+			switchover.Message = fmt.Sprintf("transferring leadership to %s: %v", switchover.TargetPod, err)
+			return
+		}
+		switchover.Phase = SwitchoverConfirming
+		switchover.Message = fmt.Sprintf("waiting for RAMD to report %s as the leader", switchover.TargetPod)
+
+	case SwitchoverConfirming:
+		leader, err := r.queryLeader(ctx, cluster)
+		if err != nil || leader != switchover.TargetPod {
+			return
+		}
+		if ready, err := r.podReady(ctx, cluster, leader); err != nil || !ready {
+			switchover.Message = fmt.Sprintf("waiting for %s to be ready", leader)
+			return
+		}
+		cluster.Status.Leader = leader
+		r.endSwitchover(cluster, SwitchoverCompleted, "")
+	}
+}
+
+// endSwitchover records how the switchover ended
+func (r *PostgreSQLClusterReconciler) endSwitchover(cluster *ramv1.PostgreSQLCluster, phase, message string) {
+	switchover := cluster.Status.Switchover
+	switchover.Phase = phase
+	switchover.Message = message
+	now := metav1.Now()
+	switchover.CompletedAt = &now
+
+	if phase == SwitchoverFailed {
+		r.event(cluster, corev1.EventTypeWarning, "SwitchoverFailed", "switchover to %s: %s",
+			switchover.TargetPod, message)
+		return
+	}
+	r.event(cluster, corev1.EventTypeNormal, "SwitchoverCompleted", "switched over to %s in %v",
+		switchover.TargetPod, now.Sub(switchover.StartedAt.Time).Round(time.Second))
+}
@@ -0,0 +1,322 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	ramv1 "github.com/pgelephant/pgraft/k8s/operator/api/v1"
+)
+
+// teardownFinalizer holds a deleted cluster until it has been taken down
+const teardownFinalizer = "ram.pgelephant.com/teardown"
+
+// ConditionTearingDown is true while a deleted cluster is taken down; its
+// reason is the step under way
+const ConditionTearingDown = "TearingDown"
+
+// teardownRequeue is how often a teardown in progress is advanced
+const teardownRequeue = 5 * time.Second
+
+// Values of the TeardownSpec policies
+const (
+	PolicyRetain = "Retain"
+	PolicyDelete = "Delete"
+)
+
+// teardownStep is one step of a teardown.  run returns what the step is
+// waiting for, or an empty string once it is done.
+type teardownStep struct {
+	reason string
+	run    func(ctx context.Context, cluster *ramv1.PostgreSQLCluster) (string, error)
+}
+
+// teardownSteps returns the steps of a teardown in order
+func (r *PostgreSQLClusterReconciler) teardownSteps() []teardownStep {
+	return []teardownStep{
+		{"FinalBackup", r.finalBackup},
+		{"RemovingMembers", r.removeMembers},
+		{"StoppingPostgreSQL", r.stopPostgreSQL},
+		{"DeletingVolumes", r.deleteVolumes},
+		{"DeletingBackups", r.deleteBackups},
+		{"DeletingStorageClass", r.deleteStorageClass},
+	}
+}
+
+// ensureFinalizer adds the teardown finalizer to a cluster not being deleted
+func (r *PostgreSQLClusterReconciler) ensureFinalizer(ctx context.Context, cluster *ramv1.PostgreSQLCluster) error {
+	if controllerutil.ContainsFinalizer(cluster, teardownFinalizer) {
+		return nil
+	}
+	patch := client.MergeFrom(cluster.DeepCopy())
+	controllerutil.AddFinalizer(cluster, teardownFinalizer)
+	return r.Patch(ctx, cluster, patch)
+}
+
+// teardown takes a deleted cluster down one step at a time, resuming at the
+// step the TearingDown condition records, and removes the finalizer once
+// every step is done so that the cluster's resources are garbage-collected
+func (r *PostgreSQLClusterReconciler) teardown(ctx context.Context, cluster *ramv1.PostgreSQLCluster) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(cluster, teardownFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	steps := r.teardownSteps()
+	first := 0
+	if condition := meta.FindStatusCondition(cluster.Status.Conditions, ConditionTearingDown); condition != nil {
+		for i, step := range steps {
+			if step.reason == condition.Reason {
+				first = i
+			}
+		}
+	} else {
+		r.event(cluster, corev1.EventTypeNormal, "TearingDown", "taking the cluster down")
+	}
+
+	for _, step := range steps[first:] {
+		message, err := step.run(ctx, cluster)
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("teardown: %s: %w", step.reason, err)
+		}
+		if message == "" {
+			continue
+		}
+
+		before := cluster.Status.DeepCopy()
+		meta.SetStatusCondition(&cluster.Status.Conditions, metav1.Condition{
+			Type:               ConditionTearingDown,
+			Status:             metav1.ConditionTrue,
+			Reason:             step.reason,
+			Message:            message,
+			ObservedGeneration: cluster.Generation,
+		})
+		if !equality.Semantic.DeepEqual(before, &cluster.Status) {
+			if err := r.Status().Update(ctx, cluster); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{RequeueAfter: teardownRequeue}, nil
+	}
+
+	r.event(cluster, corev1.EventTypeNormal, "TornDown", "the cluster has been taken down")
+	patch := client.MergeFrom(cluster.DeepCopy())
+	controllerutil.RemoveFinalizer(cluster, teardownFinalizer)
+	return ctrl.Result{}, r.Patch(ctx, cluster, patch)
+}
+
+// runJob creates the Job name with spec unless it exists and returns its
+// result.  The Job belongs to the cluster and goes with it.
+func (r *PostgreSQLClusterReconciler) runJob(ctx context.Context, cluster *ramv1.PostgreSQLCluster, name string, spec batchv1.JobSpec) (string, error) {
+	job := &batchv1.Job{}
+	err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: cluster.Namespace}, job)
+	if err == nil {
+		return jobResult(job), nil
+	}
+	if !errors.IsNotFound(err) {
+		return "", err
+	}
+
+	job = &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: cluster.Namespace,
+			Labels:    spec.Template.Labels,
+		},
+		Spec: spec,
+	}
+	if err := controllerutil.SetControllerReference(cluster, job, r.Scheme); err != nil {
+		return "", err
+	}
+	if err := r.Create(ctx, job); err != nil {
+		return "", err
+	}
+	return BackupRunning, nil
+}
+
+// finalBackup takes a last backup while the members still run.  A failed
+// backup is reported but does not hold up the teardown.
+func (r *PostgreSQLClusterReconciler) finalBackup(ctx context.Context, cluster *ramv1.PostgreSQLCluster) (string, error) {
+	if !cluster.Spec.Teardown.FinalBackup || !backupConfigured(cluster) {
+		return "", nil
+	}
+	name := cluster.Name + "-final-backup"
+	result, err := r.runJob(ctx, cluster, name, backupJobSpec(cluster))
+	if err != nil {
+		return "", err
+	}
+	switch result {
+	case BackupRunning:
+		return fmt.Sprintf("waiting for the final backup %s", name), nil
+	case BackupFailed:
+		r.event(cluster, corev1.EventTypeWarning, "FinalBackupFailed", "final backup %s failed", name)
+	default:
+		r.event(cluster, corev1.EventTypeNormal, "FinalBackupSucceeded", "final backup %s succeeded", name)
+	}
+	return "", nil
+}
+
+// removeMembers removes the members other than the leader from raft, one
+// per call from the highest ordinal down, so that the leader is the last
+// member when PostgreSQL is stopped.  Without RAMD the members cannot
+// leave raft; that is reported and the teardown goes on.
+func (r *PostgreSQLClusterReconciler) removeMembers(ctx context.Context, cluster *ramv1.PostgreSQLCluster) (string, error) {
+	members, err := r.ramdMembers(ctx, cluster)
+	if err != nil {
+		r.event(cluster, corev1.EventTypeWarning, "MembersNotRemoved", "stopping without removing the members from raft: %v", err)
+		return "", nil
+	}
+	leader, err := r.queryLeader(ctx, cluster)
+	if err != nil {
+		leader = cluster.Status.Leader
+	}
+
+	pods := make([]string, 0, len(members))
+	for pod := range members {
+		if pod != leader {
+			pods = append(pods, pod)
+		}
+	}
+	if len(pods) == 0 || len(members) == 1 {
+		return "", nil
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		a, _ := podOrdinal(cluster, pods[i])
+		b, _ := podOrdinal(cluster, pods[j])
+		return a > b
+	})
+
+	departing := pods[0]
+	id := members[departing]
+	if err := r.callRAMD(ctx, cluster, http.MethodPost, "/api/v1/cluster/remove-node", ramdRemoveNodeRequest{NodeID: id}, nil); err != nil {
+		return fmt.Sprintf("removing %s (node %d) from raft: %v", departing, id, err), nil
+	}
+	r.event(cluster, corev1.EventTypeNormal, "MemberRemoved", "removed %s (node %d) from raft", departing, id)
+	return fmt.Sprintf("removed %s from raft, leaving %d", departing, len(members)-1), nil
+}
+
+// stopPostgreSQL scales the StatefulSet to zero and waits for the pods to
+// shut PostgreSQL down
+func (r *PostgreSQLClusterReconciler) stopPostgreSQL(ctx context.Context, cluster *ramv1.PostgreSQLCluster) (string, error) {
+	statefulSet := &appsv1.StatefulSet{}
+	err := r.Get(ctx, types.NamespacedName{Name: cluster.Name + "-postgresql", Namespace: cluster.Namespace}, statefulSet)
+	if errors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if statefulSet.Spec.Replicas == nil || *statefulSet.Spec.Replicas != 0 {
+		patch := client.MergeFrom(statefulSet.DeepCopy())
+		replicas := int32(0)
+		statefulSet.Spec.Replicas = &replicas
+		if err := r.Patch(ctx, statefulSet, patch); err != nil {
+			return "", err
+		}
+	}
+	if statefulSet.Status.Replicas > 0 {
+		return fmt.Sprintf("waiting for %d PostgreSQL pods to stop", statefulSet.Status.Replicas), nil
+	}
+	return "", nil
+}
+
+// deleteVolumes deletes the PostgreSQL volumes under the Delete data policy
+func (r *PostgreSQLClusterReconciler) deleteVolumes(ctx context.Context, cluster *ramv1.PostgreSQLCluster) (string, error) {
+	if cluster.Spec.Teardown.DataPolicy != PolicyDelete {
+		return "", nil
+	}
+	claims := &corev1.PersistentVolumeClaimList{}
+	if err := r.List(ctx, claims, client.InNamespace(cluster.Namespace),
+		client.MatchingLabels(postgresqlPodLabels(cluster))); err != nil {
+		return "", err
+	}
+	for i := range claims.Items {
+		if err := r.Delete(ctx, &claims.Items[i]); err != nil && !errors.IsNotFound(err) {
+			return "", err
+		}
+	}
+	if len(claims.Items) > 0 {
+		r.event(cluster, corev1.EventTypeNormal, "VolumesDeleted", "deleted %d PostgreSQL volumes", len(claims.Items))
+	}
+	return "", nil
+}
+
+// deleteBackups deletes every backup and the archived WAL from the
+// repository under the Delete backup policy, with a Job running wal-g.
+// A failed deletion is reported but does not hold up the teardown.
+func (r *PostgreSQLClusterReconciler) deleteBackups(ctx context.Context, cluster *ramv1.PostgreSQLCluster) (string, error) {
+	repository := archiveRepository(cluster)
+	if cluster.Spec.Teardown.BackupPolicy != PolicyDelete || repository == nil {
+		return "", nil
+	}
+
+	backoffLimit := int32(1)
+	container := corev1.Container{
+		Name:    "delete-backups",
+		Image:   cluster.Spec.PostgreSQL.Backup.Image,
+		Command: []string{"/usr/local/bin/wal-g", "delete", "everything", "FORCE", "--confirm"},
+		Env:     walgEnv(cluster, repository),
+	}
+	spec := batchv1.JobSpec{
+		BackoffLimit: &backoffLimit,
+		Template: corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+				"app":       "postgresql-cluster",
+				"cluster":   cluster.Name,
+				"component": "teardown",
+			}},
+			Spec: corev1.PodSpec{
+				ImagePullSecrets: cluster.Spec.ImagePullSecrets,
+				RestartPolicy:    corev1.RestartPolicyNever,
+			},
+		},
+	}
+	if repository.CredentialsSecret != "" {
+		container.EnvFrom = []corev1.EnvFromSource{{
+			SecretRef: &corev1.SecretEnvSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: repository.CredentialsSecret},
+			},
+		}}
+		container.VolumeMounts = []corev1.VolumeMount{{
+			Name:      "wal-g-credentials",
+			MountPath: walgCredentialsDir,
+			ReadOnly:  true,
+		}}
+		spec.Template.Spec.Volumes = []corev1.Volume{{
+			Name: "wal-g-credentials",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: repository.CredentialsSecret},
+			},
+		}}
+	}
+	spec.Template.Spec.Containers = []corev1.Container{container}
+
+	name := cluster.Name + "-delete-backups"
+	result, err := r.runJob(ctx, cluster, name, spec)
+	if err != nil {
+		return "", err
+	}
+	switch result {
+	case BackupRunning:
+		return fmt.Sprintf("waiting for %s to delete the backups", name), nil
+	case BackupFailed:
+		r.event(cluster, corev1.EventTypeWarning, "BackupsNotDeleted", "%s failed to delete the backups", name)
+	default:
+		r.event(cluster, corev1.EventTypeNormal, "BackupsDeleted", "deleted the backups and archived WAL")
+	}
+	return "", nil
+}
@@ -0,0 +1,278 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	ramv1 "github.com/pgelephant/pgraft/k8s/operator/api/v1"
+)
+
+// Values of UpdateStrategySpec.Type
+const (
+	// UpdateSwitchover has the operator update the replicas first and the
+	// leader last, after switching over to an updated replica
+	UpdateSwitchover = "Switchover"
+
+	// UpdateRollingUpdate leaves the update to the StatefulSet
+	UpdateRollingUpdate = "RollingUpdate"
+)
+
+// Phases of an update with the Switchover strategy, in order
+const (
+	UpdateUpdatingReplicas = "UpdatingReplicas"
+	UpdateSwitchingOver    = "SwitchingOver"
+	UpdateUpdatingLeader   = "UpdatingLeader"
+	UpdateCompleted        = "Completed"
+)
+
+// RestartedAtAnnotation on a cluster restarts its PostgreSQL pods, as an
+// update with spec.updateStrategy, whenever its value changes; kubectl
+// pgram restart sets it to the time of the request
+const RestartedAtAnnotation = "ram.pgelephant.com/restartedAt"
+
+// ConditionUpdateInProgress is true while the pods are updated with the
+// Switchover strategy
+const ConditionUpdateInProgress = "UpdateInProgress"
+
+// updateRequeue is how often an update in progress is advanced
+const updateRequeue = 10 * time.Second
+
+// updateInProgress reports whether an update has started and not completed
+func updateInProgress(cluster *ramv1.PostgreSQLCluster) bool {
+	return cluster.Status.Update != nil && cluster.Status.Update.Phase != UpdateCompleted
+}
+
+// statefulSetUpdateStrategy returns the update strategy of the StatefulSet:
+// with the Switchover strategy the operator deletes the pods to update them,
+// and with RollingUpdate a partition holds them outside the maintenance
+// window
+func statefulSetUpdateStrategy(cluster *ramv1.PostgreSQLCluster) appsv1.StatefulSetUpdateStrategy {
+	if cluster.Spec.UpdateStrategy.Type == UpdateRollingUpdate {
+		strategy := appsv1.StatefulSetUpdateStrategy{Type: appsv1.RollingUpdateStatefulSetStrategyType}
+		if !maintenanceAllowed(cluster) {
+			partition := podCount(cluster)
+			strategy.RollingUpdate = &appsv1.RollingUpdateStatefulSetStrategy{Partition: &partition}
+		}
+		return strategy
+	}
+	return appsv1.StatefulSetUpdateStrategy{Type: appsv1.OnDeleteStatefulSetStrategyType}
+}
+
+// reconcileUpdate brings the PostgreSQL pods to the StatefulSet's update
+// revision with the Switchover strategy.  The replicas are deleted one at a
+// time, highest ordinal first, each once every pod is ready; then the
+// leadership moves to an updated replica and the old leader is updated
+// last, so that the cluster fails over at most once.  Nothing is done
+// during a failover, switchover, scale operation, bootstrap or the cutover
+// of a major upgrade, outside the maintenance window, or while
+// spec.updateStrategy.paused is set.  It returns how soon to look again,
+// zero if there is nothing to wait for.
+func (r *PostgreSQLClusterReconciler) reconcileUpdate(ctx context.Context, cluster *ramv1.PostgreSQLCluster) (time.Duration, error) {
+	if cluster.Spec.UpdateStrategy.Type == UpdateRollingUpdate {
+		return 0, nil
+	}
+	statefulSet := &appsv1.StatefulSet{}
+	err := r.Get(ctx, types.NamespacedName{Name: cluster.Name + "-postgresql", Namespace: cluster.Namespace}, statefulSet)
+	if errors.IsNotFound(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	before := cluster.Status.DeepCopy()
+	requeue, err := r.updateStep(ctx, cluster, statefulSet)
+	if err != nil {
+		return 0, err
+	}
+
+	if update := cluster.Status.Update; update != nil {
+		condition := metav1.Condition{
+			Type:               ConditionUpdateInProgress,
+			Status:             metav1.ConditionFalse,
+			Reason:             update.Phase,
+			Message:            fmt.Sprintf("%d of %d pods updated", update.Updated, update.Total),
+			ObservedGeneration: cluster.Generation,
+		}
+		if updateInProgress(cluster) {
+			condition.Status = metav1.ConditionTrue
+			if update.Message != "" {
+				condition.Message += ": " + update.Message
+			}
+		}
+		meta.SetStatusCondition(&cluster.Status.Conditions, condition)
+	}
+
+	if equality.Semantic.DeepEqual(before, &cluster.Status) {
+		return requeue, nil
+	}
+	return requeue, r.Status().Update(ctx, cluster)
+}
+
+// updateStep takes the next step of the update, recording it in
+// Status.Update, and returns how soon to look again
+func (r *PostgreSQLClusterReconciler) updateStep(ctx context.Context, cluster *ramv1.PostgreSQLCluster, statefulSet *appsv1.StatefulSet) (time.Duration, error) {
+	revision := statefulSet.Status.UpdateRevision
+	if revision == "" || statefulSet.Status.ObservedGeneration < statefulSet.Generation {
+		return updateRequeue, nil
+	}
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(cluster.Namespace),
+		client.MatchingLabels(postgresqlPodLabels(cluster))); err != nil {
+		return 0, err
+	}
+
+	var outdated []*corev1.Pod
+	for i := range pods.Items {
+		if pods.Items[i].Labels[appsv1.ControllerRevisionHashLabelKey] != revision {
+			outdated = append(outdated, &pods.Items[i])
+		}
+	}
+	update := cluster.Status.Update
+	if len(outdated) == 0 {
+		if updateInProgress(cluster) {
+			now := metav1.Now()
+			update.Phase = UpdateCompleted
+			update.Updated = int32(len(pods.Items))
+			update.Total = int32(len(pods.Items))
+			update.Message = ""
+			update.CompletedAt = &now
+			r.event(cluster, corev1.EventTypeNormal, "UpdateCompleted", "updated %d pods to revision %s in %v",
+				update.Total, revision, now.Sub(update.StartedAt.Time).Round(time.Second))
+		}
+		return 0, nil
+	}
+
+	if update == nil || update.Revision != revision {
+		update = &ramv1.UpdateStatus{
+			Revision:  revision,
+			Phase:     UpdateUpdatingReplicas,
+			StartedAt: metav1.Now(),
+		}
+		cluster.Status.Update = update
+		r.event(cluster, corev1.EventTypeNormal, "UpdateStarted", "updating %d pods to revision %s",
+			len(outdated), revision)
+	}
+	update.Total = int32(len(pods.Items))
+	update.Updated = update.Total - int32(len(outdated))
+
+	switch {
+	case cluster.Spec.UpdateStrategy.Paused:
+		update.Message = "paused"
+		return 0, nil
+	case !maintenanceAllowed(cluster):
+		update.Message = "waiting for the maintenance window"
+		return 0, nil
+	case bootstrapPending(cluster):
+		update.Message = "waiting for the bootstrap"
+		return updateRequeue, nil
+	case failoverInProgress(cluster):
+		update.Message = "waiting for the failover"
+		return updateRequeue, nil
+	case switchoverInProgress(cluster):
+		update.Message = "waiting for the switchover"
+		return updateRequeue, nil
+	case meta.IsStatusConditionTrue(cluster.Status.Conditions, ConditionScaling):
+		update.Message = "waiting for scaling"
+		return updateRequeue, nil
+	case majorUpgradeCuttingOver(cluster):
+		update.Message = "waiting for the major upgrade"
+		return updateRequeue, nil
+	case hibernationInProgress(cluster):
+		update.Message = "waiting for the cluster to resume"
+		return updateRequeue, nil
+	}
+	for i := range pods.Items {
+		if pod := &pods.Items[i]; pod.DeletionTimestamp != nil || !isPodReady(pod) {
+			update.Message = fmt.Sprintf("waiting for %s to be ready", pod.Name)
+			return updateRequeue, nil
+		}
+	}
+	leader := cluster.Status.Leader
+	if leader == "" {
+		update.Message = "waiting for a leader"
+		return updateRequeue, nil
+	}
+
+	// The replicas first, from the highest ordinal down
+	sort.Slice(outdated, func(i, j int) bool {
+		a, _ := podOrdinal(cluster, outdated[i].Name)
+		b, _ := podOrdinal(cluster, outdated[j].Name)
+		return a > b
+	})
+	for _, pod := range outdated {
+		if pod.Name == leader {
+			continue
+		}
+		if err := r.Delete(ctx, pod); err != nil && !errors.IsNotFound(err) {
+			return 0, err
+		}
+		if update.SwitchoverTarget != "" {
+			update.Phase = UpdateUpdatingLeader
+		}
+		update.Message = fmt.Sprintf("updating %s", pod.Name)
+		r.event(cluster, corev1.EventTypeNormal, "UpdatingPod", "deleted %s to update it to revision %s", pod.Name, revision)
+		return updateRequeue, nil
+	}
+
+	// Only the leader is left; a single member is updated in place
+	if len(pods.Items) == 1 {
+		if err := r.Delete(ctx, outdated[0]); err != nil && !errors.IsNotFound(err) {
+			return 0, err
+		}
+		update.Phase = UpdateUpdatingLeader
+		update.Message = fmt.Sprintf("updating %s, the only member", leader)
+		r.event(cluster, corev1.EventTypeNormal, "UpdatingPod", "deleted %s to update it to revision %s", leader, revision)
+		return updateRequeue, nil
+	}
+
+	if update.Phase == UpdateSwitchingOver {
+		if time.Since(update.SwitchoverStartedAt.Time) < switchoverTimeout {
+			update.Message = fmt.Sprintf("waiting for RAMD to report %s as the leader", update.SwitchoverTarget)
+			return failoverRequeue, nil
+		}
+		r.event(cluster, corev1.EventTypeWarning, "UpdateSwitchoverTimedOut",
+			"%s did not become the leader within %v; retrying", update.SwitchoverTarget, switchoverTimeout)
+	}
+
+	// Switch over to the updated replica with the lowest ordinal
+	target := ""
+	for ordinal := int32(0); ordinal < podCount(cluster) && target == ""; ordinal++ {
+		name := podName(cluster, ordinal)
+		for i := range pods.Items {
+			if pods.Items[i].Name == name && name != leader {
+				target = name
+			}
+		}
+	}
+	node, err := r.nodeForPod(ctx, cluster, target)
+	if err != nil {
+		update.Message = fmt.Sprintf("RAMD unavailable: %v", err)
+		return failoverRequeue, nil
+	}
+	if err := r.callRAMD(ctx, cluster, http.MethodPost, "/api/v1/cluster/switchover",
+		ramdSwitchoverRequest{TargetNode: node.Hostname}, nil); err != nil {
+		update.Message = fmt.Sprintf("transferring leadership to %s: %v", target, err)
+		return failoverRequeue, nil
+	}
+	now := metav1.Now()
+	update.Phase = UpdateSwitchingOver
+	update.SwitchoverTarget = target
+	update.SwitchoverStartedAt = &now
+	update.Message = fmt.Sprintf("waiting for RAMD to report %s as the leader", target)
+	r.event(cluster, corev1.EventTypeNormal, "UpdateSwitchover", "switching over from %s to %s to update %s",
+		leader, target, leader)
+	return failoverRequeue, nil
+}
@@ -0,0 +1,270 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	ramv1 "github.com/pgelephant/pgraft/k8s/operator/api/v1"
+)
+
+// defaultVerificationTimeout is how long a verification may take if
+// spec.postgresql.backup.verification.timeout is unset
+const defaultVerificationTimeout = 2 * time.Hour
+
+// verificationName returns the name of the CronJob verifying the backups
+func verificationName(cluster *ramv1.PostgreSQLCluster) string {
+	return cluster.Name + "-backup-verification"
+}
+
+// verificationLabels returns the labels of the verification CronJob, its
+// Jobs and their volumes
+func verificationLabels(cluster *ramv1.PostgreSQLCluster) map[string]string {
+	return map[string]string{
+		"app":       "postgresql-cluster",
+		"cluster":   cluster.Name,
+		"component": "backup-verification",
+	}
+}
+
+// verificationScript returns the script of the verification Jobs: it
+// fetches the latest backup, recovers it to the end of the backup with the
+// archived WAL and promotes it, reads the catalog of every database and
+// runs the queries of $VERIFY_QUERIES in $VERIFY_DATABASE.  The instance
+// listens on no address and archives nothing, so that it cannot be mistaken
+// for a member of the cluster.
+func verificationScript() string {
+	return fmt.Sprintf(`set -e
+socket=/var/run/postgresql
+cd /tmp
+
+echo "fetching the latest backup"
+rm -rf "$PGDATA"
+mkdir -p "$PGDATA"
+chmod 700 "$PGDATA"
+%[1]s/wal-g backup-fetch "$PGDATA" LATEST
+touch "$PGDATA/recovery.signal"
+cat >> "$PGDATA/postgresql.auto.conf" <<EOF
+restore_command = '%[1]s/wal-g wal-fetch %%f %%p'
+recovery_target = 'immediate'
+recovery_target_action = 'promote'
+EOF
+
+echo "recovering"
+pg_ctl -D "$PGDATA" -w -t 3600 -o "-c listen_addresses='' -c unix_socket_directories=$socket -c archive_mode=off" start
+until [ "$(psql -h "$socket" -U postgres -d postgres -tAc 'SELECT pg_is_in_recovery()' 2>/dev/null)" = f ]; do
+  pg_ctl -D "$PGDATA" status >/dev/null || { echo "recovery failed"; exit 1; }
+  sleep 5
+done
+
+echo "reading every database"
+for db in $(psql -h "$socket" -U postgres -d postgres -tAc "SELECT datname FROM pg_database WHERE datallowconn"); do
+  psql -h "$socket" -U postgres -d "$db" -v ON_ERROR_STOP=1 -c "SELECT count(*) FROM pg_class" >/dev/null
+done
+if [ -n "$VERIFY_QUERIES" ]; then
+  echo "running the queries in $VERIFY_DATABASE"
+  printf '%%s\n' "$VERIFY_QUERIES" | psql -h "$socket" -U postgres -d "$VERIFY_DATABASE" -v ON_ERROR_STOP=1
+fi
+pg_ctl -D "$PGDATA" -m fast -w stop
+echo "verified"
+`, walgDir)
+}
+
+// verificationQueries returns the queries of spec as one script, each
+// statement ended once
+func verificationQueries(spec *ramv1.BackupVerificationSpec) string {
+	var queries []string
+	for _, query := range spec.Queries {
+		query = strings.TrimRight(strings.TrimSpace(query), ";")
+		if query != "" {
+			queries = append(queries, query+";")
+		}
+	}
+	return strings.Join(queries, "\n")
+}
+
+// verificationJobSpec returns the spec of a Job verifying the latest
+// backup.  It runs the PostgreSQL image as the members do, on a generic
+// ephemeral volume as large as theirs, which is deleted with its pod.
+func verificationJobSpec(cluster *ramv1.PostgreSQLCluster) (batchv1.JobSpec, error) {
+	verification := cluster.Spec.PostgreSQL.Backup.Verification
+	repository := archiveRepository(cluster)
+	encryption := archiveEncryption(cluster)
+	timeout, err := specDuration(verification.Timeout, defaultVerificationTimeout)
+	if err != nil {
+		return batchv1.JobSpec{}, fmt.Errorf("spec.postgresql.backup.verification.timeout: %w", err)
+	}
+	size, err := resource.ParseQuantity(cluster.Spec.PostgreSQL.Storage.Size)
+	if err != nil {
+		return batchv1.JobSpec{}, fmt.Errorf("spec.postgresql.storage.size: %w", err)
+	}
+	deadline := int64(timeout.Seconds())
+	backoffLimit := int32(0)
+	automountToken := false
+
+	volume := corev1.PersistentVolumeClaimSpec{
+		AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceStorage: size,
+			},
+		},
+	}
+	if storageClass := dataStorageClassName(cluster); storageClass != "" {
+		volume.StorageClassName = &storageClass
+	}
+
+	container := corev1.Container{
+		Name:    "verify",
+		Image:   postgresqlImage(cluster),
+		Command: []string{"/bin/sh", "-c", verificationScript()},
+		Env: append([]corev1.EnvVar{
+			{Name: "PGDATA", Value: dataVolumePath + "/pgdata"},
+			{Name: "VERIFY_DATABASE", Value: verification.Database},
+			{Name: "VERIFY_QUERIES", Value: verificationQueries(verification)},
+		}, append(walgEnv(cluster, repository), walgEncryptionEnv(encryption, walgKeyDir)...)...),
+		VolumeMounts: []corev1.VolumeMount{
+			{Name: "postgresql-data", MountPath: dataVolumePath},
+			{Name: "wal-g", MountPath: walgDir},
+		},
+		Resources: cluster.Spec.PostgreSQL.Resources,
+	}
+	spec := corev1.PodSpec{
+		ImagePullSecrets:             cluster.Spec.ImagePullSecrets,
+		RestartPolicy:                corev1.RestartPolicyNever,
+		AutomountServiceAccountToken: &automountToken,
+		Volumes: []corev1.Volume{
+			{
+				Name: "postgresql-data",
+				VolumeSource: corev1.VolumeSource{
+					Ephemeral: &corev1.EphemeralVolumeSource{
+						VolumeClaimTemplate: &corev1.PersistentVolumeClaimTemplate{
+							ObjectMeta: metav1.ObjectMeta{Labels: verificationLabels(cluster)},
+							Spec:       volume,
+						},
+					},
+				},
+			},
+		},
+	}
+	if repository.CredentialsSecret != "" {
+		container.EnvFrom = []corev1.EnvFromSource{
+			{
+				SecretRef: &corev1.SecretEnvSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: repository.CredentialsSecret},
+				},
+			},
+		}
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      "wal-g-credentials",
+			MountPath: walgCredentialsDir,
+			ReadOnly:  true,
+		})
+		spec.Volumes = append(spec.Volumes, corev1.Volume{
+			Name: "wal-g-credentials",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: repository.CredentialsSecret},
+			},
+		})
+	}
+	addWALGKey(&spec, &container, encryption, "wal-g-key", walgKeyDir)
+	addWALGKMS(cluster, &spec, &container)
+	spec.Containers = []corev1.Container{container}
+	addWALG(cluster, &spec)
+	addSecurityContext(cluster, &spec, cluster.Spec.PostgreSQL.PodSecurityContext)
+
+	return batchv1.JobSpec{
+		BackoffLimit:          &backoffLimit,
+		ActiveDeadlineSeconds: &deadline,
+		Template: corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{Labels: verificationLabels(cluster)},
+			Spec:       spec,
+		},
+	}, nil
+}
+
+// reconcileBackupVerification restores the latest backup on the schedule
+// of spec.postgresql.backup.verification through a CronJob, and records
+// the outcome in Status.Backup.Verification.  The CronJob is suspended
+// until a backup has succeeded, so that a new cluster does not report
+// failures for backups it has yet to take, and removed while verification
+// is not configured.  previous is Status.Backup before this reconcile.
+func (r *PostgreSQLClusterReconciler) reconcileBackupVerification(ctx context.Context, cluster *ramv1.PostgreSQLCluster, previous *ramv1.BackupStatus) error {
+	verification := cluster.Spec.PostgreSQL.Backup.Verification
+	if !backupConfigured(cluster) || verification == nil {
+		cronJob := &batchv1.CronJob{ObjectMeta: metav1.ObjectMeta{Name: verificationName(cluster), Namespace: cluster.Namespace}}
+		if err := r.Delete(ctx, cronJob); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		if cluster.Status.Backup != nil {
+			cluster.Status.Backup.Verification = nil
+		}
+		return nil
+	}
+
+	jobSpec, err := verificationJobSpec(cluster)
+	if err != nil {
+		return err
+	}
+	cronJob := &batchv1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      verificationName(cluster),
+			Namespace: cluster.Namespace,
+		},
+	}
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, cronJob, func() error {
+		cronJob.Labels = verificationLabels(cluster)
+
+		history := int32(3)
+		suspend := cluster.Status.Backup == nil || cluster.Status.Backup.LastSuccessfulTime == nil
+		cronJob.Spec.Schedule = verification.Schedule
+		cronJob.Spec.Suspend = &suspend
+		cronJob.Spec.ConcurrencyPolicy = batchv1.ForbidConcurrent
+		cronJob.Spec.SuccessfulJobsHistoryLimit = &history
+		cronJob.Spec.FailedJobsHistoryLimit = &history
+		cronJob.Spec.JobTemplate = batchv1.JobTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{Labels: verificationLabels(cluster)},
+			Spec:       jobSpec,
+		}
+
+		return controllerutil.SetControllerReference(cluster, cronJob, r.Scheme)
+	})
+	if err != nil {
+		return err
+	}
+
+	status := &ramv1.BackupVerificationStatus{
+		LastScheduleTime:   cronJob.Status.LastScheduleTime,
+		LastSuccessfulTime: cronJob.Status.LastSuccessfulTime,
+	}
+	last, err := r.newestJob(ctx, cluster, verificationLabels(cluster))
+	if err != nil {
+		return err
+	}
+	if last != nil {
+		status.LastJob = last.Name
+		status.LastResult = jobResult(last)
+	}
+	cluster.Status.Backup.Verification = status
+
+	if previous != nil && previous.Verification != nil &&
+		previous.Verification.LastJob == status.LastJob && previous.Verification.LastResult == status.LastResult {
+		return nil
+	}
+	switch status.LastResult {
+	case BackupSucceeded:
+		r.event(cluster, corev1.EventTypeNormal, "BackupVerified", "verification %s restored the latest backup", status.LastJob)
+	case BackupFailed:
+		r.event(cluster, corev1.EventTypeWarning, "BackupVerificationFailed",
+			"verification %s could not restore the latest backup; see its logs", status.LastJob)
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"os"
+	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
@@ -13,6 +14,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	ramv1 "github.com/pgelephant/pgraft/k8s/operator/api/v1"
+	ramv1beta1 "github.com/pgelephant/pgraft/k8s/operator/api/v1beta1"
 	"github.com/pgelephant/pgraft/k8s/operator/controllers"
 	//+kubebuilder:scaffold:imports
 )
@@ -26,6 +28,7 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(ramv1.AddToScheme(scheme))
+	utilruntime.Must(ramv1beta1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
@@ -33,11 +36,37 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var enableWebhooks bool
+	var leaderElectionNamespace string
+	var leaseDuration, renewDeadline, retryPeriod time.Duration
+	var maxConcurrentReconciles int
+	var resyncPeriod, retryBaseDelay, retryMaxDelay time.Duration
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
-	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
+	flag.BoolVar(&enableLeaderElection, "leader-elect", true,
 		"Enable leader election for controller manager. "+
-			"Enabling this will ensure there is only one active controller manager.")
+			"Enabling this will ensure there is only one active controller manager, "+
+			"so that replicas of the operator can stand by for it.")
+	flag.StringVar(&leaderElectionNamespace, "leader-election-namespace", "",
+		"Namespace of the leader election Lease; the operator's own namespace if empty. "+
+			"Required when running outside the cluster with leader election enabled.")
+	flag.DurationVar(&leaseDuration, "leader-election-lease-duration", 15*time.Second,
+		"How long standby replicas wait before taking over from a leader that stopped renewing its lease.")
+	flag.DurationVar(&renewDeadline, "leader-election-renew-deadline", 10*time.Second,
+		"How long the leader keeps trying to renew its lease before it gives up leading.")
+	flag.DurationVar(&retryPeriod, "leader-election-retry-period", 2*time.Second,
+		"How often leader election is attempted.")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 4,
+		"How many PostgreSQLClusters are reconciled at once.")
+	flag.DurationVar(&resyncPeriod, "resync-period", controllers.DefaultResyncPeriod,
+		"How often a PostgreSQLCluster is reconciled when nothing it watches changes.")
+	flag.DurationVar(&retryBaseDelay, "retry-base-delay", controllers.DefaultRetryBaseDelay,
+		"Delay before retrying a failed reconcile, doubled on every consecutive failure.")
+	flag.DurationVar(&retryMaxDelay, "retry-max-delay", controllers.DefaultRetryMaxDelay,
+		"Longest delay between retries of a failed reconcile.")
+	flag.BoolVar(&enableWebhooks, "enable-webhooks", false,
+		"Serve the defaulting, validating and conversion webhooks for PostgreSQLClusters on port 9443. "+
+			"Requires a serving certificate in the webhook server's certificate directory.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -47,12 +76,19 @@ func main() {
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		Scheme:                 scheme,
-		MetricsBindAddress:     metricsAddr,
-		Port:                   9443,
-		HealthProbeBindAddress: probeAddr,
-		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       "pgraft-operator.ram.pgelephant.com",
+		Scheme:                  scheme,
+		MetricsBindAddress:      metricsAddr,
+		Port:                    9443,
+		HealthProbeBindAddress:  probeAddr,
+		LeaderElection:          enableLeaderElection,
+		LeaderElectionID:        "pgraft-operator.ram.pgelephant.com",
+		LeaderElectionNamespace: leaderElectionNamespace,
+		LeaseDuration:           &leaseDuration,
+		RenewDeadline:           &renewDeadline,
+		RetryPeriod:             &retryPeriod,
+		// The process exits right after the manager stops, so a standby
+		// can take over at once instead of waiting out the lease
+		LeaderElectionReleaseOnCancel: true,
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
@@ -60,12 +96,24 @@ func main() {
 	}
 
 	if err = (&controllers.PostgreSQLClusterReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("pgraft-operator"),
+
+		MaxConcurrentReconciles: maxConcurrentReconciles,
+		ResyncPeriod:            resyncPeriod,
+		RetryBaseDelay:          retryBaseDelay,
+		RetryMaxDelay:           retryMaxDelay,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "PostgreSQLCluster")
 		os.Exit(1)
 	}
+	if enableWebhooks {
+		if err = (&ramv1.PostgreSQLCluster{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "PostgreSQLCluster")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
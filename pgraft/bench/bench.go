@@ -0,0 +1,305 @@
+/*
+ * bench.go
+ * Consensus benchmarks over the simulator
+ *
+ * Each benchmark builds a simulated cluster with messages encoded in the
+ * peer frame format and measures wall-clock time: proposal throughput and
+ * the latency of each proposal until the leader applied it, and the
+ * throughput of installing a snapshot on a follower that fell behind.
+ * The simulator delivers messages without delay, so latencies are the
+ * CPU cost of a consensus round on every node, not network time; that is
+ * the part a release can make faster or slower.  Results are meant to be
+ * compared between runs on the same machine.
+ */
+
+package bench
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/pgelephant/pgraft/pgraft/sim"
+)
+
+// Format version of Report, raised when fields change meaning
+const ReportVersion = 1
+
+// Ticks allowed for a cluster to elect a leader or catch up
+const benchTimeout = 1000
+
+// Proposals made before measuring, so allocations settle
+const warmupProposals = 200
+
+type Config struct {
+	// Cluster sizes and proposal payload sizes to measure, every
+	// combination of them
+	Nodes    []int `json:"nodes"`
+	Payloads []int `json:"payloads"`
+
+	// Proposals measured per combination
+	Proposals int `json:"proposals"`
+
+	// Snapshot sizes in bytes, each installed SnapshotRounds times on a
+	// cluster of every size
+	SnapshotSizes  []int `json:"snapshot_sizes"`
+	SnapshotRounds int   `json:"snapshot_rounds"`
+
+	Seed int64 `json:"seed"`
+}
+
+// Defaults used by pgraft-bench
+func DefaultConfig() Config {
+	return Config{
+		Nodes:          []int{3, 5},
+		Payloads:       []int{128, 4096},
+		Proposals:      2000,
+		SnapshotSizes:  []int{1 << 20, 16 << 20},
+		SnapshotRounds: 9,
+		Seed:           1,
+	}
+}
+
+// Latency distribution in microseconds
+type Distribution struct {
+	Min  float64 `json:"min"`
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+type Result struct {
+	// "proposals" or "snapshot"
+	Benchmark string `json:"benchmark"`
+	Nodes     int    `json:"nodes"`
+
+	// Proposal payload or snapshot size
+	Bytes int `json:"bytes"`
+
+	Operations   int           `json:"operations"`
+	Seconds      float64       `json:"seconds"`
+	OpsPerSec    float64       `json:"ops_per_sec"`
+	BytesPerSec  float64       `json:"bytes_per_sec"`
+	Latency      *Distribution `json:"latency_us,omitempty"`
+	NetworkBytes uint64        `json:"network_bytes"`
+}
+
+// Key identifying the same measurement in two reports
+func (r Result) Key() string {
+	return fmt.Sprintf("%s/nodes=%d/bytes=%d", r.Benchmark, r.Nodes, r.Bytes)
+}
+
+type Report struct {
+	Version   int       `json:"version"`
+	GoVersion string    `json:"go_version"`
+	Platform  string    `json:"platform"`
+	CPUs      int       `json:"cpus"`
+	Started   time.Time `json:"started"`
+	Config    Config    `json:"config"`
+	Results   []Result  `json:"results"`
+}
+
+// Run every benchmark of cfg; progress, if set, is called before each
+func Run(cfg Config, progress func(benchmark string, nodes, bytes int)) (*Report, error) {
+	if len(cfg.Nodes) == 0 || cfg.Proposals <= 0 {
+		return nil, errors.New("no cluster sizes or proposals to measure")
+	}
+	if cfg.SnapshotRounds <= 0 {
+		cfg.SnapshotRounds = 1
+	}
+	report := &Report{
+		Version:   ReportVersion,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+		CPUs:      runtime.NumCPU(),
+		Started:   time.Now().UTC(),
+		Config:    cfg,
+	}
+
+	for _, nodes := range cfg.Nodes {
+		for _, payload := range cfg.Payloads {
+			if progress != nil {
+				progress("proposals", nodes, payload)
+			}
+			result, err := benchProposals(cfg, nodes, payload)
+			if err != nil {
+				return nil, fmt.Errorf("proposals with %d nodes, %d bytes: %w", nodes, payload, err)
+			}
+			report.Results = append(report.Results, result)
+		}
+		for _, size := range cfg.SnapshotSizes {
+			if progress != nil {
+				progress("snapshot", nodes, size)
+			}
+			result, err := benchSnapshot(cfg, nodes, size)
+			if err != nil {
+				return nil, fmt.Errorf("snapshot with %d nodes, %d bytes: %w", nodes, size, err)
+			}
+			report.Results = append(report.Results, result)
+		}
+	}
+	return report, nil
+}
+
+func newCluster(cfg Config, nodes int) (*sim.Cluster, uint64, error) {
+	c, err := sim.New(sim.Config{
+		Nodes:       nodes,
+		PreVote:     true,
+		CheckQuorum: true,
+		Encode:      true,
+		Seed:        cfg.Seed,
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	leader, err := c.WaitLeader(benchTimeout)
+	if err != nil {
+		return nil, 0, err
+	}
+	// Do not charge one benchmark with collecting the previous one's garbage
+	runtime.GC()
+	return c, leader, nil
+}
+
+func payload(rnd *rand.Rand, size int) []byte {
+	data := make([]byte, size)
+	rnd.Read(data)
+	return data
+}
+
+// Proposals on the leader, each timed until the leader applied it
+func benchProposals(cfg Config, nodes, size int) (Result, error) {
+	c, leader, err := newCluster(cfg, nodes)
+	if err != nil {
+		return Result{}, err
+	}
+	data := payload(c.Rand(), size)
+	for i := 0; i < warmupProposals; i++ {
+		if err := c.Propose(leader, data); err != nil {
+			return Result{}, err
+		}
+	}
+	startBytes := c.NetworkBytes()
+
+	latencies := make([]time.Duration, 0, cfg.Proposals)
+	start := time.Now()
+	for i := 0; i < cfg.Proposals; i++ {
+		target := c.AppliedIndex(leader) + 1
+		proposed := time.Now()
+		if err := c.Propose(leader, data); err != nil {
+			return Result{}, err
+		}
+		if c.AppliedIndex(leader) < target {
+			err := c.TickUntil(benchTimeout, func() bool { return c.AppliedIndex(leader) >= target })
+			if err != nil {
+				return Result{}, fmt.Errorf("proposal %d not applied: %w", i, err)
+			}
+		}
+		latencies = append(latencies, time.Since(proposed))
+	}
+	elapsed := time.Since(start).Seconds()
+
+	return Result{
+		Benchmark:    "proposals",
+		Nodes:        nodes,
+		Bytes:        size,
+		Operations:   cfg.Proposals,
+		Seconds:      elapsed,
+		OpsPerSec:    float64(cfg.Proposals) / elapsed,
+		BytesPerSec:  float64(cfg.Proposals*size) / elapsed,
+		Latency:      distribution(latencies),
+		NetworkBytes: c.NetworkBytes() - startBytes,
+	}, nil
+}
+
+// Snapshots of the given size installed on a restarted follower, timed
+// from the restart until the follower applied the snapshot; throughput is
+// that of the median round, which a stray slow round does not skew
+func benchSnapshot(cfg Config, nodes, size int) (Result, error) {
+	c, leader, err := newCluster(cfg, nodes)
+	if err != nil {
+		return Result{}, err
+	}
+	var follower uint64
+	for _, id := range c.Nodes() {
+		if id != leader {
+			follower = id
+			break
+		}
+	}
+	data := payload(c.Rand(), size)
+	startBytes := c.NetworkBytes()
+
+	var total time.Duration
+	durations := make([]time.Duration, 0, cfg.SnapshotRounds)
+	for round := 0; round < cfg.SnapshotRounds; round++ {
+		if err := c.Crash(follower); err != nil {
+			return Result{}, err
+		}
+		// The follower must miss an entry so it needs the snapshot
+		if err := c.ProposeAndWait([]byte(fmt.Sprintf("round-%d", round)), benchTimeout); err != nil {
+			return Result{}, err
+		}
+		leader = c.Leader()
+		index, err := c.Compact(leader, data)
+		if err != nil {
+			return Result{}, err
+		}
+
+		start := time.Now()
+		if err := c.Restart(follower); err != nil {
+			return Result{}, err
+		}
+		err = c.TickUntil(benchTimeout, func() bool { return c.AppliedIndex(follower) >= index })
+		if err != nil {
+			return Result{}, fmt.Errorf("snapshot at %d not installed: %w", index, err)
+		}
+		elapsed := time.Since(start)
+		total += elapsed
+		durations = append(durations, elapsed)
+	}
+	latency := distribution(durations)
+	median := latency.P50 / 1e6
+
+	return Result{
+		Benchmark:    "snapshot",
+		Nodes:        nodes,
+		Bytes:        size,
+		Operations:   cfg.SnapshotRounds,
+		Seconds:      total.Seconds(),
+		OpsPerSec:    1 / median,
+		BytesPerSec:  float64(size) / median,
+		Latency:      latency,
+		NetworkBytes: c.NetworkBytes() - startBytes,
+	}, nil
+}
+
+func distribution(samples []time.Duration) *Distribution {
+	if len(samples) == 0 {
+		return nil
+	}
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var sum time.Duration
+	for _, sample := range sorted {
+		sum += sample
+	}
+	micros := func(d time.Duration) float64 { return float64(d.Nanoseconds()) / 1000 }
+	percentile := func(p float64) float64 {
+		return micros(sorted[int(p*float64(len(sorted)-1))])
+	}
+	return &Distribution{
+		Min:  micros(sorted[0]),
+		Mean: micros(sum / time.Duration(len(sorted))),
+		P50:  percentile(0.50),
+		P90:  percentile(0.90),
+		P99:  percentile(0.99),
+		Max:  micros(sorted[len(sorted)-1]),
+	}
+}
@@ -0,0 +1,101 @@
+/*
+ * compare.go
+ * Detection of regressions between two benchmark reports
+ */
+
+package bench
+
+import (
+	"fmt"
+)
+
+// A metric that got worse by more than the allowed fraction
+type Regression struct {
+	Key      string  `json:"key"`
+	Metric   string  `json:"metric"`
+	Baseline float64 `json:"baseline"`
+	Current  float64 `json:"current"`
+
+	// Fraction by which the metric got worse
+	Change float64 `json:"change"`
+}
+
+func (r Regression) String() string {
+	return fmt.Sprintf("%s %s: %.1f -> %.1f (%.0f%% worse)", r.Key, r.Metric, r.Baseline, r.Current, r.Change*100)
+}
+
+// Metrics of current worse than in baseline by more than threshold, a
+// fraction: proposals per second or snapshot bytes per second dropping,
+// or median latency rising.  Results present in only one report are not
+// compared
+func Compare(baseline, current *Report, threshold float64) []Regression {
+	previous := make(map[string]Result, len(baseline.Results))
+	for _, result := range baseline.Results {
+		previous[result.Key()] = result
+	}
+
+	var regressions []Regression
+	check := func(key, metric string, before, after float64, higherIsBetter bool) {
+		if before <= 0 {
+			return
+		}
+		change := (after - before) / before
+		if higherIsBetter {
+			change = -change
+		}
+		if change > threshold {
+			regressions = append(regressions, Regression{
+				Key:      key,
+				Metric:   metric,
+				Baseline: before,
+				Current:  after,
+				Change:   change,
+			})
+		}
+	}
+	for _, result := range current.Results {
+		before, exists := previous[result.Key()]
+		if !exists {
+			continue
+		}
+		key := result.Key()
+		if result.Benchmark == "snapshot" {
+			check(key, "bytes_per_sec", before.BytesPerSec, result.BytesPerSec, true)
+		} else {
+			check(key, "ops_per_sec", before.OpsPerSec, result.OpsPerSec, true)
+		}
+		if before.Latency != nil && result.Latency != nil {
+			check(key, "latency_us.p50", before.Latency.P50, result.Latency.P50, false)
+		}
+	}
+	return regressions
+}
+
+// Report keeping, for each measurement, the result with the highest
+// throughput among several runs of the same configuration; the best of a
+// few runs is far less sensitive to a busy machine than any single run
+func Best(reports ...*Report) *Report {
+	if len(reports) == 0 {
+		return nil
+	}
+	best := *reports[0]
+	best.Results = append([]Result(nil), reports[0].Results...)
+	position := make(map[string]int, len(best.Results))
+	for i, result := range best.Results {
+		position[result.Key()] = i
+	}
+	for _, report := range reports[1:] {
+		for _, result := range report.Results {
+			i, exists := position[result.Key()]
+			if !exists {
+				position[result.Key()] = len(best.Results)
+				best.Results = append(best.Results, result)
+				continue
+			}
+			if result.OpsPerSec > best.Results[i].OpsPerSec {
+				best.Results[i] = result
+			}
+		}
+	}
+	return &best
+}
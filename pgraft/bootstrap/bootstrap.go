@@ -0,0 +1,294 @@
+/*
+ * bootstrap.go
+ * Cluster bootstrap plans: member IDs and per-node configuration
+ *
+ * A Plan is built once for the whole cluster from the members' hosts and
+ * ports, so every node gets the same IDs and the same peer list instead
+ * of each being assembled by hand.  Members without an explicit ID get
+ * the lowest free IDs in order of host and port, which does not depend on
+ * the order the members were listed in.  For each member the plan gives
+ * the configuration document taken by pgraft_go_init_json() and the
+ * pgraft GUCs for postgresql.conf.
+ */
+
+package bootstrap
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Largest node ID accepted by the pgraft.node_id GUC
+const MaxNodeID = 1000
+
+type Member struct {
+	ID   uint64 `json:"id"`
+	Name string `json:"name"`
+	Host string `json:"host"`
+	Port int    `json:"port"`
+}
+
+func (m Member) Endpoint() string {
+	return net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+}
+
+var (
+	hostnamePattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?)*$`)
+	namePattern     = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+)
+
+// Same rule as validHost in the library
+func validHost(host string) bool {
+	return net.ParseIP(host) != nil || hostnamePattern.MatchString(host)
+}
+
+// Parse a member given as host:port, optionally followed by ,id=N and
+// ,name=NAME; IPv6 addresses are bracketed as in [::1]:7400
+func ParseMember(spec string) (Member, error) {
+	fields := strings.Split(spec, ",")
+	host, portText, err := net.SplitHostPort(fields[0])
+	if err != nil {
+		return Member{}, fmt.Errorf("member %q: %v", spec, err)
+	}
+	if !validHost(host) {
+		return Member{}, fmt.Errorf("member %q: %q is not a valid IP address or hostname", spec, host)
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil || port <= 0 || port > 65535 {
+		return Member{}, fmt.Errorf("member %q: port %q is out of range 1-65535", spec, portText)
+	}
+	m := Member{Host: host, Port: port}
+
+	for _, field := range fields[1:] {
+		key, value, found := strings.Cut(field, "=")
+		if !found {
+			return Member{}, fmt.Errorf("member %q: %q is not of the form key=value", spec, field)
+		}
+		switch key {
+		case "id":
+			id, err := strconv.ParseUint(value, 10, 64)
+			if err != nil || id == 0 || id > MaxNodeID {
+				return Member{}, fmt.Errorf("member %q: id %q is out of range 1-%d", spec, value, MaxNodeID)
+			}
+			m.ID = id
+		case "name":
+			if !namePattern.MatchString(value) {
+				return Member{}, fmt.Errorf("member %q: invalid name %q", spec, value)
+			}
+			m.Name = value
+		default:
+			return Member{}, fmt.Errorf("member %q: unknown key %q", spec, key)
+		}
+	}
+	return m, nil
+}
+
+type Plan struct {
+	ClusterName string   `json:"cluster_name"`
+	Members     []Member `json:"members"`
+
+	// Where each node finds its TLS files and keeps its data; TLSDir is
+	// empty when peer connections do not use TLS
+	TLSDir  string `json:"tls_dir,omitempty"`
+	DataDir string `json:"data_dir,omitempty"`
+
+	// Raft timing of every node; nil leaves the library's defaults
+	Tunables *Tunables `json:"tunables,omitempty"`
+}
+
+// Build a plan for members, assigning missing IDs and names
+func NewPlan(clusterName string, members []Member) (*Plan, error) {
+	if clusterName == "" {
+		return nil, errors.New("cluster name must be set")
+	}
+	if len(members) == 0 {
+		return nil, errors.New("no members given")
+	}
+	members = append([]Member(nil), members...)
+	sort.SliceStable(members, func(i, j int) bool {
+		if members[i].Host != members[j].Host {
+			return members[i].Host < members[j].Host
+		}
+		return members[i].Port < members[j].Port
+	})
+
+	usedIDs := make(map[uint64]string)
+	endpoints := make(map[string]bool)
+	for _, m := range members {
+		if endpoints[m.Endpoint()] {
+			return nil, fmt.Errorf("%s is listed twice", m.Endpoint())
+		}
+		endpoints[m.Endpoint()] = true
+		if m.ID == 0 {
+			continue
+		}
+		if other, exists := usedIDs[m.ID]; exists {
+			return nil, fmt.Errorf("id %d is given to both %s and %s", m.ID, other, m.Endpoint())
+		}
+		usedIDs[m.ID] = m.Endpoint()
+	}
+	next := uint64(1)
+	for i := range members {
+		if members[i].ID != 0 {
+			continue
+		}
+		for usedIDs[next] != "" {
+			next++
+		}
+		if next > MaxNodeID {
+			return nil, fmt.Errorf("more than %d members", MaxNodeID)
+		}
+		members[i].ID = next
+		usedIDs[next] = members[i].Endpoint()
+	}
+
+	if err := assignNames(members); err != nil {
+		return nil, err
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].ID < members[j].ID })
+	return &Plan{ClusterName: clusterName, Members: members}, nil
+}
+
+// Name unnamed members after the first label of their hostname, adding
+// the port where that is ambiguous, or node<ID> for IP addresses
+func assignNames(members []Member) error {
+	derived := make([]string, len(members))
+	counts := make(map[string]int)
+	for i, m := range members {
+		if m.Name != "" {
+			counts[m.Name]++
+			continue
+		}
+		if net.ParseIP(m.Host) != nil {
+			derived[i] = fmt.Sprintf("node%d", m.ID)
+		} else {
+			derived[i], _, _ = strings.Cut(m.Host, ".")
+		}
+		counts[derived[i]]++
+	}
+	for i := range members {
+		if members[i].Name != "" {
+			continue
+		}
+		members[i].Name = derived[i]
+		if counts[derived[i]] > 1 {
+			members[i].Name = fmt.Sprintf("%s-%d", derived[i], members[i].Port)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, m := range members {
+		if seen[m.Name] {
+			return fmt.Errorf("name %q is used by more than one member", m.Name)
+		}
+		seen[m.Name] = true
+	}
+	return nil
+}
+
+// Configuration document of pgraft_go_init_json(), matching NodeConfig
+// in pgraft_go_config.go; tunables left out keep the library's defaults
+type NodeConfig struct {
+	NodeID   uint64        `json:"node_id"`
+	Address  string        `json:"address"`
+	Port     int           `json:"port"`
+	Peers    []PeerConfig  `json:"peers"`
+	Tunables *Tunables     `json:"tunables,omitempty"`
+	TLS      *TLSConfig    `json:"tls,omitempty"`
+	Storage  StorageConfig `json:"storage"`
+}
+
+// Raft timing, the subset of TunablesConfig in pgraft_go_config.go that
+// differs between clusters
+type Tunables struct {
+	TickIntervalMs int `json:"tick_interval_ms,omitempty"`
+	ElectionTick   int `json:"election_tick,omitempty"`
+	HeartbeatTick  int `json:"heartbeat_tick,omitempty"`
+}
+
+type PeerConfig struct {
+	ID      uint64 `json:"id"`
+	Address string `json:"address"`
+	Port    int    `json:"port"`
+}
+
+type TLSConfig struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+	CAFile   string `json:"ca_file"`
+}
+
+type StorageConfig struct {
+	DataDir string `json:"data_dir,omitempty"`
+}
+
+// Names of the TLS files in each node's TLS directory
+const (
+	CAFileName   = "ca.crt"
+	CertFileName = "node.crt"
+	KeyFileName  = "node.key"
+)
+
+func (p *Plan) peers(self Member) []Member {
+	var peers []Member
+	for _, m := range p.Members {
+		if m.ID != self.ID {
+			peers = append(peers, m)
+		}
+	}
+	return peers
+}
+
+func (p *Plan) NodeConfig(m Member) NodeConfig {
+	cfg := NodeConfig{
+		NodeID:   m.ID,
+		Address:  m.Host,
+		Port:     m.Port,
+		Peers:    []PeerConfig{},
+		Tunables: p.Tunables,
+		Storage:  StorageConfig{DataDir: p.DataDir},
+	}
+	for _, peer := range p.peers(m) {
+		cfg.Peers = append(cfg.Peers, PeerConfig{ID: peer.ID, Address: peer.Host, Port: peer.Port})
+	}
+	if p.TLSDir != "" {
+		cfg.TLS = &TLSConfig{
+			CertFile: p.TLSDir + "/" + CertFileName,
+			KeyFile:  p.TLSDir + "/" + KeyFileName,
+			CAFile:   p.TLSDir + "/" + CAFileName,
+		}
+	}
+	return cfg
+}
+
+func quoteGUC(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// pgraft GUCs of member m, to be included from its postgresql.conf
+func (p *Plan) GUCs(m Member) string {
+	var peers []string
+	for _, peer := range p.peers(m) {
+		host := peer.Host
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		peers = append(peers, fmt.Sprintf("%d:%s:%d", peer.ID, host, peer.Port))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# pgraft settings of %s (node %d of cluster %s)\n", m.Name, m.ID, p.ClusterName)
+	fmt.Fprintf(&b, "pgraft.cluster_name = %s\n", quoteGUC(p.ClusterName))
+	fmt.Fprintf(&b, "pgraft.cluster_size = %d\n", len(p.Members))
+	fmt.Fprintf(&b, "pgraft.node_id = %d\n", m.ID)
+	fmt.Fprintf(&b, "pgraft.node_name = %s\n", quoteGUC(m.Name))
+	fmt.Fprintf(&b, "pgraft.address = %s\n", quoteGUC(m.Host))
+	fmt.Fprintf(&b, "pgraft.port = %d\n", m.Port)
+	fmt.Fprintf(&b, "pgraft.peers = %s\n", quoteGUC(strings.Join(peers, ",")))
+	return b.String()
+}
@@ -0,0 +1,119 @@
+/*
+ * layout.go
+ * Writing a plan out as one directory per node
+ *
+ * The output directory holds:
+ *
+ *   cluster.json                 the plan: cluster name and members
+ *   ca/ca.crt, ca/ca.key         the cluster CA, when generated here
+ *   nodes/NAME/pgraft.json       document for pgraft_go_init_json()
+ *   nodes/NAME/pgraft.conf       GUCs to include from postgresql.conf
+ *   nodes/NAME/tls/              ca.crt, node.crt and node.key
+ *
+ * Each nodes/NAME directory is copied to its host as is, with the tls
+ * directory going to the plan's TLSDir.  The CA key is needed only to
+ * issue certificates for members added later and belongs on none of them.
+ */
+
+package bootstrap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type WriteOptions struct {
+	// CA issuing the node certificates; nil when TLS is not used
+	CA *Authority
+
+	// Write the CA certificate and key to ca/, for a CA created for
+	// this plan
+	SaveCA bool
+
+	// Validity of the node certificates
+	Validity time.Duration
+
+	// Write into a directory that is not empty
+	Force bool
+}
+
+func writeJSON(path string, value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// Write the plan under dir
+func (p *Plan) Write(dir string, opts WriteOptions) error {
+	if (opts.CA != nil) != (p.TLSDir != "") {
+		return errors.New("a CA is needed exactly when the plan has a TLS directory")
+	}
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 && !opts.Force {
+		return fmt.Errorf("%s is not empty", dir)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	if err := writeJSON(filepath.Join(dir, "cluster.json"), p); err != nil {
+		return err
+	}
+	if opts.CA != nil && opts.SaveCA {
+		caDir := filepath.Join(dir, "ca")
+		if err := os.MkdirAll(caDir, 0700); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(caDir, "ca.crt"), opts.CA.CertPEM, 0644); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(caDir, "ca.key"), opts.CA.KeyPEM, 0600); err != nil {
+			return err
+		}
+	}
+
+	for _, m := range p.Members {
+		nodeDir := filepath.Join(dir, "nodes", m.Name)
+		if err := os.MkdirAll(nodeDir, 0755); err != nil {
+			return err
+		}
+		if err := writeJSON(filepath.Join(nodeDir, "pgraft.json"), p.NodeConfig(m)); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(nodeDir, "pgraft.conf"), []byte(p.GUCs(m)), 0644); err != nil {
+			return err
+		}
+		if opts.CA == nil {
+			continue
+		}
+
+		certPEM, keyPEM, err := opts.CA.Issue(m, opts.Validity)
+		if err != nil {
+			return fmt.Errorf("certificate of %s: %w", m.Name, err)
+		}
+		tlsDir := filepath.Join(nodeDir, "tls")
+		if err := os.MkdirAll(tlsDir, 0700); err != nil {
+			return err
+		}
+		files := []struct {
+			name string
+			data []byte
+			mode os.FileMode
+		}{
+			{CAFileName, opts.CA.CertPEM, 0644},
+			{CertFileName, certPEM, 0644},
+			{KeyFileName, keyPEM, 0600},
+		}
+		for _, f := range files {
+			if err := os.WriteFile(filepath.Join(tlsDir, f.name), f.data, f.mode); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+/*
+ * decode.go
+ * Decoding peer traffic into raft messages
+ *
+ * A peer connection carries frames (pgraft/wire) both ways; the side
+ * that dialed first sends a 4-byte big-endian node ID.  A stream whose
+ * start was captured is decoded from its first byte.  Otherwise, and
+ * after a gap, frame boundaries are unknown: the decoder looks for the
+ * first offset from which frames decode one after another into valid
+ * messages, with or without the node ID in front.  Streams in which no
+ * such offset exists are not pgraft traffic, or are encrypted.
+ */
+
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pgelephant/pgraft/pgraft/wire"
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+// How far into a stream or past a gap the decoder looks for a frame
+// boundary
+const resyncWindow = 256 * 1024
+
+// Frames that must decode in a row for an offset to count as a frame
+// boundary, unless the stream ends sooner
+const resyncFrames = 3
+
+type Message struct {
+	Time   time.Time
+	Offset int
+	raftpb.Message
+}
+
+// Trouble found in a stream, at an offset of its data
+type Problem struct {
+	Offset  int
+	Message string
+}
+
+type Decoded struct {
+	Stream *Stream
+
+	// Node ID sent at the start of the connection, when seen
+	NodeID    uint32
+	HasNodeID bool
+
+	Messages []Message
+	Problems []Problem
+
+	// No frames found anywhere in the stream
+	NotPgraft bool
+
+	// The stream starts with a TLS record
+	Encrypted bool
+}
+
+// Valid frames in a row at the start of data, and the bytes they span
+func countFrames(data []byte, limit int) (int, int) {
+	r := bytes.NewReader(data)
+	count, used := 0, 0
+	for count < limit {
+		// Lengths past the end of data are not worth allocating for
+		rest := data[used:]
+		if len(rest) < 4 || int(binary.BigEndian.Uint32(rest)) > len(rest)-4 {
+			break
+		}
+		frame, err := wire.ReadFrame(r)
+		if err != nil {
+			break
+		}
+		if _, err := wire.DecodeMessage(frame); err != nil {
+			break
+		}
+		count++
+		used = len(data) - r.Len()
+	}
+	return count, used
+}
+
+// Whether data starts at a frame boundary: enough frames decode in a
+// row, or all of data is frames
+func frameBoundary(data []byte) bool {
+	count, used := countFrames(data, resyncFrames)
+	return count == resyncFrames || (count > 0 && used == len(data))
+}
+
+// Offset of the first frame boundary in data, or -1
+func resync(data []byte) int {
+	window := len(data)
+	if window > resyncWindow {
+		window = resyncWindow
+	}
+	for offset := 0; offset < window; offset++ {
+		if frameBoundary(data[offset:]) {
+			return offset
+		}
+	}
+	return -1
+}
+
+func isTLS(data []byte) bool {
+	// Handshake record of TLS 1.0 or later
+	return len(data) >= 3 && data[0] == 0x16 && data[1] == 0x03 && data[2] <= 0x04
+}
+
+// Decode the messages of a stream
+func Decode(s *Stream) *Decoded {
+	d := &Decoded{Stream: s}
+	if isTLS(s.Data) {
+		d.Encrypted = true
+		return d
+	}
+
+	// The stream is decoded in runs separated by gaps
+	bounds := append(append([]int{0}, s.Gaps...), len(s.Data))
+	for i := 0; i+1 < len(bounds); i++ {
+		start, end := bounds[i], bounds[i+1]
+		if i > 0 {
+			d.problem(start, "bytes missing from the capture")
+		}
+		d.decodeRun(start, end, i == 0)
+	}
+	d.NotPgraft = len(d.Messages) == 0 && len(s.Data) > 0
+	return d
+}
+
+func (d *Decoded) problem(offset int, format string, args ...interface{}) {
+	d.Problems = append(d.Problems, Problem{offset, fmt.Sprintf(format, args...)})
+}
+
+func (d *Decoded) decodeRun(start, end int, first bool) {
+	s := d.Stream
+	data := s.Data[start:end]
+	pos := 0
+
+	switch {
+	case first && s.Start && s.Client:
+		if len(data) < 4 {
+			return
+		}
+		d.NodeID, d.HasNodeID = binary.BigEndian.Uint32(data), true
+		pos = 4
+	case first && s.Start:
+	case first && frameBoundary(data):
+	case first && len(data) > 4 && frameBoundary(data[4:]):
+		// A raw dump of the dialing side, from its first byte
+		d.NodeID, d.HasNodeID = binary.BigEndian.Uint32(data), true
+		pos = 4
+	default:
+		offset := resync(data)
+		if offset < 0 {
+			if len(d.Messages) > 0 {
+				d.problem(start, "no frame boundary found; %d bytes skipped", len(data))
+			}
+			return
+		}
+		if offset > 0 && (len(d.Messages) > 0 || !first) {
+			d.problem(start, "%d bytes skipped to the next frame boundary", offset)
+		}
+		pos = offset
+	}
+
+	for pos < len(data) {
+		r := bytes.NewReader(data[pos:])
+		frame, err := wire.ReadFrame(r)
+		if err == io.ErrUnexpectedEOF || err == io.EOF {
+			d.problem(start+pos, "capture ends inside a frame")
+			return
+		}
+		if err != nil {
+			// The length is corrupt: continue from the next boundary
+			d.problem(start+pos, "%v", err)
+			if pos+1 < len(data) {
+				d.decodeRun(start+pos+1, end, false)
+			}
+			return
+		}
+		msg, err := wire.DecodeMessage(frame)
+		if err != nil {
+			d.problem(start+pos, "%v", err)
+		} else {
+			d.Messages = append(d.Messages, Message{Time: s.TimeAt(start + pos), Offset: start + pos, Message: msg})
+		}
+		pos = len(data) - r.Len()
+	}
+}
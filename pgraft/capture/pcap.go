@@ -0,0 +1,238 @@
+/*
+ * pcap.go
+ * Reading TCP segments from pcap files
+ *
+ * Only what decoding peer traffic needs: the classic pcap format (as
+ * written by tcpdump -w; not pcapng) in either byte order and with micro-
+ * or nanosecond timestamps, the link types tcpdump produces on Linux and
+ * BSD, IPv4 and IPv6, and TCP.  IP fragments are skipped; peers send
+ * frames over TCP, whose segments fit the path MTU.
+ */
+
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Link types, from the tcpdump list of LINKTYPE_ values
+const (
+	linkNull     = 0
+	linkEthernet = 1
+	linkRaw      = 101
+	linkLoop     = 108
+	linkSLL      = 113
+	linkSLL2     = 276
+)
+
+// Largest packet record accepted, against reading garbage
+const maxSnapLen = 256 * 1024
+
+// One TCP segment of a capture
+type Segment struct {
+	Time    time.Time
+	Src     net.TCPAddr
+	Dst     net.TCPAddr
+	Seq     uint32
+	SYN     bool
+	ACK     bool
+	FIN     bool
+	RST     bool
+	Payload []byte
+}
+
+type PcapReader struct {
+	r        *bufio.Reader
+	order    binary.ByteOrder
+	nanos    bool
+	linkType uint32
+}
+
+// Whether data starts like a pcap file
+func IsPcap(data []byte) bool {
+	if len(data) < 4 {
+		return false
+	}
+	switch binary.LittleEndian.Uint32(data) {
+	case 0xa1b2c3d4, 0xd4c3b2a1, 0xa1b23c4d, 0x4d3cb2a1:
+		return true
+	}
+	return false
+}
+
+func NewPcapReader(r io.Reader) (*PcapReader, error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	var header [24]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		return nil, fmt.Errorf("reading pcap header: %w", err)
+	}
+	pr := &PcapReader{r: br}
+	switch binary.LittleEndian.Uint32(header[:]) {
+	case 0xa1b2c3d4:
+		pr.order = binary.LittleEndian
+	case 0xd4c3b2a1:
+		pr.order = binary.BigEndian
+	case 0xa1b23c4d:
+		pr.order, pr.nanos = binary.LittleEndian, true
+	case 0x4d3cb2a1:
+		pr.order, pr.nanos = binary.BigEndian, true
+	default:
+		return nil, errors.New("not a pcap file (pcapng is not supported; convert with editcap -F pcap)")
+	}
+	pr.linkType = pr.order.Uint32(header[20:]) & 0x0fffffff
+	switch pr.linkType {
+	case linkNull, linkEthernet, linkRaw, linkLoop, linkSLL, linkSLL2:
+	default:
+		return nil, fmt.Errorf("unsupported link type %d", pr.linkType)
+	}
+	return pr, nil
+}
+
+// Next TCP segment; io.EOF at the end of the capture.  Packets that are
+// not TCP over IP are skipped
+func (pr *PcapReader) Next() (Segment, error) {
+	for {
+		var header [16]byte
+		if _, err := io.ReadFull(pr.r, header[:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+			return Segment{}, err
+		}
+		seconds := pr.order.Uint32(header[0:])
+		fraction := pr.order.Uint32(header[4:])
+		captured := pr.order.Uint32(header[8:])
+		if captured > maxSnapLen {
+			return Segment{}, fmt.Errorf("packet record of %d bytes exceeds limit", captured)
+		}
+		packet := make([]byte, captured)
+		if _, err := io.ReadFull(pr.r, packet); err != nil {
+			// A capture cut off in the middle of a packet
+			return Segment{}, io.EOF
+		}
+
+		nanos := int64(fraction)
+		if !pr.nanos {
+			nanos *= 1000
+		}
+		seg, ok := pr.parse(packet)
+		if !ok {
+			continue
+		}
+		seg.Time = time.Unix(int64(seconds), nanos)
+		return seg, nil
+	}
+}
+
+// Strip the link layer; returns the IP packet
+func (pr *PcapReader) network(packet []byte) ([]byte, bool) {
+	switch pr.linkType {
+	case linkRaw:
+		return packet, true
+	case linkNull, linkLoop:
+		if len(packet) < 4 {
+			return nil, false
+		}
+		return packet[4:], true
+	case linkEthernet:
+		if len(packet) < 14 {
+			return nil, false
+		}
+		etherType := binary.BigEndian.Uint16(packet[12:])
+		offset := 14
+		// 802.1Q VLAN tags
+		for (etherType == 0x8100 || etherType == 0x88a8) && len(packet) >= offset+4 {
+			etherType = binary.BigEndian.Uint16(packet[offset+2:])
+			offset += 4
+		}
+		if etherType != 0x0800 && etherType != 0x86dd {
+			return nil, false
+		}
+		return packet[offset:], true
+	case linkSLL:
+		if len(packet) < 16 {
+			return nil, false
+		}
+		return packet[16:], true
+	case linkSLL2:
+		if len(packet) < 20 {
+			return nil, false
+		}
+		return packet[20:], true
+	}
+	return nil, false
+}
+
+func (pr *PcapReader) parse(packet []byte) (Segment, bool) {
+	ip, ok := pr.network(packet)
+	if !ok || len(ip) < 1 {
+		return Segment{}, false
+	}
+
+	var seg Segment
+	var tcp []byte
+	switch ip[0] >> 4 {
+	case 4:
+		if len(ip) < 20 {
+			return Segment{}, false
+		}
+		headerLen := int(ip[0]&0x0f) * 4
+		total := int(binary.BigEndian.Uint16(ip[2:]))
+		flagsFragment := binary.BigEndian.Uint16(ip[6:])
+		if ip[9] != 6 || headerLen < 20 || total < headerLen || len(ip) < headerLen {
+			return Segment{}, false
+		}
+		// Fragments: more-fragments set or a non-zero offset
+		if flagsFragment&0x2000 != 0 || flagsFragment&0x1fff != 0 {
+			return Segment{}, false
+		}
+		if total > len(ip) {
+			total = len(ip)
+		}
+		seg.Src.IP = net.IP(append([]byte(nil), ip[12:16]...))
+		seg.Dst.IP = net.IP(append([]byte(nil), ip[16:20]...))
+		tcp = ip[headerLen:total]
+	case 6:
+		if len(ip) < 40 {
+			return Segment{}, false
+		}
+		payloadLen := int(binary.BigEndian.Uint16(ip[4:]))
+		if ip[6] != 6 {
+			// Extension headers are rare between peers and not followed
+			return Segment{}, false
+		}
+		end := 40 + payloadLen
+		if end > len(ip) {
+			end = len(ip)
+		}
+		seg.Src.IP = net.IP(append([]byte(nil), ip[8:24]...))
+		seg.Dst.IP = net.IP(append([]byte(nil), ip[24:40]...))
+		tcp = ip[40:end]
+	default:
+		return Segment{}, false
+	}
+
+	if len(tcp) < 20 {
+		return Segment{}, false
+	}
+	dataOffset := int(tcp[12]>>4) * 4
+	if dataOffset < 20 || dataOffset > len(tcp) {
+		return Segment{}, false
+	}
+	flags := tcp[13]
+	seg.Src.Port = int(binary.BigEndian.Uint16(tcp[0:]))
+	seg.Dst.Port = int(binary.BigEndian.Uint16(tcp[2:]))
+	seg.Seq = binary.BigEndian.Uint32(tcp[4:])
+	seg.FIN = flags&0x01 != 0
+	seg.SYN = flags&0x02 != 0
+	seg.ACK = flags&0x10 != 0
+	seg.RST = flags&0x04 != 0
+	seg.Payload = append([]byte(nil), tcp[dataOffset:]...)
+	return seg, true
+}
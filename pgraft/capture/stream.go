@@ -0,0 +1,163 @@
+/*
+ * stream.go
+ * Reassembly of captured TCP segments into byte streams
+ *
+ * Each direction of a connection is a stream.  Segments are put in
+ * sequence order; retransmitted bytes are dropped and bytes the capture
+ * missed are recorded as gaps, after which frame boundaries are unknown
+ * and the decoder has to find them again.  A stream whose SYN was
+ * captured is known to be complete from its first byte.
+ */
+
+package capture
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"time"
+)
+
+type Stream struct {
+	Src, Dst net.TCPAddr
+
+	// The SYN was captured, so Data starts at the first byte sent
+	Start bool
+
+	// The stream of the side that opened the connection
+	Client bool
+
+	Data []byte
+
+	// Offsets in Data at which bytes are missing
+	Gaps []int
+
+	// Time each run of Data arrived, by starting offset
+	marks []mark
+
+	next    uint32
+	started bool
+	pending map[uint32]Segment
+}
+
+type mark struct {
+	offset int
+	time   time.Time
+}
+
+func (s *Stream) String() string {
+	return fmt.Sprintf("%s -> %s", s.Src.String(), s.Dst.String())
+}
+
+// Time the byte at offset was captured
+func (s *Stream) TimeAt(offset int) time.Time {
+	i := sort.Search(len(s.marks), func(i int) bool { return s.marks[i].offset > offset })
+	if i == 0 {
+		return time.Time{}
+	}
+	return s.marks[i-1].time
+}
+
+func (s *Stream) appendPayload(seg Segment, payload []byte) {
+	s.marks = append(s.marks, mark{len(s.Data), seg.Time})
+	s.Data = append(s.Data, payload...)
+	s.next += uint32(len(payload))
+}
+
+func (s *Stream) add(seg Segment) {
+	if seg.SYN {
+		if !s.started {
+			s.Start = true
+			s.Client = !seg.ACK
+		}
+		if len(s.Data) == 0 {
+			s.next = seg.Seq + 1
+		}
+		s.started = true
+		return
+	}
+	if len(seg.Payload) == 0 {
+		return
+	}
+	if !s.started {
+		s.next = seg.Seq
+		s.started = true
+	}
+	s.pending[seg.Seq] = seg
+	s.drain()
+}
+
+// Append pending segments that continue the stream
+func (s *Stream) drain() {
+	for progress := true; progress; {
+		progress = false
+		for seq, seg := range s.pending {
+			ahead := int32(seq - s.next)
+			if ahead > 0 {
+				continue
+			}
+			delete(s.pending, seq)
+			progress = true
+			// Retransmitted bytes already in the stream are dropped
+			if skip := int(-ahead); skip < len(seg.Payload) {
+				s.appendPayload(seg, seg.Payload[skip:])
+			}
+		}
+	}
+}
+
+// Append what is left after the missing bytes, recording gaps
+func (s *Stream) flush() {
+	for len(s.pending) > 0 {
+		var nearest uint32
+		first := true
+		for seq := range s.pending {
+			if first || int32(seq-s.next) < int32(nearest-s.next) {
+				nearest, first = seq, false
+			}
+		}
+		s.Gaps = append(s.Gaps, len(s.Data))
+		s.next = nearest
+		s.drain()
+	}
+}
+
+// Groups segments into streams
+type Assembler struct {
+	streams map[string]*Stream
+	order   []*Stream
+}
+
+func NewAssembler() *Assembler {
+	return &Assembler{streams: make(map[string]*Stream)}
+}
+
+func (a *Assembler) Add(seg Segment) {
+	key := seg.Src.String() + ">" + seg.Dst.String()
+	s, exists := a.streams[key]
+	// A new SYN on a finished stream is a new connection on the same ports
+	if exists && seg.SYN && !seg.ACK && len(s.Data) > 0 {
+		s.flush()
+		exists = false
+	}
+	if !exists {
+		s = &Stream{Src: seg.Src, Dst: seg.Dst, pending: make(map[uint32]Segment)}
+		a.streams[key] = s
+		a.order = append(a.order, s)
+	}
+	s.add(seg)
+}
+
+// Every stream, in the order its first segment was captured
+func (a *Assembler) Streams() []*Stream {
+	for _, s := range a.order {
+		s.flush()
+	}
+	return a.order
+}
+
+// Stream of a raw dump of one direction of a connection, which may or
+// may not start with the connection's first byte
+func RawStream(data []byte) *Stream {
+	return &Stream{Data: data, marks: []mark{{0, time.Time{}}}}
+}
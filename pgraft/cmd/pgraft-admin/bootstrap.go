@@ -0,0 +1,91 @@
+/*
+ * bootstrap.go
+ * pgraft-admin bootstrap: configuration for every member of a new cluster
+ *
+ * Takes the members as host:port arguments, each optionally followed by
+ * ,id=N and ,name=NAME, and writes the layout described in the bootstrap
+ * package to -o.  With -tls, a CA is created (or loaded with -ca-cert and
+ * -ca-key, to add members to a cluster that already trusts it) and every
+ * member gets a certificate for its host.  The assigned IDs and names are
+ * printed as a table.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pgelephant/pgraft/pgraft/bootstrap"
+)
+
+func runBootstrap(args []string) {
+	flags := flag.NewFlagSet("bootstrap", flag.ExitOnError)
+	clusterName := flags.String("cluster", "pgraft_cluster", "cluster name")
+	output := flags.String("o", "pgraft-bootstrap", "output directory")
+	force := flags.Bool("force", false, "write into a non-empty output directory")
+	useTLS := flags.Bool("tls", false, "generate TLS material for peer connections")
+	tlsDir := flags.String("tls-dir", "/etc/pgraft/tls", "directory the nodes read their TLS files from")
+	caCert := flags.String("ca-cert", "", "issue certificates with this CA certificate instead of a new CA")
+	caKey := flags.String("ca-key", "", "key of the -ca-cert CA")
+	validity := flags.Duration("validity", 365*24*time.Hour, "validity of the node certificates")
+	caValidity := flags.Duration("ca-validity", 10*365*24*time.Hour, "validity of a new CA")
+	dataDir := flags.String("data-dir", "", "raft data directory of the nodes; empty leaves it unset")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s bootstrap [flags] host:port[,id=N][,name=NAME] ...\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if (*caCert == "") != (*caKey == "") {
+		fail("-ca-cert and -ca-key go together")
+	}
+	if *caCert != "" && !*useTLS {
+		fail("-ca-cert needs -tls")
+	}
+
+	var members []bootstrap.Member
+	for _, spec := range flags.Args() {
+		m, err := bootstrap.ParseMember(spec)
+		if err != nil {
+			fail("%v", err)
+		}
+		members = append(members, m)
+	}
+	plan, err := bootstrap.NewPlan(*clusterName, members)
+	if err != nil {
+		fail("%v", err)
+	}
+	plan.DataDir = *dataDir
+
+	opts := bootstrap.WriteOptions{Validity: *validity, Force: *force}
+	if *useTLS {
+		plan.TLSDir = *tlsDir
+		if *caCert != "" {
+			opts.CA, err = bootstrap.LoadAuthority(*caCert, *caKey)
+		} else {
+			opts.CA, err = bootstrap.NewAuthority(*clusterName, *caValidity)
+			opts.SaveCA = true
+		}
+		if err != nil {
+			fail("%v", err)
+		}
+	}
+	if err := plan.Write(*output, opts); err != nil {
+		fail("%v", err)
+	}
+
+	fmt.Printf("cluster %s: %d members written to %s\n", plan.ClusterName, len(plan.Members), *output)
+	fmt.Printf("%-6s %-20s %s\n", "ID", "NAME", "ENDPOINT")
+	for _, m := range plan.Members {
+		fmt.Printf("%-6d %-20s %s\n", m.ID, m.Name, m.Endpoint())
+	}
+	if opts.SaveCA {
+		fmt.Printf("keep %s/ca/ca.key off the nodes; it is only needed to add members\n", *output)
+	}
+}
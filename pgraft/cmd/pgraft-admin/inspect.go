@@ -0,0 +1,148 @@
+/*
+ * inspect.go
+ * pgraft-admin inspect: offline examination of a node's raft storage
+ *
+ * Reads a trace file, or archived log segments and snapshots, without
+ * modifying them, and prints the snapshot's ConfState, the HardState, a
+ * summary of the log (every entry with -entries) and the findings of the
+ * integrity checks.  Exits 1 if a check found an error.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/pgelephant/pgraft/pgraft/inspect"
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+type inspectReport struct {
+	Sources      []string                `json:"sources"`
+	Snapshot     raftpb.SnapshotMetadata `json:"snapshot"`
+	SnapshotSize int                     `json:"snapshot_size"`
+	HardState    *raftpb.HardState       `json:"hard_state,omitempty"`
+	FirstIndex   uint64                  `json:"first_index"`
+	LastIndex    uint64                  `json:"last_index"`
+	Entries      []inspectEntry          `json:"entries,omitempty"`
+	Findings     []inspect.Finding       `json:"findings"`
+}
+
+type inspectEntry struct {
+	Index uint64 `json:"index"`
+	Term  uint64 `json:"term"`
+	Type  string `json:"type"`
+	Size  int    `json:"size"`
+	Data  string `json:"data,omitempty"`
+}
+
+func runInspect(args []string) {
+	flags := flag.NewFlagSet("inspect", flag.ExitOnError)
+	kind := flags.String("kind", "", "kind of every file: trace, segment or snapshot; detected per file if empty")
+	entries := flags.Bool("entries", false, "list the entries of the log")
+	from := flags.Uint64("from", 0, "first index listed with -entries")
+	to := flags.Uint64("to", 0, "last index listed with -entries; 0 for the end of the log")
+	limit := flags.Int("limit", 80, "bytes of each entry's contents shown; 0 for no limit")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s inspect [flags] file ...\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	state, err := inspect.Load(flags.Args(), *kind)
+	if err != nil {
+		fail("%v", err)
+	}
+	findings := inspect.Check(state)
+
+	report := inspectReport{
+		Sources:      state.Sources,
+		Snapshot:     state.Snapshot,
+		SnapshotSize: state.SnapshotSize,
+		LastIndex:    state.LastIndex(),
+		Findings:     findings,
+	}
+	if state.HasHardState {
+		report.HardState = &state.HardState
+	}
+	if len(state.Entries) > 0 {
+		report.FirstIndex = state.Entries[0].Index
+	}
+	if *entries {
+		for _, entry := range state.Entries {
+			if entry.Index < *from || (*to != 0 && entry.Index > *to) {
+				continue
+			}
+			report.Entries = append(report.Entries, inspectEntry{
+				Index: entry.Index,
+				Term:  entry.Term,
+				Type:  inspect.EntryType(entry),
+				Size:  len(entry.Data),
+				Data:  inspect.DescribeEntry(entry, *limit),
+			})
+		}
+	}
+
+	if *asJSON {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			fail("%v", err)
+		}
+		fmt.Println(string(data))
+	} else {
+		printInspectReport(&report, state)
+	}
+	if inspect.Errors(findings) > 0 {
+		os.Exit(1)
+	}
+}
+
+func printInspectReport(report *inspectReport, state *inspect.State) {
+	meta := report.Snapshot
+	if meta.Index > 0 {
+		fmt.Printf("snapshot:   index %d, term %d, %d bytes\n", meta.Index, meta.Term, report.SnapshotSize)
+		fmt.Printf("conf state: voters %v, learners %v", meta.ConfState.Voters, meta.ConfState.Learners)
+		if len(meta.ConfState.VotersOutgoing) > 0 {
+			fmt.Printf(", outgoing voters %v, learners next %v", meta.ConfState.VotersOutgoing, meta.ConfState.LearnersNext)
+		}
+		fmt.Println()
+	} else {
+		fmt.Println("snapshot:   none")
+	}
+	if hs := report.HardState; hs != nil {
+		fmt.Printf("hard state: term %d, vote %d, commit %d\n", hs.Term, hs.Vote, hs.Commit)
+	} else {
+		fmt.Println("hard state: not recorded in these files")
+	}
+	if n := len(state.Entries); n > 0 {
+		fmt.Printf("log:        %d entries, indexes %d-%d, terms %d-%d\n",
+			n, report.FirstIndex, report.LastIndex, state.Entries[0].Term, state.Entries[n-1].Term)
+	} else {
+		fmt.Println("log:        empty")
+	}
+
+	if len(report.Entries) > 0 {
+		fmt.Printf("\n%-10s %-6s %-14s %8s  %s\n", "INDEX", "TERM", "TYPE", "BYTES", "DATA")
+		for _, e := range report.Entries {
+			fmt.Printf("%-10d %-6d %-14s %8d  %s\n", e.Index, e.Term, e.Type, e.Size, e.Data)
+		}
+	}
+
+	fmt.Println()
+	if len(report.Findings) == 0 {
+		fmt.Println("integrity: ok")
+		return
+	}
+	fmt.Printf("integrity: %d errors, %d warnings\n", inspect.Errors(report.Findings), len(report.Findings)-inspect.Errors(report.Findings))
+	for _, finding := range report.Findings {
+		fmt.Printf("  %-7s %s\n", finding.Severity, finding.Message)
+	}
+}
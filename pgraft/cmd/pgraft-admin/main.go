@@ -0,0 +1,59 @@
+/*
+ * main.go
+ * pgraft-admin: administrative commands for pgraft clusters
+ *
+ * Each command takes its own flags after the command name, as in
+ * "pgraft-admin bootstrap -h".  Commands exit 0 on success and 2 on
+ * errors, including usage errors.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+type command struct {
+	summary string
+	run     func(args []string)
+}
+
+var commands = map[string]command{
+	"bootstrap": {"generate node IDs, configuration and TLS material for a new cluster", runBootstrap},
+	"inspect":   {"examine a node's raft log, snapshot and HardState from its files", runInspect},
+	"migrate":   {"convert the configuration of a Patroni or repmgr cluster", runMigrate},
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "pgraft-admin: "+format+"\n", args...)
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [flags] [arguments]\n\ncommands:\n", os.Args[0])
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", name, commands[name].summary)
+	}
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	cmd, found := commands[os.Args[1]]
+	if !found {
+		if os.Args[1] != "help" && os.Args[1] != "-h" && os.Args[1] != "-help" {
+			fmt.Fprintf(os.Stderr, "pgraft-admin: unknown command %q\n", os.Args[1])
+		}
+		usage()
+	}
+	cmd.run(os.Args[2:])
+}
@@ -0,0 +1,93 @@
+/*
+ * migrate.go
+ * pgraft-admin migrate: configuration for a cluster moving off Patroni or repmgr
+ *
+ * Takes the Patroni YAML or repmgr.conf of every member and writes the
+ * bootstrap layout to -o, with a ramd.conf per member and NOTES.txt
+ * listing what could not be converted.  The format is detected unless
+ * given with -from.  Settings changed in Patroni's DCS after bootstrap
+ * are not in the files; pass the current failover timeout (the ttl) with
+ * -failover-timeout.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pgelephant/pgraft/pgraft/migrate"
+)
+
+func runMigrate(args []string) {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	from := flags.String("from", "", "format of the files, patroni or repmgr; detected if empty")
+	clusterName := flags.String("cluster", "", "cluster name; defaults to the Patroni scope")
+	output := flags.String("o", "pgraft-migrate", "output directory")
+	force := flags.Bool("force", false, "write into a non-empty output directory")
+	raftPort := flags.Int("raft-port", 7400, "pgraft port of the members; members sharing a host get the ports after it")
+	failoverTimeout := flags.Duration("failover-timeout", 0, "time after which the primary is given up on, overriding the files")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s migrate [flags] config-file ...\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if *raftPort <= 0 || *raftPort > 65535 {
+		fail("-raft-port %d is out of range 1-65535", *raftPort)
+	}
+
+	var parts []*migrate.Cluster
+	for _, path := range flags.Args() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fail("%v", err)
+		}
+		part, err := migrate.Parse(data, *from)
+		if err != nil {
+			fail("%s: %v", path, err)
+		}
+		parts = append(parts, part)
+	}
+	cluster, err := migrate.Merge(parts)
+	if err != nil {
+		fail("%v", err)
+	}
+	if *failoverTimeout > 0 {
+		cluster.FailoverTimeout = *failoverTimeout
+	}
+	if *clusterName == "" && cluster.Name == "" {
+		fail("the files name no cluster; give its name with -cluster")
+	}
+	plan, err := cluster.Plan(*clusterName, *raftPort)
+	if err != nil {
+		fail("%v", err)
+	}
+	if err := cluster.Write(*output, plan, *force); err != nil {
+		fail("%v", err)
+	}
+
+	tunables := plan.Tunables
+	election := time.Duration(tunables.ElectionTick*tunables.TickIntervalMs) * time.Millisecond
+	fmt.Printf("%s cluster %s: %d members written to %s\n", cluster.Format, plan.ClusterName, len(plan.Members), *output)
+	fmt.Printf("failover after %v, raft election timeout %v\n", cluster.FailoverTimeout, election)
+	fmt.Printf("%-6s %-20s %-24s %s\n", "ID", "NAME", "RAFT", "POSTGRESQL")
+	for _, m := range plan.Members {
+		for _, node := range cluster.Nodes {
+			if node.Name == m.Name {
+				fmt.Printf("%-6d %-20s %-24s %s:%d\n", m.ID, m.Name, m.Endpoint(), node.Host, node.PGPort)
+			}
+		}
+	}
+	if len(cluster.Notes) > 0 {
+		fmt.Printf("\n%d notes, also in %s/NOTES.txt:\n", len(cluster.Notes), *output)
+		for _, text := range cluster.Notes {
+			fmt.Printf("- %s\n", text)
+		}
+	}
+}
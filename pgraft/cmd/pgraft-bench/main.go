@@ -0,0 +1,148 @@
+/*
+ * main.go
+ * pgraft-bench: consensus benchmarks with JSON results
+ *
+ * Runs the benchmarks of the bench package and writes the report as JSON
+ * to stdout or -o.  With -baseline, the results are compared with an
+ * earlier report and the command exits 1 if any metric regressed by more
+ * than -threshold, so a release can be checked against the previous one
+ * on the same machine.  -count runs the suite several times and keeps the
+ * best result of each benchmark, which makes such checks far less
+ * sensitive to other load on the machine.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pgelephant/pgraft/pgraft/bench"
+)
+
+func parseSizes(value string) ([]int, error) {
+	var sizes []int
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		multiplier := 1
+		switch {
+		case strings.HasSuffix(field, "k"):
+			multiplier, field = 1<<10, strings.TrimSuffix(field, "k")
+		case strings.HasSuffix(field, "m"):
+			multiplier, field = 1<<20, strings.TrimSuffix(field, "m")
+		}
+		size, err := strconv.Atoi(field)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("invalid size %q", field)
+		}
+		sizes = append(sizes, size*multiplier)
+	}
+	return sizes, nil
+}
+
+func readReport(path string) (*bench.Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var report bench.Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if report.Version != bench.ReportVersion {
+		return nil, fmt.Errorf("%s: report version %d, expected %d", path, report.Version, bench.ReportVersion)
+	}
+	return &report, nil
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "pgraft-bench: "+format+"\n", args...)
+	os.Exit(2)
+}
+
+func main() {
+	defaults := bench.DefaultConfig()
+	nodes := flag.String("nodes", "3,5", "cluster sizes")
+	payloads := flag.String("payloads", "128,4k", "proposal payload sizes in bytes (k and m suffixes allowed)")
+	proposals := flag.Int("proposals", defaults.Proposals, "proposals measured per cluster and payload size")
+	snapshots := flag.String("snapshots", "1m,16m", "snapshot sizes in bytes; empty skips the snapshot benchmark")
+	rounds := flag.Int("snapshot-rounds", defaults.SnapshotRounds, "snapshots installed per cluster and snapshot size")
+	seed := flag.Int64("seed", defaults.Seed, "random seed of the simulated clusters")
+	output := flag.String("o", "", "write the JSON report to this file instead of stdout")
+	baseline := flag.String("baseline", "", "compare with this earlier report and fail on regressions")
+	threshold := flag.Float64("threshold", 0.25, "fraction by which a metric may get worse than the baseline")
+	count := flag.Int("count", 1, "runs of the suite, keeping the best result of each benchmark")
+	quiet := flag.Bool("q", false, "do not report progress on stderr")
+	flag.Parse()
+
+	cfg := bench.Config{
+		Proposals:      *proposals,
+		SnapshotRounds: *rounds,
+		Seed:           *seed,
+	}
+	var err error
+	if cfg.Nodes, err = parseSizes(*nodes); err != nil {
+		fail("-nodes: %v", err)
+	}
+	if cfg.Payloads, err = parseSizes(*payloads); err != nil {
+		fail("-payloads: %v", err)
+	}
+	if cfg.SnapshotSizes, err = parseSizes(*snapshots); err != nil {
+		fail("-snapshots: %v", err)
+	}
+
+	var previous *bench.Report
+	if *baseline != "" {
+		if previous, err = readReport(*baseline); err != nil {
+			fail("%v", err)
+		}
+	}
+
+	var progress func(string, int, int)
+	if !*quiet {
+		progress = func(benchmark string, nodes, bytes int) {
+			fmt.Fprintf(os.Stderr, "running %s with %d nodes, %d bytes\n", benchmark, nodes, bytes)
+		}
+	}
+	var reports []*bench.Report
+	for run := 0; run < *count || run == 0; run++ {
+		report, err := bench.Run(cfg, progress)
+		if err != nil {
+			fail("%v", err)
+		}
+		reports = append(reports, report)
+	}
+	report := bench.Best(reports...)
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		fail("%v", err)
+	}
+	data = append(data, '\n')
+	if *output != "" {
+		err = os.WriteFile(*output, data, 0644)
+	} else {
+		_, err = os.Stdout.Write(data)
+	}
+	if err != nil {
+		fail("%v", err)
+	}
+
+	if previous != nil {
+		regressions := bench.Compare(previous, report, *threshold)
+		for _, regression := range regressions {
+			fmt.Fprintf(os.Stderr, "REGRESSION %s\n", regression)
+		}
+		if len(regressions) > 0 {
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "no regressions against %s\n", *baseline)
+	}
+}
@@ -0,0 +1,184 @@
+/*
+ * main.go
+ * pgraft-decode: raft messages from captured peer traffic
+ *
+ * Decodes pcap files (tcpdump -w) or raw dumps of one direction of a peer
+ * connection into raft messages, printed in capture order with the
+ * connection they were seen on.  Connections that carry no pgraft frames
+ * are skipped, and TLS-encrypted ones reported; capture the traffic of
+ * the pgraft port, e.g. tcpdump -i any -w peers.pcap tcp port 7400.
+ * Exits 2 on errors reading the input.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pgelephant/pgraft/pgraft/capture"
+	"go.etcd.io/raft/v3"
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "pgraft-decode: "+format+"\n", args...)
+	os.Exit(2)
+}
+
+func readStreams(path string, raw bool) []*capture.Stream {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fail("%v", err)
+	}
+	if raw || !capture.IsPcap(data) {
+		return []*capture.Stream{capture.RawStream(data)}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		fail("%v", err)
+	}
+	defer file.Close()
+	reader, err := capture.NewPcapReader(file)
+	if err != nil {
+		fail("%s: %v", path, err)
+	}
+	assembler := capture.NewAssembler()
+	for {
+		segment, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fail("%s: %v", path, err)
+		}
+		assembler.Add(segment)
+	}
+	return assembler.Streams()
+}
+
+type jsonMessage struct {
+	Time       string         `json:"time,omitempty"`
+	Connection string         `json:"connection,omitempty"`
+	Offset     int            `json:"offset"`
+	Type       string         `json:"type"`
+	Message    raftpb.Message `json:"message"`
+}
+
+type item struct {
+	decoded *capture.Decoded
+	msg     capture.Message
+}
+
+func main() {
+	raw := flag.Bool("raw", false, "treat every file as a raw dump of one direction of a connection")
+	port := flag.Int("port", 0, "only decode connections to or from this port")
+	types := flag.String("type", "", "only print these message types, e.g. MsgApp,MsgVote")
+	verbose := flag.Bool("v", false, "print entry data instead of its size")
+	asJSON := flag.Bool("json", false, "print one JSON object per message")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] capture ...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	wanted := make(map[string]bool)
+	for _, name := range strings.Split(*types, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if _, known := raftpb.MessageType_value[name]; !known {
+			fail("unknown message type %q", name)
+		}
+		wanted[name] = true
+	}
+
+	var decoded []*capture.Decoded
+	var items []item
+	for _, path := range flag.Args() {
+		for _, stream := range readStreams(path, *raw) {
+			if *port != 0 && stream.Src.Port != *port && stream.Dst.Port != *port {
+				continue
+			}
+			d := capture.Decode(stream)
+			decoded = append(decoded, d)
+			for _, msg := range d.Messages {
+				if len(wanted) == 0 || wanted[msg.Type.String()] {
+					items = append(items, item{d, msg})
+				}
+			}
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].msg.Time.Before(items[j].msg.Time) })
+
+	formatter := func(data []byte) string { return fmt.Sprintf("<%d bytes>", len(data)) }
+	if *verbose {
+		formatter = nil
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetEscapeHTML(false)
+	for _, it := range items {
+		connection := ""
+		if it.decoded.Stream.Src.IP != nil {
+			connection = it.decoded.Stream.String()
+		}
+		if *asJSON {
+			out := jsonMessage{Connection: connection, Offset: it.msg.Offset, Type: it.msg.Type.String(), Message: it.msg.Message}
+			if !it.msg.Time.IsZero() {
+				out.Time = it.msg.Time.UTC().Format("2006-01-02T15:04:05.000000Z")
+			}
+			if err := encoder.Encode(out); err != nil {
+				fail("%v", err)
+			}
+			continue
+		}
+		where := fmt.Sprintf("@%d", it.msg.Offset)
+		if !it.msg.Time.IsZero() {
+			where = it.msg.Time.Format("15:04:05.000000")
+		}
+		if connection != "" {
+			where += " " + connection
+		}
+		fmt.Printf("%s  %s\n", where, raft.DescribeMessage(it.msg.Message, formatter))
+	}
+
+	// Summary of every connection, on stderr so it does not mix with the
+	// messages
+	for _, d := range decoded {
+		name := d.Stream.String()
+		if d.Stream.Src.IP == nil {
+			name = "raw stream"
+		}
+		switch {
+		case d.Encrypted:
+			fmt.Fprintf(os.Stderr, "%s: TLS-encrypted, not decoded\n", name)
+			continue
+		case d.NotPgraft:
+			fmt.Fprintf(os.Stderr, "%s: %d bytes without pgraft frames, skipped\n", name, len(d.Stream.Data))
+			continue
+		case len(d.Stream.Data) == 0:
+			continue
+		}
+		summary := fmt.Sprintf("%s: %d messages in %d bytes", name, len(d.Messages), len(d.Stream.Data))
+		if d.HasNodeID {
+			summary += fmt.Sprintf(", node ID %d sent at connection start", d.NodeID)
+		}
+		if d.Stream.Src.IP != nil && !d.Stream.Start {
+			summary += ", start not captured"
+		}
+		fmt.Fprintln(os.Stderr, summary)
+		for _, problem := range d.Problems {
+			fmt.Fprintf(os.Stderr, "  offset %d: %s\n", problem.Offset, problem.Message)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+/*
+ * main.go
+ * pgraft-lincheck: record and check linearizability of key-value histories
+ *
+ * Runs the key-value workload of the history package against simulated
+ * clusters and checks each history, stopping at the first one that is
+ * not linearizable.  The history of the last run can be written as JSON
+ * events or as a Jepsen EDN history for other checkers.  With -check, a
+ * JSON history recorded elsewhere is checked instead.  Exits 0 if every
+ * history is linearizable, 1 if one is not and 2 on errors, including a
+ * check that timed out.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pgelephant/pgraft/pgraft/history"
+	"github.com/pgelephant/pgraft/pgraft/sim"
+)
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "pgraft-lincheck: "+format+"\n", args...)
+	os.Exit(2)
+}
+
+func writeFile(path string, write func(*os.File) error) {
+	file, err := os.Create(path)
+	if err != nil {
+		fail("%v", err)
+	}
+	if err := write(file); err != nil {
+		fail("%s: %v", path, err)
+	}
+	if err := file.Close(); err != nil {
+		fail("%s: %v", path, err)
+	}
+}
+
+// Check h and print the outcome; false if it is not linearizable
+func check(h *history.History, label string, timeout time.Duration) bool {
+	start := time.Now()
+	result, err := history.Check(h.Operations(), history.CheckOptions{Timeout: timeout})
+	if err != nil {
+		fail("%s: %v", label, err)
+	}
+	elapsed := time.Since(start).Round(time.Millisecond)
+	if result.Linearizable {
+		fmt.Printf("ok   %s: %d operations on %d keys linearizable (%v)\n", label, result.Operations, result.Keys, elapsed)
+		return true
+	}
+	fmt.Printf("FAIL %s: key %q is not linearizable: %s\n", label, result.Key, result.Explanation)
+	for _, op := range result.KeyOps {
+		returned := "never"
+		if op.Return != 0 {
+			returned = fmt.Sprint(op.Return)
+		}
+		fmt.Printf("       process %-4d %-5s %-8q %-4s call %-6d return %s\n",
+			op.Process, op.Kind, op.Value, op.Status, op.Call, returned)
+	}
+	return false
+}
+
+func main() {
+	clients := flag.Int("clients", 5, "concurrent clients")
+	ops := flag.Int("ops", 1000, "operations per run")
+	keys := flag.Int("keys", 3, "keys the clients read and write")
+	reads := flag.Float64("reads", 0.5, "fraction of operations that are reads")
+	nodes := flag.Int("nodes", 3, "voters in each simulated cluster")
+	seed := flag.Int64("seed", 0, "random seed; 0 picks one from the clock")
+	runs := flag.Int("runs", 1, "runs with consecutive seeds")
+	dropRate := flag.Float64("drop-rate", 0, "fraction of messages the network drops")
+	nemesis := flag.Bool("nemesis", false, "crash and restart nodes and partition the network during the run")
+	staleReads := flag.Bool("stale-reads", false, "serve reads from local state, which should fail the check")
+	jsonPath := flag.String("json", "", "write the history of the last run as JSON events to this file")
+	ednPath := flag.String("edn", "", "write the history of the last run as a Jepsen EDN history to this file")
+	checkPath := flag.String("check", "", "check this JSON history instead of running the workload")
+	timeout := flag.Duration("timeout", time.Minute, "time allowed for checking one history")
+	flag.Parse()
+
+	if *checkPath != "" {
+		file, err := os.Open(*checkPath)
+		if err != nil {
+			fail("%v", err)
+		}
+		h, err := history.ReadJSON(file)
+		file.Close()
+		if err != nil {
+			fail("%s: %v", *checkPath, err)
+		}
+		if !check(h, *checkPath, *timeout) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	var last *history.History
+	linearizable := true
+	for run := 0; run < *runs && linearizable; run++ {
+		cfg := history.WorkloadConfig{
+			Cluster: sim.Config{
+				Nodes:       *nodes,
+				PreVote:     true,
+				CheckQuorum: true,
+				DropRate:    *dropRate,
+				Seed:        *seed + int64(run),
+			},
+			Clients:      *clients,
+			Operations:   *ops,
+			Keys:         *keys,
+			ReadFraction: *reads,
+			Nemesis:      *nemesis,
+			StaleReads:   *staleReads,
+		}
+		h, err := history.RunWorkload(cfg)
+		if err != nil {
+			fail("seed=%d: %v", cfg.Cluster.Seed, err)
+		}
+		last = h
+		linearizable = check(h, fmt.Sprintf("seed=%d", cfg.Cluster.Seed), *timeout)
+	}
+
+	if *jsonPath != "" {
+		writeFile(*jsonPath, func(f *os.File) error { return last.WriteJSON(f) })
+	}
+	if *ednPath != "" {
+		writeFile(*ednPath, func(f *os.File) error { return last.WriteEDN(f) })
+	}
+	if !linearizable {
+		os.Exit(1)
+	}
+}
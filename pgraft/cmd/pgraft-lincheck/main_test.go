@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pgelephant/pgraft/pgraft/history"
+	"github.com/pgelephant/pgraft/pgraft/sim"
+)
+
+func TestCheckWorkload(t *testing.T) {
+	h, err := history.RunWorkload(history.WorkloadConfig{
+		Cluster:      sim.Config{Seed: 1, PreVote: true, CheckQuorum: true},
+		Operations:   200,
+		ReadFraction: 0.5,
+		Nemesis:      true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !check(h, "seed=1", time.Minute) {
+		t.Error("history of the simulated cluster reported not linearizable")
+	}
+}
+
+func TestCheckRecordedHistory(t *testing.T) {
+	// A read that missed a write which had already returned
+	recorded := `{"client_id":0,"kind":"call","id":0,"time":1,"value":{"op":"write","key":"k","value":"a"}}
+{"client_id":0,"kind":"return","id":0,"time":2,"value":{"status":"ok"}}
+{"client_id":1,"kind":"call","id":1,"time":3,"value":{"op":"read","key":"k"}}
+{"client_id":1,"kind":"return","id":1,"time":4,"value":{"status":"ok","value":""}}
+`
+	h, err := history.ReadJSON(strings.NewReader(recorded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if check(h, "recorded", time.Minute) {
+		t.Error("stale read reported linearizable")
+	}
+}
@@ -0,0 +1,144 @@
+/*
+ * main.go
+ * pgraft-replay: replay a raft trace and report where it diverges
+ *
+ * Reads a trace written after pgraft_go_trace_start(), re-drives a fresh
+ * raft node with the recorded inputs and compares its output with the
+ * traced node's.  Exits 0 if the replay matches, 1 at the first
+ * divergence and 2 if the trace cannot be read.  -dump prints the records
+ * instead, for reading a trace by eye.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/pgelephant/pgraft/pgraft/trace"
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+func main() {
+	dump := flag.Bool("dump", false, "print the records of the trace and exit")
+	verbose := flag.Bool("v", false, "print each record as it is replayed")
+	attempts := flag.Int("attempts", 5, "replays allowed when the node times out early")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] trace-file\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	records, err := trace.ReadFile(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pgraft-replay: %v\n", err)
+		os.Exit(2)
+	}
+	if *dump {
+		for i, record := range records {
+			printRecord(i, record)
+		}
+		return
+	}
+
+	opts := trace.Options{Attempts: *attempts}
+	if *verbose {
+		opts.OnRecord = printRecord
+	}
+	report, err := trace.Replay(records, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pgraft-replay: %v\n", err)
+		os.Exit(2)
+	}
+	fmt.Printf("node %d: %d records, %d inputs, %d ready batches, %d attempt(s)\n",
+		report.NodeID, report.Records, report.Inputs, report.Readies, report.Attempts)
+	fmt.Printf("compared %d appended, %d committed and %d sent\n",
+		report.Appended, report.Committed, report.Sent)
+	if d := report.Divergence; d != nil {
+		fmt.Printf("DIVERGED at record %d (%s)\n", d.Record, d.Time.Format("2006-01-02 15:04:05.000000"))
+		fmt.Printf("  %s output %d\n", d.Stream, d.Position)
+		fmt.Printf("  traced:   %s\n", d.Traced)
+		if d.Replayed == "" {
+			fmt.Printf("  replayed: nothing\n")
+		} else {
+			fmt.Printf("  replayed: %s\n", d.Replayed)
+		}
+		os.Exit(1)
+	}
+	fmt.Println("replay matches the trace")
+}
+
+func printRecord(i int, record trace.Record) {
+	fmt.Printf("%6d %s %-19s %s\n", i, record.Time.Format("15:04:05.000000"), record.Kind, describe(record))
+}
+
+func describe(record trace.Record) string {
+	switch record.Kind {
+	case trace.KindStart:
+		start, err := record.Start()
+		if err != nil {
+			return err.Error()
+		}
+		return fmt.Sprintf("node=%d election=%d heartbeat=%d pre-vote=%t check-quorum=%t applied=%d peers=%d",
+			start.NodeID, start.ElectionTick, start.HeartbeatTick, start.PreVote, start.CheckQuorum,
+			start.Applied, len(start.Peers))
+	case trace.KindStorage:
+		storage, err := record.Storage()
+		if err != nil {
+			return err.Error()
+		}
+		return fmt.Sprintf("snapshot=%d term=%d vote=%d commit=%d entries=%d",
+			storage.Snapshot.Metadata.Index, storage.HardState.Term, storage.HardState.Vote,
+			storage.HardState.Commit, len(storage.Entries))
+	case trace.KindStep:
+		msg, err := record.Message()
+		if err != nil {
+			return err.Error()
+		}
+		return describeMessage(msg)
+	case trace.KindPropose, trace.KindReadIndex:
+		return fmt.Sprintf("%d bytes", len(record.Payload))
+	case trace.KindProposeConfChange, trace.KindApplyConfChange:
+		cc, err := record.ConfChange()
+		if err != nil {
+			return err.Error()
+		}
+		return raftpb.ConfChangesToString(cc.AsV2().Changes)
+	case trace.KindTransfer:
+		lead, transferee, err := record.Transfer()
+		if err != nil {
+			return err.Error()
+		}
+		return fmt.Sprintf("from=%d to=%d", lead, transferee)
+	case trace.KindUnreachable, trace.KindSnapshotStatus:
+		peer, err := record.Peer()
+		if err != nil {
+			return err.Error()
+		}
+		return fmt.Sprintf("peer=%d", peer)
+	case trace.KindReady:
+		ready, err := record.Ready()
+		if err != nil {
+			return err.Error()
+		}
+		line := fmt.Sprintf("append=%d commit=%d send=%d", len(ready.Entries), len(ready.CommittedEntries), len(ready.Messages))
+		if ready.State != "" {
+			line += fmt.Sprintf(" state=%s lead=%d", ready.State, ready.Lead)
+		}
+		if ready.HardState.Term != 0 {
+			line += fmt.Sprintf(" term=%d vote=%d", ready.HardState.Term, ready.HardState.Vote)
+		}
+		return line
+	}
+	return ""
+}
+
+func describeMessage(msg raftpb.Message) string {
+	return fmt.Sprintf("%s from=%d term=%d index=%d logterm=%d commit=%d entries=%d",
+		msg.Type, msg.From, msg.Term, msg.Index, msg.LogTerm, msg.Commit, len(msg.Entries))
+}
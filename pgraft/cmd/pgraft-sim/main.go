@@ -0,0 +1,94 @@
+/*
+ * main.go
+ * pgraft-sim: run consensus scenarios against the in-process simulator
+ *
+ * Runs every scenario of the sim package, or those named on the command
+ * line, and exits non-zero if any fails.  The seed of each run is
+ * printed, so a failure found in CI is rerun with -seed; raft randomizes
+ * election timeouts itself, so one that depends on election timing may
+ * take a few -runs to show again.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pgelephant/pgraft/pgraft/sim"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// Run the scenarios as the command line args asks; returns the exit status
+func run(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("pgraft-sim", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	nodes := flags.Int("nodes", 3, "number of voters in each simulated cluster")
+	seed := flags.Int64("seed", 0, "random seed; 0 picks one from the clock")
+	runs := flags.Int("runs", 1, "number of runs of each scenario, with consecutive seeds")
+	dropRate := flags.Float64("drop-rate", 0, "fraction of messages the network drops")
+	preVote := flags.Bool("pre-vote", true, "enable pre-vote")
+	checkQuorum := flags.Bool("check-quorum", true, "enable check-quorum")
+	list := flags.Bool("list", false, "list the scenarios and exit")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "usage: pgraft-sim [flags] [scenario ...]\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if *list {
+		for _, scenario := range sim.Scenarios {
+			fmt.Fprintln(stdout, scenario.Name)
+		}
+		return 0
+	}
+
+	scenarios := sim.Scenarios
+	if flags.NArg() > 0 {
+		scenarios = nil
+		for _, name := range flags.Args() {
+			scenario, found := sim.FindScenario(name)
+			if !found {
+				fmt.Fprintf(stderr, "pgraft-sim: unknown scenario %q\n", name)
+				return 2
+			}
+			scenarios = append(scenarios, scenario)
+		}
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+
+	failed := 0
+	for _, scenario := range scenarios {
+		for run := 0; run < *runs; run++ {
+			cfg := sim.Config{
+				Nodes:       *nodes,
+				PreVote:     *preVote,
+				CheckQuorum: *checkQuorum,
+				DropRate:    *dropRate,
+				Seed:        *seed + int64(run),
+			}
+			start := time.Now()
+			if err := scenario.Run(cfg); err != nil {
+				failed++
+				fmt.Fprintf(stdout, "FAIL %-14s seed=%d: %v\n", scenario.Name, cfg.Seed, err)
+				continue
+			}
+			fmt.Fprintf(stdout, "ok   %-14s seed=%d (%v)\n", scenario.Name, cfg.Seed, time.Since(start).Round(time.Millisecond))
+		}
+	}
+	if failed > 0 {
+		fmt.Fprintf(stdout, "%d run(s) failed\n", failed)
+		return 1
+	}
+	return 0
+}
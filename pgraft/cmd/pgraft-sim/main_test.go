@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/pgelephant/pgraft/pgraft/sim"
+)
+
+func TestRunAllScenarios(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if status := run([]string{"-seed", "1", "-runs", "2"}, &stdout, &stderr); status != 0 {
+		t.Fatalf("exit status %d\n%s%s", status, stdout.String(), stderr.String())
+	}
+	if ok := strings.Count(stdout.String(), "ok   "); ok != 2*len(sim.Scenarios) {
+		t.Errorf("%d runs passed, expected %d:\n%s", ok, 2*len(sim.Scenarios), stdout.String())
+	}
+}
+
+func TestRunNamedScenario(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if status := run([]string{"-seed", "3", "-nodes", "5", "election"}, &stdout, &stderr); status != 0 {
+		t.Fatalf("exit status %d\n%s%s", status, stdout.String(), stderr.String())
+	}
+	if !strings.HasPrefix(stdout.String(), "ok   election") || strings.Count(stdout.String(), "\n") != 1 {
+		t.Errorf("unexpected output:\n%s", stdout.String())
+	}
+}
+
+func TestRunList(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if status := run([]string{"-list"}, &stdout, &stderr); status != 0 {
+		t.Fatalf("exit status %d", status)
+	}
+	names := strings.Fields(stdout.String())
+	if len(names) != len(sim.Scenarios) {
+		t.Errorf("listed %v", names)
+	}
+}
+
+func TestRunUnknownScenario(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if status := run([]string{"no-such-scenario"}, &stdout, &stderr); status != 2 {
+		t.Errorf("exit status %d, expected 2", status)
+	}
+	if !strings.Contains(stderr.String(), "unknown scenario") {
+		t.Errorf("stderr: %s", stderr.String())
+	}
+}
@@ -0,0 +1,123 @@
+/*
+ * main.go
+ * pgraft-soak: long-running soak test over the simulator
+ *
+ * Runs the soak test of the soak package for -duration of wall-clock
+ * time, printing progress after every periodic check, and ends with a
+ * pass/fail report on stdout, or as JSON with -json.  Meant for
+ * qualifying a build before rolling it out: an hour of wall-clock time
+ * covers days of simulated traffic and restarts.  The seed is printed so
+ * a failing run can be repeated with -seed and -ticks.  Exits 0 if the
+ * run passed, 1 if it failed and 2 on errors.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pgelephant/pgraft/pgraft/sim"
+	"github.com/pgelephant/pgraft/pgraft/soak"
+)
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "pgraft-soak: "+format+"\n", args...)
+	os.Exit(2)
+}
+
+func megabytes(bytes uint64) float64 {
+	return float64(bytes) / (1 << 20)
+}
+
+func printReport(r *soak.Report) {
+	outcome := "PASS"
+	if !r.Passed {
+		outcome = "FAIL"
+	}
+	simulated := time.Duration(r.SimulatedSeconds * float64(time.Second))
+	fmt.Printf("%s seed=%d nodes=%d\n", outcome, r.Seed, r.Nodes)
+	fmt.Printf("  ran %v, %v simulated (%d ticks)\n", time.Duration(r.Seconds*float64(time.Second)).Round(time.Second), simulated, r.Ticks)
+	fmt.Printf("  proposals: %d made, %d acknowledged, %d abandoned, %d without a leader\n",
+		r.Proposed, r.Acknowledged, r.Abandoned, r.Unavailable)
+	fmt.Printf("  restarts: %d, compactions: %d, snapshots installed: %d\n", r.Restarts, r.Compactions, r.Snapshots)
+	if r.AppliedIndex > 0 {
+		fmt.Printf("  converged on index %d\n", r.AppliedIndex)
+	}
+	fmt.Printf("  longest stall without an acknowledgement: %.1fs\n", r.LongestStallSeconds)
+	fmt.Printf("  heap: %.1f MiB at start, %.1f MiB at end, %.1f MiB at most\n",
+		megabytes(r.HeapStartBytes), megabytes(r.HeapEndBytes), megabytes(r.HeapMaxBytes))
+	for _, failure := range r.Failures {
+		fmt.Printf("  failure: %s\n", failure)
+	}
+	if more := r.FailuresTotal - len(r.Failures); more > 0 {
+		fmt.Printf("  and %d more failures\n", more)
+	}
+}
+
+func main() {
+	nodes := flag.Int("nodes", 3, "voters in the simulated cluster")
+	seed := flag.Int64("seed", 0, "random seed; 0 picks one from the clock")
+	duration := flag.Duration("duration", 10*time.Minute, "wall-clock time to run for; 0 runs for -ticks")
+	maxTicks := flag.Uint64("ticks", 0, "stop after this many simulated ticks of 100ms")
+	rate := flag.Float64("rate", 50, "proposals per simulated second")
+	payload := flag.Int("payload", 256, "bytes per proposal")
+	restartInterval := flag.Duration("restart-interval", 30*time.Second, "simulated time between member restarts, on average; 0 disables them")
+	downtime := flag.Duration("downtime", 10*time.Second, "simulated time a restarted member stays down")
+	compactInterval := flag.Duration("compact-interval", time.Minute, "simulated time between log compactions; 0 disables them")
+	checkInterval := flag.Duration("check-interval", 10*time.Second, "simulated time between periodic checks")
+	stallTimeout := flag.Duration("stall-timeout", time.Minute, "simulated time the cluster may go without acknowledging a proposal")
+	dropRate := flag.Float64("drop-rate", 0, "fraction of messages the network drops")
+	asJSON := flag.Bool("json", false, "print the report as JSON")
+	quiet := flag.Bool("q", false, "do not print progress")
+	flag.Parse()
+
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	cfg := soak.Config{
+		Cluster: sim.Config{
+			Nodes:       *nodes,
+			PreVote:     true,
+			CheckQuorum: true,
+			DropRate:    *dropRate,
+			Seed:        *seed,
+		},
+		Duration:        *duration,
+		Ticks:           *maxTicks,
+		Rate:            *rate,
+		Payload:         *payload,
+		RestartInterval: *restartInterval,
+		Downtime:        *downtime,
+		CompactInterval: *compactInterval,
+		CheckInterval:   *checkInterval,
+		StallTimeout:    *stallTimeout,
+	}
+	if !*quiet {
+		fmt.Fprintf(os.Stderr, "pgraft-soak: seed=%d\n", *seed)
+		cfg.Progress = func(r *soak.Report) {
+			fmt.Fprintf(os.Stderr, "%v simulated: %d acknowledged, %d restarts, %d snapshots installed, heap %.1f MiB\n",
+				time.Duration(r.SimulatedSeconds*float64(time.Second)), r.Acknowledged, r.Restarts, r.Snapshots, megabytes(r.HeapEndBytes))
+		}
+	}
+
+	report, err := soak.Run(cfg)
+	if err != nil {
+		fail("seed=%d: %v", *seed, err)
+	}
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			fail("%v", err)
+		}
+	} else {
+		printReport(report)
+	}
+	if !report.Passed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,320 @@
+/*
+ * check.go
+ * Linearizability checker for key-value register histories
+ *
+ * Keys are independent registers, so each key's operations are checked
+ * on their own.  For each key the checker searches for an order of the
+ * operations that respects real time (an operation that returned before
+ * another was invoked comes first) and in which every read returns the
+ * latest write, or "" before any write: the algorithm of Wing and Gong
+ * with Lowe's memoization of visited states, as Porcupine uses.  Failed
+ * operations are left out; indeterminate ones may be placed anywhere
+ * after their invocation, or left out, and reads among them constrain
+ * nothing.
+ */
+
+package history
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Returned when a key could not be decided within CheckOptions.Timeout
+var ErrCheckTimeout = errors.New("linearizability check timed out")
+
+type CheckOptions struct {
+	// Time allowed for the whole check; 0 means no limit
+	Timeout time.Duration
+}
+
+type CheckResult struct {
+	Linearizable bool
+	Keys         int
+	Operations   int
+
+	// Key that is not linearizable, its operations, and the longest
+	// prefix of them the search managed to order
+	Key         string
+	KeyOps      []Operation
+	Linearized  []Operation
+	Explanation string
+}
+
+// Check whether ops are linearizable as a set of registers
+func Check(ops []Operation, opts CheckOptions) (CheckResult, error) {
+	var deadline time.Time
+	if opts.Timeout > 0 {
+		deadline = time.Now().Add(opts.Timeout)
+	}
+
+	byKey := make(map[string][]Operation)
+	for _, op := range ops {
+		if op.Status == Failed || op.Status == Pending {
+			continue
+		}
+		// A read with unknown outcome tells nothing about the register
+		if op.Kind == Read && op.Status == Indeterminate {
+			continue
+		}
+		byKey[op.Key] = append(byKey[op.Key], op)
+	}
+	keys := make([]string, 0, len(byKey))
+	for key := range byKey {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := CheckResult{Linearizable: true, Keys: len(keys)}
+	for _, key := range keys {
+		result.Operations += len(byKey[key])
+	}
+	for _, key := range keys {
+		ok, longest, err := checkKey(byKey[key], deadline)
+		if err != nil {
+			return result, fmt.Errorf("key %q: %w", key, err)
+		}
+		if !ok {
+			result.Linearizable = false
+			result.Key = key
+			result.KeyOps = byKey[key]
+			result.Linearized = longest
+			result.Explanation = explain(byKey[key], longest)
+			return result, nil
+		}
+	}
+	return result, nil
+}
+
+// Entry of the doubly linked list of call and return events
+type entry struct {
+	op     int
+	isCall bool
+	match  *entry
+	prev   *entry
+	next   *entry
+}
+
+// Unlink a call and its return
+func lift(e *entry) {
+	e.prev.next = e.next
+	if e.next != nil {
+		e.next.prev = e.prev
+	}
+	if m := e.match; m != nil {
+		m.prev.next = m.next
+		if m.next != nil {
+			m.next.prev = m.prev
+		}
+	}
+}
+
+// Relink a call and its return, in the reverse order of lift
+func unlift(e *entry) {
+	if m := e.match; m != nil {
+		m.prev.next = m
+		if m.next != nil {
+			m.next.prev = m
+		}
+	}
+	e.prev.next = e
+	if e.next != nil {
+		e.next.prev = e
+	}
+}
+
+type bitset []uint64
+
+func newBitset(n int) bitset {
+	return make(bitset, (n+63)/64)
+}
+
+func (b bitset) set(i int)   { b[i/64] |= 1 << (uint(i) % 64) }
+func (b bitset) clear(i int) { b[i/64] &^= 1 << (uint(i) % 64) }
+
+func (b bitset) hash() uint64 {
+	var h uint64 = 14695981039346656037
+	for _, word := range b {
+		h ^= word
+		h *= 1099511628211
+	}
+	return h
+}
+
+func (b bitset) equal(other bitset) bool {
+	for i := range b {
+		if b[i] != other[i] {
+			return false
+		}
+	}
+	return true
+}
+
+type cacheEntry struct {
+	linearized bitset
+	state      string
+}
+
+type frame struct {
+	e     *entry
+	state string
+}
+
+// Search for a linearization of one key's operations; returns the
+// longest linearized prefix found when there is none
+func checkKey(ops []Operation, deadline time.Time) (bool, []Operation, error) {
+	// Build the event list in time order; indeterminate operations have
+	// no return event and so never force the search to backtrack
+	type timed struct {
+		time int64
+		e    *entry
+	}
+	events := make([]timed, 0, 2*len(ops))
+	returns := 0
+	for i, op := range ops {
+		call := &entry{op: i, isCall: true}
+		events = append(events, timed{op.Call, call})
+		if op.Status == OK {
+			ret := &entry{op: i}
+			call.match = ret
+			events = append(events, timed{op.Return, ret})
+			returns++
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].time < events[j].time })
+	head := &entry{}
+	prev := head
+	for _, t := range events {
+		t.e.prev = prev
+		prev.next = t.e
+		prev = t.e
+	}
+
+	linearized := newBitset(len(ops))
+	cache := make(map[uint64][]cacheEntry)
+	var stack []frame
+	var longest []int
+	state := ""
+	e := head.next
+	steps := 0
+
+	for returns > 0 {
+		steps++
+		if steps%10000 == 0 && !deadline.IsZero() && time.Now().After(deadline) {
+			return false, nil, ErrCheckTimeout
+		}
+
+		if e == nil {
+			// Only indeterminate calls left before the end, yet returns
+			// remain: impossible, since every return follows its call
+			return false, nil, errors.New("inconsistent event list")
+		}
+		if e.isCall {
+			op := ops[e.op]
+			next, ok := step(state, op)
+			if ok {
+				linearized.set(e.op)
+				if !cached(cache, linearized, next) {
+					stack = append(stack, frame{e: e, state: state})
+					state = next
+					lift(e)
+					if e.match != nil {
+						returns--
+					}
+					if len(stack) > len(longest) {
+						longest = longest[:0]
+						for _, f := range stack {
+							longest = append(longest, f.e.op)
+						}
+					}
+					e = head.next
+					continue
+				}
+				linearized.clear(e.op)
+			}
+			e = e.next
+			continue
+		}
+
+		// A return whose call is not linearized yet: undo the last choice
+		if len(stack) == 0 {
+			ordered := make([]Operation, len(longest))
+			for i, index := range longest {
+				ordered[i] = ops[index]
+			}
+			return false, ordered, nil
+		}
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		state = top.state
+		linearized.clear(top.e.op)
+		unlift(top.e)
+		if top.e.match != nil {
+			returns++
+		}
+		e = top.e.next
+	}
+	return true, nil, nil
+}
+
+// Apply op to a register holding state
+func step(state string, op Operation) (string, bool) {
+	if op.Kind == Write {
+		return op.Value, true
+	}
+	return state, op.Value == state
+}
+
+// Record the pair in the cache, reporting whether it was already there
+func cached(cache map[uint64][]cacheEntry, linearized bitset, state string) bool {
+	h := linearized.hash() ^ stringHash(state)
+	for _, c := range cache[h] {
+		if c.state == state && c.linearized.equal(linearized) {
+			return true
+		}
+	}
+	copied := append(bitset(nil), linearized...)
+	cache[h] = append(cache[h], cacheEntry{linearized: copied, state: state})
+	return false
+}
+
+func stringHash(s string) uint64 {
+	var h uint64 = 14695981039346656037
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+// Describe why the search got stuck after the longest linearized prefix
+func explain(ops, linearized []Operation) string {
+	state := ""
+	done := make(map[int]bool, len(linearized))
+	for _, op := range linearized {
+		state, _ = step(state, op)
+		done[op.ID] = true
+	}
+	// The first operation that returned and could not be placed next
+	var stuck *Operation
+	for i := range ops {
+		op := &ops[i]
+		if done[op.ID] || op.Status != OK {
+			continue
+		}
+		if stuck == nil || op.Return < stuck.Return {
+			stuck = op
+		}
+	}
+	if stuck == nil {
+		return fmt.Sprintf("no order of %d operations fits", len(ops))
+	}
+	if stuck.Kind == Read {
+		return fmt.Sprintf("after %d operations the register holds %q, but %s by process %d (call %d, return %d) read %q",
+			len(linearized), state, stuck.Kind, stuck.Process, stuck.Call, stuck.Return, stuck.Value)
+	}
+	return fmt.Sprintf("after %d operations no order places %s of %q by process %d (call %d, return %d)",
+		len(linearized), stuck.Kind, stuck.Value, stuck.Process, stuck.Call, stuck.Return)
+}
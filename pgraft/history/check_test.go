@@ -0,0 +1,113 @@
+package history
+
+import (
+	"testing"
+)
+
+// Ops of a history built by record, which is given the history to fill
+func build(record func(h *History)) []Operation {
+	h := New()
+	record(h)
+	h.Close()
+	return h.Operations()
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name         string
+		record       func(h *History)
+		linearizable bool
+	}{
+		{"sequential", func(h *History) {
+			w := h.Invoke(0, Write, "k", "a")
+			h.Complete(w, "")
+			r := h.Invoke(1, Read, "k", "")
+			h.Complete(r, "a")
+		}, true},
+		{"initial value", func(h *History) {
+			r := h.Invoke(0, Read, "k", "")
+			h.Complete(r, "")
+		}, true},
+		{"concurrent writes in either order", func(h *History) {
+			w1 := h.Invoke(0, Write, "k", "a")
+			w2 := h.Invoke(1, Write, "k", "b")
+			h.Complete(w2, "")
+			h.Complete(w1, "")
+			r := h.Invoke(2, Read, "k", "")
+			h.Complete(r, "b")
+		}, true},
+		{"stale read", func(h *History) {
+			w1 := h.Invoke(0, Write, "k", "a")
+			h.Complete(w1, "")
+			w2 := h.Invoke(0, Write, "k", "b")
+			h.Complete(w2, "")
+			r := h.Invoke(1, Read, "k", "")
+			h.Complete(r, "a")
+		}, false},
+		{"value never written", func(h *History) {
+			r := h.Invoke(0, Read, "k", "")
+			h.Complete(r, "z")
+		}, false},
+		{"indeterminate write seen later", func(h *History) {
+			w := h.Invoke(0, Write, "k", "a")
+			h.Abandon(w)
+			r := h.Invoke(1, Read, "k", "")
+			h.Complete(r, "a")
+		}, true},
+		{"failed write seen", func(h *History) {
+			w := h.Invoke(0, Write, "k", "a")
+			h.Fail(w)
+			r := h.Invoke(1, Read, "k", "")
+			h.Complete(r, "a")
+		}, false},
+		{"read before the write it saw was invoked", func(h *History) {
+			r := h.Invoke(1, Read, "k", "")
+			h.Complete(r, "a")
+			w := h.Invoke(0, Write, "k", "a")
+			h.Complete(w, "")
+		}, false},
+		{"keys are independent registers", func(h *History) {
+			w1 := h.Invoke(0, Write, "x", "a")
+			h.Complete(w1, "")
+			w2 := h.Invoke(0, Write, "y", "b")
+			h.Complete(w2, "")
+			r1 := h.Invoke(1, Read, "x", "")
+			h.Complete(r1, "a")
+			r2 := h.Invoke(1, Read, "y", "")
+			h.Complete(r2, "b")
+		}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := Check(build(test.record), CheckOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Linearizable != test.linearizable {
+				t.Fatalf("linearizable = %v, expected %v: %s", result.Linearizable, test.linearizable, result.Explanation)
+			}
+			if !result.Linearizable && (result.Key == "" || len(result.KeyOps) == 0 || result.Explanation == "") {
+				t.Errorf("failure not explained: %+v", result)
+			}
+		})
+	}
+}
+
+func TestCheckCounts(t *testing.T) {
+	ops := build(func(h *History) {
+		for _, key := range []string{"x", "y", "z"} {
+			w := h.Invoke(0, Write, key, key)
+			h.Complete(w, "")
+		}
+		// Failed operations and reads of unknown outcome are not checked
+		h.Fail(h.Invoke(1, Write, "x", "never"))
+		h.Invoke(2, Read, "y", "")
+	})
+	result, err := Check(ops, CheckOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Linearizable || result.Keys != 3 || result.Operations != 3 {
+		t.Errorf("got %+v, expected 3 linearizable operations on 3 keys", result)
+	}
+}
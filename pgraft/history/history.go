@@ -0,0 +1,318 @@
+/*
+ * history.go
+ * Operation histories for linearizability checking
+ *
+ * A History records operations on a key-value register as clients see
+ * them: when each was invoked and when, and with what result, it
+ * returned.  An operation whose outcome the client never learned (its
+ * node crashed, it timed out) is indeterminate: it may or may not have
+ * taken effect, and the checker allows both.  Times come from a logical
+ * clock that ticks at every event, so no two events are simultaneous.
+ *
+ * Histories are written as Porcupine-style JSON events (call and return
+ * events carrying the client ID and operation ID), which ReadJSON reads
+ * back, or as a Jepsen EDN history for Knossos or Elle.
+ */
+
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+type Kind string
+
+const (
+	Read  Kind = "read"
+	Write Kind = "write"
+)
+
+type Status string
+
+const (
+	// Invoked and not yet returned
+	Pending Status = "pending"
+	OK      Status = "ok"
+
+	// Definitely did not take effect
+	Failed Status = "fail"
+
+	// Outcome unknown
+	Indeterminate Status = "info"
+)
+
+type Operation struct {
+	ID      int    `json:"id"`
+	Process int    `json:"process"`
+	Kind    Kind   `json:"kind"`
+	Key     string `json:"key"`
+
+	// Value written, or value read once the read returned
+	Value string `json:"value"`
+
+	Status Status `json:"status"`
+	Call   int64  `json:"call"`
+
+	// Zero unless Status is OK or Failed
+	Return int64 `json:"return"`
+}
+
+type History struct {
+	ops   []*Operation
+	clock int64
+}
+
+func New() *History {
+	return &History{}
+}
+
+func (h *History) tick() int64 {
+	h.clock++
+	return h.clock
+}
+
+// Record the invocation of an operation; value is the value to write,
+// and ignored for reads
+func (h *History) Invoke(process int, kind Kind, key, value string) *Operation {
+	op := &Operation{
+		ID:      len(h.ops),
+		Process: process,
+		Kind:    kind,
+		Key:     key,
+		Status:  Pending,
+		Call:    h.tick(),
+	}
+	if kind == Write {
+		op.Value = value
+	}
+	h.ops = append(h.ops, op)
+	return op
+}
+
+// Record that op returned successfully; value is the value read, and
+// ignored for writes
+func (h *History) Complete(op *Operation, value string) {
+	if op.Status != Pending {
+		return
+	}
+	if op.Kind == Read {
+		op.Value = value
+	}
+	op.Status = OK
+	op.Return = h.tick()
+}
+
+// Record that op definitely did not take effect
+func (h *History) Fail(op *Operation) {
+	if op.Status != Pending {
+		return
+	}
+	op.Status = Failed
+	op.Return = h.tick()
+}
+
+// Record that the outcome of op will never be known
+func (h *History) Abandon(op *Operation) {
+	if op.Status == Pending {
+		op.Status = Indeterminate
+	}
+}
+
+// Abandon every operation still pending, ending the history
+func (h *History) Close() {
+	for _, op := range h.ops {
+		h.Abandon(op)
+	}
+}
+
+// Copy of the recorded operations, in invocation order
+func (h *History) Operations() []Operation {
+	ops := make([]Operation, len(h.ops))
+	for i, op := range h.ops {
+		ops[i] = *op
+	}
+	return ops
+}
+
+// Event of the JSON form, after Porcupine's: a call event, then for
+// operations that returned a return event with the same ID
+type Event struct {
+	ClientID int         `json:"client_id"`
+	Kind     string      `json:"kind"`
+	ID       int         `json:"id"`
+	Time     int64       `json:"time"`
+	Value    interface{} `json:"value"`
+}
+
+type callValue struct {
+	Op    Kind   `json:"op"`
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+}
+
+type returnValue struct {
+	Status Status `json:"status"`
+	Value  string `json:"value,omitempty"`
+}
+
+type event struct {
+	time  int64
+	op    *Operation
+	isRet bool
+}
+
+func (h *History) events() []event {
+	events := make([]event, 0, 2*len(h.ops))
+	for _, op := range h.ops {
+		events = append(events, event{time: op.Call, op: op})
+		if op.Return != 0 {
+			events = append(events, event{time: op.Return, op: op, isRet: true})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].time < events[j].time })
+	return events
+}
+
+// Write the history as JSON events, one per line, in time order;
+// indeterminate operations have no return event
+func (h *History) WriteJSON(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	encoder := json.NewEncoder(bw)
+	for _, e := range h.events() {
+		out := Event{ClientID: e.op.Process, ID: e.op.ID, Time: e.time}
+		if e.isRet {
+			out.Kind = "return"
+			ret := returnValue{Status: e.op.Status}
+			if e.op.Kind == Read && e.op.Status == OK {
+				ret.Value = e.op.Value
+			}
+			out.Value = ret
+		} else {
+			out.Kind = "call"
+			call := callValue{Op: e.op.Kind, Key: e.op.Key}
+			if e.op.Kind == Write {
+				call.Value = e.op.Value
+			}
+			out.Value = call
+		}
+		if err := encoder.Encode(out); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Read a history written by WriteJSON, or recorded elsewhere in the same
+// form; operations without a return event are indeterminate
+func ReadJSON(r io.Reader) (*History, error) {
+	type rawEvent struct {
+		ClientID int             `json:"client_id"`
+		Kind     string          `json:"kind"`
+		ID       int             `json:"id"`
+		Time     int64           `json:"time"`
+		Value    json.RawMessage `json:"value"`
+	}
+
+	h := New()
+	byID := make(map[int]*Operation)
+	decoder := json.NewDecoder(r)
+	for line := 1; ; line++ {
+		var e rawEvent
+		if err := decoder.Decode(&e); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("event %d: %w", line, err)
+		}
+		if e.Time <= h.clock {
+			return nil, fmt.Errorf("event %d: time %d does not increase", line, e.Time)
+		}
+		h.clock = e.Time
+
+		switch e.Kind {
+		case "call":
+			var call callValue
+			if err := json.Unmarshal(e.Value, &call); err != nil {
+				return nil, fmt.Errorf("event %d: %w", line, err)
+			}
+			if call.Op != Read && call.Op != Write {
+				return nil, fmt.Errorf("event %d: unknown operation %q", line, call.Op)
+			}
+			if _, exists := byID[e.ID]; exists {
+				return nil, fmt.Errorf("event %d: operation %d called twice", line, e.ID)
+			}
+			op := &Operation{
+				ID:      e.ID,
+				Process: e.ClientID,
+				Kind:    call.Op,
+				Key:     call.Key,
+				Value:   call.Value,
+				Status:  Pending,
+				Call:    e.Time,
+			}
+			byID[e.ID] = op
+			h.ops = append(h.ops, op)
+		case "return":
+			op, exists := byID[e.ID]
+			if !exists || op.Status != Pending {
+				return nil, fmt.Errorf("event %d: return of operation %d without a call", line, e.ID)
+			}
+			var ret returnValue
+			if err := json.Unmarshal(e.Value, &ret); err != nil {
+				return nil, fmt.Errorf("event %d: %w", line, err)
+			}
+			switch ret.Status {
+			case OK:
+				if op.Kind == Read {
+					op.Value = ret.Value
+				}
+			case Failed:
+			default:
+				return nil, fmt.Errorf("event %d: unknown status %q", line, ret.Status)
+			}
+			op.Status = ret.Status
+			op.Return = e.Time
+		default:
+			return nil, fmt.Errorf("event %d: unknown event kind %q", line, e.Kind)
+		}
+	}
+	h.Close()
+	return h, nil
+}
+
+// Write the history in Jepsen's EDN form, with keys as independent
+// registers: {:process 0, :type :invoke, :f :write, :value ["k" "v"]}
+func (h *History) WriteEDN(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, e := range h.events() {
+		op := e.op
+		eventType := ":invoke"
+		value := "nil"
+		if e.isRet {
+			eventType = ":" + string(op.Status)
+			if op.Kind == Read && op.Status == OK {
+				value = fmt.Sprintf("%q", op.Value)
+			}
+		} else if op.Kind == Write {
+			value = fmt.Sprintf("%q", op.Value)
+		}
+		fmt.Fprintf(bw, "{:process %d, :type %s, :f :%s, :value [%q %s], :time %d}\n",
+			op.Process, eventType, op.Kind, op.Key, value, e.time)
+	}
+	// Jepsen ends an indeterminate operation with an :info completion
+	for _, op := range h.ops {
+		if op.Status != Indeterminate {
+			continue
+		}
+		value := "nil"
+		if op.Kind == Write {
+			value = fmt.Sprintf("%q", op.Value)
+		}
+		fmt.Fprintf(bw, "{:process %d, :type :info, :f :%s, :value [%q %s], :time %d}\n",
+			op.Process, op.Kind, op.Key, value, h.clock+1)
+	}
+	return bw.Flush()
+}
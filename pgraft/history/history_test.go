@@ -0,0 +1,74 @@
+package history
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func sampleHistory() *History {
+	h := New()
+	w1 := h.Invoke(0, Write, "k", "a")
+	r := h.Invoke(1, Read, "k", "")
+	h.Complete(w1, "")
+	h.Complete(r, "a")
+	h.Fail(h.Invoke(2, Write, "k", "b"))
+	h.Invoke(3, Write, "j", "c")
+	h.Close()
+	return h
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	h := sampleHistory()
+	var buf bytes.Buffer
+	if err := h.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadJSON(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read.Operations(), h.Operations()) {
+		t.Errorf("read back\n%+v\nexpected\n%+v", read.Operations(), h.Operations())
+	}
+}
+
+func TestReadJSONErrors(t *testing.T) {
+	tests := map[string]string{
+		"time not increasing": `{"client_id":0,"kind":"call","id":0,"time":2,"value":{"op":"read","key":"k"}}
+{"client_id":0,"kind":"return","id":0,"time":1,"value":{"status":"ok"}}`,
+		"return without call": `{"client_id":0,"kind":"return","id":0,"time":1,"value":{"status":"ok"}}`,
+		"called twice": `{"client_id":0,"kind":"call","id":0,"time":1,"value":{"op":"read","key":"k"}}
+{"client_id":0,"kind":"call","id":0,"time":2,"value":{"op":"read","key":"k"}}`,
+		"unknown operation": `{"client_id":0,"kind":"call","id":0,"time":1,"value":{"op":"cas","key":"k"}}`,
+		"unknown status": `{"client_id":0,"kind":"call","id":0,"time":1,"value":{"op":"read","key":"k"}}
+{"client_id":0,"kind":"return","id":0,"time":2,"value":{"status":"maybe"}}`,
+	}
+	for name, input := range tests {
+		if _, err := ReadJSON(strings.NewReader(input)); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+func TestWriteEDN(t *testing.T) {
+	var buf bytes.Buffer
+	if err := sampleHistory().WriteEDN(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	expected := []string{
+		`{:process 0, :type :invoke, :f :write, :value ["k" "a"], :time 1}`,
+		`{:process 1, :type :invoke, :f :read, :value ["k" nil], :time 2}`,
+		`{:process 0, :type :ok, :f :write, :value ["k" nil], :time 3}`,
+		`{:process 1, :type :ok, :f :read, :value ["k" "a"], :time 4}`,
+		`{:process 2, :type :invoke, :f :write, :value ["k" "b"], :time 5}`,
+		`{:process 2, :type :fail, :f :write, :value ["k" nil], :time 6}`,
+		`{:process 3, :type :invoke, :f :write, :value ["j" "c"], :time 7}`,
+		`{:process 3, :type :info, :f :write, :value ["j" "c"], :time 8}`,
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("got\n%s\nexpected\n%s", strings.Join(lines, "\n"), strings.Join(expected, "\n"))
+	}
+}
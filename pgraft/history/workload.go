@@ -0,0 +1,263 @@
+/*
+ * workload.go
+ * Key-value workload over the simulator that records a history
+ *
+ * Clients issue reads and writes of a few keys through random nodes of a
+ * simulated cluster; both go through the raft log, and an operation
+ * returns when the node it was sent to applies it.  Several operations
+ * are in flight at once, so the history has real concurrency.  The
+ * optional nemesis crashes and restarts nodes and partitions the network
+ * while the workload runs.  StaleReads serves reads from the local state
+ * of whichever node the client picked, which is not linearizable and is
+ * there to show that the checker notices.
+ */
+
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/pgelephant/pgraft/pgraft/sim"
+)
+
+type WorkloadConfig struct {
+	Cluster sim.Config
+
+	// Concurrent clients and the operations they invoke in total
+	Clients    int
+	Operations int
+
+	Keys         int
+	ReadFraction float64
+
+	// Ticks an operation may stay pending before its client gives up
+	// on it and moves on as a new process
+	Timeout int
+
+	Nemesis    bool
+	StaleReads bool
+}
+
+func (cfg *WorkloadConfig) setDefaults() {
+	if cfg.Clients == 0 {
+		cfg.Clients = 5
+	}
+	if cfg.Operations == 0 {
+		cfg.Operations = 1000
+	}
+	if cfg.Keys == 0 {
+		cfg.Keys = 3
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 50
+	}
+}
+
+// Ticks between nemesis actions, and ticks allowed at the end for
+// in-flight operations to return
+const (
+	nemesisInterval = 30
+	drainTicks      = 300
+)
+
+// Operation as carried in a log entry
+type logOp struct {
+	ID    int    `json:"id"`
+	Kind  Kind   `json:"kind"`
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+}
+
+type client struct {
+	process int
+	op      *Operation
+	node    uint64
+	since   uint64
+}
+
+type workload struct {
+	cfg     WorkloadConfig
+	cluster *sim.Cluster
+	history *History
+	clients []*client
+
+	// State machine of each node
+	kv map[uint64]map[string]string
+
+	// Client waiting for each pending operation
+	waiting map[int]*client
+
+	writes int
+}
+
+// Run the workload on a new simulated cluster and return its history
+func RunWorkload(cfg WorkloadConfig) (*History, error) {
+	cfg.setDefaults()
+	w := &workload{
+		cfg:     cfg,
+		history: New(),
+		kv:      make(map[uint64]map[string]string),
+		waiting: make(map[int]*client),
+	}
+	cfg.Cluster.OnApply = w.apply
+	c, err := sim.New(cfg.Cluster)
+	if err != nil {
+		return nil, err
+	}
+	w.cluster = c
+	for i := 0; i < cfg.Clients; i++ {
+		w.clients = append(w.clients, &client{process: i})
+	}
+	if _, err := c.WaitLeader(drainTicks); err != nil {
+		return nil, err
+	}
+
+	invoked := 0
+	for invoked < cfg.Operations {
+		for _, cl := range w.clients {
+			if cl.op == nil && invoked < cfg.Operations && c.Rand().Float64() < 0.5 {
+				w.invoke(cl)
+				invoked++
+			}
+		}
+		if err := c.Tick(); err != nil {
+			return nil, err
+		}
+		w.expire()
+		if cfg.Nemesis && c.Ticks()%nemesisInterval == 0 {
+			if err := w.nemesis(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Let the cluster recover and operations in flight return
+	c.Heal()
+	for _, id := range c.Nodes() {
+		if c.Crashed(id) {
+			if err := c.Restart(id); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for i := 0; i < drainTicks && len(w.waiting) > 0; i++ {
+		if err := c.Tick(); err != nil {
+			return nil, err
+		}
+	}
+	w.history.Close()
+	return w.history, nil
+}
+
+func (w *workload) invoke(cl *client) {
+	c := w.cluster
+	var live []uint64
+	for _, id := range c.Nodes() {
+		if !c.Crashed(id) {
+			live = append(live, id)
+		}
+	}
+	node := live[c.Rand().Intn(len(live))]
+	key := fmt.Sprintf("k%d", c.Rand().Intn(w.cfg.Keys))
+
+	if c.Rand().Float64() < w.cfg.ReadFraction {
+		op := w.history.Invoke(cl.process, Read, key, "")
+		if w.cfg.StaleReads {
+			w.history.Complete(op, w.kv[node][key])
+			return
+		}
+		w.submit(cl, op, node)
+		return
+	}
+	// Values are unique, so a read identifies the write it saw
+	w.writes++
+	op := w.history.Invoke(cl.process, Write, key, fmt.Sprintf("w%d", w.writes))
+	w.submit(cl, op, node)
+}
+
+func (w *workload) submit(cl *client, op *Operation, node uint64) {
+	data, _ := json.Marshal(logOp{ID: op.ID, Kind: op.Kind, Key: op.Key, Value: op.Value})
+	if err := w.cluster.Submit(node, data); err != nil {
+		// Not proposed at all, for example for want of a leader
+		w.history.Fail(op)
+		return
+	}
+	cl.op = op
+	cl.node = node
+	cl.since = w.cluster.Ticks()
+	w.waiting[op.ID] = cl
+}
+
+// Apply an entry to a node's state machine, completing the operation if
+// its client is waiting for this node
+func (w *workload) apply(node, index uint64, data []byte) {
+	var entry logOp
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return
+	}
+	state := w.kv[node]
+	if state == nil {
+		state = make(map[string]string)
+		w.kv[node] = state
+	}
+	if entry.Kind == Write {
+		state[entry.Key] = entry.Value
+	}
+
+	cl, waiting := w.waiting[entry.ID]
+	if !waiting || cl.node != node {
+		return
+	}
+	delete(w.waiting, entry.ID)
+	w.history.Complete(cl.op, state[entry.Key])
+	cl.op = nil
+}
+
+// Give up on operations pending too long; their clients continue as new
+// processes, as a crashed Jepsen client would
+func (w *workload) expire() {
+	for id, cl := range w.waiting {
+		if w.cluster.Ticks()-cl.since < uint64(w.cfg.Timeout) {
+			continue
+		}
+		w.history.Abandon(cl.op)
+		delete(w.waiting, id)
+		cl.op = nil
+		cl.process += w.cfg.Clients
+	}
+}
+
+// Crash or restart a node, or partition or heal the network, at random;
+// at most a minority is ever crashed
+func (w *workload) nemesis() error {
+	c := w.cluster
+	nodes := c.Nodes()
+	var crashed, live []uint64
+	for _, id := range nodes {
+		if c.Crashed(id) {
+			crashed = append(crashed, id)
+		} else {
+			live = append(live, id)
+		}
+	}
+
+	switch c.Rand().Intn(4) {
+	case 0:
+		if len(crashed)+1 <= (len(nodes)-1)/2 {
+			return c.Crash(live[c.Rand().Intn(len(live))])
+		}
+	case 1:
+		if len(crashed) > 0 {
+			return c.Restart(crashed[c.Rand().Intn(len(crashed))])
+		}
+	case 2:
+		shuffled := append([]uint64(nil), nodes...)
+		c.Rand().Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+		minority := (len(nodes) - 1) / 2
+		c.Partition(shuffled[:minority], shuffled[minority:])
+	case 3:
+		c.Heal()
+	}
+	return nil
+}
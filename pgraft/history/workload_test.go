@@ -0,0 +1,64 @@
+package history
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pgelephant/pgraft/pgraft/sim"
+)
+
+func workloadConfig(seed int64, nemesis bool) WorkloadConfig {
+	return WorkloadConfig{
+		Cluster:      sim.Config{Seed: seed, PreVote: true, CheckQuorum: true},
+		Operations:   300,
+		ReadFraction: 0.5,
+		Nemesis:      nemesis,
+	}
+}
+
+// Histories of the simulated cluster are linearizable, faults or not
+func TestWorkloadLinearizable(t *testing.T) {
+	for _, nemesis := range []bool{false, true} {
+		for seed := int64(1); seed <= 4; seed++ {
+			cfg := workloadConfig(seed, nemesis)
+			t.Run(fmt.Sprintf("nemesis=%v/seed=%d", nemesis, seed), func(t *testing.T) {
+				t.Parallel()
+				h, err := RunWorkload(cfg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				result, err := Check(h.Operations(), CheckOptions{Timeout: time.Minute})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !result.Linearizable {
+					t.Fatalf("key %q is not linearizable: %s", result.Key, result.Explanation)
+				}
+				if result.Operations == 0 {
+					t.Fatal("no operation was checked")
+				}
+			})
+		}
+	}
+}
+
+// Reads served from a node's local state are caught by the checker
+func TestWorkloadStaleReadsDetected(t *testing.T) {
+	for seed := int64(1); seed <= 20; seed++ {
+		cfg := workloadConfig(seed, true)
+		cfg.StaleReads = true
+		h, err := RunWorkload(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := Check(h.Operations(), CheckOptions{Timeout: time.Minute})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Linearizable {
+			return
+		}
+	}
+	t.Fatal("no history with stale reads was found not linearizable")
+}
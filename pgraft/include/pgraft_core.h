@@ -24,7 +24,8 @@ typedef enum
 	COMMAND_LOG_APPEND = 4,
 	COMMAND_LOG_COMMIT = 5,
 	COMMAND_LOG_APPLY = 6,
-	COMMAND_SHUTDOWN = 7
+	COMMAND_SHUTDOWN = 7,
+	COMMAND_SWITCHOVER = 8
 }			COMMAND_TYPE;
 
 /* Command status enum */
@@ -3,6 +3,262 @@
 
 #include "postgres.h"
 
+/* Go library API version this extension is built against */
+#define PGRAFT_GO_API_MAJOR		1
+#define PGRAFT_GO_API_MINOR		39
+
+/* Capability bits reported by pgraft_go_api_version */
+#define PGRAFT_CAP_LOG_CALLBACK		(UINT64CONST(1) << 0)
+#define PGRAFT_CAP_METRICS_SNAPSHOT	(UINT64CONST(1) << 1)
+#define PGRAFT_CAP_HANDLES			(UINT64CONST(1) << 2)
+#define PGRAFT_CAP_SHARED_RING		(UINT64CONST(1) << 3)
+#define PGRAFT_CAP_EVENT_QUEUE		(UINT64CONST(1) << 4)
+#define PGRAFT_CAP_SUMMARY			(UINT64CONST(1) << 5)
+#define PGRAFT_CAP_MEMORY_TUNING	(UINT64CONST(1) << 6)
+#define PGRAFT_CAP_INIT_JSON		(UINT64CONST(1) << 7)
+#define PGRAFT_CAP_FINALIZE			(UINT64CONST(1) << 8)
+#define PGRAFT_CAP_RESTART			(UINT64CONST(1) << 9)
+#define PGRAFT_CAP_CURSOR			(UINT64CONST(1) << 10)
+#define PGRAFT_CAP_COMMIT_CALLBACK	(UINT64CONST(1) << 11)
+#define PGRAFT_CAP_ASYNC			(UINT64CONST(1) << 12)
+#define PGRAFT_CAP_ERROR_TABLE		(UINT64CONST(1) << 13)
+#define PGRAFT_CAP_TRACKED_PROPOSALS	(UINT64CONST(1) << 14)
+#define PGRAFT_CAP_SELFTEST			(UINT64CONST(1) << 15)
+#define PGRAFT_CAP_VALIDATE_CONFIG	(UINT64CONST(1) << 16)
+#define PGRAFT_CAP_SYNC_STANDBYS	(UINT64CONST(1) << 17)
+#define PGRAFT_CAP_ROLE_HOOKS		(UINT64CONST(1) << 18)
+#define PGRAFT_CAP_LSN_FAILOVER		(UINT64CONST(1) << 19)
+#define PGRAFT_CAP_SWITCHOVER		(UINT64CONST(1) << 20)
+#define PGRAFT_CAP_FENCING			(UINT64CONST(1) << 21)
+#define PGRAFT_CAP_DDL				(UINT64CONST(1) << 22)
+#define PGRAFT_CAP_LOCKS			(UINT64CONST(1) << 23)
+#define PGRAFT_CAP_KV				(UINT64CONST(1) << 24)
+#define PGRAFT_CAP_BARRIER			(UINT64CONST(1) << 25)
+#define PGRAFT_CAP_SLOTS			(UINT64CONST(1) << 26)
+#define PGRAFT_CAP_BOOTSTRAP		(UINT64CONST(1) << 27)
+#define PGRAFT_CAP_REWIND			(UINT64CONST(1) << 28)
+#define PGRAFT_CAP_TIMELINES		(UINT64CONST(1) << 29)
+#define PGRAFT_CAP_MAINTENANCE		(UINT64CONST(1) << 30)
+#define PGRAFT_CAP_TAGS				(UINT64CONST(1) << 31)
+#define PGRAFT_CAP_SETTINGS			(UINT64CONST(1) << 32)
+#define PGRAFT_CAP_DURABLE_APPLIED	(UINT64CONST(1) << 33)
+#define PGRAFT_CAP_ARCHIVE			(UINT64CONST(1) << 34)
+#define PGRAFT_CAP_SEQUENCES		(UINT64CONST(1) << 35)
+#define PGRAFT_CAP_PARAMETERS		(UINT64CONST(1) << 36)
+#define PGRAFT_CAP_STALENESS		(UINT64CONST(1) << 37)
+#define PGRAFT_CAP_RESTARTS			(UINT64CONST(1) << 38)
+#define PGRAFT_CAP_BACKUP_NODE		(UINT64CONST(1) << 39)
+#define PGRAFT_CAP_CONSISTENCY_TOKENS	(UINT64CONST(1) << 40)
+#define PGRAFT_CAP_EVENT_SOCKET		(UINT64CONST(1) << 41)
+#define PGRAFT_CAP_FAULT_INJECTION	(UINT64CONST(1) << 42)
+#define PGRAFT_CAP_TRACE			(UINT64CONST(1) << 43)
+
+/*
+ * Error codes returned by Go exports; stable across releases.  Describe
+ * them with pgraft_go_strerror(), whose result is static.
+ */
+#define PGRAFT_OK						0
+#define PGRAFT_ERR_FAILED				(-1)
+#define PGRAFT_ERR_NOT_INITIALIZED		(-2)
+#define PGRAFT_ERR_NOT_RUNNING			(-3)
+#define PGRAFT_ERR_ALREADY_INITIALIZED	(-4)
+#define PGRAFT_ERR_INVALID_ARGUMENT		(-5)
+#define PGRAFT_ERR_INVALID_HANDLE		(-6)
+#define PGRAFT_ERR_NOT_LEADER			(-7)
+#define PGRAFT_ERR_PROPOSAL_DROPPED		(-8)
+#define PGRAFT_ERR_TIMEOUT				(-9)
+#define PGRAFT_ERR_STORAGE				(-10)
+#define PGRAFT_ERR_NETWORK				(-11)
+#define PGRAFT_ERR_CONFIG				(-12)
+#define PGRAFT_ERR_TLS					(-13)
+#define PGRAFT_ERR_COMPACTED			(-14)
+#define PGRAFT_ERR_NOT_FOUND			(-15)
+#define PGRAFT_ERR_PAUSED				(-16)
+#define PGRAFT_ERR_FENCING				(-17)
+
+/* Severities passed to the Go log callback */
+#define PGRAFT_GO_LOG_DEBUG		0
+#define PGRAFT_GO_LOG_INFO		1
+#define PGRAFT_GO_LOG_WARNING	2
+#define PGRAFT_GO_LOG_ERROR		3
+
+/*
+ * Opaque handle returned by pgraft_go_open; 0 is never a valid handle.  It
+ * names the process's one node, which pgraft_go_open only opens when no
+ * node is open or initialized, and is closed when the node stops.  Only
+ * the pgraft_go_handle_* exports take one; the others act on the same
+ * node without it.
+ */
+typedef uint64_t pgraft_handle_t;
+#define PGRAFT_INVALID_HANDLE	((pgraft_handle_t) 0)
+
+/*
+ * Shared-memory ring header; the record area starts PGRAFT_RING_DATA_OFFSET
+ * bytes into the region.  Records are a 24-byte header (uint32 length,
+ * uint32 flags, uint64 index, uint64 term) plus payload padded to 8 bytes.
+ */
+#define PGRAFT_RING_MAGIC		0x50475247
+#define PGRAFT_RING_VERSION		1
+#define PGRAFT_RING_DATA_OFFSET	64
+
+typedef struct pgraft_ring_header
+{
+	uint32_t	magic;
+	uint32_t	version;
+	uint64_t	capacity;
+	uint64_t	head;			/* advanced by the producer only */
+	uint64_t	tail;			/* advanced by the consumer only */
+} pgraft_ring_header;
+
+/*
+ * Event types carried in the flags word of event queue records.  For
+ * PGRAFT_EVENT_LEADER and PGRAFT_EVENT_STATE the index field holds the
+ * leader ID; PGRAFT_EVENT_STATE carries the role name as payload.
+ */
+#define PGRAFT_EVENT_COMMITTED	1
+#define PGRAFT_EVENT_LEADER		2
+#define PGRAFT_EVENT_STATE		3
+#define PGRAFT_EVENT_MEMBERSHIP	4
+#define PGRAFT_EVENT_COMPLETION	5	/* index holds the ticket */
+#define PGRAFT_EVENT_SYNC_STANDBYS	6	/* payload is the new setting */
+#define PGRAFT_EVENT_ROLE		7	/* index holds PGRAFT_TRANSITION_* */
+#define PGRAFT_EVENT_HEALTH		8	/* index holds the node, payload
+										 * "healthy" or "unhealthy" */
+
+/* Entry types returned by pgraft_go_cursor_next */
+#define PGRAFT_ENTRY_NORMAL			0
+#define PGRAFT_ENTRY_CONF_CHANGE	1
+#define PGRAFT_ENTRY_CONF_CHANGE_V2	2
+
+/* Roles reported in pgraft_go_summary.role */
+#define PGRAFT_ROLE_STOPPED			(-1)
+#define PGRAFT_ROLE_FOLLOWER		0
+#define PGRAFT_ROLE_CANDIDATE		1
+#define PGRAFT_ROLE_LEADER			2
+#define PGRAFT_ROLE_PRE_CANDIDATE	3
+
+/* Filled by pgraft_go_get_summary */
+typedef struct pgraft_go_summary
+{
+	uint64_t	node_id;
+	uint64_t	leader_id;
+	uint64_t	term;
+	int32_t		role;
+	uint64_t	commit_index;
+	uint64_t	applied_index;
+} pgraft_go_summary;
+
+/* Log callback, invoked from pgraft_go_drain_logs on the calling thread */
+typedef void (*pgraft_go_log_callback) (int level, const char *message);
+
+/*
+ * Commit callback, invoked synchronously from the Go apply loop on a Go
+ * runtime thread; it must not call PostgreSQL backend functions and data
+ * is only valid during the call.
+ */
+typedef void (*pgraft_go_commit_callback) (uint64_t index, uint64_t term, const char *data, int length, void *arg);
+
+/* States returned by pgraft_go_proposal_status */
+#define PGRAFT_PROPOSAL_PENDING		1
+#define PGRAFT_PROPOSAL_COMMITTED	2
+#define PGRAFT_PROPOSAL_APPLIED		3
+#define PGRAFT_PROPOSAL_FAILED		4
+
+/* Asynchronous operation kinds and completion statuses */
+#define PGRAFT_OP_PROPOSE			1
+#define PGRAFT_OP_ADD_PEER			2
+#define PGRAFT_OP_REMOVE_PEER		3
+#define PGRAFT_OP_SNAPSHOT			4
+
+#define PGRAFT_COMPLETION_OK		0
+#define PGRAFT_COMPLETION_FAILED	(-1)
+#define PGRAFT_COMPLETION_TIMEOUT	(-2)
+
+/* Result of an asynchronous operation, identified by its ticket */
+typedef struct pgraft_go_completion
+{
+	uint64_t	ticket;
+	int32_t		op;
+	int32_t		status;
+	uint64_t	index;
+	uint64_t	term;
+} pgraft_go_completion;
+
+/* Completion callback, invoked from pgraft_go_drain_completions */
+typedef void (*pgraft_go_completion_callback) (const pgraft_go_completion *completion, void *arg);
+
+/* Receives synchronous_standby_names, from pgraft_go_drain_sync_standbys */
+typedef void (*pgraft_go_sync_standby_callback) (const char *standby_names, void *arg);
+
+/* Debounced role transitions, from pgraft_go_drain_role_events */
+#define PGRAFT_TRANSITION_PROMOTE	1
+#define PGRAFT_TRANSITION_DEMOTE	2
+
+typedef void (*pgraft_go_role_callback) (int transition, uint64_t term, void *arg);
+
+/*
+ * Fencing callback, run by pgraft_go_run_fencing after this node is elected;
+ * returns 0 once the previous primary is fenced.  The policy decides what
+ * happens when it fails or times out.
+ */
+#define PGRAFT_FENCE_STEP_DOWN		0
+#define PGRAFT_FENCE_PROCEED		1
+
+typedef int (*pgraft_go_fencing_callback) (uint64_t term, uint64_t previous_leader, void *arg);
+
+/*
+ * Replicated DDL statement, from pgraft_go_drain_ddl; the extension executes
+ * it and reports the outcome with pgraft_go_ddl_ack
+ */
+typedef void (*pgraft_go_ddl_callback) (uint64_t ddl_id, uint64_t origin_node, uint64_t index, const char *statement, void *arg);
+
+/* KV watch events, from pgraft_go_drain_kv_events; value is NULL on delete */
+#define PGRAFT_KV_EVENT_PUT			1
+#define PGRAFT_KV_EVENT_DELETE		2
+
+typedef void (*pgraft_go_kv_watch_callback) (uint64_t watch_id, int event, const char *key, const char *value, int value_len, uint64_t revision, void *arg);
+
+/* Committed barrier, from pgraft_go_drain_barriers */
+typedef void (*pgraft_go_barrier_callback) (uint64_t barrier_id, uint64_t token, uint64_t index, uint64_t term, void *arg);
+
+/*
+ * Bootstrap of a new member from a base backup.  The snapshot provider
+ * returns the manifest for a request, or NULL on failure.
+ */
+#define PGRAFT_BOOTSTRAP_REQUESTED	1
+#define PGRAFT_BOOTSTRAP_READY		2
+#define PGRAFT_BOOTSTRAP_COMPLETED	3
+#define PGRAFT_BOOTSTRAP_FAILED		4
+
+typedef const char *(*pgraft_go_bootstrap_provider) (uint64_t bootstrap_id, uint64_t target_node, void *arg);
+
+/* Results of the pgraft_go_set_* setters for GUC-driven settings */
+#define PGRAFT_SETTING_APPLIED		0
+#define PGRAFT_SETTING_RESTART		1
+
+/*
+ * Archive callback, run by pgraft_go_run_archiver; kind is "log" or
+ * "snapshot".  Returns 0 once the data is stored safely, like
+ * archive_command.
+ */
+typedef int (*pgraft_go_archive_callback) (const char *kind, uint64_t first_index, uint64_t last_index, const char *data, int length, void *arg);
+
+/*
+ * Replicated parameter change, from pgraft_go_drain_parameters; value is NULL
+ * when the parameter is reset.  The extension applies it and reports the
+ * outcome with pgraft_go_parameter_ack
+ */
+typedef void (*pgraft_go_parameter_callback) (uint64_t change_id, uint64_t index, const char *name, const char *value, void *arg);
+
+/*
+ * Fault kinds for pgraft_go_fault_set, only exported by libraries built with
+ * -tags pgraft_faults (PGRAFT_CAP_FAULT_INJECTION)
+ */
+#define PGRAFT_FAULT_DROP		1
+#define PGRAFT_FAULT_DELAY		2
+#define PGRAFT_FAULT_REORDER	3
+#define PGRAFT_FAULT_CORRUPT	4
+
 /* Go library function types */
 typedef int (*pgraft_go_init_func) (int node_id, char *address, int port);
 typedef int (*pgraft_go_start_func) (void);
@@ -19,11 +275,172 @@ typedef void (*pgraft_go_set_debug_func) (int enabled);
 typedef int (*pgraft_go_start_network_server_func) (int port);
 typedef void (*pgraft_go_free_string_func) (char *str);
 typedef int (*pgraft_go_update_cluster_state_func) (int64_t leader_id, int64_t current_term, const char *state);
+typedef void (*pgraft_go_set_log_callback_func) (pgraft_go_log_callback callback, int min_level);
+typedef int (*pgraft_go_drain_logs_func) (void);
+typedef char *(*pgraft_go_snapshot_metrics_func) (int reset);
+typedef pgraft_handle_t (*pgraft_go_open_func) (int node_id, char *address, int port);
+typedef int (*pgraft_go_handle_acquire_func) (pgraft_handle_t handle);
+typedef int (*pgraft_go_close_func) (pgraft_handle_t handle);
+typedef int (*pgraft_go_handle_valid_func) (pgraft_handle_t handle);
+typedef int (*pgraft_go_handle_start_func) (pgraft_handle_t handle);
+typedef int (*pgraft_go_handle_add_peer_func) (pgraft_handle_t handle, int node_id, char *address, int port);
+typedef int (*pgraft_go_handle_remove_peer_func) (pgraft_handle_t handle, int node_id);
+typedef char *(*pgraft_go_handle_get_state_func) (pgraft_handle_t handle);
+typedef int64_t (*pgraft_go_handle_get_leader_func) (pgraft_handle_t handle);
+typedef int32_t (*pgraft_go_handle_get_term_func) (pgraft_handle_t handle);
+typedef int (*pgraft_go_handle_is_leader_func) (pgraft_handle_t handle);
+typedef int (*pgraft_go_handle_append_log_func) (pgraft_handle_t handle, char *data, int length);
+typedef char *(*pgraft_go_handle_get_stats_func) (pgraft_handle_t handle);
+typedef int (*pgraft_go_ring_attach_func) (void *proposals, size_t proposals_size, void *applied, size_t applied_size);
+typedef void (*pgraft_go_ring_detach_func) (void);
+typedef void (*pgraft_go_ring_notify_func) (void);
+typedef long long (*pgraft_go_ring_overflows_func) (void);
+typedef int (*pgraft_go_event_attach_func) (void *region, size_t size, int wakeup_fd);
+typedef void (*pgraft_go_event_detach_func) (void);
+typedef long long (*pgraft_go_event_dropped_func) (void);
+typedef int (*pgraft_go_get_summary_func) (pgraft_go_summary *summary);
+typedef int (*pgraft_go_api_version_func) (int *major, int *minor, uint64_t *capabilities);
+typedef int (*pgraft_go_api_compatible_func) (int major, int minor);
+typedef int64_t (*pgraft_go_set_memory_limit_func) (int64_t limit_bytes);
+typedef int (*pgraft_go_set_gc_percent_func) (int percent);
+typedef int (*pgraft_go_set_heap_ballast_func) (int64_t size_bytes);
+typedef int (*pgraft_go_init_json_func) (const char *config_json);
+typedef int (*pgraft_go_finalize_func) (void);
+typedef uint64_t (*pgraft_go_cursor_open_func) (uint64_t from_index);
+typedef int (*pgraft_go_cursor_next_func) (uint64_t cursor, uint64_t *index, uint64_t *term, int *entry_type, char **data, int *length);
+typedef int (*pgraft_go_cursor_close_func) (uint64_t cursor);
+typedef void (*pgraft_go_set_commit_callback_func) (pgraft_go_commit_callback callback, void *arg);
+typedef uint64_t (*pgraft_go_propose_async_func) (char *data, int length);
+typedef uint64_t (*pgraft_go_add_peer_async_func) (int node_id, char *address, int port);
+typedef uint64_t (*pgraft_go_remove_peer_async_func) (int node_id);
+typedef uint64_t (*pgraft_go_create_snapshot_async_func) (void);
+typedef int (*pgraft_go_poll_completions_func) (pgraft_go_completion *out, int max);
+typedef void (*pgraft_go_set_completion_callback_func) (pgraft_go_completion_callback callback, void *arg);
+typedef int (*pgraft_go_drain_completions_func) (void);
+typedef int (*pgraft_go_pending_completions_func) (void);
+typedef const char *(*pgraft_go_strerror_func) (int code);
+typedef const char *(*pgraft_go_error_name_func) (int code);
+typedef int (*pgraft_go_propose_tracked_func) (uint64_t correlation_id, char *data, int length);
+typedef int (*pgraft_go_proposal_status_func) (uint64_t correlation_id, uint64_t *index, uint64_t *term);
+typedef int (*pgraft_go_proposal_forget_func) (uint64_t correlation_id);
+typedef char *(*pgraft_go_selftest_func) (void);
+typedef char *(*pgraft_go_validate_config_func) (const char *config_json);
+typedef int (*pgraft_go_set_standby_name_func) (int node_id, const char *name);
+typedef char *(*pgraft_go_get_sync_standby_names_func) (void);
+typedef void (*pgraft_go_set_sync_standby_callback_func) (pgraft_go_sync_standby_callback callback, void *arg);
+typedef int (*pgraft_go_drain_sync_standbys_func) (void);
+typedef int (*pgraft_go_set_promotion_debounce_func) (int debounce_ms);
+typedef void (*pgraft_go_set_role_callback_func) (pgraft_go_role_callback callback, void *arg);
+typedef int (*pgraft_go_drain_role_events_func) (void);
+typedef int (*pgraft_go_is_promoted_func) (void);
+typedef int (*pgraft_go_set_node_lsn_func) (int node_id, uint64_t lsn);
+typedef void (*pgraft_go_set_lsn_failover_func) (int enabled, uint64_t lag_threshold);
+typedef int64_t (*pgraft_go_best_leader_candidate_func) (uint64_t *lsn);
+typedef char *(*pgraft_go_switchover_func) (int target_node, int timeout_ms);
+typedef int (*pgraft_go_set_fencing_callback_func) (pgraft_go_fencing_callback callback, void *arg, int timeout_ms, int policy);
+typedef int (*pgraft_go_run_fencing_func) (void);
+typedef int (*pgraft_go_propose_ddl_func) (const char *statement, uint64_t *ddl_id);
+typedef int (*pgraft_go_ddl_ack_func) (uint64_t ddl_id, int status, const char *message);
+typedef void (*pgraft_go_set_ddl_callback_func) (pgraft_go_ddl_callback callback, void *arg);
+typedef int (*pgraft_go_drain_ddl_func) (void);
+typedef char *(*pgraft_go_ddl_status_func) (uint64_t ddl_id);
+typedef int (*pgraft_go_lock_acquire_func) (const char *name, int ttl_ms, int timeout_ms);
+typedef int (*pgraft_go_lock_renew_func) (const char *name, int ttl_ms, int timeout_ms);
+typedef int (*pgraft_go_lock_release_func) (const char *name, int timeout_ms);
+typedef int (*pgraft_go_lock_holder_func) (const char *name, uint64_t *owner, int64_t *expires_ms);
+typedef int (*pgraft_go_kv_put_func) (const char *key, const char *value, int value_len, int timeout_ms, uint64_t *revision);
+typedef int (*pgraft_go_kv_cas_func) (const char *key, uint64_t expected_revision, const char *value, int value_len, int timeout_ms, uint64_t *revision);
+typedef int (*pgraft_go_kv_delete_func) (const char *key, int timeout_ms);
+typedef int (*pgraft_go_kv_get_func) (const char *key, char **value, int *value_len, uint64_t *revision);
+typedef int64_t (*pgraft_go_kv_watch_func) (const char *prefix, pgraft_go_kv_watch_callback callback, void *arg);
+typedef int (*pgraft_go_kv_unwatch_func) (uint64_t watch_id);
+typedef int (*pgraft_go_drain_kv_events_func) (void);
+typedef int (*pgraft_go_barrier_register_func) (uint64_t token, uint64_t *barrier_id);
+typedef int (*pgraft_go_barrier_wait_func) (uint64_t barrier_id, int timeout_ms);
+typedef uint64_t (*pgraft_go_barrier_watermark_func) (void);
+typedef void (*pgraft_go_set_barrier_callback_func) (pgraft_go_barrier_callback callback, void *arg);
+typedef int (*pgraft_go_drain_barriers_func) (void);
+typedef int (*pgraft_go_report_slot_func) (const char *name, const char *plugin, const char *database, uint64_t restart_lsn, uint64_t confirmed_flush);
+typedef int (*pgraft_go_report_slot_dropped_func) (const char *name);
+typedef int (*pgraft_go_set_slot_sync_interval_func) (int interval_ms);
+typedef int (*pgraft_go_get_slot_position_func) (const char *name, uint64_t *restart_lsn, uint64_t *confirmed_flush);
+typedef char *(*pgraft_go_get_slot_positions_func) (void);
+typedef int (*pgraft_go_bootstrap_begin_func) (uint64_t target_node, uint64_t *bootstrap_id);
+typedef void (*pgraft_go_set_bootstrap_provider_func) (pgraft_go_bootstrap_provider provider, void *arg);
+typedef int (*pgraft_go_run_bootstrap_provider_func) (void);
+typedef char *(*pgraft_go_bootstrap_manifest_func) (uint64_t node);
+typedef char *(*pgraft_go_bootstrap_status_func) (uint64_t bootstrap_id);
+typedef int (*pgraft_go_bootstrap_complete_func) (uint64_t bootstrap_id, int status, const char *message);
+typedef int (*pgraft_go_report_promotion_func) (uint32_t timeline, uint64_t switch_lsn);
+typedef int (*pgraft_go_report_node_position_func) (uint32_t timeline, uint64_t flush_lsn);
+typedef int (*pgraft_go_rewind_complete_func) (uint32_t timeline, int status, const char *message);
+typedef char *(*pgraft_go_rewind_status_func) (uint64_t node);
+typedef int (*pgraft_go_report_timeline_switch_func) (uint32_t parent_timeline, uint32_t timeline, uint64_t switch_lsn);
+typedef int (*pgraft_go_timeline_diverged_func) (uint32_t timeline, uint64_t lsn, uint64_t *divergence_lsn);
+typedef char *(*pgraft_go_timeline_history_func) (void);
+typedef int (*pgraft_go_set_maintenance_func) (int enabled, const char *reason, int timeout_ms);
+typedef int (*pgraft_go_in_maintenance_func) (void);
+typedef char *(*pgraft_go_get_maintenance_func) (void);
+typedef int (*pgraft_go_set_node_tag_func) (uint64_t node, const char *key, const char *value);
+typedef char *(*pgraft_go_get_node_tag_func) (uint64_t node, const char *key);
+typedef char *(*pgraft_go_get_node_tags_func) (uint64_t node);
+typedef char *(*pgraft_go_nodes_with_tag_func) (const char *key, const char *value);
+typedef int (*pgraft_go_set_tick_interval_func) (int interval_ms);
+typedef int (*pgraft_go_set_election_timeout_func) (int timeout_ms);
+typedef int (*pgraft_go_set_heartbeat_interval_func) (int interval_ms);
+typedef int (*pgraft_go_set_max_size_per_msg_func) (uint64_t size);
+typedef int (*pgraft_go_set_max_inflight_msgs_func) (int count);
+typedef int (*pgraft_go_set_pre_vote_func) (int enabled);
+typedef int (*pgraft_go_set_check_quorum_func) (int enabled);
+typedef int (*pgraft_go_set_snapshot_threshold_func) (uint64_t entries);
+typedef int (*pgraft_go_set_log_level_func) (int level);
+typedef char *(*pgraft_go_pending_restart_settings_func) (void);
+typedef int (*pgraft_go_set_durable_applied_func) (uint64_t index);
+typedef uint64_t (*pgraft_go_get_durable_applied_func) (void);
+typedef void (*pgraft_go_set_archive_callback_func) (pgraft_go_archive_callback callback, void *arg);
+typedef int (*pgraft_go_run_archiver_func) (void);
+typedef char *(*pgraft_go_archive_status_func) (void);
+typedef int (*pgraft_go_sequence_allocate_func) (const char *name, uint64_t count, int timeout_ms, uint64_t *first);
+typedef uint64_t (*pgraft_go_sequence_current_func) (const char *name);
+typedef char *(*pgraft_go_sequences_func) (void);
+typedef int (*pgraft_go_propose_parameter_func) (const char *name, const char *value, uint64_t *change_id);
+typedef int (*pgraft_go_parameter_ack_func) (uint64_t change_id, int status, const char *message);
+typedef void (*pgraft_go_set_parameter_callback_func) (pgraft_go_parameter_callback callback, void *arg);
+typedef int (*pgraft_go_drain_parameters_func) (void);
+typedef char *(*pgraft_go_parameter_status_func) (uint64_t change_id);
+typedef char *(*pgraft_go_parameters_func) (void);
+typedef int (*pgraft_go_set_node_replay_lsn_func) (uint64_t node_id, uint64_t lsn);
+typedef int (*pgraft_go_node_staleness_func) (uint64_t node_id, uint64_t *entries, uint64_t *behind_ms);
+typedef char *(*pgraft_go_get_staleness_func) (void);
+typedef int (*pgraft_go_request_restart_func) (void);
+typedef int (*pgraft_go_cancel_restart_func) (void);
+typedef int (*pgraft_go_restart_complete_func) (void);
+typedef int (*pgraft_go_restart_granted_func) (void);
+typedef int (*pgraft_go_set_restart_grant_timeout_func) (int timeout_ms);
+typedef char *(*pgraft_go_restart_status_func) (void);
+typedef int (*pgraft_go_is_backup_node_func) (void);
+typedef uint64_t (*pgraft_go_get_backup_node_func) (uint64_t *epoch);
+typedef int (*pgraft_go_resign_backup_func) (void);
+typedef char *(*pgraft_go_backup_status_func) (void);
+typedef char *(*pgraft_go_mint_consistency_token_func) (void);
+typedef int (*pgraft_go_token_reached_func) (const char *token);
+typedef int (*pgraft_go_wait_for_token_func) (const char *token, int timeout_ms);
+typedef int (*pgraft_go_start_event_socket_func) (const char *path);
+typedef void (*pgraft_go_stop_event_socket_func) (void);
+typedef int (*pgraft_go_event_subscribers_func) (void);
+typedef int (*pgraft_go_fault_set_func) (uint64_t peer, int kind, double probability, int delay_ms);
+typedef void (*pgraft_go_fault_clear_func) (void);
+typedef void (*pgraft_go_fault_seed_func) (int64_t seed);
+typedef void (*pgraft_go_fault_crash_ready_loop_func) (void);
+typedef int64_t (*pgraft_go_faults_injected_func) (void);
+typedef int (*pgraft_go_trace_start_func) (const char *path);
+typedef void (*pgraft_go_trace_stop_func) (void);
 
 /* Go library interface functions */
 int			pgraft_go_load_library(void);
 void		pgraft_go_unload_library(void);
 bool		pgraft_go_is_loaded(void);
+void		pgraft_go_drain_log_messages(void);
 int			pgraft_go_init(int node_id, char *address, int port);
 int			pgraft_go_start(void);
 int			pgraft_go_start_network_server(int port);
@@ -44,5 +461,6 @@ pgraft_go_set_debug_func pgraft_go_get_set_debug_func(void);
 pgraft_go_start_network_server_func pgraft_go_get_start_network_server_func(void);
 pgraft_go_free_string_func pgraft_go_get_free_string_func(void);
 pgraft_go_update_cluster_state_func pgraft_go_get_update_cluster_state_func(void);
+pgraft_go_switchover_func pgraft_go_get_switchover_func(void);
 
 #endif
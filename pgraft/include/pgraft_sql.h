@@ -9,6 +9,7 @@ Datum		pgraft_init(PG_FUNCTION_ARGS);
 Datum		pgraft_init_guc(PG_FUNCTION_ARGS);
 Datum		pgraft_add_node(PG_FUNCTION_ARGS);
 Datum		pgraft_remove_node(PG_FUNCTION_ARGS);
+Datum		pgraft_switchover(PG_FUNCTION_ARGS);
 Datum		pgraft_get_cluster_status_table(PG_FUNCTION_ARGS);
 Datum		pgraft_get_leader(PG_FUNCTION_ARGS);
 Datum		pgraft_get_term(PG_FUNCTION_ARGS);
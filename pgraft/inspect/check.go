@@ -0,0 +1,151 @@
+/*
+ * check.go
+ * Integrity checks of reconstructed raft storage
+ *
+ * Errors are states raft itself would refuse or could not have produced:
+ * gaps in the log, terms going backwards, a commit index past the end of
+ * the log.  Warnings are states that are legal but limit what the files
+ * can tell, such as a log that does not start where the snapshot ends.
+ */
+
+package inspect
+
+import (
+	"fmt"
+
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+type Finding struct {
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+type findings []Finding
+
+func (f *findings) errorf(format string, args ...interface{}) {
+	*f = append(*f, Finding{SeverityError, fmt.Sprintf(format, args...)})
+}
+
+func (f *findings) warnf(format string, args ...interface{}) {
+	*f = append(*f, Finding{SeverityWarning, fmt.Sprintf(format, args...)})
+}
+
+// Check the state, returning every problem found
+func Check(s *State) []Finding {
+	f := append(findings{}, s.damage...)
+	checkSnapshot(&f, s)
+	checkLog(&f, s)
+	if s.HasHardState {
+		checkHardState(&f, s)
+	}
+	return f
+}
+
+func checkSnapshot(f *findings, s *State) {
+	meta := s.Snapshot
+	if meta.Index == 0 {
+		return
+	}
+	if meta.Term == 0 {
+		f.errorf("snapshot at index %d has term 0", meta.Index)
+	}
+	cs := meta.ConfState
+	if len(cs.Voters) == 0 {
+		f.errorf("snapshot at index %d has no voters", meta.Index)
+	}
+	voters := make(map[uint64]bool)
+	for _, id := range cs.Voters {
+		voters[id] = true
+	}
+	for _, id := range cs.Learners {
+		if voters[id] {
+			f.errorf("node %d is both a voter and a learner in the snapshot", id)
+		}
+	}
+}
+
+func checkLog(f *findings, s *State) {
+	if len(s.Entries) == 0 {
+		return
+	}
+	first := s.Entries[0]
+	switch {
+	case s.Snapshot.Index > 0 && first.Index != s.Snapshot.Index+1:
+		f.warnf("log starts at index %d, not after the snapshot at index %d", first.Index, s.Snapshot.Index)
+	case s.Snapshot.Index == 0 && first.Index != 1:
+		f.warnf("log starts at index %d and there is no snapshot; earlier entries are missing", first.Index)
+	}
+	if first.Term < s.Snapshot.Term {
+		f.errorf("entry %d has term %d, lower than the snapshot's term %d", first.Index, first.Term, s.Snapshot.Term)
+	}
+
+	for i := 1; i < len(s.Entries); i++ {
+		prev, entry := s.Entries[i-1], s.Entries[i]
+		switch {
+		case entry.Index == prev.Index+1:
+		case entry.Index <= prev.Index:
+			f.errorf("entry %d (term %d) follows entry %d: the files hold conflicting entries", entry.Index, entry.Term, prev.Index)
+		default:
+			f.errorf("entries %d-%d are missing", prev.Index+1, entry.Index-1)
+		}
+		if entry.Term < prev.Term {
+			f.errorf("entry %d has term %d, lower than the term %d of entry %d", entry.Index, entry.Term, prev.Term, prev.Index)
+		}
+	}
+
+	for _, entry := range s.Entries {
+		switch entry.Type {
+		case raftpb.EntryNormal:
+		case raftpb.EntryConfChange:
+			var cc raftpb.ConfChange
+			if err := cc.Unmarshal(entry.Data); err != nil {
+				f.errorf("entry %d: undecodable conf change: %v", entry.Index, err)
+			}
+		case raftpb.EntryConfChangeV2:
+			var cc raftpb.ConfChangeV2
+			if err := cc.Unmarshal(entry.Data); err != nil {
+				f.errorf("entry %d: undecodable conf change: %v", entry.Index, err)
+			}
+		default:
+			f.errorf("entry %d has unknown type %d", entry.Index, entry.Type)
+		}
+	}
+}
+
+func checkHardState(f *findings, s *State) {
+	hs := s.HardState
+	last := s.LastIndex()
+	if hs.Commit > last {
+		f.errorf("commit index %d is past the last index %d", hs.Commit, last)
+	}
+	if hs.Commit < s.Snapshot.Index {
+		f.errorf("commit index %d is before the snapshot at index %d", hs.Commit, s.Snapshot.Index)
+	}
+	lastTerm := s.Snapshot.Term
+	if len(s.Entries) > 0 {
+		lastTerm = s.Entries[len(s.Entries)-1].Term
+	}
+	if hs.Term < lastTerm {
+		f.errorf("term %d is lower than the term %d of the last entry", hs.Term, lastTerm)
+	}
+	if hs.Term == 0 && last > 0 {
+		f.errorf("term is 0 with entries up to index %d", last)
+	}
+}
+
+// Number of error findings
+func Errors(findings []Finding) int {
+	n := 0
+	for _, finding := range findings {
+		if finding.Severity == SeverityError {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,108 @@
+/*
+ * describe.go
+ * Human-readable descriptions of log entries
+ *
+ * Conf changes are shown as raft formats them.  Typed entries, which the
+ * library marks with a reserved prefix (see pgraft_go_typed.go), are shown
+ * with the name of their service and their JSON body; other entries are
+ * payloads of the extension and are shown as text when printable, as are
+ * the payloads in proposal envelopes, after their origin and proposal ID.
+ */
+
+package inspect
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+var typedEntryMagic = []byte{0x00, 'P', 'G', 'R', 1}
+
+// Names of the typed entry kinds of pgraft_go_typed.go
+var typedEntryNames = map[byte]string{
+	1:  "ddl",
+	2:  "ddl-ack",
+	3:  "lock",
+	4:  "kv",
+	5:  "barrier",
+	6:  "slots",
+	7:  "bootstrap",
+	8:  "rewind",
+	9:  "maintenance",
+	10: "tag",
+	11: "sequence",
+	12: "parameter",
+	13: "parameter-ack",
+	14: "restart",
+	15: "backup",
+	16: "proposal",
+}
+
+const typedEntryProposal = 16
+
+// Type of an entry: "confchange", the name of a typed entry, "empty" for
+// the entries leaders append on election, or "payload"
+func EntryType(entry raftpb.Entry) string {
+	switch entry.Type {
+	case raftpb.EntryConfChange, raftpb.EntryConfChangeV2:
+		return "confchange"
+	case raftpb.EntryNormal:
+	default:
+		return fmt.Sprintf("type-%d", entry.Type)
+	}
+	if len(entry.Data) == 0 {
+		return "empty"
+	}
+	if bytes.HasPrefix(entry.Data, typedEntryMagic) && len(entry.Data) > len(typedEntryMagic) {
+		kind := entry.Data[len(typedEntryMagic)]
+		if name, known := typedEntryNames[kind]; known {
+			return name
+		}
+		return fmt.Sprintf("typed-%d", kind)
+	}
+	return "payload"
+}
+
+// Contents of an entry, cut to limit bytes
+func DescribeEntry(entry raftpb.Entry, limit int) string {
+	var text string
+	switch entry.Type {
+	case raftpb.EntryConfChange:
+		var cc raftpb.ConfChange
+		if err := cc.Unmarshal(entry.Data); err != nil {
+			return fmt.Sprintf("undecodable: %v", err)
+		}
+		text = raftpb.ConfChangesToString(cc.AsV2().Changes)
+	case raftpb.EntryConfChangeV2:
+		var cc raftpb.ConfChangeV2
+		if err := cc.Unmarshal(entry.Data); err != nil {
+			return fmt.Sprintf("undecodable: %v", err)
+		}
+		text = raftpb.ConfChangesToString(cc.Changes)
+	default:
+		data := entry.Data
+		typed := bytes.HasPrefix(data, typedEntryMagic) && len(data) > len(typedEntryMagic)
+		if typed && data[len(typedEntryMagic)] == typedEntryProposal && len(data) >= len(typedEntryMagic)+17 {
+			header := data[len(typedEntryMagic)+1:]
+			text = fmt.Sprintf("origin=%d proposal=%d ", binary.BigEndian.Uint64(header), binary.BigEndian.Uint64(header[8:]))
+			data, typed = header[16:], false
+		}
+		switch {
+		case typed && utf8.Valid(data[len(typedEntryMagic)+1:]):
+			text += string(data[len(typedEntryMagic)+1:])
+		case utf8.Valid(data):
+			text += strconv.Quote(string(data))
+		default:
+			text += fmt.Sprintf("%x", data)
+		}
+	}
+	if limit > 0 && len(text) > limit {
+		text = text[:limit] + "..."
+	}
+	return text
+}
@@ -0,0 +1,250 @@
+/*
+ * storage.go
+ * Reconstructing a node's raft storage from files, read-only
+ *
+ * The library keeps its log and HardState in memory, so the raft state of
+ * a node exists on disk only in two forms: what the archive callback
+ * stored (log segments and snapshots, see pgraft_go_archive.go) and trace
+ * files, whose storage record and Ready batches replay every write the
+ * node made to its storage.  Load builds a State from either, so a node
+ * that will not start can be examined without running anything.  The
+ * segment format is defined by the library and must change in both
+ * places.
+ */
+
+package inspect
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/pgelephant/pgraft/pgraft/trace"
+	"go.etcd.io/raft/v3"
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+// Raft storage as found in the files it was loaded from
+type State struct {
+	// Latest snapshot; its data is kept only as a size
+	Snapshot     raftpb.SnapshotMetadata
+	SnapshotSize int
+
+	// Archives do not carry a HardState
+	HardState    raftpb.HardState
+	HasHardState bool
+
+	// Log after the snapshot, in file order; gaps and overlaps between
+	// files are left for Check to report
+	Entries []raftpb.Entry
+
+	Sources []string
+
+	// Damage found while reading, such as a truncated segment
+	damage []Finding
+}
+
+// Kinds of file Load understands
+const (
+	FileTrace    = "trace"
+	FileSegment  = "segment"
+	FileSnapshot = "snapshot"
+)
+
+var traceMagic = []byte("PGRTRACE")
+
+// Decode an archived log segment: entries, each a 4-byte big-endian
+// length followed by the protobuf-encoded raftpb.Entry
+func DecodeSegment(data []byte) ([]raftpb.Entry, error) {
+	var entries []raftpb.Entry
+	for offset := 0; offset < len(data); {
+		if len(data)-offset < 4 {
+			return entries, fmt.Errorf("truncated length at offset %d", offset)
+		}
+		length := int(binary.BigEndian.Uint32(data[offset:]))
+		offset += 4
+		if length > len(data)-offset {
+			return entries, fmt.Errorf("entry of %d bytes at offset %d runs past the end", length, offset-4)
+		}
+		var entry raftpb.Entry
+		if err := entry.Unmarshal(data[offset : offset+length]); err != nil {
+			return entries, fmt.Errorf("entry at offset %d: %v", offset-4, err)
+		}
+		entries = append(entries, entry)
+		offset += length
+	}
+	return entries, nil
+}
+
+// Decode an archived snapshot, the protobuf-encoded raftpb.Snapshot
+func DecodeSnapshot(data []byte) (raftpb.Snapshot, error) {
+	var snapshot raftpb.Snapshot
+	if err := snapshot.Unmarshal(data); err != nil {
+		return raftpb.Snapshot{}, err
+	}
+	if raft.IsEmptySnap(snapshot) {
+		return raftpb.Snapshot{}, errors.New("snapshot has no index")
+	}
+	return snapshot, nil
+}
+
+// Guess the kind of a file from its contents: a trace starts with its
+// magic, and a segment starts with an entry where a snapshot, whose first
+// bytes read as a length are far too large, does not
+func DetectKind(data []byte) string {
+	if bytes.HasPrefix(data, traceMagic) {
+		return FileTrace
+	}
+	if entries, _ := DecodeSegment(data); len(entries) > 0 && entries[0].Index > 0 {
+		return FileSegment
+	}
+	return FileSnapshot
+}
+
+// Load the state in paths, which are either one trace or any number of
+// archived segments and snapshots; kind forces the kind of every file,
+// and is detected per file when empty
+func Load(paths []string, kind string) (*State, error) {
+	state := &State{Sources: paths}
+	var segments [][]raftpb.Entry
+	var traces int
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		fileKind := kind
+		if fileKind == "" {
+			fileKind = DetectKind(data)
+		}
+		switch fileKind {
+		case FileTrace:
+			traces++
+			if err := state.loadTrace(path); err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+		case FileSegment:
+			// Keep what could be decoded of a damaged segment
+			entries, err := DecodeSegment(data)
+			if err != nil {
+				state.damage = append(state.damage, Finding{SeverityError, fmt.Sprintf("%s: %v", path, err)})
+			}
+			if len(entries) > 0 {
+				segments = append(segments, entries)
+			}
+		case FileSnapshot:
+			snapshot, err := DecodeSnapshot(data)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			if snapshot.Metadata.Index > state.Snapshot.Index {
+				state.Snapshot = snapshot.Metadata
+				state.SnapshotSize = len(snapshot.Data)
+			}
+		default:
+			return nil, fmt.Errorf("unknown file kind %q", fileKind)
+		}
+	}
+	if traces > 0 && len(paths) > 1 {
+		return nil, errors.New("a trace cannot be combined with other files")
+	}
+
+	// Archived segments in log order, without what the snapshot covers;
+	// a segment archived again after a failed attempt repeats entries,
+	// which are dropped if identical
+	sort.SliceStable(segments, func(i, j int) bool {
+		return segments[i][0].Index < segments[j][0].Index
+	})
+	seen := make(map[uint64]uint64)
+	for _, seg := range segments {
+		for _, entry := range seg {
+			if entry.Index <= state.Snapshot.Index {
+				continue
+			}
+			if term, exists := seen[entry.Index]; exists && term == entry.Term {
+				continue
+			}
+			seen[entry.Index] = entry.Term
+			state.Entries = append(state.Entries, entry)
+		}
+	}
+	return state, nil
+}
+
+// Replay the storage writes recorded in a trace
+func (s *State) loadTrace(path string) error {
+	records, err := trace.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		switch record.Kind {
+		case trace.KindStorage:
+			storage, err := record.Storage()
+			if err != nil {
+				return err
+			}
+			s.applySnapshot(storage.Snapshot)
+			s.setHardState(storage.HardState)
+			s.append(storage.Entries)
+		case trace.KindReady:
+			ready, err := record.Ready()
+			if err != nil {
+				return err
+			}
+			s.applySnapshot(ready.Snapshot)
+			s.setHardState(ready.HardState)
+			s.append(ready.Entries)
+		}
+	}
+	return nil
+}
+
+func (s *State) applySnapshot(snapshot raftpb.Snapshot) {
+	if raft.IsEmptySnap(snapshot) {
+		return
+	}
+	s.Snapshot = snapshot.Metadata
+	s.SnapshotSize = len(snapshot.Data)
+	var kept []raftpb.Entry
+	for _, entry := range s.Entries {
+		if entry.Index > snapshot.Metadata.Index {
+			kept = append(kept, entry)
+		}
+	}
+	s.Entries = kept
+}
+
+func (s *State) setHardState(hs raftpb.HardState) {
+	if raft.IsEmptyHardState(hs) {
+		return
+	}
+	s.HardState = hs
+	s.HasHardState = true
+}
+
+// Append as raft storage does, replacing any entries from the first new
+// index on; a gap is kept for Check to report
+func (s *State) append(entries []raftpb.Entry) {
+	for _, entry := range entries {
+		if entry.Index <= s.Snapshot.Index {
+			continue
+		}
+		cut := len(s.Entries)
+		for cut > 0 && s.Entries[cut-1].Index >= entry.Index {
+			cut--
+		}
+		s.Entries = append(s.Entries[:cut], entry)
+	}
+}
+
+// Index of the last entry, or of the snapshot when there are none
+func (s *State) LastIndex() uint64 {
+	if len(s.Entries) == 0 {
+		return s.Snapshot.Index
+	}
+	return s.Entries[len(s.Entries)-1].Index
+}
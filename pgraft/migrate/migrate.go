@@ -0,0 +1,317 @@
+/*
+ * migrate.go
+ * Converting Patroni and repmgr clusters to pgraft and ramd
+ *
+ * Both tools keep one configuration file per member.  Each file is
+ * parsed into a Cluster of one node, the files of all members are merged,
+ * and the result becomes a bootstrap plan (node IDs, peers, raft timing)
+ * plus a ramd.conf per member.  Timeouts carry over by meaning: the time
+ * after which the old tool gave up on the primary becomes both ramd's
+ * failover timeout and the raft election timeout, so failover is neither
+ * faster nor slower than before the migration.  Settings that have no
+ * equivalent are not dropped silently; they become notes for the
+ * operator.
+ */
+
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pgelephant/pgraft/pgraft/bootstrap"
+)
+
+const (
+	FormatPatroni = "patroni"
+	FormatRepmgr  = "repmgr"
+)
+
+// Tick interval of the converted raft timing, the library's default
+const tickInterval = 100 * time.Millisecond
+
+// Fewest election ticks produced, the library's default
+const minElectionTick = 10
+
+type Node struct {
+	// Zero unless the source assigns IDs, as repmgr does
+	ID uint64
+
+	Name    string
+	Host    string
+	PGPort  int
+	DataDir string
+	BinDir  string
+
+	// Address the old tool's REST API listened on, reused for ramd's
+	APIListen string
+
+	NoFailover bool
+	NoSync     bool
+}
+
+// Cluster-wide settings
+type Settings struct {
+	// Time after which the primary is given up on, between health
+	// checks, and allowed for one health check
+	FailoverTimeout    time.Duration
+	MonitorInterval    time.Duration
+	HealthCheckTimeout time.Duration
+
+	AutoFailover bool
+	Synchronous  bool
+	SyncStandbys int
+	SyncStrict   bool
+
+	Superuser string
+	LogLevel  string
+}
+
+type Cluster struct {
+	Format string
+	Name   string
+	Nodes  []Node
+	Settings
+
+	// What could not be converted, or needs doing by hand
+	Notes []string
+}
+
+// Host and port of an address; Patroni's listen addresses may list
+// several hosts before the port, of which the first is taken
+func splitAddress(address string, defaultPort int) (string, int, error) {
+	if address == "" {
+		return "", 0, errors.New("not set")
+	}
+	host, portText, err := net.SplitHostPort(address)
+	if err != nil {
+		host, portText = address, strconv.Itoa(defaultPort)
+	}
+	host, _, _ = strings.Cut(host, ",")
+	port, err := strconv.Atoi(portText)
+	if err != nil || port <= 0 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid port in %q", address)
+	}
+	return strings.TrimSpace(host), port, nil
+}
+
+func (c *Cluster) note(format string, args ...interface{}) {
+	c.Notes = append(c.Notes, fmt.Sprintf(format, args...))
+}
+
+// Format of a configuration file: repmgr.conf if it sets node_id and
+// conninfo, else Patroni
+func Detect(data []byte) string {
+	if values, err := parseRepmgrLines(data); err == nil && values["node_id"] != "" && values["conninfo"] != "" {
+		return FormatRepmgr
+	}
+	return FormatPatroni
+}
+
+// Parse the configuration file of one member; format is FormatPatroni,
+// FormatRepmgr or empty to detect it
+func Parse(data []byte, format string) (*Cluster, error) {
+	if format == "" {
+		format = Detect(data)
+	}
+	switch format {
+	case FormatPatroni:
+		return ParsePatroni(data)
+	case FormatRepmgr:
+		return ParseRepmgr(data)
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// Combine the clusters parsed from the files of each member
+func Merge(parts []*Cluster) (*Cluster, error) {
+	if len(parts) == 0 {
+		return nil, errors.New("no configuration files")
+	}
+	merged := &Cluster{Format: parts[0].Format, Settings: parts[0].Settings}
+	names := make(map[string]bool)
+	noted := make(map[string]bool)
+	for _, part := range parts {
+		if part.Format != merged.Format {
+			return nil, fmt.Errorf("both %s and %s configuration given", merged.Format, part.Format)
+		}
+		switch {
+		case merged.Name == "":
+			merged.Name = part.Name
+		case part.Name != "" && part.Name != merged.Name:
+			return nil, fmt.Errorf("files belong to different clusters, %q and %q", merged.Name, part.Name)
+		}
+		if part.Settings != merged.Settings {
+			merged.note("%s is configured differently from %s; the settings of %s are used",
+				part.Nodes[0].Name, parts[0].Nodes[0].Name, parts[0].Nodes[0].Name)
+		}
+		for _, node := range part.Nodes {
+			if names[node.Name] {
+				return nil, fmt.Errorf("member %s is given twice", node.Name)
+			}
+			names[node.Name] = true
+			merged.Nodes = append(merged.Nodes, node)
+		}
+		for _, text := range part.Notes {
+			if !noted[text] {
+				noted[text] = true
+				merged.Notes = append(merged.Notes, text)
+			}
+		}
+	}
+	if len(merged.Nodes) == 1 {
+		merged.note("only the configuration of %s was given; pass the file of every member to get the full peer list", merged.Nodes[0].Name)
+	}
+	if merged.Synchronous {
+		merged.note("pgraft derives synchronous_standby_names from the raft quorum (ANY %d of the standbys), in place of %d synchronous standbys",
+			len(merged.Nodes)/2, merged.SyncStandbys)
+	}
+	return merged, nil
+}
+
+// Raft timing equivalent to the failover timeout
+func (c *Cluster) Tunables() *bootstrap.Tunables {
+	election := int(c.FailoverTimeout / tickInterval)
+	if election < minElectionTick {
+		election = minElectionTick
+	}
+	heartbeat := election / 10
+	if heartbeat < 1 {
+		heartbeat = 1
+	}
+	return &bootstrap.Tunables{
+		TickIntervalMs: int(tickInterval / time.Millisecond),
+		ElectionTick:   election,
+		HeartbeatTick:  heartbeat,
+	}
+}
+
+// Bootstrap plan of the cluster with raft on raftPort; members sharing a
+// host get consecutive ports in order of their PostgreSQL port.  name
+// overrides the cluster name of the files
+func (c *Cluster) Plan(name string, raftPort int) (*bootstrap.Plan, error) {
+	if name == "" {
+		name = c.Name
+	}
+	if name == "" {
+		return nil, errors.New("the files name no cluster; give its name")
+	}
+
+	nodes := append([]Node(nil), c.Nodes...)
+	sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].PGPort < nodes[j].PGPort })
+	perHost := make(map[string]int)
+	var members []bootstrap.Member
+	for _, node := range nodes {
+		port := raftPort + perHost[node.Host]
+		perHost[node.Host]++
+		members = append(members, bootstrap.Member{ID: node.ID, Name: node.Name, Host: node.Host, Port: port})
+	}
+	plan, err := bootstrap.NewPlan(name, members)
+	if err != nil {
+		return nil, err
+	}
+	plan.Tunables = c.Tunables()
+	return plan, nil
+}
+
+func (c *Cluster) node(name string) Node {
+	for _, node := range c.Nodes {
+		if node.Name == name {
+			return node
+		}
+	}
+	return Node{}
+}
+
+// ramd log level for a Patroni or repmgr one
+func ramdLogLevel(level string) string {
+	switch level {
+	case "debug", "info", "notice", "warning", "error", "alert":
+		return level
+	case "warn":
+		return "warning"
+	case "crit", "critical", "fatal":
+		return "critical"
+	case "emerg":
+		return "emergency"
+	}
+	return "info"
+}
+
+func milliseconds(d time.Duration) int64 {
+	return int64(d / time.Millisecond)
+}
+
+// ramd.conf of member m of plan
+func (c *Cluster) RamdConf(plan *bootstrap.Plan, m bootstrap.Member) string {
+	node := c.node(m.Name)
+	var b strings.Builder
+	set := func(key string, value interface{}) {
+		fmt.Fprintf(&b, "%s = %v\n", key, value)
+	}
+	fmt.Fprintf(&b, "# ramd settings of %s (node %d of cluster %s), converted from its %s configuration\n",
+		m.Name, m.ID, plan.ClusterName, c.Format)
+	set("node_id", m.ID)
+	set("hostname", node.Host)
+	set("cluster_name", plan.ClusterName)
+	set("cluster_size", len(plan.Members))
+	set("rale_port", m.Port)
+	set("postgresql_port", node.PGPort)
+	if node.DataDir != "" {
+		set("postgresql_data_dir", node.DataDir)
+	}
+	if node.BinDir != "" {
+		set("postgresql_bin_dir", node.BinDir)
+	}
+	if c.Superuser != "" {
+		set("postgresql_user", c.Superuser)
+		set("database_user", c.Superuser)
+	}
+	set("auto_failover_enabled", c.AutoFailover)
+	set("failover_timeout_ms", milliseconds(c.FailoverTimeout))
+	set("monitor_interval_ms", milliseconds(c.MonitorInterval))
+	set("health_check_timeout_ms", milliseconds(c.HealthCheckTimeout))
+	set("synchronous_replication", c.Synchronous)
+	if c.Synchronous {
+		set("num_sync_standbys", c.SyncStandbys)
+		set("enforce_sync_standbys", c.SyncStrict)
+	}
+	if node.APIListen != "" {
+		if host, port, err := splitAddress(node.APIListen, 8008); err == nil {
+			set("http_bind_address", host)
+			set("http_port", port)
+		}
+	}
+	set("log_level", ramdLogLevel(c.LogLevel))
+	return b.String()
+}
+
+// Write the plan as bootstrap does, with each member's ramd.conf next to
+// its pgraft files and the notes in NOTES.txt
+func (c *Cluster) Write(dir string, plan *bootstrap.Plan, force bool) error {
+	if err := plan.Write(dir, bootstrap.WriteOptions{Force: force}); err != nil {
+		return err
+	}
+	for _, m := range plan.Members {
+		path := filepath.Join(dir, "nodes", m.Name, "ramd.conf")
+		if err := os.WriteFile(path, []byte(c.RamdConf(plan, m)), 0644); err != nil {
+			return err
+		}
+	}
+	var notes strings.Builder
+	fmt.Fprintf(&notes, "Migration of %s cluster %s\n\n", c.Format, plan.ClusterName)
+	if len(c.Notes) == 0 {
+		notes.WriteString("Every setting was converted.\n")
+	}
+	for _, text := range c.Notes {
+		fmt.Fprintf(&notes, "- %s\n", text)
+	}
+	return os.WriteFile(filepath.Join(dir, "NOTES.txt"), []byte(notes.String()), 0644)
+}
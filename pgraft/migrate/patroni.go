@@ -0,0 +1,169 @@
+/*
+ * patroni.go
+ * Reading Patroni configuration
+ *
+ * Each Patroni member has its own YAML file naming the member and its
+ * PostgreSQL address, and carrying the cluster-wide settings under
+ * bootstrap.dcs.  Those are only used when Patroni bootstraps the
+ * cluster; later changes made with patronictl edit-config live in the
+ * DCS and are not in the files, so they have to be passed on the command
+ * line instead.  Environment variables (PATRONI_*) are not read.
+ */
+
+package migrate
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Patroni's defaults for the bootstrap.dcs settings that are converted
+const (
+	patroniTTL          = 30 * time.Second
+	patroniLoopWait     = 10 * time.Second
+	patroniRetryTimeout = 10 * time.Second
+)
+
+type patroniAddress struct {
+	Listen         string `yaml:"listen"`
+	ConnectAddress string `yaml:"connect_address"`
+}
+
+type patroniUser struct {
+	Username string `yaml:"username"`
+}
+
+type patroniFile struct {
+	Scope   string         `yaml:"scope"`
+	Name    string         `yaml:"name"`
+	RestAPI patroniAddress `yaml:"restapi"`
+
+	Bootstrap struct {
+		DCS struct {
+			TTL                   *int      `yaml:"ttl"`
+			LoopWait              *int      `yaml:"loop_wait"`
+			RetryTimeout          *int      `yaml:"retry_timeout"`
+			SynchronousMode       yaml.Node `yaml:"synchronous_mode"`
+			SynchronousModeStrict bool      `yaml:"synchronous_mode_strict"`
+			SynchronousNodeCount  *int      `yaml:"synchronous_node_count"`
+			MaximumLagOnFailover  *int64    `yaml:"maximum_lag_on_failover"`
+		} `yaml:"dcs"`
+	} `yaml:"bootstrap"`
+
+	PostgreSQL struct {
+		patroniAddress `yaml:",inline"`
+		DataDir        string `yaml:"data_dir"`
+		BinDir         string `yaml:"bin_dir"`
+		Authentication struct {
+			Superuser   patroniUser `yaml:"superuser"`
+			Replication patroniUser `yaml:"replication"`
+		} `yaml:"authentication"`
+	} `yaml:"postgresql"`
+
+	Tags map[string]interface{} `yaml:"tags"`
+
+	Log struct {
+		Level string `yaml:"level"`
+	} `yaml:"log"`
+}
+
+// Whether a tag is set to a true value, as Patroni reads it
+func patroniTag(tags map[string]interface{}, name string) bool {
+	switch value := tags[name].(type) {
+	case bool:
+		return value
+	case string:
+		return strings.EqualFold(value, "true") || strings.EqualFold(value, "on") || strings.EqualFold(value, "yes")
+	}
+	return false
+}
+
+// Parse the configuration file of one Patroni member
+func ParsePatroni(data []byte) (*Cluster, error) {
+	var file patroniFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("not a Patroni configuration: %v", err)
+	}
+	if file.Name == "" {
+		return nil, fmt.Errorf("not a Patroni configuration: no member name")
+	}
+
+	address := file.PostgreSQL.ConnectAddress
+	if address == "" {
+		address = file.PostgreSQL.Listen
+	}
+	host, port, err := splitAddress(address, 5432)
+	if err != nil {
+		return nil, fmt.Errorf("member %s: postgresql.connect_address: %v", file.Name, err)
+	}
+	if host == "" || host == "*" || host == "0.0.0.0" || host == "::" {
+		return nil, fmt.Errorf("member %s: postgresql.connect_address must name the member's host", file.Name)
+	}
+	node := Node{
+		Name:       file.Name,
+		Host:       host,
+		PGPort:     port,
+		DataDir:    file.PostgreSQL.DataDir,
+		BinDir:     file.PostgreSQL.BinDir,
+		APIListen:  file.RestAPI.Listen,
+		NoFailover: patroniTag(file.Tags, "nofailover"),
+		NoSync:     patroniTag(file.Tags, "nosync"),
+	}
+
+	c := &Cluster{
+		Format: FormatPatroni,
+		Name:   file.Scope,
+		Nodes:  []Node{node},
+		Settings: Settings{
+			FailoverTimeout:    patroniTTL,
+			MonitorInterval:    patroniLoopWait,
+			HealthCheckTimeout: patroniRetryTimeout,
+			AutoFailover:       true,
+			SyncStandbys:       1,
+			Superuser:          file.PostgreSQL.Authentication.Superuser.Username,
+			LogLevel:           strings.ToLower(file.Log.Level),
+		},
+	}
+	dcs := file.Bootstrap.DCS
+	if dcs.TTL != nil {
+		c.FailoverTimeout = time.Duration(*dcs.TTL) * time.Second
+	}
+	if dcs.LoopWait != nil {
+		c.MonitorInterval = time.Duration(*dcs.LoopWait) * time.Second
+	}
+	if dcs.RetryTimeout != nil {
+		c.HealthCheckTimeout = time.Duration(*dcs.RetryTimeout) * time.Second
+	}
+	if dcs.SynchronousNodeCount != nil {
+		c.SyncStandbys = *dcs.SynchronousNodeCount
+	}
+	c.SyncStrict = dcs.SynchronousModeStrict
+
+	// true, false or, since Patroni 4, "quorum"
+	switch mode := strings.ToLower(dcs.SynchronousMode.Value); mode {
+	case "", "false", "off", "no":
+	case "true", "on", "yes", "quorum":
+		c.Synchronous = true
+	default:
+		return nil, fmt.Errorf("member %s: unknown synchronous_mode %q", file.Name, dcs.SynchronousMode.Value)
+	}
+
+	if file.PostgreSQL.Authentication.Replication.Username != "" {
+		c.note("replication user %q: ramd takes no replication user; create it and its pg_hba.conf entry as before",
+			file.PostgreSQL.Authentication.Replication.Username)
+	}
+	if dcs.MaximumLagOnFailover != nil {
+		c.note("maximum_lag_on_failover (%d bytes) has no equivalent: pgraft only promotes members raft elects, which hold every committed entry",
+			*dcs.MaximumLagOnFailover)
+	}
+	if node.NoFailover {
+		c.note("%s is tagged nofailover, which has no equivalent: pgraft promotes whichever voter raft elects", node.Name)
+	}
+	if node.NoSync {
+		c.note("%s is tagged nosync: once the cluster runs, tag its node nosync with pgraft_go_set_node_tag()", node.Name)
+	}
+	return c, nil
+}
@@ -0,0 +1,208 @@
+/*
+ * repmgr.go
+ * Reading repmgr configuration
+ *
+ * Each repmgr node has its own repmgr.conf: key = value lines, values
+ * optionally quoted, with the node's ID and name and a libpq conninfo
+ * for reaching its PostgreSQL.  repmgr has no cluster name and keeps no
+ * synchronous replication settings of its own; both come from the
+ * command line or PostgreSQL's configuration.
+ */
+
+package migrate
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// repmgr's defaults for the settings that are converted
+const (
+	repmgrReconnectAttempts = 6
+	repmgrReconnectInterval = 10 * time.Second
+	repmgrMonitorInterval   = 2 * time.Second
+)
+
+// Parse key = value lines, removing quotes around values
+func parseRepmgrLines(data []byte) (map[string]string, error) {
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, value, found := strings.Cut(text, "=")
+		if !found {
+			return nil, fmt.Errorf("line %d: expected key=value", line)
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '\'' || value[0] == '"') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		} else if i := strings.Index(value, " #"); i >= 0 {
+			value = strings.TrimSpace(value[:i])
+		}
+		values[key] = value
+	}
+	return values, scanner.Err()
+}
+
+// Keywords of a libpq connection string, in key=value form or as a
+// postgresql:// URI
+func parseConninfo(conninfo string) (map[string]string, error) {
+	values := make(map[string]string)
+	if strings.HasPrefix(conninfo, "postgresql://") || strings.HasPrefix(conninfo, "postgres://") {
+		u, err := url.Parse(conninfo)
+		if err != nil {
+			return nil, err
+		}
+		values["host"] = u.Hostname()
+		values["port"] = u.Port()
+		values["user"] = u.User.Username()
+		values["dbname"] = strings.TrimPrefix(u.Path, "/")
+		for key, list := range u.Query() {
+			values[key] = list[0]
+		}
+		return values, nil
+	}
+
+	rest := strings.TrimSpace(conninfo)
+	for rest != "" {
+		key, after, found := strings.Cut(rest, "=")
+		if !found {
+			return nil, fmt.Errorf("%q is not of the form key=value", rest)
+		}
+		key = strings.TrimSpace(key)
+		after = strings.TrimLeft(after, " ")
+		var value strings.Builder
+		i := 0
+		if strings.HasPrefix(after, "'") {
+			for i = 1; i < len(after) && after[i] != '\''; i++ {
+				if after[i] == '\\' && i+1 < len(after) {
+					i++
+				}
+				value.WriteByte(after[i])
+			}
+			if i == len(after) {
+				return nil, fmt.Errorf("unterminated quote in value of %s", key)
+			}
+			i++
+		} else {
+			for ; i < len(after) && after[i] != ' '; i++ {
+				value.WriteByte(after[i])
+			}
+		}
+		values[key] = value.String()
+		rest = strings.TrimSpace(after[i:])
+	}
+	return values, nil
+}
+
+func repmgrDuration(values map[string]string, key string, unit, fallback time.Duration) (time.Duration, error) {
+	text, set := values[key]
+	if !set || text == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(text)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s: invalid value %q", key, text)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// Parse the repmgr.conf of one node
+func ParseRepmgr(data []byte) (*Cluster, error) {
+	values, err := parseRepmgrLines(data)
+	if err != nil {
+		return nil, fmt.Errorf("not a repmgr configuration: %v", err)
+	}
+	if values["node_id"] == "" || values["conninfo"] == "" {
+		return nil, fmt.Errorf("not a repmgr configuration: node_id and conninfo must be set")
+	}
+	id, err := strconv.ParseUint(values["node_id"], 10, 64)
+	if err != nil || id == 0 {
+		return nil, fmt.Errorf("node_id: invalid value %q", values["node_id"])
+	}
+	name := values["node_name"]
+	if name == "" {
+		name = fmt.Sprintf("node%d", id)
+	}
+
+	conninfo, err := parseConninfo(values["conninfo"])
+	if err != nil {
+		return nil, fmt.Errorf("node %s: conninfo: %v", name, err)
+	}
+	port := 5432
+	if text := conninfo["port"]; text != "" {
+		if port, err = strconv.Atoi(text); err != nil || port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("node %s: conninfo: invalid port %q", name, text)
+		}
+	}
+	if conninfo["host"] == "" || strings.HasPrefix(conninfo["host"], "/") {
+		return nil, fmt.Errorf("node %s: conninfo names no network host", name)
+	}
+
+	attempts := repmgrReconnectAttempts
+	if text := values["reconnect_attempts"]; text != "" {
+		if attempts, err = strconv.Atoi(text); err != nil || attempts < 0 {
+			return nil, fmt.Errorf("reconnect_attempts: invalid value %q", text)
+		}
+	}
+	interval, err := repmgrDuration(values, "reconnect_interval", time.Second, repmgrReconnectInterval)
+	if err != nil {
+		return nil, err
+	}
+	monitor, err := repmgrDuration(values, "monitor_interval_secs", time.Second, repmgrMonitorInterval)
+	if err != nil {
+		return nil, err
+	}
+	priority := 100
+	if text := values["priority"]; text != "" {
+		if priority, err = strconv.Atoi(text); err != nil {
+			return nil, fmt.Errorf("priority: invalid value %q", text)
+		}
+	}
+
+	node := Node{
+		ID:         id,
+		Name:       name,
+		Host:       conninfo["host"],
+		PGPort:     port,
+		DataDir:    values["data_directory"],
+		BinDir:     values["pg_bindir"],
+		NoFailover: priority == 0,
+	}
+	c := &Cluster{
+		Format: FormatRepmgr,
+		Nodes:  []Node{node},
+		Settings: Settings{
+			// The primary is given up on once every reconnection failed
+			FailoverTimeout:    time.Duration(attempts) * interval,
+			MonitorInterval:    monitor,
+			HealthCheckTimeout: interval,
+			AutoFailover:       values["failover"] != "manual",
+			SyncStandbys:       1,
+			Superuser:          conninfo["user"],
+			LogLevel:           strings.ToLower(values["log_level"]),
+		},
+	}
+	if node.NoFailover {
+		c.note("%s has priority 0, which has no equivalent: pgraft promotes whichever voter raft elects", name)
+	}
+	if values["location"] != "" && values["location"] != "default" {
+		c.note("%s is in location %q: once the cluster runs, tag its node location=%s with pgraft_go_set_node_tag()",
+			name, values["location"], values["location"])
+	}
+	for _, key := range []string{"promote_command", "follow_command", "event_notification_command"} {
+		if values[key] != "" {
+			c.note("%s is not carried over; ramd promotes and follows by itself", key)
+		}
+	}
+	return c, nil
+}
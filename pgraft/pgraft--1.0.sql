@@ -34,6 +34,12 @@ RETURNS boolean
 LANGUAGE C
 AS 'pgraft', 'pgraft_remove_node';
 
+-- Hand raft leadership to a node; the background worker runs the switchover
+CREATE OR REPLACE FUNCTION pgraft_switchover(target_node integer)
+RETURNS boolean
+LANGUAGE C
+AS 'pgraft', 'pgraft_switchover';
+
 -- Get cluster status as table with individual columns
 CREATE OR REPLACE FUNCTION pgraft_get_cluster_status()
 RETURNS TABLE(
@@ -0,0 +1,108 @@
+/*
+ * memstorage.go
+ * In-memory storage that fails on demand
+ *
+ * MemStorage is an etcd raft MemoryStorage, which is also what the
+ * library uses, that counts the writes made to it and can be told to
+ * fail the next calls of a write with a given error, to test how callers
+ * handle a disk that fills up or goes away.
+ */
+
+package raftio
+
+import (
+	"sync"
+
+	"go.etcd.io/raft/v3"
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+// Write operation of a Storage
+type Op string
+
+const (
+	OpAppend         Op = "append"
+	OpSetHardState   Op = "set-hard-state"
+	OpApplySnapshot  Op = "apply-snapshot"
+	OpCreateSnapshot Op = "create-snapshot"
+	OpCompact        Op = "compact"
+)
+
+type MemStorage struct {
+	*raft.MemoryStorage
+
+	mu       sync.Mutex
+	failures map[Op][]error
+	calls    map[Op]int
+}
+
+func NewMemStorage() *MemStorage {
+	return &MemStorage{
+		MemoryStorage: raft.NewMemoryStorage(),
+		failures:      make(map[Op][]error),
+		calls:         make(map[Op]int),
+	}
+}
+
+// Fail the next call of op with err; calls fail in the order queued and
+// leave the storage unchanged
+func (s *MemStorage) FailNext(op Op, err error) {
+	s.mu.Lock()
+	s.failures[op] = append(s.failures[op], err)
+	s.mu.Unlock()
+}
+
+// Calls of op so far, failed ones included
+func (s *MemStorage) Calls(op Op) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[op]
+}
+
+// Count a call of op and return the failure queued for it, if any
+func (s *MemStorage) begin(op Op) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls[op]++
+	queued := s.failures[op]
+	if len(queued) == 0 {
+		return nil
+	}
+	s.failures[op] = queued[1:]
+	return queued[0]
+}
+
+func (s *MemStorage) Append(entries []raftpb.Entry) error {
+	if err := s.begin(OpAppend); err != nil {
+		return err
+	}
+	return s.MemoryStorage.Append(entries)
+}
+
+func (s *MemStorage) SetHardState(st raftpb.HardState) error {
+	if err := s.begin(OpSetHardState); err != nil {
+		return err
+	}
+	return s.MemoryStorage.SetHardState(st)
+}
+
+func (s *MemStorage) ApplySnapshot(snap raftpb.Snapshot) error {
+	if err := s.begin(OpApplySnapshot); err != nil {
+		return err
+	}
+	return s.MemoryStorage.ApplySnapshot(snap)
+}
+
+func (s *MemStorage) CreateSnapshot(index uint64, cs *raftpb.ConfState, data []byte) (raftpb.Snapshot, error) {
+	if err := s.begin(OpCreateSnapshot); err != nil {
+		return raftpb.Snapshot{}, err
+	}
+	return s.MemoryStorage.CreateSnapshot(index, cs, data)
+}
+
+func (s *MemStorage) Compact(compactIndex uint64) error {
+	if err := s.begin(OpCompact); err != nil {
+		return err
+	}
+	return s.MemoryStorage.Compact(compactIndex)
+}
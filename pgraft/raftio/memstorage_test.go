@@ -0,0 +1,91 @@
+package raftio
+
+import (
+	"errors"
+	"testing"
+
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+func TestMemStorageFailNext(t *testing.T) {
+	s := NewMemStorage()
+	full := errors.New("disk full")
+	s.FailNext(OpAppend, full)
+
+	entries := []raftpb.Entry{{Index: 1, Term: 1, Data: []byte("a")}}
+	if err := s.Append(entries); !errors.Is(err, full) {
+		t.Fatalf("first append returned %v, expected %v", err, full)
+	}
+	if last, _ := s.LastIndex(); last != 0 {
+		t.Fatalf("failed append stored entries through %d", last)
+	}
+	if err := s.Append(entries); err != nil {
+		t.Fatalf("second append: %v", err)
+	}
+	if last, _ := s.LastIndex(); last != 1 {
+		t.Fatalf("last index %d after append, expected 1", last)
+	}
+	if calls := s.Calls(OpAppend); calls != 2 {
+		t.Errorf("%d appends counted, expected 2", calls)
+	}
+}
+
+func TestMemStorageFailuresInOrder(t *testing.T) {
+	s := NewMemStorage()
+	first, second := errors.New("first"), errors.New("second")
+	s.FailNext(OpSetHardState, first)
+	s.FailNext(OpSetHardState, second)
+
+	hs := raftpb.HardState{Term: 1, Vote: 1}
+	for _, expected := range []error{first, second, nil} {
+		if err := s.SetHardState(hs); !errors.Is(err, expected) {
+			t.Fatalf("SetHardState returned %v, expected %v", err, expected)
+		}
+	}
+	if stored, _, _ := s.InitialState(); stored != hs {
+		t.Errorf("hard state %v, expected %v", stored, hs)
+	}
+	// Failures are queued per operation
+	s.FailNext(OpCompact, first)
+	if err := s.Append([]raftpb.Entry{{Index: 1, Term: 1}}); err != nil {
+		t.Errorf("append failed with a failure queued for another operation: %v", err)
+	}
+}
+
+func TestMemStorageSnapshots(t *testing.T) {
+	s := NewMemStorage()
+	if err := s.Append([]raftpb.Entry{{Index: 1, Term: 1}, {Index: 2, Term: 1}, {Index: 3, Term: 2}}); err != nil {
+		t.Fatal(err)
+	}
+	failed := errors.New("failed")
+	s.FailNext(OpCreateSnapshot, failed)
+	cs := &raftpb.ConfState{Voters: []uint64{1, 2, 3}}
+	if _, err := s.CreateSnapshot(2, cs, []byte("state")); !errors.Is(err, failed) {
+		t.Fatalf("CreateSnapshot returned %v, expected %v", err, failed)
+	}
+	snap, err := s.CreateSnapshot(2, cs, []byte("state"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.Metadata.Index != 2 || string(snap.Data) != "state" {
+		t.Fatalf("snapshot at %d with %q", snap.Metadata.Index, snap.Data)
+	}
+	if err := s.Compact(2); err != nil {
+		t.Fatal(err)
+	}
+	if first, _ := s.FirstIndex(); first != 3 {
+		t.Errorf("first index %d after compacting through 2, expected 3", first)
+	}
+
+	other := NewMemStorage()
+	other.FailNext(OpApplySnapshot, failed)
+	if err := other.ApplySnapshot(snap); !errors.Is(err, failed) {
+		t.Fatalf("ApplySnapshot returned %v, expected %v", err, failed)
+	}
+	if err := other.ApplySnapshot(snap); err != nil {
+		t.Fatal(err)
+	}
+	if last, _ := other.LastIndex(); last != 2 {
+		t.Errorf("last index %d after installing the snapshot, expected 2", last)
+	}
+}
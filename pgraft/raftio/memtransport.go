@@ -0,0 +1,203 @@
+/*
+ * memtransport.go
+ * In-memory transports connected by a Network
+ *
+ * Messages sent through a MemTransport are queued on its Network and
+ * reach the recipient's Handler when the test calls Deliver, so a test
+ * decides exactly when messages arrive and nothing runs in the
+ * background.  Each message is copied on the way, as it would be by
+ * encoding it for a connection, and is recorded for assertions.  Links
+ * can be cut one way or both, and a filter can drop or inspect messages
+ * as they are delivered.
+ */
+
+package raftio
+
+import (
+	"errors"
+	"sync"
+
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+var (
+	ErrUnreachable = errors.New("peer unreachable")
+	ErrClosed      = errors.New("transport closed")
+)
+
+// Upper bound on delivery rounds in one Deliver, against handlers that
+// answer every message forever
+const maxDeliverRounds = 1000
+
+type link struct {
+	from, to uint64
+}
+
+type Network struct {
+	mu        sync.Mutex
+	endpoints map[uint64]*MemTransport
+	cut       map[link]bool
+	filter    func(raftpb.Message) bool
+	queue     []raftpb.Message
+	sent      []raftpb.Message
+}
+
+func NewNetwork() *Network {
+	return &Network{
+		endpoints: make(map[uint64]*MemTransport),
+		cut:       make(map[link]bool),
+	}
+}
+
+// Transport of node id, whose received messages go to handler; joining
+// again replaces the node's earlier transport, as a restart would
+func (n *Network) Join(id uint64, handler Handler) *MemTransport {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if old, exists := n.endpoints[id]; exists {
+		old.closed = true
+	}
+	t := &MemTransport{id: id, network: n, handler: handler}
+	n.endpoints[id] = t
+	return t
+}
+
+// Stop messages from one node to another; the other direction is kept
+func (n *Network) Cut(from, to uint64) {
+	n.mu.Lock()
+	n.cut[link{from, to}] = true
+	n.mu.Unlock()
+}
+
+// Cut every link to and from a node
+func (n *Network) Isolate(id uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for other := range n.endpoints {
+		if other != id {
+			n.cut[link{id, other}] = true
+			n.cut[link{other, id}] = true
+		}
+	}
+}
+
+// Restore every link
+func (n *Network) Heal() {
+	n.mu.Lock()
+	n.cut = make(map[link]bool)
+	n.mu.Unlock()
+}
+
+// Call filter on every message about to be delivered; those it returns
+// false for are dropped.  nil removes the filter
+func (n *Network) SetFilter(filter func(raftpb.Message) bool) {
+	n.mu.Lock()
+	n.filter = filter
+	n.mu.Unlock()
+}
+
+// Messages sent and not yet delivered
+func (n *Network) Pending() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.queue)
+}
+
+// Every message accepted by a Send so far, in order
+func (n *Network) Sent() []raftpb.Message {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]raftpb.Message(nil), n.sent...)
+}
+
+// Deliver queued messages, and those their handlers send in turn, until
+// none are left; returns the number delivered
+func (n *Network) Deliver() int {
+	delivered := 0
+	for round := 0; round < maxDeliverRounds; round++ {
+		n.mu.Lock()
+		batch := n.queue
+		n.queue = nil
+		filter := n.filter
+		n.mu.Unlock()
+		if len(batch) == 0 {
+			break
+		}
+
+		for _, msg := range batch {
+			if filter != nil && !filter(msg) {
+				continue
+			}
+			// A link cut or an endpoint closed after the send loses the
+			// messages in flight on it
+			n.mu.Lock()
+			target := n.endpoints[msg.To]
+			reachable := n.reachable(msg.From, msg.To)
+			n.mu.Unlock()
+			if !reachable {
+				continue
+			}
+			// Handlers run without the lock so they can send
+			target.handler(msg)
+			delivered++
+		}
+	}
+	return delivered
+}
+
+// Whether from can send to to; called with the lock held
+func (n *Network) reachable(from, to uint64) bool {
+	target, exists := n.endpoints[to]
+	return exists && !target.closed && !n.cut[link{from, to}]
+}
+
+// Transport of one node on a Network
+type MemTransport struct {
+	id      uint64
+	network *Network
+	handler Handler
+	closed  bool
+}
+
+func (t *MemTransport) Send(msg raftpb.Message) error {
+	n := t.network
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if t.closed {
+		return ErrClosed
+	}
+	if !n.reachable(t.id, msg.To) {
+		return ErrUnreachable
+	}
+	// Copied through the encoding, so sender and receiver share nothing
+	data, err := msg.Marshal()
+	if err != nil {
+		return err
+	}
+	var copied raftpb.Message
+	if err := copied.Unmarshal(data); err != nil {
+		return err
+	}
+	if copied.From == 0 {
+		copied.From = t.id
+	}
+	n.queue = append(n.queue, copied)
+	n.sent = append(n.sent, copied)
+	return nil
+}
+
+func (t *MemTransport) Connected(nodeID uint64) bool {
+	n := t.network
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return !t.closed && n.reachable(t.id, nodeID)
+}
+
+// Close the transport; messages still queued to it are lost
+func (t *MemTransport) Close() error {
+	n := t.network
+	n.mu.Lock()
+	t.closed = true
+	n.mu.Unlock()
+	return nil
+}
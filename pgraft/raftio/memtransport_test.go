@@ -0,0 +1,136 @@
+package raftio
+
+import (
+	"errors"
+	"testing"
+
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+// Network of nodes 1..n whose handlers record what they receive
+func newTestNetwork(n int) (*Network, []*MemTransport, map[uint64][]raftpb.Message) {
+	network := NewNetwork()
+	received := make(map[uint64][]raftpb.Message)
+	transports := make([]*MemTransport, n)
+	for i := range transports {
+		id := uint64(i + 1)
+		transports[i] = network.Join(id, func(msg raftpb.Message) {
+			received[id] = append(received[id], msg)
+		})
+	}
+	return network, transports, received
+}
+
+func TestMemTransportDelivers(t *testing.T) {
+	network, transports, received := newTestNetwork(2)
+	msg := raftpb.Message{Type: raftpb.MsgApp, To: 2, Term: 3, Entries: []raftpb.Entry{{Index: 1, Data: []byte("a")}}}
+	if err := transports[0].Send(msg); err != nil {
+		t.Fatal(err)
+	}
+	if len(received[2]) != 0 {
+		t.Fatal("message delivered before Deliver")
+	}
+	if network.Pending() != 1 {
+		t.Fatalf("%d messages pending, expected 1", network.Pending())
+	}
+
+	// The sender's message is copied, not shared
+	msg.Entries[0].Data[0] = 'b'
+	if delivered := network.Deliver(); delivered != 1 {
+		t.Fatalf("%d messages delivered, expected 1", delivered)
+	}
+	got := received[2]
+	if len(got) != 1 || got[0].From != 1 || got[0].Term != 3 || string(got[0].Entries[0].Data) != "a" {
+		t.Fatalf("received %+v", got)
+	}
+	if sent := network.Sent(); len(sent) != 1 {
+		t.Errorf("%d messages recorded as sent, expected 1", len(sent))
+	}
+}
+
+func TestMemTransportDeliversReplies(t *testing.T) {
+	network := NewNetwork()
+	var replies int
+	var second *MemTransport
+	first := network.Join(1, func(msg raftpb.Message) { replies++ })
+	second = network.Join(2, func(msg raftpb.Message) {
+		second.Send(raftpb.Message{Type: raftpb.MsgAppResp, To: msg.From})
+	})
+	if err := first.Send(raftpb.Message{Type: raftpb.MsgApp, To: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if delivered := network.Deliver(); delivered != 2 || replies != 1 {
+		t.Errorf("%d messages delivered and %d replies received, expected 2 and 1", delivered, replies)
+	}
+}
+
+func TestMemTransportCut(t *testing.T) {
+	network, transports, received := newTestNetwork(3)
+	network.Cut(1, 2)
+	if err := transports[0].Send(raftpb.Message{To: 2}); !errors.Is(err, ErrUnreachable) {
+		t.Fatalf("send over a cut link returned %v", err)
+	}
+	if transports[0].Connected(2) || !transports[1].Connected(1) {
+		t.Fatal("a cut must only stop one direction")
+	}
+
+	// A link cut while a message is in flight loses it
+	if err := transports[0].Send(raftpb.Message{To: 3}); err != nil {
+		t.Fatal(err)
+	}
+	network.Isolate(3)
+	network.Deliver()
+	if len(received[3]) != 0 {
+		t.Fatal("message delivered to an isolated node")
+	}
+
+	network.Heal()
+	if err := transports[0].Send(raftpb.Message{To: 2}); err != nil {
+		t.Fatalf("send after heal: %v", err)
+	}
+	network.Deliver()
+	if len(received[2]) != 1 {
+		t.Errorf("%d messages received after heal, expected 1", len(received[2]))
+	}
+}
+
+func TestMemTransportFilter(t *testing.T) {
+	network, transports, received := newTestNetwork(2)
+	network.SetFilter(func(msg raftpb.Message) bool { return msg.Type != raftpb.MsgHeartbeat })
+	transports[0].Send(raftpb.Message{Type: raftpb.MsgHeartbeat, To: 2})
+	transports[0].Send(raftpb.Message{Type: raftpb.MsgApp, To: 2})
+	network.Deliver()
+	if len(received[2]) != 1 || received[2][0].Type != raftpb.MsgApp {
+		t.Fatalf("received %+v, expected the MsgApp only", received[2])
+	}
+
+	network.SetFilter(nil)
+	transports[0].Send(raftpb.Message{Type: raftpb.MsgHeartbeat, To: 2})
+	network.Deliver()
+	if len(received[2]) != 2 {
+		t.Errorf("heartbeat dropped after the filter was removed")
+	}
+}
+
+func TestMemTransportClose(t *testing.T) {
+	network, transports, received := newTestNetwork(2)
+	transports[0].Send(raftpb.Message{To: 2})
+	transports[1].Close()
+	network.Deliver()
+	if len(received[2]) != 0 {
+		t.Fatal("message delivered to a closed transport")
+	}
+	if err := transports[1].Send(raftpb.Message{To: 1}); !errors.Is(err, ErrClosed) {
+		t.Fatalf("send on a closed transport returned %v", err)
+	}
+
+	// Joining again, as a restarted node does, replaces the old transport
+	restarted := network.Join(2, func(msg raftpb.Message) { received[2] = append(received[2], msg) })
+	if err := transports[0].Send(raftpb.Message{To: 2}); err != nil {
+		t.Fatal(err)
+	}
+	network.Deliver()
+	if len(received[2]) != 1 || !restarted.Connected(1) {
+		t.Errorf("restarted node received %d messages", len(received[2]))
+	}
+}
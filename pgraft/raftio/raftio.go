@@ -0,0 +1,51 @@
+/*
+ * raftio.go
+ * Transport and storage interfaces of a pgraft node
+ *
+ * The library reaches its peers only through a Transport and keeps its
+ * log only in a Storage (see pgraft_go_transport.go and
+ * pgraft_go_storage.go, which declare the same interfaces; the two must
+ * change together).  This package exports them with in-memory
+ * implementations, so code built on pgraft, such as the operator's
+ * integration with a cluster, can be unit-tested without sockets or
+ * disks: Network connects in-memory transports and can cut links
+ * between them, and MemStorage fails operations on demand.
+ */
+
+package raftio
+
+import (
+	"go.etcd.io/raft/v3"
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+type Transport interface {
+	// Send a message to msg.To; an error if it cannot be sent now.
+	// Delivery is not guaranteed, raft retransmits what is lost
+	Send(msg raftpb.Message) error
+
+	// Whether a connection to the node is established
+	Connected(nodeID uint64) bool
+
+	// Drop every connection
+	Close() error
+}
+
+// Receives the messages a Transport delivers to its node
+type Handler func(msg raftpb.Message)
+
+type Storage interface {
+	raft.Storage
+
+	Append(entries []raftpb.Entry) error
+	SetHardState(st raftpb.HardState) error
+	ApplySnapshot(snap raftpb.Snapshot) error
+	CreateSnapshot(index uint64, cs *raftpb.ConfState, data []byte) (raftpb.Snapshot, error)
+	Compact(compactIndex uint64) error
+}
+
+var (
+	_ Storage   = (*raft.MemoryStorage)(nil)
+	_ Storage   = (*MemStorage)(nil)
+	_ Transport = (*MemTransport)(nil)
+)
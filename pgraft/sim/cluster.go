@@ -0,0 +1,469 @@
+/*
+ * cluster.go
+ * In-process multi-node raft simulation
+ *
+ * A Cluster runs N etcd raft nodes in one process over an in-memory
+ * network, with the same raft settings pgraft uses, so consensus
+ * behaviour (elections, replication, membership changes, crashes,
+ * restarts and partitions) can be exercised without PostgreSQL or
+ * sockets.  Time only advances when the caller ticks the cluster, and the
+ * simulation's own randomness, which messages the network drops, comes
+ * from the configured seed.  Raft draws its randomized election timeouts
+ * from a source of its own that cannot be seeded, so a run with the same
+ * seed may elect differently: a failure that depends on election timing
+ * can take several runs with its seed to reproduce.
+ */
+
+package sim
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"slices"
+	"sort"
+
+	"go.etcd.io/raft/v3"
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+// Raft's own logging is too verbose for simulations
+var discardLogger = &raft.DefaultLogger{Logger: log.New(io.Discard, "", 0)}
+
+// Upper bound on delivery rounds within one tick, against livelock
+const maxRoundsPerTick = 1000
+
+// Config of a simulated cluster; zero values take pgraft's defaults
+type Config struct {
+	Nodes         int
+	ElectionTick  int
+	HeartbeatTick int
+	PreVote       bool
+	CheckQuorum   bool
+
+	// Fraction of messages the network drops
+	DropRate float64
+
+	// Send messages through the peer frame format instead of passing
+	// them as structs
+	Encode bool
+
+	Seed int64
+
+	// Called whenever a node applies a normal entry
+	OnApply func(node, index uint64, data []byte)
+
+	// Called whenever a node installs a snapshot in place of entries,
+	// with the index it is at and the data passed to Compact
+	OnSnapshot func(node, index uint64, data []byte)
+}
+
+func (cfg *Config) setDefaults() {
+	if cfg.Nodes == 0 {
+		cfg.Nodes = 3
+	}
+	if cfg.ElectionTick == 0 {
+		cfg.ElectionTick = 10
+	}
+	if cfg.HeartbeatTick == 0 {
+		cfg.HeartbeatTick = 1
+	}
+}
+
+type Cluster struct {
+	cfg   Config
+	nodes map[uint64]*node
+	net   *network
+	rand  *rand.Rand
+	ticks uint64
+}
+
+// Create and bootstrap a cluster of cfg.Nodes voters with IDs 1..N
+func New(cfg Config) (*Cluster, error) {
+	cfg.setDefaults()
+	rnd := rand.New(rand.NewSource(cfg.Seed))
+	c := &Cluster{
+		cfg:   cfg,
+		nodes: make(map[uint64]*node),
+		net:   newNetwork(cfg.DropRate, rnd, cfg.Encode),
+		rand:  rnd,
+	}
+
+	peers := make([]raft.Peer, cfg.Nodes)
+	for i := range peers {
+		peers[i] = raft.Peer{ID: uint64(i + 1)}
+	}
+	for _, peer := range peers {
+		n := &node{id: peer.ID, storage: raft.NewMemoryStorage()}
+		if err := c.startNode(n, peers); err != nil {
+			return nil, err
+		}
+		c.nodes[n.id] = n
+	}
+	return c, nil
+}
+
+// IDs of the nodes that are members, sorted
+func (c *Cluster) Nodes() []uint64 {
+	ids := make([]uint64, 0, len(c.nodes))
+	for id, n := range c.nodes {
+		if !n.removed {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Number of ticks since the cluster was created
+func (c *Cluster) Ticks() uint64 {
+	return c.ticks
+}
+
+// Messages delivered and dropped by the network so far
+func (c *Cluster) NetworkStats() (delivered, dropped uint64) {
+	return c.net.delivered, c.net.dropped
+}
+
+// Bytes of frames sent over the network so far; only counted with
+// Config.Encode
+func (c *Cluster) NetworkBytes() uint64 {
+	return c.net.bytes
+}
+
+// Random source of the simulation, for scenarios that need randomness
+func (c *Cluster) Rand() *rand.Rand {
+	return c.rand
+}
+
+// Process Ready batches and deliver messages until nothing is left
+func (c *Cluster) settle() error {
+	for round := 0; round < maxRoundsPerTick; round++ {
+		progress := false
+		for _, id := range c.Nodes() {
+			processed, err := c.processReady(c.nodes[id])
+			if err != nil {
+				return err
+			}
+			progress = progress || processed
+		}
+
+		msgs := c.net.take()
+		for _, msg := range msgs {
+			target, exists := c.nodes[msg.To]
+			if !exists || target.crashed || target.removed {
+				c.reportSnapshot(msg, raft.SnapshotFailure)
+				continue
+			}
+			// Errors only report messages from unknown or removed peers
+			_ = target.raw.Step(msg)
+			c.reportSnapshot(msg, raft.SnapshotFinish)
+		}
+		for _, msg := range c.net.droppedSnapshots {
+			c.reportSnapshot(msg, raft.SnapshotFailure)
+		}
+		c.net.droppedSnapshots = nil
+		if !progress && len(msgs) == 0 {
+			return nil
+		}
+	}
+	return errors.New("cluster did not settle")
+}
+
+// Tell the sender of a snapshot whether it arrived, as a transport does
+func (c *Cluster) reportSnapshot(msg raftpb.Message, status raft.SnapshotStatus) {
+	if msg.Type != raftpb.MsgSnap {
+		return
+	}
+	if sender, exists := c.nodes[msg.From]; exists && !sender.crashed && !sender.removed {
+		sender.raw.ReportSnapshot(msg.To, status)
+	}
+}
+
+// Advance logical time by one tick on every live node
+func (c *Cluster) Tick() error {
+	c.ticks++
+	for _, id := range c.Nodes() {
+		if n := c.nodes[id]; !n.crashed {
+			n.raw.Tick()
+		}
+	}
+	return c.settle()
+}
+
+// Tick until cond holds, at most maxTicks times
+func (c *Cluster) TickUntil(maxTicks int, cond func() bool) error {
+	for i := 0; i < maxTicks; i++ {
+		if cond() {
+			return nil
+		}
+		if err := c.Tick(); err != nil {
+			return err
+		}
+	}
+	if cond() {
+		return nil
+	}
+	return fmt.Errorf("condition not reached within %d ticks", maxTicks)
+}
+
+// Live leader with the highest term, or 0
+func (c *Cluster) Leader() uint64 {
+	var leader, term uint64
+	for _, id := range c.Nodes() {
+		n := c.nodes[id]
+		if n.crashed {
+			continue
+		}
+		status := n.raw.Status()
+		if status.RaftState == raft.StateLeader && status.Term >= term {
+			leader, term = id, status.Term
+		}
+	}
+	return leader
+}
+
+// Tick until a leader is elected
+func (c *Cluster) WaitLeader(maxTicks int) (uint64, error) {
+	err := c.TickUntil(maxTicks, func() bool { return c.Leader() != 0 })
+	if err != nil {
+		return 0, fmt.Errorf("no leader: %w", err)
+	}
+	return c.Leader(), nil
+}
+
+// Raft status of a node
+func (c *Cluster) Status(id uint64) (raft.Status, error) {
+	n, exists := c.nodes[id]
+	if !exists || n.crashed {
+		return raft.Status{}, fmt.Errorf("node %d is not running", id)
+	}
+	return n.raw.Status(), nil
+}
+
+// Propose data on node id without waiting for it to commit
+func (c *Cluster) Propose(id uint64, data []byte) error {
+	n, exists := c.nodes[id]
+	if !exists || n.crashed || n.removed {
+		return fmt.Errorf("node %d is not running", id)
+	}
+	if err := n.raw.Propose(data); err != nil {
+		return fmt.Errorf("node %d: %w", id, err)
+	}
+	return c.settle()
+}
+
+// Propose data on node id and leave it in flight; it is replicated and
+// applied as the cluster ticks, so several proposals can be outstanding
+// at once
+func (c *Cluster) Submit(id uint64, data []byte) error {
+	n, exists := c.nodes[id]
+	if !exists || n.crashed || n.removed {
+		return fmt.Errorf("node %d is not running", id)
+	}
+	if err := n.raw.Propose(data); err != nil {
+		return fmt.Errorf("node %d: %w", id, err)
+	}
+	return nil
+}
+
+// Propose data on the leader and tick until every live member it can
+// reach applied it
+func (c *Cluster) ProposeAndWait(data []byte, maxTicks int) error {
+	leader, err := c.WaitLeader(maxTicks)
+	if err != nil {
+		return err
+	}
+	if err := c.Propose(leader, data); err != nil {
+		return err
+	}
+	return c.TickUntil(maxTicks, func() bool { return c.allApplied(leader, data) })
+}
+
+// True if every live member reachable from leader has applied an entry
+// carrying data
+func (c *Cluster) allApplied(leader uint64, data []byte) bool {
+	for _, id := range c.Nodes() {
+		n := c.nodes[id]
+		if n.crashed || !c.net.connected(leader, id) {
+			continue
+		}
+		found := false
+		for i := len(n.applied) - 1; i >= 0; i-- {
+			if bytes.Equal(n.applied[i].Data, data) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Entries applied by a node, in order
+func (c *Cluster) Applied(id uint64) []Applied {
+	n, exists := c.nodes[id]
+	if !exists {
+		return nil
+	}
+	return append([]Applied(nil), n.applied...)
+}
+
+// Forget the entries every node applied through index, so that long
+// runs do not keep all of them; consistency is only checked on entries
+// applied after it
+func (c *Cluster) DiscardApplied(index uint64) {
+	for _, n := range c.nodes {
+		i := sort.Search(len(n.applied), func(i int) bool { return n.applied[i].Index > index })
+		n.applied = append([]Applied(nil), n.applied[i:]...)
+	}
+}
+
+// Index a node has applied through
+func (c *Cluster) AppliedIndex(id uint64) uint64 {
+	if n, exists := c.nodes[id]; exists {
+		return n.appliedIndex
+	}
+	return 0
+}
+
+// Propose a membership change on the leader and tick until the leader
+// applied it
+func (c *Cluster) changeMembership(cc raftpb.ConfChange, maxTicks int) error {
+	leader, err := c.WaitLeader(maxTicks)
+	if err != nil {
+		return err
+	}
+	before := c.nodes[leader].confState
+	if err := c.nodes[leader].raw.ProposeConfChange(cc); err != nil {
+		return fmt.Errorf("node %d: %w", leader, err)
+	}
+	if err := c.settle(); err != nil {
+		return err
+	}
+	return c.TickUntil(maxTicks, func() bool {
+		current := c.Leader()
+		return current != 0 && !confStateEqual(c.nodes[current].confState, before)
+	})
+}
+
+func confStateEqual(a, b raftpb.ConfState) bool {
+	return slices.Equal(a.Voters, b.Voters) && slices.Equal(a.Learners, b.Learners)
+}
+
+// Add a new voter with an empty log and wait until the leader applied the
+// change; the node catches up from the leader afterwards
+func (c *Cluster) AddNode(id uint64, maxTicks int) error {
+	if _, exists := c.nodes[id]; exists {
+		return fmt.Errorf("node %d already exists", id)
+	}
+	n := &node{id: id, storage: raft.NewMemoryStorage()}
+	if err := c.startNode(n, nil); err != nil {
+		return err
+	}
+	c.nodes[id] = n
+	return c.changeMembership(raftpb.ConfChange{Type: raftpb.ConfChangeAddNode, NodeID: id}, maxTicks)
+}
+
+// Remove a member, wait until the leader applied the change and stop the
+// node, which may not have learned of its removal
+func (c *Cluster) RemoveNode(id uint64, maxTicks int) error {
+	n, exists := c.nodes[id]
+	if !exists {
+		return fmt.Errorf("node %d does not exist", id)
+	}
+	if err := c.changeMembership(raftpb.ConfChange{Type: raftpb.ConfChangeRemoveNode, NodeID: id}, maxTicks); err != nil {
+		return err
+	}
+	n.removed = true
+	return nil
+}
+
+// Stop a node abruptly; its storage and applied state are kept
+func (c *Cluster) Crash(id uint64) error {
+	n, exists := c.nodes[id]
+	if !exists || n.crashed {
+		return fmt.Errorf("node %d is not running", id)
+	}
+	n.crashed = true
+	n.raw = nil
+	return nil
+}
+
+// Restart a crashed node from its storage
+func (c *Cluster) Restart(id uint64) error {
+	n, exists := c.nodes[id]
+	if !exists || !n.crashed {
+		return fmt.Errorf("node %d has not crashed", id)
+	}
+	return c.startNode(n, nil)
+}
+
+// True if a node is crashed
+func (c *Cluster) Crashed(id uint64) bool {
+	n, exists := c.nodes[id]
+	return exists && n.crashed
+}
+
+// Snapshot a node's applied state with data as its contents and discard
+// the log before it; members that fall behind the snapshot, and members
+// added later, then receive it instead of entries.  Returns the index of
+// the snapshot
+func (c *Cluster) Compact(id uint64, data []byte) (uint64, error) {
+	n, exists := c.nodes[id]
+	if !exists || n.crashed || n.removed {
+		return 0, fmt.Errorf("node %d is not running", id)
+	}
+	index := n.appliedIndex
+	if _, err := n.storage.CreateSnapshot(index, &n.confState, data); err != nil {
+		return 0, fmt.Errorf("node %d: snapshot: %w", id, err)
+	}
+	if err := n.storage.Compact(index); err != nil {
+		return 0, fmt.Errorf("node %d: compact: %w", id, err)
+	}
+	return index, nil
+}
+
+// Split the network into groups that cannot reach each other
+func (c *Cluster) Partition(groups ...[]uint64) {
+	c.net.partition(groups)
+}
+
+// Remove all partitions
+func (c *Cluster) Heal() {
+	c.net.heal()
+}
+
+// Check that the entries applied by every pair of members agree: at each
+// index both applied, they applied the same entry.  A node that installed
+// a snapshot has no entries before it
+func (c *Cluster) CheckConsistency() error {
+	ids := c.Nodes()
+	for i, a := range ids {
+		for _, b := range ids[i+1:] {
+			if err := appliedConsistent(a, c.nodes[a].applied, b, c.nodes[b].applied); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func appliedConsistent(aID uint64, a []Applied, bID uint64, b []Applied) error {
+	byIndex := make(map[uint64]Applied, len(a))
+	for _, entry := range a {
+		byIndex[entry.Index] = entry
+	}
+	for _, entry := range b {
+		other, exists := byIndex[entry.Index]
+		if exists && (other.Term != entry.Term || !bytes.Equal(other.Data, entry.Data)) {
+			return fmt.Errorf("nodes %d and %d diverge at index %d", aID, bID, entry.Index)
+		}
+	}
+	return nil
+}
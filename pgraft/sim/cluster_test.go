@@ -0,0 +1,53 @@
+package sim
+
+import (
+	"testing"
+)
+
+func TestCheckConsistencyDetectsDivergence(t *testing.T) {
+	a := []Applied{{Index: 1, Term: 1, Data: []byte("x")}, {Index: 2, Term: 1, Data: []byte("y")}}
+	b := []Applied{{Index: 1, Term: 1, Data: []byte("x")}, {Index: 2, Term: 2, Data: []byte("y")}}
+	if err := appliedConsistent(1, a, 2, b); err == nil {
+		t.Error("entries of different terms at one index were accepted")
+	}
+	b[1] = Applied{Index: 2, Term: 1, Data: []byte("z")}
+	if err := appliedConsistent(1, a, 2, b); err == nil {
+		t.Error("entries of different data at one index were accepted")
+	}
+	// A node that installed a snapshot lacks the entries before it
+	if err := appliedConsistent(1, a, 2, a[1:]); err != nil {
+		t.Errorf("a node starting after a snapshot was rejected: %v", err)
+	}
+}
+
+func TestPartitionedMinorityCannotCommit(t *testing.T) {
+	c, err := New(Config{Nodes: 3, Seed: 7, PreVote: true, CheckQuorum: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leader, err := c.WaitLeader(scenarioTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var others []uint64
+	for _, id := range c.Nodes() {
+		if id != leader {
+			others = append(others, id)
+		}
+	}
+	c.Partition([]uint64{leader}, others)
+	before := c.AppliedIndex(leader)
+	if err := c.Propose(leader, []byte("isolated")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		if err := c.Tick(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, entry := range c.Applied(leader) {
+		if entry.Index > before && string(entry.Data) == "isolated" {
+			t.Fatalf("isolated leader %d applied its proposal at %d", leader, entry.Index)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+/*
+ * network.go
+ * In-memory transport between simulated nodes
+ *
+ * Messages sent by a node are queued and delivered by the cluster in the
+ * order they were sent.  The network can be partitioned into groups that
+ * cannot reach each other, and can drop a fraction of messages using the
+ * cluster's seeded random source, so the same messages are dropped again
+ * for the same seed as long as the nodes send the same ones.  With
+ * encoding on, every message goes through the frame format and decoder
+ * peers use, so its cost is part of what a benchmark measures.
+ */
+
+package sim
+
+import (
+	"bytes"
+	"math/rand"
+
+	"github.com/pgelephant/pgraft/pgraft/wire"
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+type network struct {
+	queue []raftpb.Message
+
+	// Partition group of each node; nodes in different groups cannot
+	// communicate.  Nodes without a group reach every other node.
+	groups map[uint64]int
+
+	dropRate float64
+	rand     *rand.Rand
+	encode   bool
+
+	// Snapshots dropped since the last take, to be reported to the sender
+	droppedSnapshots []raftpb.Message
+
+	delivered uint64
+	dropped   uint64
+	bytes     uint64
+}
+
+func newNetwork(dropRate float64, rnd *rand.Rand, encode bool) *network {
+	return &network{
+		groups:   make(map[uint64]int),
+		dropRate: dropRate,
+		rand:     rnd,
+		encode:   encode,
+	}
+}
+
+func (n *network) send(msgs []raftpb.Message) {
+	n.queue = append(n.queue, msgs...)
+}
+
+// True if from can currently reach to
+func (n *network) connected(from, to uint64) bool {
+	fromGroup, fromPartitioned := n.groups[from]
+	toGroup, toPartitioned := n.groups[to]
+	if !fromPartitioned || !toPartitioned {
+		return true
+	}
+	return fromGroup == toGroup
+}
+
+// Take the queued messages that survive the partition and drop rate
+func (n *network) take() []raftpb.Message {
+	queue := n.queue
+	n.queue = nil
+
+	deliverable := queue[:0]
+	for _, msg := range queue {
+		if !n.connected(msg.From, msg.To) || (n.dropRate > 0 && n.rand.Float64() < n.dropRate) {
+			n.drop(msg)
+			continue
+		}
+		if n.encode {
+			decoded, ok := n.transcode(msg)
+			if !ok {
+				n.drop(msg)
+				continue
+			}
+			msg = decoded
+		}
+		deliverable = append(deliverable, msg)
+	}
+	n.delivered += uint64(len(deliverable))
+	return deliverable
+}
+
+func (n *network) drop(msg raftpb.Message) {
+	n.dropped++
+	if msg.Type == raftpb.MsgSnap {
+		n.droppedSnapshots = append(n.droppedSnapshots, msg)
+	}
+}
+
+// Send msg through the peer frame format and decode it on the other side
+func (n *network) transcode(msg raftpb.Message) (raftpb.Message, bool) {
+	data, err := msg.Marshal()
+	if err != nil {
+		return raftpb.Message{}, false
+	}
+	frame := wire.AppendFrame(nil, data)
+	n.bytes += uint64(len(frame))
+	payload, err := wire.ReadFrame(bytes.NewReader(frame))
+	if err != nil {
+		return raftpb.Message{}, false
+	}
+	decoded, err := wire.DecodeMessage(payload)
+	return decoded, err == nil
+}
+
+func (n *network) partition(groups [][]uint64) {
+	n.groups = make(map[uint64]int)
+	for i, group := range groups {
+		for _, id := range group {
+			n.groups[id] = i
+		}
+	}
+}
+
+func (n *network) heal() {
+	n.groups = make(map[uint64]int)
+}
@@ -0,0 +1,147 @@
+/*
+ * node.go
+ * A simulated raft node
+ *
+ * Each node drives an etcd raft RawNode synchronously, so nothing runs in
+ * the background and nothing happens between ticks but what the cluster
+ * does.  The node's storage and applied state survive a crash, the way
+ * the log on disk and the state PostgreSQL has applied would; a restart
+ * builds a new RawNode on top of them.
+ */
+
+package sim
+
+import (
+	"fmt"
+
+	"go.etcd.io/raft/v3"
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+// Entry applied by a node's state machine
+type Applied struct {
+	Index uint64
+	Term  uint64
+	Data  []byte
+}
+
+type node struct {
+	id      uint64
+	raw     *raft.RawNode
+	storage *raft.MemoryStorage
+	crashed bool
+	removed bool
+
+	confState    raftpb.ConfState
+	appliedIndex uint64
+	applied      []Applied
+}
+
+func (c *Cluster) raftConfig(n *node) *raft.Config {
+	return &raft.Config{
+		ID:              n.id,
+		ElectionTick:    c.cfg.ElectionTick,
+		HeartbeatTick:   c.cfg.HeartbeatTick,
+		Storage:         n.storage,
+		Applied:         n.appliedIndex,
+		MaxSizePerMsg:   1024 * 1024,
+		MaxInflightMsgs: 256,
+		PreVote:         c.cfg.PreVote,
+		CheckQuorum:     c.cfg.CheckQuorum,
+		Logger:          discardLogger,
+	}
+}
+
+// Create the RawNode of n, bootstrapping it with peers if given
+func (c *Cluster) startNode(n *node, peers []raft.Peer) error {
+	raw, err := raft.NewRawNode(c.raftConfig(n))
+	if err != nil {
+		return fmt.Errorf("node %d: %w", n.id, err)
+	}
+	if len(peers) > 0 {
+		if err := raw.Bootstrap(peers); err != nil {
+			return fmt.Errorf("node %d: bootstrap: %w", n.id, err)
+		}
+	}
+	n.raw = raw
+	n.crashed = false
+	return nil
+}
+
+// Persist, send and apply one Ready batch; false if there was none
+func (c *Cluster) processReady(n *node) (bool, error) {
+	if n.crashed || n.removed || !n.raw.HasReady() {
+		return false, nil
+	}
+	rd := n.raw.Ready()
+
+	if !raft.IsEmptySnap(rd.Snapshot) {
+		if err := n.storage.ApplySnapshot(rd.Snapshot); err != nil {
+			return false, fmt.Errorf("node %d: apply snapshot: %w", n.id, err)
+		}
+		n.confState = rd.Snapshot.Metadata.ConfState
+		n.appliedIndex = rd.Snapshot.Metadata.Index
+		if c.cfg.OnSnapshot != nil {
+			c.cfg.OnSnapshot(n.id, n.appliedIndex, rd.Snapshot.Data)
+		}
+	}
+	if err := n.storage.Append(rd.Entries); err != nil {
+		return false, fmt.Errorf("node %d: append: %w", n.id, err)
+	}
+	if !raft.IsEmptyHardState(rd.HardState) {
+		if err := n.storage.SetHardState(rd.HardState); err != nil {
+			return false, fmt.Errorf("node %d: hard state: %w", n.id, err)
+		}
+	}
+	c.net.send(rd.Messages)
+
+	for _, entry := range rd.CommittedEntries {
+		if err := c.applyEntry(n, entry); err != nil {
+			return false, err
+		}
+	}
+	n.raw.Advance(rd)
+	return true, nil
+}
+
+func (c *Cluster) applyEntry(n *node, entry raftpb.Entry) error {
+	if entry.Index <= n.appliedIndex {
+		return nil
+	}
+	if entry.Index != n.appliedIndex+1 {
+		return fmt.Errorf("node %d: applying index %d after %d", n.id, entry.Index, n.appliedIndex)
+	}
+	n.appliedIndex = entry.Index
+
+	switch entry.Type {
+	case raftpb.EntryNormal:
+		if len(entry.Data) > 0 {
+			n.applied = append(n.applied, Applied{Index: entry.Index, Term: entry.Term, Data: entry.Data})
+			if c.cfg.OnApply != nil {
+				c.cfg.OnApply(n.id, entry.Index, entry.Data)
+			}
+		}
+	case raftpb.EntryConfChange, raftpb.EntryConfChangeV2:
+		var cc raftpb.ConfChangeI
+		if entry.Type == raftpb.EntryConfChange {
+			var v1 raftpb.ConfChange
+			if err := v1.Unmarshal(entry.Data); err != nil {
+				return fmt.Errorf("node %d: conf change at %d: %w", n.id, entry.Index, err)
+			}
+			cc = v1
+		} else {
+			var v2 raftpb.ConfChangeV2
+			if err := v2.Unmarshal(entry.Data); err != nil {
+				return fmt.Errorf("node %d: conf change at %d: %w", n.id, entry.Index, err)
+			}
+			cc = v2
+		}
+		n.confState = *n.raw.ApplyConfChange(cc)
+		for _, change := range cc.AsV2().Changes {
+			if change.Type == raftpb.ConfChangeRemoveNode && change.NodeID == n.id {
+				n.removed = true
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,306 @@
+/*
+ * scenarios.go
+ * Standard simulation scenarios
+ *
+ * Each scenario builds its own cluster from the given configuration,
+ * drives it through one kind of event, and returns an error describing
+ * the first property that did not hold.  pgraft-sim runs them all, which
+ * is how CI exercises consensus behaviour without PostgreSQL.
+ */
+
+package sim
+
+import (
+	"fmt"
+)
+
+// Ticks allowed for any single step of a scenario
+const scenarioTimeout = 500
+
+type Scenario struct {
+	Name string
+	Run  func(cfg Config) error
+}
+
+var Scenarios = []Scenario{
+	{"election", scenarioElection},
+	{"replication", scenarioReplication},
+	{"leader-crash", scenarioLeaderCrash},
+	{"restart", scenarioRestart},
+	{"membership", scenarioMembership},
+	{"partition", scenarioPartition},
+	{"snapshot", scenarioSnapshot},
+}
+
+// Scenario with the given name, if it exists
+func FindScenario(name string) (Scenario, bool) {
+	for _, scenario := range Scenarios {
+		if scenario.Name == name {
+			return scenario, true
+		}
+	}
+	return Scenario{}, false
+}
+
+func proposeN(c *Cluster, prefix string, count int) error {
+	for i := 0; i < count; i++ {
+		if err := c.ProposeAndWait([]byte(fmt.Sprintf("%s-%d", prefix, i)), scenarioTimeout); err != nil {
+			return fmt.Errorf("proposal %s-%d: %w", prefix, i, err)
+		}
+	}
+	return nil
+}
+
+// Exactly one leader is elected, and every node agrees on it
+func scenarioElection(cfg Config) error {
+	c, err := New(cfg)
+	if err != nil {
+		return err
+	}
+	leader, err := c.WaitLeader(scenarioTimeout)
+	if err != nil {
+		return err
+	}
+	err = c.TickUntil(scenarioTimeout, func() bool {
+		for _, id := range c.Nodes() {
+			if status, _ := c.Status(id); status.Lead != leader {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("nodes do not agree on leader %d: %w", leader, err)
+	}
+	return nil
+}
+
+// Proposals are applied by every node in the same order
+func scenarioReplication(cfg Config) error {
+	c, err := New(cfg)
+	if err != nil {
+		return err
+	}
+	if err := proposeN(c, "entry", 50); err != nil {
+		return err
+	}
+	for _, id := range c.Nodes() {
+		if applied := len(c.Applied(id)); applied != 50 {
+			return fmt.Errorf("node %d applied %d entries, expected 50", id, applied)
+		}
+	}
+	return c.CheckConsistency()
+}
+
+// A new leader is elected after the leader crashes and committed entries
+// survive
+func scenarioLeaderCrash(cfg Config) error {
+	c, err := New(cfg)
+	if err != nil {
+		return err
+	}
+	if err := proposeN(c, "before", 10); err != nil {
+		return err
+	}
+	old := c.Leader()
+	if err := c.Crash(old); err != nil {
+		return err
+	}
+	leader, err := c.WaitLeader(scenarioTimeout)
+	if err != nil {
+		return fmt.Errorf("after crashing leader %d: %w", old, err)
+	}
+	if leader == old {
+		return fmt.Errorf("crashed node %d is still leader", old)
+	}
+	if err := proposeN(c, "after", 10); err != nil {
+		return err
+	}
+	if err := c.Restart(old); err != nil {
+		return err
+	}
+	err = c.TickUntil(scenarioTimeout, func() bool {
+		return c.AppliedIndex(old) == c.AppliedIndex(leader)
+	})
+	if err != nil {
+		return fmt.Errorf("restarted node %d did not catch up: %w", old, err)
+	}
+	return c.CheckConsistency()
+}
+
+// A follower restarted from its storage resumes without reapplying
+func scenarioRestart(cfg Config) error {
+	c, err := New(cfg)
+	if err != nil {
+		return err
+	}
+	if err := proposeN(c, "before", 10); err != nil {
+		return err
+	}
+	var follower uint64
+	for _, id := range c.Nodes() {
+		if id != c.Leader() {
+			follower = id
+			break
+		}
+	}
+	if err := c.Crash(follower); err != nil {
+		return err
+	}
+	if err := proposeN(c, "during", 10); err != nil {
+		return err
+	}
+	if err := c.Restart(follower); err != nil {
+		return err
+	}
+	err = c.TickUntil(scenarioTimeout, func() bool {
+		return len(c.Applied(follower)) == 20
+	})
+	if err != nil {
+		return fmt.Errorf("node %d applied %d entries after restart, expected 20: %w",
+			follower, len(c.Applied(follower)), err)
+	}
+	return c.CheckConsistency()
+}
+
+// Members can be added and removed while entries are replicated
+func scenarioMembership(cfg Config) error {
+	c, err := New(cfg)
+	if err != nil {
+		return err
+	}
+	if err := proposeN(c, "initial", 5); err != nil {
+		return err
+	}
+	added := uint64(len(c.Nodes()) + 1)
+	if err := c.AddNode(added, scenarioTimeout); err != nil {
+		return fmt.Errorf("adding node %d: %w", added, err)
+	}
+	if err := proposeN(c, "grown", 5); err != nil {
+		return err
+	}
+	if applied := len(c.Applied(added)); applied != 10 {
+		return fmt.Errorf("new node %d applied %d entries, expected 10", added, applied)
+	}
+
+	var removed uint64
+	for _, id := range c.Nodes() {
+		if id != c.Leader() && id != added {
+			removed = id
+			break
+		}
+	}
+	if err := c.RemoveNode(removed, scenarioTimeout); err != nil {
+		return fmt.Errorf("removing node %d: %w", removed, err)
+	}
+	if err := proposeN(c, "shrunk", 5); err != nil {
+		return err
+	}
+	for _, id := range c.Nodes() {
+		if id == removed {
+			return fmt.Errorf("removed node %d is still a member", removed)
+		}
+	}
+	return c.CheckConsistency()
+}
+
+// A minority partition cannot commit; after healing it converges with
+// the majority
+func scenarioPartition(cfg Config) error {
+	c, err := New(cfg)
+	if err != nil {
+		return err
+	}
+	if err := proposeN(c, "before", 5); err != nil {
+		return err
+	}
+
+	leader := c.Leader()
+	minority := []uint64{leader}
+	var majority []uint64
+	for _, id := range c.Nodes() {
+		if id != leader {
+			majority = append(majority, id)
+		}
+	}
+	if len(majority) < len(minority)+1 {
+		return fmt.Errorf("partition scenario needs at least 3 nodes")
+	}
+	c.Partition(minority, majority)
+
+	// The isolated leader accepts the proposal but cannot commit it
+	if err := c.Propose(leader, []byte("isolated")); err != nil {
+		return err
+	}
+	err = c.TickUntil(scenarioTimeout, func() bool {
+		newLeader := c.Leader()
+		return newLeader != 0 && newLeader != leader
+	})
+	if err != nil {
+		return fmt.Errorf("majority elected no leader: %w", err)
+	}
+	if err := proposeN(c, "majority", 5); err != nil {
+		return err
+	}
+
+	c.Heal()
+	err = c.TickUntil(scenarioTimeout, func() bool {
+		return c.AppliedIndex(leader) == c.AppliedIndex(c.Leader())
+	})
+	if err != nil {
+		return fmt.Errorf("old leader %d did not converge: %w", leader, err)
+	}
+	for _, id := range c.Nodes() {
+		for _, entry := range c.Applied(id) {
+			if string(entry.Data) == "isolated" {
+				return fmt.Errorf("node %d applied an entry proposed in the minority", id)
+			}
+		}
+	}
+	return c.CheckConsistency()
+}
+
+// A follower that fell behind the leader's compacted log catches up from
+// a snapshot and then replicates normally
+func scenarioSnapshot(cfg Config) error {
+	c, err := New(cfg)
+	if err != nil {
+		return err
+	}
+	if err := proposeN(c, "initial", 5); err != nil {
+		return err
+	}
+	var follower uint64
+	for _, id := range c.Nodes() {
+		if id != c.Leader() {
+			follower = id
+			break
+		}
+	}
+	if err := c.Crash(follower); err != nil {
+		return err
+	}
+	if err := proposeN(c, "behind", 20); err != nil {
+		return err
+	}
+	index, err := c.Compact(c.Leader(), []byte("snapshot"))
+	if err != nil {
+		return err
+	}
+	if err := c.Restart(follower); err != nil {
+		return err
+	}
+	err = c.TickUntil(scenarioTimeout, func() bool {
+		return c.AppliedIndex(follower) >= index
+	})
+	if err != nil {
+		return fmt.Errorf("node %d did not install the snapshot at %d: %w", follower, index, err)
+	}
+	if err := proposeN(c, "after", 5); err != nil {
+		return err
+	}
+	if applied := len(c.Applied(follower)); applied != 10 {
+		return fmt.Errorf("node %d applied %d entries around the snapshot, expected 10", follower, applied)
+	}
+	return c.CheckConsistency()
+}
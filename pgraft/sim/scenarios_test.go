@@ -0,0 +1,47 @@
+package sim
+
+import (
+	"fmt"
+	"testing"
+)
+
+// Each scenario under the configurations CI runs pgraft-sim with, over a
+// few seeds
+func TestScenarios(t *testing.T) {
+	configs := []struct {
+		name string
+		cfg  Config
+	}{
+		{"default", Config{PreVote: true, CheckQuorum: true}},
+		{"five-nodes", Config{Nodes: 5, PreVote: true, CheckQuorum: true}},
+		{"encoded", Config{PreVote: true, CheckQuorum: true, Encode: true}},
+		{"lossy", Config{PreVote: true, CheckQuorum: true, DropRate: 0.05}},
+		{"plain", Config{}},
+	}
+	for _, scenario := range Scenarios {
+		for _, config := range configs {
+			for seed := int64(1); seed <= 3; seed++ {
+				scenario, cfg := scenario, config.cfg
+				cfg.Seed = seed
+				t.Run(fmt.Sprintf("%s/%s/seed=%d", scenario.Name, config.name, seed), func(t *testing.T) {
+					t.Parallel()
+					if err := scenario.Run(cfg); err != nil {
+						t.Fatal(err)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestFindScenario(t *testing.T) {
+	for _, scenario := range Scenarios {
+		found, ok := FindScenario(scenario.Name)
+		if !ok || found.Name != scenario.Name {
+			t.Errorf("FindScenario(%q) = %q, %v", scenario.Name, found.Name, ok)
+		}
+	}
+	if _, ok := FindScenario("no-such-scenario"); ok {
+		t.Error("FindScenario found an unknown scenario")
+	}
+}
@@ -0,0 +1,569 @@
+/*
+ * soak.go
+ * Long-running soak test over the simulator
+ *
+ * A soak run proposes entries on the leader at a steady rate for hours of
+ * simulated time while members are crashed and restarted at random and
+ * logs are compacted, so followers that were down catch up from
+ * snapshots as well as from entries.  Each node's state machine is a
+ * digest chained over the entries it applied; snapshots carry the digest.
+ * Every index is checked against the first digest seen at it as nodes
+ * apply it, and at the end, with every member running again, all members
+ * must converge on the same applied index and digest, and no entry whose
+ * proposer saw it applied may be missing.  Anything else fails the run,
+ * as does the cluster making no progress for too long.
+ */
+
+package soak
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/pgelephant/pgraft/pgraft/sim"
+	"go.etcd.io/raft/v3"
+)
+
+// Simulated time of one tick, pgraft's default tick interval
+const TickInterval = 100 * time.Millisecond
+
+// Format version of Report, raised when fields change meaning
+const ReportVersion = 1
+
+// Failures kept in a report; later ones are only counted
+const maxFailures = 20
+
+type Config struct {
+	Cluster sim.Config `json:"-"`
+
+	// Wall-clock time to run for, and a bound on simulated ticks; the run
+	// ends at whichever comes first
+	Duration time.Duration `json:"duration"`
+	Ticks    uint64        `json:"ticks,omitempty"`
+
+	// Proposals per simulated second, and the size of each
+	Rate    float64 `json:"rate"`
+	Payload int     `json:"payload"`
+
+	// Simulated time between restarts, on average, and how long a
+	// crashed member stays down; no restarts if zero
+	RestartInterval time.Duration `json:"restart_interval"`
+	Downtime        time.Duration `json:"downtime"`
+
+	// Simulated time between compactions of every running member's log
+	CompactInterval time.Duration `json:"compact_interval"`
+
+	// Simulated time between periodic checks, and how long the cluster
+	// may go without acknowledging a proposal
+	CheckInterval time.Duration `json:"check_interval"`
+	StallTimeout  time.Duration `json:"stall_timeout"`
+
+	// Simulated time a proposal may stay unacknowledged before it is
+	// given up on; it may still commit later
+	AckTimeout time.Duration `json:"ack_timeout"`
+
+	// Called with the report so far after every periodic check
+	Progress func(*Report) `json:"-"`
+}
+
+func (cfg *Config) setDefaults() {
+	if cfg.Rate == 0 {
+		cfg.Rate = 50
+	}
+	if cfg.Payload == 0 {
+		cfg.Payload = 256
+	}
+	if cfg.Downtime == 0 {
+		cfg.Downtime = 10 * time.Second
+	}
+	if cfg.CheckInterval == 0 {
+		cfg.CheckInterval = 10 * time.Second
+	}
+	if cfg.StallTimeout == 0 {
+		cfg.StallTimeout = time.Minute
+	}
+	if cfg.AckTimeout == 0 {
+		cfg.AckTimeout = 10 * time.Second
+	}
+}
+
+// Durations as strings such as "1m30s" instead of nanoseconds
+func (cfg Config) MarshalJSON() ([]byte, error) {
+	type plain Config
+	return json.Marshal(struct {
+		plain
+		Duration        string `json:"duration"`
+		RestartInterval string `json:"restart_interval"`
+		Downtime        string `json:"downtime"`
+		CompactInterval string `json:"compact_interval"`
+		CheckInterval   string `json:"check_interval"`
+		StallTimeout    string `json:"stall_timeout"`
+		AckTimeout      string `json:"ack_timeout"`
+	}{
+		plain(cfg),
+		cfg.Duration.String(),
+		cfg.RestartInterval.String(),
+		cfg.Downtime.String(),
+		cfg.CompactInterval.String(),
+		cfg.CheckInterval.String(),
+		cfg.StallTimeout.String(),
+		cfg.AckTimeout.String(),
+	})
+}
+
+// Whole ticks in a simulated duration, at least one
+func ticks(d time.Duration) uint64 {
+	if n := uint64(d / TickInterval); n > 0 {
+		return n
+	}
+	return 1
+}
+
+type Report struct {
+	Version int       `json:"version"`
+	Passed  bool      `json:"passed"`
+	Nodes   int       `json:"nodes"`
+	Seed    int64     `json:"seed"`
+	Config  Config    `json:"config"`
+	Started time.Time `json:"started"`
+
+	Seconds          float64 `json:"seconds"`
+	SimulatedSeconds float64 `json:"simulated_seconds"`
+	Ticks            uint64  `json:"ticks"`
+
+	// Proposals made, those the proposer applied, those not made for want
+	// of a leader and those given up on unacknowledged
+	Proposed     uint64 `json:"proposed"`
+	Acknowledged uint64 `json:"acknowledged"`
+	Unavailable  uint64 `json:"unavailable"`
+	Abandoned    uint64 `json:"abandoned"`
+
+	Restarts    int `json:"restarts"`
+	Compactions int `json:"compactions"`
+	Snapshots   int `json:"snapshots_installed"`
+	Checks      int `json:"checks"`
+
+	// Index every member converged on at the end
+	AppliedIndex uint64 `json:"applied_index"`
+
+	// Longest simulated time without an acknowledgement
+	LongestStallSeconds float64 `json:"longest_stall_seconds"`
+
+	// Go heap in use at the first and last check and at most
+	HeapStartBytes uint64 `json:"heap_start_bytes"`
+	HeapEndBytes   uint64 `json:"heap_end_bytes"`
+	HeapMaxBytes   uint64 `json:"heap_max_bytes"`
+
+	Failures      []string `json:"failures"`
+	FailuresTotal int      `json:"failures_total"`
+}
+
+func (r *Report) failf(format string, args ...interface{}) {
+	r.FailuresTotal++
+	if len(r.Failures) < maxFailures {
+		r.Failures = append(r.Failures, fmt.Sprintf(format, args...))
+	}
+}
+
+// State machine of a node
+type machine struct {
+	index  uint64
+	digest [sha256.Size]byte
+}
+
+type proposal struct {
+	node  uint64
+	since uint64
+}
+
+type run struct {
+	cfg     Config
+	cluster *sim.Cluster
+	report  *Report
+
+	machines map[uint64]*machine
+
+	// Digest after each index, as first seen on any node; indexes every
+	// member applied are pruned
+	digests map[uint64][sha256.Size]byte
+
+	// Proposals waiting to be applied by their proposer, by sequence
+	pending map[uint64]proposal
+	seq     uint64
+
+	// Highest index of an acknowledged proposal, and when the last
+	// acknowledgement happened
+	acked     uint64
+	ackedTick uint64
+
+	// Ticks at which crashed members restart
+	downUntil map[uint64]uint64
+}
+
+// Run a soak test on a new simulated cluster
+func Run(cfg Config) (*Report, error) {
+	cfg.setDefaults()
+	if cfg.Duration <= 0 && cfg.Ticks == 0 {
+		return nil, errors.New("neither a duration nor a number of ticks to run for")
+	}
+	if cfg.Payload < 8 {
+		return nil, errors.New("payload must be at least 8 bytes")
+	}
+	r := &run{
+		cfg:       cfg,
+		machines:  make(map[uint64]*machine),
+		digests:   make(map[uint64][sha256.Size]byte),
+		pending:   make(map[uint64]proposal),
+		downUntil: make(map[uint64]uint64),
+	}
+	cfg.Cluster.OnApply = r.apply
+	cfg.Cluster.OnSnapshot = r.installSnapshot
+	c, err := sim.New(cfg.Cluster)
+	if err != nil {
+		return nil, err
+	}
+	r.cluster = c
+	r.report = &Report{
+		Version:  ReportVersion,
+		Nodes:    len(c.Nodes()),
+		Seed:     cfg.Cluster.Seed,
+		Config:   cfg,
+		Started:  time.Now().UTC(),
+		Failures: []string{},
+	}
+	for _, id := range c.Nodes() {
+		r.machines[id] = &machine{}
+	}
+
+	start := time.Now()
+	err = r.loop(start)
+	if err == nil && r.report.FailuresTotal == 0 {
+		err = r.converge()
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.sampleHeap()
+	report := r.report
+	report.Seconds = time.Since(start).Seconds()
+	report.Ticks = c.Ticks()
+	report.SimulatedSeconds = float64(c.Ticks()) * TickInterval.Seconds()
+	report.Passed = report.FailuresTotal == 0
+	return report, nil
+}
+
+func (r *run) loop(start time.Time) error {
+	c := r.cluster
+	cfg := r.cfg
+	checkEvery := ticks(cfg.CheckInterval)
+	var restartEvery, compactEvery uint64
+	if cfg.RestartInterval > 0 {
+		restartEvery = ticks(cfg.RestartInterval)
+	}
+	if cfg.CompactInterval > 0 {
+		compactEvery = ticks(cfg.CompactInterval)
+	}
+	nextRestart := r.jitter(restartEvery)
+
+	credit := 0.0
+	for r.report.FailuresTotal == 0 {
+		if cfg.Ticks > 0 && c.Ticks() >= cfg.Ticks {
+			break
+		}
+		if cfg.Duration > 0 && time.Since(start) >= cfg.Duration {
+			break
+		}
+
+		credit += cfg.Rate * TickInterval.Seconds()
+		for ; credit >= 1; credit-- {
+			r.propose()
+		}
+		if err := c.Tick(); err != nil {
+			return err
+		}
+		now := c.Ticks()
+		r.expire()
+		if err := r.restartDue(); err != nil {
+			return err
+		}
+		if restartEvery > 0 && now >= nextRestart {
+			if err := r.crash(); err != nil {
+				return err
+			}
+			nextRestart = now + r.jitter(restartEvery)
+		}
+		if compactEvery > 0 && now%compactEvery == 0 {
+			if err := r.compact(); err != nil {
+				return err
+			}
+		}
+		if now%checkEvery == 0 {
+			r.check()
+		}
+	}
+	return nil
+}
+
+// A random number of ticks averaging interval
+func (r *run) jitter(interval uint64) uint64 {
+	if interval == 0 {
+		return 0
+	}
+	return interval/2 + uint64(r.cluster.Rand().Int63n(int64(interval)+1))
+}
+
+// Propose the next entry on the leader; its first 8 bytes are its
+// sequence number
+func (r *run) propose() {
+	c := r.cluster
+	leader := c.Leader()
+	if leader == 0 {
+		r.report.Unavailable++
+		return
+	}
+	r.seq++
+	data := make([]byte, r.cfg.Payload)
+	binary.BigEndian.PutUint64(data, r.seq)
+	c.Rand().Read(data[8:])
+	if err := c.Submit(leader, data); err != nil {
+		r.report.Unavailable++
+		return
+	}
+	r.report.Proposed++
+	r.pending[r.seq] = proposal{node: leader, since: c.Ticks()}
+}
+
+func (r *run) apply(node, index uint64, data []byte) {
+	m := r.machines[node]
+	h := sha256.New()
+	h.Write(m.digest[:])
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], index)
+	h.Write(buf[:])
+	h.Write(data)
+	copy(m.digest[:], h.Sum(nil))
+	m.index = index
+	r.record(node, index, m.digest)
+
+	if len(data) < 8 {
+		return
+	}
+	seq := binary.BigEndian.Uint64(data)
+	if p, waiting := r.pending[seq]; waiting && p.node == node {
+		delete(r.pending, seq)
+		r.report.Acknowledged++
+		r.ackedTick = r.cluster.Ticks()
+		if index > r.acked {
+			r.acked = index
+		}
+	}
+}
+
+func (r *run) installSnapshot(node, index uint64, data []byte) {
+	r.report.Snapshots++
+	m := r.machines[node]
+	if len(data) != sha256.Size {
+		r.report.failf("node %d installed a snapshot at index %d without a state digest", node, index)
+		return
+	}
+	copy(m.digest[:], data)
+	m.index = index
+	r.record(node, index, m.digest)
+}
+
+// Compare the digest a node has after index with the first one seen
+func (r *run) record(node, index uint64, digest [sha256.Size]byte) {
+	first, seen := r.digests[index]
+	if !seen {
+		r.digests[index] = digest
+		return
+	}
+	if first != digest {
+		r.report.failf("node %d diverged at index %d: its state differs from what another member applied", node, index)
+	}
+}
+
+// Give up on proposals their proposer has not applied in time
+func (r *run) expire() {
+	limit := ticks(r.cfg.AckTimeout)
+	now := r.cluster.Ticks()
+	for seq, p := range r.pending {
+		if now-p.since >= limit {
+			delete(r.pending, seq)
+			r.report.Abandoned++
+		}
+	}
+}
+
+// Crash a random running member, keeping a majority up
+func (r *run) crash() error {
+	c := r.cluster
+	nodes := c.Nodes()
+	if len(r.downUntil)+1 > (len(nodes)-1)/2 {
+		return nil
+	}
+	var live []uint64
+	for _, id := range nodes {
+		if !c.Crashed(id) {
+			live = append(live, id)
+		}
+	}
+	id := live[c.Rand().Intn(len(live))]
+	if err := c.Crash(id); err != nil {
+		return err
+	}
+	r.downUntil[id] = c.Ticks() + ticks(r.cfg.Downtime)
+	return nil
+}
+
+func (r *run) restartDue() error {
+	for id, until := range r.downUntil {
+		if r.cluster.Ticks() < until {
+			continue
+		}
+		if err := r.cluster.Restart(id); err != nil {
+			return err
+		}
+		delete(r.downUntil, id)
+		r.report.Restarts++
+	}
+	return nil
+}
+
+// Snapshot every running member at its applied index with its digest
+func (r *run) compact() error {
+	c := r.cluster
+	for _, id := range c.Nodes() {
+		if c.Crashed(id) {
+			continue
+		}
+		// The snapshot may be taken after entries without data, such as
+		// a new leader's empty entry, which leave the digest as it was
+		m := r.machines[id]
+		r.record(id, c.AppliedIndex(id), m.digest)
+		_, err := c.Compact(id, append([]byte(nil), m.digest[:]...))
+		if errors.Is(err, raft.ErrSnapOutOfDate) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		r.report.Compactions++
+	}
+	return nil
+}
+
+// Periodic check: applied entries agree, the cluster makes progress, and
+// memory held for checking is released once every member is past it
+func (r *run) check() {
+	c := r.cluster
+	r.report.Checks++
+	if err := c.CheckConsistency(); err != nil {
+		r.report.failf("%v", err)
+	}
+
+	stall := c.Ticks() - r.ackedTick
+	if seconds := float64(stall) * TickInterval.Seconds(); seconds > r.report.LongestStallSeconds {
+		r.report.LongestStallSeconds = seconds
+	}
+	if stall > ticks(r.cfg.StallTimeout) {
+		r.report.failf("no proposal acknowledged for %v of simulated time", time.Duration(stall)*TickInterval)
+	}
+
+	low := r.lowestApplied()
+	for index := range r.digests {
+		if index < low {
+			delete(r.digests, index)
+		}
+	}
+	if low > 0 {
+		c.DiscardApplied(low - 1)
+	}
+
+	r.sampleHeap()
+	if r.cfg.Progress != nil {
+		r.report.Ticks = c.Ticks()
+		r.report.SimulatedSeconds = float64(c.Ticks()) * TickInterval.Seconds()
+		r.cfg.Progress(r.report)
+	}
+}
+
+// Lowest index applied by any member, crashed ones included
+func (r *run) lowestApplied() uint64 {
+	var low uint64
+	for i, id := range r.cluster.Nodes() {
+		if index := r.cluster.AppliedIndex(id); i == 0 || index < low {
+			low = index
+		}
+	}
+	return low
+}
+
+func (r *run) sampleHeap() {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	if r.report.HeapStartBytes == 0 {
+		r.report.HeapStartBytes = stats.HeapInuse
+	}
+	r.report.HeapEndBytes = stats.HeapInuse
+	if stats.HeapInuse > r.report.HeapMaxBytes {
+		r.report.HeapMaxBytes = stats.HeapInuse
+	}
+}
+
+// Restart every member, let the cluster catch up and check that all
+// members hold the same state with every acknowledged entry in it
+func (r *run) converge() error {
+	c := r.cluster
+	c.Heal()
+	for id := range r.downUntil {
+		if err := c.Restart(id); err != nil {
+			return err
+		}
+		delete(r.downUntil, id)
+		r.report.Restarts++
+	}
+
+	converged := func() bool {
+		leader := c.Leader()
+		if leader == 0 {
+			return false
+		}
+		status, err := c.Status(leader)
+		if err != nil {
+			return false
+		}
+		for _, id := range c.Nodes() {
+			if c.AppliedIndex(id) != status.Commit {
+				return false
+			}
+		}
+		return true
+	}
+	if err := c.TickUntil(int(ticks(r.cfg.StallTimeout)), converged); err != nil {
+		r.report.failf("members did not converge within %v of simulated time", r.cfg.StallTimeout)
+		return nil
+	}
+
+	nodes := c.Nodes()
+	first := r.machines[nodes[0]]
+	r.report.AppliedIndex = c.AppliedIndex(nodes[0])
+	for _, id := range nodes[1:] {
+		if m := r.machines[id]; !bytes.Equal(m.digest[:], first.digest[:]) {
+			r.report.failf("nodes %d and %d applied through index %d but hold different state", nodes[0], id, r.report.AppliedIndex)
+		}
+	}
+	if r.acked > r.report.AppliedIndex {
+		r.report.failf("acknowledged entry at index %d is missing: members converged on index %d", r.acked, r.report.AppliedIndex)
+	}
+	if err := c.CheckConsistency(); err != nil {
+		r.report.failf("%v", err)
+	}
+	return nil
+}
@@ -26,6 +26,7 @@
 static int pgraft_init_system(int node_id, const char *address, int port);
 static int pgraft_add_node_system(int node_id, const char *address, int port);
 static int pgraft_remove_node_system(int node_id);
+static int pgraft_switchover_system(int target_node, char *error_message, size_t error_size);
 static int pgraft_log_append_system(const char *log_data, int log_index);
 static int pgraft_log_commit_system(int log_index);
 static int pgraft_log_apply_system(int log_index);
@@ -36,6 +37,9 @@ PG_MODULE_MAGIC;
 /* Extension version */
 #define PGRAFT_VERSION "1.0.0"
 
+/* How long the worker waits for a switchover to complete */
+#define PGRAFT_SWITCHOVER_TIMEOUT_MS 30000
+
 /* Shared memory request hook */
 static shmem_request_hook_type prev_shmem_request_hook = NULL;
 
@@ -271,6 +275,17 @@ pgraft_main(Datum main_arg)
 					pgraft_update_command_status(cmd.timestamp, cmd.status, cmd.error_message);
 					break;
 					
+				case COMMAND_SWITCHOVER:
+					/* Hand leadership over; only the worker runs the raft node */
+					if (pgraft_switchover_system(cmd.node_id, cmd.error_message,
+												 sizeof(cmd.error_message)) != 0) {
+						cmd.status = COMMAND_STATUS_FAILED;
+					} else {
+						cmd.status = COMMAND_STATUS_COMPLETED;
+					}
+					pgraft_update_command_status(cmd.timestamp, cmd.status, cmd.error_message);
+					break;
+					
 				case COMMAND_SHUTDOWN:
 					elog(LOG, "pgraft: SHUTDOWN command received");
 					state->status = WORKER_STATUS_STOPPED;
@@ -288,6 +303,9 @@ pgraft_main(Datum main_arg)
 			}
 		}
 
+		/* Go log messages can only reach elog() from this thread */
+		pgraft_go_drain_log_messages();
+
 		/* Sleep for a short time to avoid busy waiting */
 		pg_usleep(1000000); /* 1 second */
 		
@@ -300,6 +318,7 @@ pgraft_main(Datum main_arg)
 	}
 
 	/* Cleanup */
+	pgraft_go_drain_log_messages();
 	state->status = WORKER_STATUS_STOPPED;
 	elog(LOG, "pgraft: Background worker stopped");
 }
@@ -452,6 +471,43 @@ pgraft_remove_node_system(int node_id)
 	return 0;
 }
 
+/*
+ * Hand raft leadership to target_node and wait for it to lead; on failure
+ * error_message gets the switchover report
+ */
+static int
+pgraft_switchover_system(int target_node, char *error_message, size_t error_size)
+{
+	/* Variable declarations at the top - PostgreSQL C standard */
+	pgraft_go_switchover_func switchover_func;
+	pgraft_go_free_string_func free_string_func;
+	char	   *report;
+	bool		success;
+
+	if (!pgraft_go_is_loaded() || (switchover_func = pgraft_go_get_switchover_func()) == NULL) {
+		snprintf(error_message, error_size, "Go library does not support switchover");
+		return -1;
+	}
+
+	report = switchover_func(target_node, PGRAFT_SWITCHOVER_TIMEOUT_MS);
+	if (report == NULL) {
+		snprintf(error_message, error_size, "Switchover to node %d returned no report", target_node);
+		return -1;
+	}
+	success = strstr(report, "\"success\":true") != NULL;
+	if (success) {
+		elog(INFO, "pgraft: Switchover to node %d completed: %s", target_node, report);
+	} else {
+		elog(WARNING, "pgraft: Switchover to node %d failed: %s", target_node, report);
+		snprintf(error_message, error_size, "%s", report);
+	}
+
+	free_string_func = pgraft_go_get_free_string_func();
+	if (free_string_func)
+		free_string_func(report);
+	return success ? 0 : -1;
+}
+
 /*
  * Append log entry to pgraft system
  */
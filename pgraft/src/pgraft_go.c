@@ -15,6 +15,7 @@
 #include <dlfcn.h>
 
 #include "../include/pgraft_go.h"
+#include "../include/pgraft_guc.h"
 #include "../include/pgraft_state.h"
 
 /* Global variables */
@@ -36,6 +37,32 @@ static pgraft_go_set_debug_func pgraft_go_set_debug_ptr = NULL;
 static pgraft_go_start_network_server_func pgraft_go_start_network_server_ptr = NULL;
 static pgraft_go_free_string_func pgraft_go_free_string_ptr = NULL;
 static pgraft_go_update_cluster_state_func pgraft_go_update_cluster_state_ptr = NULL;
+static pgraft_go_switchover_func pgraft_go_switchover_ptr = NULL;
+static pgraft_go_set_log_callback_func pgraft_go_set_log_callback_ptr = NULL;
+static pgraft_go_drain_logs_func pgraft_go_drain_logs_ptr = NULL;
+static pgraft_go_api_version_func pgraft_go_api_version_ptr = NULL;
+
+/*
+ * Log callback registered with the Go library; runs on the thread that
+ * drains the log queue, so it may call elog().  elog(ERROR) would abort
+ * the worker, so Go errors are logged as warnings.
+ */
+static void
+pgraft_go_log_to_elog(int level, const char *message)
+{
+	switch (level)
+	{
+		case PGRAFT_GO_LOG_DEBUG:
+			elog(DEBUG1, "%s", message);
+			break;
+		case PGRAFT_GO_LOG_INFO:
+			elog(LOG, "%s", message);
+			break;
+		default:
+			elog(WARNING, "%s", message);
+			break;
+	}
+}
 
 /*
  * Load Go Raft library dynamically
@@ -94,6 +121,10 @@ pgraft_go_load_library(void)
 	pgraft_go_start_network_server_ptr = (pgraft_go_start_network_server_func) dlsym(go_lib_handle, "pgraft_go_start_network_server");
 	pgraft_go_free_string_ptr = (pgraft_go_free_string_func) dlsym(go_lib_handle, "pgraft_go_free_string");
 	pgraft_go_update_cluster_state_ptr = (pgraft_go_update_cluster_state_func) dlsym(go_lib_handle, "pgraft_go_update_cluster_state");
+	pgraft_go_switchover_ptr = (pgraft_go_switchover_func) dlsym(go_lib_handle, "pgraft_go_switchover");
+	pgraft_go_set_log_callback_ptr = (pgraft_go_set_log_callback_func) dlsym(go_lib_handle, "pgraft_go_set_log_callback");
+	pgraft_go_drain_logs_ptr = (pgraft_go_drain_logs_func) dlsym(go_lib_handle, "pgraft_go_drain_logs");
+	pgraft_go_api_version_ptr = (pgraft_go_api_version_func) dlsym(go_lib_handle, "pgraft_go_api_version");
 	
 	/* Check if all critical functions were loaded */
 	if (!pgraft_go_init_ptr || !pgraft_go_start_ptr || !pgraft_go_stop_ptr)
@@ -104,6 +135,36 @@ pgraft_go_load_library(void)
 		return -1;
 	}
 	
+	/*
+	 * Refuse a library of another major API version, or one older than the
+	 * exports this extension calls
+	 */
+	{
+		int			major = 0;
+		int			minor = 0;
+		uint64_t	capabilities = 0;
+
+		if (!pgraft_go_api_version_ptr ||
+			pgraft_go_api_version_ptr(&major, &minor, &capabilities) != 0 ||
+			major != PGRAFT_GO_API_MAJOR || minor < PGRAFT_GO_API_MINOR)
+		{
+			dlclose(go_lib_handle);
+			go_lib_handle = NULL;
+			pgraft_go_api_version_ptr = NULL;
+			pgraft_go_set_log_callback_ptr = NULL;
+			pgraft_go_drain_logs_ptr = NULL;
+			elog(WARNING, "pgraft: Go library API version %d.%d is incompatible with %d.%d",
+				 major, minor, PGRAFT_GO_API_MAJOR, PGRAFT_GO_API_MINOR);
+			return -1;
+		}
+		elog(LOG, "pgraft: Go library API version %d.%d, capabilities 0x%llx",
+			 major, minor, (unsigned long long) capabilities);
+	}
+	
+	/* Route Go log output to the server log, drained by the worker loop */
+	if (pgraft_go_set_log_callback_ptr && pgraft_go_drain_logs_ptr)
+		pgraft_go_set_log_callback_ptr(pgraft_go_log_to_elog, pgraft_log_level);
+	
 	/* Update shared memory state */
 	pgraft_state_set_go_lib_loaded(true);
 	
@@ -120,6 +181,10 @@ pgraft_go_unload_library(void)
 {
 	if (go_lib_handle)
 	{
+		/* Deliver what is queued and send the rest back to stderr */
+		pgraft_go_drain_log_messages();
+		if (pgraft_go_set_log_callback_ptr)
+			pgraft_go_set_log_callback_ptr(NULL, 0);
 		dlclose(go_lib_handle);
 		go_lib_handle = NULL;
 	}
@@ -138,6 +203,10 @@ pgraft_go_unload_library(void)
 	pgraft_go_test_ptr = NULL;
 	pgraft_go_set_debug_ptr = NULL;
 	pgraft_go_free_string_ptr = NULL;
+	pgraft_go_switchover_ptr = NULL;
+	pgraft_go_set_log_callback_ptr = NULL;
+	pgraft_go_drain_logs_ptr = NULL;
+	pgraft_go_api_version_ptr = NULL;
 	
 	/* Update shared memory state */
 	pgraft_state_set_go_lib_loaded(false);
@@ -145,6 +214,17 @@ pgraft_go_unload_library(void)
 	elog(INFO, "pgraft: Go library unloaded");
 }
 
+/*
+ * Write the Go log messages queued since the last call to the server log;
+ * called from the worker loop, as elog() must run on this thread
+ */
+void
+pgraft_go_drain_log_messages(void)
+{
+	if (pgraft_go_drain_logs_ptr)
+		pgraft_go_drain_logs_ptr();
+}
+
 /*
  * Check if Go library is loaded
  */
@@ -251,6 +331,12 @@ pgraft_go_get_update_cluster_state_func(void)
 	return pgraft_go_update_cluster_state_ptr;
 }
 
+pgraft_go_switchover_func
+pgraft_go_get_switchover_func(void)
+{
+	return pgraft_go_switchover_ptr;
+}
+
 /*
  * Initialize the Go library
  */
@@ -12,8 +12,6 @@
 package main
 
 /*
-#cgo CFLAGS: -I/usr/local/pgsql.17/include/server
-#cgo LDFLAGS: -L/usr/local/pgsql.17/lib
 #include <stdlib.h>
 #include <string.h>
 */
@@ -24,6 +22,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
@@ -51,7 +50,7 @@ type ClusterState struct {
 // Global state following etcd-io/raft patterns
 var (
 	raftNode    raft.Node
-	raftStorage *raft.MemoryStorage
+	raftStorage Storage
 	raftConfig  *raft.Config
 	raftCtx     context.Context
 	raftCancel  context.CancelFunc
@@ -117,7 +116,7 @@ func recordError(err error) {
 // Network utility functions
 func readUint32(conn net.Conn, value *uint32) error {
 	buf := make([]byte, 4)
-	if _, err := conn.Read(buf); err != nil {
+	if _, err := io.ReadFull(conn, buf); err != nil {
 		return err
 	}
 	*value = uint32(buf[0])<<24 | uint32(buf[1])<<16 | uint32(buf[2])<<8 | uint32(buf[3])
@@ -167,14 +166,17 @@ func pgraft_go_start() C.int {
 
 	if atomic.LoadInt32(&initialized) == 0 {
 		log.Printf("pgraft: ERROR - Not initialized")
-		return -1
+		return errNotInitialized
 	}
 
-	// Start background processing
-	raftTicker = time.NewTicker(100 * time.Millisecond)
-	go raftProcessingLoop()
-	go tickerLoop()
-	go messageReceiver()
+	// Start background processing, reusing the ticker created by init so
+	// a restarted node does not leak the previous one
+	if raftTicker == nil {
+		raftTicker = time.NewTicker(currentTickInterval())
+	}
+	goBackground(raftProcessingLoop)
+	goBackground(tickerLoop)
+	goBackground(messageReceiver)
 
 	atomic.StoreInt32(&running, 1)
 	log.Printf("pgraft: INFO - Started successfully")
@@ -184,36 +186,22 @@ func pgraft_go_start() C.int {
 
 //export pgraft_go_stop
 func pgraft_go_stop() C.int {
-	raftMutex.Lock()
-	defer raftMutex.Unlock()
-
-	if atomic.LoadInt32(&running) == 0 {
+	if atomic.LoadInt32(&running) == 0 && atomic.LoadInt32(&initialized) == 0 {
 		log.Printf("pgraft: WARNING - Already stopped")
 		return 0
 	}
 
-	// Signal shutdown
-	close(stopChan)
-
-	// Stop ticker
-	if raftTicker != nil {
-		raftTicker.Stop()
-	}
-
-	// Cancel context
-	if raftCancel != nil {
-		raftCancel()
-	}
+	forceDemotion()
 
-	// Close all connections
-	connMutex.Lock()
-	for nodeID, conn := range connections {
-		conn.Close()
-		delete(connections, nodeID)
-	}
-	connMutex.Unlock()
+	// Stop goroutines, listener, connections and the Raft node; the log
+	// storage is kept so a later init restarts from it
+	teardownNode()
 
 	atomic.StoreInt32(&running, 0)
+	atomic.StoreInt32(&initialized, 0)
+	resetHandles()
+	healthStatus = "stopped"
+	publishStatusSnapshot()
 	log.Printf("pgraft: INFO - Stopped successfully")
 
 	return 0
@@ -271,13 +259,24 @@ var (
 
 //export pgraft_go_init
 func pgraft_go_init(nodeID C.int, address *C.char, port C.int) C.int {
+	cfg := defaultNodeConfig()
+	cfg.NodeID = uint64(nodeID)
+	cfg.Address = C.GoString(address)
+	cfg.Port = int(port)
+
+	return initializeNode(cfg)
+}
+
+// Create the Raft node and start its background processing
+func initializeNode(cfg *NodeConfig) (rc C.int) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("pgraft: PANIC in pgraft_go_init: %v", r)
+			rc = -1
 		}
 	}()
 
-	log.Printf("pgraft: INFO - Initializing node %d at %s:%d", nodeID, C.GoString(address), int(port))
+	log.Printf("pgraft: INFO - Initializing node %d at %s", cfg.NodeID, cfg.listenAddress())
 
 	raftMutex.Lock()
 	defer raftMutex.Unlock()
@@ -287,20 +286,38 @@ func pgraft_go_init(nodeID C.int, address *C.char, port C.int) C.int {
 		return 0 // Already initialized
 	}
 
-	// Initialize storage
-	raftStorage = raft.NewMemoryStorage()
-	log.Printf("pgraft: DEBUG - Memory storage initialized")
+	// Reuse the storage of a previous run of the same node so a stop/init
+	// cycle resumes from its log and HardState
+	restarting := false
+	if raftStorage != nil && activeConfig != nil && activeConfig.NodeID == cfg.NodeID {
+		hs, _, _ := raftStorage.InitialState()
+		lastIdx, _ := raftStorage.LastIndex()
+		restarting = !raft.IsEmptyHardState(hs) || lastIdx > 0
+	}
+	var restartApplied uint64
+	if !restarting {
+		raftStorage = newStorage()
+		log.Printf("pgraft: DEBUG - Memory storage initialized")
+	} else {
+		hs, _, _ := raftStorage.InitialState()
+		snapshot, _ := raftStorage.Snapshot()
+		restartApplied = restartAppliedIndex(hs.Commit, snapshot.Metadata.Index)
+		log.Printf("pgraft: INFO - Restarting node %d from existing storage, applied through %d, services through %d",
+			cfg.NodeID, restartApplied, atomic.LoadUint64(&servicesApplied))
+	}
 
 	// Create configuration following etcd-io/raft patterns
 	raftConfig = &raft.Config{
-		ID:              uint64(nodeID),
-		ElectionTick:    10,
-		HeartbeatTick:   1,
+		ID:              cfg.NodeID,
+		ElectionTick:    cfg.Tunables.ElectionTick,
+		HeartbeatTick:   cfg.Tunables.HeartbeatTick,
 		Storage:         raftStorage,
-		MaxSizePerMsg:   4096,
-		MaxInflightMsgs: 256,
-		Logger:          nil,   // Use default logger
-		PreVote:         false, // Disable pre-vote for single node
+		Applied:         restartApplied,
+		MaxSizePerMsg:   cfg.Tunables.MaxSizePerMsg,
+		MaxInflightMsgs: cfg.Tunables.MaxInflightMsgs,
+		Logger:          nil, // Use default logger
+		PreVote:         cfg.Tunables.PreVote,
+		CheckQuorum:     cfg.Tunables.CheckQuorum,
 	}
 	log.Printf("pgraft: DEBUG - Raft configuration created")
 
@@ -316,9 +333,12 @@ func pgraft_go_init(nodeID C.int, address *C.char, port C.int) C.int {
 	if nodes == nil {
 		nodes = make(map[uint64]string)
 	}
-	nodes[uint64(nodeID)] = fmt.Sprintf("%s:%d", C.GoString(address), int(port))
+	nodes[cfg.NodeID] = cfg.listenAddress()
+	for _, peer := range cfg.Peers {
+		nodes[peer.ID] = peer.peerAddress()
+	}
 	nodesMutex.Unlock()
-	log.Printf("pgraft: INFO - Self node registered: %d -> %s:%d", nodeID, C.GoString(address), int(port))
+	log.Printf("pgraft: INFO - Self node registered: %d -> %s", cfg.NodeID, cfg.listenAddress())
 
 	// Initialize connections
 	connections = make(map[uint64]net.Conn)
@@ -333,28 +353,42 @@ func pgraft_go_init(nodeID C.int, address *C.char, port C.int) C.int {
 		CommitIndex: 0,
 	}
 
-	// Create initial peer configuration for this node
-	// Additional peers will be added via pgraft_add_node calls
+	// Create initial peer configuration for this node plus any peers from
+	// an inline configuration; additional peers will be added via
+	// pgraft_add_node calls
 	peers := []raft.Peer{
-		{ID: uint64(nodeID)},
+		{ID: cfg.NodeID},
+	}
+	for _, peer := range cfg.Peers {
+		peers = append(peers, raft.Peer{ID: peer.ID, Context: []byte(peer.peerAddress())})
 	}
+	activeConfig = cfg
+	atomic.StoreUint64(&snapshotThreshold, cfg.Tunables.SnapshotThreshold)
+	clearPendingRestartSettings()
 
-	// Create the actual Raft node with peers
-	raftNode = raft.StartNode(raftConfig, peers)
-	log.Printf("pgraft: INFO - Raft node created with %d initial peers", len(peers))
+	// Create the actual Raft node with peers, or restart it from storage;
+	// membership is then recovered from the log
+	if restarting {
+		raftNode = traceNode(raft.RestartNode(raftConfig), raftConfig, raftStorage, nil)
+		log.Printf("pgraft: INFO - Raft node restarted")
+	} else {
+		raftNode = traceNode(raft.StartNode(raftConfig, peers), raftConfig, raftStorage, peers)
+		log.Printf("pgraft: INFO - Raft node created with %d initial peers", len(peers))
+	}
 
 	// Initialize context but don't start background processing yet
 	raftCtx, raftCancel = context.WithCancel(context.Background())
 	log.Printf("pgraft: DEBUG - Context initialized, background processing deferred to PostgreSQL workers")
 
 	// Initialize applied and committed indices
-	appliedIndex = 0
-	committedIndex = 0
+	atomic.StoreUint64(&appliedIndex, restartApplied)
+	committedIndex = restartApplied
+	publishStatusSnapshot()
 
 	// Start network server for incoming connections
 	log.Printf("pgraft: DEBUG - About to start network server goroutine")
-	go startNetworkServer(C.GoString(address), int(port))
-	log.Printf("pgraft: INFO - Network server started on %s:%d", C.GoString(address), int(port))
+	goBackground(func() { startNetworkServer(cfg.Address, cfg.Port) })
+	log.Printf("pgraft: INFO - Network server started on %s", cfg.listenAddress())
 
 	// Load and connect to configured peers
 	go loadAndConnectToPeers()
@@ -362,34 +396,33 @@ func pgraft_go_init(nodeID C.int, address *C.char, port C.int) C.int {
 
 	// Start background processing automatically
 	log.Printf("pgraft: DEBUG - About to start Raft Ready processing goroutine")
-	go processRaftReady()
+	goBackground(processRaftReady)
 	log.Printf("pgraft: INFO - Raft Ready processing started")
 
 	// Start the ticker for Raft operations
 	log.Printf("pgraft: DEBUG - About to start Raft ticker")
-	raftTicker = time.NewTicker(100 * time.Millisecond)
-	go processRaftTicker()
+	raftTicker = time.NewTicker(cfg.tickInterval())
+	goBackground(processRaftTicker)
 	log.Printf("pgraft: INFO - Raft ticker started")
 
 	// Start message processing
 	log.Printf("pgraft: DEBUG - About to start message processing")
-	go processIncomingMessages()
+	goBackground(processIncomingMessages)
 	log.Printf("pgraft: INFO - Message processing started")
 
+	// Proposals queued in an attached ring while the node was down
+	resumeRingDrain()
+
 	log.Printf("pgraft: DEBUG - All Raft processing goroutines started successfully")
 
 	// Initialize metrics
-	atomic.StoreInt64(&messagesProcessed, 0)
-	atomic.StoreInt64(&logEntriesCommitted, 0)
-	atomic.StoreInt64(&heartbeatsSent, 0)
-	atomic.StoreInt64(&electionsTriggered, 0)
-	atomic.StoreInt64(&errorCount, 0)
+	resetNodeCounters()
 
 	startupTime = time.Now()
 	healthStatus = "initializing"
 
 	atomic.StoreInt32(&initialized, 1)
-	log.Printf("pgraft: INFO - Initialization completed successfully for node %d at %s:%d", nodeID, C.GoString(address), int(port))
+	log.Printf("pgraft: INFO - Initialization completed successfully for node %d at %s", cfg.NodeID, cfg.listenAddress())
 
 	log.Printf("pgraft: INFO - Returning success from initialization")
 	return 0
@@ -403,12 +436,15 @@ func pgraft_go_start_background() C.int {
 	defer raftMutex.Unlock()
 
 	// Start the background processing loop
-	go processRaftReady()
+	goBackground(processRaftReady)
 	debugLog("start_background: background processing started")
 
-	// Start the ticker for Raft operations
-	raftTicker = time.NewTicker(100 * time.Millisecond)
-	go processRaftTicker()
+	// Start the ticker for Raft operations, replacing any previous one
+	if raftTicker != nil {
+		raftTicker.Stop()
+	}
+	raftTicker = time.NewTicker(currentTickInterval())
+	goBackground(processRaftTicker)
 	debugLog("start_background: Raft ticker started")
 
 	debugLog("start_background: all background processing started")
@@ -459,7 +495,7 @@ func pgraft_go_add_peer(nodeID C.int, address *C.char, port C.int) C.int {
 		log.Printf("pgraft: proposing configuration change for node %d", nodeID)
 		if err := raftNode.ProposeConfChange(raftCtx, cc); err != nil {
 			log.Printf("pgraft: ERROR proposing configuration change: %v", err)
-			return -1
+			return errProposalDropped
 		}
 
 		log.Printf("pgraft: configuration change proposed successfully for node %d", nodeID)
@@ -485,7 +521,7 @@ func pgraft_go_remove_peer(nodeID C.int) C.int {
 	defer raftMutex.Unlock()
 
 	if atomic.LoadInt32(&running) == 0 {
-		return -1 // Not running
+		return errNotRunning
 	}
 
 	// Close connection
@@ -538,89 +574,72 @@ func pgraft_go_get_state() *C.char {
 }
 
 //export pgraft_go_get_leader
-func pgraft_go_get_leader() C.int64_t {
+func pgraft_go_get_leader() (result C.int64_t) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("pgraft: PANIC in pgraft_go_get_leader: %v", r)
+			result = -1
 		}
 	}()
 
-	log.Printf("pgraft: pgraft_go_get_leader called")
-
-	raftMutex.RLock()
-	defer raftMutex.RUnlock()
-
 	if atomic.LoadInt32(&running) == 0 {
-		log.Printf("pgraft: get_leader - not running")
+		debugLog("get_leader - not running")
 		return -1
 	}
 
-	if raftNode == nil {
-		log.Printf("pgraft: get_leader - raftNode is nil")
+	snapshot := loadStatusSnapshot()
+	if snapshot == nil {
+		debugLog("get_leader - no status snapshot yet")
 		return -1
 	}
 
-	status := raftNode.Status()
-	log.Printf("pgraft: get_leader - status.Lead=%d", status.Lead)
-	return C.int64_t(status.Lead)
+	return C.int64_t(snapshot.LeaderID)
 }
 
 //export pgraft_go_get_term
-func pgraft_go_get_term() C.int32_t {
+func pgraft_go_get_term() (result C.int32_t) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("pgraft: PANIC in pgraft_go_get_term: %v", r)
+			result = -1
 		}
 	}()
 
-	log.Printf("pgraft: pgraft_go_get_term called")
-
-	raftMutex.RLock()
-	defer raftMutex.RUnlock()
-
 	if atomic.LoadInt32(&running) == 0 {
-		log.Printf("pgraft: get_term - not running")
+		debugLog("get_term - not running")
 		return -1
 	}
 
-	if raftNode == nil {
-		log.Printf("pgraft: get_term - raftNode is nil")
+	snapshot := loadStatusSnapshot()
+	if snapshot == nil {
+		debugLog("get_term - no status snapshot yet")
 		return -1
 	}
 
-	status := raftNode.Status()
-	log.Printf("pgraft: get_term - returning term: %d", status.Term)
-	return C.int32_t(status.Term)
+	return C.int32_t(snapshot.Term)
 }
 
 //export pgraft_go_is_leader
-func pgraft_go_is_leader() C.int {
+func pgraft_go_is_leader() (result C.int) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("pgraft: PANIC in pgraft_go_is_leader: %v", r)
+			result = 0
 		}
 	}()
 
-	log.Printf("pgraft: pgraft_go_is_leader called")
-
-	raftMutex.RLock()
-	defer raftMutex.RUnlock()
-
 	if atomic.LoadInt32(&running) == 0 {
-		log.Printf("pgraft: is_leader - not running")
+		debugLog("is_leader - not running")
 		return 0
 	}
 
-	if raftNode == nil {
-		log.Printf("pgraft: is_leader - raftNode is nil")
+	snapshot := loadStatusSnapshot()
+	if snapshot == nil {
+		debugLog("is_leader - no status snapshot yet")
 		return 0
 	}
 
-	status := raftNode.Status()
-	isLeader := status.Lead == status.ID
-	log.Printf("pgraft: is_leader - status.ID=%d, status.Lead=%d, isLeader=%v", status.ID, status.Lead, isLeader)
-
-	if isLeader {
+	if snapshot.LeaderID != 0 && snapshot.LeaderID == snapshot.NodeID {
 		return 1
 	}
 	return 0
@@ -632,11 +651,18 @@ func pgraft_go_append_log(data *C.char, length C.int) C.int {
 	defer raftMutex.RUnlock()
 
 	if atomic.LoadInt32(&running) == 0 {
-		return -1
+		return errNotRunning
+	}
+	if rc := proposalGate(); rc != errOK {
+		return rc
 	}
 
 	// Convert C data to Go byte slice
 	goData := C.GoBytes(unsafe.Pointer(data), length)
+	if reservedPayload(goData) {
+		log.Printf("pgraft: ERROR - Payload begins with the prefix reserved for typed entries")
+		return errInvalidArgument
+	}
 
 	// Propose the data
 	raftNode.Propose(raftCtx, goData)
@@ -659,11 +685,19 @@ func pgraft_go_get_stats() *C.char {
 		"heartbeats_sent":       atomic.LoadInt64(&heartbeatsSent),
 		"elections_triggered":   atomic.LoadInt64(&electionsTriggered),
 		"error_count":           atomic.LoadInt64(&errorCount),
-		"applied_index":         appliedIndex,
+		"applied_index":         atomic.LoadUint64(&appliedIndex),
+		"durable_applied_index": atomic.LoadUint64(&durableApplied),
 		"committed_index":       committedIndex,
 		"uptime_seconds":        time.Since(startupTime).Seconds(),
 		"health_status":         healthStatus,
+		"maintenance":           maintenanceStats(),
 		"connected_nodes":       len(connections),
+		"ring_proposals":        atomic.LoadInt64(&ringProposals),
+		"ring_overflows":        atomic.LoadInt64(&ringOverflows),
+		"ring_corrupt_records":  atomic.LoadInt64(&ringCorruption),
+		"ring_rejected_records": atomic.LoadInt64(&ringRejected),
+		"commit_callback_calls": atomic.LoadInt64(&commitCallbackCalls),
+		"go_memory":             goMemoryStats(),
 	}
 
 	jsonData, err := json.Marshal(stats)
@@ -700,7 +734,7 @@ func pgraft_go_get_logs() *C.char {
 			"index":     entry.Index,
 			"term":      entry.Term,
 			"type":      entry.Type.String(),
-			"data":      string(entry.Data),
+			"data":      string(entryPayload(entry.Data)),
 			"committed": entry.Index <= committedIndex,
 		}
 
@@ -721,7 +755,7 @@ func pgraft_go_commit_log(index C.long) C.int {
 	defer raftMutex.RUnlock()
 
 	if atomic.LoadInt32(&running) == 0 {
-		return -1
+		return errNotRunning
 	}
 
 	// In etcd-io/raft, commits happen automatically
@@ -737,17 +771,20 @@ func pgraft_go_step_message(data *C.char, length C.int) C.int {
 	defer raftMutex.RUnlock()
 
 	if atomic.LoadInt32(&running) == 0 {
-		return -1
+		return errNotRunning
+	}
+	if rc := proposalGate(); rc != errOK {
+		return rc
 	}
 
 	// Convert C data to Go byte slice
 	goData := C.GoBytes(unsafe.Pointer(data), length)
 
 	// Parse as raftpb.Message
-	var msg raftpb.Message
-	if err := msg.Unmarshal(goData); err != nil {
+	msg, err := decodeMessage(goData)
+	if err != nil {
 		log.Printf("pgraft: failed to unmarshal message: %v", err)
-		return -1
+		return errInvalidArgument
 	}
 
 	// Step the message
@@ -862,21 +899,15 @@ func handleIncomingMessage(nodeID uint64, conn net.Conn) {
 	// Set read timeout
 	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
 
-	// Read message length first
-	var msgLen uint32
-	if err := readUint32(conn, &msgLen); err != nil {
+	// Read one length-prefixed message
+	msgData, err := readFrame(conn)
+	if err != nil {
 		return // No message or timeout
 	}
 
-	// Read message data
-	msgData := make([]byte, msgLen)
-	if _, err := conn.Read(msgData); err != nil {
-		return
-	}
-
 	// Parse as raftpb.Message
-	var msg raftpb.Message
-	if err := msg.Unmarshal(msgData); err != nil {
+	msg, err := decodeMessage(msgData)
+	if err != nil {
 		log.Printf("pgraft: failed to unmarshal incoming message: %v", err)
 		return
 	}
@@ -911,6 +942,9 @@ func processReady(rd raft.Ready) {
 	}
 
 	// 3. Apply committed entries to state machine
+	for _, entry := range rd.CommittedEntries {
+		markTrackedProposal(entry, proposalCommitted)
+	}
 	for _, entry := range rd.CommittedEntries {
 		processCommittedEntry(entry)
 	}
@@ -987,10 +1021,14 @@ func processCommittedEntry(entry raftpb.Entry) {
 		var cc raftpb.ConfChange
 		cc.Unmarshal(entry.Data)
 		raftNode.ApplyConfChange(cc)
+	} else if entry.Type == raftpb.EntryNormal && len(entry.Data) > 0 && !applyTypedEntry(entry) {
+		deliverCommittedEntry(entry.Index, entry.Term, entryPayload(entry.Data))
 	}
 
+	notifyEntryApplied(entry)
+
 	// Update applied index
-	appliedIndex = entry.Index
+	atomic.StoreUint64(&appliedIndex, entry.Index)
 
 	log.Printf("pgraft: applied entry %d, term %d, type %s",
 		entry.Index, entry.Term, entry.Type.String())
@@ -1004,6 +1042,7 @@ func startNetworkServer(address string, port int) {
 		return
 	}
 	defer listener.Close()
+	setNetworkListener(listener)
 
 	log.Printf("pgraft: INFO - Network server listening on %s:%d", address, port)
 
@@ -1028,7 +1067,7 @@ func startNetworkServer(address string, port int) {
 			}
 
 			// Handle incoming connection in a goroutine
-			go handleIncomingConnection(conn)
+			go handleIncomingConnection(wrapServerConn(conn))
 		}
 	}
 }
@@ -1070,23 +1109,17 @@ func handleConnectionMessages(nodeID uint64, conn net.Conn) {
 			// Set read timeout
 			conn.SetReadDeadline(time.Now().Add(30 * time.Second))
 
-			// Read message length
-			var msgLen uint32
-			if err := readUint32(conn, &msgLen); err != nil {
-				log.Printf("pgraft: WARNING - Failed to read message length from node %d: %v", nodeID, err)
-				return
-			}
-
-			// Read message data
-			data := make([]byte, msgLen)
-			if _, err := conn.Read(data); err != nil {
-				log.Printf("pgraft: WARNING - Failed to read message data from node %d: %v", nodeID, err)
+			// Read one length-prefixed message; a corrupt length
+			// leaves the stream unusable, so the connection is dropped
+			data, err := readFrame(conn)
+			if err != nil {
+				log.Printf("pgraft: WARNING - Failed to read message from node %d: %v", nodeID, err)
 				return
 			}
 
 			// Process message
-			var msg raftpb.Message
-			if err := msg.Unmarshal(data); err != nil {
+			msg, err := decodeMessage(data)
+			if err != nil {
 				log.Printf("pgraft: WARNING - Failed to unmarshal message from node %d: %v", nodeID, err)
 				continue
 			}
@@ -1094,11 +1127,7 @@ func handleConnectionMessages(nodeID uint64, conn net.Conn) {
 			log.Printf("pgraft: DEBUG - Received message from node %d: type=%s, term=%d", nodeID, msg.Type.String(), msg.Term)
 
 			// Send message to Raft node
-			select {
-			case messageChan <- msg:
-			default:
-				log.Printf("pgraft: WARNING - Message channel full, dropping message from node %d", nodeID)
-			}
+			receiveMessage(msg)
 		}
 	}
 }
@@ -1107,6 +1136,16 @@ func handleConnectionMessages(nodeID uint64, conn net.Conn) {
 func loadAndConnectToPeers() {
 	log.Printf("pgraft: INFO - Starting peer discovery process")
 
+	// Peers from an inline configuration carry their real node IDs and
+	// need no configuration file
+	if activeConfig != nil && activeConfig.inline {
+		for _, peer := range activeConfig.Peers {
+			go establishConnectionWithRetry(peer.ID, peer.peerAddress())
+		}
+		log.Printf("pgraft: INFO - Connecting to %d peers from inline configuration", len(activeConfig.Peers))
+		return
+	}
+
 	// Start peer discovery in a separate goroutine to avoid blocking
 	go func() {
 		defer func() {
@@ -1213,6 +1252,7 @@ func connectToPeer(nodeID uint64, peerAddr string) error {
 	if err != nil {
 		return fmt.Errorf("failed to dial %s: %v", peerAddr, err)
 	}
+	conn = wrapClientConn(conn, peerAddr)
 
 	// Send node ID first
 	if err := writeUint32(conn, uint32(nodeID)); err != nil {
@@ -1338,6 +1378,10 @@ func pgraft_go_replicate_log_entry(data *C.char, dataLen C.int) C.int {
 
 	// Convert C data to Go
 	goData := C.GoBytes(unsafe.Pointer(data), dataLen)
+	if reservedPayload(goData) {
+		log.Printf("pgraft: ERROR - Payload begins with the prefix reserved for typed entries")
+		return C.int(0)
+	}
 
 	// Propose the log entry for replication
 	ctx, cancel := context.WithTimeout(raftCtx, 5*time.Second)
@@ -1367,7 +1411,7 @@ func pgraft_go_get_replication_status() *C.char {
 		"replication_lag_ms":  replicationState.replicationLag.Milliseconds(),
 		"is_leader":           pgraft_go_get_leader() != 0,
 		"committed_index":     committedIndex,
-		"applied_index":       appliedIndex,
+		"applied_index":       atomic.LoadUint64(&appliedIndex),
 	}
 
 	jsonData, err := json.Marshal(status)
@@ -1382,17 +1426,15 @@ func pgraft_go_get_replication_status() *C.char {
 //export pgraft_go_create_snapshot
 func pgraft_go_create_snapshot() *C.char {
 	raftMutex.RLock()
-	defer raftMutex.RUnlock()
+	node, storage := raftNode, raftStorage
+	raftMutex.RUnlock()
 
-	if raftNode == nil {
+	if node == nil {
 		return C.CString("")
 	}
 
-	// Create snapshot using etcd-io/raft
-	snapshot, err := raftStorage.CreateSnapshot(committedIndex, &raftpb.ConfState{
-		Voters: getClusterNodes(),
-	}, []byte("pgraft_snapshot_data"))
-
+	// Snapshot the replicated services at the applied index
+	snapshot, err := createServiceSnapshot(storage)
 	if err != nil {
 		recordError(errors.New(fmt.Sprintf("failed to create snapshot: %v", err)))
 		return C.CString("")
@@ -1423,35 +1465,30 @@ func pgraft_go_create_snapshot() *C.char {
 //export pgraft_go_apply_snapshot
 func pgraft_go_apply_snapshot(snapshotData *C.char) C.int {
 	raftMutex.RLock()
-	defer raftMutex.RUnlock()
+	node, storage := raftNode, raftStorage
+	raftMutex.RUnlock()
 
-	if raftNode == nil {
+	if node == nil {
 		return C.int(0)
 	}
 
 	// Parse snapshot data
-	var snapshotInfo map[string]interface{}
-	err := json.Unmarshal([]byte(C.GoString(snapshotData)), &snapshotInfo)
+	snapshot, err := parseSnapshotJSON([]byte(C.GoString(snapshotData)))
 	if err != nil {
 		recordError(errors.New(fmt.Sprintf("failed to parse snapshot data: %v", err)))
 		return C.int(0)
 	}
 
-	// Create snapshot from data
-	snapshot := raftpb.Snapshot{
-		Data: []byte(snapshotInfo["data"].(string)),
-		Metadata: raftpb.SnapshotMetadata{
-			Index: uint64(snapshotInfo["index"].(float64)),
-			Term:  uint64(snapshotInfo["term"].(float64)),
-		},
-	}
-
 	// Apply snapshot to storage
-	err = raftStorage.ApplySnapshot(snapshot)
+	err = storage.ApplySnapshot(snapshot)
 	if err != nil {
 		recordError(errors.New(fmt.Sprintf("failed to apply snapshot: %v", err)))
 		return C.int(0)
 	}
+	if err := restoreServiceSnapshot(snapshot); err != nil {
+		recordError(errors.New(fmt.Sprintf("failed to restore snapshot data: %v", err)))
+		return C.int(0)
+	}
 
 	// Update replication state
 	replicationState.replicationMutex.Lock()
@@ -1534,7 +1571,7 @@ func pgraft_go_sync_replication() C.int {
 		for _, entry := range rd.CommittedEntries {
 			if entry.Type == raftpb.EntryNormal {
 				// Apply the entry to state machine
-				appliedIndex = entry.Index
+				atomic.StoreUint64(&appliedIndex, entry.Index)
 				replicationState.replicationMutex.Lock()
 				replicationState.lastAppliedIndex = entry.Index
 				replicationState.replicationMutex.Unlock()
@@ -1583,6 +1620,9 @@ func processRaftReady() {
 			log.Printf("pgraft: processRaftReady stopping")
 			return
 		case rd := <-raftNode.Ready():
+			if readyLoopCrashed() {
+				return
+			}
 			log.Printf("pgraft: DEBUG - Processing Raft Ready message")
 
 			// Save to storage
@@ -1604,6 +1644,16 @@ func processRaftReady() {
 				}
 			}
 
+			// A snapshot sent by the leader to a follower too far behind
+			// replaces its log and services up to the snapshot's index
+			if !raft.IsEmptySnap(rd.Snapshot) {
+				if err := raftStorage.ApplySnapshot(rd.Snapshot); err != nil {
+					log.Printf("pgraft: ERROR - Failed to apply snapshot at index %d: %v", rd.Snapshot.Metadata.Index, err)
+				} else if err := restoreServiceSnapshot(rd.Snapshot); err != nil {
+					log.Printf("pgraft: ERROR - Failed to restore the replicated services: %v", err)
+				}
+			}
+
 			// Save entries
 			if len(rd.Entries) > 0 {
 				log.Printf("pgraft: DEBUG - Saving %d entries", len(rd.Entries))
@@ -1612,6 +1662,10 @@ func processRaftReady() {
 			}
 
 			// Process committed entries
+			for _, entry := range rd.CommittedEntries {
+				markTrackedProposal(entry, proposalCommitted)
+			}
+			applyMutex.Lock()
 			for _, entry := range rd.CommittedEntries {
 				if entry.Type == raftpb.EntryConfChange {
 					log.Printf("pgraft: processing configuration change")
@@ -1626,13 +1680,26 @@ func processRaftReady() {
 						log.Printf("pgraft: removing node %d", cc.NodeID)
 						raftNode.ApplyConfChange(cc)
 					}
+					emitRaftEvent(eventMembership, entry.Index, entry.Term, []byte(cc.String()))
+					notifyEntryApplied(entry)
 				} else if entry.Type == raftpb.EntryNormal && len(entry.Data) > 0 {
 					log.Printf("pgraft: processing normal entry: %s", string(entry.Data))
 					// Process normal log entry
 					committedIndex = entry.Index
 					atomic.StoreInt64(&logEntriesCommitted, int64(entry.Index))
+					if !applyTypedEntry(entry) {
+						payload := entryPayload(entry.Data)
+						publishAppliedEntry(entry.Index, entry.Term, payload)
+						deliverCommittedEntry(entry.Index, entry.Term, payload)
+					}
+					notifyEntryApplied(entry)
+					emitRaftEvent(eventCommitted, entry.Index, entry.Term, nil)
 				}
+
+				// Readers waiting on a consistency token poll this
+				atomic.StoreUint64(&appliedIndex, entry.Index)
 			}
+			applyMutex.Unlock()
 
 			// Send messages to peers
 			for _, msg := range rd.Messages {
@@ -1656,15 +1723,21 @@ func processRaftReady() {
 				// Update shared memory cluster state
 				stateStr := raft.StateType(rd.SoftState.RaftState).String()
 				updateSharedMemoryClusterState(int64(rd.SoftState.Lead), int64(hs.Term), stateStr)
+				emitRaftEvent(eventState, rd.SoftState.Lead, hs.Term, []byte(stateStr))
+				if atomic.SwapUint64(&lastEventLeader, rd.SoftState.Lead) != rd.SoftState.Lead {
+					emitRaftEvent(eventLeader, rd.SoftState.Lead, hs.Term, nil)
+				}
 
 				if rd.SoftState.Lead != 0 {
 					log.Printf("pgraft: leader elected: %d", rd.SoftState.Lead)
-					atomic.StoreInt64(&electionsTriggered, atomic.LoadInt64(&electionsTriggered)+1)
+					atomic.AddInt64(&electionsTriggered, 1)
 				}
 			}
 
 			// Advance the node
 			raftNode.Advance()
+
+			publishStatusSnapshot()
 		}
 	}
 }
@@ -1682,6 +1755,17 @@ func processRaftTicker() {
 			if raftNode != nil {
 				// Tick the Raft node (this triggers elections, heartbeats, etc.)
 				raftNode.Tick()
+				publishStatusSnapshot()
+				recordCommitSample()
+				refreshSyncStandbys(false)
+				maybeTransferForLSN()
+				evaluateFencing()
+				evaluateLeadership()
+				syncSlotPositions()
+				grantRestartSlots()
+				electBackupNode()
+				publishHealthTransitions()
+				maybeAutoSnapshot()
 
 				// Check for ready messages
 				select {
@@ -1708,37 +1792,9 @@ func getCurrentTerm() uint64 {
 func sendMessage(msg raftpb.Message) {
 	log.Printf("pgraft: DEBUG - Sending message to node %d: type=%s", msg.To, msg.Type)
 
-	// Get connection to peer
-	connMutex.Lock()
-	conn, exists := connections[msg.To]
-	connMutex.Unlock()
-
-	if !exists {
-		log.Printf("pgraft: WARNING - No connection to peer %d", msg.To)
-		return
-	}
-
-	// Serialize message
-	data, err := msg.Marshal()
-	if err != nil {
-		log.Printf("pgraft: ERROR - Failed to marshal message: %v", err)
-		return
-	}
-
-	// Send message length first
-	if err := writeUint32(conn, uint32(len(data))); err != nil {
-		log.Printf("pgraft: ERROR - Failed to send message length: %v", err)
-		return
+	if err := peerTransport.Send(msg); err != nil {
+		log.Printf("pgraft: WARNING - %v", err)
 	}
-
-	// Send message data
-	if _, err := conn.Write(data); err != nil {
-		log.Printf("pgraft: ERROR - Failed to send message data: %v", err)
-		return
-	}
-
-	log.Printf("pgraft: DEBUG - Message sent successfully to node %d", msg.To)
-	atomic.AddInt64(&messagesProcessed, 1)
 }
 
 // processIncomingMessages processes messages from the message channel
@@ -0,0 +1,146 @@
+/*
+ * pgraft_go_api.go
+ * ABI version and capability negotiation
+ *
+ * The C extension calls pgraft_go_api_version() right after dlopen() and
+ * refuses to run if the major version differs from the one it was built
+ * against.  The minor version grows whenever exports are added; individual
+ * optional features are advertised as capability bits so the extension
+ * can enable them only when the loaded library provides them.
+ */
+
+package main
+
+/*
+#include <stdint.h>
+*/
+import "C"
+
+// Must match PGRAFT_GO_API_MAJOR/MINOR in pgraft_go.h
+const (
+	apiVersionMajor = 1
+	apiVersionMinor = 39
+)
+
+// Capability bits, mirrored by PGRAFT_CAP_* in pgraft_go.h
+const (
+	capLogCallback       = 1 << 0
+	capMetricsSnapshot   = 1 << 1
+	capHandles           = 1 << 2
+	capSharedRing        = 1 << 3
+	capEventQueue        = 1 << 4
+	capSummary           = 1 << 5
+	capMemoryTuning      = 1 << 6
+	capInitJSON          = 1 << 7
+	capFinalize          = 1 << 8
+	capRestart           = 1 << 9
+	capCursor            = 1 << 10
+	capCommitCallback    = 1 << 11
+	capAsync             = 1 << 12
+	capErrorTable        = 1 << 13
+	capTrackedProposals  = 1 << 14
+	capSelftest          = 1 << 15
+	capValidateConfig    = 1 << 16
+	capSyncStandbys      = 1 << 17
+	capRoleHooks         = 1 << 18
+	capLSNFailover       = 1 << 19
+	capSwitchover        = 1 << 20
+	capFencing           = 1 << 21
+	capDDL               = 1 << 22
+	capLocks             = 1 << 23
+	capKV                = 1 << 24
+	capBarrier           = 1 << 25
+	capSlots             = 1 << 26
+	capBootstrap         = 1 << 27
+	capRewind            = 1 << 28
+	capTimelines         = 1 << 29
+	capMaintenance       = 1 << 30
+	capTags              = 1 << 31
+	capSettings          = 1 << 32
+	capDurableApplied    = 1 << 33
+	capArchive           = 1 << 34
+	capSequences         = 1 << 35
+	capParameters        = 1 << 36
+	capStaleness         = 1 << 37
+	capRestarts          = 1 << 38
+	capBackupNode        = 1 << 39
+	capConsistencyTokens = 1 << 40
+	capEventSocket       = 1 << 41
+	capFaultInjection    = 1 << 42
+	capTrace             = 1 << 43
+)
+
+func apiCapabilities() uint64 {
+	return capLogCallback |
+		capMetricsSnapshot |
+		capHandles |
+		capSharedRing |
+		capEventQueue |
+		capSummary |
+		capMemoryTuning |
+		capInitJSON |
+		capFinalize |
+		capRestart |
+		capCursor |
+		capCommitCallback |
+		capAsync |
+		capErrorTable |
+		capTrackedProposals |
+		capSelftest |
+		capValidateConfig |
+		capSyncStandbys |
+		capRoleHooks |
+		capLSNFailover |
+		capSwitchover |
+		capFencing |
+		capDDL |
+		capLocks |
+		capKV |
+		capBarrier |
+		capSlots |
+		capBootstrap |
+		capRewind |
+		capTimelines |
+		capMaintenance |
+		capTags |
+		capSettings |
+		capDurableApplied |
+		capArchive |
+		capSequences |
+		capParameters |
+		capStaleness |
+		capRestarts |
+		capBackupNode |
+		capConsistencyTokens |
+		capEventSocket |
+		faultCapabilities |
+		capTrace
+}
+
+// Report the library API version and capability bits; any pointer may be
+// NULL
+//
+//export pgraft_go_api_version
+func pgraft_go_api_version(major *C.int, minor *C.int, capabilities *C.uint64_t) C.int {
+	if major != nil {
+		*major = apiVersionMajor
+	}
+	if minor != nil {
+		*minor = apiVersionMinor
+	}
+	if capabilities != nil {
+		*capabilities = C.uint64_t(apiCapabilities())
+	}
+	return 0
+}
+
+// Returns 1 if this library can serve a caller built against the given
+// API version: same major version and at least the requested minor
+//
+//export pgraft_go_api_compatible
+func pgraft_go_api_compatible(major C.int, minor C.int) C.int {
+	if major == apiVersionMajor && minor <= apiVersionMinor {
+		return 1
+	}
+	return 0
+}
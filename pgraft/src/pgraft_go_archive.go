@@ -0,0 +1,216 @@
+/*
+ * pgraft_go_archive.go
+ * Archiving of the raft log and snapshots before compaction
+ *
+ * With an archive callback registered, the log is handled like WAL under
+ * archive_command: entries are only compacted once they have been
+ * archived successfully.  pgraft_go_run_archiver(), called from the
+ * extension's worker loop, hands the callback segments of up to
+ * archiveSegmentEntries durable entries and then every new snapshot, on
+ * the calling thread.  A segment is a sequence of entries, each a 4-byte
+ * big-endian length followed by the protobuf-encoded raftpb.Entry; a
+ * snapshot is the protobuf-encoded raftpb.Snapshot.  The callback returns
+ * 0 once the data is stored safely; any other result leaves it to be
+ * offered again on the next call.  pgraft-admin inspect reads both forms
+ * from the files the callback wrote.
+ */
+
+package main
+
+/*
+#include <stdint.h>
+#include <stdlib.h>
+
+typedef int (*pgraft_go_archive_callback) (const char *kind, uint64_t first_index, uint64_t last_index, const char *data, int length, void *arg);
+
+static inline int
+pgraft_go_call_archive_callback(pgraft_go_archive_callback cb, const char *kind, uint64_t first_index, uint64_t last_index, const char *data, int length, void *arg)
+{
+	return cb(kind, first_index, last_index, data, length, arg);
+}
+*/
+import "C"
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"log"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// Maximum number of entries handed to the callback at once
+const archiveSegmentEntries = 10000
+
+var (
+	archiveCallback    C.pgraft_go_archive_callback
+	archiveCallbackArg unsafe.Pointer
+
+	// Last log index and snapshot index archived successfully
+	archivedThrough  uint64
+	archivedSnapshot uint64
+
+	archiveFailures    int64
+	archiveLastFailure time.Time
+	archiveMutex       sync.Mutex
+
+	// Only one archiver runs at a time
+	archiverMutex sync.Mutex
+)
+
+// Last archived index, and whether archiving bounds compaction
+func archiveLimit() (uint64, bool) {
+	archiveMutex.Lock()
+	defer archiveMutex.Unlock()
+	return archivedThrough, archiveCallback != nil
+}
+
+func resetArchive() {
+	archiveMutex.Lock()
+	archiveCallback = nil
+	archiveCallbackArg = nil
+	archivedThrough = 0
+	archivedSnapshot = 0
+	archiveFailures = 0
+	archiveLastFailure = time.Time{}
+	archiveMutex.Unlock()
+}
+
+// Hand one piece of data to the callback; false if it was not archived
+func archiveData(callback C.pgraft_go_archive_callback, arg unsafe.Pointer, kind string, first, last uint64, data []byte) bool {
+	cKind := C.CString(kind)
+	defer C.free(unsafe.Pointer(cKind))
+	cData := C.CBytes(data)
+	defer C.free(cData)
+
+	rc := C.pgraft_go_call_archive_callback(callback, cKind, C.uint64_t(first), C.uint64_t(last),
+		(*C.char)(cData), C.int(len(data)), arg)
+	if rc == 0 {
+		return true
+	}
+
+	archiveMutex.Lock()
+	archiveFailures++
+	archiveLastFailure = time.Now()
+	archiveMutex.Unlock()
+	log.Printf("pgraft: WARNING - Archiving %s %d-%d failed with %d, will retry", kind, first, last, int(rc))
+	return false
+}
+
+// Encode entries as length-prefixed protobuf records
+func encodeArchiveSegment(first, last uint64) ([]byte, uint64, error) {
+	entries, err := raftStorage.Entries(first, last+1, math.MaxUint64)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var segment []byte
+	var length [4]byte
+	for _, entry := range entries {
+		encoded, err := entry.Marshal()
+		if err != nil {
+			return nil, 0, err
+		}
+		binary.BigEndian.PutUint32(length[:], uint32(len(encoded)))
+		segment = append(segment, length[:]...)
+		segment = append(segment, encoded...)
+	}
+	return segment, entries[len(entries)-1].Index, nil
+}
+
+// Archive pending log segments and snapshots on the calling thread;
+// returns the number archived, or an error code if one failed
+//
+//export pgraft_go_run_archiver
+func pgraft_go_run_archiver() C.int {
+	archiverMutex.Lock()
+	defer archiverMutex.Unlock()
+
+	archiveMutex.Lock()
+	callback, arg := archiveCallback, archiveCallbackArg
+	next := archivedThrough + 1
+	lastSnapshot := archivedSnapshot
+	archiveMutex.Unlock()
+	storage := raftStorage
+	if callback == nil || storage == nil {
+		return 0
+	}
+
+	archived := 0
+	if firstIndex, err := storage.FirstIndex(); err == nil && next < firstIndex {
+		log.Printf("pgraft: WARNING - Entries %d-%d were compacted before archiving", next, firstIndex-1)
+		next = firstIndex
+	}
+	for limit := durableLimit(atomic.LoadUint64(&appliedIndex)); next <= limit; {
+		last := next + archiveSegmentEntries - 1
+		if last > limit {
+			last = limit
+		}
+		segment, last, err := encodeArchiveSegment(next, last)
+		if err != nil {
+			log.Printf("pgraft: ERROR - Cannot read entries %d-%d for archiving: %v", next, last, err)
+			return errStorage
+		}
+		if !archiveData(callback, arg, "log", next, last, segment) {
+			return errFailed
+		}
+		archiveMutex.Lock()
+		archivedThrough = last
+		archiveMutex.Unlock()
+		archived++
+		next = last + 1
+	}
+
+	snapshot, err := storage.Snapshot()
+	if err == nil && snapshot.Metadata.Index > lastSnapshot {
+		encoded, err := snapshot.Marshal()
+		if err != nil {
+			return errStorage
+		}
+		if !archiveData(callback, arg, "snapshot", snapshot.Metadata.Index, snapshot.Metadata.Index, encoded) {
+			return errFailed
+		}
+		archiveMutex.Lock()
+		archivedSnapshot = snapshot.Metadata.Index
+		archiveMutex.Unlock()
+		archived++
+	}
+	return C.int(archived)
+}
+
+// Register the archive callback; while set, compaction waits for entries
+// to be archived.  NULL disables archiving
+//
+//export pgraft_go_set_archive_callback
+func pgraft_go_set_archive_callback(callback C.pgraft_go_archive_callback, arg unsafe.Pointer) {
+	archiveMutex.Lock()
+	archiveCallback = callback
+	archiveCallbackArg = arg
+	archiveMutex.Unlock()
+}
+
+// Archiving progress as JSON; free with pgraft_go_free_string()
+//
+//export pgraft_go_archive_status
+func pgraft_go_archive_status() *C.char {
+	archiveMutex.Lock()
+	status := map[string]interface{}{
+		"enabled":           archiveCallback != nil,
+		"archived_through":  archivedThrough,
+		"archived_snapshot": archivedSnapshot,
+		"failures":          archiveFailures,
+	}
+	if !archiveLastFailure.IsZero() {
+		status["last_failure"] = archiveLastFailure.UTC().Format(time.RFC3339)
+	}
+	archiveMutex.Unlock()
+
+	jsonData, err := json.Marshal(status)
+	if err != nil {
+		return C.CString("{\"error\": \"failed to marshal archive status\"}")
+	}
+	return C.CString(string(jsonData))
+}
@@ -0,0 +1,441 @@
+/*
+ * pgraft_go_async.go
+ * Asynchronous operations and their completion queue
+ *
+ * The *_async exports return a ticket immediately and never block the
+ * caller on raft.  When the operation finishes, a completion carrying the
+ * ticket is queued: a proposal completes once its entry is applied, a
+ * configuration change once it is applied, and a snapshot once it has
+ * been taken.  Operations that do not finish within asyncOpTimeout
+ * complete with PGRAFT_COMPLETION_TIMEOUT.  Background workers either poll
+ * the queue with pgraft_go_poll_completions() or register a callback that
+ * pgraft_go_drain_completions() invokes on the calling thread.  Each
+ * completion also raises PGRAFT_EVENT_COMPLETION on the event queue so a
+ * worker waiting on the wakeup fd notices it.
+ *
+ * A proposal carries its ticket in a proposal envelope (see
+ * pgraft_go_typed.go) and completes when the entry with that ticket is
+ * applied, whatever else is in flight with the same payload.
+ */
+
+package main
+
+/*
+#include <stdint.h>
+#include <stdlib.h>
+
+typedef struct pgraft_go_completion
+{
+	uint64_t	ticket;
+	int32_t		op;
+	int32_t		status;
+	uint64_t	index;
+	uint64_t	term;
+} pgraft_go_completion;
+
+typedef void (*pgraft_go_completion_callback) (const pgraft_go_completion *completion, void *arg);
+
+static inline void
+pgraft_go_call_completion_callback(pgraft_go_completion_callback cb, const pgraft_go_completion *completion, void *arg)
+{
+	cb(completion, arg);
+}
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+// Operation kinds, mirrored by PGRAFT_OP_* in pgraft_go.h
+const (
+	asyncOpPropose    = 1
+	asyncOpAddPeer    = 2
+	asyncOpRemovePeer = 3
+	asyncOpSnapshot   = 4
+)
+
+// Completion statuses, mirrored by PGRAFT_COMPLETION_* in pgraft_go.h
+const (
+	completionOK      = 0
+	completionFailed  = -1
+	completionTimeout = -2
+)
+
+// How long a proposal or configuration change may stay pending
+const asyncOpTimeout = 30 * time.Second
+
+type asyncCompletion struct {
+	ticket uint64
+	op     int
+	status int
+	index  uint64
+	term   uint64
+}
+
+type pendingOp struct {
+	ticket   uint64
+	op       int
+	deadline time.Time
+}
+
+var (
+	nextTicketID uint64
+
+	completionQueue []asyncCompletion
+	completionMutex sync.Mutex
+
+	completionCallback    C.pgraft_go_completion_callback
+	completionCallbackArg unsafe.Pointer
+
+	// Pending operations keyed by proposal ticket or conf change
+	pendingOps      = make(map[string][]*pendingOp)
+	pendingOpsMutex sync.Mutex
+)
+
+// Tickets are also the proposal IDs of the proposal envelopes this node
+// proposes, tracked proposals included, so no two are ever the same
+func newTicket() uint64 {
+	return atomic.AddUint64(&nextTicketID, 1)
+}
+
+func confChangeKey(changeType raftpb.ConfChangeType, nodeID uint64) string {
+	return fmt.Sprintf("cc/%d/%d", changeType, nodeID)
+}
+
+func proposalKey(ticket uint64) string {
+	return fmt.Sprintf("p/%d", ticket)
+}
+
+func completeAsyncOp(ticket uint64, op int, status int, index uint64, term uint64) {
+	completionMutex.Lock()
+	completionQueue = append(completionQueue, asyncCompletion{
+		ticket: ticket,
+		op:     op,
+		status: status,
+		index:  index,
+		term:   term,
+	})
+	completionMutex.Unlock()
+
+	emitRaftEvent(eventCompletion, ticket, term, nil)
+}
+
+func addPendingOp(key string, ticket uint64, op int) {
+	pendingOpsMutex.Lock()
+	pendingOps[key] = append(pendingOps[key], &pendingOp{
+		ticket:   ticket,
+		op:       op,
+		deadline: time.Now().Add(asyncOpTimeout),
+	})
+	pendingOpsMutex.Unlock()
+}
+
+// Remove and return the oldest pending operation for key
+func takePendingOp(key string) *pendingOp {
+	pendingOpsMutex.Lock()
+	defer pendingOpsMutex.Unlock()
+
+	queue := pendingOps[key]
+	if len(queue) == 0 {
+		return nil
+	}
+	pending := queue[0]
+	if len(queue) == 1 {
+		delete(pendingOps, key)
+	} else {
+		pendingOps[key] = queue[1:]
+	}
+	return pending
+}
+
+// Drop the pending operation for ticket after it failed to start
+func removePendingOp(key string, ticket uint64) {
+	pendingOpsMutex.Lock()
+	defer pendingOpsMutex.Unlock()
+
+	queue := pendingOps[key]
+	for i, pending := range queue {
+		if pending.ticket == ticket {
+			queue = append(queue[:i], queue[i+1:]...)
+			break
+		}
+	}
+	if len(queue) == 0 {
+		delete(pendingOps, key)
+	} else {
+		pendingOps[key] = queue
+	}
+}
+
+// Complete operations that waited longer than asyncOpTimeout
+func expirePendingOps() {
+	now := time.Now()
+	var expired []*pendingOp
+
+	pendingOpsMutex.Lock()
+	for key, queue := range pendingOps {
+		kept := queue[:0]
+		for _, pending := range queue {
+			if now.After(pending.deadline) {
+				expired = append(expired, pending)
+			} else {
+				kept = append(kept, pending)
+			}
+		}
+		if len(kept) == 0 {
+			delete(pendingOps, key)
+		} else {
+			pendingOps[key] = kept
+		}
+	}
+	pendingOpsMutex.Unlock()
+
+	for _, pending := range expired {
+		log.Printf("pgraft: WARNING - Async operation %d timed out", pending.ticket)
+		completeAsyncOp(pending.ticket, pending.op, completionTimeout, 0, 0)
+	}
+}
+
+// Called from the apply path for every committed entry
+func notifyEntryApplied(entry raftpb.Entry) {
+	markTrackedProposal(entry, proposalApplied)
+
+	var key string
+	switch entry.Type {
+	case raftpb.EntryNormal:
+		ticket := localProposalID(entry)
+		if ticket == 0 {
+			return
+		}
+		key = proposalKey(ticket)
+	case raftpb.EntryConfChange:
+		var cc raftpb.ConfChange
+		if err := cc.Unmarshal(entry.Data); err != nil {
+			return
+		}
+		key = confChangeKey(cc.Type, cc.NodeID)
+	default:
+		return
+	}
+
+	if pending := takePendingOp(key); pending != nil {
+		completeAsyncOp(pending.ticket, pending.op, completionOK, entry.Index, entry.Term)
+	}
+}
+
+func resetAsyncOps() {
+	pendingOpsMutex.Lock()
+	pendingOps = make(map[string][]*pendingOp)
+	pendingOpsMutex.Unlock()
+
+	completionMutex.Lock()
+	completionQueue = nil
+	completionCallback = nil
+	completionCallbackArg = nil
+	completionMutex.Unlock()
+}
+
+// Propose data without waiting; returns a ticket, or 0 if the node is not
+// running or not accepting proposals or the data begins with the prefix
+// reserved for typed entries
+//
+//export pgraft_go_propose_async
+func pgraft_go_propose_async(data *C.char, length C.int) C.uint64_t {
+	if atomic.LoadInt32(&running) == 0 || data == nil || length <= 0 || proposalGate() != errOK {
+		return 0
+	}
+
+	goData := C.GoBytes(unsafe.Pointer(data), length)
+	if reservedPayload(goData) {
+		log.Printf("pgraft: ERROR - Async proposal begins with the prefix reserved for typed entries")
+		return 0
+	}
+	ticket := newTicket()
+	key := proposalKey(ticket)
+	addPendingOp(key, ticket, asyncOpPropose)
+
+	go func() {
+		raftMutex.RLock()
+		node, ctx := raftNode, raftCtx
+		raftMutex.RUnlock()
+
+		if node == nil {
+			removePendingOp(key, ticket)
+			completeAsyncOp(ticket, asyncOpPropose, completionFailed, 0, 0)
+			return
+		}
+		if err := node.Propose(ctx, encodeProposalEntry(ticket, goData)); err != nil {
+			log.Printf("pgraft: ERROR - Async proposal %d failed: %v", ticket, err)
+			removePendingOp(key, ticket)
+			completeAsyncOp(ticket, asyncOpPropose, completionFailed, 0, 0)
+		}
+	}()
+
+	return C.uint64_t(ticket)
+}
+
+// Add a peer without waiting; completes when the change is applied
+//
+//export pgraft_go_add_peer_async
+func pgraft_go_add_peer_async(nodeID C.int, address *C.char, port C.int) C.uint64_t {
+	if atomic.LoadInt32(&initialized) == 0 || address == nil || nodeID <= 0 {
+		return 0
+	}
+
+	// The caller's string is only valid until we return
+	addressCopy := C.CString(C.GoString(address))
+	ticket := newTicket()
+	key := confChangeKey(raftpb.ConfChangeAddNode, uint64(nodeID))
+	addPendingOp(key, ticket, asyncOpAddPeer)
+
+	go func() {
+		defer C.free(unsafe.Pointer(addressCopy))
+		if pgraft_go_add_peer(nodeID, addressCopy, port) != 0 {
+			removePendingOp(key, ticket)
+			completeAsyncOp(ticket, asyncOpAddPeer, completionFailed, 0, 0)
+		}
+	}()
+
+	return C.uint64_t(ticket)
+}
+
+// Remove a peer without waiting; completes when the change is applied
+//
+//export pgraft_go_remove_peer_async
+func pgraft_go_remove_peer_async(nodeID C.int) C.uint64_t {
+	if atomic.LoadInt32(&running) == 0 || nodeID <= 0 {
+		return 0
+	}
+
+	ticket := newTicket()
+	key := confChangeKey(raftpb.ConfChangeRemoveNode, uint64(nodeID))
+	addPendingOp(key, ticket, asyncOpRemovePeer)
+
+	go func() {
+		if pgraft_go_remove_peer(nodeID) != 0 {
+			removePendingOp(key, ticket)
+			completeAsyncOp(ticket, asyncOpRemovePeer, completionFailed, 0, 0)
+		}
+	}()
+
+	return C.uint64_t(ticket)
+}
+
+// Take a snapshot without waiting; completes with the snapshot index
+//
+//export pgraft_go_create_snapshot_async
+func pgraft_go_create_snapshot_async() C.uint64_t {
+	if atomic.LoadInt32(&initialized) == 0 {
+		return 0
+	}
+
+	ticket := newTicket()
+
+	go func() {
+		result := pgraft_go_create_snapshot()
+		resultJSON := C.GoString(result)
+		pgraft_go_free_string(result)
+
+		var snapshot struct {
+			Index uint64 `json:"index"`
+			Term  uint64 `json:"term"`
+		}
+		if resultJSON == "" || json.Unmarshal([]byte(resultJSON), &snapshot) != nil {
+			completeAsyncOp(ticket, asyncOpSnapshot, completionFailed, 0, 0)
+			return
+		}
+		completeAsyncOp(ticket, asyncOpSnapshot, completionOK, snapshot.Index, snapshot.Term)
+	}()
+
+	return C.uint64_t(ticket)
+}
+
+// Copy up to max queued completions into out; returns the number copied
+//
+//export pgraft_go_poll_completions
+func pgraft_go_poll_completions(out *C.pgraft_go_completion, max C.int) C.int {
+	if out == nil || max <= 0 {
+		return 0
+	}
+
+	expirePendingOps()
+
+	completionMutex.Lock()
+	defer completionMutex.Unlock()
+
+	n := len(completionQueue)
+	if n > int(max) {
+		n = int(max)
+	}
+
+	results := unsafe.Slice(out, n)
+	for i := 0; i < n; i++ {
+		fillCompletion(&results[i], completionQueue[i])
+	}
+	completionQueue = completionQueue[n:]
+
+	return C.int(n)
+}
+
+// Register the callback used by pgraft_go_drain_completions(); NULL
+// unregisters
+//
+//export pgraft_go_set_completion_callback
+func pgraft_go_set_completion_callback(callback C.pgraft_go_completion_callback, arg unsafe.Pointer) {
+	completionMutex.Lock()
+	completionCallback = callback
+	completionCallbackArg = arg
+	completionMutex.Unlock()
+}
+
+// Deliver queued completions to the registered callback on the calling
+// thread; returns the number delivered
+//
+//export pgraft_go_drain_completions
+func pgraft_go_drain_completions() C.int {
+	expirePendingOps()
+
+	completionMutex.Lock()
+	callback, arg := completionCallback, completionCallbackArg
+	if callback == nil {
+		completionMutex.Unlock()
+		return 0
+	}
+	completions := completionQueue
+	completionQueue = nil
+	completionMutex.Unlock()
+
+	var completion C.pgraft_go_completion
+	for _, c := range completions {
+		fillCompletion(&completion, c)
+		C.pgraft_go_call_completion_callback(callback, &completion, arg)
+	}
+
+	return C.int(len(completions))
+}
+
+// Number of completions waiting to be polled or drained
+//
+//export pgraft_go_pending_completions
+func pgraft_go_pending_completions() C.int {
+	completionMutex.Lock()
+	defer completionMutex.Unlock()
+	return C.int(len(completionQueue))
+}
+
+func fillCompletion(out *C.pgraft_go_completion, c asyncCompletion) {
+	out.ticket = C.uint64_t(c.ticket)
+	out.op = C.int32_t(c.op)
+	out.status = C.int32_t(c.status)
+	out.index = C.uint64_t(c.index)
+	out.term = C.uint64_t(c.term)
+}
@@ -0,0 +1,276 @@
+/*
+ * pgraft_go_backup.go
+ * Election of the node that runs backups
+ *
+ * Exactly one member at a time is the backup node, normally a replica so
+ * that backups do not load the primary.  The leader chooses it from its
+ * ticker and records the choice as a typed entry, so every node agrees on
+ * the holder and on an epoch that increases with each election; backup
+ * tooling can record the epoch to detect a superseded holder.  Replicas
+ * tagged "backup-source" are preferred.  The holder keeps the role while
+ * it is replicating; if it stops responding for backupFailoverDelay,
+ * becomes the write leader, or resigns, another node is elected.  The
+ * leader only holds the role when no replica is available.
+ */
+
+package main
+
+/*
+#include <stdint.h>
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"go.etcd.io/raft/v3"
+	"go.etcd.io/raft/v3/tracker"
+)
+
+const (
+	// Tag marking nodes preferred for running backups
+	tagBackupSource = "backup-source"
+
+	// How long the holder may be unreachable before another is elected
+	backupFailoverDelay = 10 * time.Second
+
+	// Minimum time between election proposals from the ticker
+	backupProposeInterval = time.Second
+)
+
+type backupEntryBody struct {
+	Node   uint64 `json:"node"`
+	Epoch  uint64 `json:"epoch"`
+	Resign bool   `json:"resign,omitempty"`
+	NowMs  int64  `json:"now_ms"`
+}
+
+var (
+	backupHolder   uint64
+	backupEpoch    uint64
+	backupSinceMs  int64
+	backupResigned uint64
+	backupMutex    sync.Mutex
+
+	// Leader-side failure detection
+	backupUnhealthySince time.Time
+	backupProposedAt     time.Time
+)
+
+func applyBackupEntry(index, term uint64, body []byte) {
+	var entry backupEntryBody
+	if err := json.Unmarshal(body, &entry); err != nil {
+		log.Printf("pgraft: ERROR - Malformed backup election entry at index %d: %v", index, err)
+		return
+	}
+
+	backupMutex.Lock()
+	defer backupMutex.Unlock()
+
+	// Elections are proposed for the next epoch; stale ones are ignored
+	if entry.Epoch != backupEpoch+1 {
+		return
+	}
+	if entry.Resign {
+		if entry.Node != backupHolder {
+			return
+		}
+		log.Printf("pgraft: INFO - Node %d resigned as backup node", entry.Node)
+		backupResigned = entry.Node
+		backupHolder = 0
+	} else {
+		log.Printf("pgraft: INFO - Node %d elected backup node for epoch %d", entry.Node, entry.Epoch)
+		backupHolder = entry.Node
+	}
+	backupEpoch = entry.Epoch
+	backupSinceMs = entry.NowMs
+	backupUnhealthySince = time.Time{}
+}
+
+func resetBackup() {
+	backupMutex.Lock()
+	backupHolder = 0
+	backupEpoch = 0
+	backupSinceMs = 0
+	backupResigned = 0
+	backupUnhealthySince = time.Time{}
+	backupProposedAt = time.Time{}
+	backupMutex.Unlock()
+}
+
+// True if node is replicating and recently heard from
+func backupCandidateHealthy(status raft.Status, node uint64) bool {
+	pr, tracked := status.Progress[node]
+	return tracked && pr.RecentActive && pr.State == tracker.StateReplicate
+}
+
+// Best backup node other than exclude: a healthy replica tagged
+// backup-source, then any healthy replica, then the leader itself.  A
+// replica that resigned is only chosen again when no other is available.
+func chooseBackupNode(status raft.Status, exclude, resigned uint64) uint64 {
+	var candidates []uint64
+	for id := range status.Config.Voters.IDs() {
+		candidates = append(candidates, id)
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i] < candidates[j] })
+
+	rank := func(id uint64) int {
+		switch {
+		case id == exclude || (id != status.ID && !backupCandidateHealthy(status, id)):
+			return 0
+		case id == status.ID:
+			return 1
+		case id == resigned:
+			return 2
+		case !nodeHasTag(id, tagBackupSource):
+			return 3
+		}
+		return 4
+	}
+
+	var best uint64
+	bestRank := 0
+	for _, id := range candidates {
+		if r := rank(id); r > bestRank {
+			best, bestRank = id, r
+		}
+	}
+	return best
+}
+
+// Elect a backup node when there is none or the holder has failed; called
+// on every tick
+func electBackupNode() {
+	snapshot := loadStatusSnapshot()
+	node := raftNode
+	if snapshot == nil || snapshot.RaftState != raft.StateLeader || node == nil {
+		return
+	}
+	status := node.Status()
+	now := time.Now()
+
+	backupMutex.Lock()
+	holder, epoch, resigned := backupHolder, backupEpoch, backupResigned
+	if now.Sub(backupProposedAt) < backupProposeInterval {
+		backupMutex.Unlock()
+		return
+	}
+
+	var exclude uint64
+	switch {
+	case holder == 0:
+	case holder == status.ID:
+		// The leader only holds the role until a replica is available
+		if chooseBackupNode(status, holder, resigned) == 0 {
+			backupMutex.Unlock()
+			return
+		}
+		exclude = holder
+	case backupCandidateHealthy(status, holder):
+		backupUnhealthySince = time.Time{}
+		backupMutex.Unlock()
+		return
+	default:
+		if backupUnhealthySince.IsZero() {
+			backupUnhealthySince = now
+		}
+		if now.Sub(backupUnhealthySince) < backupFailoverDelay {
+			backupMutex.Unlock()
+			return
+		}
+		exclude = holder
+	}
+
+	candidate := chooseBackupNode(status, exclude, resigned)
+	if candidate == 0 || candidate == holder {
+		backupMutex.Unlock()
+		return
+	}
+	backupProposedAt = now
+	backupMutex.Unlock()
+
+	body := backupEntryBody{Node: candidate, Epoch: epoch + 1, NowMs: now.UnixMilli()}
+	// Proposing blocks on the raft loop, which the ticker feeds
+	go func() {
+		if rc := proposeTypedEntry(typedEntryBackup, body); rc != errOK {
+			debugLog("backup: proposing node %d for epoch %d failed (%d)", body.Node, body.Epoch, rc)
+		}
+	}()
+}
+
+// 1 if this node is the backup node, 0 otherwise
+//
+//export pgraft_go_is_backup_node
+func pgraft_go_is_backup_node() C.int {
+	if activeConfig == nil {
+		return 0
+	}
+
+	backupMutex.Lock()
+	defer backupMutex.Unlock()
+	if backupHolder != 0 && backupHolder == activeConfig.NodeID {
+		return 1
+	}
+	return 0
+}
+
+// Current backup node, or 0 if none; its election epoch is stored in
+// *epoch
+//
+//export pgraft_go_get_backup_node
+func pgraft_go_get_backup_node(epoch *C.uint64_t) C.uint64_t {
+	backupMutex.Lock()
+	defer backupMutex.Unlock()
+
+	if epoch != nil {
+		*epoch = C.uint64_t(backupEpoch)
+	}
+	return C.uint64_t(backupHolder)
+}
+
+// Give up the backup role held by this node so that another is elected
+//
+//export pgraft_go_resign_backup
+func pgraft_go_resign_backup() C.int {
+	if activeConfig == nil {
+		return errNotInitialized
+	}
+
+	backupMutex.Lock()
+	holder, epoch := backupHolder, backupEpoch
+	backupMutex.Unlock()
+	if holder != activeConfig.NodeID {
+		return errInvalidArgument
+	}
+
+	return C.int(proposeTypedEntry(typedEntryBackup, backupEntryBody{
+		Node:   holder,
+		Epoch:  epoch + 1,
+		Resign: true,
+		NowMs:  time.Now().UnixMilli(),
+	}))
+}
+
+// Backup node, epoch and when it was elected as JSON; free with
+// pgraft_go_free_string()
+//
+//export pgraft_go_backup_status
+func pgraft_go_backup_status() *C.char {
+	backupMutex.Lock()
+	status := map[string]interface{}{
+		"node":     backupHolder,
+		"epoch":    backupEpoch,
+		"since_ms": backupSinceMs,
+	}
+	backupMutex.Unlock()
+
+	jsonData, err := json.Marshal(status)
+	if err != nil {
+		return C.CString("{\"error\": \"failed to marshal backup status\"}")
+	}
+	return C.CString(string(jsonData))
+}
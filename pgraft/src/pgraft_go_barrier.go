@@ -0,0 +1,210 @@
+/*
+ * pgraft_go_barrier.go
+ * Commit barriers tying WAL positions to quorum-committed raft entries
+ *
+ * pgraft_go_barrier_register() appends a barrier carrying a token, usually
+ * the commit LSN of a transaction, to the raft log.  Once the entry is
+ * committed on a quorum and applied locally the barrier is complete: the
+ * extension can block on it with pgraft_go_barrier_wait(), or receive it
+ * through the callback run by pgraft_go_drain_barriers().  Every member
+ * also tracks the highest token committed so far, so backends waiting for
+ * a quorum-acknowledged commit can compare their LSN with
+ * pgraft_go_barrier_watermark() instead of waiting on each barrier.  The
+ * latest barrierHistoryLimit barriers registered on this node are kept.
+ */
+
+package main
+
+/*
+#include <stdint.h>
+
+typedef void (*pgraft_go_barrier_callback) (uint64_t barrier_id, uint64_t token, uint64_t index, uint64_t term, void *arg);
+
+static inline void
+pgraft_go_call_barrier_callback(pgraft_go_barrier_callback cb, uint64_t barrier_id, uint64_t token, uint64_t index, uint64_t term, void *arg)
+{
+	cb(barrier_id, token, index, term, arg);
+}
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// Number of barriers registered on this node whose state is retained
+const barrierHistoryLimit = 10000
+
+type barrierEntryBody struct {
+	ID     uint64 `json:"id"`
+	Origin uint64 `json:"origin"`
+	Token  uint64 `json:"token"`
+}
+
+type barrier struct {
+	id        uint64
+	token     uint64
+	index     uint64
+	term      uint64
+	committed chan struct{}
+}
+
+var (
+	barriers     = make(map[uint64]*barrier)
+	barrierOrder []uint64
+
+	// Committed barriers not yet handed to the callback
+	barrierQueue []*barrier
+
+	barrierCallback    C.pgraft_go_barrier_callback
+	barrierCallbackArg unsafe.Pointer
+	barrierMutex       sync.Mutex
+
+	// Highest token committed by any member
+	barrierWatermark uint64
+)
+
+func applyBarrierEntry(index, term uint64, body []byte) {
+	var entry barrierEntryBody
+	if err := json.Unmarshal(body, &entry); err != nil {
+		log.Printf("pgraft: ERROR - Malformed barrier entry at index %d: %v", index, err)
+		return
+	}
+
+	for {
+		current := atomic.LoadUint64(&barrierWatermark)
+		if entry.Token <= current || atomic.CompareAndSwapUint64(&barrierWatermark, current, entry.Token) {
+			break
+		}
+	}
+
+	barrierMutex.Lock()
+	defer barrierMutex.Unlock()
+
+	b, registered := barriers[entry.ID]
+	if !registered || b.index != 0 {
+		return
+	}
+	b.index = index
+	b.term = term
+	close(b.committed)
+	if barrierCallback != nil {
+		barrierQueue = append(barrierQueue, b)
+	}
+}
+
+func resetBarriers() {
+	barrierMutex.Lock()
+	barriers = make(map[uint64]*barrier)
+	barrierOrder = nil
+	barrierQueue = nil
+	barrierCallback = nil
+	barrierCallbackArg = nil
+	barrierMutex.Unlock()
+	atomic.StoreUint64(&barrierWatermark, 0)
+}
+
+// Register a barrier for token (a WAL LSN or any opaque value) and store
+// its ID in *barrierID
+//
+//export pgraft_go_barrier_register
+func pgraft_go_barrier_register(token C.uint64_t, barrierID *C.uint64_t) C.int {
+	if barrierID == nil {
+		return errInvalidArgument
+	}
+	if activeConfig == nil {
+		return errNotInitialized
+	}
+
+	b := &barrier{id: newReplicatedID(), token: uint64(token), committed: make(chan struct{})}
+	barrierMutex.Lock()
+	barriers[b.id] = b
+	barrierOrder = append(barrierOrder, b.id)
+	if len(barrierOrder) > barrierHistoryLimit {
+		delete(barriers, barrierOrder[0])
+		barrierOrder = barrierOrder[1:]
+	}
+	barrierMutex.Unlock()
+
+	rc := proposeTypedEntry(typedEntryBarrier, barrierEntryBody{ID: b.id, Origin: activeConfig.NodeID, Token: b.token})
+	if rc != errOK {
+		barrierMutex.Lock()
+		delete(barriers, b.id)
+		barrierMutex.Unlock()
+		return C.int(rc)
+	}
+	*barrierID = C.uint64_t(b.id)
+	return errOK
+}
+
+// Wait up to timeoutMs for a barrier to commit; returns 1 once committed
+//
+//export pgraft_go_barrier_wait
+func pgraft_go_barrier_wait(barrierID C.uint64_t, timeoutMs C.int) C.int {
+	if timeoutMs < 0 {
+		return errInvalidArgument
+	}
+
+	barrierMutex.Lock()
+	b, exists := barriers[uint64(barrierID)]
+	barrierMutex.Unlock()
+	if !exists {
+		return errNotFound
+	}
+
+	timer := time.NewTimer(time.Duration(timeoutMs) * time.Millisecond)
+	defer timer.Stop()
+	select {
+	case <-b.committed:
+		return 1
+	case <-timer.C:
+		return errTimeout
+	}
+}
+
+// Highest token committed on a quorum by any member
+//
+//export pgraft_go_barrier_watermark
+func pgraft_go_barrier_watermark() C.uint64_t {
+	return C.uint64_t(atomic.LoadUint64(&barrierWatermark))
+}
+
+// Register the callback used by pgraft_go_drain_barriers(); NULL
+// unregisters
+//
+//export pgraft_go_set_barrier_callback
+func pgraft_go_set_barrier_callback(callback C.pgraft_go_barrier_callback, arg unsafe.Pointer) {
+	barrierMutex.Lock()
+	barrierCallback = callback
+	barrierCallbackArg = arg
+	if callback == nil {
+		barrierQueue = nil
+	}
+	barrierMutex.Unlock()
+}
+
+// Hand committed barriers to the callback on the calling thread, in commit
+// order; returns the number delivered
+//
+//export pgraft_go_drain_barriers
+func pgraft_go_drain_barriers() C.int {
+	barrierMutex.Lock()
+	callback, arg := barrierCallback, barrierCallbackArg
+	queue := barrierQueue
+	barrierQueue = nil
+	barrierMutex.Unlock()
+
+	if callback == nil {
+		return 0
+	}
+	for _, b := range queue {
+		C.pgraft_go_call_barrier_callback(callback, C.uint64_t(b.id), C.uint64_t(b.token),
+			C.uint64_t(b.index), C.uint64_t(b.term), arg)
+	}
+	return C.int(len(queue))
+}
@@ -0,0 +1,324 @@
+/*
+ * pgraft_go_bootstrap.go
+ * Coordinated bootstrap of new members from a base backup
+ *
+ * Adding a node needs both raft state and a PostgreSQL data directory.
+ * The leader starts the flow with pgraft_go_bootstrap_begin(), which
+ * records a request for the new node in the raft log.  The leader's
+ * extension acts as snapshot provider: pgraft_go_run_bootstrap_provider()
+ * calls its provider callback on the calling thread for each open request,
+ * and the manifest it returns (where and how to run pg_basebackup, the
+ * backup label, the start LSN, ...) is replicated as well.  The new node
+ * reads the manifest with pgraft_go_bootstrap_manifest() once it receives
+ * the log, takes the base backup, and reports the outcome with
+ * pgraft_go_bootstrap_complete().  Every step is a typed entry, so any
+ * member can follow progress with pgraft_go_bootstrap_status().  The
+ * manifest is opaque to pgraft.
+ */
+
+package main
+
+/*
+#include <stdint.h>
+
+typedef const char *(*pgraft_go_bootstrap_provider) (uint64_t bootstrap_id, uint64_t target_node, void *arg);
+
+static inline const char *
+pgraft_go_call_bootstrap_provider(pgraft_go_bootstrap_provider cb, uint64_t bootstrap_id, uint64_t target_node, void *arg)
+{
+	return cb(bootstrap_id, target_node, arg);
+}
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"go.etcd.io/raft/v3"
+)
+
+// Bootstrap states, mirrored by PGRAFT_BOOTSTRAP_* in pgraft_go.h
+const (
+	bootstrapRequested = 1
+	bootstrapReady     = 2
+	bootstrapCompleted = 3
+	bootstrapFailed    = 4
+)
+
+// Bootstrap steps carried in typedEntryBootstrap entries
+const (
+	bootstrapOpRequest  = "request"
+	bootstrapOpManifest = "manifest"
+	bootstrapOpComplete = "complete"
+)
+
+// Number of finished bootstraps whose status is retained
+const bootstrapHistoryLimit = 100
+
+type bootstrapEntryBody struct {
+	ID       uint64 `json:"id"`
+	Op       string `json:"op"`
+	Node     uint64 `json:"node,omitempty"`
+	Leader   uint64 `json:"leader,omitempty"`
+	Manifest string `json:"manifest,omitempty"`
+	Status   int    `json:"status,omitempty"`
+	Message  string `json:"message,omitempty"`
+}
+
+type bootstrapRecord struct {
+	ID           uint64 `json:"id"`
+	Node         uint64 `json:"node"`
+	Leader       uint64 `json:"leader"`
+	State        int    `json:"state"`
+	Manifest     string `json:"manifest,omitempty"`
+	Message      string `json:"message,omitempty"`
+	RequestIndex uint64 `json:"request_index"`
+	UpdateIndex  uint64 `json:"update_index"`
+
+	// Provider already called on this node
+	provided bool
+}
+
+var (
+	bootstraps     = make(map[uint64]*bootstrapRecord)
+	bootstrapOrder []uint64
+
+	bootstrapProvider    C.pgraft_go_bootstrap_provider
+	bootstrapProviderArg unsafe.Pointer
+	bootstrapMutex       sync.Mutex
+)
+
+func applyBootstrapEntry(index, term uint64, body []byte) {
+	var entry bootstrapEntryBody
+	if err := json.Unmarshal(body, &entry); err != nil {
+		log.Printf("pgraft: ERROR - Malformed bootstrap entry at index %d: %v", index, err)
+		return
+	}
+
+	bootstrapMutex.Lock()
+	defer bootstrapMutex.Unlock()
+
+	if entry.Op == bootstrapOpRequest {
+		if _, exists := bootstraps[entry.ID]; exists {
+			return
+		}
+		bootstraps[entry.ID] = &bootstrapRecord{
+			ID:           entry.ID,
+			Node:         entry.Node,
+			Leader:       entry.Leader,
+			State:        bootstrapRequested,
+			RequestIndex: index,
+			UpdateIndex:  index,
+		}
+		bootstrapOrder = append(bootstrapOrder, entry.ID)
+		pruneBootstraps()
+		log.Printf("pgraft: INFO - Bootstrap %d of node %d requested by node %d", entry.ID, entry.Node, entry.Leader)
+		return
+	}
+
+	record, exists := bootstraps[entry.ID]
+	if !exists || record.State == bootstrapCompleted || record.State == bootstrapFailed {
+		return
+	}
+	record.UpdateIndex = index
+	switch entry.Op {
+	case bootstrapOpManifest:
+		if entry.Status != 0 {
+			record.State = bootstrapFailed
+			record.Message = entry.Message
+			log.Printf("pgraft: ERROR - Bootstrap %d of node %d failed: %s", record.ID, record.Node, entry.Message)
+			return
+		}
+		record.State = bootstrapReady
+		record.Manifest = entry.Manifest
+	case bootstrapOpComplete:
+		record.Message = entry.Message
+		if entry.Status == 0 {
+			record.State = bootstrapCompleted
+			log.Printf("pgraft: INFO - Bootstrap %d of node %d completed", record.ID, record.Node)
+		} else {
+			record.State = bootstrapFailed
+			log.Printf("pgraft: ERROR - Bootstrap %d of node %d failed: %s", record.ID, record.Node, entry.Message)
+		}
+	}
+}
+
+// Drop the oldest finished bootstraps beyond the history limit;
+// bootstrapMutex must be held
+func pruneBootstraps() {
+	for len(bootstrapOrder) > bootstrapHistoryLimit {
+		oldest := bootstraps[bootstrapOrder[0]]
+		if oldest != nil && oldest.State != bootstrapCompleted && oldest.State != bootstrapFailed {
+			return
+		}
+		delete(bootstraps, bootstrapOrder[0])
+		bootstrapOrder = bootstrapOrder[1:]
+	}
+}
+
+func resetBootstraps() {
+	bootstrapMutex.Lock()
+	bootstraps = make(map[uint64]*bootstrapRecord)
+	bootstrapOrder = nil
+	bootstrapProvider = nil
+	bootstrapProviderArg = nil
+	bootstrapMutex.Unlock()
+}
+
+// Most recent bootstrap accepted by match as JSON, or NULL if none
+func bootstrapJSON(match func(*bootstrapRecord) bool) *C.char {
+	bootstrapMutex.Lock()
+	var found *bootstrapRecord
+	for i := len(bootstrapOrder) - 1; i >= 0; i-- {
+		if record := bootstraps[bootstrapOrder[i]]; record != nil && match(record) {
+			found = record
+			break
+		}
+	}
+	var jsonData []byte
+	var err error
+	if found != nil {
+		jsonData, err = json.Marshal(found)
+	}
+	bootstrapMutex.Unlock()
+
+	if found == nil {
+		return nil
+	}
+	if err != nil {
+		return C.CString("{\"error\": \"failed to marshal bootstrap status\"}")
+	}
+	return C.CString(string(jsonData))
+}
+
+// Start bootstrapping targetNode; must be called on the leader.  The ID of
+// the bootstrap is stored in *bootstrapID
+//
+//export pgraft_go_bootstrap_begin
+func pgraft_go_bootstrap_begin(targetNode C.uint64_t, bootstrapID *C.uint64_t) C.int {
+	if targetNode == 0 || bootstrapID == nil {
+		return errInvalidArgument
+	}
+	if atomic.LoadInt32(&running) == 0 {
+		return errNotRunning
+	}
+	snapshot := loadStatusSnapshot()
+	if snapshot == nil || snapshot.RaftState != raft.StateLeader {
+		return errNotLeader
+	}
+
+	body := bootstrapEntryBody{
+		ID:     newReplicatedID(),
+		Op:     bootstrapOpRequest,
+		Node:   uint64(targetNode),
+		Leader: snapshot.NodeID,
+	}
+	rc := proposeTypedEntry(typedEntryBootstrap, body)
+	if rc == errOK {
+		*bootstrapID = C.uint64_t(body.ID)
+	}
+	return C.int(rc)
+}
+
+// Register the snapshot provider; it returns the manifest for a bootstrap,
+// which pgraft copies, or NULL on failure.  NULL unregisters
+//
+//export pgraft_go_set_bootstrap_provider
+func pgraft_go_set_bootstrap_provider(provider C.pgraft_go_bootstrap_provider, arg unsafe.Pointer) {
+	bootstrapMutex.Lock()
+	bootstrapProvider = provider
+	bootstrapProviderArg = arg
+	bootstrapMutex.Unlock()
+}
+
+// Call the provider on the calling thread for each open request this node
+// started and replicate the manifests; returns the number handled
+//
+//export pgraft_go_run_bootstrap_provider
+func pgraft_go_run_bootstrap_provider() C.int {
+	if activeConfig == nil {
+		return 0
+	}
+
+	bootstrapMutex.Lock()
+	provider, arg := bootstrapProvider, bootstrapProviderArg
+	if provider == nil {
+		bootstrapMutex.Unlock()
+		return 0
+	}
+	var open []*bootstrapRecord
+	for _, id := range bootstrapOrder {
+		record := bootstraps[id]
+		if record != nil && record.State == bootstrapRequested && record.Leader == activeConfig.NodeID && !record.provided {
+			record.provided = true
+			open = append(open, record)
+		}
+	}
+	bootstrapMutex.Unlock()
+
+	for _, record := range open {
+		body := bootstrapEntryBody{ID: record.ID, Op: bootstrapOpManifest}
+		manifest := C.pgraft_go_call_bootstrap_provider(provider, C.uint64_t(record.ID), C.uint64_t(record.Node), arg)
+		if manifest == nil {
+			body.Status = errFailed
+			body.Message = "snapshot provider failed"
+		} else {
+			body.Manifest = C.GoString(manifest)
+		}
+
+		if rc := proposeTypedEntry(typedEntryBootstrap, body); rc != errOK {
+			log.Printf("pgraft: ERROR - Failed to replicate bootstrap %d manifest: %d", record.ID, rc)
+			bootstrapMutex.Lock()
+			record.provided = false
+			bootstrapMutex.Unlock()
+		}
+	}
+	return C.int(len(open))
+}
+
+// Latest bootstrap of node as JSON, including the manifest once ready, or
+// NULL if none; free with pgraft_go_free_string()
+//
+//export pgraft_go_bootstrap_manifest
+func pgraft_go_bootstrap_manifest(node C.uint64_t) *C.char {
+	return bootstrapJSON(func(record *bootstrapRecord) bool {
+		return record.Node == uint64(node)
+	})
+}
+
+// Status of a bootstrap as JSON, or NULL if unknown; free with
+// pgraft_go_free_string()
+//
+//export pgraft_go_bootstrap_status
+func pgraft_go_bootstrap_status(bootstrapID C.uint64_t) *C.char {
+	return bootstrapJSON(func(record *bootstrapRecord) bool {
+		return record.ID == uint64(bootstrapID)
+	})
+}
+
+// Report the outcome of a base backup taken for a bootstrap; status 0
+// means the node is ready
+//
+//export pgraft_go_bootstrap_complete
+func pgraft_go_bootstrap_complete(bootstrapID C.uint64_t, status C.int, message *C.char) C.int {
+	bootstrapMutex.Lock()
+	record, exists := bootstraps[uint64(bootstrapID)]
+	var node uint64
+	if exists {
+		node = record.Node
+	}
+	bootstrapMutex.Unlock()
+	if !exists {
+		return errNotFound
+	}
+
+	body := bootstrapEntryBody{ID: uint64(bootstrapID), Op: bootstrapOpComplete, Node: node, Status: int(status)}
+	if message != nil {
+		body.Message = C.GoString(message)
+	}
+	return C.int(proposeTypedEntry(typedEntryBootstrap, body))
+}
@@ -0,0 +1,85 @@
+/*
+ * pgraft_go_commit.go
+ * Direct delivery of committed entries to a registered C function
+ *
+ * As a lower-latency alternative to polling pgraft_go_get_logs(), the
+ * extension can register a callback that the apply path invokes
+ * synchronously for every committed normal entry, in log order.
+ *
+ * Threading rules:
+ *  - The callback runs on a Go runtime thread, never on the backend's
+ *    main thread.  It must not call elog(), palloc() or any other
+ *    PostgreSQL backend function; hand the entry off to shared memory or
+ *    a lock-free queue and return.
+ *  - data is only valid for the duration of the call and must be copied
+ *    if kept.
+ *  - The apply loop is blocked while the callback runs, so it must be
+ *    short and must not call pgraft exports that wait for the apply loop
+ *    (stop, finalize, pgraft_go_set_commit_callback).
+ *  - Once pgraft_go_set_commit_callback() returns, the previous callback
+ *    is not running and will not be invoked again.
+ */
+
+package main
+
+/*
+#include <stdint.h>
+
+typedef void (*pgraft_go_commit_callback) (uint64_t index, uint64_t term, const char *data, int length, void *arg);
+
+static inline void
+pgraft_go_call_commit_callback(pgraft_go_commit_callback cb, uint64_t index, uint64_t term, const char *data, int length, void *arg)
+{
+	cb(index, term, data, length, arg);
+}
+*/
+import "C"
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+var (
+	commitCallback      C.pgraft_go_commit_callback
+	commitCallbackArg   unsafe.Pointer
+	commitCallbackMutex sync.RWMutex
+	commitCallbackCalls int64
+)
+
+// Register the commit callback and the opaque argument passed to it; a
+// NULL callback unregisters
+//
+//export pgraft_go_set_commit_callback
+func pgraft_go_set_commit_callback(callback C.pgraft_go_commit_callback, arg unsafe.Pointer) {
+	commitCallbackMutex.Lock()
+	commitCallback = callback
+	commitCallbackArg = arg
+	commitCallbackMutex.Unlock()
+
+	if callback == nil {
+		log.Printf("pgraft: INFO - Commit callback unregistered")
+	} else {
+		log.Printf("pgraft: INFO - Commit callback registered")
+	}
+}
+
+// Hand a committed entry to the registered callback, if any
+func deliverCommittedEntry(index uint64, term uint64, data []byte) {
+	commitCallbackMutex.RLock()
+	defer commitCallbackMutex.RUnlock()
+
+	if commitCallback == nil {
+		return
+	}
+
+	var dataPtr *C.char
+	if len(data) > 0 {
+		dataPtr = (*C.char)(unsafe.Pointer(&data[0]))
+	}
+	C.pgraft_go_call_commit_callback(commitCallback, C.uint64_t(index), C.uint64_t(term),
+		dataPtr, C.int(len(data)), commitCallbackArg)
+	atomic.AddInt64(&commitCallbackCalls, 1)
+}
@@ -0,0 +1,145 @@
+/*
+ * pgraft_go_compaction.go
+ * Automatic snapshots, log compaction and the durably applied index
+ *
+ * When tunables.snapshot_threshold is non-zero, the ticker takes a
+ * snapshot of the replicated services once that many entries have been
+ * applied since the previous snapshot, and compacts the log up to it.
+ * Cursors and readers positioned before the compacted entries then get
+ * PGRAFT_ERR_COMPACTED.
+ *
+ * The applied index only lives in memory, so entries applied but not yet
+ * made durable by the extension would be lost if compacted.  Once the
+ * extension reports the index it has durably applied through, typically
+ * at each PostgreSQL checkpoint, with pgraft_go_set_durable_applied(),
+ * compaction never passes that index, and a node restarted from its
+ * storage resumes applying right after it instead of from the start of
+ * the log.  The index may be reported before pgraft_go_init() to restore
+ * a value the extension persisted itself.  With log archiving enabled,
+ * compaction also waits for entries to be archived.
+ */
+
+package main
+
+/*
+#include <stdint.h>
+*/
+import "C"
+
+import (
+	"log"
+	"sync/atomic"
+)
+
+var (
+	// Entries applied between automatic snapshots; 0 disables them
+	snapshotThreshold uint64
+
+	// Index the extension has durably applied through, once reported
+	durableApplied        uint64
+	durableAppliedTracked int32
+)
+
+// Highest applied index that is also durable in the extension
+func durableLimit(applied uint64) uint64 {
+	if atomic.LoadInt32(&durableAppliedTracked) == 0 {
+		return applied
+	}
+	if durable := atomic.LoadUint64(&durableApplied); durable < applied {
+		return durable
+	}
+	return applied
+}
+
+// Highest index compaction may remove: durable, and archived when log
+// archiving is enabled
+func compactionLimit(applied uint64) uint64 {
+	limit := durableLimit(applied)
+	if archived, enabled := archiveLimit(); enabled && archived < limit {
+		limit = archived
+	}
+	return limit
+}
+
+// Applied index to restart from, given the recovered commit index and the
+// index of the storage's snapshot; 0 leaves the choice to raft
+func restartAppliedIndex(commit, snapshotIndex uint64) uint64 {
+	if atomic.LoadInt32(&durableAppliedTracked) == 0 {
+		return 0
+	}
+	durable := atomic.LoadUint64(&durableApplied)
+	if durable > commit {
+		log.Printf("pgraft: WARNING - Durably applied index %d is beyond the recovered commit index %d", durable, commit)
+		durable = commit
+	}
+	if durable < snapshotIndex {
+		return 0
+	}
+	return durable
+}
+
+func resetCompaction() {
+	atomic.StoreUint64(&snapshotThreshold, 0)
+	atomic.StoreUint64(&durableApplied, 0)
+	atomic.StoreInt32(&durableAppliedTracked, 0)
+}
+
+// Snapshot the services and compact the log once the threshold is
+// reached; called on every tick
+func maybeAutoSnapshot() {
+	threshold := atomic.LoadUint64(&snapshotThreshold)
+	storage := raftStorage
+	if threshold == 0 || storage == nil {
+		return
+	}
+
+	current, err := storage.Snapshot()
+	if err != nil || atomic.LoadUint64(&appliedIndex) < current.Metadata.Index+threshold {
+		return
+	}
+
+	snapshot, err := createServiceSnapshot(storage)
+	if err != nil {
+		log.Printf("pgraft: WARNING - Automatic snapshot failed: %v", err)
+		return
+	}
+	replicationState.replicationMutex.Lock()
+	replicationState.lastSnapshotIndex = snapshot.Metadata.Index
+	replicationState.replicationMutex.Unlock()
+
+	// Entries not yet durable in the extension, or not yet archived, stay
+	limit := compactionLimit(snapshot.Metadata.Index)
+	if first, err := storage.FirstIndex(); err != nil || limit < first {
+		debugLog("compaction: snapshot at index %d, nothing to compact", snapshot.Metadata.Index)
+		return
+	}
+	if err := storage.Compact(limit); err != nil {
+		log.Printf("pgraft: WARNING - Log compaction up to index %d failed: %v", limit, err)
+		return
+	}
+	debugLog("compaction: snapshot at index %d, compaction up to index %d", snapshot.Metadata.Index, limit)
+}
+
+// Record that the extension has durably applied every entry through index
+//
+//export pgraft_go_set_durable_applied
+func pgraft_go_set_durable_applied(index C.uint64_t) C.int {
+	// The durable index never moves backwards; an index already recorded
+	// is accepted even while a restarted node is still catching up to it
+	if atomic.LoadInt32(&durableAppliedTracked) == 1 && uint64(index) <= atomic.LoadUint64(&durableApplied) {
+		return errOK
+	}
+	if atomic.LoadInt32(&initialized) == 1 && uint64(index) > atomic.LoadUint64(&appliedIndex) {
+		return errInvalidArgument
+	}
+	atomic.StoreUint64(&durableApplied, uint64(index))
+	atomic.StoreInt32(&durableAppliedTracked, 1)
+	return errOK
+}
+
+// Index last reported with pgraft_go_set_durable_applied(), or 0
+//
+//export pgraft_go_get_durable_applied
+func pgraft_go_get_durable_applied() C.uint64_t {
+	return C.uint64_t(atomic.LoadUint64(&durableApplied))
+}
@@ -0,0 +1,215 @@
+/*
+ * pgraft_go_config.go
+ * Node configuration, including initialization from an inline JSON document
+ *
+ * pgraft_go_init_json() takes the complete configuration generated by the
+ * extension from its GUCs, so the Go side never has to probe the file
+ * system for a pgraft.conf.  pgraft_go_init() builds the same structure
+ * from its arguments and the defaults below.
+ */
+
+package main
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"time"
+)
+
+// PeerConfig describes one other member of the cluster
+type PeerConfig struct {
+	ID      uint64 `json:"id"`
+	Address string `json:"address"`
+	Port    int    `json:"port"`
+}
+
+// TunablesConfig holds the raft timing and flow-control settings
+type TunablesConfig struct {
+	TickIntervalMs  int    `json:"tick_interval_ms"`
+	ElectionTick    int    `json:"election_tick"`
+	HeartbeatTick   int    `json:"heartbeat_tick"`
+	MaxSizePerMsg   uint64 `json:"max_size_per_msg"`
+	MaxInflightMsgs int    `json:"max_inflight_msgs"`
+	PreVote         bool   `json:"pre_vote"`
+	CheckQuorum     bool   `json:"check_quorum"`
+
+	// Entries applied between automatic snapshots; 0 disables them
+	SnapshotThreshold uint64 `json:"snapshot_threshold"`
+}
+
+// TLSConfig enables TLS on peer connections when CertFile is set
+type TLSConfig struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+	CAFile   string `json:"ca_file"`
+}
+
+// StorageConfig holds on-disk locations used by the node
+type StorageConfig struct {
+	DataDir string `json:"data_dir"`
+}
+
+// NodeConfig is the complete configuration of the local node
+type NodeConfig struct {
+	NodeID   uint64         `json:"node_id"`
+	Address  string         `json:"address"`
+	Port     int            `json:"port"`
+	Peers    []PeerConfig   `json:"peers"`
+	Tunables TunablesConfig `json:"tunables"`
+	TLS      TLSConfig      `json:"tls"`
+	Storage  StorageConfig  `json:"storage"`
+	LogLevel string         `json:"log_level"`
+
+	// Set when the configuration came from pgraft_go_init_json, in which
+	// case peers are never discovered from configuration files
+	inline bool
+}
+
+var (
+	// Configuration the node was initialized with
+	activeConfig *NodeConfig
+
+	// Non-nil when peer connections use TLS
+	peerTLSConfig *tls.Config
+)
+
+func defaultNodeConfig() *NodeConfig {
+	return &NodeConfig{
+		Port: 7400,
+		Tunables: TunablesConfig{
+			TickIntervalMs:  100,
+			ElectionTick:    10,
+			HeartbeatTick:   1,
+			MaxSizePerMsg:   4096,
+			MaxInflightMsgs: 256,
+		},
+		LogLevel: "info",
+	}
+}
+
+func (cfg *NodeConfig) tickInterval() time.Duration {
+	return time.Duration(cfg.Tunables.TickIntervalMs) * time.Millisecond
+}
+
+// Tick interval of the active configuration, or the default before init
+func currentTickInterval() time.Duration {
+	if activeConfig == nil {
+		return defaultNodeConfig().tickInterval()
+	}
+	return activeConfig.tickInterval()
+}
+
+func (cfg *NodeConfig) listenAddress() string {
+	return net.JoinHostPort(cfg.Address, fmt.Sprintf("%d", cfg.Port))
+}
+
+func (p PeerConfig) peerAddress() string {
+	return net.JoinHostPort(p.Address, fmt.Sprintf("%d", p.Port))
+}
+
+// Parse a JSON configuration document on top of the defaults
+func parseNodeConfigJSON(data []byte) (*NodeConfig, error) {
+	cfg := defaultNodeConfig()
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("invalid configuration JSON: %v", err)
+	}
+	cfg.inline = true
+
+	if finding := validateNodeConfig(cfg).firstError(); finding != nil {
+		if finding.Field == "" {
+			return nil, errors.New(finding.Message)
+		}
+		return nil, fmt.Errorf("%s: %s", finding.Field, finding.Message)
+	}
+
+	return cfg, nil
+}
+
+// Build the peer TLS configuration; nil when TLS is not configured
+func loadPeerTLSConfig(cfg TLSConfig) (*tls.Config, error) {
+	if cfg.CertFile == "" {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS key pair: %v", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if cfg.CAFile != "" {
+		caData, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read TLS CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caData) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+// Wrap an accepted peer connection in TLS when configured
+func wrapServerConn(conn net.Conn) net.Conn {
+	if peerTLSConfig == nil {
+		return conn
+	}
+	return tls.Server(conn, peerTLSConfig)
+}
+
+// Wrap a dialed peer connection in TLS when configured
+func wrapClientConn(conn net.Conn, peerAddr string) net.Conn {
+	if peerTLSConfig == nil {
+		return conn
+	}
+	clientConfig := peerTLSConfig.Clone()
+	if host, _, err := net.SplitHostPort(peerAddr); err == nil {
+		clientConfig.ServerName = host
+	}
+	return tls.Client(conn, clientConfig)
+}
+
+//export pgraft_go_init_json
+func pgraft_go_init_json(configJSON *C.char) C.int {
+	if configJSON == nil {
+		log.Printf("pgraft: ERROR - NULL configuration passed to pgraft_go_init_json")
+		return errInvalidArgument
+	}
+
+	cfg, err := parseNodeConfigJSON([]byte(C.GoString(configJSON)))
+	if err != nil {
+		log.Printf("pgraft: ERROR - %v", err)
+		return errConfig
+	}
+
+	tlsConfig, err := loadPeerTLSConfig(cfg.TLS)
+	if err != nil {
+		log.Printf("pgraft: ERROR - %v", err)
+		return errTLS
+	}
+	peerTLSConfig = tlsConfig
+
+	debugEnabled = cfg.LogLevel == "debug"
+
+	return initializeNode(cfg)
+}
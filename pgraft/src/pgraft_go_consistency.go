@@ -0,0 +1,110 @@
+/*
+ * pgraft_go_consistency.go
+ * Consistency tokens for read-your-writes on replicas
+ *
+ * After a write, an application asks the node it wrote to for a token
+ * recording the term and commit index at that moment.  When it later
+ * reads from a replica it passes the token along, and the replica waits
+ * with pgraft_go_wait_for_token() until it has applied through that
+ * index, so the read observes the write.  Committed entries are never
+ * rewritten, so the index alone decides; the term is kept to make tokens
+ * from different leaderships distinguishable when debugging.  Tokens are
+ * short strings of the form "term/index".
+ */
+
+package main
+
+/*
+#include <stdint.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// How often waiting callers check the applied index
+const tokenPollInterval = 2 * time.Millisecond
+
+func formatConsistencyToken(term, index uint64) string {
+	return strconv.FormatUint(term, 10) + "/" + strconv.FormatUint(index, 10)
+}
+
+func parseConsistencyToken(token string) (uint64, uint64, error) {
+	termText, indexText, found := strings.Cut(token, "/")
+	if !found {
+		return 0, 0, fmt.Errorf("malformed consistency token %q", token)
+	}
+	term, err := strconv.ParseUint(termText, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("malformed term in consistency token %q", token)
+	}
+	index, err := strconv.ParseUint(indexText, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("malformed index in consistency token %q", token)
+	}
+	return term, index, nil
+}
+
+// Token for everything committed so far, or NULL if the node is not
+// running; free with pgraft_go_free_string()
+//
+//export pgraft_go_mint_consistency_token
+func pgraft_go_mint_consistency_token() *C.char {
+	node := raftNode
+	if node == nil {
+		return nil
+	}
+
+	status := node.Status()
+	return C.CString(formatConsistencyToken(status.Term, status.Commit))
+}
+
+// 1 if this node has applied through the token, 0 if not yet
+//
+//export pgraft_go_token_reached
+func pgraft_go_token_reached(token *C.char) C.int {
+	if token == nil {
+		return errInvalidArgument
+	}
+	_, index, err := parseConsistencyToken(C.GoString(token))
+	if err != nil {
+		return errInvalidArgument
+	}
+
+	if atomic.LoadUint64(&appliedIndex) >= index {
+		return 1
+	}
+	return 0
+}
+
+// Wait up to timeoutMs until this node has applied through the token;
+// returns 1 once it has
+//
+//export pgraft_go_wait_for_token
+func pgraft_go_wait_for_token(token *C.char, timeoutMs C.int) C.int {
+	if token == nil || timeoutMs < 0 {
+		return errInvalidArgument
+	}
+	_, index, err := parseConsistencyToken(C.GoString(token))
+	if err != nil {
+		debugLog("consistency: %v", err)
+		return errInvalidArgument
+	}
+
+	deadline := time.Now().Add(time.Duration(timeoutMs) * time.Millisecond)
+	for atomic.LoadUint64(&appliedIndex) < index {
+		if time.Now().After(deadline) {
+			return errTimeout
+		}
+		if raftNode == nil {
+			return errNotRunning
+		}
+		time.Sleep(tokenPollInterval)
+	}
+	return 1
+}
@@ -0,0 +1,149 @@
+/*
+ * pgraft_go_cursor.go
+ * Cursors over committed log entries for crash recovery replay
+ *
+ * After a crash the extension reopens a cursor at the first index it has
+ * not applied and walks forward with pgraft_go_cursor_next() until it
+ * catches up, instead of only seeing entries committed while it was
+ * attached.  A cursor that reaches the commit index stays positioned there
+ * and returns further entries once they commit.  Entries already compacted
+ * out of storage cannot be replayed; opening a cursor below the first
+ * retained index fails.
+ */
+
+package main
+
+/*
+#include <stdint.h>
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+type entryCursor struct {
+	id   uint64
+	next uint64
+}
+
+var (
+	cursors      = make(map[uint64]*entryCursor)
+	cursorsMutex sync.Mutex
+	nextCursorID uint64
+)
+
+func resetCursors() {
+	cursorsMutex.Lock()
+	cursors = make(map[uint64]*entryCursor)
+	cursorsMutex.Unlock()
+}
+
+// Open a cursor positioned at fromIndex, or at the first retained entry
+// when fromIndex is 0.  Returns 0 on failure.
+//
+//export pgraft_go_cursor_open
+func pgraft_go_cursor_open(fromIndex C.uint64_t) C.uint64_t {
+	if atomic.LoadInt32(&initialized) == 0 {
+		log.Printf("pgraft: ERROR - Cannot open cursor, node not initialized")
+		return 0
+	}
+
+	raftMutex.RLock()
+	firstIndex, err := raftStorage.FirstIndex()
+	raftMutex.RUnlock()
+	if err != nil {
+		log.Printf("pgraft: ERROR - Cannot open cursor: %v", err)
+		return 0
+	}
+
+	start := uint64(fromIndex)
+	if start == 0 {
+		start = firstIndex
+	}
+	if start < firstIndex {
+		log.Printf("pgraft: ERROR - Cannot open cursor at %d, log compacted up to %d", start, firstIndex-1)
+		return 0
+	}
+
+	cursorsMutex.Lock()
+	defer cursorsMutex.Unlock()
+
+	nextCursorID++
+	cursor := &entryCursor{id: nextCursorID, next: start}
+	cursors[cursor.id] = cursor
+
+	debugLog("cursor_open: cursor %d at index %d", cursor.id, start)
+	return C.uint64_t(cursor.id)
+}
+
+// Return the next committed entry.  On 1 the entry is stored through the
+// out parameters and *data, if not NULL, must be released with
+// pgraft_go_free_string(); 0 means no further entry is committed yet and
+// -1 means the cursor is unknown or its position was compacted away.
+//
+//export pgraft_go_cursor_next
+func pgraft_go_cursor_next(cursorID C.uint64_t, index *C.uint64_t, term *C.uint64_t, entryType *C.int, data **C.char, length *C.int) C.int {
+	cursorsMutex.Lock()
+	defer cursorsMutex.Unlock()
+
+	cursor, exists := cursors[uint64(cursorID)]
+	if !exists {
+		return -1
+	}
+
+	raftMutex.RLock()
+	if raftStorage == nil || cursor.next > committedIndex {
+		raftMutex.RUnlock()
+		return 0
+	}
+	entries, err := raftStorage.Entries(cursor.next, cursor.next+1, 0)
+	raftMutex.RUnlock()
+	if err != nil || len(entries) == 0 {
+		log.Printf("pgraft: ERROR - Cursor %d cannot read index %d: %v", cursor.id, cursor.next, err)
+		return -1
+	}
+
+	entry := entries[0]
+	if entry.Type == raftpb.EntryNormal {
+		entry.Data = entryPayload(entry.Data)
+	}
+	if index != nil {
+		*index = C.uint64_t(entry.Index)
+	}
+	if term != nil {
+		*term = C.uint64_t(entry.Term)
+	}
+	if entryType != nil {
+		*entryType = C.int(entry.Type)
+	}
+	if length != nil {
+		*length = C.int(len(entry.Data))
+	}
+	if data != nil {
+		*data = nil
+		if len(entry.Data) > 0 {
+			*data = (*C.char)(C.CBytes(entry.Data))
+		}
+	}
+
+	cursor.next = entry.Index + 1
+	return 1
+}
+
+//export pgraft_go_cursor_close
+func pgraft_go_cursor_close(cursorID C.uint64_t) C.int {
+	cursorsMutex.Lock()
+	defer cursorsMutex.Unlock()
+
+	if _, exists := cursors[uint64(cursorID)]; !exists {
+		return -1
+	}
+	delete(cursors, uint64(cursorID))
+	return 0
+}
@@ -0,0 +1,277 @@
+/*
+ * pgraft_go_ddl.go
+ * Replication of DDL statements through the raft log
+ *
+ * pgraft_go_propose_ddl() appends a statement to the log as a typed
+ * entry.  Every node applies DDL entries in log order by queueing them
+ * for the extension, which executes them from its worker loop via the
+ * callback run by pgraft_go_drain_ddl() and then reports the outcome with
+ * pgraft_go_ddl_ack().  Acknowledgments are themselves raft entries, so
+ * every member sees which nodes applied each statement, and
+ * pgraft_go_ddl_status() reports them together with the voters still
+ * outstanding.  The latest ddlHistoryLimit statements are kept.
+ */
+
+package main
+
+/*
+#include <stdint.h>
+#include <stdlib.h>
+
+typedef void (*pgraft_go_ddl_callback) (uint64_t ddl_id, uint64_t origin_node, uint64_t index, const char *statement, void *arg);
+
+static inline void
+pgraft_go_call_ddl_callback(pgraft_go_ddl_callback cb, uint64_t ddl_id, uint64_t origin_node, uint64_t index, const char *statement, void *arg)
+{
+	cb(ddl_id, origin_node, index, statement, arg);
+}
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"unsafe"
+)
+
+// Number of DDL statements whose status is retained
+const ddlHistoryLimit = 1000
+
+type ddlEntryBody struct {
+	ID        uint64 `json:"id"`
+	Origin    uint64 `json:"origin"`
+	Statement string `json:"statement"`
+}
+
+type ddlAckBody struct {
+	ID      uint64 `json:"id"`
+	Node    uint64 `json:"node"`
+	Status  int    `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+type ddlAck struct {
+	Node    uint64 `json:"node"`
+	OK      bool   `json:"ok"`
+	Status  int    `json:"status"`
+	Message string `json:"message,omitempty"`
+	Index   uint64 `json:"index"`
+}
+
+type ddlRecord struct {
+	ID        uint64
+	Origin    uint64
+	Statement string
+	Index     uint64
+	Term      uint64
+	Acks      map[uint64]ddlAck
+}
+
+var (
+	ddlRecords = make(map[uint64]*ddlRecord)
+	ddlOrder   []uint64
+
+	// Applied statements not yet handed to the extension
+	ddlQueue []*ddlRecord
+
+	ddlCallback    C.pgraft_go_ddl_callback
+	ddlCallbackArg unsafe.Pointer
+	ddlMutex       sync.Mutex
+)
+
+func applyDDLEntry(index, term uint64, body []byte) {
+	var ddl ddlEntryBody
+	if err := json.Unmarshal(body, &ddl); err != nil {
+		log.Printf("pgraft: ERROR - Malformed DDL entry at index %d: %v", index, err)
+		return
+	}
+
+	ddlMutex.Lock()
+	defer ddlMutex.Unlock()
+
+	if _, exists := ddlRecords[ddl.ID]; exists {
+		return
+	}
+	record := &ddlRecord{
+		ID:        ddl.ID,
+		Origin:    ddl.Origin,
+		Statement: ddl.Statement,
+		Index:     index,
+		Term:      term,
+		Acks:      make(map[uint64]ddlAck),
+	}
+	ddlRecords[ddl.ID] = record
+	ddlOrder = append(ddlOrder, ddl.ID)
+	if len(ddlOrder) > ddlHistoryLimit {
+		delete(ddlRecords, ddlOrder[0])
+		ddlOrder = ddlOrder[1:]
+	}
+	ddlQueue = append(ddlQueue, record)
+
+	debugLog("ddl: statement %d committed at index %d", ddl.ID, index)
+}
+
+func applyDDLAckEntry(index, term uint64, body []byte) {
+	var ack ddlAckBody
+	if err := json.Unmarshal(body, &ack); err != nil {
+		log.Printf("pgraft: ERROR - Malformed DDL acknowledgment at index %d: %v", index, err)
+		return
+	}
+
+	ddlMutex.Lock()
+	defer ddlMutex.Unlock()
+
+	record, exists := ddlRecords[ack.ID]
+	if !exists {
+		return
+	}
+	record.Acks[ack.Node] = ddlAck{
+		Node:    ack.Node,
+		OK:      ack.Status == 0,
+		Status:  ack.Status,
+		Message: ack.Message,
+		Index:   index,
+	}
+	if ack.Status != 0 {
+		log.Printf("pgraft: WARNING - Node %d failed to apply DDL %d: %s", ack.Node, ack.ID, ack.Message)
+	}
+}
+
+func resetDDL() {
+	ddlMutex.Lock()
+	ddlRecords = make(map[uint64]*ddlRecord)
+	ddlOrder = nil
+	ddlQueue = nil
+	ddlCallback = nil
+	ddlCallbackArg = nil
+	ddlMutex.Unlock()
+}
+
+// Replicate a DDL statement; its ID is stored in *ddlID
+//
+//export pgraft_go_propose_ddl
+func pgraft_go_propose_ddl(statement *C.char, ddlID *C.uint64_t) C.int {
+	if statement == nil || C.GoString(statement) == "" {
+		return errInvalidArgument
+	}
+
+	var origin uint64
+	if activeConfig != nil {
+		origin = activeConfig.NodeID
+	}
+	body := ddlEntryBody{
+		ID:        newReplicatedID(),
+		Origin:    origin,
+		Statement: C.GoString(statement),
+	}
+
+	rc := proposeTypedEntry(typedEntryDDL, body)
+	if rc == errOK && ddlID != nil {
+		*ddlID = C.uint64_t(body.ID)
+	}
+	return C.int(rc)
+}
+
+// Record this node's outcome for a statement; status 0 means applied
+//
+//export pgraft_go_ddl_ack
+func pgraft_go_ddl_ack(ddlID C.uint64_t, status C.int, message *C.char) C.int {
+	if activeConfig == nil {
+		return errNotInitialized
+	}
+
+	ack := ddlAckBody{
+		ID:     uint64(ddlID),
+		Node:   activeConfig.NodeID,
+		Status: int(status),
+	}
+	if message != nil {
+		ack.Message = C.GoString(message)
+	}
+	return C.int(proposeTypedEntry(typedEntryDDLAck, ack))
+}
+
+// Register the callback used by pgraft_go_drain_ddl(); NULL unregisters
+//
+//export pgraft_go_set_ddl_callback
+func pgraft_go_set_ddl_callback(callback C.pgraft_go_ddl_callback, arg unsafe.Pointer) {
+	ddlMutex.Lock()
+	ddlCallback = callback
+	ddlCallbackArg = arg
+	ddlMutex.Unlock()
+}
+
+// Hand committed statements to the callback on the calling thread, in log
+// order; returns the number delivered
+//
+//export pgraft_go_drain_ddl
+func pgraft_go_drain_ddl() C.int {
+	ddlMutex.Lock()
+	callback, arg := ddlCallback, ddlCallbackArg
+	if callback == nil {
+		ddlMutex.Unlock()
+		return 0
+	}
+	queue := ddlQueue
+	ddlQueue = nil
+	ddlMutex.Unlock()
+
+	for _, record := range queue {
+		statement := C.CString(record.Statement)
+		C.pgraft_go_call_ddl_callback(callback, C.uint64_t(record.ID), C.uint64_t(record.Origin),
+			C.uint64_t(record.Index), statement, arg)
+		C.free(unsafe.Pointer(statement))
+	}
+	return C.int(len(queue))
+}
+
+// Status of a replicated statement as JSON, or NULL if unknown; free with
+// pgraft_go_free_string()
+//
+//export pgraft_go_ddl_status
+func pgraft_go_ddl_status(ddlID C.uint64_t) *C.char {
+	voters := sortedVoters()
+
+	ddlMutex.Lock()
+	record, exists := ddlRecords[uint64(ddlID)]
+	if !exists {
+		ddlMutex.Unlock()
+		return nil
+	}
+
+	acks := make([]ddlAck, 0, len(record.Acks))
+	for _, ack := range record.Acks {
+		acks = append(acks, ack)
+	}
+	pending := make([]uint64, 0)
+	failed := false
+	for _, id := range voters {
+		ack, acked := record.Acks[id]
+		if !acked {
+			pending = append(pending, id)
+		} else if !ack.OK {
+			failed = true
+		}
+	}
+	status := map[string]interface{}{
+		"id":        record.ID,
+		"origin":    record.Origin,
+		"statement": record.Statement,
+		"index":     record.Index,
+		"term":      record.Term,
+		"acks":      acks,
+		"pending":   pending,
+		"complete":  len(pending) == 0 && !failed,
+		"failed":    failed,
+	}
+	ddlMutex.Unlock()
+
+	sort.Slice(acks, func(i, j int) bool { return acks[i].Node < acks[j].Node })
+	jsonData, err := json.Marshal(status)
+	if err != nil {
+		return C.CString("{\"error\": \"failed to marshal DDL status\"}")
+	}
+	return C.CString(string(jsonData))
+}
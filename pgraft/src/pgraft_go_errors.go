@@ -0,0 +1,93 @@
+/*
+ * pgraft_go_errors.go
+ * Stable error codes and their descriptions
+ *
+ * Exports that return an int report failure with one of the negative
+ * codes below.  Codes are part of the ABI and are never renumbered; new
+ * codes are only appended.  pgraft_go_strerror() returns a static English
+ * description suitable for wrapping in gettext on the C side, and
+ * pgraft_go_error_name() the symbolic name used by PGRAFT_ERR_* in
+ * pgraft_go.h.  Both return pointers to static storage that must not be
+ * freed.
+ */
+
+package main
+
+/*
+#include <stddef.h>
+
+typedef struct pgraft_go_error_entry
+{
+	const char *name;
+	const char *message;
+} pgraft_go_error_entry;
+
+// Indexed by the negated error code; keep in sync with the Go constants
+static const pgraft_go_error_entry pgraft_go_error_table[] = {
+	{"PGRAFT_OK", "success"},
+	{"PGRAFT_ERR_FAILED", "operation failed"},
+	{"PGRAFT_ERR_NOT_INITIALIZED", "raft node is not initialized"},
+	{"PGRAFT_ERR_NOT_RUNNING", "raft node is not running"},
+	{"PGRAFT_ERR_ALREADY_INITIALIZED", "raft node is already initialized"},
+	{"PGRAFT_ERR_INVALID_ARGUMENT", "invalid argument"},
+	{"PGRAFT_ERR_INVALID_HANDLE", "invalid or closed handle"},
+	{"PGRAFT_ERR_NOT_LEADER", "this node is not the raft leader"},
+	{"PGRAFT_ERR_PROPOSAL_DROPPED", "proposal was dropped"},
+	{"PGRAFT_ERR_TIMEOUT", "operation timed out"},
+	{"PGRAFT_ERR_STORAGE", "raft storage error"},
+	{"PGRAFT_ERR_NETWORK", "network error"},
+	{"PGRAFT_ERR_CONFIG", "invalid configuration"},
+	{"PGRAFT_ERR_TLS", "TLS configuration error"},
+	{"PGRAFT_ERR_COMPACTED", "requested log entries have been compacted"},
+	{"PGRAFT_ERR_NOT_FOUND", "object not found"},
+	{"PGRAFT_ERR_PAUSED", "proposals are paused for a switchover"},
+	{"PGRAFT_ERR_FENCING", "writes are blocked until the previous leader is fenced"},
+};
+
+static inline const char *
+pgraft_go_error_field(int code, int want_name)
+{
+	int			idx = -code;
+
+	if (idx < 0 || (size_t) idx >= sizeof(pgraft_go_error_table) / sizeof(pgraft_go_error_table[0]))
+		return want_name ? "PGRAFT_ERR_UNKNOWN" : "unknown error";
+	return want_name ? pgraft_go_error_table[idx].name : pgraft_go_error_table[idx].message;
+}
+*/
+import "C"
+
+// Error codes, mirrored by PGRAFT_OK and PGRAFT_ERR_* in pgraft_go.h
+const (
+	errOK                 = 0
+	errFailed             = -1
+	errNotInitialized     = -2
+	errNotRunning         = -3
+	errAlreadyInitialized = -4
+	errInvalidArgument    = -5
+	errInvalidHandle      = -6
+	errNotLeader          = -7
+	errProposalDropped    = -8
+	errTimeout            = -9
+	errStorage            = -10
+	errNetwork            = -11
+	errConfig             = -12
+	errTLS                = -13
+	errCompacted          = -14
+	errNotFound           = -15
+	errPaused             = -16
+	errFencing            = -17
+)
+
+// Describe an error code; unknown codes yield "unknown error"
+//
+//export pgraft_go_strerror
+func pgraft_go_strerror(code C.int) *C.char {
+	return C.pgraft_go_error_field(code, 0)
+}
+
+// Symbolic name of an error code, e.g. "PGRAFT_ERR_NOT_LEADER"
+//
+//export pgraft_go_error_name
+func pgraft_go_error_name(code C.int) *C.char {
+	return C.pgraft_go_error_field(code, 1)
+}
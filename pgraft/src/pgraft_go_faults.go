@@ -0,0 +1,211 @@
+//go:build pgraft_faults
+
+/*
+ * pgraft_go_faults.go
+ * Fault injection for chaos testing
+ *
+ * Only built with -tags pgraft_faults; production libraries contain the
+ * no-op hooks of pgraft_go_faults_off.go instead and do not export these
+ * functions.  Rules apply to the messages this node sends to a peer (0
+ * for every peer): drop them, delay them, hold one back so that it is
+ * overtaken by the next, or flip a byte of the frame.  Each rule fires
+ * with a probability drawn from a random source seeded with
+ * pgraft_go_fault_seed(), so a CI run is reproduced by reusing the seed.
+ * To cut the link between two peers, install a drop rule on both.
+ * pgraft_go_fault_crash_ready_loop() makes the Ready loop exit as if it
+ * had crashed, leaving the node unable to make progress.
+ */
+
+package main
+
+/*
+#include <stdint.h>
+*/
+import "C"
+
+import (
+	"log"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+// Fault kinds, mirrored by PGRAFT_FAULT_* in pgraft_go.h
+const (
+	faultDrop    = 1
+	faultDelay   = 2
+	faultReorder = 3
+	faultCorrupt = 4
+)
+
+const faultCapabilities = capFaultInjection
+
+type faultRule struct {
+	kind        int
+	probability float64
+	delay       time.Duration
+}
+
+type faultKey struct {
+	to   uint64
+	kind int
+}
+
+var (
+	faultRules = make(map[faultKey]faultRule)
+
+	// Frame held back by a reorder rule, by peer
+	faultHeld = make(map[uint64]func())
+
+	faultRand  = rand.New(rand.NewSource(1))
+	faultMutex sync.Mutex
+
+	faultCrashReady int32
+	faultsInjected  int64
+)
+
+// Rule of the given kind for a peer, falling back to the rule for all
+// peers; faultMutex must be held
+func faultRuleFor(to uint64, kind int) (faultRule, bool) {
+	if rule, exists := faultRules[faultKey{to, kind}]; exists {
+		return rule, true
+	}
+	rule, exists := faultRules[faultKey{0, kind}]
+	return rule, exists
+}
+
+// True if a rule of the given kind fires; faultMutex must be held
+func faultFires(to uint64, kind int) (faultRule, bool) {
+	rule, exists := faultRuleFor(to, kind)
+	if !exists || faultRand.Float64() >= rule.probability {
+		return rule, false
+	}
+	atomic.AddInt64(&faultsInjected, 1)
+	return rule, true
+}
+
+// Send a frame through the fault rules; send writes it to the peer
+func injectSendFaults(msg raftpb.Message, data []byte, send func([]byte)) {
+	faultMutex.Lock()
+	if _, fires := faultFires(msg.To, faultDrop); fires {
+		faultMutex.Unlock()
+		debugLog("faults: dropped %s to node %d", msg.Type, msg.To)
+		return
+	}
+	if _, fires := faultFires(msg.To, faultCorrupt); fires && len(data) > 0 {
+		data = append([]byte(nil), data...)
+		data[faultRand.Intn(len(data))] ^= byte(1 + faultRand.Intn(255))
+		debugLog("faults: corrupted %s to node %d", msg.Type, msg.To)
+	}
+	held := faultHeld[msg.To]
+	delete(faultHeld, msg.To)
+	if held == nil {
+		if _, fires := faultFires(msg.To, faultReorder); fires {
+			faultHeld[msg.To] = func() { send(data) }
+			faultMutex.Unlock()
+			debugLog("faults: holding back %s to node %d", msg.Type, msg.To)
+			return
+		}
+	}
+	rule, delayed := faultFires(msg.To, faultDelay)
+	faultMutex.Unlock()
+
+	deliver := func() {
+		send(data)
+		if held != nil {
+			held()
+		}
+	}
+	if delayed {
+		time.AfterFunc(rule.delay, deliver)
+		return
+	}
+	deliver()
+}
+
+// True once the Ready loop has been told to crash
+func readyLoopCrashed() bool {
+	if atomic.LoadInt32(&faultCrashReady) == 0 {
+		return false
+	}
+	log.Printf("pgraft: WARNING - Fault injection: Ready loop crashed")
+	return true
+}
+
+func resetFaults() {
+	faultMutex.Lock()
+	faultRules = make(map[faultKey]faultRule)
+	faultHeld = make(map[uint64]func())
+	faultRand = rand.New(rand.NewSource(1))
+	faultMutex.Unlock()
+	atomic.StoreInt32(&faultCrashReady, 0)
+	atomic.StoreInt64(&faultsInjected, 0)
+}
+
+// Install a rule for messages to peer (0: every peer), replacing any rule
+// of the same kind; probability 0 removes it.  delayMs is used by
+// PGRAFT_FAULT_DELAY only
+//
+//export pgraft_go_fault_set
+func pgraft_go_fault_set(peer C.uint64_t, kind C.int, probability C.double, delayMs C.int) C.int {
+	if kind < faultDrop || kind > faultCorrupt || probability < 0 || probability > 1 ||
+		(kind == faultDelay && delayMs <= 0) {
+		return errInvalidArgument
+	}
+
+	key := faultKey{uint64(peer), int(kind)}
+	faultMutex.Lock()
+	defer faultMutex.Unlock()
+	if probability == 0 {
+		delete(faultRules, key)
+		return errOK
+	}
+	faultRules[key] = faultRule{
+		kind:        int(kind),
+		probability: float64(probability),
+		delay:       time.Duration(delayMs) * time.Millisecond,
+	}
+	log.Printf("pgraft: WARNING - Fault injection: rule %d for node %d with probability %.2f", int(kind), uint64(peer), float64(probability))
+	return errOK
+}
+
+// Remove every rule and release frames held back for reordering
+//
+//export pgraft_go_fault_clear
+func pgraft_go_fault_clear() {
+	faultMutex.Lock()
+	held := faultHeld
+	faultRules = make(map[faultKey]faultRule)
+	faultHeld = make(map[uint64]func())
+	faultMutex.Unlock()
+
+	for _, send := range held {
+		send()
+	}
+}
+
+// Seed the random source deciding which messages rules apply to
+//
+//export pgraft_go_fault_seed
+func pgraft_go_fault_seed(seed C.int64_t) {
+	faultMutex.Lock()
+	faultRand = rand.New(rand.NewSource(int64(seed)))
+	faultMutex.Unlock()
+}
+
+// Make the Ready loop exit before processing its next batch
+//
+//export pgraft_go_fault_crash_ready_loop
+func pgraft_go_fault_crash_ready_loop() {
+	atomic.StoreInt32(&faultCrashReady, 1)
+}
+
+// Number of faults injected so far
+//
+//export pgraft_go_faults_injected
+func pgraft_go_faults_injected() C.int64_t {
+	return C.int64_t(atomic.LoadInt64(&faultsInjected))
+}
@@ -0,0 +1,28 @@
+//go:build !pgraft_faults
+
+/*
+ * pgraft_go_faults_off.go
+ * Fault injection hooks of production builds
+ *
+ * Without the pgraft_faults build tag every hook passes messages through
+ * untouched and the fault injection exports do not exist.
+ */
+
+package main
+
+import (
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+const faultCapabilities = 0
+
+func injectSendFaults(msg raftpb.Message, data []byte, send func([]byte)) {
+	send(data)
+}
+
+func readyLoopCrashed() bool {
+	return false
+}
+
+func resetFaults() {
+}
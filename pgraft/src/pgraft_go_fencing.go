@@ -0,0 +1,216 @@
+/*
+ * pgraft_go_fencing.go
+ * Fencing of the previous primary before a new leader accepts writes
+ *
+ * When a fencing callback is registered, a node that wins an election
+ * rejects proposals with PGRAFT_ERR_FENCING and does not report a
+ * promotion until the callback has succeeded for the new term.  The
+ * extension runs the callback from its worker loop with
+ * pgraft_go_run_fencing(), on the calling thread, so it may STONITH the
+ * old primary or move a VIP with normal backend facilities.  If the
+ * callback fails, or has not succeeded within the fencing timeout, the
+ * failure policy decides: PGRAFT_FENCE_STEP_DOWN hands leadership to the
+ * most caught-up voter and keeps rejecting writes, PGRAFT_FENCE_PROCEED
+ * logs the failure and accepts writes anyway.
+ */
+
+package main
+
+/*
+#include <stdint.h>
+
+typedef int (*pgraft_go_fencing_callback) (uint64_t term, uint64_t previous_leader, void *arg);
+
+static inline int
+pgraft_go_call_fencing_callback(pgraft_go_fencing_callback cb, uint64_t term, uint64_t previous_leader, void *arg)
+{
+	return cb(term, previous_leader, arg);
+}
+*/
+import "C"
+
+import (
+	"log"
+	"sync"
+	"time"
+	"unsafe"
+
+	"go.etcd.io/raft/v3"
+)
+
+// Failure policies, mirrored by PGRAFT_FENCE_* in pgraft_go.h
+const (
+	fencePolicyStepDown = 0
+	fencePolicyProceed  = 1
+)
+
+const defaultFencingTimeout = 10 * time.Second
+
+var (
+	fencingCallback C.pgraft_go_fencing_callback
+	fencingArg      unsafe.Pointer
+	fencingTimeout  = defaultFencingTimeout
+	fencingPolicy   = fencePolicyStepDown
+
+	// Term in which this node must fence before accepting writes, or 0
+	fencingTerm uint64
+
+	// Last term fenced successfully or waived by policy
+	fencedTerm uint64
+
+	fencingSince   time.Time
+	fencingGaveUp  bool
+	previousLeader uint64
+	fencingMutex   sync.Mutex
+)
+
+// True while this node leads but has not completed fencing, including
+// the window before the ticker notices a new term
+func fencingPending() bool {
+	fencingMutex.Lock()
+	defer fencingMutex.Unlock()
+
+	if fencingTerm != 0 {
+		return true
+	}
+	if fencingCallback == nil {
+		return false
+	}
+	snapshot := loadStatusSnapshot()
+	return snapshot != nil && snapshot.RaftState == raft.StateLeader && snapshot.Term != fencedTerm
+}
+
+// Resolve the pending fencing for term; fencingMutex must be held
+func finishFencing(term uint64) {
+	fencedTerm = term
+	fencingTerm = 0
+	fencingGaveUp = false
+}
+
+// Apply the failure policy; fencingMutex must be held
+func fencingFailed(reason string) {
+	if fencingPolicy == fencePolicyProceed {
+		log.Printf("pgraft: WARNING - Fencing for term %d %s, accepting writes by policy", fencingTerm, reason)
+		finishFencing(fencingTerm)
+		return
+	}
+
+	if fencingGaveUp {
+		return
+	}
+	fencingGaveUp = true
+	log.Printf("pgraft: ERROR - Fencing for term %d %s, stepping down", fencingTerm, reason)
+
+	node := raftNode
+	if node == nil {
+		return
+	}
+	status := node.Status()
+	var target, targetMatch uint64
+	for id := range status.Config.Voters.IDs() {
+		if pr, tracked := status.Progress[id]; id != status.ID && tracked && pr.Match >= targetMatch {
+			target, targetMatch = id, pr.Match
+		}
+	}
+	if target != 0 {
+		go node.TransferLeadership(raftCtx, status.ID, target)
+	}
+}
+
+// Track leadership and fencing deadlines; called on every tick
+func evaluateFencing() {
+	snapshot := loadStatusSnapshot()
+	if snapshot == nil {
+		return
+	}
+
+	fencingMutex.Lock()
+	defer fencingMutex.Unlock()
+
+	isLeader := snapshot.RaftState == raft.StateLeader
+	switch {
+	case !isLeader:
+		if snapshot.LeaderID != 0 && snapshot.LeaderID != snapshot.NodeID {
+			previousLeader = snapshot.LeaderID
+		}
+		fencingTerm = 0
+		fencingGaveUp = false
+	case fencingCallback == nil:
+		fencedTerm = snapshot.Term
+	case fencingTerm == 0 && fencedTerm != snapshot.Term:
+		fencingTerm = snapshot.Term
+		fencingSince = time.Now()
+		log.Printf("pgraft: INFO - Elected leader in term %d, fencing node %d before accepting writes",
+			snapshot.Term, previousLeader)
+	case fencingTerm != 0 && time.Since(fencingSince) > fencingTimeout:
+		fencingFailed("timed out")
+	}
+}
+
+func resetFencing() {
+	fencingMutex.Lock()
+	fencingCallback = nil
+	fencingArg = nil
+	fencingTerm = 0
+	fencedTerm = 0
+	fencingGaveUp = false
+	previousLeader = 0
+	fencingMutex.Unlock()
+}
+
+// Register the fencing callback with its timeout and failure policy; a
+// NULL callback disables fencing
+//
+//export pgraft_go_set_fencing_callback
+func pgraft_go_set_fencing_callback(callback C.pgraft_go_fencing_callback, arg unsafe.Pointer, timeoutMs C.int, policy C.int) C.int {
+	if callback != nil && (timeoutMs <= 0 || (policy != fencePolicyStepDown && policy != fencePolicyProceed)) {
+		return errInvalidArgument
+	}
+
+	fencingMutex.Lock()
+	defer fencingMutex.Unlock()
+
+	fencingCallback = callback
+	fencingArg = arg
+	if callback == nil {
+		fencingTerm = 0
+		return errOK
+	}
+	fencingTimeout = time.Duration(timeoutMs) * time.Millisecond
+	fencingPolicy = int(policy)
+	return errOK
+}
+
+// Run the fencing callback on the calling thread if fencing is pending;
+// returns 1 if fencing succeeded, 0 if nothing was pending, or an error
+// code if the callback failed
+//
+//export pgraft_go_run_fencing
+func pgraft_go_run_fencing() C.int {
+	fencingMutex.Lock()
+	callback, arg := fencingCallback, fencingArg
+	term, oldLeader := fencingTerm, previousLeader
+	if callback == nil || term == 0 || fencingGaveUp {
+		fencingMutex.Unlock()
+		return 0
+	}
+	fencingMutex.Unlock()
+
+	rc := C.pgraft_go_call_fencing_callback(callback, C.uint64_t(term), C.uint64_t(oldLeader), arg)
+
+	fencingMutex.Lock()
+	defer fencingMutex.Unlock()
+
+	// Leadership may have been lost while the callback ran
+	if fencingTerm != term {
+		return 0
+	}
+	if rc != 0 {
+		fencingFailed("callback failed")
+		return errFailed
+	}
+
+	log.Printf("pgraft: INFO - Fencing for term %d completed, accepting writes", term)
+	finishFencing(term)
+	return 1
+}
@@ -0,0 +1,224 @@
+/*
+ * pgraft_go_handle.go
+ * Opaque, reference-counted handles for the Go Raft node
+ *
+ * pgraft_go_open() initializes the node and returns a pgraft_handle_t that
+ * the handle-based exports below require.  Each PostgreSQL background
+ * worker that shares the node takes its own reference with
+ * pgraft_go_handle_acquire() and drops it with pgraft_go_close(); the node
+ * is stopped when the last reference goes away.  Handles are plain
+ * integers, so no Go pointer ever crosses into C.
+ *
+ * The library hosts a single node per process, and a handle names that
+ * node rather than holding state of its own: the handle exports below
+ * check the handle and then act on the process-wide node, exactly as the
+ * handle-less exports do, and only those below take a handle.
+ * pgraft_go_open() therefore fails while a node is open or initialized
+ * through pgraft_go_init(); further workers share the node through
+ * pgraft_go_handle_acquire().  Stopping the node, with or without a
+ * handle, closes its handle.
+ *
+ * Handles do not replace the process-wide node: its state stays in the
+ * package globals, every other export works without a handle, and one
+ * process cannot host nodes of two clusters.
+ */
+
+package main
+
+/*
+#include <stdint.h>
+
+typedef uint64_t pgraft_handle_t;
+*/
+import "C"
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+type pgraftHandle struct {
+	id     uint64
+	nodeID uint64
+	refs   int32
+}
+
+var (
+	handles      = make(map[uint64]*pgraftHandle)
+	handlesMutex sync.Mutex
+	nextHandleID uint64
+)
+
+// Resolve a handle passed in from C; nil if it is unknown or closed
+func lookupHandle(h C.pgraft_handle_t) *pgraftHandle {
+	handlesMutex.Lock()
+	defer handlesMutex.Unlock()
+
+	handle, exists := handles[uint64(h)]
+	if !exists || handle.refs <= 0 {
+		return nil
+	}
+	return handle
+}
+
+//export pgraft_go_open
+func pgraft_go_open(nodeID C.int, address *C.char, port C.int) C.pgraft_handle_t {
+	handlesMutex.Lock()
+	defer handlesMutex.Unlock()
+
+	for _, handle := range handles {
+		log.Printf("pgraft: ERROR - Node %d already open in this process as handle %d, cannot open node %d",
+			handle.nodeID, handle.id, nodeID)
+		return 0
+	}
+	if atomic.LoadInt32(&initialized) == 1 {
+		log.Printf("pgraft: ERROR - A node is already initialized in this process, cannot open node %d", nodeID)
+		return 0
+	}
+
+	if pgraft_go_init(nodeID, address, port) != 0 {
+		return 0
+	}
+
+	nextHandleID++
+	handle := &pgraftHandle{
+		id:     nextHandleID,
+		nodeID: uint64(nodeID),
+		refs:   1,
+	}
+	handles[handle.id] = handle
+
+	log.Printf("pgraft: INFO - Opened handle %d for node %d", handle.id, nodeID)
+	return C.pgraft_handle_t(handle.id)
+}
+
+// Take an additional reference; returns the new reference count or -1
+//
+//export pgraft_go_handle_acquire
+func pgraft_go_handle_acquire(h C.pgraft_handle_t) C.int {
+	handlesMutex.Lock()
+	defer handlesMutex.Unlock()
+
+	handle, exists := handles[uint64(h)]
+	if !exists || handle.refs <= 0 {
+		return -1
+	}
+
+	handle.refs++
+	return C.int(handle.refs)
+}
+
+// Drop a reference, stopping the node when the last one is released;
+// returns the remaining reference count or -1 for an invalid handle
+//
+//export pgraft_go_close
+func pgraft_go_close(h C.pgraft_handle_t) C.int {
+	handlesMutex.Lock()
+	handle, exists := handles[uint64(h)]
+	if !exists || handle.refs <= 0 {
+		handlesMutex.Unlock()
+		return -1
+	}
+
+	handle.refs--
+	remaining := handle.refs
+	if remaining == 0 {
+		delete(handles, handle.id)
+	}
+	handlesMutex.Unlock()
+
+	if remaining == 0 {
+		log.Printf("pgraft: INFO - Last reference to handle %d released, stopping node %d", handle.id, handle.nodeID)
+		pgraft_go_stop()
+	}
+
+	return C.int(remaining)
+}
+
+// Forget the handles of a node that has stopped
+func resetHandles() {
+	handlesMutex.Lock()
+	handles = make(map[uint64]*pgraftHandle)
+	handlesMutex.Unlock()
+}
+
+//export pgraft_go_handle_valid
+func pgraft_go_handle_valid(h C.pgraft_handle_t) C.int {
+	if lookupHandle(h) == nil {
+		return 0
+	}
+	return 1
+}
+
+//export pgraft_go_handle_start
+func pgraft_go_handle_start(h C.pgraft_handle_t) C.int {
+	if lookupHandle(h) == nil {
+		return errInvalidHandle
+	}
+	return pgraft_go_start()
+}
+
+//export pgraft_go_handle_add_peer
+func pgraft_go_handle_add_peer(h C.pgraft_handle_t, nodeID C.int, address *C.char, port C.int) C.int {
+	if lookupHandle(h) == nil {
+		return errInvalidHandle
+	}
+	return pgraft_go_add_peer(nodeID, address, port)
+}
+
+//export pgraft_go_handle_remove_peer
+func pgraft_go_handle_remove_peer(h C.pgraft_handle_t, nodeID C.int) C.int {
+	if lookupHandle(h) == nil {
+		return errInvalidHandle
+	}
+	return pgraft_go_remove_peer(nodeID)
+}
+
+//export pgraft_go_handle_get_state
+func pgraft_go_handle_get_state(h C.pgraft_handle_t) *C.char {
+	if lookupHandle(h) == nil {
+		return C.CString("invalid")
+	}
+	return pgraft_go_get_state()
+}
+
+//export pgraft_go_handle_get_leader
+func pgraft_go_handle_get_leader(h C.pgraft_handle_t) C.int64_t {
+	if lookupHandle(h) == nil {
+		return -1
+	}
+	return pgraft_go_get_leader()
+}
+
+//export pgraft_go_handle_get_term
+func pgraft_go_handle_get_term(h C.pgraft_handle_t) C.int32_t {
+	if lookupHandle(h) == nil {
+		return -1
+	}
+	return pgraft_go_get_term()
+}
+
+//export pgraft_go_handle_is_leader
+func pgraft_go_handle_is_leader(h C.pgraft_handle_t) C.int {
+	if lookupHandle(h) == nil {
+		return 0
+	}
+	return pgraft_go_is_leader()
+}
+
+//export pgraft_go_handle_append_log
+func pgraft_go_handle_append_log(h C.pgraft_handle_t, data *C.char, length C.int) C.int {
+	if lookupHandle(h) == nil {
+		return errInvalidHandle
+	}
+	return pgraft_go_append_log(data, length)
+}
+
+//export pgraft_go_handle_get_stats
+func pgraft_go_handle_get_stats(h C.pgraft_handle_t) *C.char {
+	if lookupHandle(h) == nil {
+		return C.CString("{\"error\": \"invalid handle\"}")
+	}
+	return pgraft_go_get_stats()
+}
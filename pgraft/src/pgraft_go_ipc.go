@@ -0,0 +1,115 @@
+/*
+ * pgraft_go_ipc.go
+ * Shared-memory event queue with file-descriptor wakeup
+ *
+ * Instead of polling the exports, the C worker attaches a shared-memory
+ * region and a wakeup descriptor (an eventfd created with EFD_NONBLOCK, or
+ * the write end of a non-blocking pipe).  Raft events are appended to the
+ * region using the ring layout from pgraft_go_ring.go, with the event type
+ * in the record flags, and the descriptor is signalled so the worker can
+ * sleep in WaitLatchOrSocket() and wake only when there is work.
+ */
+
+package main
+
+/*
+#include <stddef.h>
+*/
+import "C"
+
+import (
+	"encoding/binary"
+	"log"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+// Event types stored in the record flags, mirrored by PGRAFT_EVENT_* in
+// pgraft_go.h
+const (
+	eventCommitted    = 1
+	eventLeader       = 2
+	eventState        = 3
+	eventMembership   = 4
+	eventCompletion   = 5
+	eventSyncStandbys = 6
+	eventRole         = 7
+	eventHealth       = 8
+	eventQueueWakeup  = 1
+)
+
+var (
+	eventRing     *shmRing
+	eventWakeupFd int = -1
+	eventMutex    sync.Mutex
+	eventDropped  int64
+
+	// Leader last reported, so leader events fire only on change
+	lastEventLeader uint64
+)
+
+//export pgraft_go_event_attach
+func pgraft_go_event_attach(region unsafe.Pointer, size C.size_t, wakeupFd C.int) C.int {
+	eventMutex.Lock()
+	defer eventMutex.Unlock()
+
+	if eventRing != nil {
+		log.Printf("pgraft: WARNING - Event queue already attached")
+		return -1
+	}
+
+	ring := newShmRing(region, uintptr(size))
+	if ring == nil {
+		return -1
+	}
+
+	eventRing = ring
+	eventWakeupFd = int(wakeupFd)
+	log.Printf("pgraft: INFO - Event queue attached (wakeup fd %d)", eventWakeupFd)
+	return 0
+}
+
+//export pgraft_go_event_detach
+func pgraft_go_event_detach() {
+	eventMutex.Lock()
+	defer eventMutex.Unlock()
+
+	eventRing = nil
+	eventWakeupFd = -1
+}
+
+// Number of events dropped because the queue was full
+//
+//export pgraft_go_event_dropped
+func pgraft_go_event_dropped() C.longlong {
+	return C.longlong(atomic.LoadInt64(&eventDropped))
+}
+
+// Queue an event for the C worker and signal its wakeup descriptor, and
+// send it to socket subscribers
+func emitRaftEvent(eventType uint32, index uint64, term uint64, payload []byte) {
+	publishToSubscribers(eventType, index, term, payload)
+
+	eventMutex.Lock()
+	defer eventMutex.Unlock()
+
+	if eventRing == nil {
+		return
+	}
+
+	if !eventRing.push(payload, eventType, index, term) {
+		atomic.AddInt64(&eventDropped, 1)
+		debugLog("event queue full, dropped event type %d", eventType)
+		return
+	}
+
+	if eventWakeupFd >= 0 {
+		// eventfd requires an 8-byte write; EAGAIN means a wakeup is
+		// already pending, which is all the worker needs
+		var buf [8]byte
+		binary.NativeEndian.PutUint64(buf[:], eventQueueWakeup)
+		syscall.Write(eventWakeupFd, buf[:])
+	}
+}
@@ -0,0 +1,346 @@
+/*
+ * pgraft_go_kv.go
+ * Key-value metadata store replicated through the raft log
+ *
+ * A small built-in DCS for cluster metadata such as failover state and
+ * node tags.  Writes (put, delete, compare-and-swap) are typed entries and
+ * block until applied locally or the timeout passes; reads are served
+ * from the local replica.  Each key carries the index of the entry that
+ * last wrote it as its revision, which compare-and-swap checks; a
+ * revision of 0 means the key must not exist.  Watches match a key prefix
+ * and their events are delivered in log order on the thread calling
+ * pgraft_go_drain_kv_events().
+ */
+
+package main
+
+/*
+#include <stdint.h>
+#include <stdlib.h>
+
+typedef void (*pgraft_go_kv_watch_callback) (uint64_t watch_id, int event, const char *key, const char *value, int value_len, uint64_t revision, void *arg);
+
+static inline void
+pgraft_go_call_kv_watch_callback(pgraft_go_kv_watch_callback cb, uint64_t watch_id, int event, const char *key, const char *value, int value_len, uint64_t revision, void *arg)
+{
+	cb(watch_id, event, key, value, value_len, revision, arg);
+}
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// KV write operations carried in typedEntryKV entries
+const (
+	kvOpPut    = "put"
+	kvOpDelete = "delete"
+	kvOpCAS    = "cas"
+)
+
+// Watch event types, mirrored by PGRAFT_KV_EVENT_* in pgraft_go.h
+const (
+	kvEventPut    = 1
+	kvEventDelete = 2
+)
+
+// Undelivered watch events kept before the oldest are dropped
+const kvEventLimit = 10000
+
+type kvEntryBody struct {
+	RequestID        uint64 `json:"request_id"`
+	Op               string `json:"op"`
+	Key              string `json:"key"`
+	Value            []byte `json:"value,omitempty"`
+	ExpectedRevision uint64 `json:"expected_revision,omitempty"`
+}
+
+type kvValue struct {
+	Value    []byte
+	Revision uint64
+}
+
+type kvWatch struct {
+	prefix   string
+	callback C.pgraft_go_kv_watch_callback
+	arg      unsafe.Pointer
+}
+
+type kvEvent struct {
+	watchID  uint64
+	event    int
+	key      string
+	value    []byte
+	revision uint64
+}
+
+var (
+	kvData  = make(map[string]*kvValue)
+	kvMutex sync.RWMutex
+
+	kvWatches            = make(map[uint64]*kvWatch)
+	kvNextWatchID uint64 = 1
+	kvEvents      []kvEvent
+	kvEventsLost  bool
+	kvWatchMutex  sync.Mutex
+)
+
+func applyKVEntry(index, term uint64, body []byte) {
+	var op kvEntryBody
+	if err := json.Unmarshal(body, &op); err != nil {
+		log.Printf("pgraft: ERROR - Malformed KV entry at index %d: %v", index, err)
+		return
+	}
+
+	kvMutex.Lock()
+	current, exists := kvData[op.Key]
+	result := 0
+	event := 0
+	switch op.Op {
+	case kvOpPut:
+		result, event = 1, kvEventPut
+	case kvOpCAS:
+		if (!exists && op.ExpectedRevision == 0) || (exists && current.Revision == op.ExpectedRevision) {
+			result, event = 1, kvEventPut
+		}
+	case kvOpDelete:
+		if exists {
+			result, event = 1, kvEventDelete
+		}
+	default:
+		log.Printf("pgraft: WARNING - Unknown KV operation %q at index %d", op.Op, index)
+	}
+	switch event {
+	case kvEventPut:
+		kvData[op.Key] = &kvValue{Value: op.Value, Revision: index}
+	case kvEventDelete:
+		delete(kvData, op.Key)
+	}
+	kvMutex.Unlock()
+
+	if event != 0 {
+		queueKVEvent(event, op.Key, op.Value, index)
+	}
+	completeTypedWaiter(op.RequestID, result, index)
+}
+
+// Queue an event for every watch whose prefix matches key
+func queueKVEvent(event int, key string, value []byte, revision uint64) {
+	kvWatchMutex.Lock()
+	defer kvWatchMutex.Unlock()
+
+	for id, watch := range kvWatches {
+		if !strings.HasPrefix(key, watch.prefix) {
+			continue
+		}
+		kvEvents = append(kvEvents, kvEvent{watchID: id, event: event, key: key, value: value, revision: revision})
+	}
+	if len(kvEvents) > kvEventLimit {
+		if !kvEventsLost {
+			log.Printf("pgraft: WARNING - KV watch events are not being drained, dropping the oldest")
+			kvEventsLost = true
+		}
+		kvEvents = kvEvents[len(kvEvents)-kvEventLimit:]
+	}
+}
+
+func resetKV() {
+	kvMutex.Lock()
+	kvData = make(map[string]*kvValue)
+	kvMutex.Unlock()
+
+	kvWatchMutex.Lock()
+	kvWatches = make(map[uint64]*kvWatch)
+	kvEvents = nil
+	kvEventsLost = false
+	kvWatchMutex.Unlock()
+}
+
+// Keys and values for a snapshot
+func snapshotKV() map[string]kvValue {
+	kvMutex.RLock()
+	defer kvMutex.RUnlock()
+
+	data := make(map[string]kvValue, len(kvData))
+	for key, value := range kvData {
+		data[key] = *value
+	}
+	return data
+}
+
+// Replace the keys and values with those of a snapshot; watches get no
+// events for the keys it changes
+func restoreKV(data map[string]kvValue) {
+	kvMutex.Lock()
+	defer kvMutex.Unlock()
+
+	kvData = make(map[string]*kvValue, len(data))
+	for key, value := range data {
+		value := value
+		kvData[key] = &value
+	}
+}
+
+// Propose a KV write and wait for its outcome and revision
+func proposeKVOp(op kvEntryBody, timeoutMs C.int, revision *C.uint64_t) C.int {
+	if op.Key == "" || timeoutMs <= 0 {
+		return errInvalidArgument
+	}
+
+	op.RequestID = newReplicatedID()
+	rc, index := proposeTypedEntryAndWait(typedEntryKV, op.RequestID, op, time.Duration(timeoutMs)*time.Millisecond)
+	if rc == 1 && revision != nil {
+		*revision = C.uint64_t(index)
+	}
+	return C.int(rc)
+}
+
+// Copy a C buffer, which may be NULL only when empty
+func kvValueBytes(value *C.char, valueLen C.int) ([]byte, bool) {
+	if valueLen < 0 || (value == nil && valueLen > 0) {
+		return nil, false
+	}
+	if valueLen == 0 {
+		return []byte{}, true
+	}
+	return C.GoBytes(unsafe.Pointer(value), valueLen), true
+}
+
+// Store a value; returns 1 and the new revision in *revision
+//
+//export pgraft_go_kv_put
+func pgraft_go_kv_put(key *C.char, value *C.char, valueLen C.int, timeoutMs C.int, revision *C.uint64_t) C.int {
+	data, ok := kvValueBytes(value, valueLen)
+	if key == nil || !ok {
+		return errInvalidArgument
+	}
+	return proposeKVOp(kvEntryBody{Op: kvOpPut, Key: C.GoString(key), Value: data}, timeoutMs, revision)
+}
+
+// Store a value only if the key is at expectedRevision (0: absent);
+// returns 1 if swapped, 0 if the revision did not match
+//
+//export pgraft_go_kv_cas
+func pgraft_go_kv_cas(key *C.char, expectedRevision C.uint64_t, value *C.char, valueLen C.int, timeoutMs C.int, revision *C.uint64_t) C.int {
+	data, ok := kvValueBytes(value, valueLen)
+	if key == nil || !ok {
+		return errInvalidArgument
+	}
+	op := kvEntryBody{Op: kvOpCAS, Key: C.GoString(key), Value: data, ExpectedRevision: uint64(expectedRevision)}
+	return proposeKVOp(op, timeoutMs, revision)
+}
+
+// Delete a key; returns 1 if deleted, 0 if it did not exist
+//
+//export pgraft_go_kv_delete
+func pgraft_go_kv_delete(key *C.char, timeoutMs C.int) C.int {
+	if key == nil {
+		return errInvalidArgument
+	}
+	return proposeKVOp(kvEntryBody{Op: kvOpDelete, Key: C.GoString(key)}, timeoutMs, nil)
+}
+
+// Read a key from the local replica; returns 1 and a copy of the value
+// in *value (free with pgraft_go_free_string()), or 0 if absent
+//
+//export pgraft_go_kv_get
+func pgraft_go_kv_get(key *C.char, value **C.char, valueLen *C.int, revision *C.uint64_t) C.int {
+	if key == nil || value == nil || valueLen == nil {
+		return errInvalidArgument
+	}
+
+	kvMutex.RLock()
+	current, exists := kvData[C.GoString(key)]
+	kvMutex.RUnlock()
+	if !exists {
+		return 0
+	}
+
+	// Allocate at least one byte so an empty value is not NULL
+	*value = (*C.char)(C.CBytes(append(append([]byte(nil), current.Value...), 0)))
+	*valueLen = C.int(len(current.Value))
+	if revision != nil {
+		*revision = C.uint64_t(current.Revision)
+	}
+	return 1
+}
+
+// Watch keys starting with prefix ("" watches every key); returns the
+// watch ID
+//
+//export pgraft_go_kv_watch
+func pgraft_go_kv_watch(prefix *C.char, callback C.pgraft_go_kv_watch_callback, arg unsafe.Pointer) C.int64_t {
+	if prefix == nil || callback == nil {
+		return errInvalidArgument
+	}
+
+	kvWatchMutex.Lock()
+	defer kvWatchMutex.Unlock()
+
+	id := kvNextWatchID
+	kvNextWatchID++
+	kvWatches[id] = &kvWatch{prefix: C.GoString(prefix), callback: callback, arg: arg}
+	return C.int64_t(id)
+}
+
+// Cancel a watch and discard its undelivered events
+//
+//export pgraft_go_kv_unwatch
+func pgraft_go_kv_unwatch(watchID C.uint64_t) C.int {
+	kvWatchMutex.Lock()
+	defer kvWatchMutex.Unlock()
+
+	if _, exists := kvWatches[uint64(watchID)]; !exists {
+		return errInvalidHandle
+	}
+	delete(kvWatches, uint64(watchID))
+	kept := kvEvents[:0]
+	for _, event := range kvEvents {
+		if event.watchID != uint64(watchID) {
+			kept = append(kept, event)
+		}
+	}
+	kvEvents = kept
+	return errOK
+}
+
+// Deliver queued watch events on the calling thread, in log order;
+// returns the number delivered
+//
+//export pgraft_go_drain_kv_events
+func pgraft_go_drain_kv_events() C.int {
+	kvWatchMutex.Lock()
+	events := kvEvents
+	kvEvents = nil
+	kvEventsLost = false
+	watches := make(map[uint64]kvWatch, len(kvWatches))
+	for id, watch := range kvWatches {
+		watches[id] = *watch
+	}
+	kvWatchMutex.Unlock()
+
+	delivered := 0
+	for _, event := range events {
+		watch, exists := watches[event.watchID]
+		if !exists {
+			continue
+		}
+		key := C.CString(event.key)
+		var value unsafe.Pointer
+		if event.event == kvEventPut {
+			value = C.CBytes(append(append([]byte(nil), event.value...), 0))
+		}
+		C.pgraft_go_call_kv_watch_callback(watch.callback, C.uint64_t(event.watchID), C.int(event.event), key,
+			(*C.char)(value), C.int(len(event.value)), C.uint64_t(event.revision), watch.arg)
+		C.free(unsafe.Pointer(key))
+		C.free(value)
+		delivered++
+	}
+	return C.int(delivered)
+}
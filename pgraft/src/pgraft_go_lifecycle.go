@@ -0,0 +1,196 @@
+/*
+ * pgraft_go_lifecycle.go
+ * Node teardown and library finalization
+ *
+ * Background goroutines started for the node are tracked so teardown can
+ * wait for them to exit before the Raft node and its storage are
+ * released.  pgraft_go_finalize() returns the library to its freshly
+ * loaded state: no goroutines, listeners, connections, or C callbacks
+ * remain, so the extension can initialize again within the same backend
+ * or unload the library.  The Go runtime itself stays resident after
+ * dlclose(); finalize only guarantees it no longer runs pgraft code or
+ * calls into the extension.
+ */
+
+package main
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// How long teardown waits for background goroutines to exit
+const shutdownWaitTimeout = 5 * time.Second
+
+var (
+	backgroundWG    sync.WaitGroup
+	networkListener net.Listener
+	listenerMutex   sync.Mutex
+)
+
+// Start a goroutine that teardown waits for
+func goBackground(fn func()) {
+	backgroundWG.Add(1)
+	go func() {
+		defer backgroundWG.Done()
+		fn()
+	}()
+}
+
+func setNetworkListener(listener net.Listener) {
+	listenerMutex.Lock()
+	networkListener = listener
+	listenerMutex.Unlock()
+}
+
+func closeNetworkListener() {
+	listenerMutex.Lock()
+	if networkListener != nil {
+		networkListener.Close()
+		networkListener = nil
+	}
+	listenerMutex.Unlock()
+}
+
+// Wait for tracked goroutines; false if they did not exit in time
+func waitForBackground(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		backgroundWG.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		log.Printf("pgraft: WARNING - Background goroutines did not exit within %v", timeout)
+		return false
+	}
+}
+
+// Signal every background goroutine to exit, close the listener and peer
+// connections, wait for the goroutines and stop the Raft node.  raftMutex
+// must not be held, since the Ready loop takes it.
+func teardownNode() {
+	raftMutex.Lock()
+	if stopChan != nil {
+		select {
+		case <-stopChan:
+		default:
+			close(stopChan)
+		}
+	}
+	if raftTicker != nil {
+		raftTicker.Stop()
+	}
+	if raftCancel != nil {
+		raftCancel()
+	}
+	raftMutex.Unlock()
+
+	closeNetworkListener()
+	peerTransport.Close()
+	waitForBackground(shutdownWaitTimeout)
+
+	raftMutex.Lock()
+	if raftNode != nil {
+		raftNode.Stop()
+	}
+	raftMutex.Unlock()
+}
+
+// Stop the node if needed and release all library state
+//
+//export pgraft_go_finalize
+func pgraft_go_finalize() C.int {
+	log.Printf("pgraft: INFO - Finalizing Go library")
+
+	teardownNode()
+
+	pgraft_go_ring_detach()
+	pgraft_go_event_detach()
+	resetEventSocket()
+
+	resetHandles()
+
+	resetCursors()
+	resetAsyncOps()
+	resetTrackedProposals()
+	resetSyncStandbys()
+	resetPromotionState()
+	resetNodeLSNs()
+	resetFencing()
+	resetDDL()
+	resetLocks()
+	resetKV()
+	resetBarriers()
+	resetSlots()
+	resetBootstraps()
+	resetRewind()
+	resetTimelines()
+	resetMaintenance()
+	resetTags()
+	resetSequences()
+	resetParameters()
+	resetStaleness()
+	resetRestarts()
+	resetBackup()
+	resetFaults()
+	resetCompaction()
+	resetArchive()
+	resetTrace()
+	resetTypedWaiters()
+	resetServicesApplied()
+
+	raftMutex.Lock()
+	raftNode = nil
+	raftStorage = nil
+	raftConfig = nil
+	raftTicker = nil
+	raftCancel = nil
+	activeConfig = nil
+	peerTLSConfig = nil
+	committedIndex = 0
+	atomic.StoreUint64(&appliedIndex, 0)
+	clusterState = ClusterState{}
+	raftMutex.Unlock()
+
+	nodesMutex.Lock()
+	nodes = make(map[uint64]string)
+	nodesMutex.Unlock()
+
+	connMutex.Lock()
+	connections = make(map[uint64]net.Conn)
+	connMutex.Unlock()
+
+	replicationState.replicationMutex.Lock()
+	replicationState.lastAppliedIndex = 0
+	replicationState.lastSnapshotIndex = 0
+	replicationState.replicationLag = 0
+	replicationState.replicationMutex.Unlock()
+
+	cachedStatus.Store(nil)
+
+	atomic.StoreInt32(&running, 0)
+	atomic.StoreInt32(&initialized, 0)
+
+	pgraft_go_set_commit_callback(nil, nil)
+
+	log.Printf("pgraft: INFO - Go library finalized")
+
+	// Finalize runs on the caller's thread, so queued log records can be
+	// delivered before the C callback is dropped
+	pgraft_go_drain_logs()
+	pgraft_go_set_log_callback(nil, 0)
+
+	return 0
+}
@@ -0,0 +1,198 @@
+/*
+ * pgraft_go_locks.go
+ * Cluster-wide advisory locks replicated through the raft log
+ *
+ * Lock operations are typed entries, so every member holds the same lock
+ * table and locks survive a change of leader.  A lock is owned by a node
+ * and expires after its TTL unless renewed.  To keep the table identical
+ * on every node, expiry is decided against the proposer's clock stamped
+ * into each entry rather than the local clock when it is applied; clock
+ * skew between members therefore shortens or lengthens a TTL by at most
+ * the skew.  The acquire, release and renew exports block until the entry
+ * is applied locally or the timeout passes.
+ */
+
+package main
+
+/*
+#include <stdint.h>
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+// Lock operations carried in typedEntryLock entries
+const (
+	lockOpAcquire = "acquire"
+	lockOpRelease = "release"
+	lockOpRenew   = "renew"
+)
+
+type lockEntryBody struct {
+	RequestID uint64 `json:"request_id"`
+	Op        string `json:"op"`
+	Name      string `json:"name"`
+	Owner     uint64 `json:"owner"`
+	TTLMs     int64  `json:"ttl_ms,omitempty"`
+	NowMs     int64  `json:"now_ms"`
+}
+
+type lockState struct {
+	Owner     uint64
+	ExpiresMs int64
+}
+
+var (
+	lockTable = make(map[string]*lockState)
+	lockMutex sync.Mutex
+)
+
+func applyLockEntry(index, term uint64, body []byte) {
+	var op lockEntryBody
+	if err := json.Unmarshal(body, &op); err != nil {
+		log.Printf("pgraft: ERROR - Malformed lock entry at index %d: %v", index, err)
+		return
+	}
+
+	lockMutex.Lock()
+	held, exists := lockTable[op.Name]
+	if exists && held.ExpiresMs <= op.NowMs {
+		delete(lockTable, op.Name)
+		exists = false
+	}
+	ownedByRequester := exists && held.Owner == op.Owner
+
+	result := 0
+	switch op.Op {
+	case lockOpAcquire:
+		if !exists {
+			lockTable[op.Name] = &lockState{Owner: op.Owner, ExpiresMs: op.NowMs + op.TTLMs}
+			result = 1
+		} else if ownedByRequester {
+			held.ExpiresMs = op.NowMs + op.TTLMs
+			result = 1
+		}
+	case lockOpRenew:
+		if ownedByRequester {
+			held.ExpiresMs = op.NowMs + op.TTLMs
+			result = 1
+		}
+	case lockOpRelease:
+		if ownedByRequester {
+			delete(lockTable, op.Name)
+			result = 1
+		}
+	default:
+		log.Printf("pgraft: WARNING - Unknown lock operation %q at index %d", op.Op, index)
+	}
+	lockMutex.Unlock()
+
+	debugLog("locks: %s %q by node %d at index %d -> %d", op.Op, op.Name, op.Owner, index, result)
+	completeTypedWaiter(op.RequestID, result, index)
+}
+
+func resetLocks() {
+	lockMutex.Lock()
+	lockTable = make(map[string]*lockState)
+	lockMutex.Unlock()
+}
+
+// Lock table for a snapshot
+func snapshotLocks() map[string]lockState {
+	lockMutex.Lock()
+	defer lockMutex.Unlock()
+
+	locks := make(map[string]lockState, len(lockTable))
+	for name, held := range lockTable {
+		locks[name] = *held
+	}
+	return locks
+}
+
+// Replace the lock table with one from a snapshot
+func restoreLocks(locks map[string]lockState) {
+	lockMutex.Lock()
+	defer lockMutex.Unlock()
+
+	lockTable = make(map[string]*lockState, len(locks))
+	for name, held := range locks {
+		held := held
+		lockTable[name] = &held
+	}
+}
+
+// Propose a lock operation on behalf of this node and wait for its outcome
+func proposeLockOp(op string, name *C.char, ttlMs C.int, timeoutMs C.int) C.int {
+	if name == nil || C.GoString(name) == "" || timeoutMs <= 0 || (op != lockOpRelease && ttlMs <= 0) {
+		return errInvalidArgument
+	}
+	if activeConfig == nil {
+		return errNotInitialized
+	}
+
+	body := lockEntryBody{
+		RequestID: newReplicatedID(),
+		Op:        op,
+		Name:      C.GoString(name),
+		Owner:     activeConfig.NodeID,
+		TTLMs:     int64(ttlMs),
+		NowMs:     time.Now().UnixMilli(),
+	}
+	timeout := time.Duration(timeoutMs) * time.Millisecond
+	rc, _ := proposeTypedEntryAndWait(typedEntryLock, body.RequestID, body, timeout)
+	return C.int(rc)
+}
+
+// Acquire a lock for this node for ttlMs; returns 1 if acquired (or
+// already held, extending its TTL), 0 if another node holds it
+//
+//export pgraft_go_lock_acquire
+func pgraft_go_lock_acquire(name *C.char, ttlMs C.int, timeoutMs C.int) C.int {
+	return proposeLockOp(lockOpAcquire, name, ttlMs, timeoutMs)
+}
+
+// Extend a lock held by this node to ttlMs from now; returns 1 if renewed,
+// 0 if the lock was lost
+//
+//export pgraft_go_lock_renew
+func pgraft_go_lock_renew(name *C.char, ttlMs C.int, timeoutMs C.int) C.int {
+	return proposeLockOp(lockOpRenew, name, ttlMs, timeoutMs)
+}
+
+// Release a lock held by this node; returns 1 if released, 0 if this
+// node did not hold it
+//
+//export pgraft_go_lock_release
+func pgraft_go_lock_release(name *C.char, timeoutMs C.int) C.int {
+	return proposeLockOp(lockOpRelease, name, 0, timeoutMs)
+}
+
+// Current holder of a lock from the local replica; returns 1 and fills
+// *owner and *expiresMs (Unix milliseconds) if held, 0 if free
+//
+//export pgraft_go_lock_holder
+func pgraft_go_lock_holder(name *C.char, owner *C.uint64_t, expiresMs *C.int64_t) C.int {
+	if name == nil {
+		return errInvalidArgument
+	}
+
+	lockMutex.Lock()
+	defer lockMutex.Unlock()
+
+	held, exists := lockTable[C.GoString(name)]
+	if !exists || held.ExpiresMs <= time.Now().UnixMilli() {
+		return 0
+	}
+	if owner != nil {
+		*owner = C.uint64_t(held.Owner)
+	}
+	if expiresMs != nil {
+		*expiresMs = C.int64_t(held.ExpiresMs)
+	}
+	return 1
+}
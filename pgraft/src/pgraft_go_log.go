@@ -0,0 +1,169 @@
+/*
+ * pgraft_go_log.go
+ * Log sink bridging Go-side messages into the PostgreSQL server log
+ *
+ * The Go runtime runs on its own threads, and elog() must only be called
+ * from the backend's main thread.  Once a callback is registered, every
+ * line written through the standard "log" package is classified by its
+ * severity tag and queued.  The C extension drains the queue from its
+ * worker loop with pgraft_go_drain_logs(), which invokes the callback on
+ * the calling thread so messages go through elog() with the configured
+ * log_line_prefix and CSV/JSON log destinations.
+ */
+
+package main
+
+/*
+#include <stdlib.h>
+
+typedef void (*pgraft_go_log_callback) (int level, const char *message);
+
+static inline void
+pgraft_go_call_log_callback(pgraft_go_log_callback cb, int level, const char *message)
+{
+	cb(level, message);
+}
+*/
+import "C"
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Log severities passed to the C log callback, mirrored by the
+// PGRAFT_GO_LOG_* defines in pgraft_go.h
+const (
+	logLevelDebug   = 0
+	logLevelInfo    = 1
+	logLevelWarning = 2
+	logLevelError   = 3
+)
+
+// Maximum number of undrained records kept before new ones are dropped
+const logQueueCapacity = 4096
+
+type logRecord struct {
+	level   int
+	message string
+}
+
+// logSink is installed as the output of the standard logger while a
+// C callback is registered
+type logSink struct{}
+
+var (
+	logCallback   C.pgraft_go_log_callback
+	logMinLevel   int
+	logQueue      []logRecord
+	logQueueMutex sync.Mutex
+	logDropped    int64
+)
+
+// Split a formatted line into its severity and the message text with the
+// "LEVEL - " tag removed, keeping the "pgraft: " prefix
+func classifyLogLine(line string) (int, string) {
+	tags := []struct {
+		tag   string
+		level int
+	}{
+		{"PANIC", logLevelError},
+		{"ERROR", logLevelError},
+		{"WARNING", logLevelWarning},
+		{"INFO", logLevelInfo},
+		{"DEBUG", logLevelDebug},
+	}
+
+	rest := strings.TrimPrefix(line, "pgraft: ")
+	for _, t := range tags {
+		if strings.HasPrefix(rest, t.tag+" - ") {
+			return t.level, "pgraft: " + strings.TrimPrefix(rest, t.tag+" - ")
+		}
+		if strings.HasPrefix(rest, t.tag) {
+			return t.level, line
+		}
+	}
+
+	return logLevelInfo, line
+}
+
+func (logSink) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+
+		level, message := classifyLogLine(line)
+
+		logQueueMutex.Lock()
+		if level < logMinLevel {
+			logQueueMutex.Unlock()
+			continue
+		}
+		if len(logQueue) >= logQueueCapacity {
+			logQueueMutex.Unlock()
+			atomic.AddInt64(&logDropped, 1)
+			continue
+		}
+		logQueue = append(logQueue, logRecord{level: level, message: message})
+		logQueueMutex.Unlock()
+	}
+
+	return len(p), nil
+}
+
+// Register a log callback; passing NULL restores logging to stderr
+//
+//export pgraft_go_set_log_callback
+func pgraft_go_set_log_callback(callback C.pgraft_go_log_callback, minLevel C.int) {
+	logQueueMutex.Lock()
+	logCallback = callback
+	logMinLevel = int(minLevel)
+	logQueueMutex.Unlock()
+
+	if callback == nil {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+		return
+	}
+
+	// PostgreSQL adds its own timestamp through log_line_prefix
+	log.SetFlags(0)
+	log.SetOutput(logSink{})
+}
+
+// Deliver queued log records to the registered callback on the calling
+// thread; returns the number of records delivered
+//
+//export pgraft_go_drain_logs
+func pgraft_go_drain_logs() C.int {
+	logQueueMutex.Lock()
+	callback := logCallback
+	records := logQueue
+	logQueue = nil
+	logQueueMutex.Unlock()
+
+	if callback == nil {
+		return 0
+	}
+
+	if dropped := atomic.SwapInt64(&logDropped, 0); dropped > 0 {
+		records = append(records, logRecord{
+			level:   logLevelWarning,
+			message: "pgraft: log queue full, dropped " + strconv.FormatInt(dropped, 10) + " messages",
+		})
+	}
+
+	for _, rec := range records {
+		cMessage := C.CString(rec.message)
+		C.pgraft_go_call_log_callback(callback, C.int(rec.level), cMessage)
+		C.free(unsafe.Pointer(cMessage))
+	}
+
+	return C.int(len(records))
+}
@@ -0,0 +1,169 @@
+/*
+ * pgraft_go_lsn.go
+ * WAL-position-aware leadership
+ *
+ * Raft elects whichever node times out first, which after a failover may
+ * be a replica that is missing WAL another replica already has.  The
+ * extension feeds every node's WAL flush LSN it knows about with
+ * pgraft_go_set_node_lsn().  While this node leads, the ticker compares
+ * its own LSN with the fresh LSNs of recently active voters and, if one
+ * of them is ahead by more than the configured threshold, transfers
+ * leadership to the most advanced one.  Promotion is held back while such
+ * a transfer is in flight, so a lagging node never promotes PostgreSQL.
+ * pgraft_go_best_leader_candidate() exposes the same choice to callers.
+ */
+
+package main
+
+/*
+#include <stdint.h>
+*/
+import "C"
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"go.etcd.io/raft/v3"
+)
+
+const (
+	// LSNs older than this are ignored, e.g. from a node that went away
+	lsnStaleAfter = 10 * time.Second
+
+	// Minimum time between LSN-driven leadership transfers
+	lsnTransferCooldown = 5 * time.Second
+)
+
+type nodeLSNReport struct {
+	lsn        uint64
+	reportedAt time.Time
+}
+
+var (
+	nodeLSNs          = make(map[uint64]nodeLSNReport)
+	lsnFailoverOn     = true
+	lsnLagThreshold   uint64
+	lsnTransferTarget uint64
+	lsnTransferAt     time.Time
+	lsnMutex          sync.Mutex
+)
+
+// Voter with the highest fresh LSN among self and recently active peers;
+// returns 0 when no LSNs are known.  lsnMutex must be held.
+func bestLSNCandidate(status raft.Status, now time.Time) (uint64, uint64) {
+	var bestID, bestLSN uint64
+	for id := range status.Config.Voters.IDs() {
+		report, known := nodeLSNs[id]
+		if !known || now.Sub(report.reportedAt) > lsnStaleAfter {
+			continue
+		}
+		if id != status.ID && status.RaftState == raft.StateLeader {
+			if pr, tracked := status.Progress[id]; !tracked || !pr.RecentActive {
+				continue
+			}
+		}
+		if bestID == 0 || report.lsn > bestLSN || (report.lsn == bestLSN && id == status.ID) {
+			bestID, bestLSN = id, report.lsn
+		}
+	}
+	return bestID, bestLSN
+}
+
+// Hand leadership to a voter with more WAL; called on every tick
+func maybeTransferForLSN() {
+	node := raftNode
+	if node == nil {
+		return
+	}
+	status := node.Status()
+	if status.RaftState != raft.StateLeader || status.LeadTransferee != 0 {
+		return
+	}
+
+	lsnMutex.Lock()
+	now := time.Now()
+	if !lsnFailoverOn || maintenanceActive() || now.Sub(lsnTransferAt) < lsnTransferCooldown {
+		lsnMutex.Unlock()
+		return
+	}
+	own, known := nodeLSNs[status.ID]
+	if !known || now.Sub(own.reportedAt) > lsnStaleAfter {
+		lsnMutex.Unlock()
+		return
+	}
+	target, targetLSN := bestLSNCandidate(status, now)
+	if target == 0 || target == status.ID || targetLSN <= own.lsn+lsnLagThreshold {
+		lsnMutex.Unlock()
+		return
+	}
+	lsnTransferTarget = target
+	lsnTransferAt = now
+	lsnMutex.Unlock()
+
+	log.Printf("pgraft: INFO - Node %d has WAL up to %X, ahead of local %X; transferring leadership",
+		target, targetLSN, own.lsn)
+	node.TransferLeadership(raftCtx, status.ID, target)
+}
+
+// True while an LSN-driven transfer started recently may still complete
+func lsnTransferPending() bool {
+	lsnMutex.Lock()
+	defer lsnMutex.Unlock()
+	return lsnTransferTarget != 0 && time.Since(lsnTransferAt) < lsnTransferCooldown
+}
+
+func resetNodeLSNs() {
+	lsnMutex.Lock()
+	nodeLSNs = make(map[uint64]nodeLSNReport)
+	lsnTransferTarget = 0
+	lsnTransferAt = time.Time{}
+	lsnMutex.Unlock()
+}
+
+// Record a node's WAL flush LSN, including this node's own
+//
+//export pgraft_go_set_node_lsn
+func pgraft_go_set_node_lsn(nodeID C.int, lsn C.uint64_t) C.int {
+	if nodeID <= 0 {
+		return errInvalidArgument
+	}
+
+	lsnMutex.Lock()
+	nodeLSNs[uint64(nodeID)] = nodeLSNReport{lsn: uint64(lsn), reportedAt: time.Now()}
+	lsnMutex.Unlock()
+	return errOK
+}
+
+// Enable or disable LSN-driven leadership transfer; a transfer happens only
+// when another voter is more than lagThreshold bytes ahead
+//
+//export pgraft_go_set_lsn_failover
+func pgraft_go_set_lsn_failover(enabled C.int, lagThreshold C.uint64_t) {
+	lsnMutex.Lock()
+	lsnFailoverOn = enabled != 0
+	lsnLagThreshold = uint64(lagThreshold)
+	lsnMutex.Unlock()
+}
+
+// Node that should lead based on reported LSNs, storing its LSN in *lsn;
+// 0 when no fresh LSNs are known
+//
+//export pgraft_go_best_leader_candidate
+func pgraft_go_best_leader_candidate(lsn *C.uint64_t) C.int64_t {
+	node := raftNode
+	if node == nil {
+		return 0
+	}
+	status := node.Status()
+
+	lsnMutex.Lock()
+	bestID, bestLSN := bestLSNCandidate(status, time.Now())
+	lsnMutex.Unlock()
+
+	if lsn != nil {
+		*lsn = C.uint64_t(bestLSN)
+	}
+	return C.int64_t(bestID)
+}
@@ -0,0 +1,159 @@
+/*
+ * pgraft_go_maintenance.go
+ * Cluster-wide maintenance mode
+ *
+ * Maintenance mode is a flag kept in the raft log, so it is visible on
+ * every member and survives restarts and leader changes.  While it is
+ * set, pgraft takes no automatic failover action: leadership is not
+ * transferred towards the most caught-up node, and a node that becomes
+ * leader is not reported as promoted until maintenance ends.  Demotions
+ * are still reported.  The flag is shown in pgraft_go_get_stats(), and
+ * ramd is expected to hold its own failover logic while it is set.
+ */
+
+package main
+
+/*
+#include <stdint.h>
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type maintenanceEntryBody struct {
+	RequestID uint64 `json:"request_id"`
+	Enabled   bool   `json:"enabled"`
+	Reason    string `json:"reason,omitempty"`
+	Node      uint64 `json:"node"`
+	NowMs     int64  `json:"now_ms"`
+}
+
+type maintenanceState struct {
+	Enabled bool   `json:"enabled"`
+	Reason  string `json:"reason,omitempty"`
+	Node    uint64 `json:"node,omitempty"`
+	SinceMs int64  `json:"since_ms,omitempty"`
+	Index   uint64 `json:"index,omitempty"`
+}
+
+var (
+	maintenanceFlag    int32
+	maintenanceCurrent maintenanceState
+	maintenanceMutex   sync.Mutex
+)
+
+func maintenanceActive() bool {
+	return atomic.LoadInt32(&maintenanceFlag) == 1
+}
+
+func applyMaintenanceEntry(index, term uint64, body []byte) {
+	var entry maintenanceEntryBody
+	if err := json.Unmarshal(body, &entry); err != nil {
+		log.Printf("pgraft: ERROR - Malformed maintenance entry at index %d: %v", index, err)
+		return
+	}
+
+	maintenanceMutex.Lock()
+	changed := entry.Enabled != maintenanceCurrent.Enabled
+	maintenanceCurrent = maintenanceState{
+		Enabled: entry.Enabled,
+		Reason:  entry.Reason,
+		Node:    entry.Node,
+		SinceMs: entry.NowMs,
+		Index:   index,
+	}
+	if entry.Enabled {
+		atomic.StoreInt32(&maintenanceFlag, 1)
+	} else {
+		atomic.StoreInt32(&maintenanceFlag, 0)
+	}
+	maintenanceMutex.Unlock()
+
+	if changed && entry.Enabled {
+		log.Printf("pgraft: INFO - Maintenance mode enabled by node %d: %s", entry.Node, entry.Reason)
+	} else if changed {
+		log.Printf("pgraft: INFO - Maintenance mode disabled by node %d", entry.Node)
+	}
+	completeTypedWaiter(entry.RequestID, 1, index)
+}
+
+// Maintenance state for pgraft_go_get_stats
+func maintenanceStats() maintenanceState {
+	maintenanceMutex.Lock()
+	defer maintenanceMutex.Unlock()
+	return maintenanceCurrent
+}
+
+// Replace the maintenance state with that of a snapshot
+func restoreMaintenance(state maintenanceState) {
+	maintenanceMutex.Lock()
+	maintenanceCurrent = state
+	if state.Enabled {
+		atomic.StoreInt32(&maintenanceFlag, 1)
+	} else {
+		atomic.StoreInt32(&maintenanceFlag, 0)
+	}
+	maintenanceMutex.Unlock()
+}
+
+func resetMaintenance() {
+	maintenanceMutex.Lock()
+	maintenanceCurrent = maintenanceState{}
+	atomic.StoreInt32(&maintenanceFlag, 0)
+	maintenanceMutex.Unlock()
+}
+
+// Set or clear maintenance mode for the whole cluster and wait up to
+// timeoutMs until it is applied locally
+//
+//export pgraft_go_set_maintenance
+func pgraft_go_set_maintenance(enabled C.int, reason *C.char, timeoutMs C.int) C.int {
+	if timeoutMs <= 0 {
+		return errInvalidArgument
+	}
+	if activeConfig == nil {
+		return errNotInitialized
+	}
+
+	body := maintenanceEntryBody{
+		RequestID: newReplicatedID(),
+		Enabled:   enabled != 0,
+		Node:      activeConfig.NodeID,
+		NowMs:     time.Now().UnixMilli(),
+	}
+	if reason != nil {
+		body.Reason = C.GoString(reason)
+	}
+	rc, _ := proposeTypedEntryAndWait(typedEntryMaintenance, body.RequestID, body, time.Duration(timeoutMs)*time.Millisecond)
+	if rc == 1 {
+		return errOK
+	}
+	return C.int(rc)
+}
+
+// 1 if the cluster is in maintenance mode, 0 otherwise
+//
+//export pgraft_go_in_maintenance
+func pgraft_go_in_maintenance() C.int {
+	if maintenanceActive() {
+		return 1
+	}
+	return 0
+}
+
+// Maintenance state as JSON; free with pgraft_go_free_string()
+//
+//export pgraft_go_get_maintenance
+func pgraft_go_get_maintenance() *C.char {
+	jsonData, err := json.Marshal(maintenanceStats())
+	if err != nil {
+		return C.CString("{\"error\": \"failed to marshal maintenance state\"}")
+	}
+	return C.CString(string(jsonData))
+}
@@ -0,0 +1,116 @@
+/*
+ * pgraft_go_memory.go
+ * Memory tuning for the Go runtime embedded in the PostgreSQL process
+ *
+ * These are the programmatic equivalents of GOMEMLIMIT and GOGC, plus an
+ * optional heap ballast, so the extension can bound the runtime's
+ * footprint from its GUCs before calling init.  Current runtime memory
+ * statistics are reported in the stats JSON.
+ */
+
+package main
+
+/*
+#include <stdint.h>
+*/
+import "C"
+
+import (
+	"log"
+	"math"
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+var (
+	// Never read; keeps the ballast allocation reachable
+	heapBallast      []byte
+	heapBallastMutex sync.Mutex
+	gcPercentSetting = 100
+)
+
+// Set the soft memory limit in bytes (GOMEMLIMIT); a value <= 0 removes
+// the limit.  Returns the previous limit, or -1 if there was none.
+//
+//export pgraft_go_set_memory_limit
+func pgraft_go_set_memory_limit(limitBytes C.int64_t) C.int64_t {
+	limit := int64(limitBytes)
+	if limit <= 0 {
+		limit = math.MaxInt64
+	}
+
+	previous := debug.SetMemoryLimit(limit)
+	log.Printf("pgraft: INFO - Go memory limit set to %d bytes", int64(limitBytes))
+
+	if previous == math.MaxInt64 {
+		return -1
+	}
+	return C.int64_t(previous)
+}
+
+// Set the GC target percentage (GOGC); -1 disables the collector.
+// Returns the previous setting.
+//
+//export pgraft_go_set_gc_percent
+func pgraft_go_set_gc_percent(percent C.int) C.int {
+	previous := debug.SetGCPercent(int(percent))
+
+	heapBallastMutex.Lock()
+	gcPercentSetting = int(percent)
+	heapBallastMutex.Unlock()
+
+	log.Printf("pgraft: INFO - Go GC percent set to %d", int(percent))
+	return C.int(previous)
+}
+
+// Allocate a heap ballast of the given size, replacing any previous one;
+// 0 releases it.  A ballast raises the heap size at which GC triggers
+// without being touched, so it costs address space but little RSS.
+//
+//export pgraft_go_set_heap_ballast
+func pgraft_go_set_heap_ballast(sizeBytes C.int64_t) C.int {
+	if sizeBytes < 0 {
+		return -1
+	}
+
+	heapBallastMutex.Lock()
+	if sizeBytes == 0 {
+		heapBallast = nil
+	} else {
+		heapBallast = make([]byte, int64(sizeBytes))
+	}
+	heapBallastMutex.Unlock()
+
+	log.Printf("pgraft: INFO - Go heap ballast set to %d bytes", int64(sizeBytes))
+	return 0
+}
+
+// Runtime memory statistics for the stats JSON
+func goMemoryStats() map[string]interface{} {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	heapBallastMutex.Lock()
+	ballast := len(heapBallast)
+	gcPercent := gcPercentSetting
+	heapBallastMutex.Unlock()
+
+	memoryLimit := debug.SetMemoryLimit(-1)
+	if memoryLimit == math.MaxInt64 {
+		memoryLimit = -1
+	}
+
+	return map[string]interface{}{
+		"heap_alloc_bytes":   ms.HeapAlloc,
+		"heap_sys_bytes":     ms.HeapSys,
+		"heap_inuse_bytes":   ms.HeapInuse,
+		"sys_bytes":          ms.Sys,
+		"num_gc":             ms.NumGC,
+		"gc_pause_total_ns":  ms.PauseTotalNs,
+		"goroutines":         runtime.NumGoroutine(),
+		"memory_limit_bytes": memoryLimit,
+		"gc_percent":         gcPercent,
+		"ballast_bytes":      ballast,
+	}
+}
@@ -0,0 +1,109 @@
+/*
+ * pgraft_go_metrics.go
+ * Interval snapshots of the Go-side counters
+ *
+ * Monitoring consumers that want per-interval rates call
+ * pgraft_go_snapshot_metrics(1) once per interval: each result holds what
+ * the counters grew by since the previous reset.  The counters themselves
+ * are never zeroed, so pgraft_go_get_stats keeps reporting lifetime
+ * totals; a reset only moves the baselines the deltas are taken from.
+ */
+
+package main
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A counter of the snapshot, with its value at the last reset
+type metricsCounter struct {
+	name     string
+	value    *int64
+	baseline int64
+
+	// Zeroed when a node is initialized; the others count for the library
+	perNode bool
+}
+
+var (
+	// Guards the baselines and the interval start, so that every counter
+	// of a snapshot is measured against the same reset
+	metricsMutex         sync.Mutex
+	metricsIntervalStart time.Time
+
+	metricsCounters = []metricsCounter{
+		{name: "messages_processed", value: &messagesProcessed, perNode: true},
+		{name: "log_entries_committed", value: &logEntriesCommitted, perNode: true},
+		{name: "heartbeats_sent", value: &heartbeatsSent, perNode: true},
+		{name: "elections_triggered", value: &electionsTriggered, perNode: true},
+		{name: "error_count", value: &errorCount, perNode: true},
+		{name: "ring_proposals", value: &ringProposals},
+		{name: "ring_overflows", value: &ringOverflows},
+		{name: "ring_corrupt_records", value: &ringCorruption},
+		{name: "ring_rejected_records", value: &ringRejected},
+		{name: "commit_callback_calls", value: &commitCallbackCalls},
+	}
+)
+
+// Zero the counters of a node and their baselines; called when a node is
+// initialized
+func resetNodeCounters() {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+
+	for i := range metricsCounters {
+		counter := &metricsCounters[i]
+		if counter.perNode {
+			atomic.StoreInt64(counter.value, 0)
+			counter.baseline = 0
+		}
+	}
+}
+
+//export pgraft_go_snapshot_metrics
+func pgraft_go_snapshot_metrics(reset C.int) *C.char {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+
+	doReset := reset != 0
+	now := time.Now()
+
+	intervalStart := metricsIntervalStart
+	if intervalStart.IsZero() {
+		intervalStart = startupTime
+	}
+
+	snapshot := map[string]interface{}{
+		"interval_start":   intervalStart.UTC().Format(time.RFC3339Nano),
+		"interval_end":     now.UTC().Format(time.RFC3339Nano),
+		"interval_seconds": now.Sub(intervalStart).Seconds(),
+		"reset":            doReset,
+	}
+	for i := range metricsCounters {
+		counter := &metricsCounters[i]
+		value := atomic.LoadInt64(counter.value)
+		snapshot[counter.name] = value - counter.baseline
+		if doReset {
+			counter.baseline = value
+		}
+	}
+
+	if doReset {
+		metricsIntervalStart = now
+	}
+
+	jsonData, err := json.Marshal(snapshot)
+	if err != nil {
+		return C.CString("{\"error\": \"failed to marshal metrics snapshot\"}")
+	}
+
+	return C.CString(string(jsonData))
+}
@@ -0,0 +1,402 @@
+/*
+ * pgraft_go_parameters.go
+ * Cluster-wide PostgreSQL parameter changes through the raft log
+ *
+ * pgraft_go_propose_parameter() on the leader replicates an ALTER
+ * SYSTEM-style change: a parameter set to a new value, or reset when the
+ * value is NULL.  Every node applies parameter entries in log order by
+ * queueing them for the extension, which writes them to its
+ * postgresql.auto.conf and reloads from its worker loop via the callback
+ * run by pgraft_go_drain_parameters(), then reports the outcome with
+ * pgraft_go_parameter_ack().  As with DDL, acknowledgments are raft
+ * entries, so every member can report which nodes applied a change.  The
+ * value last replicated for each parameter is kept so that the cluster's
+ * intended configuration can be listed.
+ */
+
+package main
+
+/*
+#include <stdint.h>
+#include <stdlib.h>
+
+typedef void (*pgraft_go_parameter_callback) (uint64_t change_id, uint64_t index, const char *name, const char *value, void *arg);
+
+static inline void
+pgraft_go_call_parameter_callback(pgraft_go_parameter_callback cb, uint64_t change_id, uint64_t index, const char *name, const char *value, void *arg)
+{
+	cb(change_id, index, name, value, arg);
+}
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"go.etcd.io/raft/v3"
+)
+
+// Number of parameter changes whose status is retained
+const parameterHistoryLimit = 1000
+
+type parameterEntryBody struct {
+	ID     uint64 `json:"id"`
+	Origin uint64 `json:"origin"`
+	Name   string `json:"name"`
+	Value  string `json:"value,omitempty"`
+	Reset  bool   `json:"reset,omitempty"`
+}
+
+type parameterAckBody struct {
+	ID      uint64 `json:"id"`
+	Node    uint64 `json:"node"`
+	Status  int    `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+type parameterChange struct {
+	ID     uint64
+	Origin uint64
+	Name   string
+	Value  string
+	Reset  bool
+	Index  uint64
+	Term   uint64
+	Acks   map[uint64]ddlAck
+}
+
+var (
+	parameterChanges = make(map[uint64]*parameterChange)
+	parameterOrder   []uint64
+
+	// Latest change of each parameter, by name
+	parameterLatest = make(map[string]*parameterChange)
+
+	// Applied changes not yet handed to the extension
+	parameterQueue []*parameterChange
+
+	parameterCallback    C.pgraft_go_parameter_callback
+	parameterCallbackArg unsafe.Pointer
+	parameterMutex       sync.Mutex
+)
+
+func applyParameterEntry(index, term uint64, body []byte) {
+	var entry parameterEntryBody
+	if err := json.Unmarshal(body, &entry); err != nil {
+		log.Printf("pgraft: ERROR - Malformed parameter entry at index %d: %v", index, err)
+		return
+	}
+
+	parameterMutex.Lock()
+	defer parameterMutex.Unlock()
+
+	if _, exists := parameterChanges[entry.ID]; exists {
+		return
+	}
+	change := &parameterChange{
+		ID:     entry.ID,
+		Origin: entry.Origin,
+		Name:   entry.Name,
+		Value:  entry.Value,
+		Reset:  entry.Reset,
+		Index:  index,
+		Term:   term,
+		Acks:   make(map[uint64]ddlAck),
+	}
+	parameterChanges[entry.ID] = change
+	parameterOrder = append(parameterOrder, entry.ID)
+	if len(parameterOrder) > parameterHistoryLimit {
+		delete(parameterChanges, parameterOrder[0])
+		parameterOrder = parameterOrder[1:]
+	}
+	parameterLatest[entry.Name] = change
+	parameterQueue = append(parameterQueue, change)
+
+	debugLog("parameters: change %d of %s committed at index %d", entry.ID, entry.Name, index)
+}
+
+func applyParameterAckEntry(index, term uint64, body []byte) {
+	var ack parameterAckBody
+	if err := json.Unmarshal(body, &ack); err != nil {
+		log.Printf("pgraft: ERROR - Malformed parameter acknowledgment at index %d: %v", index, err)
+		return
+	}
+
+	parameterMutex.Lock()
+	defer parameterMutex.Unlock()
+
+	change, exists := parameterChanges[ack.ID]
+	if !exists {
+		return
+	}
+	change.Acks[ack.Node] = ddlAck{
+		Node:    ack.Node,
+		OK:      ack.Status == 0,
+		Status:  ack.Status,
+		Message: ack.Message,
+		Index:   index,
+	}
+	if ack.Status != 0 {
+		log.Printf("pgraft: WARNING - Node %d failed to apply %s: %s", ack.Node, change.Name, ack.Message)
+	}
+}
+
+func resetParameters() {
+	parameterMutex.Lock()
+	parameterChanges = make(map[uint64]*parameterChange)
+	parameterOrder = nil
+	parameterLatest = make(map[string]*parameterChange)
+	parameterQueue = nil
+	parameterCallback = nil
+	parameterCallbackArg = nil
+	parameterMutex.Unlock()
+}
+
+// Retained changes in log order and the latest change of each
+// parameter, in a snapshot
+type parameterSnapshot struct {
+	Changes []parameterChange          `json:"changes,omitempty"`
+	Latest  map[string]parameterChange `json:"latest,omitempty"`
+}
+
+// Parameter changes for a snapshot
+func snapshotParameters() parameterSnapshot {
+	parameterMutex.Lock()
+	defer parameterMutex.Unlock()
+
+	snapshot := parameterSnapshot{Latest: make(map[string]parameterChange, len(parameterLatest))}
+	for _, id := range parameterOrder {
+		snapshot.Changes = append(snapshot.Changes, *parameterChanges[id])
+	}
+	for name, change := range parameterLatest {
+		snapshot.Latest[name] = *change
+	}
+	return snapshot
+}
+
+// Replace the parameter changes with those of a snapshot.  The latest
+// change of each parameter applied after index, which this node skipped,
+// is queued for the extension.
+func restoreParameters(snapshot parameterSnapshot, index uint64) {
+	parameterMutex.Lock()
+	defer parameterMutex.Unlock()
+
+	parameterChanges = make(map[uint64]*parameterChange, len(snapshot.Changes))
+	parameterOrder = nil
+	for _, change := range snapshot.Changes {
+		change := change
+		parameterChanges[change.ID] = &change
+		parameterOrder = append(parameterOrder, change.ID)
+	}
+	parameterLatest = make(map[string]*parameterChange, len(snapshot.Latest))
+	for name, change := range snapshot.Latest {
+		change := change
+		if retained, exists := parameterChanges[change.ID]; exists {
+			parameterLatest[name] = retained
+		} else {
+			parameterLatest[name] = &change
+		}
+		if change.Index > index {
+			parameterQueue = append(parameterQueue, parameterLatest[name])
+		}
+	}
+	sort.Slice(parameterQueue, func(i, j int) bool { return parameterQueue[i].Index < parameterQueue[j].Index })
+}
+
+// Current voters, sorted
+func sortedVoters() []uint64 {
+	var voters []uint64
+	if node := raftNode; node != nil {
+		for id := range node.Status().Config.Voters.IDs() {
+			voters = append(voters, id)
+		}
+	}
+	sort.Slice(voters, func(i, j int) bool { return voters[i] < voters[j] })
+	return voters
+}
+
+// Status of a change, with the voters that have not acknowledged it;
+// parameterMutex must be held
+func parameterChangeStatus(change *parameterChange, voters []uint64) map[string]interface{} {
+	acks := make([]ddlAck, 0, len(change.Acks))
+	for _, ack := range change.Acks {
+		acks = append(acks, ack)
+	}
+	sort.Slice(acks, func(i, j int) bool { return acks[i].Node < acks[j].Node })
+
+	pending := make([]uint64, 0)
+	failed := false
+	for _, id := range voters {
+		ack, acked := change.Acks[id]
+		if !acked {
+			pending = append(pending, id)
+		} else if !ack.OK {
+			failed = true
+		}
+	}
+
+	status := map[string]interface{}{
+		"id":       change.ID,
+		"origin":   change.Origin,
+		"name":     change.Name,
+		"reset":    change.Reset,
+		"index":    change.Index,
+		"term":     change.Term,
+		"acks":     acks,
+		"pending":  pending,
+		"complete": len(pending) == 0 && !failed,
+		"failed":   failed,
+	}
+	if !change.Reset {
+		status["value"] = change.Value
+	}
+	return status
+}
+
+// Replicate a parameter change from the leader; a NULL value resets the
+// parameter.  The change ID is stored in *changeID
+//
+//export pgraft_go_propose_parameter
+func pgraft_go_propose_parameter(name *C.char, value *C.char, changeID *C.uint64_t) C.int {
+	if name == nil || C.GoString(name) == "" {
+		return errInvalidArgument
+	}
+	if atomic.LoadInt32(&running) == 0 || activeConfig == nil {
+		return errNotRunning
+	}
+	snapshot := loadStatusSnapshot()
+	if snapshot == nil || snapshot.RaftState != raft.StateLeader {
+		return errNotLeader
+	}
+
+	body := parameterEntryBody{
+		ID:     newReplicatedID(),
+		Origin: activeConfig.NodeID,
+		Name:   C.GoString(name),
+		Reset:  value == nil,
+	}
+	if value != nil {
+		body.Value = C.GoString(value)
+	}
+
+	rc := proposeTypedEntry(typedEntryParameter, body)
+	if rc == errOK && changeID != nil {
+		*changeID = C.uint64_t(body.ID)
+	}
+	return C.int(rc)
+}
+
+// Record this node's outcome for a change; status 0 means applied
+//
+//export pgraft_go_parameter_ack
+func pgraft_go_parameter_ack(changeID C.uint64_t, status C.int, message *C.char) C.int {
+	if activeConfig == nil {
+		return errNotInitialized
+	}
+
+	ack := parameterAckBody{
+		ID:     uint64(changeID),
+		Node:   activeConfig.NodeID,
+		Status: int(status),
+	}
+	if message != nil {
+		ack.Message = C.GoString(message)
+	}
+	return C.int(proposeTypedEntry(typedEntryParameterAck, ack))
+}
+
+// Register the callback used by pgraft_go_drain_parameters(); NULL
+// unregisters
+//
+//export pgraft_go_set_parameter_callback
+func pgraft_go_set_parameter_callback(callback C.pgraft_go_parameter_callback, arg unsafe.Pointer) {
+	parameterMutex.Lock()
+	parameterCallback = callback
+	parameterCallbackArg = arg
+	parameterMutex.Unlock()
+}
+
+// Hand committed changes to the callback on the calling thread, in log
+// order; value is NULL for a reset.  Returns the number delivered
+//
+//export pgraft_go_drain_parameters
+func pgraft_go_drain_parameters() C.int {
+	parameterMutex.Lock()
+	callback, arg := parameterCallback, parameterCallbackArg
+	if callback == nil {
+		parameterMutex.Unlock()
+		return 0
+	}
+	queue := parameterQueue
+	parameterQueue = nil
+	parameterMutex.Unlock()
+
+	for _, change := range queue {
+		name := C.CString(change.Name)
+		var value *C.char
+		if !change.Reset {
+			value = C.CString(change.Value)
+		}
+		C.pgraft_go_call_parameter_callback(callback, C.uint64_t(change.ID), C.uint64_t(change.Index),
+			name, value, arg)
+		C.free(unsafe.Pointer(name))
+		if value != nil {
+			C.free(unsafe.Pointer(value))
+		}
+	}
+	return C.int(len(queue))
+}
+
+// Status of a parameter change as JSON, or NULL if unknown; free with
+// pgraft_go_free_string()
+//
+//export pgraft_go_parameter_status
+func pgraft_go_parameter_status(changeID C.uint64_t) *C.char {
+	voters := sortedVoters()
+
+	parameterMutex.Lock()
+	change, exists := parameterChanges[uint64(changeID)]
+	if !exists {
+		parameterMutex.Unlock()
+		return nil
+	}
+	status := parameterChangeStatus(change, voters)
+	parameterMutex.Unlock()
+
+	jsonData, err := json.Marshal(status)
+	if err != nil {
+		return C.CString("{\"error\": \"failed to marshal parameter status\"}")
+	}
+	return C.CString(string(jsonData))
+}
+
+// Latest change of every replicated parameter with its per-node status,
+// as a JSON array sorted by name; free with pgraft_go_free_string()
+//
+//export pgraft_go_parameters
+func pgraft_go_parameters() *C.char {
+	voters := sortedVoters()
+
+	parameterMutex.Lock()
+	names := make([]string, 0, len(parameterLatest))
+	for name := range parameterLatest {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	all := make([]map[string]interface{}, 0, len(names))
+	for _, name := range names {
+		all = append(all, parameterChangeStatus(parameterLatest[name], voters))
+	}
+	parameterMutex.Unlock()
+
+	jsonData, err := json.Marshal(all)
+	if err != nil {
+		return C.CString("[]")
+	}
+	return C.CString(string(jsonData))
+}
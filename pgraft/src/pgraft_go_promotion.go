@@ -0,0 +1,203 @@
+/*
+ * pgraft_go_promotion.go
+ * Promotion and demotion hooks driven by raft leadership
+ *
+ * The ticker compares the node's raft role with the last role reported to
+ * the extension.  A change is only reported once it has held for the
+ * debounce interval, so a leadership flap shorter than that never reaches
+ * PostgreSQL, and promotion and demotion strictly alternate: the
+ * extension sees exactly one PROMOTE per acquired leadership and one
+ * DEMOTE when it is lost.  Stopping the node demotes immediately.
+ *
+ * Transitions raise PGRAFT_EVENT_ROLE and are delivered to the registered
+ * callback by pgraft_go_drain_role_events() on the calling thread, where
+ * the extension can run pg_promote() or its demotion logic.
+ */
+
+package main
+
+/*
+#include <stdint.h>
+
+typedef void (*pgraft_go_role_callback) (int transition, uint64_t term, void *arg);
+
+static inline void
+pgraft_go_call_role_callback(pgraft_go_role_callback cb, int transition, uint64_t term, void *arg)
+{
+	cb(transition, term, arg);
+}
+*/
+import "C"
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"go.etcd.io/raft/v3"
+)
+
+// Role transitions, mirrored by PGRAFT_TRANSITION_* in pgraft_go.h
+const (
+	transitionPromote = 1
+	transitionDemote  = 2
+)
+
+const defaultPromotionDebounce = time.Second
+
+type roleTransition struct {
+	kind int
+	term uint64
+}
+
+var (
+	promotionDebounce = defaultPromotionDebounce
+
+	// Role last reported to the extension
+	confirmedLeader bool
+
+	// Role currently observed and since when
+	observedLeader bool
+	observedSince  time.Time
+
+	roleTransitions []roleTransition
+	roleCallback    C.pgraft_go_role_callback
+	roleCallbackArg unsafe.Pointer
+	promotionMutex  sync.Mutex
+)
+
+// Queue a transition; promotionMutex must be held
+func reportRoleTransition(kind int, term uint64) {
+	confirmedLeader = kind == transitionPromote
+	roleTransitions = append(roleTransitions, roleTransition{kind: kind, term: term})
+
+	if kind == transitionPromote {
+		log.Printf("pgraft: INFO - Leadership confirmed in term %d, requesting promotion", term)
+	} else {
+		log.Printf("pgraft: INFO - Leadership lost in term %d, requesting demotion", term)
+	}
+	emitRaftEvent(eventRole, uint64(kind), term, nil)
+}
+
+// Compare the raft role with the reported one; called on every tick
+func evaluateLeadership() {
+	snapshot := loadStatusSnapshot()
+	isLeader := snapshot != nil && atomic.LoadInt32(&running) == 1 &&
+		snapshot.RaftState == raft.StateLeader && !lsnTransferPending() && !fencingPending()
+	var term uint64
+	if snapshot != nil {
+		term = snapshot.Term
+	}
+
+	promotionMutex.Lock()
+	defer promotionMutex.Unlock()
+
+	now := time.Now()
+	if isLeader != observedLeader {
+		observedLeader = isLeader
+		observedSince = now
+	}
+	if observedLeader != confirmedLeader && now.Sub(observedSince) >= promotionDebounce {
+		// Promotion waits for maintenance mode to end
+		if observedLeader && maintenanceActive() {
+			return
+		}
+		if observedLeader {
+			reportRoleTransition(transitionPromote, term)
+		} else {
+			reportRoleTransition(transitionDemote, term)
+		}
+	}
+}
+
+// Report a demotion without debounce when the node stops while leader
+func forceDemotion() {
+	var term uint64
+	if snapshot := loadStatusSnapshot(); snapshot != nil {
+		term = snapshot.Term
+	}
+
+	promotionMutex.Lock()
+	defer promotionMutex.Unlock()
+
+	observedLeader = false
+	observedSince = time.Now()
+	if confirmedLeader {
+		reportRoleTransition(transitionDemote, term)
+	}
+}
+
+func resetPromotionState() {
+	promotionMutex.Lock()
+	confirmedLeader = false
+	observedLeader = false
+	observedSince = time.Time{}
+	roleTransitions = nil
+	roleCallback = nil
+	roleCallbackArg = nil
+	promotionMutex.Unlock()
+}
+
+// Set how long a role change must hold before it is reported; returns the
+// previous interval in milliseconds
+//
+//export pgraft_go_set_promotion_debounce
+func pgraft_go_set_promotion_debounce(debounceMs C.int) C.int {
+	if debounceMs < 0 {
+		return errInvalidArgument
+	}
+
+	promotionMutex.Lock()
+	defer promotionMutex.Unlock()
+
+	previous := promotionDebounce
+	promotionDebounce = time.Duration(debounceMs) * time.Millisecond
+	return C.int(previous.Milliseconds())
+}
+
+// Register the callback used by pgraft_go_drain_role_events(); NULL
+// unregisters
+//
+//export pgraft_go_set_role_callback
+func pgraft_go_set_role_callback(callback C.pgraft_go_role_callback, arg unsafe.Pointer) {
+	promotionMutex.Lock()
+	roleCallback = callback
+	roleCallbackArg = arg
+	promotionMutex.Unlock()
+}
+
+// Deliver queued transitions to the callback on the calling thread;
+// returns the number delivered
+//
+//export pgraft_go_drain_role_events
+func pgraft_go_drain_role_events() C.int {
+	promotionMutex.Lock()
+	callback, arg := roleCallback, roleCallbackArg
+	if callback == nil {
+		promotionMutex.Unlock()
+		return 0
+	}
+	transitions := roleTransitions
+	roleTransitions = nil
+	promotionMutex.Unlock()
+
+	for _, t := range transitions {
+		C.pgraft_go_call_role_callback(callback, C.int(t.kind), C.uint64_t(t.term), arg)
+	}
+	return C.int(len(transitions))
+}
+
+// Returns 1 if the last reported transition was a promotion
+//
+//export pgraft_go_is_promoted
+func pgraft_go_is_promoted() C.int {
+	promotionMutex.Lock()
+	defer promotionMutex.Unlock()
+
+	if confirmedLeader {
+		return 1
+	}
+	return 0
+}
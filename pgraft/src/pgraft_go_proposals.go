@@ -0,0 +1,252 @@
+/*
+ * pgraft_go_proposals.go
+ * Proposal tracking by caller-supplied correlation ID
+ *
+ * Several backends can have proposals in flight at once; each tags its
+ * proposal with a correlation ID unique among its outstanding proposals
+ * (for example its procnumber in the high bits and a local counter) and
+ * later asks for the status by that ID.  The entry carries this node's ID
+ * and a proposal ID of its own in a proposal envelope (see
+ * pgraft_go_typed.go), by which it is recognised when it commits, however
+ * many proposals of the same payload are in flight.  A proposal moves
+ * from pending to committed when its entry is committed and to applied once the apply
+ * loop has processed it.  A proposal that was rejected, or that is still
+ * pending after trackedProposalTimeout (for example because leadership
+ * changed and the entry was dropped), is reported as failed.  Finished
+ * proposals are kept until forgotten or until trackedProposalRetention
+ * has passed.
+ */
+
+package main
+
+/*
+#include <stdint.h>
+*/
+import "C"
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"go.etcd.io/raft/v3"
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+// Proposal states, mirrored by PGRAFT_PROPOSAL_* in pgraft_go.h
+const (
+	proposalPending   = 1
+	proposalCommitted = 2
+	proposalApplied   = 3
+	proposalFailed    = 4
+)
+
+const (
+	trackedProposalTimeout   = 30 * time.Second
+	trackedProposalRetention = 10 * time.Minute
+)
+
+type trackedProposal struct {
+	correlationID uint64
+	proposalID    uint64
+	status        int
+	index         uint64
+	term          uint64
+	proposedAt    time.Time
+	finishedAt    time.Time
+}
+
+var (
+	trackedProposals = make(map[uint64]*trackedProposal)
+
+	// Correlation IDs of pending proposals, by proposal ID
+	trackedByProposal = make(map[uint64]uint64)
+
+	// Correlation IDs of committed proposals awaiting apply, by index
+	trackedByIndex = make(map[uint64]uint64)
+
+	trackedMutex sync.Mutex
+)
+
+func finishProposal(p *trackedProposal, status int) {
+	switch p.status {
+	case proposalPending:
+		delete(trackedByProposal, p.proposalID)
+	case proposalCommitted:
+		delete(trackedByIndex, p.index)
+	}
+	p.status = status
+	if status != proposalCommitted {
+		p.finishedAt = time.Now()
+	}
+}
+
+// Fail timed-out proposals and forget finished ones past retention;
+// trackedMutex must be held
+func expireTrackedProposals(now time.Time) {
+	for id, p := range trackedProposals {
+		switch {
+		case p.status == proposalPending && now.Sub(p.proposedAt) > trackedProposalTimeout:
+			log.Printf("pgraft: WARNING - Proposal %d timed out", id)
+			finishProposal(p, proposalFailed)
+		case !p.finishedAt.IsZero() && now.Sub(p.finishedAt) > trackedProposalRetention:
+			delete(trackedProposals, id)
+		}
+	}
+}
+
+// Advance the tracked proposal matching a committed or applied entry
+func markTrackedProposal(entry raftpb.Entry, status int) {
+	if entry.Type != raftpb.EntryNormal || len(entry.Data) == 0 {
+		return
+	}
+
+	trackedMutex.Lock()
+	defer trackedMutex.Unlock()
+
+	var p *trackedProposal
+	if status == proposalCommitted {
+		proposalID := localProposalID(entry)
+		if proposalID == 0 {
+			return
+		}
+		id, exists := trackedByProposal[proposalID]
+		if !exists {
+			return
+		}
+		if p = trackedProposals[id]; p == nil {
+			return
+		}
+		finishProposal(p, status)
+		p.index = entry.Index
+		p.term = entry.Term
+		trackedByIndex[entry.Index] = p.correlationID
+		return
+	}
+
+	id, exists := trackedByIndex[entry.Index]
+	if !exists {
+		return
+	}
+	if p = trackedProposals[id]; p != nil {
+		finishProposal(p, status)
+	}
+}
+
+func resetTrackedProposals() {
+	trackedMutex.Lock()
+	trackedProposals = make(map[uint64]*trackedProposal)
+	trackedByProposal = make(map[uint64]uint64)
+	trackedByIndex = make(map[uint64]uint64)
+	trackedMutex.Unlock()
+}
+
+// Propose data under a caller-chosen correlation ID; fails with
+// PGRAFT_ERR_INVALID_ARGUMENT if the ID is still pending or the data
+// begins with the prefix reserved for typed entries
+//
+//export pgraft_go_propose_tracked
+func pgraft_go_propose_tracked(correlationID C.uint64_t, data *C.char, length C.int) C.int {
+	if atomic.LoadInt32(&running) == 0 {
+		return errNotRunning
+	}
+	if correlationID == 0 || data == nil || length <= 0 {
+		return errInvalidArgument
+	}
+	if rc := proposalGate(); rc != errOK {
+		return rc
+	}
+
+	goData := C.GoBytes(unsafe.Pointer(data), length)
+	if reservedPayload(goData) {
+		log.Printf("pgraft: ERROR - Proposal %d begins with the prefix reserved for typed entries", correlationID)
+		return errInvalidArgument
+	}
+	id := uint64(correlationID)
+	now := time.Now()
+
+	trackedMutex.Lock()
+	expireTrackedProposals(now)
+	if existing, exists := trackedProposals[id]; exists &&
+		(existing.status == proposalPending || existing.status == proposalCommitted) {
+		trackedMutex.Unlock()
+		log.Printf("pgraft: ERROR - Correlation ID %d already in flight", id)
+		return errInvalidArgument
+	}
+	p := &trackedProposal{
+		correlationID: id,
+		proposalID:    newTicket(),
+		status:        proposalPending,
+		proposedAt:    now,
+	}
+	trackedProposals[id] = p
+	trackedByProposal[p.proposalID] = id
+	trackedMutex.Unlock()
+
+	raftMutex.RLock()
+	node, ctx := raftNode, raftCtx
+	raftMutex.RUnlock()
+
+	var err error
+	if node == nil {
+		err = raft.ErrStopped
+	} else {
+		err = node.Propose(ctx, encodeProposalEntry(p.proposalID, goData))
+	}
+	if err != nil {
+		log.Printf("pgraft: ERROR - Proposal %d rejected: %v", id, err)
+		trackedMutex.Lock()
+		finishProposal(p, proposalFailed)
+		trackedMutex.Unlock()
+		return errProposalDropped
+	}
+
+	return errOK
+}
+
+// Report the state of a tracked proposal (PGRAFT_PROPOSAL_*), storing its
+// index and term once known; PGRAFT_ERR_NOT_FOUND for unknown IDs
+//
+//export pgraft_go_proposal_status
+func pgraft_go_proposal_status(correlationID C.uint64_t, index *C.uint64_t, term *C.uint64_t) C.int {
+	trackedMutex.Lock()
+	defer trackedMutex.Unlock()
+
+	expireTrackedProposals(time.Now())
+
+	p, exists := trackedProposals[uint64(correlationID)]
+	if !exists {
+		return errNotFound
+	}
+	if index != nil {
+		*index = C.uint64_t(p.index)
+	}
+	if term != nil {
+		*term = C.uint64_t(p.term)
+	}
+	return C.int(p.status)
+}
+
+// Stop tracking a proposal; a pending one still commits but is no longer
+// reported
+//
+//export pgraft_go_proposal_forget
+func pgraft_go_proposal_forget(correlationID C.uint64_t) C.int {
+	trackedMutex.Lock()
+	defer trackedMutex.Unlock()
+
+	p, exists := trackedProposals[uint64(correlationID)]
+	if !exists {
+		return errNotFound
+	}
+	switch p.status {
+	case proposalPending:
+		delete(trackedByProposal, p.proposalID)
+	case proposalCommitted:
+		delete(trackedByIndex, p.index)
+	}
+	delete(trackedProposals, p.correlationID)
+	return errOK
+}
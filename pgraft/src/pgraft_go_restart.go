@@ -0,0 +1,293 @@
+/*
+ * pgraft_go_restart.go
+ * Rolling restarts coordinated through the raft log
+ *
+ * A node that wants to restart, for a minor upgrade or a parameter that
+ * needs one, asks for a restart slot with pgraft_go_request_restart().
+ * Requests, grants and completions are typed entries, so every member
+ * sees the same queue.  The leader grants one slot at a time from its
+ * ticker, in request order but never to itself while another node is
+ * waiting, and only when the voters left running, all replicating and
+ * recently active, still form a quorum.  The granted node polls
+ * pgraft_go_restart_granted(), restarts, and reports
+ * pgraft_go_restart_complete() once it is back; the next slot is only
+ * granted when the quorum check passes again, i.e. the restarted node has
+ * caught up.  A grant not completed within the grant timeout is revoked.
+ */
+
+package main
+
+/*
+#include <stdint.h>
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"go.etcd.io/raft/v3"
+	"go.etcd.io/raft/v3/tracker"
+)
+
+const (
+	restartOpRequest = "request"
+	restartOpCancel  = "cancel"
+	restartOpGrant   = "grant"
+	restartOpDone    = "done"
+	restartOpRevoke  = "revoke"
+
+	defaultRestartGrantTimeout = 15 * time.Minute
+
+	// Minimum time between grant or revoke proposals from the ticker
+	restartProposeInterval = time.Second
+)
+
+type restartEntryBody struct {
+	Op    string `json:"op"`
+	Node  uint64 `json:"node"`
+	NowMs int64  `json:"now_ms"`
+}
+
+type restartState struct {
+	Queue       []uint64         `json:"queue"`
+	Granted     uint64           `json:"granted,omitempty"`
+	GrantedAtMs int64            `json:"granted_at_ms,omitempty"`
+	Completed   map[uint64]int64 `json:"completed_at_ms"`
+}
+
+var (
+	restarts = restartState{Queue: []uint64{}, Completed: make(map[uint64]int64)}
+
+	restartGrantTimeout = defaultRestartGrantTimeout
+	restartProposedAt   time.Time
+	restartMutex        sync.Mutex
+)
+
+// Remove node from the queue; restartMutex must be held
+func dequeueRestart(node uint64) bool {
+	for i, id := range restarts.Queue {
+		if id == node {
+			restarts.Queue = append(restarts.Queue[:i], restarts.Queue[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func applyRestartEntry(index, term uint64, body []byte) {
+	var entry restartEntryBody
+	if err := json.Unmarshal(body, &entry); err != nil {
+		log.Printf("pgraft: ERROR - Malformed restart entry at index %d: %v", index, err)
+		return
+	}
+
+	restartMutex.Lock()
+	defer restartMutex.Unlock()
+
+	switch entry.Op {
+	case restartOpRequest:
+		if entry.Node == restarts.Granted {
+			return
+		}
+		for _, id := range restarts.Queue {
+			if id == entry.Node {
+				return
+			}
+		}
+		restarts.Queue = append(restarts.Queue, entry.Node)
+		log.Printf("pgraft: INFO - Node %d requested a restart slot", entry.Node)
+	case restartOpCancel:
+		dequeueRestart(entry.Node)
+		if restarts.Granted == entry.Node {
+			restarts.Granted = 0
+			restarts.GrantedAtMs = 0
+		}
+	case restartOpGrant:
+		if restarts.Granted != 0 || !dequeueRestart(entry.Node) {
+			return
+		}
+		restarts.Granted = entry.Node
+		restarts.GrantedAtMs = entry.NowMs
+		log.Printf("pgraft: INFO - Restart slot granted to node %d", entry.Node)
+	case restartOpDone:
+		if restarts.Granted != entry.Node {
+			return
+		}
+		restarts.Granted = 0
+		restarts.GrantedAtMs = 0
+		restarts.Completed[entry.Node] = entry.NowMs
+		log.Printf("pgraft: INFO - Node %d completed its restart", entry.Node)
+	case restartOpRevoke:
+		if restarts.Granted != entry.Node {
+			return
+		}
+		restarts.Granted = 0
+		restarts.GrantedAtMs = 0
+		log.Printf("pgraft: WARNING - Restart slot of node %d revoked after timing out", entry.Node)
+	default:
+		log.Printf("pgraft: WARNING - Unknown restart operation %q at index %d", entry.Op, index)
+	}
+}
+
+func resetRestarts() {
+	restartMutex.Lock()
+	restarts = restartState{Queue: []uint64{}, Completed: make(map[uint64]int64)}
+	restartGrantTimeout = defaultRestartGrantTimeout
+	restartProposedAt = time.Time{}
+	restartMutex.Unlock()
+}
+
+// True if the voters other than node that are replicating and recently
+// active form a quorum
+func quorumWithout(status raft.Status, node uint64) bool {
+	voters := status.Config.Voters.IDs()
+	healthy := 0
+	for id := range voters {
+		if id == node {
+			continue
+		}
+		if id == status.ID {
+			healthy++
+			continue
+		}
+		if pr, tracked := status.Progress[id]; tracked && pr.RecentActive && pr.State == tracker.StateReplicate {
+			healthy++
+		}
+	}
+	return healthy >= len(voters)/2+1
+}
+
+// Grant the next restart slot, or revoke an expired one; called on every
+// tick
+func grantRestartSlots() {
+	snapshot := loadStatusSnapshot()
+	node := raftNode
+	if snapshot == nil || snapshot.RaftState != raft.StateLeader || node == nil {
+		return
+	}
+
+	restartMutex.Lock()
+	if time.Since(restartProposedAt) < restartProposeInterval {
+		restartMutex.Unlock()
+		return
+	}
+
+	body := restartEntryBody{NowMs: time.Now().UnixMilli()}
+	switch {
+	case restarts.Granted != 0:
+		if time.Duration(body.NowMs-restarts.GrantedAtMs)*time.Millisecond < restartGrantTimeout {
+			restartMutex.Unlock()
+			return
+		}
+		body.Op, body.Node = restartOpRevoke, restarts.Granted
+	case len(restarts.Queue) == 0:
+		restartMutex.Unlock()
+		return
+	default:
+		// The leader goes last
+		for _, id := range restarts.Queue {
+			if id != snapshot.NodeID {
+				body.Node = id
+				break
+			}
+		}
+		if body.Node == 0 {
+			body.Node = snapshot.NodeID
+		}
+		if !quorumWithout(node.Status(), body.Node) {
+			restartMutex.Unlock()
+			return
+		}
+		body.Op = restartOpGrant
+	}
+	restartProposedAt = time.Now()
+	restartMutex.Unlock()
+
+	// Proposing blocks on the raft loop, which the ticker feeds
+	go func() {
+		if rc := proposeTypedEntry(typedEntryRestart, body); rc != errOK {
+			debugLog("restart: proposing %s for node %d failed (%d)", body.Op, body.Node, rc)
+		}
+	}()
+}
+
+// Propose a restart operation for this node
+func proposeRestartOp(op string) C.int {
+	if activeConfig == nil {
+		return errNotInitialized
+	}
+	return C.int(proposeTypedEntry(typedEntryRestart, restartEntryBody{
+		Op:    op,
+		Node:  activeConfig.NodeID,
+		NowMs: time.Now().UnixMilli(),
+	}))
+}
+
+// Ask for a restart slot for this node
+//
+//export pgraft_go_request_restart
+func pgraft_go_request_restart() C.int {
+	return proposeRestartOp(restartOpRequest)
+}
+
+// Withdraw this node's restart request or give up its slot
+//
+//export pgraft_go_cancel_restart
+func pgraft_go_cancel_restart() C.int {
+	return proposeRestartOp(restartOpCancel)
+}
+
+// Report that this node has restarted and released its slot
+//
+//export pgraft_go_restart_complete
+func pgraft_go_restart_complete() C.int {
+	return proposeRestartOp(restartOpDone)
+}
+
+// 1 if this node holds the restart slot, 0 otherwise
+//
+//export pgraft_go_restart_granted
+func pgraft_go_restart_granted() C.int {
+	if activeConfig == nil {
+		return 0
+	}
+
+	restartMutex.Lock()
+	defer restartMutex.Unlock()
+	if restarts.Granted == activeConfig.NodeID {
+		return 1
+	}
+	return 0
+}
+
+// Set how long a granted node may take to complete its restart
+//
+//export pgraft_go_set_restart_grant_timeout
+func pgraft_go_set_restart_grant_timeout(timeoutMs C.int) C.int {
+	if timeoutMs <= 0 {
+		return errInvalidArgument
+	}
+
+	restartMutex.Lock()
+	restartGrantTimeout = time.Duration(timeoutMs) * time.Millisecond
+	restartMutex.Unlock()
+	return errOK
+}
+
+// Restart queue, current grant and completed restarts as JSON; free with
+// pgraft_go_free_string()
+//
+//export pgraft_go_restart_status
+func pgraft_go_restart_status() *C.char {
+	restartMutex.Lock()
+	jsonData, err := json.Marshal(restarts)
+	restartMutex.Unlock()
+
+	if err != nil {
+		return C.CString("{\"error\": \"failed to marshal restart status\"}")
+	}
+	return C.CString(string(jsonData))
+}
@@ -0,0 +1,227 @@
+/*
+ * pgraft_go_rewind.go
+ * Coordination of pg_rewind after a failover
+ *
+ * A newly promoted primary records the point where its timeline forked
+ * from the old one with pgraft_go_report_promotion(), and every node
+ * reports its own timeline and flush LSN with
+ * pgraft_go_report_node_position().  Both are typed entries, so every
+ * member can compute the same verdict for each node: a node still on an
+ * older timeline whose WAL extends past the fork point has diverged and
+ * needs pg_rewind before it can follow the new primary; promotions also
+ * extend the timeline history kept in pgraft_go_timeline.go.  ramd reads the
+ * verdict with pgraft_go_rewind_status(), runs pg_rewind against the
+ * target timeline and reports back with pgraft_go_rewind_complete().
+ */
+
+package main
+
+/*
+#include <stdint.h>
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+)
+
+// Rewind steps carried in typedEntryRewind entries
+const (
+	rewindOpPromotion = "promotion"
+	rewindOpPosition  = "position"
+	rewindOpComplete  = "complete"
+)
+
+type rewindEntryBody struct {
+	Op       string `json:"op"`
+	Node     uint64 `json:"node"`
+	Timeline uint32 `json:"timeline,omitempty"`
+	Parent   uint32 `json:"parent,omitempty"`
+	LSN      uint64 `json:"lsn,omitempty"`
+	Status   int    `json:"status,omitempty"`
+	Message  string `json:"message,omitempty"`
+}
+
+// Last reported position of a node and its rewind outcome
+type rewindNode struct {
+	Timeline      uint32
+	FlushLSN      uint64
+	RewindFailed  bool
+	RewindMessage string
+}
+
+// Fork point recorded by the latest promotion
+type rewindFork struct {
+	Primary  uint64
+	Timeline uint32
+	LSN      uint64
+	Index    uint64
+}
+
+type rewindVerdict struct {
+	Node           uint64 `json:"node"`
+	Timeline       uint32 `json:"timeline"`
+	FlushLSN       uint64 `json:"flush_lsn"`
+	NeedsRewind    bool   `json:"needs_rewind"`
+	Primary        uint64 `json:"primary"`
+	TargetTimeline uint32 `json:"target_timeline"`
+	DivergenceLSN  uint64 `json:"divergence_lsn"`
+	RewindFailed   bool   `json:"rewind_failed,omitempty"`
+	Message        string `json:"message,omitempty"`
+}
+
+var (
+	rewindNodes  = make(map[uint64]*rewindNode)
+	rewindLatest rewindFork
+	rewindMutex  sync.Mutex
+)
+
+func applyRewindEntry(index, term uint64, body []byte) {
+	var entry rewindEntryBody
+	if err := json.Unmarshal(body, &entry); err != nil {
+		log.Printf("pgraft: ERROR - Malformed rewind entry at index %d: %v", index, err)
+		return
+	}
+
+	rewindMutex.Lock()
+	defer rewindMutex.Unlock()
+
+	node, known := rewindNodes[entry.Node]
+	if !known {
+		node = &rewindNode{}
+		rewindNodes[entry.Node] = node
+	}
+	switch entry.Op {
+	case rewindOpPromotion:
+		parent := entry.Parent
+		if parent == 0 {
+			parent = entry.Timeline - 1
+		}
+		recordTimelineSwitch(timelineSwitch{
+			Timeline:  entry.Timeline,
+			Parent:    parent,
+			SwitchLSN: entry.LSN,
+			Node:      entry.Node,
+			Index:     index,
+		})
+		if entry.Timeline <= rewindLatest.Timeline {
+			return
+		}
+		rewindLatest = rewindFork{Primary: entry.Node, Timeline: entry.Timeline, LSN: entry.LSN, Index: index}
+		node.Timeline = entry.Timeline
+		log.Printf("pgraft: INFO - Node %d promoted to timeline %d at %X/%X", entry.Node, entry.Timeline,
+			uint32(entry.LSN>>32), uint32(entry.LSN))
+	case rewindOpPosition:
+		node.Timeline = entry.Timeline
+		node.FlushLSN = entry.LSN
+	case rewindOpComplete:
+		node.RewindFailed = entry.Status != 0
+		node.RewindMessage = entry.Message
+		if entry.Status == 0 {
+			node.Timeline = entry.Timeline
+			log.Printf("pgraft: INFO - Node %d rewound to timeline %d", entry.Node, entry.Timeline)
+		} else {
+			log.Printf("pgraft: ERROR - Rewind of node %d failed: %s", entry.Node, entry.Message)
+		}
+	}
+}
+
+// Verdict for one node; rewindMutex must be held
+func rewindVerdictFor(id uint64) rewindVerdict {
+	verdict := rewindVerdict{
+		Node:           id,
+		Primary:        rewindLatest.Primary,
+		TargetTimeline: rewindLatest.Timeline,
+		DivergenceLSN:  rewindLatest.LSN,
+	}
+	node, known := rewindNodes[id]
+	if !known {
+		return verdict
+	}
+	verdict.Timeline = node.Timeline
+	verdict.FlushLSN = node.FlushLSN
+	verdict.RewindFailed = node.RewindFailed
+	verdict.Message = node.RewindMessage
+	if fork, known := timelineDivergence(node.Timeline); known {
+		verdict.DivergenceLSN = fork
+		verdict.NeedsRewind = id != rewindLatest.Primary && node.FlushLSN > fork
+	}
+	return verdict
+}
+
+func resetRewind() {
+	rewindMutex.Lock()
+	rewindNodes = make(map[uint64]*rewindNode)
+	rewindLatest = rewindFork{}
+	rewindMutex.Unlock()
+}
+
+// Propose a rewind entry about this node
+func proposeRewindEntry(entry rewindEntryBody) C.int {
+	if activeConfig == nil {
+		return errNotInitialized
+	}
+	entry.Node = activeConfig.NodeID
+	return C.int(proposeTypedEntry(typedEntryRewind, entry))
+}
+
+// Record that this node was promoted onto timeline, forking at switchLSN
+//
+//export pgraft_go_report_promotion
+func pgraft_go_report_promotion(timeline C.uint32_t, switchLSN C.uint64_t) C.int {
+	if timeline < 2 {
+		return errInvalidArgument
+	}
+	return proposeRewindEntry(rewindEntryBody{Op: rewindOpPromotion, Timeline: uint32(timeline), LSN: uint64(switchLSN)})
+}
+
+// Record this node's current timeline and flush LSN
+//
+//export pgraft_go_report_node_position
+func pgraft_go_report_node_position(timeline C.uint32_t, flushLSN C.uint64_t) C.int {
+	if timeline == 0 {
+		return errInvalidArgument
+	}
+	return proposeRewindEntry(rewindEntryBody{Op: rewindOpPosition, Timeline: uint32(timeline), LSN: uint64(flushLSN)})
+}
+
+// Report the outcome of pg_rewind on this node; status 0 means it now
+// follows timeline
+//
+//export pgraft_go_rewind_complete
+func pgraft_go_rewind_complete(timeline C.uint32_t, status C.int, message *C.char) C.int {
+	entry := rewindEntryBody{Op: rewindOpComplete, Timeline: uint32(timeline), Status: int(status)}
+	if message != nil {
+		entry.Message = C.GoString(message)
+	}
+	return proposeRewindEntry(entry)
+}
+
+// Rewind verdict for a node as JSON; node 0 returns every reported node
+// as an array.  Free with pgraft_go_free_string()
+//
+//export pgraft_go_rewind_status
+func pgraft_go_rewind_status(node C.uint64_t) *C.char {
+	rewindMutex.Lock()
+	var result interface{}
+	if node != 0 {
+		result = rewindVerdictFor(uint64(node))
+	} else {
+		verdicts := make([]rewindVerdict, 0, len(rewindNodes))
+		for id := range rewindNodes {
+			verdicts = append(verdicts, rewindVerdictFor(id))
+		}
+		sort.Slice(verdicts, func(i, j int) bool { return verdicts[i].Node < verdicts[j].Node })
+		result = verdicts
+	}
+	rewindMutex.Unlock()
+
+	jsonData, err := json.Marshal(result)
+	if err != nil {
+		return C.CString("{\"error\": \"failed to marshal rewind status\"}")
+	}
+	return C.CString(string(jsonData))
+}
@@ -0,0 +1,381 @@
+/*
+ * pgraft_go_ring.go
+ * Shared-memory rings for proposals and applied entries
+ *
+ * The C extension can map two memory regions (for example in PostgreSQL
+ * shared memory) and attach them here.  Backends append proposals to the
+ * proposal ring without a cgo call per entry; a goroutine drains the ring
+ * into raftNode.Propose() while the node is initialized.  A record is only
+ * consumed once proposed, so records stay in the ring while the node is
+ * down or not accepting proposals.  Committed normal entries are written to
+ * the applied ring, which the C side consumes directly.
+ *
+ * Each region starts with a pgraft_ring_header (see pgraft_go.h) padded to
+ * PGRAFT_RING_DATA_OFFSET bytes, followed by a circular byte buffer of
+ * records.  A record is a 24-byte header (length, flags, index, term)
+ * followed by the payload, padded to an 8-byte boundary; records may wrap
+ * around the end of the buffer.  Each ring has exactly one producer and
+ * one consumer: head is only advanced by the producer and tail only by the
+ * consumer, both with atomic stores after the record bytes are in place.
+ */
+
+package main
+
+/*
+#include <stddef.h>
+*/
+import "C"
+
+import (
+	"encoding/binary"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+const (
+	ringMagic      = 0x50475247 // "PGRG"
+	ringVersion    = 1
+	ringDataOffset = 64
+	ringRecordHdr  = 24
+
+	// How often the proposal ring is checked when no notification arrives
+	ringPollInterval = 5 * time.Millisecond
+)
+
+// ringHeader matches pgraft_ring_header in pgraft_go.h
+type ringHeader struct {
+	magic    uint32
+	version  uint32
+	capacity uint64
+	head     uint64
+	tail     uint64
+}
+
+type shmRing struct {
+	hdr  *ringHeader
+	data []byte
+}
+
+var (
+	proposalRing   *shmRing
+	appliedRing    *shmRing
+	ringMutex      sync.Mutex
+	ringNotify     chan struct{}
+	ringStop       chan struct{}
+	ringDrained    chan struct{}
+	ringOverflows  int64
+	ringProposals  int64
+	ringCorruption int64
+	ringRejected   int64
+)
+
+// Wrap a C memory region as a ring, initializing the header if the C side
+// has not done so yet
+func newShmRing(base unsafe.Pointer, size uintptr) *shmRing {
+	if base == nil || size <= ringDataOffset+ringRecordHdr {
+		return nil
+	}
+
+	hdr := (*ringHeader)(base)
+	capacity := uint64(size - ringDataOffset)
+
+	if atomic.LoadUint32(&hdr.magic) == 0 {
+		hdr.version = ringVersion
+		hdr.capacity = capacity
+		atomic.StoreUint64(&hdr.head, 0)
+		atomic.StoreUint64(&hdr.tail, 0)
+		atomic.StoreUint32(&hdr.magic, ringMagic)
+	}
+
+	if hdr.magic != ringMagic || hdr.version != ringVersion || hdr.capacity > capacity {
+		log.Printf("pgraft: ERROR - Invalid ring header (magic=%x, version=%d, capacity=%d)",
+			hdr.magic, hdr.version, hdr.capacity)
+		return nil
+	}
+
+	return &shmRing{
+		hdr:  hdr,
+		data: unsafe.Slice((*byte)(unsafe.Add(base, ringDataOffset)), hdr.capacity),
+	}
+}
+
+func ringAlign(n uint64) uint64 {
+	return (n + 7) &^ 7
+}
+
+func (r *shmRing) readAt(pos uint64, dst []byte) {
+	off := pos % r.hdr.capacity
+	n := copy(dst, r.data[off:])
+	if n < len(dst) {
+		copy(dst[n:], r.data)
+	}
+}
+
+func (r *shmRing) writeAt(pos uint64, src []byte) {
+	off := pos % r.hdr.capacity
+	n := copy(r.data[off:], src)
+	if n < len(src) {
+		copy(r.data, src[n:])
+	}
+}
+
+// Consume the next record; ok is false when the ring is empty or the
+// record is malformed
+func (r *shmRing) pop() (payload []byte, flags uint32, index uint64, term uint64, ok bool) {
+	payload, flags, index, term, ok = r.peek()
+	if ok {
+		r.skip()
+	}
+	return payload, flags, index, term, ok
+}
+
+// Read the next record without consuming it; a malformed record discards
+// the ring contents
+func (r *shmRing) peek() (payload []byte, flags uint32, index uint64, term uint64, ok bool) {
+	tail := atomic.LoadUint64(&r.hdr.tail)
+	head := atomic.LoadUint64(&r.hdr.head)
+	if tail == head {
+		return nil, 0, 0, 0, false
+	}
+
+	var recHdr [ringRecordHdr]byte
+	r.readAt(tail, recHdr[:])
+	length := uint64(binary.LittleEndian.Uint32(recHdr[0:4]))
+	flags = binary.LittleEndian.Uint32(recHdr[4:8])
+	index = binary.LittleEndian.Uint64(recHdr[8:16])
+	term = binary.LittleEndian.Uint64(recHdr[16:24])
+
+	recLen := ringAlign(ringRecordHdr + length)
+	if recLen > head-tail || recLen > r.hdr.capacity {
+		atomic.AddInt64(&ringCorruption, 1)
+		log.Printf("pgraft: ERROR - Corrupt ring record at %d (length %d), discarding ring contents", tail, length)
+		atomic.StoreUint64(&r.hdr.tail, head)
+		return nil, 0, 0, 0, false
+	}
+
+	payload = make([]byte, length)
+	r.readAt(tail+ringRecordHdr, payload)
+
+	return payload, flags, index, term, true
+}
+
+// Consume the record peek() returned
+func (r *shmRing) skip() {
+	tail := atomic.LoadUint64(&r.hdr.tail)
+	var recHdr [4]byte
+	r.readAt(tail, recHdr[:])
+	atomic.StoreUint64(&r.hdr.tail, tail+ringAlign(ringRecordHdr+uint64(binary.LittleEndian.Uint32(recHdr[:]))))
+}
+
+// Append a record; false when there is not enough free space
+func (r *shmRing) push(payload []byte, flags uint32, index uint64, term uint64) bool {
+	head := atomic.LoadUint64(&r.hdr.head)
+	tail := atomic.LoadUint64(&r.hdr.tail)
+
+	recLen := ringAlign(ringRecordHdr + uint64(len(payload)))
+	if r.hdr.capacity-(head-tail) < recLen {
+		return false
+	}
+
+	var recHdr [ringRecordHdr]byte
+	binary.LittleEndian.PutUint32(recHdr[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(recHdr[4:8], flags)
+	binary.LittleEndian.PutUint64(recHdr[8:16], index)
+	binary.LittleEndian.PutUint64(recHdr[16:24], term)
+
+	r.writeAt(head, recHdr[:])
+	r.writeAt(head+ringRecordHdr, payload)
+	atomic.StoreUint64(&r.hdr.head, head+recLen)
+
+	return true
+}
+
+// Attach the proposal and applied rings; either region may be NULL
+//
+//export pgraft_go_ring_attach
+func pgraft_go_ring_attach(proposals unsafe.Pointer, proposalsSize C.size_t, applied unsafe.Pointer, appliedSize C.size_t) C.int {
+	nodeStop := currentNodeStop()
+
+	ringMutex.Lock()
+	defer ringMutex.Unlock()
+
+	if proposalRing != nil || appliedRing != nil {
+		log.Printf("pgraft: WARNING - Shared-memory rings already attached")
+		return -1
+	}
+
+	var pRing, aRing *shmRing
+	if proposals != nil {
+		if pRing = newShmRing(proposals, uintptr(proposalsSize)); pRing == nil {
+			return -1
+		}
+	}
+	if applied != nil {
+		if aRing = newShmRing(applied, uintptr(appliedSize)); aRing == nil {
+			return -1
+		}
+	}
+
+	proposalRing = pRing
+	appliedRing = aRing
+	if proposalRing != nil {
+		ringNotify = make(chan struct{}, 1)
+	}
+	startRingDrain(nodeStop)
+
+	log.Printf("pgraft: INFO - Shared-memory rings attached (proposals=%v, applied=%v)",
+		proposalRing != nil, appliedRing != nil)
+	return 0
+}
+
+// Detach the rings, once the drain goroutine has stopped touching the
+// proposal ring
+//
+//export pgraft_go_ring_detach
+func pgraft_go_ring_detach() {
+	ringMutex.Lock()
+	stop, drained := ringStop, ringDrained
+	ringStop, ringDrained = nil, nil
+	proposalRing = nil
+	appliedRing = nil
+	ringMutex.Unlock()
+
+	// Not under ringMutex: a drain blocked in Propose() needs the Ready
+	// loop, which publishes to the applied ring
+	if stop != nil {
+		close(stop)
+	}
+	if drained != nil {
+		<-drained
+	}
+}
+
+// Stop channel of the initialized node, or nil; taken before ringMutex,
+// which is never held while waiting for raftMutex
+func currentNodeStop() <-chan struct{} {
+	if atomic.LoadInt32(&initialized) == 0 {
+		return nil
+	}
+	raftMutex.RLock()
+	defer raftMutex.RUnlock()
+	return stopChan
+}
+
+// Start draining the proposal ring into the node stopped by nodeStop, if
+// a proposal ring is attached and no drain is running; ringMutex must be
+// held
+func startRingDrain(nodeStop <-chan struct{}) {
+	if proposalRing == nil || nodeStop == nil || ringStop != nil {
+		return
+	}
+	ringStop = make(chan struct{})
+	ringDrained = make(chan struct{})
+	ring, notify, stop, drained := proposalRing, ringNotify, ringStop, ringDrained
+	goBackground(func() { drainProposalRing(ring, notify, stop, nodeStop, drained) })
+}
+
+// Resume draining an attached proposal ring into a newly initialized node;
+// raftMutex must be held
+func resumeRingDrain() {
+	ringMutex.Lock()
+	startRingDrain(stopChan)
+	ringMutex.Unlock()
+}
+
+// Wake the drain goroutine after appending to the proposal ring; optional,
+// the ring is also polled
+//
+//export pgraft_go_ring_notify
+func pgraft_go_ring_notify() {
+	ringMutex.Lock()
+	notify := ringNotify
+	ringMutex.Unlock()
+
+	if notify == nil {
+		return
+	}
+	select {
+	case notify <- struct{}{}:
+	default:
+	}
+}
+
+// Number of applied entries dropped because the applied ring was full
+//
+//export pgraft_go_ring_overflows
+func pgraft_go_ring_overflows() C.longlong {
+	return C.longlong(atomic.LoadInt64(&ringOverflows))
+}
+
+// Publish a committed entry to the applied ring, if one is attached
+func publishAppliedEntry(index uint64, term uint64, data []byte) {
+	// Held across the push so that the ring cannot be detached under it
+	ringMutex.Lock()
+	defer ringMutex.Unlock()
+
+	if appliedRing == nil {
+		return
+	}
+	if !appliedRing.push(data, 0, index, term) {
+		atomic.AddInt64(&ringOverflows, 1)
+		debugLog("applied ring full, dropped entry %d", index)
+	}
+}
+
+// Propose the records of the proposal ring until the ring is detached or
+// the node stops.  A record is consumed once proposed; one the node could
+// not take stays in the ring and is retried.
+func drainProposalRing(ring *shmRing, notify <-chan struct{}, stop <-chan struct{}, nodeStop <-chan struct{}, drained chan struct{}) {
+	defer close(drained)
+	log.Printf("pgraft: INFO - Proposal ring drain started")
+
+	for {
+		// Records stay in the ring while proposals are not accepted
+		for proposalGate() == errOK {
+			payload, _, _, _, ok := ring.peek()
+			if !ok {
+				break
+			}
+			if reservedPayload(payload) {
+				ring.skip()
+				atomic.AddInt64(&ringRejected, 1)
+				log.Printf("pgraft: ERROR - Discarding ring record beginning with the prefix reserved for typed entries")
+				continue
+			}
+
+			raftMutex.RLock()
+			node, ctx := raftNode, raftCtx
+			raftMutex.RUnlock()
+			if node == nil {
+				break
+			}
+			if err := node.Propose(ctx, payload); err != nil {
+				log.Printf("pgraft: WARNING - Ring proposal not accepted, retrying: %v", err)
+				recordError(err)
+				break
+			}
+			ring.skip()
+			atomic.AddInt64(&ringProposals, 1)
+		}
+
+		select {
+		case <-stop:
+			log.Printf("pgraft: INFO - Proposal ring drain stopped")
+			return
+		case <-nodeStop:
+			// Resumed by the next initialization if still attached
+			ringMutex.Lock()
+			if ringStop == stop {
+				ringStop, ringDrained = nil, nil
+			}
+			ringMutex.Unlock()
+			log.Printf("pgraft: INFO - Proposal ring drain stopped with the node")
+			return
+		case <-notify:
+		case <-time.After(ringPollInterval):
+		}
+	}
+}
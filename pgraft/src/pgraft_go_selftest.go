@@ -0,0 +1,274 @@
+/*
+ * pgraft_go_selftest.go
+ * Self-test of the library's building blocks
+ *
+ * pgraft_go_selftest() exercises raft storage, message marshalling, the
+ * length-prefixed peer framing over a loopback TCP connection, and the
+ * runtime timers, independently of the running node.  The extension runs
+ * it at startup to fail fast on a broken build or environment, and
+ * support bundles include the JSON report it returns.
+ */
+
+package main
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"runtime"
+	"time"
+
+	"go.etcd.io/raft/v3"
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+type selftestResult struct {
+	Name       string  `json:"name"`
+	Passed     bool    `json:"passed"`
+	DurationMs float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+func runSelftestCheck(name string, check func() error) selftestResult {
+	start := time.Now()
+	err := check()
+	result := selftestResult{
+		Name:       name,
+		Passed:     err == nil,
+		DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Error = err.Error()
+		log.Printf("pgraft: ERROR - Self-test %s failed: %v", name, err)
+	}
+	return result
+}
+
+func selftestEntries() []raftpb.Entry {
+	return []raftpb.Entry{
+		{Index: 1, Term: 1, Type: raftpb.EntryNormal, Data: []byte("selftest-1")},
+		{Index: 2, Term: 1, Type: raftpb.EntryNormal, Data: []byte("selftest-2")},
+		{Index: 3, Term: 2, Type: raftpb.EntryNormal, Data: []byte("selftest-3")},
+	}
+}
+
+// Write entries and hard state to a scratch storage and read them back
+func selftestStorage() error {
+	storage := raft.NewMemoryStorage()
+	entries := selftestEntries()
+
+	if err := storage.Append(entries); err != nil {
+		return fmt.Errorf("append: %v", err)
+	}
+	if err := storage.SetHardState(raftpb.HardState{Term: 2, Vote: 1, Commit: 3}); err != nil {
+		return fmt.Errorf("set hard state: %v", err)
+	}
+
+	read, err := storage.Entries(1, 4, math.MaxUint64)
+	if err != nil {
+		return fmt.Errorf("read entries: %v", err)
+	}
+	if len(read) != len(entries) {
+		return fmt.Errorf("read %d entries, wrote %d", len(read), len(entries))
+	}
+	for i := range entries {
+		if read[i].Index != entries[i].Index || read[i].Term != entries[i].Term ||
+			!bytes.Equal(read[i].Data, entries[i].Data) {
+			return fmt.Errorf("entry %d read back differently", entries[i].Index)
+		}
+	}
+
+	hs, _, err := storage.InitialState()
+	if err != nil {
+		return fmt.Errorf("initial state: %v", err)
+	}
+	if hs.Term != 2 || hs.Vote != 1 || hs.Commit != 3 {
+		return fmt.Errorf("hard state read back as %+v", hs)
+	}
+
+	if _, err := storage.CreateSnapshot(2, &raftpb.ConfState{Voters: []uint64{1}}, nil); err != nil {
+		return fmt.Errorf("create snapshot: %v", err)
+	}
+	if err := storage.Compact(2); err != nil {
+		return fmt.Errorf("compact: %v", err)
+	}
+	if first, _ := storage.FirstIndex(); first != 3 {
+		return fmt.Errorf("first index %d after compaction, expected 3", first)
+	}
+
+	return nil
+}
+
+func selftestMessage() raftpb.Message {
+	return raftpb.Message{
+		Type:    raftpb.MsgApp,
+		To:      2,
+		From:    1,
+		Term:    2,
+		LogTerm: 1,
+		Index:   1,
+		Entries: selftestEntries()[1:],
+		Commit:  2,
+	}
+}
+
+func compareMessages(got, want raftpb.Message) error {
+	if got.Type != want.Type || got.To != want.To || got.From != want.From ||
+		got.Term != want.Term || got.Index != want.Index || got.Commit != want.Commit {
+		return fmt.Errorf("message header mismatch: %s", got.String())
+	}
+	if len(got.Entries) != len(want.Entries) {
+		return fmt.Errorf("message has %d entries, expected %d", len(got.Entries), len(want.Entries))
+	}
+	for i := range want.Entries {
+		if !bytes.Equal(got.Entries[i].Data, want.Entries[i].Data) {
+			return fmt.Errorf("entry %d payload mismatch", want.Entries[i].Index)
+		}
+	}
+	return nil
+}
+
+// Round-trip a raft message through its wire encoding
+func selftestMarshal() error {
+	want := selftestMessage()
+	data, err := want.Marshal()
+	if err != nil {
+		return fmt.Errorf("marshal: %v", err)
+	}
+
+	var got raftpb.Message
+	if err := got.Unmarshal(data); err != nil {
+		return fmt.Errorf("unmarshal: %v", err)
+	}
+	return compareMessages(got, want)
+}
+
+// Send a framed message over a loopback TCP connection, as peers do
+func selftestTransport() error {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("listen: %v", err)
+	}
+	defer listener.Close()
+
+	want := selftestMessage()
+	data, err := want.Marshal()
+	if err != nil {
+		return fmt.Errorf("marshal: %v", err)
+	}
+
+	sendErr := make(chan error, 1)
+	go func() {
+		conn, err := net.DialTimeout("tcp", listener.Addr().String(), time.Second)
+		if err != nil {
+			sendErr <- err
+			return
+		}
+		defer conn.Close()
+		if err := writeUint32(conn, uint32(len(data))); err != nil {
+			sendErr <- err
+			return
+		}
+		_, err = conn.Write(data)
+		sendErr <- err
+	}()
+
+	conn, err := listener.Accept()
+	if err != nil {
+		return fmt.Errorf("accept: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	var msgLen uint32
+	if err := readUint32(conn, &msgLen); err != nil {
+		return fmt.Errorf("read length: %v", err)
+	}
+	if msgLen != uint32(len(data)) {
+		return fmt.Errorf("frame length %d, sent %d", msgLen, len(data))
+	}
+	received := make([]byte, msgLen)
+	if _, err := io.ReadFull(conn, received); err != nil {
+		return fmt.Errorf("read frame: %v", err)
+	}
+	if err := <-sendErr; err != nil {
+		return fmt.Errorf("send: %v", err)
+	}
+
+	var got raftpb.Message
+	if err := got.Unmarshal(received); err != nil {
+		return fmt.Errorf("unmarshal: %v", err)
+	}
+	return compareMessages(got, want)
+}
+
+// Check that tickers fire at roughly the requested rate
+func selftestTimers() error {
+	const (
+		interval = 5 * time.Millisecond
+		ticks    = 5
+	)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	timeout := time.After(time.Second)
+
+	start := time.Now()
+	for i := 0; i < ticks; i++ {
+		select {
+		case <-ticker.C:
+		case <-timeout:
+			return fmt.Errorf("only %d of %d ticks within 1s", i, ticks)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < interval*(ticks-1) {
+		return errors.New("ticker fired faster than requested")
+	}
+	return nil
+}
+
+// Run all self-tests and return a JSON report; free the result with
+// pgraft_go_free_string()
+//
+//export pgraft_go_selftest
+func pgraft_go_selftest() *C.char {
+	start := time.Now()
+	results := []selftestResult{
+		runSelftestCheck("storage", selftestStorage),
+		runSelftestCheck("marshal", selftestMarshal),
+		runSelftestCheck("transport", selftestTransport),
+		runSelftestCheck("timers", selftestTimers),
+	}
+
+	passed := true
+	for _, result := range results {
+		passed = passed && result.Passed
+	}
+
+	report := map[string]interface{}{
+		"passed":      passed,
+		"checks":      results,
+		"duration_ms": float64(time.Since(start).Microseconds()) / 1000,
+		"api_version": fmt.Sprintf("%d.%d", apiVersionMajor, apiVersionMinor),
+		"go_version":  runtime.Version(),
+		"platform":    runtime.GOOS + "/" + runtime.GOARCH,
+	}
+
+	jsonData, err := json.Marshal(report)
+	if err != nil {
+		return C.CString("{\"error\": \"failed to marshal selftest report\"}")
+	}
+
+	log.Printf("pgraft: INFO - Self-test finished, passed=%v", passed)
+	return C.CString(string(jsonData))
+}
@@ -0,0 +1,165 @@
+/*
+ * pgraft_go_sequence.go
+ * Cluster-wide sequences allocated in blocks through the raft log
+ *
+ * A named sequence hands out IDs that are unique across the cluster, in
+ * blocks so that a node only goes through raft once per block rather than
+ * once per ID.  Each allocation is a typed entry; applying it advances the
+ * sequence on every node, so the proposing node gets the first ID of its
+ * block and no other node can be given any ID in it.  IDs start at 1 and
+ * are never handed out twice, even if the proposer times out waiting: the
+ * block is then simply lost.
+ */
+
+package main
+
+/*
+#include <stdint.h>
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+type sequenceEntryBody struct {
+	RequestID uint64 `json:"request_id"`
+	Name      string `json:"name"`
+	Count     uint64 `json:"count"`
+}
+
+var (
+	// Last ID allocated from each sequence, and the index of the entry
+	// that allocated it
+	sequences       = make(map[string]uint64)
+	sequenceIndexes = make(map[string]uint64)
+	sequencesMutex  sync.Mutex
+)
+
+func applySequenceEntry(index, term uint64, body []byte) {
+	var entry sequenceEntryBody
+	if err := json.Unmarshal(body, &entry); err != nil {
+		log.Printf("pgraft: ERROR - Malformed sequence entry at index %d: %v", index, err)
+		return
+	}
+
+	sequencesMutex.Lock()
+	// An entry applied again, as when a restarted node replays its log,
+	// must not allocate its block twice
+	if index <= sequenceIndexes[entry.Name] {
+		sequencesMutex.Unlock()
+		debugLog("sequence: entry %d of %q already applied", index, entry.Name)
+		return
+	}
+	last := sequences[entry.Name]
+	if entry.Count == 0 || last > math.MaxUint64-entry.Count {
+		sequencesMutex.Unlock()
+		log.Printf("pgraft: WARNING - Sequence %q cannot allocate %d IDs at index %d", entry.Name, entry.Count, index)
+		completeTypedWaiter(entry.RequestID, 0, index)
+		return
+	}
+	sequences[entry.Name] = last + entry.Count
+	sequenceIndexes[entry.Name] = index
+	sequencesMutex.Unlock()
+
+	completeTypedWaiterValue(entry.RequestID, typedOutcome{result: 1, index: index, value: last + 1})
+}
+
+func resetSequences() {
+	sequencesMutex.Lock()
+	sequences = make(map[string]uint64)
+	sequenceIndexes = make(map[string]uint64)
+	sequencesMutex.Unlock()
+}
+
+// Last ID of a sequence and the index that allocated it, in a snapshot
+type sequenceSnapshot struct {
+	Last  uint64 `json:"last"`
+	Index uint64 `json:"index"`
+}
+
+// Sequences for a snapshot
+func snapshotSequences() map[string]sequenceSnapshot {
+	sequencesMutex.Lock()
+	defer sequencesMutex.Unlock()
+
+	all := make(map[string]sequenceSnapshot, len(sequences))
+	for name, last := range sequences {
+		all[name] = sequenceSnapshot{Last: last, Index: sequenceIndexes[name]}
+	}
+	return all
+}
+
+// Replace the sequences with those of a snapshot
+func restoreSequences(all map[string]sequenceSnapshot) {
+	sequencesMutex.Lock()
+	defer sequencesMutex.Unlock()
+
+	sequences = make(map[string]uint64, len(all))
+	sequenceIndexes = make(map[string]uint64, len(all))
+	for name, sequence := range all {
+		sequences[name] = sequence.Last
+		sequenceIndexes[name] = sequence.Index
+	}
+}
+
+// Allocate count consecutive IDs from the named sequence, waiting up to
+// timeoutMs; on success *first is the first ID of the block
+//
+//export pgraft_go_sequence_allocate
+func pgraft_go_sequence_allocate(name *C.char, count C.uint64_t, timeoutMs C.int, first *C.uint64_t) C.int {
+	if name == nil || C.GoString(name) == "" || count == 0 || timeoutMs <= 0 || first == nil {
+		return errInvalidArgument
+	}
+
+	body := sequenceEntryBody{
+		RequestID: newReplicatedID(),
+		Name:      C.GoString(name),
+		Count:     uint64(count),
+	}
+	outcome := proposeTypedEntryAndAwait(typedEntrySequence, body.RequestID, body, time.Duration(timeoutMs)*time.Millisecond)
+	switch outcome.result {
+	case 1:
+		*first = C.uint64_t(outcome.value)
+		return errOK
+	case 0:
+		return errFailed
+	}
+	return C.int(outcome.result)
+}
+
+// Last ID allocated from the named sequence as applied on this node, or 0
+//
+//export pgraft_go_sequence_current
+func pgraft_go_sequence_current(name *C.char) C.uint64_t {
+	if name == nil {
+		return 0
+	}
+
+	sequencesMutex.Lock()
+	defer sequencesMutex.Unlock()
+	return C.uint64_t(sequences[C.GoString(name)])
+}
+
+// Last ID allocated from every sequence as a JSON object; free with
+// pgraft_go_free_string()
+//
+//export pgraft_go_sequences
+func pgraft_go_sequences() *C.char {
+	sequencesMutex.Lock()
+	all := make(map[string]uint64, len(sequences))
+	for name, last := range sequences {
+		all[name] = last
+	}
+	sequencesMutex.Unlock()
+
+	jsonData, err := json.Marshal(all)
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(jsonData))
+}
@@ -0,0 +1,211 @@
+/*
+ * pgraft_go_settings.go
+ * Individual setters for settings driven by GUCs
+ *
+ * The extension calls these from its GUC assign hooks.  Each setter
+ * validates the new value against the rest of the active configuration
+ * and records it there.  Settings that can change under a running node
+ * (tick interval, snapshot threshold, log level) take effect at once and
+ * return PGRAFT_SETTING_APPLIED; those fixed when the raft node is created
+ * return PGRAFT_SETTING_RESTART and are listed by
+ * pgraft_go_pending_restart_settings() until the node is next
+ * initialized.  Election and heartbeat timeouts are counted in ticks, so
+ * changing the tick interval scales them as well.
+ */
+
+package main
+
+/*
+#include <stdint.h>
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Setter results, mirrored by PGRAFT_SETTING_* in pgraft_go.h
+const (
+	settingApplied = 0
+	settingRestart = 1
+)
+
+var (
+	// Settings changed since the raft node was created that need a restart
+	pendingRestartSettings = make(map[string]bool)
+	settingsMutex          sync.Mutex
+)
+
+// Validate and store a change to the active configuration; runtime reports
+// whether the running node picks it up
+func changeSetting(name string, runtime bool, change func(cfg *NodeConfig)) C.int {
+	settingsMutex.Lock()
+	defer settingsMutex.Unlock()
+
+	current := activeConfig
+	if current == nil {
+		return errNotInitialized
+	}
+	candidate := *current
+	change(&candidate)
+	if err := validateNodeConfig(&candidate).firstError(); err != nil {
+		log.Printf("pgraft: ERROR - Rejected %s: %v", name, err)
+		return errConfig
+	}
+	change(current)
+
+	if runtime {
+		log.Printf("pgraft: INFO - Setting %s applied", name)
+		return settingApplied
+	}
+	pendingRestartSettings[name] = true
+	log.Printf("pgraft: INFO - Setting %s changed, takes effect after restart", name)
+	return settingRestart
+}
+
+func clearPendingRestartSettings() {
+	settingsMutex.Lock()
+	pendingRestartSettings = make(map[string]bool)
+	settingsMutex.Unlock()
+}
+
+// Set the raft tick interval; applied at runtime
+//
+//export pgraft_go_set_tick_interval
+func pgraft_go_set_tick_interval(intervalMs C.int) C.int {
+	rc := changeSetting("tick_interval_ms", true, func(cfg *NodeConfig) {
+		cfg.Tunables.TickIntervalMs = int(intervalMs)
+	})
+	if rc == settingApplied && raftTicker != nil {
+		raftTicker.Reset(time.Duration(intervalMs) * time.Millisecond)
+	}
+	return rc
+}
+
+// Set the election timeout, rounded to whole ticks; needs a restart
+//
+//export pgraft_go_set_election_timeout
+func pgraft_go_set_election_timeout(timeoutMs C.int) C.int {
+	if timeoutMs <= 0 {
+		return errInvalidArgument
+	}
+	return changeSetting("election_timeout", false, func(cfg *NodeConfig) {
+		cfg.Tunables.ElectionTick = (int(timeoutMs) + cfg.Tunables.TickIntervalMs/2) / cfg.Tunables.TickIntervalMs
+	})
+}
+
+// Set the heartbeat interval, rounded to whole ticks; needs a restart
+//
+//export pgraft_go_set_heartbeat_interval
+func pgraft_go_set_heartbeat_interval(intervalMs C.int) C.int {
+	if intervalMs <= 0 {
+		return errInvalidArgument
+	}
+	return changeSetting("heartbeat_interval", false, func(cfg *NodeConfig) {
+		cfg.Tunables.HeartbeatTick = (int(intervalMs) + cfg.Tunables.TickIntervalMs/2) / cfg.Tunables.TickIntervalMs
+	})
+}
+
+// Set the maximum size of one append message; needs a restart
+//
+//export pgraft_go_set_max_size_per_msg
+func pgraft_go_set_max_size_per_msg(size C.uint64_t) C.int {
+	return changeSetting("max_size_per_msg", false, func(cfg *NodeConfig) {
+		cfg.Tunables.MaxSizePerMsg = uint64(size)
+	})
+}
+
+// Set the number of in-flight append messages; needs a restart
+//
+//export pgraft_go_set_max_inflight_msgs
+func pgraft_go_set_max_inflight_msgs(count C.int) C.int {
+	return changeSetting("max_inflight_msgs", false, func(cfg *NodeConfig) {
+		cfg.Tunables.MaxInflightMsgs = int(count)
+	})
+}
+
+// Enable or disable pre-vote; needs a restart
+//
+//export pgraft_go_set_pre_vote
+func pgraft_go_set_pre_vote(enabled C.int) C.int {
+	return changeSetting("pre_vote", false, func(cfg *NodeConfig) {
+		cfg.Tunables.PreVote = enabled != 0
+	})
+}
+
+// Enable or disable check-quorum; needs a restart
+//
+//export pgraft_go_set_check_quorum
+func pgraft_go_set_check_quorum(enabled C.int) C.int {
+	return changeSetting("check_quorum", false, func(cfg *NodeConfig) {
+		cfg.Tunables.CheckQuorum = enabled != 0
+	})
+}
+
+// Set the number of entries between automatic snapshots (0 disables
+// them); applied at runtime
+//
+//export pgraft_go_set_snapshot_threshold
+func pgraft_go_set_snapshot_threshold(entries C.uint64_t) C.int {
+	rc := changeSetting("snapshot_threshold", true, func(cfg *NodeConfig) {
+		cfg.Tunables.SnapshotThreshold = uint64(entries)
+	})
+	if rc == settingApplied {
+		atomic.StoreUint64(&snapshotThreshold, uint64(entries))
+	}
+	return rc
+}
+
+// Set the minimum severity of Go-side messages (PGRAFT_GO_LOG_*); applied
+// at runtime
+//
+//export pgraft_go_set_log_level
+func pgraft_go_set_log_level(level C.int) C.int {
+	names := map[C.int]string{
+		logLevelDebug:   "debug",
+		logLevelInfo:    "info",
+		logLevelWarning: "warning",
+		logLevelError:   "error",
+	}
+	name, known := names[level]
+	if !known {
+		return errInvalidArgument
+	}
+
+	rc := changeSetting("log_level", true, func(cfg *NodeConfig) {
+		cfg.LogLevel = name
+	})
+	if rc == settingApplied {
+		debugEnabled = level == logLevelDebug
+		logQueueMutex.Lock()
+		logMinLevel = int(level)
+		logQueueMutex.Unlock()
+	}
+	return rc
+}
+
+// Names of the settings changed since the node was created that only
+// take effect after a restart, as a JSON array; free with
+// pgraft_go_free_string()
+//
+//export pgraft_go_pending_restart_settings
+func pgraft_go_pending_restart_settings() *C.char {
+	settingsMutex.Lock()
+	names := make([]string, 0, len(pendingRestartSettings))
+	for name := range pendingRestartSettings {
+		names = append(names, name)
+	}
+	settingsMutex.Unlock()
+
+	sort.Strings(names)
+	jsonData, err := json.Marshal(names)
+	if err != nil {
+		return C.CString("[]")
+	}
+	return C.CString(string(jsonData))
+}
@@ -0,0 +1,271 @@
+/*
+ * pgraft_go_slots.go
+ * Replication of logical slot positions through the raft log
+ *
+ * The extension on the primary reports the restart_lsn and confirmed_flush
+ * of its logical slots with pgraft_go_report_slot().  While this node is
+ * the raft leader, changed positions are proposed as a typed entry at most
+ * once per sync interval, so every member holds the latest position of
+ * every slot.  After failover the new primary reads them back with
+ * pgraft_go_get_slot_positions() and recreates its slots without losing
+ * subscriber progress.  Reports made while not leading are kept and
+ * proposed once this node leads.
+ */
+
+package main
+
+/*
+#include <stdint.h>
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"go.etcd.io/raft/v3"
+)
+
+const defaultSlotSyncInterval = time.Second
+
+type slotPosition struct {
+	Name           string `json:"name"`
+	Plugin         string `json:"plugin"`
+	Database       string `json:"database"`
+	RestartLSN     uint64 `json:"restart_lsn"`
+	ConfirmedFlush uint64 `json:"confirmed_flush"`
+	Origin         uint64 `json:"origin,omitempty"`
+	Index          uint64 `json:"index,omitempty"`
+}
+
+type slotEntryBody struct {
+	Origin  uint64         `json:"origin"`
+	Slots   []slotPosition `json:"slots,omitempty"`
+	Dropped []string       `json:"dropped,omitempty"`
+}
+
+var (
+	// Positions replicated through the log, by slot name
+	replicatedSlots = make(map[string]slotPosition)
+
+	// Local reports not yet proposed
+	slotUpdates = make(map[string]slotPosition)
+	slotDrops   = make(map[string]bool)
+
+	slotSyncInterval = defaultSlotSyncInterval
+	slotSyncedAt     time.Time
+	slotMutex        sync.Mutex
+)
+
+func applySlotEntry(index, term uint64, body []byte) {
+	var entry slotEntryBody
+	if err := json.Unmarshal(body, &entry); err != nil {
+		log.Printf("pgraft: ERROR - Malformed slot entry at index %d: %v", index, err)
+		return
+	}
+
+	slotMutex.Lock()
+	defer slotMutex.Unlock()
+
+	for _, slot := range entry.Slots {
+		slot.Origin = entry.Origin
+		slot.Index = index
+		replicatedSlots[slot.Name] = slot
+	}
+	for _, name := range entry.Dropped {
+		delete(replicatedSlots, name)
+	}
+}
+
+// Propose pending slot reports if this node leads and the sync interval
+// has passed; called on every tick
+func syncSlotPositions() {
+	snapshot := loadStatusSnapshot()
+	if snapshot == nil || snapshot.RaftState != raft.StateLeader {
+		return
+	}
+
+	slotMutex.Lock()
+	if (len(slotUpdates) == 0 && len(slotDrops) == 0) || time.Since(slotSyncedAt) < slotSyncInterval {
+		slotMutex.Unlock()
+		return
+	}
+	body := slotEntryBody{Origin: snapshot.NodeID}
+	for _, slot := range slotUpdates {
+		body.Slots = append(body.Slots, slot)
+	}
+	for name := range slotDrops {
+		body.Dropped = append(body.Dropped, name)
+	}
+	updates, drops := slotUpdates, slotDrops
+	slotUpdates = make(map[string]slotPosition)
+	slotDrops = make(map[string]bool)
+	slotSyncedAt = time.Now()
+	slotMutex.Unlock()
+
+	// Proposing blocks on the raft loop, which the ticker feeds
+	go func() {
+		if rc := proposeTypedEntry(typedEntrySlots, body); rc != errOK {
+			debugLog("slots: proposing %d positions failed (%d), will retry", len(body.Slots), rc)
+			restoreSlotReports(updates, drops)
+		}
+	}()
+}
+
+// Requeue reports whose proposal failed unless newer ones arrived
+func restoreSlotReports(updates map[string]slotPosition, drops map[string]bool) {
+	slotMutex.Lock()
+	defer slotMutex.Unlock()
+
+	for name, slot := range updates {
+		if _, newer := slotUpdates[name]; !newer && !slotDrops[name] {
+			slotUpdates[name] = slot
+		}
+	}
+	for name := range drops {
+		if _, newer := slotUpdates[name]; !newer {
+			slotDrops[name] = true
+		}
+	}
+}
+
+func resetSlots() {
+	slotMutex.Lock()
+	replicatedSlots = make(map[string]slotPosition)
+	slotUpdates = make(map[string]slotPosition)
+	slotDrops = make(map[string]bool)
+	slotSyncInterval = defaultSlotSyncInterval
+	slotSyncedAt = time.Time{}
+	slotMutex.Unlock()
+}
+
+// Replicated slot positions for a snapshot
+func snapshotSlots() map[string]slotPosition {
+	slotMutex.Lock()
+	defer slotMutex.Unlock()
+
+	slots := make(map[string]slotPosition, len(replicatedSlots))
+	for name, slot := range replicatedSlots {
+		slots[name] = slot
+	}
+	return slots
+}
+
+// Replace the replicated slot positions with those of a snapshot; local
+// reports not yet proposed are kept
+func restoreSlots(slots map[string]slotPosition) {
+	slotMutex.Lock()
+	defer slotMutex.Unlock()
+
+	replicatedSlots = make(map[string]slotPosition, len(slots))
+	for name, slot := range slots {
+		replicatedSlots[name] = slot
+	}
+}
+
+// Report the current position of a local logical slot
+//
+//export pgraft_go_report_slot
+func pgraft_go_report_slot(name *C.char, plugin *C.char, database *C.char, restartLSN C.uint64_t, confirmedFlush C.uint64_t) C.int {
+	if name == nil || plugin == nil || database == nil || C.GoString(name) == "" {
+		return errInvalidArgument
+	}
+
+	slot := slotPosition{
+		Name:           C.GoString(name),
+		Plugin:         C.GoString(plugin),
+		Database:       C.GoString(database),
+		RestartLSN:     uint64(restartLSN),
+		ConfirmedFlush: uint64(confirmedFlush),
+	}
+
+	slotMutex.Lock()
+	defer slotMutex.Unlock()
+
+	current, known := replicatedSlots[slot.Name]
+	if known && current.RestartLSN == slot.RestartLSN && current.ConfirmedFlush == slot.ConfirmedFlush &&
+		current.Plugin == slot.Plugin && current.Database == slot.Database {
+		delete(slotUpdates, slot.Name)
+		delete(slotDrops, slot.Name)
+		return errOK
+	}
+	slotUpdates[slot.Name] = slot
+	delete(slotDrops, slot.Name)
+	return errOK
+}
+
+// Report that a local logical slot was dropped
+//
+//export pgraft_go_report_slot_dropped
+func pgraft_go_report_slot_dropped(name *C.char) C.int {
+	if name == nil {
+		return errInvalidArgument
+	}
+
+	slotMutex.Lock()
+	defer slotMutex.Unlock()
+
+	slotDrops[C.GoString(name)] = true
+	delete(slotUpdates, C.GoString(name))
+	return errOK
+}
+
+// Set how often changed slot positions are proposed
+//
+//export pgraft_go_set_slot_sync_interval
+func pgraft_go_set_slot_sync_interval(intervalMs C.int) C.int {
+	if intervalMs <= 0 {
+		return errInvalidArgument
+	}
+
+	slotMutex.Lock()
+	slotSyncInterval = time.Duration(intervalMs) * time.Millisecond
+	slotMutex.Unlock()
+	return errOK
+}
+
+// Replicated position of one slot; returns 1 if known, 0 otherwise
+//
+//export pgraft_go_get_slot_position
+func pgraft_go_get_slot_position(name *C.char, restartLSN *C.uint64_t, confirmedFlush *C.uint64_t) C.int {
+	if name == nil {
+		return errInvalidArgument
+	}
+
+	slotMutex.Lock()
+	slot, known := replicatedSlots[C.GoString(name)]
+	slotMutex.Unlock()
+	if !known {
+		return 0
+	}
+	if restartLSN != nil {
+		*restartLSN = C.uint64_t(slot.RestartLSN)
+	}
+	if confirmedFlush != nil {
+		*confirmedFlush = C.uint64_t(slot.ConfirmedFlush)
+	}
+	return 1
+}
+
+// All replicated slot positions as a JSON array ordered by name; free with
+// pgraft_go_free_string()
+//
+//export pgraft_go_get_slot_positions
+func pgraft_go_get_slot_positions() *C.char {
+	slotMutex.Lock()
+	slots := make([]slotPosition, 0, len(replicatedSlots))
+	for _, slot := range replicatedSlots {
+		slots = append(slots, slot)
+	}
+	slotMutex.Unlock()
+
+	sort.Slice(slots, func(i, j int) bool { return slots[i].Name < slots[j].Name })
+	jsonData, err := json.Marshal(slots)
+	if err != nil {
+		return C.CString("[]")
+	}
+	return C.CString(string(jsonData))
+}
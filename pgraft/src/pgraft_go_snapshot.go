@@ -0,0 +1,103 @@
+/*
+ * pgraft_go_snapshot.go
+ * State of the replicated services carried in raft snapshots
+ *
+ * A snapshot stands for every entry up to its index: once the log is
+ * compacted, a follower too far behind to be sent the entries is sent the
+ * leader's snapshot instead.  Its data is therefore the state of the
+ * replicated services as of that index (locks, KV, sequences, tags,
+ * maintenance, parameter changes and slot positions), JSON-encoded, and a
+ * follower installing it replaces its own tables with it.  Payloads of the
+ * extension are not part of it; compaction never passes the index the
+ * extension has made durable, or archived when archiving is enabled.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+// Version of the snapshot data; a snapshot of another version is not
+// restored
+const serviceSnapshotVersion = 1
+
+type serviceSnapshot struct {
+	Version     int                          `json:"version"`
+	Locks       map[string]lockState         `json:"locks,omitempty"`
+	KV          map[string]kvValue           `json:"kv,omitempty"`
+	Sequences   map[string]sequenceSnapshot  `json:"sequences,omitempty"`
+	Tags        map[uint64]map[string]string `json:"tags,omitempty"`
+	Maintenance maintenanceState             `json:"maintenance"`
+	Parameters  parameterSnapshot            `json:"parameters"`
+	Slots       map[string]slotPosition      `json:"slots,omitempty"`
+}
+
+// Held while committed entries are applied, so that a snapshot sees the
+// services exactly as of appliedIndex
+var applyMutex sync.Mutex
+
+// Snapshot of the services at the applied index, stored in storage
+func createServiceSnapshot(storage Storage) (raftpb.Snapshot, error) {
+	applyMutex.Lock()
+	defer applyMutex.Unlock()
+
+	data, err := json.Marshal(serviceSnapshot{
+		Version:     serviceSnapshotVersion,
+		Locks:       snapshotLocks(),
+		KV:          snapshotKV(),
+		Sequences:   snapshotSequences(),
+		Tags:        snapshotTags(),
+		Maintenance: maintenanceStats(),
+		Parameters:  snapshotParameters(),
+		Slots:       snapshotSlots(),
+	})
+	if err != nil {
+		return raftpb.Snapshot{}, err
+	}
+	return storage.CreateSnapshot(atomic.LoadUint64(&appliedIndex), &raftpb.ConfState{
+		Voters: getClusterNodes(),
+	}, data)
+}
+
+// Replace the services with the state in a snapshot installed in storage,
+// and continue applying after its index
+func restoreServiceSnapshot(snapshot raftpb.Snapshot) error {
+	var state serviceSnapshot
+	if err := json.Unmarshal(snapshot.Data, &state); err != nil {
+		return fmt.Errorf("malformed snapshot data at index %d: %v", snapshot.Metadata.Index, err)
+	}
+	if state.Version != serviceSnapshotVersion {
+		return fmt.Errorf("snapshot at index %d has data version %d, not %d",
+			snapshot.Metadata.Index, state.Version, serviceSnapshotVersion)
+	}
+
+	applyMutex.Lock()
+	defer applyMutex.Unlock()
+
+	restoreLocks(state.Locks)
+	restoreKV(state.KV)
+	restoreSequences(state.Sequences)
+	restoreTags(state.Tags)
+	restoreMaintenance(state.Maintenance)
+	restoreParameters(state.Parameters, atomic.LoadUint64(&servicesApplied))
+	restoreSlots(state.Slots)
+
+	atomic.StoreUint64(&servicesApplied, snapshot.Metadata.Index)
+	atomic.StoreUint64(&appliedIndex, snapshot.Metadata.Index)
+	committedIndex = snapshot.Metadata.Index
+
+	replicationState.replicationMutex.Lock()
+	replicationState.lastSnapshotIndex = snapshot.Metadata.Index
+	replicationState.lastAppliedIndex = snapshot.Metadata.Index
+	replicationState.replicationMutex.Unlock()
+
+	log.Printf("pgraft: INFO - Restored the replicated services from the snapshot at index %d", snapshot.Metadata.Index)
+	return nil
+}
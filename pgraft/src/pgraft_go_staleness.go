@@ -0,0 +1,212 @@
+/*
+ * pgraft_go_staleness.go
+ * Per-node staleness for read routing
+ *
+ * Poolers and proxies that send reads to standbys need to know how far
+ * behind each one is.  On the leader, every voter's match index is
+ * compared with the commit index: the difference is how many entries the
+ * node is behind, and the time the commit index first passed its match
+ * index, taken from samples recorded on every tick, estimates how many
+ * seconds behind it is.  Other nodes can only report themselves, as the
+ * distance between their applied and commit indexes.  The extension adds
+ * each node's WAL replay LSN with pgraft_go_set_node_replay_lsn(), so
+ * routers can also compare positions in PostgreSQL terms.
+ */
+
+package main
+
+/*
+#include <stdint.h>
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"go.etcd.io/raft/v3"
+	"go.etcd.io/raft/v3/tracker"
+)
+
+// Number of commit index samples kept for estimating lag in seconds
+const commitSampleLimit = 4096
+
+type commitSample struct {
+	index uint64
+	at    time.Time
+}
+
+type nodeStaleness struct {
+	Node           uint64  `json:"node"`
+	Leader         bool    `json:"leader"`
+	MatchIndex     uint64  `json:"match_index"`
+	EntriesBehind  uint64  `json:"entries_behind"`
+	SecondsBehind  float64 `json:"seconds_behind"`
+	State          string  `json:"state,omitempty"`
+	RecentActive   bool    `json:"recent_active"`
+	ReplayLSN      uint64  `json:"replay_lsn,omitempty"`
+	ReplayLSNAgeMs int64   `json:"replay_lsn_age_ms,omitempty"`
+	ReplayLSNKnown bool    `json:"replay_lsn_known"`
+}
+
+var (
+	// Times at which the commit index advanced, oldest first
+	commitSamples []commitSample
+
+	// WAL replay LSNs reported by the extension, by node
+	nodeReplayLSNs = make(map[uint64]nodeLSNReport)
+
+	stalenessMutex sync.Mutex
+)
+
+// Record the commit index if it advanced; called on every tick
+func recordCommitSample() {
+	snapshot := loadStatusSnapshot()
+	if snapshot == nil {
+		return
+	}
+
+	stalenessMutex.Lock()
+	defer stalenessMutex.Unlock()
+
+	if n := len(commitSamples); n > 0 && commitSamples[n-1].index >= snapshot.CommitIndex {
+		return
+	}
+	commitSamples = append(commitSamples, commitSample{index: snapshot.CommitIndex, at: snapshot.UpdatedAt})
+	if len(commitSamples) > commitSampleLimit {
+		commitSamples = commitSamples[len(commitSamples)-commitSampleLimit:]
+	}
+}
+
+// Seconds since the commit index first passed index, 0 if it has not;
+// stalenessMutex must be held
+func secondsBehind(index uint64, now time.Time) float64 {
+	i := sort.Search(len(commitSamples), func(i int) bool {
+		return commitSamples[i].index > index
+	})
+	if i == len(commitSamples) {
+		return 0
+	}
+	return now.Sub(commitSamples[i].at).Seconds()
+}
+
+func resetStaleness() {
+	stalenessMutex.Lock()
+	commitSamples = nil
+	nodeReplayLSNs = make(map[uint64]nodeLSNReport)
+	stalenessMutex.Unlock()
+}
+
+// Staleness of every node this node can judge: all voters on the leader,
+// only itself elsewhere
+func collectStaleness() (raft.Status, []nodeStaleness) {
+	var status raft.Status
+	if node := raftNode; node != nil {
+		status = node.Status()
+	}
+	now := time.Now()
+
+	stalenessMutex.Lock()
+	defer stalenessMutex.Unlock()
+
+	var nodes []nodeStaleness
+	add := func(id, match uint64, progress *tracker.Progress) {
+		entry := nodeStaleness{
+			Node:       id,
+			Leader:     id == status.Lead,
+			MatchIndex: match,
+		}
+		if match < status.Commit {
+			entry.EntriesBehind = status.Commit - match
+			entry.SecondsBehind = secondsBehind(match, now)
+		}
+		if progress != nil {
+			entry.State = progress.State.String()
+			entry.RecentActive = progress.RecentActive || id == status.ID
+		}
+		if report, known := nodeReplayLSNs[id]; known {
+			entry.ReplayLSN = report.lsn
+			entry.ReplayLSNAgeMs = now.Sub(report.reportedAt).Milliseconds()
+			entry.ReplayLSNKnown = true
+		}
+		nodes = append(nodes, entry)
+	}
+
+	if status.RaftState == raft.StateLeader {
+		for id, progress := range status.Progress {
+			add(id, progress.Match, &progress)
+		}
+	} else if status.ID != 0 {
+		add(status.ID, status.Applied, nil)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Node < nodes[j].Node })
+	return status, nodes
+}
+
+// Record a node's WAL replay LSN, including this node's own
+//
+//export pgraft_go_set_node_replay_lsn
+func pgraft_go_set_node_replay_lsn(nodeID C.uint64_t, lsn C.uint64_t) C.int {
+	if nodeID == 0 {
+		return errInvalidArgument
+	}
+
+	stalenessMutex.Lock()
+	nodeReplayLSNs[uint64(nodeID)] = nodeLSNReport{lsn: uint64(lsn), reportedAt: time.Now()}
+	stalenessMutex.Unlock()
+	return errOK
+}
+
+// Entries and milliseconds node is behind; on followers only this node
+// can be queried
+//
+//export pgraft_go_node_staleness
+func pgraft_go_node_staleness(nodeID C.uint64_t, entries *C.uint64_t, behindMs *C.uint64_t) C.int {
+	status, nodes := collectStaleness()
+	if status.ID == 0 {
+		return errNotRunning
+	}
+	for _, node := range nodes {
+		if node.Node != uint64(nodeID) {
+			continue
+		}
+		if entries != nil {
+			*entries = C.uint64_t(node.EntriesBehind)
+		}
+		if behindMs != nil {
+			*behindMs = C.uint64_t(node.SecondsBehind * 1000)
+		}
+		return errOK
+	}
+	if status.RaftState != raft.StateLeader {
+		return errNotLeader
+	}
+	return errNotFound
+}
+
+// Staleness of every node this node can judge as JSON; free with
+// pgraft_go_free_string()
+//
+//export pgraft_go_get_staleness
+func pgraft_go_get_staleness() *C.char {
+	status, nodes := collectStaleness()
+	if nodes == nil {
+		nodes = []nodeStaleness{}
+	}
+
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"node_id":      status.ID,
+		"leader_id":    status.Lead,
+		"is_leader":    status.RaftState == raft.StateLeader,
+		"term":         status.Term,
+		"commit_index": status.Commit,
+		"nodes":        nodes,
+		"generated_at": time.Now().UTC().Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		return C.CString("{\"error\": \"failed to marshal staleness\"}")
+	}
+	return C.CString(string(jsonData))
+}
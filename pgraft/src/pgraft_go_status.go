@@ -0,0 +1,100 @@
+/*
+ * pgraft_go_status.go
+ * Lock-free cached status snapshot for hot read paths
+ *
+ * The Ready loop publishes an immutable snapshot of the node status after
+ * each batch it processes.  get_leader, get_term and is_leader read the
+ * latest snapshot with a single atomic load instead of taking raftMutex
+ * and calling raftNode.Status(), so they are cheap enough to call per
+ * query.  pgraft_go_get_summary returns the whole snapshot in one call for
+ * monitoring ticks.
+ */
+
+package main
+
+/*
+#include <stdint.h>
+
+typedef struct pgraft_go_summary
+{
+	uint64_t	node_id;
+	uint64_t	leader_id;
+	uint64_t	term;
+	int32_t		role;
+	uint64_t	commit_index;
+	uint64_t	applied_index;
+} pgraft_go_summary;
+*/
+import "C"
+
+import (
+	"sync/atomic"
+	"time"
+
+	"go.etcd.io/raft/v3"
+)
+
+// statusSnapshot is never modified after it has been published
+type statusSnapshot struct {
+	NodeID       uint64
+	LeaderID     uint64
+	Term         uint64
+	RaftState    raft.StateType
+	CommitIndex  uint64
+	AppliedIndex uint64
+	UpdatedAt    time.Time
+}
+
+var cachedStatus atomic.Pointer[statusSnapshot]
+
+// Capture the current node status and make it visible to readers
+func publishStatusSnapshot() {
+	if raftNode == nil {
+		return
+	}
+
+	status := raftNode.Status()
+	cachedStatus.Store(&statusSnapshot{
+		NodeID:       status.ID,
+		LeaderID:     status.Lead,
+		Term:         status.Term,
+		RaftState:    status.RaftState,
+		CommitIndex:  status.Commit,
+		AppliedIndex: status.Applied,
+		UpdatedAt:    time.Now(),
+	})
+}
+
+// Latest published snapshot, or nil before the first publish
+func loadStatusSnapshot() *statusSnapshot {
+	return cachedStatus.Load()
+}
+
+// Role reported when the node is not running; otherwise the role is the
+// raft.StateType value (PGRAFT_ROLE_* in pgraft_go.h)
+const roleStopped = -1
+
+// Fill *summary from the cached snapshot; returns -1 if not running
+//
+//export pgraft_go_get_summary
+func pgraft_go_get_summary(summary *C.pgraft_go_summary) C.int {
+	if summary == nil {
+		return -1
+	}
+
+	snapshot := loadStatusSnapshot()
+	if atomic.LoadInt32(&running) == 0 || snapshot == nil {
+		*summary = C.pgraft_go_summary{role: roleStopped}
+		return -1
+	}
+
+	*summary = C.pgraft_go_summary{
+		node_id:       C.uint64_t(snapshot.NodeID),
+		leader_id:     C.uint64_t(snapshot.LeaderID),
+		term:          C.uint64_t(snapshot.Term),
+		role:          C.int32_t(snapshot.RaftState),
+		commit_index:  C.uint64_t(snapshot.CommitIndex),
+		applied_index: C.uint64_t(snapshot.AppliedIndex),
+	}
+	return 0
+}
@@ -0,0 +1,33 @@
+/*
+ * pgraft_go_storage.go
+ * Storage of the raft log, HardState and snapshots
+ *
+ * The node keeps its log in whatever newStorage() returns, which is an
+ * etcd raft MemoryStorage unless a test replaced it, for example with
+ * one that fails on demand.  Only the operations the library performs
+ * are part of the interface.  pgraft/raftio declares the same interface,
+ * with in-memory implementations for consumers; the two must change
+ * together.
+ */
+
+package main
+
+import (
+	"go.etcd.io/raft/v3"
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+type Storage interface {
+	raft.Storage
+
+	Append(entries []raftpb.Entry) error
+	SetHardState(st raftpb.HardState) error
+	ApplySnapshot(snap raftpb.Snapshot) error
+	CreateSnapshot(index uint64, cs *raftpb.ConfState, data []byte) (raftpb.Snapshot, error)
+	Compact(compactIndex uint64) error
+}
+
+// Storage for a node starting with an empty log
+var newStorage = func() Storage {
+	return raft.NewMemoryStorage()
+}
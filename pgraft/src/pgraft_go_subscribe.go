@@ -0,0 +1,327 @@
+/*
+ * pgraft_go_subscribe.go
+ * Local Unix socket streaming raft events to subscribers
+ *
+ * ramd runs outside the backend that loads this library, so it cannot use
+ * the shared-memory event queue.  pgraft_go_start_event_socket() listens
+ * on a Unix socket where local processes receive every event raised with
+ * emitRaftEvent() as newline-delimited JSON, instead of polling the
+ * exports.  A subscriber may send a line {"subscribe": ["leader", ...]}
+ * at any time to choose the event types it receives; committed events are
+ * only sent to subscribers asking for them, since there is one per entry.
+ * A subscriber that falls subscriberBacklog events behind is disconnected
+ * and has to reconnect and resynchronize from the exports.
+ *
+ * While this node leads, the ticker also raises health events when a
+ * voter starts or stops replicating, with the node ID as index and
+ * "healthy" or "unhealthy" as payload.
+ */
+
+package main
+
+/*
+#include <stdint.h>
+*/
+import "C"
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"go.etcd.io/raft/v3"
+	"go.etcd.io/raft/v3/tracker"
+)
+
+// Events queued for a subscriber before it is disconnected
+const subscriberBacklog = 1024
+
+// Names of event types on the socket and in subscribe requests
+var eventTypeNames = map[uint32]string{
+	eventCommitted:    "committed",
+	eventLeader:       "leader",
+	eventState:        "state",
+	eventMembership:   "membership",
+	eventCompletion:   "completion",
+	eventSyncStandbys: "sync_standbys",
+	eventRole:         "role",
+	eventHealth:       "health",
+}
+
+type socketEvent struct {
+	Seq     uint64 `json:"seq"`
+	Type    string `json:"type"`
+	Index   uint64 `json:"index"`
+	Term    uint64 `json:"term"`
+	Payload string `json:"payload,omitempty"`
+	Time    string `json:"time"`
+}
+
+type subscribeRequest struct {
+	Subscribe []string `json:"subscribe"`
+}
+
+type eventSubscriber struct {
+	conn   net.Conn
+	events chan []byte
+	types  map[string]bool
+}
+
+var (
+	eventListener    net.Listener
+	eventSocketPath  string
+	eventSubscribers = make(map[*eventSubscriber]bool)
+	eventSeq         uint64
+	subscriberMutex  sync.Mutex
+	subscriberWG     sync.WaitGroup
+
+	// Voter health last reported by the leader
+	reportedHealth = make(map[uint64]bool)
+)
+
+// Subscribers receive every type except committed until they choose
+func defaultSubscription() map[string]bool {
+	types := make(map[string]bool, len(eventTypeNames))
+	for eventType, name := range eventTypeNames {
+		if eventType != eventCommitted {
+			types[name] = true
+		}
+	}
+	return types
+}
+
+// Send an event to the subscribers that asked for its type
+func publishToSubscribers(eventType uint32, index, term uint64, payload []byte) {
+	subscriberMutex.Lock()
+	defer subscriberMutex.Unlock()
+
+	if len(eventSubscribers) == 0 {
+		return
+	}
+	name := eventTypeNames[eventType]
+	eventSeq++
+	line, err := json.Marshal(socketEvent{
+		Seq:     eventSeq,
+		Type:    name,
+		Index:   index,
+		Term:    term,
+		Payload: string(payload),
+		Time:    time.Now().UTC().Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	for sub := range eventSubscribers {
+		if !sub.types[name] {
+			continue
+		}
+		select {
+		case sub.events <- line:
+		default:
+			log.Printf("pgraft: WARNING - Event subscriber fell behind, disconnecting")
+			dropSubscriber(sub)
+		}
+	}
+}
+
+// Forget a subscriber and close its connection; subscriberMutex must be
+// held
+func dropSubscriber(sub *eventSubscriber) {
+	if !eventSubscribers[sub] {
+		return
+	}
+	delete(eventSubscribers, sub)
+	close(sub.events)
+	sub.conn.Close()
+}
+
+// Write queued events to a subscriber until it goes away
+func serveSubscriber(sub *eventSubscriber) {
+	defer subscriberWG.Done()
+
+	for line := range sub.events {
+		if _, err := sub.conn.Write(line); err != nil {
+			break
+		}
+	}
+	subscriberMutex.Lock()
+	dropSubscriber(sub)
+	subscriberMutex.Unlock()
+}
+
+// Apply subscribe requests sent by a subscriber
+func readSubscriptions(sub *eventSubscriber) {
+	defer subscriberWG.Done()
+
+	scanner := bufio.NewScanner(sub.conn)
+	for scanner.Scan() {
+		var request subscribeRequest
+		if err := json.Unmarshal(scanner.Bytes(), &request); err != nil {
+			debugLog("subscribe: ignoring malformed request: %v", err)
+			continue
+		}
+		types := make(map[string]bool, len(request.Subscribe))
+		for _, name := range request.Subscribe {
+			types[name] = true
+		}
+		subscriberMutex.Lock()
+		sub.types = types
+		subscriberMutex.Unlock()
+	}
+
+	subscriberMutex.Lock()
+	dropSubscriber(sub)
+	subscriberMutex.Unlock()
+}
+
+func acceptSubscribers(listener net.Listener) {
+	defer subscriberWG.Done()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		sub := &eventSubscriber{
+			conn:   conn,
+			events: make(chan []byte, subscriberBacklog),
+			types:  defaultSubscription(),
+		}
+		subscriberMutex.Lock()
+		eventSubscribers[sub] = true
+		subscriberMutex.Unlock()
+
+		subscriberWG.Add(2)
+		go serveSubscriber(sub)
+		go readSubscriptions(sub)
+	}
+}
+
+// Raise health events for voters whose replication state changed; called
+// on every tick
+func publishHealthTransitions() {
+	snapshot := loadStatusSnapshot()
+	node := raftNode
+	if snapshot == nil || snapshot.RaftState != raft.StateLeader || node == nil {
+		subscriberMutex.Lock()
+		reportedHealth = make(map[uint64]bool)
+		subscriberMutex.Unlock()
+		return
+	}
+	status := node.Status()
+
+	type transition struct {
+		node    uint64
+		healthy bool
+	}
+	var changes []transition
+	subscriberMutex.Lock()
+	for id := range status.Config.Voters.IDs() {
+		if id == status.ID {
+			continue
+		}
+		pr, tracked := status.Progress[id]
+		healthy := tracked && pr.RecentActive && pr.State == tracker.StateReplicate
+		if previous, known := reportedHealth[id]; !known || previous != healthy {
+			reportedHealth[id] = healthy
+			changes = append(changes, transition{node: id, healthy: healthy})
+		}
+	}
+	subscriberMutex.Unlock()
+
+	for _, change := range changes {
+		payload := "unhealthy"
+		if change.healthy {
+			payload = "healthy"
+		}
+		emitRaftEvent(eventHealth, change.node, status.Term, []byte(payload))
+	}
+}
+
+// Close the socket, disconnect subscribers and wait for their goroutines
+func stopEventSocket() {
+	subscriberMutex.Lock()
+	listener, path := eventListener, eventSocketPath
+	eventListener = nil
+	eventSocketPath = ""
+	for sub := range eventSubscribers {
+		dropSubscriber(sub)
+	}
+	subscriberMutex.Unlock()
+
+	if listener != nil {
+		listener.Close()
+		os.Remove(path)
+	}
+	subscriberWG.Wait()
+}
+
+func resetEventSocket() {
+	stopEventSocket()
+
+	subscriberMutex.Lock()
+	eventSeq = 0
+	reportedHealth = make(map[uint64]bool)
+	subscriberMutex.Unlock()
+}
+
+// Listen for event subscribers on a Unix socket at path, replacing a stale
+// socket left by a previous run
+//
+//export pgraft_go_start_event_socket
+func pgraft_go_start_event_socket(path *C.char) C.int {
+	if path == nil || C.GoString(path) == "" {
+		return errInvalidArgument
+	}
+	socketPath := C.GoString(path)
+
+	subscriberMutex.Lock()
+	defer subscriberMutex.Unlock()
+
+	if eventListener != nil {
+		log.Printf("pgraft: WARNING - Event socket already listening on %s", eventSocketPath)
+		return errAlreadyInitialized
+	}
+	if info, err := os.Lstat(socketPath); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(socketPath)
+	}
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		log.Printf("pgraft: ERROR - Cannot listen on event socket %s: %v", socketPath, err)
+		return errNetwork
+	}
+	if err := os.Chmod(socketPath, 0600); err != nil {
+		listener.Close()
+		log.Printf("pgraft: ERROR - Cannot restrict event socket %s: %v", socketPath, err)
+		return errNetwork
+	}
+
+	eventListener = listener
+	eventSocketPath = socketPath
+	subscriberWG.Add(1)
+	go acceptSubscribers(listener)
+	log.Printf("pgraft: INFO - Event socket listening on %s", socketPath)
+	return errOK
+}
+
+// Stop the event socket and disconnect all subscribers
+//
+//export pgraft_go_stop_event_socket
+func pgraft_go_stop_event_socket() {
+	stopEventSocket()
+}
+
+// Number of connected event subscribers
+//
+//export pgraft_go_event_subscribers
+func pgraft_go_event_subscribers() C.int {
+	subscriberMutex.Lock()
+	defer subscriberMutex.Unlock()
+	return C.int(len(eventSubscribers))
+}
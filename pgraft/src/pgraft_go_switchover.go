@@ -0,0 +1,206 @@
+/*
+ * pgraft_go_switchover.go
+ * Coordinated leadership switchover
+ *
+ * pgraft_go_switchover() runs the whole planned handover on the current
+ * leader: it pauses new proposals, waits until the target's log matches
+ * the leader's, transfers leadership, and waits until the target is seen
+ * as leader.  Proposals are resumed whatever the outcome.  The result is a
+ * JSON report listing each step, so ramd does not need to sequence the
+ * steps itself.  While paused, proposals fail with PGRAFT_ERR_PAUSED and
+ * the shared proposal ring is left untouched.
+ */
+
+package main
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.etcd.io/raft/v3"
+)
+
+// How often switchover re-checks raft status while waiting
+const switchoverPollInterval = 10 * time.Millisecond
+
+var (
+	proposalsPausedFlag int32
+
+	// Only one switchover may run at a time
+	switchoverMutex sync.Mutex
+)
+
+func proposalsPaused() bool {
+	return atomic.LoadInt32(&proposalsPausedFlag) == 1
+}
+
+// errOK if new proposals may be accepted, otherwise the reason they are not
+func proposalGate() C.int {
+	if proposalsPaused() {
+		return errPaused
+	}
+	if fencingPending() {
+		return errFencing
+	}
+	return errOK
+}
+
+type switchoverStep struct {
+	Step       string  `json:"step"`
+	OK         bool    `json:"ok"`
+	DurationMs float64 `json:"duration_ms"`
+	Detail     string  `json:"detail,omitempty"`
+}
+
+type switchoverResult struct {
+	Success   bool             `json:"success"`
+	From      uint64           `json:"from"`
+	Target    uint64           `json:"target"`
+	NewLeader uint64           `json:"new_leader"`
+	Term      uint64           `json:"term"`
+	Steps     []switchoverStep `json:"steps"`
+	Error     string           `json:"error,omitempty"`
+}
+
+// Run one step, recording its outcome; returns false if it failed
+func (r *switchoverResult) run(name string, step func() (string, error)) bool {
+	start := time.Now()
+	detail, err := step()
+	entry := switchoverStep{
+		Step:       name,
+		OK:         err == nil,
+		DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+		Detail:     detail,
+	}
+	if err != nil {
+		entry.Detail = err.Error()
+		r.Error = fmt.Sprintf("%s: %v", name, err)
+	}
+	r.Steps = append(r.Steps, entry)
+	return err == nil
+}
+
+// Poll raft status until cond holds or the deadline passes
+func waitForStatus(node raft.Node, deadline time.Time, cond func(raft.Status) bool) (raft.Status, bool) {
+	for {
+		status := node.Status()
+		if cond(status) {
+			return status, true
+		}
+		if time.Now().After(deadline) {
+			return status, false
+		}
+		time.Sleep(switchoverPollInterval)
+	}
+}
+
+func switchover(target uint64, timeout time.Duration) *switchoverResult {
+	result := &switchoverResult{Target: target}
+	deadline := time.Now().Add(timeout)
+
+	node := raftNode
+	if node == nil || atomic.LoadInt32(&running) == 0 {
+		result.Error = "node is not running"
+		return result
+	}
+
+	status := node.Status()
+	result.From = status.ID
+	result.Term = status.Term
+
+	ok := result.run("check", func() (string, error) {
+		if status.RaftState != raft.StateLeader {
+			return "", fmt.Errorf("node %d is not the leader", status.ID)
+		}
+		if target == status.ID {
+			return "", fmt.Errorf("node %d is already the leader", target)
+		}
+		if _, isVoter := status.Config.Voters.IDs()[target]; !isVoter {
+			return "", fmt.Errorf("node %d is not a voter", target)
+		}
+		return "", nil
+	})
+	if !ok {
+		return result
+	}
+
+	atomic.StoreInt32(&proposalsPausedFlag, 1)
+	defer atomic.StoreInt32(&proposalsPausedFlag, 0)
+	result.run("pause_proposals", func() (string, error) {
+		return "new proposals rejected", nil
+	})
+
+	ok = result.run("catch_up", func() (string, error) {
+		lastIndex, err := raftStorage.LastIndex()
+		if err != nil {
+			return "", err
+		}
+		final, caughtUp := waitForStatus(node, deadline, func(s raft.Status) bool {
+			return s.Progress[target].Match >= lastIndex
+		})
+		match := final.Progress[target].Match
+		if !caughtUp {
+			return "", fmt.Errorf("target at index %d, leader at %d", match, lastIndex)
+		}
+		return fmt.Sprintf("target matched index %d", match), nil
+	})
+	if !ok {
+		return result
+	}
+
+	result.run("transfer", func() (string, error) {
+		node.TransferLeadership(raftCtx, status.ID, target)
+		return fmt.Sprintf("leadership transfer to %d requested", target), nil
+	})
+
+	result.run("confirm", func() (string, error) {
+		final, confirmed := waitForStatus(node, deadline, func(s raft.Status) bool {
+			return s.Lead == target
+		})
+		result.NewLeader = final.Lead
+		result.Term = final.Term
+		if !confirmed {
+			return "", fmt.Errorf("leader is %d, expected %d", final.Lead, target)
+		}
+		result.Success = true
+		return fmt.Sprintf("node %d leads term %d", final.Lead, final.Term), nil
+	})
+
+	return result
+}
+
+// Hand leadership to targetNode within timeoutMs and return a JSON report;
+// free it with pgraft_go_free_string()
+//
+//export pgraft_go_switchover
+func pgraft_go_switchover(targetNode C.int, timeoutMs C.int) *C.char {
+	if targetNode <= 0 || timeoutMs <= 0 {
+		return C.CString("{\"success\": false, \"error\": \"invalid target or timeout\"}")
+	}
+
+	switchoverMutex.Lock()
+	defer switchoverMutex.Unlock()
+
+	log.Printf("pgraft: INFO - Switchover to node %d requested (timeout %dms)", int(targetNode), int(timeoutMs))
+	result := switchover(uint64(targetNode), time.Duration(timeoutMs)*time.Millisecond)
+	if result.Success {
+		log.Printf("pgraft: INFO - Switchover to node %d completed", result.Target)
+	} else {
+		log.Printf("pgraft: ERROR - Switchover to node %d failed: %s", result.Target, result.Error)
+	}
+
+	jsonData, err := json.Marshal(result)
+	if err != nil {
+		return C.CString("{\"success\": false, \"error\": \"failed to marshal switchover result\"}")
+	}
+	return C.CString(string(jsonData))
+}
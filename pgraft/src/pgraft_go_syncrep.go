@@ -0,0 +1,218 @@
+/*
+ * pgraft_go_syncrep.go
+ * synchronous_standby_names derived from raft membership
+ *
+ * On the leader, the synchronous standby set is recomputed from the raft
+ * voters and their replication progress so that a PostgreSQL commit is
+ * acknowledged by the same quorum that raft requires: with N voters the
+ * leader waits for ANY floor(N/2) standbys.  Healthy followers (recently
+ * active, connected, not receiving a snapshot) are listed first; if fewer
+ * healthy followers than the quorum remain, every voter is listed so that
+ * commits block instead of silently losing durability.  Followers tagged
+ * "nosync" are treated like unhealthy ones.  Followers report an empty
+ * setting.
+ *
+ * Standbys are named after their node, "pgraft_node_<id>", unless the
+ * extension registers their application_name.  Changes raise
+ * PGRAFT_EVENT_SYNC_STANDBYS and are delivered to the registered callback
+ * by pgraft_go_drain_sync_standbys() on the calling thread; the current
+ * value can also be read with pgraft_go_get_sync_standby_names().
+ */
+
+package main
+
+/*
+#include <stdlib.h>
+
+typedef void (*pgraft_go_sync_standby_callback) (const char *standby_names, void *arg);
+
+static inline void
+pgraft_go_call_sync_standby_callback(pgraft_go_sync_standby_callback cb, const char *standby_names, void *arg)
+{
+	cb(standby_names, arg);
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"go.etcd.io/raft/v3"
+	"go.etcd.io/raft/v3/tracker"
+)
+
+// Minimum interval between recomputations from the ticker
+const syncStandbyRefreshInterval = time.Second
+
+var (
+	standbyNames = make(map[uint64]string)
+
+	syncStandbyValue     string
+	syncStandbyDelivered string
+	syncStandbyRefreshed time.Time
+	syncStandbyCallback  C.pgraft_go_sync_standby_callback
+	syncStandbyArg       unsafe.Pointer
+	syncStandbyMutex     sync.Mutex
+)
+
+func standbyName(nodeID uint64) string {
+	if name, exists := standbyNames[nodeID]; exists {
+		return name
+	}
+	return fmt.Sprintf("pgraft_node_%d", nodeID)
+}
+
+// Compute synchronous_standby_names from a leader's raft status;
+// syncStandbyMutex must be held
+func computeSyncStandbyNames(status raft.Status) string {
+	if status.RaftState != raft.StateLeader {
+		return ""
+	}
+
+	voters := status.Config.Voters.IDs()
+	quorum := len(voters) / 2
+	if quorum == 0 {
+		return ""
+	}
+
+	var healthy, unhealthy []uint64
+	for id := range voters {
+		if id == status.ID {
+			continue
+		}
+		pr, tracked := status.Progress[id]
+		connected := peerTransport.Connected(id)
+		if tracked && connected && pr.RecentActive && pr.State != tracker.StateSnapshot && !nodeHasTag(id, tagNoSync) {
+			healthy = append(healthy, id)
+		} else {
+			unhealthy = append(unhealthy, id)
+		}
+	}
+
+	// Most caught-up standbys first, then by ID for a stable setting
+	sort.Slice(healthy, func(i, j int) bool {
+		mi, mj := status.Progress[healthy[i]].Match, status.Progress[healthy[j]].Match
+		if mi != mj {
+			return mi > mj
+		}
+		return healthy[i] < healthy[j]
+	})
+	sort.Slice(unhealthy, func(i, j int) bool { return unhealthy[i] < unhealthy[j] })
+
+	members := healthy
+	if len(healthy) < quorum {
+		members = append(members, unhealthy...)
+	}
+
+	names := make([]string, 0, len(members))
+	for _, id := range members {
+		names = append(names, `"`+strings.ReplaceAll(standbyName(id), `"`, `""`)+`"`)
+	}
+	return fmt.Sprintf("ANY %d (%s)", quorum, strings.Join(names, ", "))
+}
+
+// Recompute the standby set, at most once per syncStandbyRefreshInterval
+// unless forced
+func refreshSyncStandbys(force bool) {
+	syncStandbyMutex.Lock()
+	defer syncStandbyMutex.Unlock()
+
+	if !force && time.Since(syncStandbyRefreshed) < syncStandbyRefreshInterval {
+		return
+	}
+	syncStandbyRefreshed = time.Now()
+
+	node := raftNode
+	if node == nil {
+		return
+	}
+
+	value := computeSyncStandbyNames(node.Status())
+	if value == syncStandbyValue {
+		return
+	}
+
+	log.Printf("pgraft: INFO - synchronous_standby_names changed to '%s'", value)
+	syncStandbyValue = value
+	emitRaftEvent(eventSyncStandbys, 0, 0, []byte(value))
+}
+
+func resetSyncStandbys() {
+	syncStandbyMutex.Lock()
+	standbyNames = make(map[uint64]string)
+	syncStandbyValue = ""
+	syncStandbyDelivered = ""
+	syncStandbyRefreshed = time.Time{}
+	syncStandbyCallback = nil
+	syncStandbyArg = nil
+	syncStandbyMutex.Unlock()
+}
+
+// Set the application_name a node's standby connects with; an empty name
+// restores the default
+//
+//export pgraft_go_set_standby_name
+func pgraft_go_set_standby_name(nodeID C.int, name *C.char) C.int {
+	if nodeID <= 0 {
+		return errInvalidArgument
+	}
+
+	syncStandbyMutex.Lock()
+	if name == nil || C.GoString(name) == "" {
+		delete(standbyNames, uint64(nodeID))
+	} else {
+		standbyNames[uint64(nodeID)] = C.GoString(name)
+	}
+	syncStandbyMutex.Unlock()
+
+	refreshSyncStandbys(true)
+	return errOK
+}
+
+// Current synchronous_standby_names; free with pgraft_go_free_string()
+//
+//export pgraft_go_get_sync_standby_names
+func pgraft_go_get_sync_standby_names() *C.char {
+	refreshSyncStandbys(false)
+
+	syncStandbyMutex.Lock()
+	defer syncStandbyMutex.Unlock()
+	return C.CString(syncStandbyValue)
+}
+
+// Register the callback used by pgraft_go_drain_sync_standbys(); NULL
+// unregisters
+//
+//export pgraft_go_set_sync_standby_callback
+func pgraft_go_set_sync_standby_callback(callback C.pgraft_go_sync_standby_callback, arg unsafe.Pointer) {
+	syncStandbyMutex.Lock()
+	syncStandbyCallback = callback
+	syncStandbyArg = arg
+	syncStandbyMutex.Unlock()
+}
+
+// Invoke the callback on the calling thread if the setting changed since
+// the last delivery; returns 1 if it was invoked
+//
+//export pgraft_go_drain_sync_standbys
+func pgraft_go_drain_sync_standbys() C.int {
+	syncStandbyMutex.Lock()
+	callback, arg, value := syncStandbyCallback, syncStandbyArg, syncStandbyValue
+	if callback == nil || value == syncStandbyDelivered {
+		syncStandbyMutex.Unlock()
+		return 0
+	}
+	syncStandbyDelivered = value
+	syncStandbyMutex.Unlock()
+
+	cValue := C.CString(value)
+	defer C.free(unsafe.Pointer(cValue))
+	C.pgraft_go_call_sync_standby_callback(callback, cValue, arg)
+	return 1
+}
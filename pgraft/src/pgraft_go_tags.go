@@ -0,0 +1,212 @@
+/*
+ * pgraft_go_tags.go
+ * Node tags replicated through the raft log
+ *
+ * Members can carry arbitrary tags, either flags such as "nosync" or
+ * "backup-source" or key/value pairs such as zone=us-east-1a.  Tags are
+ * typed entries, so every node sees the same set and routing or backup
+ * decisions agree cluster-wide.  pgraft itself honours "nosync": such a
+ * node is only listed in synchronous_standby_names when too few other
+ * standbys are healthy to form the quorum.
+ */
+
+package main
+
+/*
+#include <stdint.h>
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Tag excluding a node from the preferred synchronous standbys
+const tagNoSync = "nosync"
+
+type tagEntryBody struct {
+	Node   uint64 `json:"node"`
+	Key    string `json:"key"`
+	Value  string `json:"value,omitempty"`
+	Remove bool   `json:"remove,omitempty"`
+}
+
+var (
+	nodeTags  = make(map[uint64]map[string]string)
+	tagsMutex sync.RWMutex
+)
+
+func applyTagEntry(index, term uint64, body []byte) {
+	var entry tagEntryBody
+	if err := json.Unmarshal(body, &entry); err != nil {
+		log.Printf("pgraft: ERROR - Malformed tag entry at index %d: %v", index, err)
+		return
+	}
+
+	tagsMutex.Lock()
+	defer tagsMutex.Unlock()
+
+	tags := nodeTags[entry.Node]
+	if entry.Remove {
+		delete(tags, entry.Key)
+		if len(tags) == 0 {
+			delete(nodeTags, entry.Node)
+		}
+		return
+	}
+	if tags == nil {
+		tags = make(map[string]string)
+		nodeTags[entry.Node] = tags
+	}
+	tags[entry.Key] = entry.Value
+}
+
+func nodeHasTag(nodeID uint64, key string) bool {
+	tagsMutex.RLock()
+	defer tagsMutex.RUnlock()
+
+	_, tagged := nodeTags[nodeID][key]
+	return tagged
+}
+
+func resetTags() {
+	tagsMutex.Lock()
+	nodeTags = make(map[uint64]map[string]string)
+	tagsMutex.Unlock()
+}
+
+// Tags of every node for a snapshot
+func snapshotTags() map[uint64]map[string]string {
+	tagsMutex.RLock()
+	defer tagsMutex.RUnlock()
+
+	all := make(map[uint64]map[string]string, len(nodeTags))
+	for node, tags := range nodeTags {
+		copied := make(map[string]string, len(tags))
+		for key, value := range tags {
+			copied[key] = value
+		}
+		all[node] = copied
+	}
+	return all
+}
+
+// Replace the tags with those of a snapshot
+func restoreTags(all map[uint64]map[string]string) {
+	tagsMutex.Lock()
+	defer tagsMutex.Unlock()
+
+	nodeTags = make(map[uint64]map[string]string, len(all))
+	for node, tags := range all {
+		if len(tags) > 0 {
+			nodeTags[node] = tags
+		}
+	}
+}
+
+// Node a tag export refers to; 0 means this node
+func tagNode(node C.uint64_t) uint64 {
+	if node == 0 && activeConfig != nil {
+		return activeConfig.NodeID
+	}
+	return uint64(node)
+}
+
+// Set a tag on node (0: this node); a NULL value removes the tag, an
+// empty one sets a flag
+//
+//export pgraft_go_set_node_tag
+func pgraft_go_set_node_tag(node C.uint64_t, key *C.char, value *C.char) C.int {
+	if key == nil || C.GoString(key) == "" {
+		return errInvalidArgument
+	}
+	target := tagNode(node)
+	if target == 0 {
+		return errNotInitialized
+	}
+
+	entry := tagEntryBody{Node: target, Key: C.GoString(key), Remove: value == nil}
+	if value != nil {
+		entry.Value = C.GoString(value)
+	}
+	return C.int(proposeTypedEntry(typedEntryTag, entry))
+}
+
+// Value of a tag on node (0: this node), or NULL if it is not set; free
+// with pgraft_go_free_string()
+//
+//export pgraft_go_get_node_tag
+func pgraft_go_get_node_tag(node C.uint64_t, key *C.char) *C.char {
+	if key == nil {
+		return nil
+	}
+
+	tagsMutex.RLock()
+	value, tagged := nodeTags[tagNode(node)][C.GoString(key)]
+	tagsMutex.RUnlock()
+	if !tagged {
+		return nil
+	}
+	return C.CString(value)
+}
+
+// Tags of node (0: every node, keyed by node ID) as a JSON object; free
+// with pgraft_go_free_string()
+//
+//export pgraft_go_get_node_tags
+func pgraft_go_get_node_tags(node C.uint64_t) *C.char {
+	tagsMutex.RLock()
+	var jsonData []byte
+	var err error
+	if node != 0 {
+		tags := nodeTags[uint64(node)]
+		if tags == nil {
+			tags = map[string]string{}
+		}
+		jsonData, err = json.Marshal(tags)
+	} else {
+		all := make(map[string]map[string]string, len(nodeTags))
+		for id, tags := range nodeTags {
+			all[strconv.FormatUint(id, 10)] = tags
+		}
+		jsonData, err = json.Marshal(all)
+	}
+	tagsMutex.RUnlock()
+
+	if err != nil {
+		return C.CString("{}")
+	}
+	return C.CString(string(jsonData))
+}
+
+// IDs of the nodes carrying a tag, optionally with the given value, as a
+// JSON array; free with pgraft_go_free_string()
+//
+//export pgraft_go_nodes_with_tag
+func pgraft_go_nodes_with_tag(key *C.char, value *C.char) *C.char {
+	if key == nil {
+		return C.CString("[]")
+	}
+	wantKey := C.GoString(key)
+
+	tagsMutex.RLock()
+	nodes := make([]uint64, 0)
+	for id, tags := range nodeTags {
+		v, tagged := tags[wantKey]
+		if tagged && (value == nil || v == C.GoString(value)) {
+			nodes = append(nodes, id)
+		}
+	}
+	tagsMutex.RUnlock()
+
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i] < nodes[j] })
+	jsonData, err := json.Marshal(nodes)
+	if err != nil {
+		return C.CString("[]")
+	}
+	return C.CString(string(jsonData))
+}
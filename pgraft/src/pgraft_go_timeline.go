@@ -0,0 +1,172 @@
+/*
+ * pgraft_go_timeline.go
+ * PostgreSQL timeline history kept in the raft log
+ *
+ * Every timeline switch reported through pgraft_go_report_promotion() or
+ * pgraft_go_report_timeline_switch() is appended to a replicated history,
+ * the cluster-wide equivalent of the .history files of one server.  From
+ * it any member can decide whether WAL written on some timeline up to
+ * some LSN is contained in the current timeline, or has diverged and must
+ * be rewound before the node is reattached.  The rewind verdicts use the
+ * full history, so a node several timelines behind is judged against the
+ * fork point of its own branch.
+ */
+
+package main
+
+/*
+#include <stdint.h>
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+type timelineSwitch struct {
+	Timeline  uint32 `json:"timeline"`
+	Parent    uint32 `json:"parent"`
+	SwitchLSN uint64 `json:"switch_lsn"`
+	Node      uint64 `json:"node"`
+	Index     uint64 `json:"index"`
+}
+
+var (
+	// Switches in log order, by the timeline they created
+	timelineHistory = make(map[uint32]timelineSwitch)
+	timelineOrder   []uint32
+	timelineCurrent uint32
+	timelineMutex   sync.Mutex
+)
+
+// Record a switch unless its timeline is already known
+func recordTimelineSwitch(sw timelineSwitch) {
+	timelineMutex.Lock()
+	defer timelineMutex.Unlock()
+
+	if _, known := timelineHistory[sw.Timeline]; known || sw.Parent >= sw.Timeline {
+		return
+	}
+	timelineHistory[sw.Timeline] = sw
+	timelineOrder = append(timelineOrder, sw.Timeline)
+	if sw.Timeline > timelineCurrent {
+		timelineCurrent = sw.Timeline
+	}
+}
+
+// Timelines from tli back to the first known one; timelineMutex must be
+// held
+func timelineAncestry(tli uint32) map[uint32]bool {
+	ancestry := map[uint32]bool{tli: true}
+	for {
+		sw, known := timelineHistory[tli]
+		if !known {
+			return ancestry
+		}
+		ancestry[sw.Parent] = true
+		tli = sw.Parent
+	}
+}
+
+// LSN past which WAL on timeline is not part of the current timeline, and
+// whether it could be determined; WAL on the current timeline never
+// diverges
+func timelineDivergence(timeline uint32) (uint64, bool) {
+	timelineMutex.Lock()
+	defer timelineMutex.Unlock()
+
+	current := timelineCurrent
+	if current == 0 || timeline == current {
+		return 0, false
+	}
+
+	// Walk back from the node's timeline to the first ancestor shared
+	// with the current one; the node's branch forked from it at the lower
+	// of the two branch points
+	currentAncestry := timelineAncestry(current)
+	child, tli := uint32(0), timeline
+	for {
+		if currentAncestry[tli] {
+			break
+		}
+		sw, known := timelineHistory[tli]
+		if !known {
+			return 0, false
+		}
+		child, tli = tli, sw.Parent
+	}
+
+	// The current timeline's branch from the shared ancestor
+	fork := uint64(0)
+	for t := current; t != tli; {
+		sw := timelineHistory[t]
+		fork = sw.SwitchLSN
+		t = sw.Parent
+	}
+	if child != 0 {
+		if nodeFork := timelineHistory[child].SwitchLSN; nodeFork < fork {
+			fork = nodeFork
+		}
+	}
+	return fork, true
+}
+
+func resetTimelines() {
+	timelineMutex.Lock()
+	timelineHistory = make(map[uint32]timelineSwitch)
+	timelineOrder = nil
+	timelineCurrent = 0
+	timelineMutex.Unlock()
+}
+
+// Record that this node switched from parentTimeline to timeline at
+// switchLSN
+//
+//export pgraft_go_report_timeline_switch
+func pgraft_go_report_timeline_switch(parentTimeline C.uint32_t, timeline C.uint32_t, switchLSN C.uint64_t) C.int {
+	if parentTimeline == 0 || timeline <= parentTimeline {
+		return errInvalidArgument
+	}
+	return proposeRewindEntry(rewindEntryBody{
+		Op:       rewindOpPromotion,
+		Timeline: uint32(timeline),
+		Parent:   uint32(parentTimeline),
+		LSN:      uint64(switchLSN),
+	})
+}
+
+// Whether WAL on timeline up to lsn has diverged from the current
+// timeline; returns 1 and the divergence point in *divergenceLSN if so, 0
+// if not or if the timeline is unknown
+//
+//export pgraft_go_timeline_diverged
+func pgraft_go_timeline_diverged(timeline C.uint32_t, lsn C.uint64_t, divergenceLSN *C.uint64_t) C.int {
+	fork, known := timelineDivergence(uint32(timeline))
+	if !known || uint64(lsn) <= fork {
+		return 0
+	}
+	if divergenceLSN != nil {
+		*divergenceLSN = C.uint64_t(fork)
+	}
+	return 1
+}
+
+// Replicated timeline history as a JSON array in log order; free with
+// pgraft_go_free_string()
+//
+//export pgraft_go_timeline_history
+func pgraft_go_timeline_history() *C.char {
+	timelineMutex.Lock()
+	history := make([]timelineSwitch, 0, len(timelineOrder))
+	for _, tli := range timelineOrder {
+		history = append(history, timelineHistory[tli])
+	}
+	timelineMutex.Unlock()
+
+	jsonData, err := json.Marshal(history)
+	if err != nil {
+		return C.CString("[]")
+	}
+	return C.CString(string(jsonData))
+}
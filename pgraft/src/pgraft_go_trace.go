@@ -0,0 +1,392 @@
+/*
+ * pgraft_go_trace.go
+ * Recording of the raft node's inputs and outputs for offline replay
+ *
+ * With a trace file set by pgraft_go_trace_start() before the node is
+ * created, the node is wrapped so that every input (ticks, inbound
+ * messages, proposals, configuration changes, campaigns, transfers and
+ * reports) and every Ready it produces is appended to the file, preceded
+ * by the raft configuration and the contents of storage at creation.
+ * pgraft-replay re-drives a fresh node from the trace and reports the
+ * first point where its output differs, so a misbehaving consensus
+ * pipeline can be debugged offline.  Tracing is opt-in and costs a write
+ * per input; it stops with pgraft_go_trace_stop() or when the node stops.
+ *
+ * The format is shared with pgraft/trace, which reads it: the magic
+ * "PGRTRACE" and a 4-byte version, then records of a kind byte, an 8-byte
+ * Unix time in nanoseconds, a 4-byte length and the payload, all
+ * big-endian.
+ */
+
+package main
+
+/*
+#include <stdint.h>
+*/
+import "C"
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"go.etcd.io/raft/v3"
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+const (
+	traceMagic   = "PGRTRACE"
+	traceVersion = 1
+)
+
+// Record kinds; values are part of the file format
+const (
+	traceStart             = 1
+	traceStorage           = 2
+	traceTick              = 3
+	traceStep              = 4
+	tracePropose           = 5
+	traceProposeConfChange = 6
+	traceCampaign          = 7
+	traceTransfer          = 8
+	traceApplyConfChange   = 9
+	traceReady             = 10
+	traceUnreachable       = 11
+	traceSnapshotStatus    = 12
+	traceReadIndex         = 13
+	traceForgetLeader      = 14
+)
+
+type traceStartRecord struct {
+	NodeID          uint64      `json:"node_id"`
+	ElectionTick    int         `json:"election_tick"`
+	HeartbeatTick   int         `json:"heartbeat_tick"`
+	MaxSizePerMsg   uint64      `json:"max_size_per_msg"`
+	MaxInflightMsgs int         `json:"max_inflight_msgs"`
+	PreVote         bool        `json:"pre_vote"`
+	CheckQuorum     bool        `json:"check_quorum"`
+	Applied         uint64      `json:"applied"`
+	Peers           []tracePeer `json:"peers,omitempty"`
+}
+
+type tracePeer struct {
+	ID      uint64 `json:"id"`
+	Context []byte `json:"context,omitempty"`
+}
+
+type traceStorageRecord struct {
+	Snapshot  []byte   `json:"snapshot"`
+	HardState []byte   `json:"hard_state"`
+	Entries   [][]byte `json:"entries"`
+}
+
+type traceReadyRecord struct {
+	Lead             uint64   `json:"lead,omitempty"`
+	State            string   `json:"state,omitempty"`
+	HardState        []byte   `json:"hard_state,omitempty"`
+	Entries          [][]byte `json:"entries,omitempty"`
+	CommittedEntries [][]byte `json:"committed_entries,omitempty"`
+	Messages         [][]byte `json:"messages,omitempty"`
+	Snapshot         []byte   `json:"snapshot,omitempty"`
+}
+
+var (
+	tracePath   string
+	traceFile   *os.File
+	traceWriter *bufio.Writer
+	traceMutex  sync.Mutex
+)
+
+// Append one record; stops tracing if the file cannot be written
+func writeTraceRecord(kind byte, payload []byte) {
+	traceMutex.Lock()
+	defer traceMutex.Unlock()
+
+	if traceWriter == nil {
+		return
+	}
+	var header [13]byte
+	header[0] = kind
+	binary.BigEndian.PutUint64(header[1:], uint64(time.Now().UnixNano()))
+	binary.BigEndian.PutUint32(header[9:], uint32(len(payload)))
+	traceWriter.Write(header[:])
+	traceWriter.Write(payload)
+	if kind == traceReady || kind == traceStorage {
+		if err := traceWriter.Flush(); err != nil {
+			log.Printf("pgraft: ERROR - Writing trace %s failed, tracing stopped: %v", tracePath, err)
+			closeTraceLocked()
+		}
+	}
+}
+
+func writeTraceJSON(kind byte, record interface{}) {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	writeTraceRecord(kind, payload)
+}
+
+type marshaler interface {
+	Marshal() ([]byte, error)
+}
+
+func mustMarshal(m marshaler) []byte {
+	data, _ := m.Marshal()
+	return data
+}
+
+// Configuration change prefixed by its version, 1 or 2
+func encodeTraceConfChange(cc raftpb.ConfChangeI) []byte {
+	if v1, isV1 := cc.AsV1(); isV1 {
+		return append([]byte{1}, mustMarshal(&v1)...)
+	}
+	v2 := cc.AsV2()
+	return append([]byte{2}, mustMarshal(&v2)...)
+}
+
+func closeTraceLocked() {
+	if traceWriter != nil {
+		traceWriter.Flush()
+	}
+	if traceFile != nil {
+		traceFile.Close()
+	}
+	traceWriter = nil
+	traceFile = nil
+}
+
+// Open the trace file and record the configuration and storage the node
+// is created from
+func beginTrace(cfg *raft.Config, storage raft.Storage, peers []raft.Peer) bool {
+	traceMutex.Lock()
+	path := tracePath
+	if path == "" {
+		traceMutex.Unlock()
+		return false
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		traceMutex.Unlock()
+		log.Printf("pgraft: ERROR - Cannot create trace %s: %v", path, err)
+		return false
+	}
+	traceFile = file
+	traceWriter = bufio.NewWriterSize(file, 64*1024)
+	var header [12]byte
+	copy(header[:], traceMagic)
+	binary.BigEndian.PutUint32(header[8:], traceVersion)
+	traceWriter.Write(header[:])
+	traceMutex.Unlock()
+
+	start := traceStartRecord{
+		NodeID:          cfg.ID,
+		ElectionTick:    cfg.ElectionTick,
+		HeartbeatTick:   cfg.HeartbeatTick,
+		MaxSizePerMsg:   cfg.MaxSizePerMsg,
+		MaxInflightMsgs: cfg.MaxInflightMsgs,
+		PreVote:         cfg.PreVote,
+		CheckQuorum:     cfg.CheckQuorum,
+		Applied:         cfg.Applied,
+	}
+	for _, peer := range peers {
+		start.Peers = append(start.Peers, tracePeer{ID: peer.ID, Context: peer.Context})
+	}
+	writeTraceJSON(traceStart, start)
+
+	var dump traceStorageRecord
+	if snapshot, err := storage.Snapshot(); err == nil {
+		dump.Snapshot = mustMarshal(&snapshot)
+	}
+	if hardState, _, err := storage.InitialState(); err == nil {
+		dump.HardState = mustMarshal(&hardState)
+	}
+	first, _ := storage.FirstIndex()
+	last, _ := storage.LastIndex()
+	if last >= first {
+		entries, _ := storage.Entries(first, last+1, 1<<62)
+		for i := range entries {
+			dump.Entries = append(dump.Entries, mustMarshal(&entries[i]))
+		}
+	}
+	writeTraceJSON(traceStorage, dump)
+
+	log.Printf("pgraft: INFO - Tracing raft node %d to %s", cfg.ID, path)
+	return true
+}
+
+// raft.Node that records its inputs and the Ready batches it produces
+type tracedNode struct {
+	raft.Node
+	ready   chan raft.Ready
+	stopped chan struct{}
+	once    sync.Once
+}
+
+// Wrap node if a trace file is set
+func traceNode(node raft.Node, cfg *raft.Config, storage raft.Storage, peers []raft.Peer) raft.Node {
+	if !beginTrace(cfg, storage, peers) {
+		return node
+	}
+	t := &tracedNode{
+		Node:    node,
+		ready:   make(chan raft.Ready),
+		stopped: make(chan struct{}),
+	}
+	go t.forwardReady()
+	return t
+}
+
+// Record each Ready on its way to the consumer; the wrapped node does not
+// produce another until Advance, so this never reads ahead
+func (t *tracedNode) forwardReady() {
+	for {
+		select {
+		case rd := <-t.Node.Ready():
+			recordTraceReady(rd)
+			select {
+			case t.ready <- rd:
+			case <-t.stopped:
+				return
+			}
+		case <-t.stopped:
+			return
+		}
+	}
+}
+
+func recordTraceReady(rd raft.Ready) {
+	record := traceReadyRecord{}
+	if rd.SoftState != nil {
+		record.Lead = rd.SoftState.Lead
+		record.State = rd.SoftState.RaftState.String()
+	}
+	if !raft.IsEmptyHardState(rd.HardState) {
+		record.HardState = mustMarshal(&rd.HardState)
+	}
+	for i := range rd.Entries {
+		record.Entries = append(record.Entries, mustMarshal(&rd.Entries[i]))
+	}
+	for i := range rd.CommittedEntries {
+		record.CommittedEntries = append(record.CommittedEntries, mustMarshal(&rd.CommittedEntries[i]))
+	}
+	for i := range rd.Messages {
+		record.Messages = append(record.Messages, mustMarshal(&rd.Messages[i]))
+	}
+	if !raft.IsEmptySnap(rd.Snapshot) {
+		record.Snapshot = mustMarshal(&rd.Snapshot)
+	}
+	writeTraceJSON(traceReady, record)
+}
+
+func (t *tracedNode) Ready() <-chan raft.Ready {
+	return t.ready
+}
+
+func (t *tracedNode) Tick() {
+	writeTraceRecord(traceTick, nil)
+	t.Node.Tick()
+}
+
+func (t *tracedNode) Campaign(ctx context.Context) error {
+	writeTraceRecord(traceCampaign, nil)
+	return t.Node.Campaign(ctx)
+}
+
+func (t *tracedNode) Propose(ctx context.Context, data []byte) error {
+	writeTraceRecord(tracePropose, data)
+	return t.Node.Propose(ctx, data)
+}
+
+func (t *tracedNode) ProposeConfChange(ctx context.Context, cc raftpb.ConfChangeI) error {
+	writeTraceRecord(traceProposeConfChange, encodeTraceConfChange(cc))
+	return t.Node.ProposeConfChange(ctx, cc)
+}
+
+func (t *tracedNode) Step(ctx context.Context, msg raftpb.Message) error {
+	writeTraceRecord(traceStep, mustMarshal(&msg))
+	return t.Node.Step(ctx, msg)
+}
+
+func (t *tracedNode) ApplyConfChange(cc raftpb.ConfChangeI) *raftpb.ConfState {
+	writeTraceRecord(traceApplyConfChange, encodeTraceConfChange(cc))
+	return t.Node.ApplyConfChange(cc)
+}
+
+func (t *tracedNode) TransferLeadership(ctx context.Context, lead, transferee uint64) {
+	var payload [16]byte
+	binary.BigEndian.PutUint64(payload[:8], lead)
+	binary.BigEndian.PutUint64(payload[8:], transferee)
+	writeTraceRecord(traceTransfer, payload[:])
+	t.Node.TransferLeadership(ctx, lead, transferee)
+}
+
+func (t *tracedNode) ForgetLeader(ctx context.Context) error {
+	writeTraceRecord(traceForgetLeader, nil)
+	return t.Node.ForgetLeader(ctx)
+}
+
+func (t *tracedNode) ReadIndex(ctx context.Context, rctx []byte) error {
+	writeTraceRecord(traceReadIndex, rctx)
+	return t.Node.ReadIndex(ctx, rctx)
+}
+
+func (t *tracedNode) ReportUnreachable(id uint64) {
+	var payload [8]byte
+	binary.BigEndian.PutUint64(payload[:], id)
+	writeTraceRecord(traceUnreachable, payload[:])
+	t.Node.ReportUnreachable(id)
+}
+
+func (t *tracedNode) ReportSnapshot(id uint64, status raft.SnapshotStatus) {
+	var payload [9]byte
+	binary.BigEndian.PutUint64(payload[:8], id)
+	payload[8] = byte(status)
+	writeTraceRecord(traceSnapshotStatus, payload[:])
+	t.Node.ReportSnapshot(id, status)
+}
+
+func (t *tracedNode) Stop() {
+	t.Node.Stop()
+	t.once.Do(func() { close(t.stopped) })
+	traceMutex.Lock()
+	closeTraceLocked()
+	traceMutex.Unlock()
+}
+
+func resetTrace() {
+	traceMutex.Lock()
+	closeTraceLocked()
+	tracePath = ""
+	traceMutex.Unlock()
+}
+
+// Trace the raft node to path from the next time it is created; an
+// existing file is overwritten
+//
+//export pgraft_go_trace_start
+func pgraft_go_trace_start(path *C.char) C.int {
+	if path == nil || C.GoString(path) == "" {
+		return errInvalidArgument
+	}
+
+	traceMutex.Lock()
+	defer traceMutex.Unlock()
+	if traceWriter != nil {
+		return errAlreadyInitialized
+	}
+	tracePath = C.GoString(path)
+	return errOK
+}
+
+// Stop tracing and close the trace file
+//
+//export pgraft_go_trace_stop
+func pgraft_go_trace_stop() {
+	resetTrace()
+}
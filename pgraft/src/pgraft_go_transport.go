@@ -0,0 +1,98 @@
+/*
+ * pgraft_go_transport.go
+ * Transport between the raft node and its peers
+ *
+ * Outgoing raft messages leave through peerTransport and incoming ones
+ * enter through receiveMessage, so nothing else in the library depends
+ * on how peers are reached.  The default transport is the TCP (or TLS)
+ * connections set up by loadAndConnectToPeers(); tests replace it with
+ * an in-memory one before initializing the node.  pgraft/raftio declares
+ * the same interface, with in-memory implementations for consumers; the
+ * two must change together.
+ */
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"sync/atomic"
+
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+type Transport interface {
+	// Send a message to msg.To; an error if it cannot be sent now.
+	// Delivery is not guaranteed, raft retransmits what is lost
+	Send(msg raftpb.Message) error
+
+	// Whether a connection to the node is established
+	Connected(nodeID uint64) bool
+
+	// Drop every connection
+	Close() error
+}
+
+// Transport used by the node
+var peerTransport Transport = tcpTransport{}
+
+// Hand a message received from a peer to the raft node; messages are
+// dropped if the node is not keeping up
+func receiveMessage(msg raftpb.Message) {
+	select {
+	case messageChan <- msg:
+	default:
+		log.Printf("pgraft: WARNING - Message channel full, dropping message from node %d", msg.From)
+	}
+}
+
+// Peer connections of the connections map, framed as in pgraft_go_wire.go
+type tcpTransport struct{}
+
+func (tcpTransport) Send(msg raftpb.Message) error {
+	connMutex.RLock()
+	conn, exists := connections[msg.To]
+	connMutex.RUnlock()
+	if !exists {
+		return fmt.Errorf("no connection to peer %d", msg.To)
+	}
+
+	data, err := msg.Marshal()
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %v", err)
+	}
+
+	injectSendFaults(msg, data, func(data []byte) {
+		// Length and data go out in one write, so frames delayed by fault
+		// injection cannot interleave with others
+		frame := make([]byte, 4+len(data))
+		binary.BigEndian.PutUint32(frame, uint32(len(data)))
+		copy(frame[4:], data)
+		if _, err := conn.Write(frame); err != nil {
+			log.Printf("pgraft: ERROR - Failed to send message data: %v", err)
+			return
+		}
+
+		log.Printf("pgraft: DEBUG - Message sent successfully to node %d", msg.To)
+		atomic.AddInt64(&messagesProcessed, 1)
+	})
+	return nil
+}
+
+func (tcpTransport) Connected(nodeID uint64) bool {
+	connMutex.RLock()
+	defer connMutex.RUnlock()
+	_, exists := connections[nodeID]
+	return exists
+}
+
+func (tcpTransport) Close() error {
+	connMutex.Lock()
+	for nodeID, conn := range connections {
+		conn.Close()
+		delete(connections, nodeID)
+	}
+	connMutex.Unlock()
+	return nil
+}
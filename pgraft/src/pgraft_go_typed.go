@@ -0,0 +1,291 @@
+/*
+ * pgraft_go_typed.go
+ * Typed entries carried in the raft log
+ *
+ * Besides the opaque payloads proposed by the extension, the log carries
+ * entries used by pgraft's own replicated services.  They are normal raft
+ * entries whose data starts with a reserved prefix (a NUL byte, "PGR" and
+ * a version byte) followed by a kind byte and a JSON body.  Typed entries
+ * are applied by their service on every node and are not handed to the
+ * commit callback or the applied-entries ring; payloads proposed by the
+ * extension therefore must not begin with the reserved prefix, and every
+ * propose path of the extension rejects those that do.
+ *
+ * The one exception is the proposal envelope: an extension payload whose
+ * proposer needs to recognise it when it is applied is wrapped with the
+ * proposing node's ID and a proposal ID, in binary rather than JSON, and
+ * is delivered as the bare payload.
+ *
+ * Services whose operations have an outcome (a lock granted or not)
+ * propose with proposeTypedEntryAndWait(); the apply function on the
+ * proposing node reports the outcome with completeTypedWaiter().
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+var typedEntryMagic = []byte{0x00, 'P', 'G', 'R', 1}
+
+// Kinds of typed entries; values are stored in the log and never reused,
+// and pgraft/inspect has a name for each
+const (
+	typedEntryDDL          = 1
+	typedEntryDDLAck       = 2
+	typedEntryLock         = 3
+	typedEntryKV           = 4
+	typedEntryBarrier      = 5
+	typedEntrySlots        = 6
+	typedEntryBootstrap    = 7
+	typedEntryRewind       = 8
+	typedEntryMaintenance  = 9
+	typedEntryTag          = 10
+	typedEntrySequence     = 11
+	typedEntryParameter    = 12
+	typedEntryParameterAck = 13
+	typedEntryRestart      = 14
+	typedEntryBackup       = 15
+	typedEntryProposal     = 16
+)
+
+// Apply functions of the replicated services, by entry kind
+var typedEntryHandlers = map[byte]func(index, term uint64, body []byte){
+	typedEntryDDL:          applyDDLEntry,
+	typedEntryDDLAck:       applyDDLAckEntry,
+	typedEntryLock:         applyLockEntry,
+	typedEntryKV:           applyKVEntry,
+	typedEntryBarrier:      applyBarrierEntry,
+	typedEntrySlots:        applySlotEntry,
+	typedEntryBootstrap:    applyBootstrapEntry,
+	typedEntryRewind:       applyRewindEntry,
+	typedEntryMaintenance:  applyMaintenanceEntry,
+	typedEntryTag:          applyTagEntry,
+	typedEntrySequence:     applySequenceEntry,
+	typedEntryParameter:    applyParameterEntry,
+	typedEntryParameterAck: applyParameterAckEntry,
+	typedEntryRestart:      applyRestartEntry,
+	typedEntryBackup:       applyBackupEntry,
+}
+
+// Outcome of a typed entry reported by its service, the entry's index and
+// any value the service produced
+type typedOutcome struct {
+	result int
+	index  uint64
+	value  uint64
+}
+
+// Index through which typed entries have been applied.  The service
+// tables survive pgraft_go_stop(), and a node restarted from its storage
+// replays the log from the durably applied index, or from the start;
+// entries at or below this index are already in the tables and skipped.
+var servicesApplied uint64
+
+var (
+	// Proposals from this node awaiting their outcome, by request ID
+	typedWaiters     = make(map[uint64]chan typedOutcome)
+	typedWaiterMutex sync.Mutex
+)
+
+func encodeTypedEntry(kind byte, body interface{}) ([]byte, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded := make([]byte, 0, len(typedEntryMagic)+1+len(data))
+	encoded = append(encoded, typedEntryMagic...)
+	encoded = append(encoded, kind)
+	return append(encoded, data...), nil
+}
+
+func decodeTypedEntry(data []byte) (byte, []byte, bool) {
+	if len(data) <= len(typedEntryMagic) || !bytes.HasPrefix(data, typedEntryMagic) {
+		return 0, nil, false
+	}
+	return data[len(typedEntryMagic)], data[len(typedEntryMagic)+1:], true
+}
+
+// Whether an extension payload begins with the prefix reserved for typed
+// entries, which would have it applied as one
+func reservedPayload(data []byte) bool {
+	return bytes.HasPrefix(data, typedEntryMagic)
+}
+
+// Wrap a payload proposed from this node under proposalID: origin node ID
+// and proposal ID, big-endian, then the payload
+func encodeProposalEntry(proposalID uint64, payload []byte) []byte {
+	encoded := make([]byte, 0, len(typedEntryMagic)+17+len(payload))
+	encoded = append(encoded, typedEntryMagic...)
+	encoded = append(encoded, typedEntryProposal)
+	encoded = binary.BigEndian.AppendUint64(encoded, activeConfig.NodeID)
+	encoded = binary.BigEndian.AppendUint64(encoded, proposalID)
+	return append(encoded, payload...)
+}
+
+func decodeProposalEntry(data []byte) (origin uint64, proposalID uint64, payload []byte, ok bool) {
+	kind, body, typed := decodeTypedEntry(data)
+	if !typed || kind != typedEntryProposal || len(body) < 16 {
+		return 0, 0, nil, false
+	}
+	return binary.BigEndian.Uint64(body), binary.BigEndian.Uint64(body[8:]), body[16:], true
+}
+
+// Payload of an entry as the extension proposed it
+func entryPayload(data []byte) []byte {
+	if _, _, payload, ok := decodeProposalEntry(data); ok {
+		return payload
+	}
+	return data
+}
+
+// Proposal ID of an entry this node proposed in an envelope; 0 for any
+// other entry
+func localProposalID(entry raftpb.Entry) uint64 {
+	if entry.Type != raftpb.EntryNormal || activeConfig == nil {
+		return 0
+	}
+	origin, proposalID, _, ok := decodeProposalEntry(entry.Data)
+	if !ok || origin != activeConfig.NodeID {
+		return 0
+	}
+	return proposalID
+}
+
+// Apply a typed entry; false if the entry is an opaque extension payload,
+// enveloped or not
+func applyTypedEntry(entry raftpb.Entry) bool {
+	kind, body, ok := decodeTypedEntry(entry.Data)
+	if !ok || kind == typedEntryProposal {
+		return false
+	}
+	if entry.Index <= atomic.LoadUint64(&servicesApplied) {
+		debugLog("typed: entry %d already applied", entry.Index)
+		return true
+	}
+
+	handler, known := typedEntryHandlers[kind]
+	if !known {
+		log.Printf("pgraft: WARNING - Skipping typed entry %d of unknown kind %d", entry.Index, kind)
+	} else {
+		handler(entry.Index, entry.Term, body)
+	}
+	atomic.StoreUint64(&servicesApplied, entry.Index)
+	return true
+}
+
+// Propose a typed entry through raft
+func proposeTypedEntry(kind byte, body interface{}) int {
+	if atomic.LoadInt32(&running) == 0 {
+		return errNotRunning
+	}
+	if rc := proposalGate(); rc != errOK {
+		return int(rc)
+	}
+
+	data, err := encodeTypedEntry(kind, body)
+	if err != nil {
+		log.Printf("pgraft: ERROR - Failed to encode typed entry: %v", err)
+		return errInvalidArgument
+	}
+
+	raftMutex.RLock()
+	node, ctx := raftNode, raftCtx
+	raftMutex.RUnlock()
+	if node == nil {
+		return errNotRunning
+	}
+	if err := node.Propose(ctx, data); err != nil {
+		log.Printf("pgraft: ERROR - Failed to propose typed entry: %v", err)
+		return errProposalDropped
+	}
+	return errOK
+}
+
+// Propose a typed entry carrying requestID and wait until it is applied
+// locally; returns the outcome reported by the service, or an error code,
+// and the index the entry was applied at
+func proposeTypedEntryAndWait(kind byte, requestID uint64, body interface{}, timeout time.Duration) (int, uint64) {
+	outcome := proposeTypedEntryAndAwait(kind, requestID, body, timeout)
+	return outcome.result, outcome.index
+}
+
+// As proposeTypedEntryAndWait(), returning the complete outcome
+func proposeTypedEntryAndAwait(kind byte, requestID uint64, body interface{}, timeout time.Duration) typedOutcome {
+	result := make(chan typedOutcome, 1)
+	typedWaiterMutex.Lock()
+	typedWaiters[requestID] = result
+	typedWaiterMutex.Unlock()
+
+	defer func() {
+		typedWaiterMutex.Lock()
+		delete(typedWaiters, requestID)
+		typedWaiterMutex.Unlock()
+	}()
+
+	if rc := proposeTypedEntry(kind, body); rc != errOK {
+		return typedOutcome{result: rc}
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case outcome := <-result:
+		return outcome
+	case <-timer.C:
+		return typedOutcome{result: errTimeout}
+	}
+}
+
+// Report the outcome of requestID if this node proposed it
+func completeTypedWaiter(requestID uint64, result int, index uint64) {
+	completeTypedWaiterValue(requestID, typedOutcome{result: result, index: index})
+}
+
+// Report an outcome carrying a value if this node proposed requestID
+func completeTypedWaiterValue(requestID uint64, outcome typedOutcome) {
+	typedWaiterMutex.Lock()
+	defer typedWaiterMutex.Unlock()
+
+	if waiter, exists := typedWaiters[requestID]; exists {
+		waiter <- outcome
+		delete(typedWaiters, requestID)
+	}
+}
+
+func resetServicesApplied() {
+	atomic.StoreUint64(&servicesApplied, 0)
+}
+
+func resetTypedWaiters() {
+	typedWaiterMutex.Lock()
+	for requestID, waiter := range typedWaiters {
+		waiter <- typedOutcome{result: errNotRunning}
+		delete(typedWaiters, requestID)
+	}
+	typedWaiterMutex.Unlock()
+}
+
+// Random non-zero identifier for objects created through typed entries
+func newReplicatedID() uint64 {
+	var buf [8]byte
+	for {
+		if _, err := rand.Read(buf[:]); err != nil {
+			panic(err)
+		}
+		if id := binary.LittleEndian.Uint64(buf[:]) >> 1; id != 0 {
+			return id
+		}
+	}
+}
@@ -0,0 +1,246 @@
+/*
+ * pgraft_go_validate.go
+ * Configuration validation with detailed findings
+ *
+ * pgraft_go_validate_config() checks a configuration document of the form
+ * accepted by pgraft_go_init_json() without initializing anything, and
+ * reports every problem it finds rather than the first one.  Errors make
+ * init fail; warnings flag settings that are accepted but likely wrong.
+ * Structural checks are shared with init, while the environment checks
+ * (TLS files, port availability, data directory) only run here.
+ */
+
+package main
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const (
+	findingError   = "error"
+	findingWarning = "warning"
+)
+
+type configFinding struct {
+	Severity string `json:"severity"`
+	Field    string `json:"field"`
+	Message  string `json:"message"`
+}
+
+type configFindings []configFinding
+
+func (f *configFindings) errorf(field, format string, args ...interface{}) {
+	*f = append(*f, configFinding{findingError, field, fmt.Sprintf(format, args...)})
+}
+
+func (f *configFindings) warnf(field, format string, args ...interface{}) {
+	*f = append(*f, configFinding{findingWarning, field, fmt.Sprintf(format, args...)})
+}
+
+// First error finding, or nil
+func (f configFindings) firstError() *configFinding {
+	for i := range f {
+		if f[i].Severity == findingError {
+			return &f[i]
+		}
+	}
+	return nil
+}
+
+var hostnamePattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?)*$`)
+
+func validHost(host string) bool {
+	return net.ParseIP(host) != nil || hostnamePattern.MatchString(host)
+}
+
+func subField(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+func checkEndpoint(findings *configFindings, prefix, host string, port int) {
+	if host == "" {
+		findings.errorf(subField(prefix, "address"), "address must be set")
+	} else if !validHost(host) {
+		findings.errorf(subField(prefix, "address"), "%q is not a valid IP address or hostname", host)
+	}
+	if port <= 0 || port > 65535 {
+		findings.errorf(subField(prefix, "port"), "port %d is out of range 1-65535", port)
+	}
+}
+
+// Checks that depend only on the document itself
+func validateNodeConfig(cfg *NodeConfig) configFindings {
+	var findings configFindings
+
+	if cfg.NodeID == 0 {
+		findings.errorf("node_id", "node_id must be set and non-zero")
+	}
+	checkEndpoint(&findings, "", cfg.Address, cfg.Port)
+
+	seenIDs := map[uint64]string{cfg.NodeID: "node_id"}
+	seenEndpoints := map[string]string{cfg.listenAddress(): "node"}
+	for i, peer := range cfg.Peers {
+		field := fmt.Sprintf("peers[%d]", i)
+		checkEndpoint(&findings, field, peer.Address, peer.Port)
+
+		if peer.ID == 0 {
+			findings.errorf(subField(field, "id"), "peer id must be non-zero")
+		} else if other, exists := seenIDs[peer.ID]; exists {
+			findings.errorf(subField(field, "id"), "id %d is already used by %s", peer.ID, other)
+		} else {
+			seenIDs[peer.ID] = field
+		}
+
+		endpoint := peer.peerAddress()
+		if other, exists := seenEndpoints[endpoint]; exists {
+			findings.errorf(field, "%s conflicts with %s", endpoint, other)
+		} else {
+			seenEndpoints[endpoint] = field
+		}
+	}
+
+	t := cfg.Tunables
+	if t.TickIntervalMs <= 0 {
+		findings.errorf("tunables.tick_interval_ms", "must be positive")
+	} else if t.TickIntervalMs < 10 {
+		findings.warnf("tunables.tick_interval_ms", "%dms ticks cause heavy CPU and network load", t.TickIntervalMs)
+	} else if t.TickIntervalMs > 1000 {
+		findings.warnf("tunables.tick_interval_ms", "%dms ticks make failover very slow", t.TickIntervalMs)
+	}
+	if t.HeartbeatTick <= 0 {
+		findings.errorf("tunables.heartbeat_tick", "must be positive")
+	}
+	if t.ElectionTick <= t.HeartbeatTick {
+		findings.errorf("tunables.election_tick", "must be greater than tunables.heartbeat_tick")
+	} else if t.ElectionTick < 10*t.HeartbeatTick {
+		findings.warnf("tunables.election_tick", "less than 10 heartbeats per election timeout risks spurious elections")
+	}
+	if t.MaxSizePerMsg == 0 {
+		findings.warnf("tunables.max_size_per_msg", "0 limits append messages to a single entry")
+	}
+	if t.MaxInflightMsgs <= 0 {
+		findings.errorf("tunables.max_inflight_msgs", "must be positive")
+	}
+
+	if cfg.TLS.CertFile != "" && cfg.TLS.KeyFile == "" {
+		findings.errorf("tls.key_file", "must be set when tls.cert_file is set")
+	}
+	if cfg.TLS.CertFile == "" && (cfg.TLS.KeyFile != "" || cfg.TLS.CAFile != "") {
+		findings.warnf("tls.cert_file", "TLS is disabled because cert_file is not set")
+	}
+
+	switch cfg.LogLevel {
+	case "debug", "info", "warning", "error":
+	default:
+		findings.warnf("log_level", "unknown log level %q", cfg.LogLevel)
+	}
+
+	return findings
+}
+
+func checkReadable(findings *configFindings, field, path string) {
+	if path == "" {
+		return
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		findings.errorf(field, "cannot read %s: %v", path, err)
+		return
+	}
+	file.Close()
+}
+
+// Checks against the local environment
+func validateNodeEnvironment(cfg *NodeConfig, findings *configFindings) {
+	before := len(*findings)
+	checkReadable(findings, "tls.cert_file", cfg.TLS.CertFile)
+	checkReadable(findings, "tls.key_file", cfg.TLS.KeyFile)
+	checkReadable(findings, "tls.ca_file", cfg.TLS.CAFile)
+	if len(*findings) == before && cfg.TLS.CertFile != "" && cfg.TLS.KeyFile != "" {
+		if _, err := loadPeerTLSConfig(cfg.TLS); err != nil {
+			findings.errorf("tls", "%v", err)
+		}
+	}
+
+	if cfg.Port > 0 && cfg.Port <= 65535 && validHost(cfg.Address) {
+		listener, err := net.Listen("tcp", cfg.listenAddress())
+		if err != nil {
+			findings.warnf("port", "cannot listen on %s: %v", cfg.listenAddress(), err)
+		} else {
+			listener.Close()
+		}
+	}
+
+	if dir := cfg.Storage.DataDir; dir != "" {
+		if info, err := os.Stat(dir); err != nil {
+			findings.warnf("storage.data_dir", "%v", err)
+		} else if !info.IsDir() {
+			findings.errorf("storage.data_dir", "%s is not a directory", dir)
+		}
+	}
+}
+
+// Validate a configuration document; returns a JSON report to be freed
+// with pgraft_go_free_string()
+//
+//export pgraft_go_validate_config
+func pgraft_go_validate_config(configJSON *C.char) *C.char {
+	var findings configFindings
+
+	if configJSON == nil {
+		findings.errorf("", "no configuration given")
+	} else {
+		data := []byte(C.GoString(configJSON))
+		cfg := defaultNodeConfig()
+		if err := json.Unmarshal(data, cfg); err != nil {
+			findings.errorf("", "invalid configuration JSON: %v", err)
+		} else {
+			// Unknown keys are usually typos of real settings
+			decoder := json.NewDecoder(bytes.NewReader(data))
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(defaultNodeConfig()); err != nil {
+				findings.warnf("", "%s", strings.TrimPrefix(err.Error(), "json: "))
+			}
+
+			findings = append(findings, validateNodeConfig(cfg)...)
+			validateNodeEnvironment(cfg, &findings)
+		}
+	}
+
+	errorCount := 0
+	for _, finding := range findings {
+		if finding.Severity == findingError {
+			errorCount++
+		}
+	}
+	if findings == nil {
+		findings = configFindings{}
+	}
+
+	report := map[string]interface{}{
+		"valid":    errorCount == 0,
+		"errors":   errorCount,
+		"warnings": len(findings) - errorCount,
+		"findings": findings,
+	}
+
+	jsonData, err := json.Marshal(report)
+	if err != nil {
+		return C.CString("{\"error\": \"failed to marshal validation report\"}")
+	}
+	return C.CString(string(jsonData))
+}
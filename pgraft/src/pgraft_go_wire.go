@@ -0,0 +1,102 @@
+/*
+ * pgraft_go_wire.go
+ * Decoding of untrusted input: peer frames, raft messages and snapshots
+ *
+ * Everything read from a peer connection or handed over as a snapshot is
+ * decoded here and nowhere else, so that malformed input is rejected
+ * with an error instead of crashing the PostgreSQL process the library
+ * runs in.  pgraft/wire holds the same decoders for the fuzz targets and
+ * the standalone tools; the two must change together.
+ */
+
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"go.etcd.io/raft/v3"
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+// Largest frame accepted from a peer; a length beyond it means the
+// stream is corrupt or hostile and is never allocated
+const maxFrameSize = 64 * 1024 * 1024
+
+// Read one length-prefixed frame
+func readFrame(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[:])
+	if length > maxFrameSize {
+		return nil, fmt.Errorf("frame of %d bytes exceeds limit of %d", length, maxFrameSize)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// Decode a raft message received from a peer, rejecting messages no peer
+// sends: unknown types, types raft only generates locally, and messages
+// without a sender or recipient
+func decodeMessage(data []byte) (msg raftpb.Message, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			msg = raftpb.Message{}
+			err = fmt.Errorf("malformed message: %v", r)
+		}
+	}()
+
+	if err := msg.Unmarshal(data); err != nil {
+		return raftpb.Message{}, err
+	}
+	if _, known := raftpb.MessageType_name[int32(msg.Type)]; !known {
+		return raftpb.Message{}, fmt.Errorf("unknown message type %d", int32(msg.Type))
+	}
+	if raft.IsLocalMsg(msg.Type) {
+		return raftpb.Message{}, fmt.Errorf("local message type %s from the network", msg.Type)
+	}
+	if msg.From == 0 || msg.To == 0 {
+		return raftpb.Message{}, errors.New("message without sender or recipient")
+	}
+	return msg, nil
+}
+
+// Snapshot as serialized by pgraft_go_create_snapshot()
+type snapshotJSON struct {
+	Index     *uint64 `json:"index"`
+	Term      *uint64 `json:"term"`
+	Data      string  `json:"data"`
+	Timestamp int64   `json:"timestamp"`
+}
+
+// Parse a snapshot produced by pgraft_go_create_snapshot()
+func parseSnapshotJSON(data []byte) (raftpb.Snapshot, error) {
+	var parsed snapshotJSON
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return raftpb.Snapshot{}, err
+	}
+	if parsed.Index == nil || parsed.Term == nil {
+		return raftpb.Snapshot{}, errors.New("snapshot without index or term")
+	}
+	if *parsed.Index > 0 && *parsed.Term == 0 {
+		return raftpb.Snapshot{}, fmt.Errorf("snapshot at index %d without a term", *parsed.Index)
+	}
+	return raftpb.Snapshot{
+		Data: []byte(parsed.Data),
+		Metadata: raftpb.SnapshotMetadata{
+			Index: *parsed.Index,
+			Term:  *parsed.Term,
+		},
+	}, nil
+}
@@ -31,6 +31,7 @@ PG_FUNCTION_INFO_V1(pgraft_init);
 PG_FUNCTION_INFO_V1(pgraft_init_guc);
 PG_FUNCTION_INFO_V1(pgraft_add_node);
 PG_FUNCTION_INFO_V1(pgraft_remove_node);
+PG_FUNCTION_INFO_V1(pgraft_switchover);
 PG_FUNCTION_INFO_V1(pgraft_get_cluster_status_table);
 PG_FUNCTION_INFO_V1(pgraft_get_leader);
 PG_FUNCTION_INFO_V1(pgraft_get_term);
@@ -153,6 +154,27 @@ pgraft_remove_node(PG_FUNCTION_ARGS)
     PG_RETURN_BOOL(true);
 }
 
+/*
+ * Hand leadership to another node; the background worker runs the
+ * switchover, so this only queues it
+ */
+Datum
+pgraft_switchover(PG_FUNCTION_ARGS)
+{
+	int32_t		target_node = PG_GETARG_INT32(0);
+	
+	if (target_node <= 0)
+		elog(ERROR, "pgraft: Invalid switchover target node %d", target_node);
+	
+	elog(INFO, "pgraft: Queuing SWITCHOVER command to node %d", target_node);
+	
+	if (!pgraft_queue_command(COMMAND_SWITCHOVER, target_node, "", 0, NULL)) {
+		elog(ERROR, "pgraft: Failed to queue SWITCHOVER command");
+		PG_RETURN_BOOL(false);
+	}
+	
+	PG_RETURN_BOOL(true);
+}
 
 /*
  * Get cluster status as table with individual columns
@@ -0,0 +1,203 @@
+#!/bin/bash
+
+# pgraft compatibility runner across PostgreSQL major versions
+#
+# For each version given (default 15 16 17) this builds the Go library and
+# the extension against that version's headers, runs the consensus
+# simulation, installs the extension and runs a smoke test on a throwaway
+# single-node server: CREATE EXTENSION, pgraft_test(), and pgraft_init()
+# followed by the node electing itself leader.
+#
+# pg_config is taken from PG_CONFIG_<version> if set, else from the first
+# of /usr/local/pgsql.<version>, /usr/lib/postgresql/<version> and
+# /usr/pgsql-<version> that has one.  Installing needs write access to the
+# server's lib and share directories; set SUDO=sudo if that takes root.
+#
+# Usage: test/pgversions.sh [-runs N] [-strict] [-keep] [-o dir] [version ...]
+#   -runs N   simulation runs per version (default 5)
+#   -strict   fail on versions that are not installed instead of skipping
+#   -keep     keep the smoke test data directories
+#   -o dir    directory for the per-version logs (default pgversions-logs)
+#
+# Exits 0 if every version installed passed and at least one was, 1
+# otherwise.
+
+set -u
+
+RUNS=5
+STRICT=0
+KEEP=0
+LOG_DIR="pgversions-logs"
+SUDO="${SUDO:-}"
+
+while [ $# -gt 0 ]; do
+    case "$1" in
+        -runs) RUNS="$2"; shift 2 ;;
+        -strict) STRICT=1; shift ;;
+        -keep) KEEP=1; shift ;;
+        -o) LOG_DIR="$2"; shift 2 ;;
+        -h|-help|--help) sed -n '3,23p' "$0" | sed 's/^# \{0,1\}//'; exit 0 ;;
+        -*) echo "unknown flag $1" >&2; exit 2 ;;
+        *) break ;;
+    esac
+done
+VERSIONS="${*:-15 16 17}"
+
+cd "$(dirname "$0")/.." || exit 2
+mkdir -p "$LOG_DIR" || exit 2
+LOG_DIR="$(cd "$LOG_DIR" && pwd)"
+
+# Colors for output
+RED='\033[0;31m'
+GREEN='\033[0;32m'
+BLUE='\033[0;34m'
+NC='\033[0m' # No Color
+
+find_pg_config() {
+    local version="$1"
+    local var="PG_CONFIG_$version"
+    if [ -n "${!var:-}" ]; then
+        echo "${!var}"
+        return
+    fi
+    for dir in "/usr/local/pgsql.$version" "/usr/lib/postgresql/$version" "/usr/pgsql-$version"; do
+        if [ -x "$dir/bin/pg_config" ]; then
+            echo "$dir/bin/pg_config"
+            return
+        fi
+    done
+}
+
+# Run one step, sending its output to the version's log
+step() {
+    local log="$1"
+    shift
+    echo "\$ $*" >>"$log"
+    "$@" >>"$log" 2>&1
+}
+
+# Smoke test the installed extension on a new single-node server
+smoke() {
+    local version="$1" pg_config="$2" log="$3"
+    local bindir port raft_port data
+    bindir="$("$pg_config" --bindir)"
+    port=$((55400 + version))
+    raft_port=$((7500 + version))
+    data="$(mktemp -d "${TMPDIR:-/tmp}/pgraft-pg$version.XXXXXX")"
+
+    step "$log" "$bindir/initdb" -D "$data/pgdata" -A trust -U postgres || return 1
+    cat >>"$data/pgdata/postgresql.conf" <<EOF
+port = $port
+listen_addresses = ''
+unix_socket_directories = '$data'
+shared_preload_libraries = 'pgraft'
+pgraft.node_id = 1
+pgraft.address = '127.0.0.1'
+pgraft.port = $raft_port
+pgraft.cluster_size = 1
+EOF
+    if ! step "$log" "$bindir/pg_ctl" -D "$data/pgdata" -l "$data/server.log" -w -t 60 start; then
+        cat "$data/server.log" >>"$log" 2>/dev/null
+        return 1
+    fi
+
+    local psql=("$bindir/psql" -X -q -A -t -v ON_ERROR_STOP=1 -h "$data" -p "$port" -U postgres -d postgres)
+    local status=0
+    if ! step "$log" "${psql[@]}" -c "CREATE EXTENSION pgraft" \
+        -c "SELECT pgraft_get_version()"; then
+        status=1
+    elif [ "$("${psql[@]}" -c "SELECT pgraft_test()" 2>>"$log")" != "t" ]; then
+        echo "pgraft_test() did not return true" >>"$log"
+        status=1
+    elif ! step "$log" "${psql[@]}" -c "SELECT pgraft_init()"; then
+        status=1
+    else
+        local leader=""
+        for _ in $(seq 1 30); do
+            leader="$("${psql[@]}" -c "SELECT pgraft_is_leader()" 2>>"$log")"
+            [ "$leader" = "t" ] && break
+            sleep 1
+        done
+        if [ "$leader" != "t" ]; then
+            echo "node did not become leader within 30s" >>"$log"
+            status=1
+        fi
+    fi
+
+    step "$log" "$bindir/pg_ctl" -D "$data/pgdata" -m fast -w stop
+    if grep -q "was terminated by signal" "$data/server.log"; then
+        echo "a server process crashed" >>"$log"
+        status=1
+    fi
+    echo "--- server log ---" >>"$log"
+    cat "$data/server.log" >>"$log"
+    if [ "$KEEP" = 1 ]; then
+        echo "data directory kept in $data" >>"$log"
+    else
+        rm -rf "$data"
+    fi
+    return $status
+}
+
+# Build, simulate and smoke test one version; prints its result row
+run_version() {
+    local version="$1" log="$LOG_DIR/pg$version.log"
+    local pg_config build=- sim=- smoke_result=-
+    : >"$log"
+
+    pg_config="$(find_pg_config "$version")"
+    if [ -z "$pg_config" ]; then
+        echo "no pg_config found for PostgreSQL $version" >>"$log"
+        printf "%-8s %-8s %-8s %-8s %s\n" "$version" "$build" "$sim" "$smoke_result" "not installed"
+        [ "$STRICT" = 1 ] && return 1
+        return 0
+    fi
+    tested=$((tested + 1))
+    local found
+    found="$("$pg_config" --version | sed -E 's/^PostgreSQL ([0-9]+).*/\1/')"
+    if [ "$found" != "$version" ]; then
+        echo "$pg_config is PostgreSQL $found, not $version" >>"$log"
+        printf "%-8s %-8s %-8s %-8s %s\n" "$version" "$build" "$sim" "$smoke_result" "$pg_config is $found"
+        return 1
+    fi
+
+    build=FAIL
+    if step "$log" make clean PG_CONFIG="$pg_config" &&
+        step "$log" make PG_CONFIG="$pg_config" &&
+        step "$log" $SUDO make install PG_CONFIG="$pg_config"; then
+        build=ok
+    fi
+    sim=FAIL
+    if step "$log" make sim PG_CONFIG="$pg_config" SIMFLAGS="-runs $RUNS"; then
+        sim=ok
+    fi
+    if [ "$build" = ok ]; then
+        smoke_result=FAIL
+        if smoke "$version" "$pg_config" "$log"; then
+            smoke_result=ok
+        fi
+    fi
+
+    printf "%-8s %-8s %-8s %-8s %s\n" "$version" "$build" "$sim" "$smoke_result" "$log"
+    [ "$build" = ok ] && [ "$sim" = ok ] && [ "$smoke_result" = ok ]
+}
+
+echo -e "${BLUE}=== pgraft PostgreSQL version matrix: $VERSIONS ===${NC}"
+printf "%-8s %-8s %-8s %-8s %s\n" "VERSION" "BUILD" "SIM" "SMOKE" "LOG"
+failed=""
+tested=0
+for version in $VERSIONS; do
+    if ! run_version "$version"; then
+        failed="$failed $version"
+    fi
+done
+
+if [ -n "$failed" ]; then
+    echo -e "${RED}Failed on PostgreSQL$failed${NC}"
+    exit 1
+fi
+if [ "$tested" = 0 ]; then
+    echo -e "${RED}None of the versions is installed${NC}"
+    exit 1
+fi
+echo -e "${GREEN}Passed on every installed version${NC}"
@@ -0,0 +1,329 @@
+/*
+ * format.go
+ * Reading pgraft trace files
+ *
+ * A trace is written by the library (pgraft_go_trace.go) while a node
+ * runs: the magic "PGRTRACE" and a 4-byte version, then records of a kind
+ * byte, an 8-byte Unix time in nanoseconds, a 4-byte length and the
+ * payload, all big-endian.  The library is built separately, so the
+ * format is defined in both places and must change in both.
+ */
+
+package trace
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"go.etcd.io/raft/v3"
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+const (
+	magic   = "PGRTRACE"
+	version = 1
+
+	// Upper bound on a record payload, against reading garbage
+	maxPayload = 256 * 1024 * 1024
+)
+
+type Kind byte
+
+// Record kinds, as written by pgraft_go_trace.go
+const (
+	KindStart             Kind = 1
+	KindStorage           Kind = 2
+	KindTick              Kind = 3
+	KindStep              Kind = 4
+	KindPropose           Kind = 5
+	KindProposeConfChange Kind = 6
+	KindCampaign          Kind = 7
+	KindTransfer          Kind = 8
+	KindApplyConfChange   Kind = 9
+	KindReady             Kind = 10
+	KindUnreachable       Kind = 11
+	KindSnapshotStatus    Kind = 12
+	KindReadIndex         Kind = 13
+	KindForgetLeader      Kind = 14
+)
+
+var kindNames = map[Kind]string{
+	KindStart:             "start",
+	KindStorage:           "storage",
+	KindTick:              "tick",
+	KindStep:              "step",
+	KindPropose:           "propose",
+	KindProposeConfChange: "propose-conf-change",
+	KindCampaign:          "campaign",
+	KindTransfer:          "transfer",
+	KindApplyConfChange:   "apply-conf-change",
+	KindReady:             "ready",
+	KindUnreachable:       "unreachable",
+	KindSnapshotStatus:    "snapshot-status",
+	KindReadIndex:         "read-index",
+	KindForgetLeader:      "forget-leader",
+}
+
+func (k Kind) String() string {
+	if name, known := kindNames[k]; known {
+		return name
+	}
+	return fmt.Sprintf("kind-%d", byte(k))
+}
+
+type Record struct {
+	Kind    Kind
+	Time    time.Time
+	Payload []byte
+}
+
+// Configuration the traced node was created with; Peers is empty if it
+// was restarted from storage rather than bootstrapped
+type Start struct {
+	NodeID          uint64 `json:"node_id"`
+	ElectionTick    int    `json:"election_tick"`
+	HeartbeatTick   int    `json:"heartbeat_tick"`
+	MaxSizePerMsg   uint64 `json:"max_size_per_msg"`
+	MaxInflightMsgs int    `json:"max_inflight_msgs"`
+	PreVote         bool   `json:"pre_vote"`
+	CheckQuorum     bool   `json:"check_quorum"`
+	Applied         uint64 `json:"applied"`
+	Peers           []Peer `json:"peers,omitempty"`
+}
+
+// Member a bootstrapped node was created with
+type Peer struct {
+	ID      uint64 `json:"id"`
+	Context []byte `json:"context,omitempty"`
+}
+
+// Contents of storage when the traced node was created
+type Storage struct {
+	Snapshot  raftpb.Snapshot
+	HardState raftpb.HardState
+	Entries   []raftpb.Entry
+}
+
+// One Ready batch the traced node produced
+type Ready struct {
+	Lead             uint64
+	State            string
+	HardState        raftpb.HardState
+	Entries          []raftpb.Entry
+	CommittedEntries []raftpb.Entry
+	Messages         []raftpb.Message
+	Snapshot         raftpb.Snapshot
+}
+
+type rawStorage struct {
+	Snapshot  []byte   `json:"snapshot"`
+	HardState []byte   `json:"hard_state"`
+	Entries   [][]byte `json:"entries"`
+}
+
+type rawReady struct {
+	Lead             uint64   `json:"lead,omitempty"`
+	State            string   `json:"state,omitempty"`
+	HardState        []byte   `json:"hard_state,omitempty"`
+	Entries          [][]byte `json:"entries,omitempty"`
+	CommittedEntries [][]byte `json:"committed_entries,omitempty"`
+	Messages         [][]byte `json:"messages,omitempty"`
+	Snapshot         []byte   `json:"snapshot,omitempty"`
+}
+
+type Reader struct {
+	r *bufio.Reader
+}
+
+// Reader of the trace in r; fails unless r starts with a trace header
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	var header [12]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		return nil, fmt.Errorf("reading trace header: %w", err)
+	}
+	if string(header[:8]) != magic {
+		return nil, errors.New("not a pgraft trace")
+	}
+	if v := binary.BigEndian.Uint32(header[8:]); v != version {
+		return nil, fmt.Errorf("unsupported trace version %d", v)
+	}
+	return &Reader{r: br}, nil
+}
+
+// Next record; io.EOF at the end of the trace.  A record cut short, as
+// the last one is when the process died while writing it, is reported as
+// io.ErrUnexpectedEOF
+func (tr *Reader) Next() (Record, error) {
+	var header [13]byte
+	n, err := io.ReadFull(tr.r, header[:])
+	if err == io.EOF {
+		return Record{}, io.EOF
+	}
+	if err != nil {
+		if n > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return Record{}, err
+	}
+	length := binary.BigEndian.Uint32(header[9:])
+	if length > maxPayload {
+		return Record{}, fmt.Errorf("record of %d bytes exceeds limit", length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(tr.r, payload); err != nil {
+		return Record{}, io.ErrUnexpectedEOF
+	}
+	return Record{
+		Kind:    Kind(header[0]),
+		Time:    time.Unix(0, int64(binary.BigEndian.Uint64(header[1:]))),
+		Payload: payload,
+	}, nil
+}
+
+// Every record of the trace at path; a truncated last record is dropped
+func ReadFile(path string) ([]Record, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	tr, err := NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	var records []Record
+	for {
+		record, err := tr.Next()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, record)
+	}
+}
+
+func (rec Record) Start() (Start, error) {
+	var start Start
+	err := json.Unmarshal(rec.Payload, &start)
+	return start, err
+}
+
+func (rec Record) Storage() (Storage, error) {
+	var raw rawStorage
+	var storage Storage
+	if err := json.Unmarshal(rec.Payload, &raw); err != nil {
+		return storage, err
+	}
+	if err := storage.Snapshot.Unmarshal(raw.Snapshot); err != nil {
+		return storage, fmt.Errorf("snapshot: %w", err)
+	}
+	if err := storage.HardState.Unmarshal(raw.HardState); err != nil {
+		return storage, fmt.Errorf("hard state: %w", err)
+	}
+	entries, err := unmarshalEntries(raw.Entries)
+	storage.Entries = entries
+	return storage, err
+}
+
+func (rec Record) Ready() (Ready, error) {
+	var raw rawReady
+	var ready Ready
+	if err := json.Unmarshal(rec.Payload, &raw); err != nil {
+		return ready, err
+	}
+	ready.Lead = raw.Lead
+	ready.State = raw.State
+	if err := ready.HardState.Unmarshal(raw.HardState); err != nil {
+		return ready, fmt.Errorf("hard state: %w", err)
+	}
+	if err := ready.Snapshot.Unmarshal(raw.Snapshot); err != nil {
+		return ready, fmt.Errorf("snapshot: %w", err)
+	}
+	var err error
+	if ready.Entries, err = unmarshalEntries(raw.Entries); err != nil {
+		return ready, err
+	}
+	if ready.CommittedEntries, err = unmarshalEntries(raw.CommittedEntries); err != nil {
+		return ready, err
+	}
+	for _, data := range raw.Messages {
+		var msg raftpb.Message
+		if err := msg.Unmarshal(data); err != nil {
+			return ready, fmt.Errorf("message: %w", err)
+		}
+		ready.Messages = append(ready.Messages, msg)
+	}
+	return ready, nil
+}
+
+// Message of a step record
+func (rec Record) Message() (raftpb.Message, error) {
+	var msg raftpb.Message
+	err := msg.Unmarshal(rec.Payload)
+	return msg, err
+}
+
+// Configuration change of a propose-conf-change or apply-conf-change
+// record
+func (rec Record) ConfChange() (raftpb.ConfChangeI, error) {
+	if len(rec.Payload) == 0 {
+		return nil, errors.New("empty conf change")
+	}
+	switch rec.Payload[0] {
+	case 1:
+		var cc raftpb.ConfChange
+		err := cc.Unmarshal(rec.Payload[1:])
+		return cc, err
+	case 2:
+		var cc raftpb.ConfChangeV2
+		err := cc.Unmarshal(rec.Payload[1:])
+		return cc, err
+	}
+	return nil, fmt.Errorf("unknown conf change version %d", rec.Payload[0])
+}
+
+// Leader and transferee of a transfer record
+func (rec Record) Transfer() (lead, transferee uint64, err error) {
+	if len(rec.Payload) != 16 {
+		return 0, 0, errors.New("malformed transfer record")
+	}
+	return binary.BigEndian.Uint64(rec.Payload[:8]), binary.BigEndian.Uint64(rec.Payload[8:]), nil
+}
+
+// Peer of an unreachable or snapshot-status record
+func (rec Record) Peer() (uint64, error) {
+	if len(rec.Payload) < 8 {
+		return 0, errors.New("malformed peer record")
+	}
+	return binary.BigEndian.Uint64(rec.Payload[:8]), nil
+}
+
+// Status of a snapshot-status record
+func (rec Record) SnapshotStatus() (raft.SnapshotStatus, error) {
+	if len(rec.Payload) != 9 {
+		return 0, errors.New("malformed snapshot status record")
+	}
+	return raft.SnapshotStatus(rec.Payload[8]), nil
+}
+
+func unmarshalEntries(raw [][]byte) ([]raftpb.Entry, error) {
+	entries := make([]raftpb.Entry, 0, len(raw))
+	for _, data := range raw {
+		var entry raftpb.Entry
+		if err := entry.Unmarshal(data); err != nil {
+			return entries, fmt.Errorf("entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
@@ -0,0 +1,440 @@
+/*
+ * replay.go
+ * Deterministic replay of a pgraft trace
+ *
+ * Replay builds a fresh raft node from the configuration and storage the
+ * trace starts with, feeds it the recorded inputs in order, and compares
+ * what it produces with what the traced node produced: the entries it
+ * appended, the entries it committed and the messages it sent.  Only the
+ * order of each stream is compared, not how the traced node happened to
+ * batch it into Ready structs, since that depends on goroutine timing.
+ *
+ * Raft randomizes each node's election timeout from a source that cannot
+ * be seeded.  A tick after which the traced node started an election is
+ * therefore replayed as a campaign; but the replayed node may still time
+ * out earlier than the traced one did, which shows up as a divergence at
+ * a vote request.  Such runs are retried, up to Options.Attempts times,
+ * before the divergence is reported.
+ */
+
+package trace
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"go.etcd.io/raft/v3"
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+// Raft's own logging is too verbose for replays
+var discardLogger = &raft.DefaultLogger{Logger: log.New(io.Discard, "", 0)}
+
+type Options struct {
+	// Runs allowed when the replayed node starts an election on its own
+	// timer; 0 means 5
+	Attempts int
+
+	// Called with every record as it is replayed
+	OnRecord func(index int, record Record)
+}
+
+// First output of the replay that differs from the trace
+type Divergence struct {
+	// Index in the trace of the last input replayed, and its time
+	Record int
+	Time   time.Time
+
+	// "appended", "committed" or "sent", the position in that stream,
+	// and the output of the traced and the replayed node; an empty
+	// Replayed means the replayed node produced nothing there
+	Stream   string
+	Position int
+	Traced   string
+	Replayed string
+}
+
+func (d *Divergence) Error() string {
+	replayed := d.Replayed
+	if replayed == "" {
+		replayed = "nothing"
+	}
+	return fmt.Sprintf("record %d: %s output %d differs: traced %s, replayed %s",
+		d.Record, d.Stream, d.Position, d.Traced, replayed)
+}
+
+type Report struct {
+	NodeID   uint64
+	Records  int
+	Inputs   int
+	Readies  int
+	Attempts int
+
+	// Outputs compared in each stream
+	Appended  int
+	Committed int
+	Sent      int
+
+	// nil if the replay matched the trace
+	Divergence *Divergence
+}
+
+const (
+	streamAppended = iota
+	streamCommitted
+	streamSent
+	streamCount
+)
+
+var streamNames = [streamCount]string{"appended", "committed", "sent"}
+
+type replayer struct {
+	start   Start
+	raw     *raft.RawNode
+	storage *raft.MemoryStorage
+
+	traced   [streamCount][]string
+	replayed [streamCount][]string
+	compared [streamCount]int
+}
+
+// Replay records, which must begin with the start and storage records
+// the library writes first
+func Replay(records []Record, opts Options) (*Report, error) {
+	if len(records) < 2 || records[0].Kind != KindStart || records[1].Kind != KindStorage {
+		return nil, errors.New("trace does not begin with start and storage records")
+	}
+	if opts.Attempts <= 0 {
+		opts.Attempts = 5
+	}
+	campaigns, err := electionTicks(records)
+	if err != nil {
+		return nil, err
+	}
+
+	var report *Report
+	for attempt := 1; attempt <= opts.Attempts; attempt++ {
+		report, err = replayOnce(records, campaigns, opts)
+		if err != nil {
+			return nil, err
+		}
+		report.Attempts = attempt
+		if report.Divergence == nil || !isSelfVote(report.Divergence.Replayed) {
+			break
+		}
+		opts.OnRecord = nil
+	}
+	return report, nil
+}
+
+func replayOnce(records []Record, campaigns map[int]bool, opts Options) (*Report, error) {
+	start, err := records[0].Start()
+	if err != nil {
+		return nil, fmt.Errorf("start record: %w", err)
+	}
+	rp, err := newReplayer(start, records[1])
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{NodeID: start.NodeID, Records: len(records)}
+	for i, record := range records {
+		if opts.OnRecord != nil {
+			opts.OnRecord(i, record)
+		}
+		if i < 2 {
+			continue
+		}
+		if record.Kind == KindReady {
+			report.Readies++
+			if err := rp.addTraced(record); err != nil {
+				return nil, fmt.Errorf("record %d: %w", i, err)
+			}
+		} else {
+			report.Inputs++
+			if err := rp.input(record, campaigns[i]); err != nil {
+				return nil, fmt.Errorf("record %d: %w", i, err)
+			}
+			if err := rp.drain(); err != nil {
+				return nil, fmt.Errorf("record %d: %w", i, err)
+			}
+		}
+		if d := rp.compare(); d != nil {
+			d.Record = i
+			d.Time = record.Time
+			report.Divergence = d
+			break
+		}
+	}
+
+	// Everything the traced node produced must have been reproduced;
+	// the replay may be ahead of a trace that ended mid-batch
+	if report.Divergence == nil {
+		for s := 0; s < streamCount; s++ {
+			if len(rp.traced[s]) > len(rp.replayed[s]) {
+				report.Divergence = &Divergence{
+					Record:   len(records) - 1,
+					Time:     records[len(records)-1].Time,
+					Stream:   streamNames[s],
+					Position: len(rp.replayed[s]),
+					Traced:   rp.traced[s][len(rp.replayed[s])],
+				}
+				break
+			}
+		}
+	}
+	report.Appended = rp.compared[streamAppended]
+	report.Committed = rp.compared[streamCommitted]
+	report.Sent = rp.compared[streamSent]
+	return report, nil
+}
+
+func newReplayer(start Start, storageRecord Record) (*replayer, error) {
+	dump, err := storageRecord.Storage()
+	if err != nil {
+		return nil, fmt.Errorf("storage record: %w", err)
+	}
+	storage := raft.NewMemoryStorage()
+	if !raft.IsEmptySnap(dump.Snapshot) {
+		if err := storage.ApplySnapshot(dump.Snapshot); err != nil {
+			return nil, fmt.Errorf("restoring snapshot: %w", err)
+		}
+	}
+	if err := storage.SetHardState(dump.HardState); err != nil {
+		return nil, fmt.Errorf("restoring hard state: %w", err)
+	}
+	if err := storage.Append(dump.Entries); err != nil {
+		return nil, fmt.Errorf("restoring entries: %w", err)
+	}
+
+	raw, err := raft.NewRawNode(&raft.Config{
+		ID:              start.NodeID,
+		ElectionTick:    start.ElectionTick,
+		HeartbeatTick:   start.HeartbeatTick,
+		Storage:         storage,
+		Applied:         start.Applied,
+		MaxSizePerMsg:   start.MaxSizePerMsg,
+		MaxInflightMsgs: start.MaxInflightMsgs,
+		PreVote:         start.PreVote,
+		CheckQuorum:     start.CheckQuorum,
+		Logger:          discardLogger,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating node %d: %w", start.NodeID, err)
+	}
+	if len(start.Peers) > 0 {
+		peers := make([]raft.Peer, 0, len(start.Peers))
+		for _, peer := range start.Peers {
+			peers = append(peers, raft.Peer{ID: peer.ID, Context: peer.Context})
+		}
+		if err := raw.Bootstrap(peers); err != nil {
+			return nil, fmt.Errorf("bootstrapping node %d: %w", start.NodeID, err)
+		}
+	}
+	return &replayer{start: start, raw: raw, storage: storage}, nil
+}
+
+// Feed one recorded input to the node.  Errors the traced node got back
+// (a dropped proposal, a message from an unknown peer) are expected to
+// recur and are ignored
+func (rp *replayer) input(record Record, campaign bool) error {
+	switch record.Kind {
+	case KindTick:
+		if campaign {
+			rp.raw.Campaign()
+		} else {
+			rp.raw.Tick()
+		}
+	case KindCampaign:
+		rp.raw.Campaign()
+	case KindStep:
+		msg, err := record.Message()
+		if err != nil {
+			return err
+		}
+		rp.raw.Step(msg)
+	case KindPropose:
+		rp.raw.Propose(record.Payload)
+	case KindProposeConfChange:
+		cc, err := record.ConfChange()
+		if err != nil {
+			return err
+		}
+		rp.raw.ProposeConfChange(cc)
+	case KindApplyConfChange:
+		cc, err := record.ConfChange()
+		if err != nil {
+			return err
+		}
+		rp.raw.ApplyConfChange(cc)
+	case KindTransfer:
+		_, transferee, err := record.Transfer()
+		if err != nil {
+			return err
+		}
+		rp.raw.TransferLeader(transferee)
+	case KindUnreachable:
+		peer, err := record.Peer()
+		if err != nil {
+			return err
+		}
+		rp.raw.ReportUnreachable(peer)
+	case KindSnapshotStatus:
+		peer, err := record.Peer()
+		if err != nil {
+			return err
+		}
+		status, err := record.SnapshotStatus()
+		if err != nil {
+			return err
+		}
+		rp.raw.ReportSnapshot(peer, status)
+	case KindReadIndex:
+		rp.raw.ReadIndex(record.Payload)
+	case KindForgetLeader:
+		rp.raw.ForgetLeader()
+	default:
+		return fmt.Errorf("unexpected %s record", record.Kind)
+	}
+	return nil
+}
+
+// Persist and collect every Ready the node has
+func (rp *replayer) drain() error {
+	for rp.raw.HasReady() {
+		rd := rp.raw.Ready()
+		if !raft.IsEmptySnap(rd.Snapshot) {
+			if err := rp.storage.ApplySnapshot(rd.Snapshot); err != nil {
+				return fmt.Errorf("applying snapshot: %w", err)
+			}
+		}
+		if err := rp.storage.Append(rd.Entries); err != nil {
+			return fmt.Errorf("appending entries: %w", err)
+		}
+		if !raft.IsEmptyHardState(rd.HardState) {
+			if err := rp.storage.SetHardState(rd.HardState); err != nil {
+				return fmt.Errorf("setting hard state: %w", err)
+			}
+		}
+		collect(&rp.replayed, rd.Entries, rd.CommittedEntries, rd.Messages)
+		rp.raw.Advance(rd)
+	}
+	return nil
+}
+
+func (rp *replayer) addTraced(record Record) error {
+	ready, err := record.Ready()
+	if err != nil {
+		return err
+	}
+	collect(&rp.traced, ready.Entries, ready.CommittedEntries, ready.Messages)
+	return nil
+}
+
+func collect(streams *[streamCount][]string, appended, committed []raftpb.Entry, sent []raftpb.Message) {
+	for _, entry := range appended {
+		streams[streamAppended] = append(streams[streamAppended], describeEntry(entry))
+	}
+	for _, entry := range committed {
+		streams[streamCommitted] = append(streams[streamCommitted], describeEntry(entry))
+	}
+	for _, msg := range sent {
+		streams[streamSent] = append(streams[streamSent], describeMessage(msg))
+	}
+}
+
+// Compare the outputs both nodes have produced so far
+func (rp *replayer) compare() *Divergence {
+	for s := 0; s < streamCount; s++ {
+		traced, replayed := rp.traced[s], rp.replayed[s]
+		for rp.compared[s] < len(traced) && rp.compared[s] < len(replayed) {
+			pos := rp.compared[s]
+			if traced[pos] != replayed[pos] {
+				return &Divergence{
+					Stream:   streamNames[s],
+					Position: pos,
+					Traced:   traced[pos],
+					Replayed: replayed[pos],
+				}
+			}
+			rp.compared[s]++
+		}
+	}
+	return nil
+}
+
+func describeEntry(entry raftpb.Entry) string {
+	return fmt.Sprintf("%s index=%d term=%d size=%d", entry.Type, entry.Index, entry.Term, len(entry.Data))
+}
+
+func describeMessage(msg raftpb.Message) string {
+	return fmt.Sprintf("%s to=%d term=%d index=%d logterm=%d commit=%d reject=%t entries=%d",
+		msg.Type, msg.To, msg.Term, msg.Index, msg.LogTerm, msg.Commit, msg.Reject, len(msg.Entries))
+}
+
+// True if a replayed output is a request for votes, which the node only
+// sends when it starts an election
+func isSelfVote(description string) bool {
+	return strings.HasPrefix(description, raftpb.MsgVote.String()+" ") ||
+		strings.HasPrefix(description, raftpb.MsgPreVote.String()+" ")
+}
+
+// Ticks after which the traced node started an election on its own: the
+// last tick before a Ready showing the node asking for (pre-)votes, or
+// leaving the follower state in a single-node cluster, unless a campaign,
+// transfer or timeout-now request came after it
+func electionTicks(records []Record) (map[int]bool, error) {
+	start, err := records[0].Start()
+	if err != nil {
+		return nil, fmt.Errorf("start record: %w", err)
+	}
+	election := raftpb.MsgVote
+	if start.PreVote {
+		election = raftpb.MsgPreVote
+	}
+	ticks := make(map[int]bool)
+	lastTick := -1
+	explicit := false
+	follower := true
+
+	for i, record := range records {
+		switch record.Kind {
+		case KindTick:
+			lastTick = i
+		case KindCampaign, KindTransfer:
+			explicit = true
+		case KindStep:
+			if msg, err := record.Message(); err == nil && msg.Type == raftpb.MsgTimeoutNow {
+				explicit = true
+			}
+		case KindReady:
+			ready, err := record.Ready()
+			if err != nil {
+				return nil, fmt.Errorf("record %d: %w", i, err)
+			}
+			started := false
+			for _, msg := range ready.Messages {
+				if msg.Type == election && msg.From == start.NodeID {
+					started = true
+				}
+			}
+			if ready.State != "" {
+				if follower && ready.State != raft.StateFollower.String() && len(ready.Messages) == 0 {
+					started = true
+				}
+				follower = ready.State == raft.StateFollower.String()
+			}
+			if started {
+				if !explicit && lastTick >= 0 {
+					ticks[lastTick] = true
+				}
+				explicit = false
+			}
+		}
+	}
+	return ticks, nil
+}
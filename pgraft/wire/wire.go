@@ -0,0 +1,121 @@
+/*
+ * wire.go
+ * Decoders for pgraft's network and snapshot formats
+ *
+ * Peers exchange raft messages as frames of a 4-byte big-endian length
+ * followed by the marshaled raftpb.Message; snapshots are handed to and
+ * from PostgreSQL as JSON.  These are the decoders the library runs on
+ * untrusted input (pgraft_go_wire.go) and must stay identical to them:
+ * the library is built as a separate cgo module, so the fuzz targets and
+ * tools exercise this copy.
+ */
+
+package wire
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"go.etcd.io/raft/v3"
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+// Largest frame accepted from a peer; a length beyond it means the
+// stream is corrupt or hostile and is never allocated
+const MaxFrameSize = 64 * 1024 * 1024
+
+// Read one length-prefixed frame
+func ReadFrame(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[:])
+	if length > MaxFrameSize {
+		return nil, fmt.Errorf("frame of %d bytes exceeds limit of %d", length, MaxFrameSize)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// Append payload to dst as one frame
+func AppendFrame(dst, payload []byte) []byte {
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(payload)))
+	return append(dst, payload...)
+}
+
+// Decode a raft message received from a peer, rejecting messages no peer
+// sends: unknown types, types raft only generates locally, and messages
+// without a sender or recipient
+func DecodeMessage(data []byte) (msg raftpb.Message, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			msg = raftpb.Message{}
+			err = fmt.Errorf("malformed message: %v", r)
+		}
+	}()
+
+	if err := msg.Unmarshal(data); err != nil {
+		return raftpb.Message{}, err
+	}
+	if _, known := raftpb.MessageType_name[int32(msg.Type)]; !known {
+		return raftpb.Message{}, fmt.Errorf("unknown message type %d", int32(msg.Type))
+	}
+	if raft.IsLocalMsg(msg.Type) {
+		return raftpb.Message{}, fmt.Errorf("local message type %s from the network", msg.Type)
+	}
+	if msg.From == 0 || msg.To == 0 {
+		return raftpb.Message{}, errors.New("message without sender or recipient")
+	}
+	return msg, nil
+}
+
+// Snapshot as serialized by pgraft_go_create_snapshot()
+type snapshotJSON struct {
+	Index     *uint64 `json:"index"`
+	Term      *uint64 `json:"term"`
+	Data      string  `json:"data"`
+	Timestamp int64   `json:"timestamp"`
+}
+
+// Parse a snapshot produced by pgraft_go_create_snapshot()
+func ParseSnapshot(data []byte) (raftpb.Snapshot, error) {
+	var parsed snapshotJSON
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return raftpb.Snapshot{}, err
+	}
+	if parsed.Index == nil || parsed.Term == nil {
+		return raftpb.Snapshot{}, errors.New("snapshot without index or term")
+	}
+	if *parsed.Index > 0 && *parsed.Term == 0 {
+		return raftpb.Snapshot{}, fmt.Errorf("snapshot at index %d without a term", *parsed.Index)
+	}
+	return raftpb.Snapshot{
+		Data: []byte(parsed.Data),
+		Metadata: raftpb.SnapshotMetadata{
+			Index: *parsed.Index,
+			Term:  *parsed.Term,
+		},
+	}, nil
+}
+
+// Serialize a snapshot the way pgraft_go_create_snapshot() does
+func FormatSnapshot(snapshot raftpb.Snapshot) ([]byte, error) {
+	index, term := snapshot.Metadata.Index, snapshot.Metadata.Term
+	return json.Marshal(snapshotJSON{
+		Index:     &index,
+		Term:      &term,
+		Data:      string(snapshot.Data),
+		Timestamp: time.Now().Unix(),
+	})
+}
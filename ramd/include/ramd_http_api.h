@@ -145,6 +145,19 @@ void ramd_http_handle_remove_node(ramd_http_request_t* request, ramd_http_respon
 void ramd_http_handle_cluster_health(ramd_http_request_t* request, ramd_http_response_t* response);
 void ramd_http_handle_cluster_notify(ramd_http_request_t* request, ramd_http_response_t* response);
 
+/* Probe endpoints, which need no credentials and answer 200 or 503 */
+typedef enum
+{
+	RAMD_PROBE_HEALTH,    /* PostgreSQL accepts connections */
+	RAMD_PROBE_READINESS, /* ... and is primary only if raft made it leader */
+	RAMD_PROBE_LEADER,    /* ... as the raft leader's primary */
+	RAMD_PROBE_REPLICA    /* ... as a replica */
+} ramd_probe_t;
+
+void ramd_http_handle_liveness(ramd_http_request_t* request, ramd_http_response_t* response);
+void ramd_http_handle_probe(ramd_http_request_t* request, ramd_http_response_t* response,
+                            ramd_probe_t probe);
+
 /* Utility functions */
 char* ramd_http_get_query_param(const char* query_string,
                                 const char* param_name);
@@ -92,6 +92,13 @@ extern int ramd_pgraft_add_node(PGconn* conn, int node_id, const char* hostname,
  */
 extern int ramd_pgraft_remove_node(PGconn* conn, int node_id);
 
+/*
+ * Hand Raft leadership to a node; conn must be to the current leader,
+ * whose background worker then runs the switchover
+ * Returns: RAMD_PGRAFT_SUCCESS once queued, error code on failure
+ */
+extern int ramd_pgraft_switchover(PGconn* conn, int target_node_id);
+
 /*
  * Get cluster health information
  * Returns: JSON string with health information, or NULL on error
@@ -39,12 +39,6 @@
 #include "ramd_metrics.h"
 
 /* Stub functions for missing API handlers */
-bool ramd_api_handle_switchover(ramd_http_request_t* request __attribute__((unused)), ramd_http_response_t* response) {
-    response->status = 200;
-    strcpy(response->body, "{\"status\":\"switchover_initiated\"}");
-    return true;
-}
-
 bool ramd_api_handle_config_get(ramd_http_request_t* request __attribute__((unused)), ramd_http_response_t* response) {
     response->status = 200;
     strcpy(response->body, "{\"config\":{}}");
@@ -742,6 +736,16 @@ ramd_http_route_request(ramd_http_request_t *request, ramd_http_response_t *resp
 		ramd_http_handle_metrics(request, response);
 	else if (strcmp(request->path, "/prometheus") == 0)
 		ramd_http_handle_prometheus_metrics(request, response);
+	else if (strcmp(request->path, "/liveness") == 0)
+		ramd_http_handle_liveness(request, response);
+	else if (strcmp(request->path, "/health") == 0)
+		ramd_http_handle_probe(request, response, RAMD_PROBE_HEALTH);
+	else if (strcmp(request->path, "/readiness") == 0)
+		ramd_http_handle_probe(request, response, RAMD_PROBE_READINESS);
+	else if (strcmp(request->path, "/leader") == 0)
+		ramd_http_handle_probe(request, response, RAMD_PROBE_LEADER);
+	else if (strcmp(request->path, "/replica") == 0)
+		ramd_http_handle_probe(request, response, RAMD_PROBE_REPLICA);
 	else if (strcmp(request->path, "/api/v1/security/status") == 0)
 		ramd_http_handle_security_status(request, response);
 	else if (strcmp(request->path, "/api/v1/security/audit") == 0)
@@ -876,6 +880,7 @@ ramd_http_send_response(int client_fd, ramd_http_response_t *response)
 						   response->status == RAMD_HTTP_400_BAD_REQUEST ? "Bad Request" :
 						   response->status == RAMD_HTTP_404_NOT_FOUND ? "Not Found" :
 						   response->status == RAMD_HTTP_500_INTERNAL_ERROR ? "Internal Server Error" :
+						   response->status == RAMD_HTTP_503_SERVICE_UNAVAILABLE ? "Service Unavailable" :
 						   "Unknown",
 						   strlen(response->content_type) > 0 ? response->content_type : "application/json",
 						   response->body_length,
@@ -2126,6 +2131,106 @@ ramd_http_handle_remove_node(ramd_http_request_t* request __attribute__((unused)
 	ramd_http_set_json_response(response, RAMD_HTTP_200_OK, json_response);
 }
 
+/*
+ * Hand Raft leadership to the node named by target_node, its hostname.
+ * The leader's pgraft worker runs the switchover, so the request is made
+ * on the leader; the reply only says it has started, and the new leader
+ * shows in GET /api/v1/cluster/status once it is done.  An unhealthy
+ * target is refused unless force is "true".
+ */
+bool
+ramd_api_handle_switchover(ramd_http_request_t* request, ramd_http_response_t* response)
+{
+	if (request->method != RAMD_HTTP_POST)
+	{
+		ramd_http_set_error_response(response, RAMD_HTTP_405_METHOD_NOT_ALLOWED, "Method not allowed");
+		return false;
+	}
+
+	json_t* json = json_loads(request->body, 0, NULL);
+	if (!json)
+	{
+		ramd_http_set_error_response(response, RAMD_HTTP_400_BAD_REQUEST, "Invalid JSON");
+		return false;
+	}
+
+	const char* target_name = json_string_value(json_object_get(json, "target_node"));
+	const char* force_value = json_string_value(json_object_get(json, "force"));
+	bool force = force_value && strcmp(force_value, "true") == 0;
+	if (!target_name || strlen(target_name) == 0)
+	{
+		json_decref(json);
+		ramd_http_set_error_response(response, RAMD_HTTP_400_BAD_REQUEST, "Missing target_node parameter");
+		return false;
+	}
+
+	ramd_cluster_t* cluster = &g_ramd_daemon->cluster;
+	ramd_node_t* target = NULL;
+	for (int32_t i = 0; i < cluster->node_count; i++)
+	{
+		if (strcmp(cluster->nodes[i].hostname, target_name) == 0)
+		{
+			target = &cluster->nodes[i];
+			break;
+		}
+	}
+	json_decref(json);
+
+	if (!target)
+	{
+		ramd_http_set_error_response(response, RAMD_HTTP_404_NOT_FOUND, "Target node not found");
+		return false;
+	}
+	if (!target->is_healthy && !force)
+	{
+		ramd_http_set_error_response(response, RAMD_HTTP_409_CONFLICT,
+									 "Target node is not healthy; use force=true to override");
+		return false;
+	}
+
+	ramd_node_t* leader = ramd_cluster_get_leader_node(cluster);
+	if (!leader)
+	{
+		ramd_http_set_error_response(response, RAMD_HTTP_503_SERVICE_UNAVAILABLE, "No Raft leader");
+		return false;
+	}
+
+	char json_response[512];
+	if (leader->node_id == target->node_id)
+	{
+		snprintf(json_response, sizeof(json_response),
+			"{\"success\":true,\"message\":\"Node %d is already the leader\",\"node_id\":%d}",
+			target->node_id, target->node_id);
+		ramd_http_set_json_response(response, RAMD_HTTP_200_OK, json_response);
+		return true;
+	}
+
+	PGconn* conn = ramd_conn_get_cached(leader->node_id,
+									   leader->hostname,
+									   leader->postgresql_port,
+									   g_ramd_daemon->config.database_name,
+									   g_ramd_daemon->config.database_user,
+									   g_ramd_daemon->config.database_password);
+	if (!conn)
+	{
+		ramd_http_set_error_response(response, RAMD_HTTP_500_INTERNAL_ERROR, "Leader database connection failed");
+		return false;
+	}
+
+	if (ramd_pgraft_switchover(conn, target->node_id) != RAMD_PGRAFT_SUCCESS)
+	{
+		ramd_log_error("Switchover to node %d failed: %s", target->node_id, ramd_pgraft_get_last_error());
+		ramd_http_set_error_response(response, RAMD_HTTP_500_INTERNAL_ERROR, "Failed to start the switchover");
+		return false;
+	}
+
+	snprintf(json_response, sizeof(json_response),
+		"{\"success\":true,\"status\":\"switchover_initiated\",\"from\":%d,\"node_id\":%d}",
+		leader->node_id, target->node_id);
+	ramd_http_set_json_response(response, RAMD_HTTP_200_OK, json_response);
+	return true;
+}
+
 void
 ramd_http_handle_cluster_health(ramd_http_request_t* request __attribute__((unused)), ramd_http_response_t* response)
 {
@@ -2161,6 +2266,110 @@ ramd_http_handle_cluster_health(ramd_http_request_t* request __attribute__((unus
 	free(health_json);
 }
 
+/*
+ * Liveness probe: ramd is up as long as it answers.
+ */
+void
+ramd_http_handle_liveness(ramd_http_request_t* request, ramd_http_response_t* response)
+{
+	char json_buffer[256];
+
+	if (request->method != RAMD_HTTP_GET)
+	{
+		ramd_http_set_error_response(response, RAMD_HTTP_405_METHOD_NOT_ALLOWED, "Method not allowed");
+		return;
+	}
+
+	snprintf(json_buffer, sizeof(json_buffer),
+			"{\n"
+			"  \"status\": \"alive\",\n"
+			"  \"node_id\": %d\n"
+			"}",
+			g_ramd_daemon->config.node_id);
+
+	ramd_http_set_json_response(response, RAMD_HTTP_200_OK, json_buffer);
+}
+
+/*
+ * Health probes of the local PostgreSQL.  Readiness is role-aware: a node
+ * in recovery is ready, but a primary only while raft has made this node
+ * the leader, so that a stale primary gets no traffic.  The answer is 200
+ * if the probe passes and 503 if not.
+ */
+void
+ramd_http_handle_probe(ramd_http_request_t* request, ramd_http_response_t* response,
+                       ramd_probe_t probe)
+{
+	ramd_config_t* config = &g_ramd_daemon->config;
+	char           json_buffer[512];
+	PGconn*        conn;
+	PGresult*      res;
+	bool           running = false;
+	bool           in_recovery = false;
+	bool           is_leader = false;
+	bool           has_quorum;
+	int32_t        node_count;
+	int32_t        leader_id;
+	bool           passed;
+
+	if (request->method != RAMD_HTTP_GET)
+	{
+		ramd_http_set_error_response(response, RAMD_HTTP_405_METHOD_NOT_ALLOWED, "Method not allowed");
+		return;
+	}
+
+	conn = ramd_conn_get_cached(config->node_id, config->hostname, config->postgresql_port,
+								config->database_name, config->database_user,
+								config->database_password);
+	if (conn)
+	{
+		res = ramd_query_exec_with_result(conn, "SELECT pg_is_in_recovery()");
+		if (res && PQresultStatus(res) == PGRES_TUPLES_OK && PQntuples(res) == 1)
+		{
+			running = true;
+			in_recovery = (strcmp(PQgetvalue(res, 0, 0), "t") == 0);
+		}
+		if (res)
+			PQclear(res);
+	}
+
+	if (running && !ramd_postgresql_query_pgraft_cluster_status(config, &node_count, &is_leader,
+															   &leader_id, &has_quorum))
+		is_leader = (g_ramd_daemon->cluster.primary_node_id == config->node_id);
+
+	switch (probe)
+	{
+		case RAMD_PROBE_READINESS:
+			passed = running && (in_recovery || is_leader);
+			break;
+		case RAMD_PROBE_LEADER:
+			passed = running && is_leader && !in_recovery;
+			break;
+		case RAMD_PROBE_REPLICA:
+			passed = running && !is_leader && in_recovery;
+			break;
+		default:
+			passed = running;
+			break;
+	}
+
+	snprintf(json_buffer, sizeof(json_buffer),
+			"{\n"
+			"  \"status\": \"%s\",\n"
+			"  \"node_id\": %d,\n"
+			"  \"postgresql\": \"%s\",\n"
+			"  \"is_leader\": %s\n"
+			"}",
+			passed ? "ok" : "unavailable",
+			config->node_id,
+			!running ? "down" : in_recovery ? "replica" : "primary",
+			is_leader ? "true" : "false");
+
+	ramd_http_set_json_response(response,
+								passed ? RAMD_HTTP_200_OK : RAMD_HTTP_503_SERVICE_UNAVAILABLE,
+								json_buffer);
+}
+
 void
 ramd_http_handle_cluster_notify(ramd_http_request_t* request __attribute__((unused)), ramd_http_response_t* response)
 {
@@ -304,6 +304,39 @@ ramd_pgraft_remove_node(PGconn* conn, int node_id)
 	return RAMD_PGRAFT_SUCCESS;
 }
 
+int
+ramd_pgraft_switchover(PGconn* conn, int target_node_id)
+{
+	char query[256];
+	PGresult* result;
+
+	if (!conn)
+	{
+		set_last_error("Database connection is NULL");
+		return RAMD_PGRAFT_ERROR;
+	}
+
+	snprintf(query, sizeof(query), "SELECT pgraft_switchover(%d)", target_node_id);
+
+	result = ramd_query_exec_with_result(conn, query);
+	if (!result)
+	{
+		set_last_error("Failed to execute pgraft_switchover: %s", PQerrorMessage(conn));
+		return RAMD_PGRAFT_ERROR;
+	}
+
+	if (PQresultStatus(result) != PGRES_TUPLES_OK)
+	{
+		set_last_error("pgraft_switchover failed: %s", PQresultErrorMessage(result));
+		PQclear(result);
+		return RAMD_PGRAFT_ERROR;
+	}
+
+	PQclear(result);
+	ramd_log_info("Queued switchover of Raft leadership to node %d", target_node_id);
+	return RAMD_PGRAFT_SUCCESS;
+}
+
 char*
 ramd_pgraft_get_cluster_health(PGconn* conn)
 {